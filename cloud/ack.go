@@ -0,0 +1,157 @@
+// Package swarm — Delivery acknowledgements.
+//
+// An enterprise subscriber connecting to /ws with ?ack=1 acknowledges the
+// filter versions it has applied, either by sending {"type":"ack",
+// "version":N} over the socket or with POST /subscriptions/{id}/ack and a
+// body of {"version":N}; the subscription ID is returned in the
+// X-Subscription-ID header of the upgrade response.  Acks are
+// idempotent and only ever move forward: an older version than the one
+// recorded is accepted and ignored, and one newer than the subscriber was
+// sent is rejected.
+//
+// While the latest version sent stays unacknowledged for push.ack_timeout
+// the subscriber is sent the whole filter again, marked resync, at most
+// push.ack_max_redeliveries times per version.  GET /admin/subscribers
+// shows each subscriber's acked version and lag, and ?min_lag=N lists only
+// those at least N versions behind.
+package swarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ackMessage is an acknowledgement, sent over the socket or posted.
+type ackMessage struct {
+	Type    string `json:"type,omitempty"`
+	Version uint64 `json:"version"`
+}
+
+// sentLocked records that version was delivered at now.  A newer version
+// restarts the ack timeout and its redelivery count.
+func (sub *subscriber) sentLocked(version uint64, now time.Time) {
+	if version <= sub.lastVersion && !sub.sentAt.IsZero() {
+		return
+	}
+	sub.lastVersion = max(sub.lastVersion, version)
+	sub.sentAt = now
+	sub.resent = 0
+}
+
+// markSent records a delivery made outside broadcast, such as the filter
+// a connection starts from.
+func (sub *subscriber) markSent(version uint64, now time.Time) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	sub.sentLocked(version, now)
+}
+
+// ack records version as acknowledged, returning the acked version.  It
+// reports false for a version the subscriber has not been sent.
+func (sub *subscriber) ack(version uint64, now time.Time) (uint64, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if version > sub.lastVersion {
+		return sub.ackedVersion, false
+	}
+	if version > sub.ackedVersion {
+		sub.ackedVersion = version
+		sub.ackedAt = now
+	}
+	return sub.ackedVersion, true
+}
+
+// dueRedelivery reports whether the latest version sent has gone
+// unacknowledged for timeout, counting the redelivery if so.
+func (sub *subscriber) dueRedelivery(now time.Time, timeout time.Duration, maxResends int) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if !sub.acks || timeout <= 0 || sub.sentAt.IsZero() || sub.ackedVersion >= sub.lastVersion {
+		return false
+	}
+	if sub.resent >= maxResends || now.Sub(sub.sentAt) < timeout {
+		return false
+	}
+	sub.resent++
+	sub.redeliveries++
+	sub.sentAt = now
+	return true
+}
+
+// envelopeVersion returns the version an encoded envelope brings a client
+// to, or zero if it is not one.
+func envelopeVersion(data []byte) uint64 {
+	if len(data) > 0 && data[0] != '{' {
+		doc, err := msgpackDecode(data) // the payload is a bin, not decoded
+		env, ok := doc.(map[string]any)
+		if err != nil || !ok {
+			return 0
+		}
+		return max(msgpackUint(env["version"]), msgpackUint(env["to_version"]))
+	}
+	var env struct {
+		Version   uint64 `json:"version"`
+		ToVersion uint64 `json:"to_version"`
+	}
+	if json.Unmarshal(data, &env) != nil {
+		return 0
+	}
+	return max(env.Version, env.ToVersion)
+}
+
+// handleSubscriptionAck is the HTTP handler for POST
+// /subscriptions/{id}/ack.  Only the key that opened a subscription may
+// acknowledge for it.
+func (s *SwarmAggregator) handleSubscriptionAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/subscriptions/"), "/ack")
+	if !ok || id == "" || strings.Contains(id, "/") {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found")
+		return
+	}
+	var msg ackMessage
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&msg); err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, "Invalid JSON body")
+		return
+	}
+
+	key, _ := APIKeyFromContext(r.Context())
+	ss := s.subscribers
+	if key.Namespace != "" {
+		ss = s.namespace(key.Namespace).subscribers
+	}
+	sub := ss.get(id)
+	if sub == nil || sub.key != key.Name() {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Unknown subscription")
+		return
+	}
+	if !sub.acks {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Subscription was not opened with ack=1")
+		return
+	}
+	acked, ok := sub.ack(msg.Version, time.Now())
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Version was never sent to this subscription")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"subscription_id": id, "acked_version": acked})
+}
+
+// parseMinLag parses the min_lag parameter of GET /admin/subscribers.
+func parseMinLag(r *http.Request) (uint64, bool) {
+	v := r.URL.Query().Get("min_lag")
+	if v == "" {
+		return 0, true
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	return n, err == nil
+}
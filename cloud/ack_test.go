@@ -0,0 +1,151 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newAckAggregator(t *testing.T, timeout time.Duration, maxResends int) (*SwarmAggregator, *httptest.Server) {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Ingest.Synchronous = true
+	cfg.Push.AckTimeout = Duration(timeout)
+	cfg.Push.AckMaxRedeliveries = maxResends
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.keys.Add("ent-secret", APIKey{ID: "ent", Role: RoleEnterprise})
+	agg.keys.Add("admin-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	if _, err := agg.SubmitReport(context.Background(), IOCReport{Address: evmAddress("acked"), ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(agg.Routes())
+	t.Cleanup(srv.Close)
+	return agg, srv
+}
+
+// dialAcking opens /ws?ack=1 and returns the subscription ID and the
+// version of the initial snapshot.
+func dialAcking(t *testing.T, srv *httptest.Server) (*websocket.Conn, string, uint64) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?ack=1", http.Header{"X-API-Key": {"ent-secret"}})
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	id := resp.Header.Get("X-Subscription-ID")
+	if !strings.HasPrefix(id, "ws-ent-") {
+		t.Fatalf("Expected the subscription ID in the upgrade response, got %q", id)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var initial FilterEnvelope
+	if err := conn.ReadJSON(&initial); err != nil || initial.Version == 0 {
+		t.Fatalf("Expected an initial snapshot, got %+v %v", initial, err)
+	}
+	return conn, id, initial.Version
+}
+
+// expectNoMessage fails if the connection is sent anything within d.
+func expectNoMessage(t *testing.T, conn *websocket.Conn, d time.Duration) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(d))
+	if _, data, err := conn.ReadMessage(); err == nil {
+		t.Fatalf("Expected no redelivery, got %s", data)
+	}
+}
+
+func TestLateAckerIsRedeliveredUntilItAcks(t *testing.T) {
+	agg, srv := newAckAggregator(t, 100*time.Millisecond, 3)
+	conn, id, version := dialAcking(t, srv)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var env FilterEnvelope
+	if err := conn.ReadJSON(&env); err != nil || !env.Resync || env.Version != version {
+		t.Fatalf("Expected the unacknowledged filter redelivered as resync, got %+v %v", env, err)
+	}
+	if err := conn.WriteJSON(ackMessage{Type: "ack", Version: version}); err != nil {
+		t.Fatal(err)
+	}
+	expectNoMessage(t, conn, 400*time.Millisecond)
+
+	subs := agg.ListSubscribers()
+	if len(subs) != 1 || subs[0].ID != id || subs[0].AckedVersion != version || subs[0].Lag != 0 || subs[0].Redeliveries != 1 {
+		t.Errorf("Expected one redelivery and no lag, got %+v", subs)
+	}
+	if n := testutil.ToFloat64(agg.metrics.pushRedeliveries); n != 1 {
+		t.Errorf("Expected 1 redelivery counted, got %v", n)
+	}
+}
+
+func TestNeverAckerIsCappedAndListedAsLagging(t *testing.T) {
+	agg, srv := newAckAggregator(t, 40*time.Millisecond, 2)
+	conn, id, _ := dialAcking(t, srv)
+	agg.Subscribe("plain")
+	defer agg.Unsubscribe("plain")
+
+	for i := 0; i < 2; i++ {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var env FilterEnvelope
+		if err := conn.ReadJSON(&env); err != nil || !env.Resync {
+			t.Fatalf("Expected redelivery %d, got %+v %v", i+1, env, err)
+		}
+	}
+	expectNoMessage(t, conn, 300*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/admin/subscribers?min_lag=1", nil)
+	req.Header.Set("X-API-Key", "admin-secret")
+	agg.Routes().ServeHTTP(rec, req)
+	var resp struct {
+		Subscribers []SubscriberInfo `json:"subscribers"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || len(resp.Subscribers) != 1 {
+		t.Fatalf("Expected only the acking subscriber listed, got %d %+v", rec.Code, resp.Subscribers)
+	}
+	if sub := resp.Subscribers[0]; sub.ID != id || sub.Lag < 1 || sub.Redeliveries != 2 || sub.AckedAt != nil {
+		t.Errorf("Expected a lagging subscriber capped at 2 redeliveries, got %+v", sub)
+	}
+}
+
+func TestSubscriptionAckIsMonotonic(t *testing.T) {
+	agg, _ := newAckAggregator(t, 0, 0)
+	agg.keys.Add("other-secret", APIKey{ID: "other", Role: RoleEnterprise})
+	agg.SubscribeWithOptions("sub-1", SubscribeOptions{Key: "ent", Acks: true})
+	defer agg.Unsubscribe("sub-1")
+	agg.subscribers.get("sub-1").markSent(5, time.Now())
+
+	ack := func(secret, id, body string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/subscriptions/"+id+"/ack", strings.NewReader(body))
+		req.Header.Set("X-API-Key", secret)
+		agg.Routes().ServeHTTP(rec, req)
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return rec.Code, resp
+	}
+	for _, tc := range []struct {
+		version string
+		want    float64
+	}{{"3", 3}, {"3", 3}, {"2", 3}, {"5", 5}} {
+		if code, resp := ack("ent-secret", "sub-1", `{"version":`+tc.version+`}`); code != http.StatusOK || resp["acked_version"] != tc.want {
+			t.Errorf("Ack %s: expected acked_version %v, got %d %v", tc.version, tc.want, code, resp)
+		}
+	}
+	if code, _ := ack("ent-secret", "sub-1", `{"version":6}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 acking a version never sent, got %d", code)
+	}
+	if code, _ := ack("other-secret", "sub-1", `{"version":5}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 acking another key's subscription, got %d", code)
+	}
+	if code, _ := ack("ent-secret", "missing", `{"version":1}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown subscription, got %d", code)
+	}
+}
@@ -0,0 +1,332 @@
+// Package swarm — Address normalization per chain family.
+//
+// The filter and TWAB are keyed by address string, so every spelling of
+// an address must reduce to one key before it reaches them.  The chain ID
+// selects the family: EVM addresses are lowercased (a mixed-case address
+// must carry a valid EIP-55 checksum), Solana and Tron addresses are
+// base58 and kept as sent once validated, and Bitcoin addresses are
+// base58check or bech32/bech32m, the latter lowercased.  Chain ID 0 means
+// the chain was not declared: EVM-shaped addresses are still lowercased,
+// anything else passes through unchanged.
+package swarm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Chain IDs for the non-EVM families.  EVM chains use their EIP-155 IDs.
+// Tron uses the ID its own JSON-RPC reports; Bitcoin and Solana have none,
+// so they take their SLIP-44 coin type offset by 1e9, a block no EVM chain
+// is registered in.  All three fit in 32 bits, so a chain ID is an int on
+// every platform.
+const (
+	ChainBitcoin = 1000000000 // SLIP-44 0
+	ChainSolana  = 1000000501 // SLIP-44 501
+	ChainTron    = 728126428
+)
+
+// ChainFamily is the address format a chain uses.
+type ChainFamily string
+
+const (
+	FamilyUnknown ChainFamily = "unknown" // chain ID 0
+	FamilyEVM     ChainFamily = "evm"
+	FamilySolana  ChainFamily = "solana"
+	FamilyTron    ChainFamily = "tron"
+	FamilyBitcoin ChainFamily = "bitcoin"
+)
+
+// ChainFamilyOf returns the address family for a chain ID.  Any ID not
+// registered as another family is an EVM chain.
+func ChainFamilyOf(chainID int) ChainFamily {
+	switch chainID {
+	case 0:
+		return FamilyUnknown
+	case ChainSolana:
+		return FamilySolana
+	case ChainTron:
+		return FamilyTron
+	case ChainBitcoin:
+		return FamilyBitcoin
+	}
+	return FamilyEVM
+}
+
+// AddressError reports an address that is not valid for its chain.
+type AddressError struct {
+	ChainID int
+	Address string
+	Reason  string
+}
+
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("invalid %s address %q for chain %d: %s", ChainFamilyOf(e.ChainID), e.Address, e.ChainID, e.Reason)
+}
+
+// NormalizeAddress returns the canonical form of address on chainID, or an
+// *AddressError if it is not a valid address for that chain.
+func NormalizeAddress(chainID int, address string) (string, error) {
+	address = strings.TrimSpace(address)
+	var reason string
+	switch ChainFamilyOf(chainID) {
+	case FamilyUnknown:
+		if isEVMShaped(address) {
+			return normalizeEVM(chainID, address)
+		}
+		return address, nil
+	case FamilyEVM:
+		return normalizeEVM(chainID, address)
+	case FamilySolana:
+		reason = validateSolana(address)
+	case FamilyTron:
+		reason = validateTron(address)
+	case FamilyBitcoin:
+		return normalizeBitcoin(chainID, address)
+	}
+	if reason != "" {
+		return "", &AddressError{ChainID: chainID, Address: address, Reason: reason}
+	}
+	return address, nil
+}
+
+// normalizeReport sanitizes the report (see sanitize.go) and rewrites
+// report.Address to its indicator key (see indicator.go).
+func (s *SwarmAggregator) normalizeReport(report *IOCReport) error {
+	if err := s.sanitizeReport(report); err != nil {
+		return err
+	}
+	if err := checkReportFields(*report); err != nil {
+		return err
+	}
+	address, err := IndicatorKey(report.IndicatorType, report.ChainID, report.Address)
+	if err != nil {
+		s.metrics.invalidAddresses.Inc()
+		return err
+	}
+	report.Address = address
+	return nil
+}
+
+func isEVMShaped(address string) bool {
+	if len(address) != 42 || !strings.HasPrefix(address, "0x") {
+		return false
+	}
+	_, err := hex.DecodeString(address[2:])
+	return err == nil
+}
+
+func normalizeEVM(chainID int, address string) (string, error) {
+	if !isEVMShaped(address) {
+		return "", &AddressError{ChainID: chainID, Address: address, Reason: "want 0x followed by 40 hex digits"}
+	}
+	body := address[2:]
+	lower := strings.ToLower(body)
+	if body != lower && body != strings.ToUpper(body) && eip55(lower) != body {
+		return "", &AddressError{ChainID: chainID, Address: address, Reason: "bad EIP-55 checksum"}
+	}
+	return "0x" + lower, nil
+}
+
+// eip55 returns the checksummed form of a lowercase hex address body.
+func eip55(lower string) string {
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte(lower))
+	digest := h.Sum(nil)
+
+	out := []byte(lower)
+	for i, c := range out {
+		nibble := digest[i/2] >> 4
+		if i%2 == 1 {
+			nibble = digest[i/2] & 0x0f
+		}
+		if c >= 'a' && nibble >= 8 {
+			out[i] = c - 'a' + 'A'
+		}
+	}
+	return string(out)
+}
+
+func validateSolana(address string) string {
+	raw, ok := base58Decode(address)
+	if !ok {
+		return "not base58"
+	}
+	if len(raw) != 32 {
+		return fmt.Sprintf("decodes to %d bytes, want 32", len(raw))
+	}
+	return ""
+}
+
+func validateTron(address string) string {
+	payload, reason := base58CheckDecode(address)
+	if reason != "" {
+		return reason
+	}
+	if len(payload) != 21 || payload[0] != 0x41 {
+		return "not a Tron account address"
+	}
+	return ""
+}
+
+func normalizeBitcoin(chainID int, address string) (string, error) {
+	lower := strings.ToLower(address)
+	if strings.HasPrefix(lower, "bc1") {
+		if address != lower && address != strings.ToUpper(address) {
+			return "", &AddressError{ChainID: chainID, Address: address, Reason: "mixed-case bech32"}
+		}
+		if reason := validateSegwit(lower); reason != "" {
+			return "", &AddressError{ChainID: chainID, Address: address, Reason: reason}
+		}
+		return lower, nil
+	}
+
+	payload, reason := base58CheckDecode(address)
+	if reason == "" && (len(payload) != 21 || (payload[0] != 0x00 && payload[0] != 0x05)) {
+		reason = "not a P2PKH or P2SH address"
+	}
+	if reason != "" {
+		return "", &AddressError{ChainID: chainID, Address: address, Reason: reason}
+	}
+	return address, nil
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58Decode decodes a Bitcoin-alphabet base58 string.
+func base58Decode(s string) ([]byte, bool) {
+	if s == "" {
+		return nil, false
+	}
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range []byte(s) {
+		d := strings.IndexByte(base58Alphabet, c)
+		if d < 0 {
+			return nil, false
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(d)))
+	}
+	zeros := 0
+	for zeros < len(s) && s[zeros] == '1' {
+		zeros++
+	}
+	return append(make([]byte, zeros), n.Bytes()...), true
+}
+
+// base58CheckDecode decodes s and verifies its 4-byte double-SHA256
+// checksum, returning the payload or a reason it is invalid.
+func base58CheckDecode(s string) ([]byte, string) {
+	raw, ok := base58Decode(s)
+	if !ok {
+		return nil, "not base58"
+	}
+	if len(raw) < 5 {
+		return nil, "too short"
+	}
+	payload, sum := raw[:len(raw)-4], raw[len(raw)-4:]
+	first := sha256.Sum256(payload)
+	second := sha256.Sum256(first[:])
+	if !bytes.Equal(second[:4], sum) {
+		return nil, "bad base58check checksum"
+	}
+	return payload, ""
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// Checksum constants for BIP-173 (witness v0) and BIP-350 (v1+).
+const (
+	bech32Const  = 1
+	bech32mConst = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>i)&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+// validateSegwit checks a lowercase bech32 mainnet segwit address.
+func validateSegwit(address string) string {
+	sep := strings.LastIndexByte(address, '1')
+	if sep != 2 || len(address) > 90 || len(address)-sep-1 < 7 {
+		return "malformed bech32"
+	}
+	data := make([]byte, 0, len(address)-sep-1)
+	for _, c := range []byte(address[sep+1:]) {
+		d := strings.IndexByte(bech32Charset, c)
+		if d < 0 {
+			return "invalid bech32 character"
+		}
+		data = append(data, byte(d))
+	}
+
+	hrp := address[:sep]
+	expanded := make([]byte, 0, len(hrp)*2+1+len(data))
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c>>5)
+	}
+	expanded = append(expanded, 0)
+	for _, c := range []byte(hrp) {
+		expanded = append(expanded, c&31)
+	}
+	expanded = append(expanded, data...)
+
+	version := data[0]
+	want := uint32(bech32Const)
+	if version > 0 {
+		want = bech32mConst
+	}
+	if bech32Polymod(expanded) != want {
+		return "bad bech32 checksum"
+	}
+
+	program, ok := convertBits(data[1:len(data)-6], 5, 8)
+	if !ok || version > 16 || len(program) < 2 || len(program) > 40 {
+		return "invalid witness program"
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return "invalid witness v0 program length"
+	}
+	return ""
+}
+
+// convertBits regroups 5-bit bech32 data into bytes, rejecting non-zero
+// padding.
+func convertBits(data []byte, from, to uint) ([]byte, bool) {
+	var (
+		acc  uint32
+		bits uint
+		out  []byte
+	)
+	maxv := uint32(1)<<to - 1
+	for _, v := range data {
+		acc = acc<<from | uint32(v)
+		bits += from
+		for bits >= to {
+			bits -= to
+			out = append(out, byte(acc>>bits&maxv))
+		}
+	}
+	if bits >= from || (acc<<(to-bits))&maxv != 0 {
+		return nil, false
+	}
+	return out, true
+}
@@ -0,0 +1,113 @@
+package swarm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// evmAddress returns a valid lowercase EVM address for a fixture label, so
+// tests can keep readable names for reports on EVM chains.
+func evmAddress(label string) string {
+	sum := sha256.Sum256([]byte(label))
+	return "0x" + hex.EncodeToString(sum[:20])
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		chainID int
+		in      string
+		want    string // "" means invalid
+	}{
+		{"evm checksummed", 1, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"},
+		{"evm lowercase", 137, "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359"},
+		{"evm uppercase", 1, "0xDBF03B407C01E7CD3CBEA99509D93F8DDDC8C6FB", "0xdbf03b407c01e7cd3cbea99509d93f8dddc8c6fb"},
+		{"evm whitespace", 1, " 0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359\n", "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359"},
+		{"evm bad checksum", 1, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAeD", ""},
+		{"evm short", 1, "0x5aaeb6053f3e94c9b9a09f33669435e7ef1bea", ""},
+		{"evm not hex", 1, "0xRouter", ""},
+		{"evm no prefix", 1, "5aaeb6053f3e94c9b9a09f33669435e7ef1beaed", ""},
+
+		{"undeclared evm-shaped", 0, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"},
+		{"undeclared other", 0, "0xRouter", "0xRouter"},
+
+		{"solana", ChainSolana, "So11111111111111111111111111111111111111112", "So11111111111111111111111111111111111111112"},
+		{"solana system program", ChainSolana, "11111111111111111111111111111111", "11111111111111111111111111111111"},
+		{"solana bad character", ChainSolana, "So1111111111111111111111111111111111111111O", ""},
+		{"solana wrong length", ChainSolana, "So1111111111", ""},
+
+		{"tron", ChainTron, "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t"},
+		{"tron bad checksum", ChainTron, "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6s", ""},
+		{"tron bitcoin address", ChainTron, "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", ""},
+
+		{"bitcoin p2pkh", ChainBitcoin, "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa", "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNa"},
+		{"bitcoin p2sh", ChainBitcoin, "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy", "3J98t1WpEZ73CNmQviecrnyiWrnqRhWNLy"},
+		{"bitcoin bech32 uppercase", ChainBitcoin, "BC1QAR0SRRR7XFKVY5L643LYDNW9RE59GTZZWF5MDQ", "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"},
+		{"bitcoin bech32 p2wsh", ChainBitcoin, "bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3", "bc1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3qccfmv3"},
+		{"bitcoin bech32m taproot", ChainBitcoin, "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0"},
+		{"bitcoin bad base58check", ChainBitcoin, "1A1zP1eP5QGefi2DMPTfTL5SLmv7DivfNb", ""},
+		{"bitcoin mixed-case bech32", ChainBitcoin, "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdQ", ""},
+		{"bitcoin bad bech32 checksum", ChainBitcoin, "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdp", ""},
+	}
+	for _, tc := range tests {
+		got, err := NormalizeAddress(tc.chainID, tc.in)
+		if tc.want == "" {
+			var addrErr *AddressError
+			if !errors.As(err, &addrErr) {
+				t.Errorf("%s: expected an AddressError for %q, got %q, %v", tc.name, tc.in, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s: NormalizeAddress(%d, %q) = %q, %v; want %q", tc.name, tc.chainID, tc.in, got, err, tc.want)
+		}
+	}
+}
+
+func TestMixedCaseEVMReportsAggregate(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2})
+	ctx := context.Background()
+	checksummed := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	lower := "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+
+	agg.IngestReport(ctx, IOCReport{Address: checksummed, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"})
+	if !agg.IngestReport(ctx, IOCReport{Address: lower, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-B"}) {
+		t.Fatal("Expected both spellings to count towards one consensus")
+	}
+	if summary, ok := agg.twab.Summary(lower); !ok || summary.ReportCount != 2 {
+		t.Errorf("Expected one TWAB entry with 2 reports, got %+v", summary)
+	}
+	if agg.BloomFilterLen() != 1 {
+		t.Errorf("Expected one filter entry, got %d", agg.BloomFilterLen())
+	}
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?address="+checksummed, nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"flagged":true`) {
+		t.Errorf("Expected /check to find the checksummed spelling, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestInvalidAddressRejectedWith422(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+
+	rec := postIngest(agg, "/ingest", `{"address":"0xNotHex","chain_id":1,"source_id":"agent-A"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for an invalid EVM address, got %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?address=0xNotHex&chain_id=1", nil))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected /check to reject an invalid address for its chain, got %d", rec.Code)
+	}
+	if agg.IngestReport(context.Background(), IOCReport{Address: "0xNotHex", ChainID: 1, Confidence: 1, Timestamp: time.Now(), SourceID: "agent-A"}) || agg.BloomFilterLen() != 0 {
+		t.Error("IngestReport must drop invalid addresses")
+	}
+}
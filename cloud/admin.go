@@ -0,0 +1,248 @@
+// Package swarm — Operator overrides of the consensus set.
+//
+// Admins can force an address into the filter (block), pull one out
+// (unblock), and maintain an allowlist of addresses that consensus may
+// never promote, e.g. well-known router contracts that SDK heuristics
+// occasionally misreport.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// provenanceAdmin is the Source tag for operator force-adds.
+const provenanceAdmin = "admin"
+
+// AdminAction is the request body for block, unblock, and allowlist calls.
+type AdminAction struct {
+	Address  string `json:"address"`
+	ChainID  int    `json:"chain_id,omitempty"`
+	Category string `json:"category,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Block force-adds an address to the confirmed set, bypassing TWAB and
+// lifting any cooldown (see cooldown.go).  It returns false if the
+// address was already confirmed.
+func (s *SwarmAggregator) Block(ctx context.Context, action AdminAction) bool {
+	s.mu.Lock()
+	if _, ok := s.confirmed[action.Address]; ok {
+		s.mu.Unlock()
+		return false
+	}
+	now := s.clock.Now()
+	s.confirmed[action.Address] = &ConfirmedEntry{
+		Address:    action.Address,
+		ChainID:    action.ChainID,
+		Category:   action.Category,
+		Confidence: 1.0,
+		PromotedAt: now,
+		Provenance: Provenance{
+			Source:     provenanceAdmin,
+			Category:   action.Category,
+			Reason:     action.Reason,
+			ImportedAt: now,
+		},
+	}
+	delete(s.allowlist, action.Address)
+	s.tripwire.remove(protectedAllowlist, action.Address)
+	s.cooldowns.lift(action.Address)
+	s.filterAddLocked(s.confirmed[action.Address])
+	ev := entryEvent(EventPromoted, s.confirmed[action.Address])
+	ev.Source, ev.Tier, ev.Reason = provenanceAdmin, TierMain, action.Reason
+	if s.staging != nil && s.unstageLocked(action.Address) {
+		ev.FromTier = TierStaging
+	}
+	s.mu.Unlock()
+	s.review.take(action.Address, time.Time{})
+
+	s.events.publish(ctx, ev)
+	return true
+}
+
+// Unblock removes an address from the confirmed set and the filter, or
+// cancels its graduation from staging, and starts its cooldown.  It
+// returns false if the address was neither confirmed nor staged.
+func (s *SwarmAggregator) Unblock(ctx context.Context, address string) bool {
+	s.mu.Lock()
+	entry, ok := s.confirmed[address]
+	if !ok {
+		s.mu.Unlock()
+		if !s.saveStaged(ctx, address, stagingSaveUnblocked) {
+			return false
+		}
+		s.startCooldown(address, cooldownRetracted, s.clock.Now())
+		return true
+	}
+	delete(s.confirmed, address)
+	s.filterRemoveLocked(entry)
+	s.mu.Unlock()
+	s.startCooldown(address, cooldownUnblocked, s.clock.Now())
+
+	ev := entryEvent(EventRemoved, entry)
+	ev.FromTier, ev.Reason = TierMain, eventReasonUnblocked
+	s.events.publish(ctx, ev)
+	return true
+}
+
+// Allow adds an address to the allowlist, removing it from the filter if
+// it was confirmed, from staging, and from the review queue.
+func (s *SwarmAggregator) Allow(ctx context.Context, address string) {
+	s.mu.Lock()
+	s.allowlist[address] = true
+	s.tripwire.add(protectedAllowlist, address)
+	entry, wasConfirmed := s.confirmed[address]
+	if wasConfirmed {
+		delete(s.confirmed, address)
+		s.filterRemoveLocked(entry)
+	}
+	s.mu.Unlock()
+	s.review.take(address, time.Time{})
+	s.saveStaged(ctx, address, stagingSaveAllowlisted)
+
+	if wasConfirmed {
+		ev := entryEvent(EventRemoved, entry)
+		ev.FromTier, ev.Reason = TierMain, eventReasonAllowlisted
+		s.events.publish(ctx, ev)
+	}
+}
+
+// Disallow removes an address from the allowlist.  Existing reports are
+// kept, so the next report may promote it.
+func (s *SwarmAggregator) Disallow(address string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.allowlist[address] {
+		return false
+	}
+	delete(s.allowlist, address)
+	delete(s.fileAllow, address)
+	s.tripwire.remove(protectedAllowlist, address)
+	return true
+}
+
+// Allowlist returns the allowlisted addresses in sorted order.
+func (s *SwarmAggregator) Allowlist() []string {
+	s.mu.RLock()
+	out := make([]string, 0, len(s.allowlist))
+	for addr := range s.allowlist {
+		out = append(out, addr)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(out)
+	return out
+}
+
+// decodeAdminAction reads an AdminAction body and normalizes its address,
+// writing a 400 or 422 on failure.
+func decodeAdminAction(w http.ResponseWriter, r *http.Request) (AdminAction, bool) {
+	var action AdminAction
+	if err := json.NewDecoder(r.Body).Decode(&action); err != nil {
+		writeBodyError(w, r, err, "Invalid JSON")
+		return action, false
+	}
+	if action.Address == "" {
+		writeError(w, r, http.StatusBadRequest, CodeMissingAddress, "Missing address")
+		return action, false
+	}
+	address, err := NormalizeAddress(action.ChainID, action.Address)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, CodeInvalidAddress, err.Error())
+		return action, false
+	}
+	action.Address = address
+	return action, true
+}
+
+// handleAdminBlock is the HTTP handler for POST /admin/block.
+func (s *SwarmAggregator) handleAdminBlock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	action, ok := decodeAdminAction(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditBlock, Address: action.Address, Reason: action.Reason}) {
+		return
+	}
+	changed := s.Block(r.Context(), action)
+	writeAdminResult(w, action.Address, changed)
+}
+
+// handleAdminUnblock is the HTTP handler for POST /admin/unblock.
+func (s *SwarmAggregator) handleAdminUnblock(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	action, ok := decodeAdminAction(w, r)
+	if !ok {
+		return
+	}
+
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditUnblock, Address: action.Address, Reason: action.Reason}) {
+		return
+	}
+	changed := s.Unblock(r.Context(), action.Address)
+	writeAdminResult(w, action.Address, changed)
+}
+
+// handleAdminAllowlist is the HTTP handler for /admin/allowlist.
+//
+// GET lists the allowlist, POST adds the address in the body, and DELETE
+// removes the address given by the ?address= query parameter, with an
+// optional ?reason= for the audit log.
+func (s *SwarmAggregator) handleAdminAllowlist(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"addresses": s.Allowlist(),
+		})
+
+	case http.MethodPost:
+		action, ok := decodeAdminAction(w, r)
+		if !ok {
+			return
+		}
+		if !s.auditAdmin(w, r, AuditEvent{Action: AuditAllow, Address: action.Address, Reason: action.Reason}) {
+			return
+		}
+		s.Allow(r.Context(), action.Address)
+		writeAdminResult(w, action.Address, true)
+
+	case http.MethodDelete:
+		address := r.URL.Query().Get("address")
+		if address == "" {
+			writeError(w, r, http.StatusBadRequest, CodeMissingAddress, "Missing address")
+			return
+		}
+		address, ok := normalizeQueryAddress(w, r, address)
+		if !ok {
+			return
+		}
+		if !s.auditAdmin(w, r, AuditEvent{Action: AuditDisallow, Address: address}) {
+			return
+		}
+		writeAdminResult(w, address, s.Disallow(address))
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func writeAdminResult(w http.ResponseWriter, address string, changed bool) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"address": address,
+		"changed": changed,
+	})
+}
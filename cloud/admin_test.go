@@ -0,0 +1,88 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/client"
+	"github.com/gorilla/websocket"
+)
+
+func TestBlockUnblockAndAllowlist(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{
+		MinReportCount:     1,
+		MinTimeSpanSeconds: 0.0,
+		MinDistinctSources: 1,
+	})
+	ctx := context.Background()
+
+	if !agg.Block(ctx, AdminAction{Address: "0xForced", Reason: "incident-42"}) {
+		t.Fatal("Expected block to add the address")
+	}
+	if entry, _ := agg.Confirmed("0xForced"); entry.Provenance.Source != provenanceAdmin || entry.Provenance.Reason != "incident-42" {
+		t.Errorf("Unexpected provenance %+v", entry.Provenance)
+	}
+	if !agg.Unblock(ctx, "0xForced") || agg.BloomFilterLen() != 0 {
+		t.Fatal("Expected unblock to remove the address")
+	}
+
+	agg.Allow(ctx, evmAddress("router"))
+	added := agg.IngestReport(ctx, IOCReport{Address: evmAddress("router"), ChainID: 1, Confidence: 1.0, Timestamp: time.Now(), SourceID: "agent-A"})
+	if added || agg.BloomFilterLen() != 0 {
+		t.Error("Allowlisted address must not be promoted")
+	}
+	if !agg.Disallow(evmAddress("router")) {
+		t.Fatal("Expected allowlist removal")
+	}
+	if !agg.IngestReport(ctx, IOCReport{Address: evmAddress("router"), ChainID: 1, Confidence: 1.0, Timestamp: time.Now(), SourceID: "agent-B"}) {
+		t.Error("Address should promote once removed from the allowlist")
+	}
+}
+
+func TestWebSocketReceivesSnapshotThenPush(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var snapshot struct {
+		Payload struct {
+			Count int `json:"count"`
+		} `json:"payload"`
+	}
+	if err := conn.ReadJSON(&snapshot); err != nil || snapshot.Payload.Count != 0 {
+		t.Fatalf("Expected empty snapshot, got %+v (%v)", snapshot, err)
+	}
+
+	// The subscription is registered before the snapshot is written, so
+	// this push is guaranteed to reach the connection.
+	agg.IngestReport(context.Background(), IOCReport{Address: evmAddress("live"), ChainID: 1, Confidence: 1.0, Timestamp: time.Now(), SourceID: "agent-A"})
+
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Expected push, got %v", err)
+	}
+	if err := client.VerifyFilterPayload(agg.signer.Active().Public(), data); err != nil {
+		t.Errorf("Push failed verification: %v", err)
+	}
+	var push struct {
+		Payload struct {
+			Entries []string `json:"entries"`
+		} `json:"payload"`
+	}
+	json.Unmarshal(data, &push)
+	if len(push.Payload.Entries) != 1 || push.Payload.Entries[0] != evmAddress("live") {
+		t.Errorf("Unexpected push %s", data)
+	}
+}
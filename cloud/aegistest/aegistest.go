@@ -0,0 +1,255 @@
+// Package aegistest boots a real Aegis Swarm Aggregator for end-to-end
+// tests of code built against it: SDKs, dashboards, and services that
+// embed the swarm package.
+//
+// StartTestAggregator serves the ingest, subscribe and admin routes on
+// one ephemeral port and metrics on another, with fast TWAB thresholds,
+// two keys for the admin and subscriber roles, and its clock under the
+// test's control: Advance moves time on and runs a maintenance pass, so
+// time-span gates, expiry and cooldowns are crossed without waiting.  The
+// aggregator is torn down when the test ends.
+package aegistest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/gorilla/websocket"
+)
+
+// Keys every test aggregator accepts, with the admin and subscriber
+// roles.  Do and DialWS present them.
+const (
+	AdminKey      = "aegistest-admin"
+	SubscriberKey = "aegistest-subscriber"
+)
+
+// TWAB are the fast thresholds of a test aggregator: two reports from two
+// sources a minute apart.
+var TWAB = swarm.TWABConfig{MinReportCount: 2, MinDistinctSources: 2, MinTimeSpanSeconds: 60}
+
+// shutdownTimeout bounds the teardown's drain of the ingest queue.
+const shutdownTimeout = 10 * time.Second
+
+// Options adjust StartTestAggregator.
+type Options struct {
+	// Configure edits the config before the aggregator is built: the
+	// default config with TWAB and listeners on ephemeral ports.
+	Configure func(cfg *swarm.Config)
+
+	// Start is the clock's initial time, now if zero.
+	Start time.Time
+
+	// Keys are accepted beside AdminKey and SubscriberKey, by secret, such
+	// as a peer key for a standby.
+	Keys map[string]swarm.APIKey
+}
+
+// Aggregator is an aggregator serving on ephemeral ports, started the
+// way the server binary starts one, with its clock under the test's
+// control.
+type Aggregator struct {
+	*swarm.SwarmAggregator
+	Clock *swarm.ManualClock
+
+	URL        string // ingest, subscribe and admin routes
+	WSURL      string // the WebSocket push endpoint
+	MetricsURL string
+
+	servers []*http.Server
+	t       testing.TB
+}
+
+// StartTestAggregator boots an aggregator for an end-to-end test and
+// tears it down when the test ends.
+func StartTestAggregator(t testing.TB, opts Options) *Aggregator {
+	t.Helper()
+	cfg := swarm.DefaultConfig()
+	cfg.TWAB = TWAB
+	cfg.Listeners = []swarm.ListenerConfig{
+		{Address: "tcp://127.0.0.1:0", Routes: []swarm.RouteGroup{swarm.RouteIngest, swarm.RouteSubscribe, swarm.RouteAdmin}},
+		{Address: "tcp://127.0.0.1:0", Routes: []swarm.RouteGroup{swarm.RouteMetrics}},
+	}
+	if opts.Configure != nil {
+		opts.Configure(&cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Invalid test aggregator config: %v", err)
+	}
+	start := opts.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+
+	agg := swarm.NewSwarmAggregatorWithConfig(cfg)
+	clock := swarm.NewManualClock(start)
+	agg.SetClock(clock)
+	keys := swarm.NewKeyStore()
+	keys.Add(AdminKey, swarm.APIKey{ID: "aegistest-admin", Role: swarm.RoleAdmin})
+	keys.Add(SubscriberKey, swarm.APIKey{ID: "aegistest-subscriber", Role: swarm.RoleSubscriber})
+	for secret, key := range opts.Keys {
+		keys.Add(secret, key)
+	}
+	agg.SetAPIKeys(keys)
+	if !cfg.Ingest.Synchronous {
+		agg.StartIngestQueue()
+	}
+	agg.StartMaintenance()
+
+	errs := make(chan error, 1)
+	servers, err := agg.Serve(cfg, errs)
+	if err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, srv := range servers {
+			srv.Close()
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		agg.DrainIngestQueue(ctx)
+		agg.Close()
+		select {
+		case err := <-errs:
+			t.Errorf("Test aggregator stopped serving: %v", err)
+		default:
+		}
+	})
+
+	h := &Aggregator{SwarmAggregator: agg, Clock: clock, servers: servers, t: t}
+	h.URL = "http://" + servers[0].Addr
+	h.WSURL = "ws://" + servers[0].Addr + "/ws"
+	if len(servers) > 1 {
+		h.MetricsURL = "http://" + servers[1].Addr + "/metrics"
+	}
+	return h
+}
+
+// Address returns a valid EVM address derived from label, so a test can
+// name the addresses it reports.
+func Address(label string) string {
+	sum := sha256.Sum256([]byte(label))
+	return "0x" + hex.EncodeToString(sum[:20])
+}
+
+// Kill stops serving at once, dropping every connection, as a crash
+// would.
+func (h *Aggregator) Kill() {
+	for _, srv := range h.servers {
+		srv.Close()
+	}
+}
+
+// Advance moves the clock on by d and runs a maintenance pass at the new
+// time.
+func (h *Aggregator) Advance(d time.Duration) {
+	h.Clock.Advance(d)
+	h.RunMaintenance(context.Background())
+}
+
+// Report submits a report over POST /ingest, failing the test unless it
+// is accepted.  Chain 1, a confidence of 0.9 and the clock's time stand
+// in for those left zero.
+func (h *Aggregator) Report(report swarm.IOCReport) {
+	h.t.Helper()
+	if report.ChainID == 0 {
+		report.ChainID = 1
+	}
+	if report.Confidence == 0 {
+		report.Confidence = 0.9
+	}
+	if report.Timestamp.IsZero() {
+		report.Timestamp = h.Clock.Now()
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	resp, err := http.Post(h.URL+"/ingest", "application/json", bytes.NewReader(body))
+	if err != nil {
+		h.t.Fatalf("POST /ingest: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(resp.Body)
+		h.t.Fatalf("POST /ingest of %s from %s: expected 2xx, got %d: %s", report.Address, report.SourceID, resp.StatusCode, msg)
+	}
+}
+
+// Do sends a request with the admin key, returning the response body.
+func (h *Aggregator) Do(method, path, body string) (int, []byte) {
+	h.t.Helper()
+	req, err := http.NewRequest(method, h.URL+path, strings.NewReader(body))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", AdminKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		h.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, data
+}
+
+// WaitForVersion long-polls GET /filter/wait until the filter reaches
+// version, returning it, and fails the test if it does not within five
+// seconds.
+func (h *Aggregator) WaitForVersion(version uint64) swarm.FilterPayload {
+	h.t.Helper()
+	path := fmt.Sprintf("/filter/wait?version=%d&timeout=5s", version-1)
+	status, data := h.Do(http.MethodGet, path, "")
+	if status == http.StatusNoContent {
+		h.t.Fatalf("Filter did not reach version %d, still at %d", version, h.FilterSnapshot().Version)
+	}
+	var payload swarm.FilterPayload
+	if err := json.Unmarshal(data, &payload); status != http.StatusOK || err != nil {
+		h.t.Fatalf("GET %s: expected 200, got %d: %s", path, status, data)
+	}
+	return payload
+}
+
+// DialWS subscribes to pushes with the subscriber key, returning the
+// connection once the initial snapshot has been read.
+func (h *Aggregator) DialWS() (*websocket.Conn, swarm.FilterEnvelope) {
+	h.t.Helper()
+	return h.DialWSQuery("")
+}
+
+// DialWSQuery is DialWS with query parameters, such as "max_frequency=1m".
+func (h *Aggregator) DialWSQuery(query string) (*websocket.Conn, swarm.FilterEnvelope) {
+	h.t.Helper()
+	url := h.WSURL
+	if query != "" {
+		url += "?" + query
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"X-API-Key": {SubscriberKey}})
+	if err != nil {
+		h.t.Fatalf("Dial %s: %v", url, err)
+	}
+	h.t.Cleanup(func() { conn.Close() })
+	return conn, h.ReadPush(conn)
+}
+
+// ReadPush reads the next envelope from a connection, failing the test
+// if none arrives within two seconds.
+func (h *Aggregator) ReadPush(conn *websocket.Conn) swarm.FilterEnvelope {
+	h.t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var env swarm.FilterEnvelope
+	if err := conn.ReadJSON(&env); err != nil {
+		h.t.Fatalf("Expected a push, got %v", err)
+	}
+	return env
+}
@@ -0,0 +1,99 @@
+package aegistest_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+func TestTWABThresholdMet(t *testing.T) {
+	h := aegistest.StartTestAggregator(t, aegistest.Options{})
+	addr := aegistest.Address("evil")
+
+	h.Report(swarm.IOCReport{Address: addr, Confidence: 0.95, SourceID: "agent-A"})
+	h.Report(swarm.IOCReport{Address: addr, Confidence: 0.90, SourceID: "agent-B"})
+	h.Advance(time.Minute)
+	h.Report(swarm.IOCReport{Address: addr, Confidence: 0.95, SourceID: "agent-A"})
+
+	if got := h.WaitForVersion(1); got.Count != 1 || got.Entries[0] != addr {
+		t.Errorf("Expected the address promoted at version 1, got %+v", got)
+	}
+}
+
+func TestTWABTimeSpanGateFollowsClock(t *testing.T) {
+	h := aegistest.StartTestAggregator(t, aegistest.Options{})
+	addr := aegistest.Address("patient")
+
+	h.Report(swarm.IOCReport{Address: addr, SourceID: "agent-A"})
+	h.Report(swarm.IOCReport{Address: addr, SourceID: "agent-B"})
+	h.Advance(30 * time.Second)
+	h.Report(swarm.IOCReport{Address: addr, SourceID: "agent-A"})
+	if status, _ := h.Do(http.MethodGet, "/filter/wait?version=0&timeout=50ms", ""); status != http.StatusNoContent {
+		t.Fatalf("Expected nothing promoted half a minute in, got %d", status)
+	}
+
+	h.Advance(30 * time.Second)
+	h.Report(swarm.IOCReport{Address: addr, SourceID: "agent-A"})
+	if got := h.WaitForVersion(1); got.Count != 1 {
+		t.Errorf("Expected the address promoted once a minute has passed, got %+v", got)
+	}
+}
+
+func TestSybilResistanceSingleSource(t *testing.T) {
+	h := aegistest.StartTestAggregator(t, aegistest.Options{})
+	victim, bystander := aegistest.Address("victim"), aegistest.Address("bystander")
+
+	// All reports from the same source — should NOT meet threshold
+	for i := 0; i < 10; i++ {
+		h.Report(swarm.IOCReport{Address: victim, Confidence: 1.0, SourceID: "sybil-attacker"})
+		h.Advance(time.Minute)
+	}
+	h.Report(swarm.IOCReport{Address: bystander, SourceID: "agent-A"})
+	h.Report(swarm.IOCReport{Address: bystander, SourceID: "agent-B"})
+	h.Advance(time.Minute)
+	h.Report(swarm.IOCReport{Address: bystander, SourceID: "agent-A"})
+
+	if got := h.WaitForVersion(1); got.Count != 1 || got.Entries[0] != bystander {
+		t.Errorf("Single-source Sybil attack should NOT add to bloom filter, got %v", got.Entries)
+	}
+}
+
+func TestSubscriberReceivesPush(t *testing.T) {
+	h := aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.TWAB = swarm.TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	}})
+	conn, snapshot := h.DialWS()
+	if snapshot.Kind != "snapshot" || snapshot.Version != 0 {
+		t.Fatalf("Expected an empty snapshot first, got %+v", snapshot)
+	}
+
+	h.Report(swarm.IOCReport{Address: aegistest.Address("pushed"), Confidence: 1.0, SourceID: "agent-X"})
+
+	if env := h.ReadPush(conn); env.Version != 1 || len(env.Payload) == 0 {
+		t.Errorf("Expected a push of version 1, got %+v", env)
+	}
+}
+
+func TestHarnessServesMetricsAndAdmin(t *testing.T) {
+	h := aegistest.StartTestAggregator(t, aegistest.Options{})
+	resp, err := http.Get(h.MetricsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /metrics: expected 200, got %d", resp.StatusCode)
+	}
+	if status, _ := h.Do(http.MethodGet, "/admin/sources", ""); status != http.StatusOK {
+		t.Errorf("GET /admin/sources with the admin key: expected 200, got %d", status)
+	}
+	if resp, err := http.Get(h.URL + "/metrics"); err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected /metrics only on its own listener, got %d", resp.StatusCode)
+		}
+	}
+}
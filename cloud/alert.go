@@ -0,0 +1,332 @@
+// Package swarm — Promotion alerts.
+//
+// Every address promoted by consensus becomes a PromotionEvent handed to
+// the registered AlertSinks (a Slack-compatible webhook, the log, or
+// anything implementing the interface).  IngestReport only enqueues the
+// event; delivery happens on the alert goroutine, so a slow or failing
+// sink never holds up ingest.  Events are collected for an interval and
+// each sink gets at most MaxPerInterval of them, the overflow folded into
+// the last as a count, so a promotion storm is one short burst of
+// messages rather than hundreds.
+//
+// High-priority events, such as the filter reaching its cap (see
+// filtercap.go), are delivered ahead of promotions and never folded into
+// them; repeats of one kind within an interval are counted on the first,
+// those of a burn-rate trip (see burnrate.go) only on the same chain and
+// those of the tripwire (see tripwire.go) only for the same address.
+package swarm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// alertQueueSize bounds events waiting for the next interval.  Events
+// beyond it are counted rather than queued.
+const alertQueueSize = 1024
+
+// PromotionEvent describes an address that reached consensus.
+type PromotionEvent struct {
+	Address       string     `json:"address"`
+	ChainID       int        `json:"chain_id"`
+	Category      string     `json:"category"`
+	WeightedScore float64    `json:"weighted_score"`
+	Score         float64    `json:"consensus_score"`
+	SourceCount   int        `json:"source_count"`
+	SourceTier    SourceTier `json:"source_tier,omitempty"` // of the report that reached consensus
+	SeverityBand  int        `json:"severity_band,omitempty"`
+	PromotedAt    time.Time  `json:"promoted_at"`
+
+	// Coalesced counts further promotions in the same interval that were
+	// folded into this event instead of being delivered separately.
+	Coalesced int `json:"coalesced,omitempty"`
+
+	// Kind is empty for a promotion.  alertFilterCap marks Address as the
+	// promotion that found the filter at MaxEntries, handled by Policy.
+	// alertFilterResize marks the filter resized to hold MaxEntries for
+	// Projected entries (see autosize.go).
+	Kind       string `json:"kind,omitempty"`
+	Priority   string `json:"priority,omitempty"`
+	MaxEntries int    `json:"max_entries,omitempty"`
+	Policy     string `json:"policy,omitempty"`
+	Projected  int    `json:"projected_entries,omitempty"`
+
+	// alertBurnRate marks ChainID tripping the burn-rate detector with
+	// Promotions in its short window, BurnRatio times its baseline rate,
+	// and Review if its promotions now go to review (see burnrate.go).
+	AlertID    string  `json:"alert_id,omitempty"`
+	BurnRatio  float64 `json:"burn_ratio,omitempty"`
+	Promotions int     `json:"promotions,omitempty"`
+	Review     bool    `json:"review,omitempty"`
+
+	// alertTripwire marks Address, queued for review rather than
+	// promoted, as Distance edits from NearAddress on list NearList (see
+	// tripwire.go).
+	NearAddress string `json:"near_address,omitempty"`
+	NearList    string `json:"near_list,omitempty"`
+	Distance    int    `json:"distance,omitempty"`
+}
+
+// Event kinds and priorities other than a plain promotion.
+const (
+	alertFilterCap    = "filter_cap"
+	alertFilterResize = "filter_resize"
+	alertBurnRate     = "burn_rate"
+	alertTripwire     = "tripwire"
+	alertPriorityHigh = "high"
+)
+
+// AlertSink receives promotion events.
+type AlertSink interface {
+	Notify(ctx context.Context, event PromotionEvent) error
+}
+
+// Enabled reports whether a built-in sink is configured.
+func (c AlertConfig) Enabled() bool { return c.WebhookURL != "" || c.Log }
+
+// alertDispatcher queues events and delivers them to sinks.
+type alertDispatcher struct {
+	config     AlertConfig
+	categories map[string]bool
+
+	mu      sync.Mutex
+	sinks   []AlertSink
+	queue   []PromotionEvent
+	urgent  []PromotionEvent // high priority, at most one per kind
+	dropped int
+}
+
+func newAlertDispatcher(cfg AlertConfig) *alertDispatcher {
+	defaults := DefaultConfig().Alerts
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaults.Interval
+	}
+	if cfg.MaxPerInterval < 1 {
+		cfg.MaxPerInterval = defaults.MaxPerInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaults.Timeout
+	}
+	d := &alertDispatcher{config: cfg}
+	if len(cfg.Categories) > 0 {
+		d.categories = make(map[string]bool, len(cfg.Categories))
+		for _, c := range cfg.Categories {
+			d.categories[c] = true
+		}
+	}
+	return d
+}
+
+// wants reports whether a promotion in category would be delivered.
+func (d *alertDispatcher) wants(category string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.sinks) > 0 && (d.categories == nil || d.categories[category])
+}
+
+// enqueue adds an event for the next delivery.  It never blocks.
+func (d *alertDispatcher) enqueue(event PromotionEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if event.Priority == alertPriorityHigh {
+		for i := range d.urgent {
+			if d.urgent[i].sameUrgent(event) {
+				d.urgent[i].Coalesced++
+				return
+			}
+		}
+		d.urgent = append(d.urgent, event)
+		return
+	}
+	if len(d.queue) >= alertQueueSize {
+		d.dropped++
+		return
+	}
+	d.queue = append(d.queue, event)
+}
+
+// take removes the queued events, high-priority ones first, coalescing
+// promotions past MaxPerInterval.
+func (d *alertDispatcher) take() ([]PromotionEvent, []AlertSink) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	events, dropped, urgent := d.queue, d.dropped, d.urgent
+	d.queue, d.dropped, d.urgent = nil, 0, nil
+
+	if max := d.config.MaxPerInterval; len(events) > max {
+		dropped += len(events) - max
+		events = events[:max]
+	}
+	if dropped > 0 && len(events) > 0 {
+		events[len(events)-1].Coalesced += dropped
+	}
+	return append(urgent, events...), append([]AlertSink(nil), d.sinks...)
+}
+
+// flush delivers everything queued.  Sink errors are logged.
+func (d *alertDispatcher) flush(ctx context.Context) {
+	events, sinks := d.take()
+	for _, sink := range sinks {
+		for _, event := range events {
+			sctx, cancel := context.WithTimeout(ctx, time.Duration(d.config.Timeout))
+			err := sink.Notify(sctx, event)
+			cancel()
+			if err != nil {
+				log.Printf("Alert sink %T failed for %s: %v", sink, event.Address, err)
+			}
+		}
+	}
+}
+
+// AddAlertSink registers a sink for promotion events.
+func (s *SwarmAggregator) AddAlertSink(sink AlertSink) {
+	s.alerts.mu.Lock()
+	defer s.alerts.mu.Unlock()
+	s.alerts.sinks = append(s.alerts.sinks, sink)
+}
+
+// alertPromotion queues the alert for a newly promoted entry, promoted by
+// a report of the given tier.
+func (s *SwarmAggregator) alertPromotion(entry ConfirmedEntry, tier SourceTier) {
+	if !s.alerts.wants(entry.Category) {
+		return
+	}
+	event := PromotionEvent{
+		Address:    entry.Address,
+		ChainID:    entry.ChainID,
+		Category:   entry.Category,
+		SourceTier: tier,
+		PromotedAt: entry.PromotedAt,
+	}
+	if sum, ok := s.twab.Summary(entry.Address); ok {
+		event.WeightedScore = sum.WeightedScore
+		event.SourceCount = sum.DistinctSources
+		event.Score = s.ConsensusScore(entry.Address)
+		event.SeverityBand = s.twab.SeverityBand(entry.Address, s.current().TWAB)
+	}
+	s.alerts.enqueue(event)
+}
+
+// runAlerts delivers queued alerts every interval until ctx is done, then
+// flushes what is left.
+func (s *SwarmAggregator) runAlerts(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.alerts.config.Interval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.alerts.flush(context.WithoutCancel(ctx))
+			return
+		case <-ticker.C:
+			s.alerts.flush(ctx)
+		}
+	}
+}
+
+// LogSink writes alerts to a logger, or the standard logger when nil.
+type LogSink struct {
+	Logger *log.Logger
+}
+
+// Notify implements AlertSink.
+func (l LogSink) Notify(_ context.Context, event PromotionEvent) error {
+	msg := alertText(event)
+	if l.Logger != nil {
+		l.Logger.Print(msg)
+	} else {
+		log.Print(msg)
+	}
+	return nil
+}
+
+// WebhookSink posts alerts as Slack-compatible JSON: a "text" field for
+// chat tools plus the structured "event" for everything else.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewWebhookSink returns a sink posting to url with the default client.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Client: http.DefaultClient}
+}
+
+// Notify implements AlertSink.
+func (w *WebhookSink) Notify(ctx context.Context, event PromotionEvent) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"text":  alertText(event),
+		"event": event,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// sameUrgent reports whether a high-priority event repeats e: one of the
+// same kind, on the same chain for a burn-rate trip and for the same
+// address for a tripwire.
+func (e PromotionEvent) sameUrgent(other PromotionEvent) bool {
+	switch {
+	case e.Kind != other.Kind:
+		return false
+	case e.Kind == alertBurnRate:
+		return e.ChainID == other.ChainID
+	case e.Kind == alertTripwire:
+		return e.Address == other.Address
+	}
+	return true
+}
+
+// alertText is the one-line human summary of an event.
+func alertText(e PromotionEvent) string {
+	if e.Kind == alertFilterCap {
+		msg := fmt.Sprintf("Aegis: filter reached its cap of %d entries promoting %s on chain %d; overflow policy %s",
+			e.MaxEntries, e.Address, e.ChainID, e.Policy)
+		if e.Coalesced > 0 {
+			msg += fmt.Sprintf(" (+%d more times)", e.Coalesced)
+		}
+		return msg
+	}
+	if e.Kind == alertBurnRate {
+		msg := fmt.Sprintf("Aegis: promotions on chain %d are running at %.1fx their baseline rate, %d in the short window; alert %s",
+			e.ChainID, e.BurnRatio, e.Promotions, e.AlertID)
+		if e.Review {
+			msg += ", promotions on the chain go to review until it is acknowledged"
+		}
+		return msg
+	}
+	if e.Kind == alertTripwire {
+		return fmt.Sprintf("Aegis: %s on chain %d reached consensus %d edits from %s address %s; queued for review",
+			e.Address, e.ChainID, e.Distance, e.NearList, e.NearAddress)
+	}
+	category := e.Category
+	if category == "" {
+		category = "uncategorized"
+	}
+	msg := fmt.Sprintf("Aegis: %s (%s) on chain %d reached consensus from %d sources, score %.2f",
+		e.Address, category, e.ChainID, e.SourceCount, e.WeightedScore)
+	if e.Coalesced > 0 {
+		msg += fmt.Sprintf(" (+%d more promotions)", e.Coalesced)
+	}
+	return msg
+}
@@ -0,0 +1,167 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink collects the events it is notified of.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []PromotionEvent
+}
+
+func (r *recordingSink) Notify(_ context.Context, e PromotionEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recordingSink) Events() []PromotionEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]PromotionEvent(nil), r.events...)
+}
+
+func reportFromSources(agg *SwarmAggregator, address, category string, sources ...string) {
+	for _, src := range sources {
+		agg.IngestReport(context.Background(), IOCReport{
+			Address: address, ChainID: 1, Category: category,
+			Confidence: 0.8, Timestamp: time.Now(), SourceID: src,
+		})
+	}
+}
+
+func TestPromotionAlertCarriesConsensusDetails(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2})
+	sink := &recordingSink{}
+	agg.AddAlertSink(sink)
+
+	reportFromSources(agg, evmAddress("drainer"), "drainer", "agent-A", "agent-B", "agent-C")
+	agg.alerts.flush(context.Background())
+
+	events := sink.Events()
+	if len(events) != 1 {
+		t.Fatalf("Expected one alert for one promotion, got %d", len(events))
+	}
+	e := events[0]
+	if e.Address != evmAddress("drainer") || e.ChainID != 1 || e.Category != "drainer" || e.SourceCount != 2 || e.WeightedScore != 1.6 {
+		t.Errorf("Unexpected event %+v", e)
+	}
+}
+
+func TestAlertStormIsCoalesced(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Alerts.MaxPerInterval = 2
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	sink := &recordingSink{}
+	agg.AddAlertSink(sink)
+
+	for i := 0; i < 10; i++ {
+		reportFromSources(agg, evmAddress(fmt.Sprintf("storm%d", i)), "drainer", "agent-A")
+	}
+	agg.alerts.flush(context.Background())
+
+	events := sink.Events()
+	if len(events) != 2 || events[1].Coalesced != 8 {
+		t.Fatalf("Expected 2 alerts with 8 coalesced, got %+v", events)
+	}
+	if !strings.Contains(alertText(events[1]), "+8 more") {
+		t.Errorf("Coalesced count missing from text %q", alertText(events[1]))
+	}
+}
+
+func TestAlertCategoryFilter(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Alerts.Categories = []string{"drainer"}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	sink := &recordingSink{}
+	agg.AddAlertSink(sink)
+
+	reportFromSources(agg, evmAddress("phish"), "phishing", "agent-A")
+	reportFromSources(agg, evmAddress("drainer"), "drainer", "agent-A")
+	agg.alerts.flush(context.Background())
+
+	if events := sink.Events(); len(events) != 1 || events[0].Address != evmAddress("drainer") {
+		t.Errorf("Expected only the drainer alert, got %+v", events)
+	}
+}
+
+// blockingSink never returns until released.
+type blockingSink struct{ release chan struct{} }
+
+func (b blockingSink) Notify(ctx context.Context, _ PromotionEvent) error {
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+	}
+	return errors.New("sink unavailable")
+}
+
+func TestFailingSinkNeverBlocksIngest(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Alerts.Interval = Duration(time.Millisecond)
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	sink := blockingSink{release: make(chan struct{})}
+	defer close(sink.release)
+	agg.AddAlertSink(sink)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go agg.runAlerts(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < alertQueueSize+100; i++ {
+			reportFromSources(agg, fmt.Sprintf("0x%d", i), "drainer", "agent-A")
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("IngestReport blocked on a stuck alert sink")
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var got map[string]json.RawMessage
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	sink := NewWebhookSink(srv.URL)
+	event := PromotionEvent{Address: evmAddress("drainer"), ChainID: 1, Category: "drainer", SourceCount: 3, WeightedScore: 2.4}
+	if err := sink.Notify(context.Background(), event); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+	var text string
+	json.Unmarshal(got["text"], &text)
+	if !strings.Contains(text, evmAddress("drainer")) || !strings.Contains(text, "3 sources") {
+		t.Errorf("Unexpected Slack text %q", text)
+	}
+	var sent PromotionEvent
+	if json.Unmarshal(got["event"], &sent); sent != event {
+		t.Errorf("Structured event mismatch: %+v", sent)
+	}
+
+	status = http.StatusInternalServerError
+	if err := sink.Notify(context.Background(), event); err == nil {
+		t.Error("Expected an error for a non-2xx response")
+	}
+}
@@ -0,0 +1,392 @@
+// Package swarm — Audit log of state-changing operations.
+//
+// With persistence.audit_log_file set, every admin action (block, unblock,
+// allowlist changes, feed imports, merges, ban lifts, signing key
+// rotation, API key revocation, configuration reloads, review and dispute
+// decisions) and every automatic one (TTL expiry, quota bans, dispute
+// escalations) is appended to the file
+// as one JSON line, recording the actor, time, affected address or
+// subject, and stated reason.  Admin actions are
+// written synchronously before they are applied, and the request fails
+// with 500 if the write does, so no change is ever made without its
+// record; a recorded action may still turn out to change nothing.
+// Automatic actions, potentially thousands per sweep, are queued and
+// written in the background by actor "system"; if the queue is full they
+// are dropped and counted.  The server never rewrites or truncates the
+// file.  A reload on SIGHUP is written synchronously too, as actor
+// "system", and is not applied if the write fails.
+//
+// GET /admin/audit reads the events back, filtered by since, actor, and
+// action, paging on the after cursor.  It scans the file, which is
+// adequate for occasional compliance queries.
+package swarm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// AuditAction names a kind of audited operation.
+type AuditAction string
+
+const (
+	AuditBlock         AuditAction = "block"
+	AuditUnblock       AuditAction = "unblock"
+	AuditAllow         AuditAction = "allowlist_add"
+	AuditDisallow      AuditAction = "allowlist_remove"
+	AuditImport        AuditAction = "import"
+	AuditMerge         AuditAction = "merge"
+	AuditBanLift       AuditAction = "ban_lift"
+	AuditSigningRotate AuditAction = "signing_key_rotate"
+	AuditAPIKeyRevoke  AuditAction = "api_key_revoke"
+	AuditExpire        AuditAction = "expire"
+	AuditBan           AuditAction = "ban"
+	AuditConfigReload  AuditAction = "config_reload"
+	AuditReviewApprove AuditAction = "review_approve"
+	AuditReviewReject  AuditAction = "review_reject"
+	AuditFilterRebuild AuditAction = "filter_rebuild"
+	AuditStateImport   AuditAction = "state_import"
+	AuditFilterEvict   AuditAction = "filter_evict"
+	AuditStagingSave   AuditAction = "staging_save"
+	AuditSanctionsSync AuditAction = "sanctions_sync"
+	AuditTierGrant     AuditAction = "source_tier_grant"
+	AuditTierRevoke    AuditAction = "source_tier_revoke"
+
+	AuditDisputeEscalate AuditAction = "dispute_escalate"
+	AuditDisputeUphold   AuditAction = "dispute_uphold"
+	AuditDisputeDismiss  AuditAction = "dispute_dismiss"
+
+	AuditBurnRateReview AuditAction = "burn_rate_review"
+	AuditAlertAck       AuditAction = "alert_ack"
+
+	AuditPromoteToActive AuditAction = "promote_to_active"
+)
+
+// Actors recorded for events without an API key behind them.
+const (
+	auditActorSystem    = "system"
+	auditActorAnonymous = "anonymous"
+)
+
+const (
+	// auditQueueSize bounds the automatic events waiting to be written.
+	auditQueueSize = 4096
+
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// Audit write outcomes recorded in aegis_audit_events_total.
+const (
+	auditOutcomeWritten = "written"
+	auditOutcomeFailed  = "failed"
+	auditOutcomeDropped = "dropped"
+)
+
+// AuditEvent is one line of the audit log.  Subject names what was acted
+// on besides an address: a source, feed, region, or key.
+type AuditEvent struct {
+	Seq     uint64      `json:"seq"`
+	Time    time.Time   `json:"time"`
+	Actor   string      `json:"actor"`
+	Action  AuditAction `json:"action"`
+	Address string      `json:"address,omitempty"`
+	Subject string      `json:"subject,omitempty"`
+	Reason  string      `json:"reason,omitempty"`
+}
+
+// AuditLogger appends events to a JSONL file.  A nil *AuditLogger records
+// nothing.
+type AuditLogger struct {
+	path    string
+	metrics *prometheus.CounterVec
+
+	mu   sync.Mutex // serializes writes and seq
+	file *os.File
+	seq  uint64 // of the last event written
+
+	queueMu sync.RWMutex // guards closed against sends on queue
+	closed  bool
+	queue   chan AuditEvent
+	done    chan struct{}
+}
+
+// OpenAuditLogger opens or creates the log at path, continuing its
+// sequence numbers, and starts the background writer.
+func OpenAuditLogger(path string, metrics *prometheus.CounterVec) (*AuditLogger, error) {
+	last, err := lastAuditSeq(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	l := &AuditLogger{
+		path:    path,
+		metrics: metrics,
+		file:    f,
+		seq:     last,
+		queue:   make(chan AuditEvent, auditQueueSize),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l, nil
+}
+
+// lastAuditSeq returns the sequence number of the last event in the log
+// at path, or zero if there is none.
+func lastAuditSeq(path string) (uint64, error) {
+	var last uint64
+	err := scanAudit(path, func(ev AuditEvent) bool {
+		last = ev.Seq
+		return true
+	})
+	return last, err
+}
+
+// scanAudit calls fn for each event in the log at path, oldest first,
+// until fn returns false.  A missing file has no events.
+func scanAudit(path string, fn func(AuditEvent) bool) error {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		var ev AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			return fmt.Errorf("audit log %s line %d: %w", path, line, err)
+		}
+		if !fn(ev) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// Record writes an event and syncs it to disk before returning.
+func (l *AuditLogger) Record(ev AuditEvent) error {
+	if l == nil {
+		return nil
+	}
+	err := l.write(ev)
+	if err != nil {
+		log.Printf("Failed to write audit event %s: %v", ev.Action, err)
+	}
+	return err
+}
+
+// RecordAsync queues an event for the background writer.  It never
+// blocks.
+func (l *AuditLogger) RecordAsync(ev AuditEvent) {
+	if l == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	l.queueMu.RLock()
+	defer l.queueMu.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.queue <- ev:
+	default:
+		l.metrics.WithLabelValues(auditOutcomeDropped).Inc()
+	}
+}
+
+func (l *AuditLogger) write(ev AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ev.Seq = l.seq + 1
+	data, err := json.Marshal(ev)
+	if err == nil {
+		if _, err = l.file.Write(append(data, '\n')); err == nil {
+			err = l.file.Sync()
+		}
+	}
+	if err != nil {
+		l.metrics.WithLabelValues(auditOutcomeFailed).Inc()
+		return err
+	}
+	l.seq = ev.Seq
+	l.metrics.WithLabelValues(auditOutcomeWritten).Inc()
+	return nil
+}
+
+// run writes queued events until the queue is closed.
+func (l *AuditLogger) run() {
+	defer close(l.done)
+	for ev := range l.queue {
+		if err := l.write(ev); err != nil {
+			log.Printf("Failed to write audit event %s: %v", ev.Action, err)
+		}
+	}
+}
+
+// Close writes any queued events and closes the file.
+func (l *AuditLogger) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.queueMu.Lock()
+	if l.closed {
+		l.queueMu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.queue)
+	l.queueMu.Unlock()
+
+	<-l.done
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
+
+// AuditQuery selects events from the log.  Zero fields match everything.
+type AuditQuery struct {
+	Since  time.Time
+	Actor  string
+	Action AuditAction
+	After  uint64 // return events with a greater Seq
+	Limit  int
+}
+
+// Query returns up to q.Limit matching events, oldest first, and whether
+// more follow.
+func (l *AuditLogger) Query(q AuditQuery) ([]AuditEvent, bool, error) {
+	if q.Limit <= 0 {
+		q.Limit = defaultAuditPageSize
+	}
+	// Holding mu keeps a half-written line out of the scan.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := []AuditEvent{}
+	more := false
+	err := scanAudit(l.path, func(ev AuditEvent) bool {
+		if ev.Seq <= q.After || ev.Time.Before(q.Since) ||
+			(q.Actor != "" && ev.Actor != q.Actor) || (q.Action != "" && ev.Action != q.Action) {
+			return true
+		}
+		if len(out) == q.Limit {
+			more = true
+			return false
+		}
+		out = append(out, ev)
+		return true
+	})
+	return out, more, err
+}
+
+// auditActor names the caller of an admin request by its API key.
+func auditActor(r *http.Request) string {
+	if key, ok := APIKeyFromContext(r.Context()); ok {
+		return key.Name()
+	}
+	return auditActorAnonymous
+}
+
+// auditAdmin records an admin request's action before it is applied,
+// answering 500 and reporting false if the write fails.  The reason is
+// taken from the reason query parameter unless given.
+func (s *SwarmAggregator) auditAdmin(w http.ResponseWriter, r *http.Request, ev AuditEvent) bool {
+	ev.Actor = auditActor(r)
+	if ev.Reason == "" {
+		ev.Reason = r.URL.Query().Get("reason")
+	}
+	if err := s.audit.Record(ev); err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to write audit log")
+		return false
+	}
+	return true
+}
+
+// auditSystem queues an automatic action for the audit log.
+func (s *SwarmAggregator) auditSystem(ev AuditEvent) {
+	ev.Actor = auditActorSystem
+	s.audit.RecordAsync(ev)
+}
+
+// handleAdminAudit is the HTTP handler for GET /admin/audit.
+//
+// Query parameters: since (RFC 3339), actor, action, after (the
+// next_cursor of a previous page), and limit.
+func (s *SwarmAggregator) handleAdminAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if s.audit == nil {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Audit log is not enabled")
+		return
+	}
+
+	params := r.URL.Query()
+	q := AuditQuery{Actor: params.Get("actor"), Action: AuditAction(params.Get("action")), Limit: defaultAuditPageSize}
+	if v := params.Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid since: want RFC 3339")
+			return
+		}
+		q.Since = t
+	}
+	if v := params.Get("after"); v != "" {
+		after, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid after")
+			return
+		}
+		q.After = after
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxAuditPageSize {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid limit: want 1 to %d", maxAuditPageSize))
+			return
+		}
+		q.Limit = limit
+	}
+
+	events, more, err := s.audit.Query(q)
+	if err != nil {
+		log.Printf("Failed to read audit log: %v", err)
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to read audit log")
+		return
+	}
+	resp := map[string]interface{}{"events": events}
+	if more {
+		resp["next_cursor"] = events[len(events)-1].Seq
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,185 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newAuditedAggregator returns an aggregator auditing to a temporary file,
+// with admin keys "ops" and "oncall".
+func newAuditedAggregator(t *testing.T, cfg Config) (*SwarmAggregator, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	audit, err := OpenAuditLogger(path, agg.metrics.auditEvents)
+	if err != nil {
+		t.Fatalf("OpenAuditLogger failed: %v", err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	agg.audit = audit
+	agg.keys.Add("ops-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	agg.keys.Add("oncall-secret", APIKey{ID: "oncall", Role: RoleAdmin})
+	return agg, path
+}
+
+func adminRequest(t *testing.T, agg *SwarmAggregator, secret, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-API-Key", secret)
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	return rec
+}
+
+type auditPage struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor uint64       `json:"next_cursor"`
+}
+
+func queryAudit(t *testing.T, agg *SwarmAggregator, query string) auditPage {
+	t.Helper()
+	rec := adminRequest(t, agg, "ops-secret", http.MethodGet, "/admin/audit"+query, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /admin/audit%s: expected 200, got %d: %s", query, rec.Code, rec.Body)
+	}
+	var page auditPage
+	if err := json.NewDecoder(rec.Body).Decode(&page); err != nil {
+		t.Fatalf("Invalid audit response: %v", err)
+	}
+	return page
+}
+
+func TestAdminActionsAreAudited(t *testing.T) {
+	agg, _ := newAuditedAggregator(t, DefaultConfig())
+	start := time.Now().Add(-time.Second)
+	drainer, router := evmAddress("drainer"), evmAddress("router")
+
+	for _, step := range []struct{ secret, method, path, body string }{
+		{"ops-secret", http.MethodPost, "/admin/block", `{"address":"` + drainer + `","reason":"incident 42"}`},
+		{"oncall-secret", http.MethodPost, "/admin/allowlist", `{"address":"` + router + `","reason":"known router"}`},
+		{"oncall-secret", http.MethodDelete, "/admin/allowlist?address=" + router + "&reason=misfiled", ""},
+		{"ops-secret", http.MethodPost, "/admin/unblock", `{"address":"` + drainer + `"}`},
+	} {
+		if rec := adminRequest(t, agg, step.secret, step.method, step.path, step.body); rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: expected 200, got %d", step.method, step.path, rec.Code)
+		}
+	}
+
+	all := queryAudit(t, agg, "?since="+start.UTC().Format(time.RFC3339))
+	want := []AuditEvent{
+		{Seq: 1, Actor: "ops", Action: AuditBlock, Address: drainer, Reason: "incident 42"},
+		{Seq: 2, Actor: "oncall", Action: AuditAllow, Address: router, Reason: "known router"},
+		{Seq: 3, Actor: "oncall", Action: AuditDisallow, Address: router, Reason: "misfiled"},
+		{Seq: 4, Actor: "ops", Action: AuditUnblock, Address: drainer},
+	}
+	if len(all.Events) != len(want) {
+		t.Fatalf("Expected %d events, got %+v", len(want), all.Events)
+	}
+	for i, ev := range all.Events {
+		if ev.Time.Before(start) {
+			t.Errorf("Event %d: expected a timestamp, got %v", i, ev.Time)
+		}
+		ev.Time = time.Time{}
+		if ev != want[i] {
+			t.Errorf("Event %d: expected %+v, got %+v", i, want[i], ev)
+		}
+	}
+
+	if page := queryAudit(t, agg, "?actor=oncall&action=allowlist_remove"); len(page.Events) != 1 || page.Events[0].Seq != 3 {
+		t.Errorf("Expected the actor and action filters to select event 3, got %+v", page.Events)
+	}
+	if page := queryAudit(t, agg, "?since=2099-01-01T00:00:00Z"); len(page.Events) != 0 {
+		t.Errorf("Expected no events after since, got %+v", page.Events)
+	}
+
+	first := queryAudit(t, agg, "?limit=3")
+	if len(first.Events) != 3 || first.NextCursor != 3 {
+		t.Fatalf("Expected a first page of 3 with a cursor, got %+v", first)
+	}
+	rest := queryAudit(t, agg, "?limit=3&after=3")
+	if len(rest.Events) != 1 || rest.Events[0].Seq != 4 || rest.NextCursor != 0 {
+		t.Errorf("Expected the last event and no cursor, got %+v", rest)
+	}
+	if rec := adminRequest(t, agg, "ops-secret", http.MethodGet, "/admin/audit?limit=0", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a zero limit, got %d", rec.Code)
+	}
+}
+
+func TestFailedAuditWriteFailsAdminAction(t *testing.T) {
+	agg, _ := newAuditedAggregator(t, DefaultConfig())
+	agg.audit.file.Close() // every write now fails
+
+	addr := evmAddress("unaudited")
+	rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/block", `{"address":"`+addr+`"}`)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500, got %d", rec.Code)
+	}
+	if _, ok := agg.Confirmed(addr); ok {
+		t.Error("An action whose audit write failed must not be applied")
+	}
+}
+
+func TestSystemActionsAreAuditedAsync(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Expiry.TTL = Duration(time.Hour)
+	cfg.Quota = testQuota(1, 0)
+	agg, path := newAuditedAggregator(t, cfg)
+	ctx := context.Background()
+
+	stale := evmAddress("stale")
+	promote(agg, stale, "")
+	if n := agg.ExpireDue(ctx, time.Now().Add(2*time.Hour)); n != 1 {
+		t.Fatalf("Expected one expiry, got %d", n)
+	}
+	report := IOCReport{Address: evmAddress("spam"), ChainID: 1, Confidence: 0.5, Timestamp: time.Now(), SourceID: "mallory"}
+	agg.SubmitReport(ctx, report)
+	agg.SubmitReport(ctx, report) // over quota: banned
+	agg.SubmitReport(ctx, report) // already banned: not a new event
+
+	if err := agg.audit.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	reopened, err := OpenAuditLogger(path, agg.metrics.auditEvents)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer reopened.Close()
+	agg.audit = reopened
+
+	events := queryAudit(t, agg, "?actor=system").Events
+	if len(events) != 2 {
+		t.Fatalf("Expected an expiry and a ban, got %+v", events)
+	}
+	if events[0].Action != AuditExpire || events[0].Address != stale {
+		t.Errorf("Expected the expiry of %s, got %+v", stale, events[0])
+	}
+	if events[1].Action != AuditBan || events[1].Subject != "mallory" || events[1].Reason == "" {
+		t.Errorf("Expected mallory's ban with its reason, got %+v", events[1])
+	}
+
+	// Sequence numbers continue across a reopen.
+	if err := reopened.Record(AuditEvent{Actor: "ops", Action: AuditSigningRotate}); err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if page := queryAudit(t, agg, "?action=signing_key_rotate"); len(page.Events) != 1 || page.Events[0].Seq != 3 {
+		t.Errorf("Expected seq 3 after reopening, got %+v", page.Events)
+	}
+}
+
+func TestAuditEndpointWithoutLog(t *testing.T) {
+	agg := NewSwarmAggregator()
+	agg.keys.Add("ops-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	if rec := adminRequest(t, agg, "ops-secret", http.MethodGet, "/admin/audit", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 with auditing disabled, got %d", rec.Code)
+	}
+	if rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/block", `{"address":"`+evmAddress("x")+`"}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected admin actions to work without an audit log, got %d", rec.Code)
+	}
+}
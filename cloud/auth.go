@@ -0,0 +1,225 @@
+// Package swarm — API key authentication for the Swarm Aggregator.
+//
+// Keys are configured as a comma-separated list of id:role:secret triples
+// (AEGIS_API_KEYS).  Clients present the secret either as a bearer token
+// or in the X-API-Key header.  The admin role satisfies every role check,
+// and the enterprise role every subscriber check.  The peer role is for
+// replicating aggregators (see replication.go).
+// An id of the form ns/id binds the key to a tenant namespace.
+package swarm
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// Role is the permission level bound to an API key.
+type Role string
+
+const (
+	RoleAdmin      Role = "admin"
+	RoleReporter   Role = "reporter"
+	RoleSubscriber Role = "subscriber"
+	RoleEnterprise Role = "enterprise" // a subscriber that may acknowledge pushes
+	RolePeer       Role = "peer"       // a replicating aggregator
+)
+
+// validRoles lists every role accepted in key configuration.
+var validRoles = map[Role]bool{
+	RoleAdmin:      true,
+	RoleReporter:   true,
+	RoleSubscriber: true,
+	RoleEnterprise: true,
+	RolePeer:       true,
+}
+
+// APIKey identifies an authenticated caller.  The secret itself is never
+// stored on the struct so it can be logged and attached to contexts.
+type APIKey struct {
+	ID        string `json:"id"`
+	Role      Role   `json:"role"`
+	Namespace string `json:"namespace,omitempty"` // "" is the global swarm
+}
+
+// KeyStore is a concurrent-safe set of API keys indexed by secret.
+type KeyStore struct {
+	mu   sync.RWMutex
+	keys map[string]APIKey // secret -> key
+}
+
+// NewKeyStore creates an empty key store.
+func NewKeyStore() *KeyStore {
+	return &KeyStore{keys: make(map[string]APIKey)}
+}
+
+// ParseAPIKeys builds a key store from an "id:role:secret,..." spec, where
+// id may be prefixed with a namespace as ns/id.
+func ParseAPIKeys(spec string) (*KeyStore, error) {
+	ks := NewKeyStore()
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid API key entry %q: want id:role:secret", item)
+		}
+		role := Role(parts[1])
+		if !validRoles[role] {
+			return nil, fmt.Errorf("invalid role %q for API key %q", parts[1], parts[0])
+		}
+		key := APIKey{ID: parts[0], Role: role}
+		if ns, id, ok := strings.Cut(parts[0], "/"); ok {
+			if !feedNamePattern.MatchString(ns) || id == "" {
+				return nil, fmt.Errorf("invalid namespace in API key %q: want ns/id", parts[0])
+			}
+			key.ID = id
+			if ns != defaultNamespace {
+				key.Namespace = ns
+			}
+		}
+		ks.Add(parts[2], key)
+	}
+	return ks, nil
+}
+
+// SetAPIKeys replaces the keys the aggregator accepts.  Call it before
+// serving.
+func (s *SwarmAggregator) SetAPIKeys(keys *KeyStore) {
+	s.keys = keys
+}
+
+// Add registers a key under the given secret.
+func (ks *KeyStore) Add(secret string, key APIKey) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.keys[secret] = key
+}
+
+// Lookup resolves a presented secret to its key.
+func (ks *KeyStore) Lookup(secret string) (APIKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	for s, key := range ks.keys {
+		if subtle.ConstantTimeCompare([]byte(s), []byte(secret)) == 1 {
+			return key, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// Revoke removes every secret bound to the named key (see APIKey.Name),
+// returning how many were removed.
+func (ks *KeyStore) Revoke(name string) int {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	n := 0
+	for secret, key := range ks.keys {
+		if key.Name() == name {
+			delete(ks.keys, secret)
+			n++
+		}
+	}
+	return n
+}
+
+// Named returns the key with the given name (see APIKey.Name).
+func (ks *KeyStore) Named(name string) (APIKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	for _, key := range ks.keys {
+		if key.Name() == name {
+			return key, true
+		}
+	}
+	return APIKey{}, false
+}
+
+// Len returns the number of configured keys.
+func (ks *KeyStore) Len() int {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return len(ks.keys)
+}
+
+// Name identifies the key as it was configured: ns/id for a namespaced
+// key, otherwise its ID.
+func (k APIKey) Name() string {
+	if k.Namespace == "" {
+		return k.ID
+	}
+	return k.Namespace + "/" + k.ID
+}
+
+// hasRole reports whether the key satisfies any of the given roles.  An
+// enterprise key also satisfies the subscriber role.
+func (k APIKey) hasRole(roles ...Role) bool {
+	if k.Role == RoleAdmin {
+		return true
+	}
+	for _, r := range roles {
+		if k.Role == r || (k.Role == RoleEnterprise && r == RoleSubscriber) {
+			return true
+		}
+	}
+	return false
+}
+
+type apiKeyContextKey struct{}
+
+// APIKeyFromContext returns the authenticated key attached by requireRole.
+func APIKeyFromContext(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(APIKey)
+	return key, ok
+}
+
+// presentedSecret extracts the API key secret from the request headers.
+func presentedSecret(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// requireRole wraps a handler so it only runs for keys holding one of the
+// given roles.  The resolved key is attached to the request context.
+func (s *SwarmAggregator) requireRole(next http.HandlerFunc, roles ...Role) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, ok := s.authorize(w, r, roles...)
+		if !ok {
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key)))
+	}
+}
+
+// authorize resolves the presented key and checks it holds one of roles,
+// answering the request with 401 or 403 when it does not.
+func (s *SwarmAggregator) authorize(w http.ResponseWriter, r *http.Request, roles ...Role) (APIKey, bool) {
+	return s.authorizeSecret(w, r, presentedSecret(r), roles...)
+}
+
+// authorizeSecret is authorize for a secret the caller extracted itself.
+func (s *SwarmAggregator) authorizeSecret(w http.ResponseWriter, r *http.Request, secret string, roles ...Role) (APIKey, bool) {
+	if secret == "" {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Missing API key")
+		return APIKey{}, false
+	}
+	key, ok := s.keys.Lookup(secret)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Invalid API key")
+		return APIKey{}, false
+	}
+	if !key.hasRole(roles...) {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "Forbidden")
+		return APIKey{}, false
+	}
+	return key, true
+}
@@ -0,0 +1,172 @@
+// Package swarm — Filter auto-sizing.
+//
+// With bloom.auto_size_horizon set, operators need not guess
+// bloom.expected_items at deploy time.  The filter_autosize maintenance
+// task tracks how fast the confirmed set grows, in entries a day, net of
+// removals and smoothed exponentially over autoSizeSmoothing, and
+// projects its size that far ahead.  Once it has watched the set for
+// autoSizeWarmup, a projection the current encoding cannot hold at
+// bloom.false_positive_rate rebuilds the filter (see rebuild.go), sized
+// for bloom.auto_size_headroom times the projection.  The headroom is the
+// hysteresis: the next resize waits until the projection has grown past
+// it, so a set hovering near a boundary does not rebuild on every pass.
+// The filter only grows this way; it is never shrunk.
+//
+// Each resize is logged, audited, raised as a high-priority alert, and
+// counted in aegis_filter_rebuilds_total.  GET /health reports, under
+// filter_sizing, the current fill and capacity, the growth rate, the
+// projection, and when the next resize is due at that rate.
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	// autoSizeSmoothing is the time constant of the growth rate average.
+	autoSizeSmoothing = 7 * 24 * time.Hour
+
+	// autoSizeWarmup is how long growth is watched before resizing on it.
+	autoSizeWarmup = 24 * time.Hour
+
+	// defaultAutoSizeHeadroom is the bloom.auto_size_headroom for zero.
+	defaultAutoSizeHeadroom = 1.5
+)
+
+// FilterSizing is the filter_sizing object of GET /health.
+type FilterSizing struct {
+	Entries      int        `json:"entries"`
+	Capacity     int        `json:"capacity"` // entries the encoding holds at the target rate
+	FillRatio    float64    `json:"fill_ratio"`
+	GrowthPerDay float64    `json:"growth_per_day"`
+	Horizon      Duration   `json:"horizon"`
+	Projected    int        `json:"projected_entries"`
+	NextResize   *time.Time `json:"next_resize_at,omitempty"` // none while the set is not growing
+	LastResize   *time.Time `json:"last_resize_at,omitempty"`
+}
+
+// filterGrowth tracks the growth rate of the confirmed set.
+type filterGrowth struct {
+	mu         sync.Mutex
+	since      time.Time // of the first observation
+	at         time.Time // of the last
+	count      int       // entries at the last
+	rate       float64   // smoothed, in entries a day
+	lastResize time.Time
+}
+
+// observe folds the count at now into the rate.
+func (g *filterGrowth) observe(count int, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.since.IsZero() {
+		g.since, g.at, g.count = now, now, count
+		return
+	}
+	dt := now.Sub(g.at)
+	if dt <= 0 {
+		return
+	}
+	observed := float64(count-g.count) / (dt.Hours() / 24)
+	if g.at.Equal(g.since) {
+		g.rate = observed // the first interval seeds the average
+	} else {
+		g.rate += (1 - math.Exp(-float64(dt)/float64(autoSizeSmoothing))) * (observed - g.rate)
+	}
+	g.at, g.count = now, count
+}
+
+// state returns the smoothed rate, whether it has been watched for
+// autoSizeWarmup at now, and when the filter was last resized.
+func (g *filterGrowth) state(now time.Time) (float64, bool, time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.rate, !g.since.IsZero() && now.Sub(g.since) >= autoSizeWarmup, g.lastResize
+}
+
+func (g *filterGrowth) resized(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lastResize = now
+}
+
+// autoSizeHeadroom returns bloom.auto_size_headroom, the default for zero.
+func (c BloomConfig) autoSizeHeadroom() float64 {
+	if c.AutoSizeHeadroom == 0 {
+		return defaultAutoSizeHeadroom
+	}
+	return c.AutoSizeHeadroom
+}
+
+// filterSizing projects the confirmed set at now from its growth rate.
+func (s *SwarmAggregator) filterSizing(now time.Time) FilterSizing {
+	cfg := s.config.Bloom
+	rate, _, last := s.growth.state(now)
+	s.mu.RLock()
+	entries := len(s.confirmed)
+	s.mu.RUnlock()
+	horizon := time.Duration(cfg.AutoSizeHorizon)
+	sizing := FilterSizing{
+		Entries:      entries,
+		Capacity:     s.bloomFilter.Params().Capacity(cfg.FalsePositiveRate),
+		FillRatio:    s.bloomFilter.FillRatio(),
+		GrowthPerDay: rate,
+		Horizon:      cfg.AutoSizeHorizon,
+		Projected:    entries + int(math.Ceil(math.Max(rate, 0)*horizon.Hours()/24)),
+	}
+	if rate > 0 {
+		// The projection reaches the capacity once the set is that much
+		// larger, at the current rate.
+		days := float64(sizing.Capacity-sizing.Projected) / rate
+		next := now.Add(time.Duration(math.Max(days, 0) * 24 * float64(time.Hour)))
+		sizing.NextResize = &next
+	}
+	if !last.IsZero() {
+		sizing.LastResize = &last
+	}
+	return sizing
+}
+
+// autoSizeFilter is the filter_autosize maintenance task.
+func (s *SwarmAggregator) autoSizeFilter(ctx context.Context) TaskStats {
+	now := s.clock.Now()
+	s.mu.RLock()
+	s.growth.observe(len(s.confirmed), now)
+	s.mu.RUnlock()
+	if _, warm, _ := s.growth.state(now); !warm {
+		return TaskStats{}
+	}
+	sizing := s.filterSizing(now)
+	if sizing.Projected <= sizing.Capacity {
+		return TaskStats{}
+	}
+
+	cfg := s.config.Bloom
+	target := uint(math.Ceil(float64(sizing.Projected) * cfg.autoSizeHeadroom()))
+	params := cfg.paramsFor(max(target, cfg.ExpectedItems))
+	if params.Bits <= s.bloomFilter.Params().Bits {
+		return TaskStats{}
+	}
+	res, err := s.rebuildFilter(ctx, params)
+	if err != nil {
+		log.Printf("Failed to resize filter for a projected %d entries: %v", sizing.Projected, err)
+		return TaskStats{}
+	}
+	s.growth.resized(now)
+	capacity := params.Capacity(cfg.FalsePositiveRate)
+	log.Printf("Resized filter for %d entries projected in %s at %.1f a day: capacity %d -> %d", sizing.Projected, time.Duration(cfg.AutoSizeHorizon), sizing.GrowthPerDay, sizing.Capacity, capacity)
+	s.auditSystem(AuditEvent{Action: AuditFilterRebuild, Reason: fmt.Sprintf("projected %d entries in %s, over capacity %d", sizing.Projected, time.Duration(cfg.AutoSizeHorizon), sizing.Capacity), Time: now})
+	s.alerts.enqueue(PromotionEvent{
+		PromotedAt: now,
+		Kind:       alertFilterResize,
+		Priority:   alertPriorityHigh,
+		MaxEntries: capacity,
+		Projected:  sizing.Projected,
+	})
+	return TaskStats{Items: res.Entries}
+}
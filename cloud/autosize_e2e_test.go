@@ -0,0 +1,96 @@
+package swarm_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+// startAutoSizeHarness starts a test aggregator sized for 500 entries at
+// 1% that projects its growth 30 days ahead.
+func startAutoSizeHarness(t *testing.T) *aegistest.Aggregator {
+	return aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.Bloom.ExpectedItems = 500
+		cfg.Bloom.FalsePositiveRate = 0.01
+		cfg.Bloom.AutoSizeHorizon = swarm.Duration(30 * 24 * time.Hour)
+	}})
+}
+
+// blockN confirms n new addresses.
+func blockN(h *aegistest.Aggregator, n int) {
+	for i := 0; i < n; i++ {
+		h.Block(context.Background(), swarm.AdminAction{Address: aegistest.Address(fmt.Sprintf("grown-%d", h.BloomFilterLen()))})
+	}
+}
+
+func filterSizingOf(t *testing.T, h *aegistest.Aggregator) swarm.FilterSizing {
+	t.Helper()
+	var health struct {
+		Sizing *swarm.FilterSizing `json:"filter_sizing"`
+	}
+	if getJSON(t, h, "/health", &health); health.Sizing == nil {
+		t.Fatal("Expected filter_sizing in /health")
+	}
+	return *health.Sizing
+}
+
+// filterRebuilds is aegis_filter_rebuilds_total.
+func filterRebuilds(t *testing.T, h *aegistest.Aggregator) float64 {
+	return metric(t, h, "aegis_filter_rebuilds_total")
+}
+
+func TestFilterAutoSizesOnceForSteadyGrowth(t *testing.T) {
+	h := startAutoSizeHarness(t)
+	h.Advance(time.Minute) // the first observation
+	before := filterParams(t, h)
+
+	// Ten entries a day, unevenly, for 40 days: the 30-day projection
+	// outgrows the 500 the filter was sized for within three weeks, and
+	// the resized one holds it for the rest.
+	for day := 1; day <= 40; day++ {
+		blockN(h, 5+10*(day%2))
+		h.Advance(24 * time.Hour)
+		if day == 5 {
+			sizing := filterSizingOf(t, h)
+			if sizing.LastResize != nil || sizing.NextResize == nil || !sizing.NextResize.After(h.Clock.Now()) {
+				t.Errorf("Expected a resize planned ahead on day 5, got %+v", sizing)
+			}
+			if sizing.GrowthPerDay < 5 || sizing.GrowthPerDay > 15 || sizing.Projected <= sizing.Entries {
+				t.Errorf("Expected a projection from about 10 a day, got %+v", sizing)
+			}
+		}
+	}
+
+	if rebuilds := filterRebuilds(t, h); rebuilds != 1 {
+		t.Fatalf("Expected exactly one resize, got %v", rebuilds)
+	}
+	after := filterParams(t, h)
+	if after.Bits <= before.Bits || after.Hash != before.Hash {
+		t.Errorf("Expected the filter resized larger with the same hash, from %+v to %+v", before, after)
+	}
+	sizing := filterSizingOf(t, h)
+	if sizing.LastResize == nil || sizing.Projected > sizing.Capacity || sizing.Capacity <= 500 {
+		t.Errorf("Expected the resized filter to hold the projection, got %+v", sizing)
+	}
+	if sizing.Entries != h.BloomFilterLen() {
+		t.Errorf("Expected %d entries reported, got %d", h.BloomFilterLen(), sizing.Entries)
+	}
+}
+
+func TestFilterAutoSizeWaitsForWarmup(t *testing.T) {
+	h := startAutoSizeHarness(t)
+	h.Advance(time.Minute)
+	blockN(h, 400) // a burst projecting far past 500
+	h.Advance(time.Hour)
+	if rebuilds := filterRebuilds(t, h); rebuilds != 0 {
+		t.Fatalf("Expected no resize inside the warmup, got %v", rebuilds)
+	}
+	h.Advance(swarm.AutoSizeWarmup)
+	if rebuilds := filterRebuilds(t, h); rebuilds != 1 {
+		t.Errorf("Expected a resize once warmed up, got %v", rebuilds)
+	}
+}
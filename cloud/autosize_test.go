@@ -0,0 +1,26 @@
+package swarm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBloomParamsCapacity(t *testing.T) {
+	for _, n := range []uint{100, 10000, 1000000} {
+		for _, p := range []float64{0.01, 0.001} {
+			got := BloomParamsFor(n, p).Capacity(p)
+			if math.Abs(float64(got)-float64(n)) > 0.02*float64(n) {
+				t.Errorf("Expected parameters for %d at %g to hold about %d, got %d", n, p, n, got)
+			}
+		}
+	}
+	if (BloomParams{}).Capacity(0.01) != 0 {
+		t.Error("Expected empty parameters to hold nothing")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Bloom.AutoSizeHeadroom = 1
+	if err := cfg.Validate(); err == nil {
+		t.Error("Expected a headroom of 1 rejected")
+	}
+}
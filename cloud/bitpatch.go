@@ -0,0 +1,186 @@
+// Package swarm — Bit-patch resume.
+//
+// A subscriber holding only the bits of the global filter can resume with
+// ?deltas=bits on /ws: instead of deltas naming addresses it is sent one
+// envelope of kind "patch", the XOR of the bits it holds and the current
+// ones (see bloom/patch.go), which applies removals as well as additions.
+// A patch is made from the bits retained for the version the subscriber
+// resumes from: the aggregator keeps those of the last
+// push.bit_patch_history versions pushed or sent whole while such a
+// subscriber was connected, so pushes cost nothing more while none is.
+// A version no longer retained, or retained with other parameters, is
+// answered with a resync snapshot, as is every resume after a rebuild or
+// a state import, which forget the retained bits.  Zero
+// push.bit_patch_history disables patches: deltas=bits always resyncs.
+//
+// Retaining a version costs one pass over the filter's entries, unless it
+// follows on from the last one retained by additions alone, which are set
+// on a copy of its bits.
+package swarm
+
+import (
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+
+	"github.com/aegis-protocol/swarm/bloom"
+)
+
+// envelopePatch is the kind of a bit-patch envelope.
+const envelopePatch = "patch"
+
+// BitPatch is the payload of a patch envelope: the bloom patch from the
+// bits at FromVersion to those at Version, encoded with the filter
+// parameters it names.
+type BitPatch struct {
+	Version     uint64 `json:"version"`
+	FromVersion uint64 `json:"from_version"`
+	Patch       []byte `json:"patch"`
+	BloomParams
+}
+
+// retainedBits is the global filter's bits at one version.
+type retainedBits struct {
+	version uint64
+	bits    *bloom.Bits
+}
+
+// bitHistory holds the bits of the most recent versions retained, oldest
+// first.
+type bitHistory struct {
+	mu   sync.Mutex
+	kept []retainedBits
+
+	subscribers atomic.Int64 // deltas=bits subscriptions open
+}
+
+func newBitHistory() *bitHistory {
+	return &bitHistory{}
+}
+
+// at returns the bits retained for version with params.
+func (h *bitHistory) at(version uint64, params BloomParams) (*bloom.Bits, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, r := range h.kept {
+		if r.version == version && r.bits.Params() == params {
+			return r.bits, true
+		}
+	}
+	return nil, false
+}
+
+// newest returns the bits retained last.
+func (h *bitHistory) newest() (retainedBits, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.kept) == 0 {
+		return retainedBits{}, false
+	}
+	return h.kept[len(h.kept)-1], true
+}
+
+// add retains bits for version, dropping the oldest past limit.
+func (h *bitHistory) add(version uint64, bits *bloom.Bits, limit int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if n := len(h.kept); n > 0 && h.kept[n-1].version >= version {
+		return // a concurrent retain got there first
+	}
+	h.kept = append(h.kept, retainedBits{version: version, bits: bits})
+	if over := len(h.kept) - limit; over > 0 {
+		h.kept = h.kept[over:]
+	}
+}
+
+// reset forgets every version retained.
+func (h *bitHistory) reset() {
+	h.mu.Lock()
+	h.kept = nil
+	h.mu.Unlock()
+}
+
+// retainPushedBits retains the bits of a global push while a subscriber
+// that may resume from it is connected.
+func (s *SwarmAggregator) retainPushedBits(snap filterSnapshot) {
+	if s.bitPatches.subscribers.Load() > 0 {
+		s.retainBits(snap)
+	}
+}
+
+// retainBits retains the bits of a global snapshot, returning them; nil
+// when patches are disabled or the snapshot's parameters have no bits.
+func (s *SwarmAggregator) retainBits(snap filterSnapshot) *bloom.Bits {
+	limit := s.current().Push.BitPatchHistory
+	if limit <= 0 {
+		return nil
+	}
+	if bits, ok := s.bitPatches.at(snap.version, snap.params); ok {
+		return bits
+	}
+	bits := s.bitsFollowingOn(snap)
+	if bits == nil {
+		var ok bool
+		if bits, ok = bloom.NewBits(snap.params); !ok {
+			return nil
+		}
+		for _, addr := range snap.entries {
+			bits.Add(addr)
+		}
+	}
+	s.bitPatches.add(snap.version, bits, limit)
+	return bits
+}
+
+// bitsFollowingOn returns the bits of snap made from the newest retained
+// ones, nil unless the changes between them are additions the filter's
+// history still holds.
+func (s *SwarmAggregator) bitsFollowingOn(snap filterSnapshot) *bloom.Bits {
+	last, ok := s.bitPatches.newest()
+	if !ok || last.version > snap.version || last.bits.Params() != snap.params {
+		return nil
+	}
+	changes, _, ok := s.bloomFilter.ChangesSince(last.version)
+	if !ok {
+		return nil
+	}
+	bits := last.bits.Clone()
+	for _, c := range changes {
+		if c.Version > snap.version {
+			break
+		}
+		if c.Removed {
+			return nil
+		}
+		bits.Add(c.Address)
+	}
+	return bits
+}
+
+// resumeBits builds the Resume reply for a subscriber holding the bits of
+// lastVersion: a patch to the current bits, or a resync snapshot when
+// those of lastVersion are not retained.
+func (s *SwarmAggregator) resumeBits(lastVersion uint64) ([]FilterEnvelope, error) {
+	snap := s.globalSnapshot()
+	bits := s.retainBits(snap)
+	held, ok := s.bitPatches.at(lastVersion, snap.params)
+	if bits == nil || !ok {
+		env, err := s.signSnapshot(snap)
+		if err != nil {
+			return nil, err
+		}
+		env.Resync = true
+		return []FilterEnvelope{env}, nil
+	}
+	patch, err := bloom.Diff(held, lastVersion, bits, snap.version)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(BitPatch{Version: snap.version, FromVersion: lastVersion, Patch: patch, BloomParams: snap.params})
+	if err != nil {
+		return nil, err
+	}
+	env := s.signEnvelope(envelopePatch, snap, data)
+	env.FromVersion = lastVersion
+	return []FilterEnvelope{env}, nil
+}
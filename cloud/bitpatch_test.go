@@ -0,0 +1,168 @@
+package swarm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/bloom"
+	"github.com/aegis-protocol/swarm/client"
+	"github.com/gorilla/websocket"
+)
+
+// heldBits returns the encoded bits of a snapshot envelope, as a client
+// holding only bits makes them.
+func heldBits(t *testing.T, env FilterEnvelope) []byte {
+	t.Helper()
+	var p FilterPayload
+	if err := json.Unmarshal(env.Payload, &p); env.Kind != envelopeSnapshot || err != nil {
+		t.Fatalf("Expected a snapshot, got %+v (%v)", env, err)
+	}
+	bits, ok := bloom.NewBits(p.BloomParams)
+	if !ok {
+		t.Fatalf("Expected bits for %+v", p.BloomParams)
+	}
+	for _, addr := range p.Entries {
+		bits.Add(addr)
+	}
+	return bloom.EncodeBits(bits, p.Version)
+}
+
+// applyPatch applies a patch envelope to held bits.
+func applyPatch(t *testing.T, agg *SwarmAggregator, held []byte, env FilterEnvelope) []byte {
+	t.Helper()
+	data, _ := json.Marshal(env)
+	if err := client.VerifyFilterPayload(agg.signer.Active().Public(), data); err != nil {
+		t.Fatalf("Patch failed verification: %v", err)
+	}
+	var p BitPatch
+	if err := json.Unmarshal(env.Payload, &p); env.Kind != envelopePatch || err != nil {
+		t.Fatalf("Expected a patch, got %+v (%v)", env, err)
+	}
+	next, err := bloom.ApplyPatch(held, p.Patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := agg.bloomFilter.MarshalBits(); !bytes.Equal(next, want) {
+		t.Fatalf("Expected the patched bits of v%d to equal the filter's", env.ToVersion)
+	}
+	return next
+}
+
+func TestBitPatchResumeAcrossVersions(t *testing.T) {
+	agg := NewSwarmAggregator()
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	blockAll(agg, "0xA") // v1
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?deltas=bits", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var env FilterEnvelope
+	if err := conn.ReadJSON(&env); err != nil {
+		t.Fatal(err)
+	}
+	held := heldBits(t, env)
+	blockAll(agg, "0xB", "0xC") // v3, pushed to the connection
+	var pushed FilterEnvelope
+	for pushed.Version != 3 {
+		if err := conn.ReadJSON(&pushed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conn.Close()
+	for deadline := time.Now().Add(2 * time.Second); agg.bitPatches.subscribers.Load() != 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the bits subscription closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	blockAll(agg, "0xD")                     // v4, retained by nothing
+	agg.Unblock(context.Background(), "0xB") // v5
+	reconnect, _, err := websocket.DefaultDialer.Dial(wsURL+"?last_version=1&deltas=bits", header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reconnect.Close()
+	reconnect.SetReadDeadline(time.Now().Add(2 * time.Second))
+	env = FilterEnvelope{}
+	if err := reconnect.ReadJSON(&env); err != nil || env.FromVersion != 1 || env.ToVersion != 5 || env.Resync {
+		t.Fatalf("Expected a patch from v1 to v5, got %+v (%v)", env, err)
+	}
+	held = applyPatch(t, agg, held, env)
+
+	// A push retained while a bits subscriber was connected patches too.
+	envs, err := agg.Resume(3, DeltaBits)
+	if err != nil || len(envs) != 1 {
+		t.Fatalf("Expected one envelope, got %+v (%v)", envs, err)
+	}
+	applyPatch(t, agg, heldBits(t, mustSnapshotAt(t, pushed)), envs[0])
+
+	agg.Unblock(context.Background(), "0xA") // v6
+	envs, _ = agg.Resume(5, DeltaBits)
+	applyPatch(t, agg, held, envs[0])
+
+	if envs, _ := agg.Resume(4, DeltaBits); len(envs) != 1 || envs[0].Kind != envelopeSnapshot || !envs[0].Resync {
+		t.Errorf("Expected a version pushed with no bits subscriber connected resynced, got %+v", envs)
+	}
+}
+
+// mustSnapshotAt returns a pushed envelope, failing unless it is a
+// snapshot.
+func mustSnapshotAt(t *testing.T, env FilterEnvelope) FilterEnvelope {
+	t.Helper()
+	if env.Kind != envelopeSnapshot {
+		t.Fatalf("Expected a pushed snapshot, got %+v", env)
+	}
+	return env
+}
+
+func TestBitPatchResumeAfterRebuildResyncs(t *testing.T) {
+	agg := NewSwarmAggregator()
+	blockAll(agg, "0xA", "0xB")
+	held, _ := agg.initialEnvelopes(false, 0, DeltaBits)
+	var env FilterEnvelope
+	json.Unmarshal(held[0], &env)
+	bits := heldBits(t, env)
+
+	blockAll(agg, "0xC")
+	envs, _ := agg.Resume(2, DeltaBits)
+	bits = applyPatch(t, agg, bits, envs[0])
+
+	// Rebuilt in place, then resized: neither can be patched.
+	if err := agg.RebuildFilter(BloomParams{}); err != nil {
+		t.Fatal(err)
+	}
+	if envs, _ := agg.Resume(3, DeltaBits); len(envs) != 1 || envs[0].Kind != envelopeSnapshot || !envs[0].Resync {
+		t.Errorf("Expected a resync after a rebuild, got %+v", envs)
+	}
+	version := agg.bloomFilter.Version()
+	if err := agg.RebuildFilter(BloomParams{Bits: 1 << 16, Hashes: 7, Hash: HashXXH64}); err != nil {
+		t.Fatal(err)
+	}
+	envs, _ = agg.Resume(version, DeltaBits)
+	if len(envs) != 1 || envs[0].Kind != envelopeSnapshot || !envs[0].Resync {
+		t.Fatalf("Expected a resize to resync, got %+v", envs)
+	}
+	bits = heldBits(t, envs[0])
+	blockAll(agg, "0xD")
+	envs, _ = agg.Resume(envs[0].Version, DeltaBits)
+	applyPatch(t, agg, bits, envs[0])
+
+	cfg := DefaultConfig()
+	cfg.Push.BitPatchHistory = 0
+	disabled := NewSwarmAggregatorWithConfig(cfg)
+	disabled.initialEnvelopes(false, 0, DeltaBits)
+	if envs, _ := disabled.Resume(0, DeltaBits); envs[0].Kind != envelopeSnapshot || !envs[0].Resync {
+		t.Errorf("Expected bit patches disabled, got %+v", envs)
+	}
+}
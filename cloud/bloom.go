@@ -1,75 +1,78 @@
-// Package main — Aegis Swarm Bloom Filter utilities.
+// Package swarm — Aegis Swarm Bloom filter.
 //
-// A compressed Bloom filter with O(1) lookups is the primary transport
-// format for pushing consensus blacklists to enterprise clients.
-package main
+// The filter itself lives in the importable bloom package, so the client
+// SDK, the CLI and embedders share its encoding; the aliases here keep the
+// aggregator's names for it.  What stays is sizing a filter from the
+// bloom section of the config.
+package swarm
 
 import (
-	"encoding/json"
-	"sync"
+	"github.com/aegis-protocol/swarm/bloom"
 )
 
-// BloomFilter is a concurrent-safe Bloom filter wrapper.
-type BloomFilter struct {
-	mu      sync.RWMutex
-	entries map[string]bool // Simplified for initial implementation
-	version uint64
-}
+type (
+	BloomFilter        = bloom.BloomFilter
+	FilterChange       = bloom.FilterChange
+	BloomParams        = bloom.BloomParams
+	FilterParams       = bloom.FilterParams
+	FormatVersionError = bloom.FormatVersionError
+)
 
-// NewBloomFilter creates a new empty Bloom filter.
-func NewBloomFilter() *BloomFilter {
-	return &BloomFilter{
-		entries: make(map[string]bool),
-		version: 0,
-	}
-}
+// Hash algorithm names and the filter format version.
+const (
+	HashFNV1a          = bloom.HashFNV1a
+	HashXXH64          = bloom.HashXXH64
+	BloomFormatVersion = bloom.BloomFormatVersion
+)
 
-// Add inserts an address into the filter.
-func (bf *BloomFilter) Add(address string) {
-	bf.mu.Lock()
-	defer bf.mu.Unlock()
-	bf.entries[address] = true
-	bf.version++
-}
+// Filter errors (see bloom/errors.go).
+var (
+	ErrFilterMagic        = bloom.ErrFilterMagic
+	ErrMalformedFilter    = bloom.ErrMalformed
+	ErrUnknownHash        = bloom.ErrUnknownHash
+	ErrIncompatibleParams = bloom.ErrIncompatibleParams
+)
 
-// Contains checks if an address might be in the filter.
-func (bf *BloomFilter) Contains(address string) bool {
-	bf.mu.RLock()
-	defer bf.mu.RUnlock()
-	return bf.entries[address]
-}
+// defaultFilterHistory is the number of changes retained for resume.
+const defaultFilterHistory = bloom.DefaultHistory
 
-// Len returns the number of entries.
-func (bf *BloomFilter) Len() int {
-	bf.mu.RLock()
-	defer bf.mu.RUnlock()
-	return len(bf.entries)
-}
+// BloomParamsFor sizes a filter for n items at false-positive rate p,
+// hashed with FNV-1a.
+func BloomParamsFor(n uint, p float64) BloomParams { return bloom.BloomParamsFor(n, p) }
 
-// Version returns the current filter version.
-func (bf *BloomFilter) Version() uint64 {
-	bf.mu.RLock()
-	defer bf.mu.RUnlock()
-	return bf.version
-}
+// ParseBloomFilter decodes a serialized filter, JSON or binary.
+func ParseBloomFilter(data []byte) (*BloomFilter, error) { return bloom.ParseBloomFilter(data) }
 
-// Serialize returns a JSON representation for WebSocket push.
-func (bf *BloomFilter) Serialize() ([]byte, error) {
-	bf.mu.RLock()
-	defer bf.mu.RUnlock()
+// DeserializeBloomFilter decodes a binary filter payload.
+func DeserializeBloomFilter(data []byte) (*BloomFilter, error) {
+	return bloom.DeserializeBloomFilter(data)
+}
 
-	payload := struct {
-		Version  uint64   `json:"version"`
-		Entries  []string `json:"entries"`
-		Count    int      `json:"count"`
-	}{
-		Version: bf.version,
-		Count:   len(bf.entries),
+// hash returns the configured hash algorithm, FNV-1a if unset.
+func (c BloomConfig) hash() string {
+	if c.Hash == "" {
+		return HashFNV1a
 	}
+	return c.Hash
+}
 
-	for addr := range bf.entries {
-		payload.Entries = append(payload.Entries, addr)
+// paramsFor sizes a filter for n items at the configured false-positive
+// rate and hash.
+func (c BloomConfig) paramsFor(n uint) BloomParams {
+	params := BloomParamsFor(n, c.FalsePositiveRate)
+	if params.Bits > 0 {
+		params.Hash = c.hash()
 	}
+	return params
+}
+
+// NewBloomFilter creates a new empty Bloom filter.
+func NewBloomFilter() *BloomFilter {
+	return NewBloomFilterWithConfig(DefaultConfig().Bloom, defaultFilterHistory)
+}
 
-	return json.Marshal(payload)
+// NewBloomFilterWithConfig creates an empty filter sized by cfg that
+// retains the last history changes for resume.
+func NewBloomFilterWithConfig(cfg BloomConfig, history int) *BloomFilter {
+	return bloom.NewBloomFilterWithParams(cfg.paramsFor(cfg.ExpectedItems), history)
 }
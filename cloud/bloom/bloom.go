@@ -0,0 +1,369 @@
+// Package bloom is the Aegis Swarm consensus filter.
+//
+// A compressed Bloom filter with O(1) lookups is the primary transport
+// format for pushing consensus blacklists to enterprise clients.  The
+// aggregator keeps its filter in a BloomFilter, versioned with a change
+// history for resume, and serializes it as JSON or in the binary format
+// of format.go; clients and embedders can decode both with this package,
+// and test membership against the bit array alone with Bits.
+package bloom
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// BloomFilter is a concurrent-safe Bloom filter wrapper.
+type BloomFilter struct {
+	mu      sync.RWMutex
+	entries map[string]bool // Simplified for initial implementation
+	version uint64
+	updated time.Time // when version was reached; zero before any change
+	params  BloomParams
+
+	// history holds the most recent changes, oldest first, one per
+	// version, so subscribers can resume from a version they already have.
+	history      []FilterChange
+	historyLimit int
+}
+
+// FilterChange is the change that produced one filter version.  ChainID
+// and Category are those of the confirmed entry, for push summaries; they
+// are zero for changes made through Add and Remove.
+type FilterChange struct {
+	Version  uint64
+	Address  string
+	Removed  bool
+	ChainID  int
+	Category string
+}
+
+// DefaultHistory is the number of changes NewBloomFilter retains for
+// resume.
+const DefaultHistory = 10000
+
+// BloomParams are the bit-array dimensions and hash algorithm of the
+// filter encoding.  Two filters can only be merged when they match.
+type BloomParams struct {
+	Bits   uint64 `json:"bits"`
+	Hashes uint   `json:"hashes"`
+	Hash   string `json:"hash"`
+}
+
+// BloomParamsFor sizes a filter for n items at false-positive rate p,
+// hashed with FNV-1a.
+func BloomParamsFor(n uint, p float64) BloomParams {
+	if n == 0 || p <= 0 || p >= 1 {
+		return BloomParams{}
+	}
+	bits := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	hashes := math.Max(1, math.Round(bits/float64(n)*math.Ln2))
+	return BloomParams{Bits: uint64(bits), Hashes: uint(hashes), Hash: HashFNV1a}
+}
+
+// Capacity is the number of entries params hold at false-positive rate p.
+func (params BloomParams) Capacity(p float64) int {
+	if params.Bits == 0 || params.Hashes == 0 {
+		return 0
+	}
+	k := float64(params.Hashes)
+	return int(-float64(params.Bits) / k * math.Log(1-math.Pow(p, 1/k)))
+}
+
+// NewBloomFilter creates a new empty filter sized for a million entries
+// at a 0.1% false-positive rate, hashed with xxh64, that retains the
+// last DefaultHistory changes for resume.
+func NewBloomFilter() *BloomFilter {
+	params := BloomParamsFor(1000000, 0.001)
+	params.Hash = HashXXH64
+	return NewBloomFilterWithParams(params, DefaultHistory)
+}
+
+// NewBloomFilterWithParams creates an empty filter encoded with params
+// that retains the last history changes for resume.
+func NewBloomFilterWithParams(params BloomParams, history int) *BloomFilter {
+	return &BloomFilter{
+		entries:      make(map[string]bool),
+		version:      0,
+		params:       params,
+		historyLimit: history,
+	}
+}
+
+// Add inserts an address into the filter.
+func (bf *BloomFilter) Add(address string) {
+	bf.AddTagged(address, 0, "")
+}
+
+// AddTagged inserts an address, recording the chain and category it is
+// confirmed under in the change history.
+func (bf *BloomFilter) AddTagged(address string, chainID int, category string) {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	bf.entries[address] = true
+	bf.advanceLocked()
+	bf.recordLocked(FilterChange{Address: address, ChainID: chainID, Category: category})
+}
+
+// advanceLocked moves to the next version.
+func (bf *BloomFilter) advanceLocked() {
+	bf.version++
+	bf.updated = time.Now()
+}
+
+// recordLocked appends the change for the current version, trimming the
+// oldest once the limit is reached.
+func (bf *BloomFilter) recordLocked(change FilterChange) {
+	if bf.historyLimit <= 0 {
+		return
+	}
+	change.Version = bf.version
+	bf.history = append(bf.history, change)
+	if over := len(bf.history) - bf.historyLimit; over > 0 {
+		bf.history = bf.history[over:]
+	}
+}
+
+// ChangesSince returns the changes after version since, oldest first, and
+// the current version.  ok is false when the retained history does not
+// reach back to since, or since is ahead of the filter.
+func (bf *BloomFilter) ChangesSince(since uint64) (changes []FilterChange, current uint64, ok bool) {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+
+	current = bf.version
+	if since > current {
+		return nil, current, false
+	}
+	if since == current {
+		return nil, current, true
+	}
+	if len(bf.history) == 0 || bf.history[0].Version > since+1 {
+		return nil, current, false
+	}
+	start := int(since + 1 - bf.history[0].Version)
+	return append([]FilterChange(nil), bf.history[start:]...), current, true
+}
+
+// Remove deletes an address from the filter, reporting whether it was
+// present.  The version only advances when something changed.
+func (bf *BloomFilter) Remove(address string) bool {
+	return bf.RemoveTagged(address, 0, "")
+}
+
+// RemoveTagged is Remove, recording the chain and category the address
+// was confirmed under in the change history.
+func (bf *BloomFilter) RemoveTagged(address string, chainID int, category string) bool {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	if !bf.entries[address] {
+		return false
+	}
+	delete(bf.entries, address)
+	bf.advanceLocked()
+	bf.recordLocked(FilterChange{Address: address, Removed: true, ChainID: chainID, Category: category})
+	return true
+}
+
+// Contains checks if an address might be in the filter.
+func (bf *BloomFilter) Contains(address string) bool {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+	return bf.entries[address]
+}
+
+// Snapshot returns the entries, sorted, and the version they make up.
+func (bf *BloomFilter) Snapshot() ([]string, uint64) {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+
+	entries := make([]string, 0, len(bf.entries))
+	for addr := range bf.entries {
+		entries = append(entries, addr)
+	}
+	sort.Strings(entries)
+	return entries, bf.version
+}
+
+// State returns the entries, sorted, with the version they make up, the
+// parameters they are encoded with, and when the version was reached,
+// all read under one lock.
+func (bf *BloomFilter) State() ([]string, uint64, BloomParams, time.Time) {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+
+	entries := make([]string, 0, len(bf.entries))
+	for addr := range bf.entries {
+		entries = append(entries, addr)
+	}
+	sort.Strings(entries)
+	return entries, bf.version, bf.params, bf.updated
+}
+
+// Len returns the number of entries.
+func (bf *BloomFilter) Len() int {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+	return len(bf.entries)
+}
+
+// Version returns the current filter version.
+func (bf *BloomFilter) Version() uint64 {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+	return bf.version
+}
+
+// Serialize returns a JSON representation for WebSocket push.  See
+// MarshalBinary for the binary one.  Like it, the encoding is canonical:
+// entries are sorted, so the same filter state always serializes to the
+// same bytes, and a checksum or signature over them is stable.
+func (bf *BloomFilter) Serialize() ([]byte, error) {
+	data, _, err := bf.SerializeVersioned()
+	return data, err
+}
+
+// SerializeVersioned returns the serialized payload together with the
+// version it encodes, captured under a single lock.
+func (bf *BloomFilter) SerializeVersioned() ([]byte, uint64, error) {
+	entries, version, params, _ := bf.State()
+	data, err := json.Marshal(filterPayload{
+		FormatVersion: BloomFormatVersion,
+		Version:       version,
+		Entries:       entries,
+		Count:         len(entries),
+		BloomParams:   params,
+	})
+	return data, version, err
+}
+
+// filterPayload is the serialized filter.  See format.go for the header
+// fields.
+type filterPayload struct {
+	FormatVersion int      `json:"format_version"`
+	Version       uint64   `json:"version"`
+	Entries       []string `json:"entries"`
+	Count         int      `json:"count"`
+	BloomParams
+}
+
+// ParseBloomFilter decodes a serialized filter, JSON or binary, such as
+// one received from a peer aggregator.  The result carries no change
+// history.
+func ParseBloomFilter(data []byte) (*BloomFilter, error) {
+	if IsBinary(data) {
+		return DeserializeBloomFilter(data)
+	}
+	var payload filterPayload
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, errorf(ErrMalformed, "bloom: invalid filter payload: %w", err)
+	}
+	if payload.FormatVersion == 0 {
+		payload.FormatVersion = 1
+	}
+	if err := checkFormatVersion(payload.FormatVersion); err != nil {
+		return nil, err
+	}
+	if payload.Bits == 0 || payload.Hashes == 0 {
+		return nil, errorf(ErrMalformed, "bloom: filter payload is missing bits and hashes")
+	}
+	if payload.Hash == "" {
+		payload.Hash = HashFNV1a
+	}
+	if _, ok := HasherFor(payload.Hash); !ok {
+		return nil, errorf(ErrUnknownHash, "bloom: unknown hash algorithm %q", payload.Hash)
+	}
+	bf := &BloomFilter{
+		entries: make(map[string]bool, len(payload.Entries)),
+		version: payload.Version,
+		params:  payload.BloomParams,
+	}
+	for _, addr := range payload.Entries {
+		bf.entries[addr] = true
+	}
+	return bf, nil
+}
+
+// Params returns the filter's bit-array dimensions.
+func (bf *BloomFilter) Params() BloomParams {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+	return bf.params
+}
+
+// FillRatio estimates the fraction of bits set in the filter's encoding.
+func (bf *BloomFilter) FillRatio() float64 {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+	if bf.params.Bits == 0 {
+		return 0
+	}
+	return -math.Expm1(-float64(bf.params.Hashes) * float64(len(bf.entries)) / float64(bf.params.Bits))
+}
+
+// Replace swaps in a new entry set and parameters as one new version,
+// returned.  The change history is dropped, since the difference from
+// the previous entries is not a list of changes, so subscribers resuming
+// from before it get a full snapshot.
+func (bf *BloomFilter) Replace(entries map[string]bool, params BloomParams) uint64 {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	bf.entries = entries
+	bf.params = params
+	bf.advanceLocked()
+	bf.history = nil
+	return bf.version
+}
+
+// ContinueFrom moves the filter to version, if it is behind, so the next
+// change follows on from a saved one, such as the version recorded
+// before a restart.
+func (bf *BloomFilter) ContinueFrom(version uint64) {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	bf.version = max(bf.version, version)
+}
+
+// Compatible reports why other cannot be merged into bf, if it cannot.
+func (bf *BloomFilter) Compatible(other *BloomFilter) error {
+	ours, theirs := bf.Params(), other.Params()
+	if theirs != ours {
+		return errorf(ErrIncompatibleParams, "bloom: cannot merge a filter of %d bits and %d %s hashes into one of %d bits and %d %s hashes",
+			theirs.Bits, theirs.Hashes, theirs.Hash, ours.Bits, ours.Hashes, ours.Hash)
+	}
+	return nil
+}
+
+// Merge adds every entry of other to bf, one version per new entry.  The
+// filters must have the same bit size and hash count.
+func (bf *BloomFilter) Merge(other *BloomFilter) error {
+	if other == bf {
+		return nil
+	}
+	if err := bf.Compatible(other); err != nil {
+		return err
+	}
+
+	other.mu.RLock()
+	incoming := make([]string, 0, len(other.entries))
+	for addr := range other.entries {
+		incoming = append(incoming, addr)
+	}
+	other.mu.RUnlock()
+	sort.Strings(incoming)
+
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	for _, addr := range incoming {
+		if bf.entries[addr] {
+			continue
+		}
+		bf.entries[addr] = true
+		bf.advanceLocked()
+		bf.recordLocked(FilterChange{Address: addr})
+	}
+	return nil
+}
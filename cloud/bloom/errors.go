@@ -0,0 +1,52 @@
+// Errors.
+//
+// Every error this package returns is one of the sentinels below, wraps
+// one, or is a *FormatVersionError, so callers branch with errors.Is and
+// errors.As rather than on messages, which stay free to say more:
+//
+//	ErrMalformed           a payload, bit array or patch that does not decode
+//	  ErrFilterMagic         one that is not of the expected kind at all
+//	ErrUnknownHash         a hash algorithm this build does not implement
+//	ErrIncompatibleParams  filters or bits of different parameters
+//	  ErrPatchParams         a patch between, or applied to, such bits
+//	ErrPatchVersion        a patch applied to bits of another version
+//	ErrUnencodable         a filter the binary format cannot carry
+package bloom
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMalformed is wrapped by every error for input that does not
+	// decode.
+	ErrMalformed = errors.New("bloom: malformed payload")
+
+	// ErrUnknownHash is wrapped by errors for a hash algorithm, by name or
+	// ID, this build does not implement.
+	ErrUnknownHash = errors.New("bloom: unknown hash algorithm")
+
+	// ErrIncompatibleParams is wrapped by errors for filters or bits that
+	// differ in size, hash count or hash algorithm where they must not.
+	ErrIncompatibleParams = errors.New("bloom: incompatible filter parameters")
+
+	// ErrUnencodable is wrapped by errors for a filter the binary format
+	// has no room for.
+	ErrUnencodable = errors.New("bloom: filter cannot be encoded")
+)
+
+// kindError is an error of a sentinel kind with a message of its own.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// errorf formats an error, as fmt.Errorf does, that is also kind.
+func errorf(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
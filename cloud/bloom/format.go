@@ -0,0 +1,181 @@
+// Filter format header.
+//
+// A client that syncs a filter must know the bit size, hash count and hash
+// algorithm it was encoded with, or it computes membership wrong without
+// noticing.  Every serialized filter therefore describes itself: the JSON
+// payload carries format_version and hash beside bits and hashes, and the
+// binary encoding opens with a fixed header, big-endian:
+//
+//	offset  size  field
+//	0       4     magic "AEGF"
+//	4       1     format version
+//	5       1     hash algorithm ID
+//	6       4     k, hashes per entry
+//	10      8     m, bits
+//	18      8     entry count
+//	26      8     filter version
+//
+// followed by the entries, each a 2-byte length and the address.  The
+// format version is checked first, so a later version may change
+// everything after it; a decoder refuses a version it does not know with
+// a *FormatVersionError rather than guessing.  A JSON payload without
+// format_version predates the header and is read as version 1 hashed
+// with FNV-1a; the hash algorithms and their IDs are in hasher.go.
+//
+// Both encodings, and the bit arrays of patch.go, are canonical: entries
+// are written sorted, so one filter state always encodes to the same
+// bytes, and the checksums, signatures and ETags the aggregator computes
+// over them are stable across calls and instances.  The golden_* files in
+// testdata pin the wire format byte for byte; regenerating them with
+// go test -update is a format change, and needs a new format version.
+
+package bloom
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// BloomFormatVersion is the filter format this build writes, and the
+// newest it reads.
+const BloomFormatVersion = 1
+
+// bloomMagic opens every binary filter payload.
+const bloomMagic = "AEGF"
+
+// bloomHeaderSize is the length of the binary header.
+const bloomHeaderSize = 34
+
+// ErrFilterMagic is returned for a binary payload that is not a filter.
+var ErrFilterMagic = errorf(ErrMalformed, "bloom: payload is not an aegis filter")
+
+// FormatVersionError is returned for a payload in a format version this
+// build cannot read.
+type FormatVersionError struct {
+	Version int
+}
+
+func (e *FormatVersionError) Error() string {
+	return fmt.Sprintf("bloom: unsupported filter format version %d, this build reads up to %d", e.Version, BloomFormatVersion)
+}
+
+// checkFormatVersion rejects format versions other than the ones known.
+func checkFormatVersion(v int) error {
+	if v < 1 || v > BloomFormatVersion {
+		return &FormatVersionError{Version: v}
+	}
+	return nil
+}
+
+// FilterParams describes a serialized filter: everything in the header
+// but the entries.
+type FilterParams struct {
+	FormatVersion int `json:"format_version"`
+	BloomParams
+	Count   int    `json:"count"`
+	Version uint64 `json:"version"`
+}
+
+// EncodeBinary writes the binary encoding of a filter of the given
+// entries, in their order.  p.FormatVersion and p.Count are ignored: the
+// header carries this build's format version and len(entries).
+func EncodeBinary(p FilterParams, entries []string) ([]byte, error) {
+	alg, ok := hashAlgorithms[p.Hash]
+	if !ok {
+		return nil, errorf(ErrUnknownHash, "bloom: unknown hash algorithm %q", p.Hash)
+	}
+	if uint64(p.Hashes) > 1<<32-1 {
+		return nil, errorf(ErrUnencodable, "bloom: %d hashes do not fit the header", p.Hashes)
+	}
+
+	size := bloomHeaderSize
+	for _, addr := range entries {
+		size += 2 + len(addr)
+	}
+	buf := make([]byte, bloomHeaderSize, size)
+	copy(buf, bloomMagic)
+	buf[4] = BloomFormatVersion
+	buf[5] = alg.id
+	binary.BigEndian.PutUint32(buf[6:], uint32(p.Hashes))
+	binary.BigEndian.PutUint64(buf[10:], p.Bits)
+	binary.BigEndian.PutUint64(buf[18:], uint64(len(entries)))
+	binary.BigEndian.PutUint64(buf[26:], p.Version)
+	for _, addr := range entries {
+		if len(addr) > 1<<16-1 {
+			return nil, errorf(ErrUnencodable, "bloom: entry of %d bytes is too long to encode", len(addr))
+		}
+		buf = binary.BigEndian.AppendUint16(buf, uint16(len(addr)))
+		buf = append(buf, addr...)
+	}
+	return buf, nil
+}
+
+// MarshalBinary returns the binary encoding of the filter, entries sorted.
+func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
+	entries, version, params, _ := bf.State()
+	return EncodeBinary(FilterParams{BloomParams: params, Version: version}, entries)
+}
+
+// DeserializeBloomFilter decodes a binary filter payload after validating
+// its header.  The result carries no change history.
+func DeserializeBloomFilter(data []byte) (*BloomFilter, error) {
+	if len(data) < 5 || string(data[:4]) != bloomMagic {
+		return nil, ErrFilterMagic
+	}
+	if err := checkFormatVersion(int(data[4])); err != nil {
+		return nil, err
+	}
+	if len(data) < bloomHeaderSize {
+		return nil, errorf(ErrMalformed, "bloom: header truncated at %d of %d bytes", len(data), bloomHeaderSize)
+	}
+
+	var params BloomParams
+	name, ok := hashAlgorithmByID(data[5])
+	if !ok {
+		return nil, errorf(ErrUnknownHash, "bloom: unknown hash algorithm ID %d", data[5])
+	}
+	params.Hash = name
+	params.Hashes = uint(binary.BigEndian.Uint32(data[6:]))
+	params.Bits = binary.BigEndian.Uint64(data[10:])
+	if params.Bits == 0 || params.Hashes == 0 {
+		return nil, errorf(ErrMalformed, "bloom: header is missing bits and hashes")
+	}
+	count := binary.BigEndian.Uint64(data[18:])
+	version := binary.BigEndian.Uint64(data[26:])
+
+	// Each entry takes at least its 2-byte length, which bounds a
+	// plausible count before anything is allocated.
+	body := data[bloomHeaderSize:]
+	if count > uint64(len(body)/2) {
+		return nil, errorf(ErrMalformed, "bloom: header claims %d entries in %d bytes", count, len(body))
+	}
+	bf := &BloomFilter{entries: make(map[string]bool, count), version: version, params: params}
+	for i := uint64(0); i < count; i++ {
+		if len(body) < 2 {
+			return nil, errorf(ErrMalformed, "bloom: entry %d truncated", i)
+		}
+		n := int(binary.BigEndian.Uint16(body))
+		if len(body) < 2+n {
+			return nil, errorf(ErrMalformed, "bloom: entry %d truncated", i)
+		}
+		if n == 0 {
+			return nil, errorf(ErrMalformed, "bloom: entry %d is empty", i)
+		}
+		bf.entries[string(body[2:2+n])] = true
+		body = body[2+n:]
+	}
+	if len(body) != 0 {
+		return nil, errorf(ErrMalformed, "bloom: %d bytes after the last of %d entries", len(body), count)
+	}
+	if uint64(len(bf.entries)) != count {
+		return nil, errorf(ErrMalformed, "bloom: header claims %d entries, payload has %d distinct", count, len(bf.entries))
+	}
+	return bf, nil
+}
+
+// IsBinary reports whether data is a binary filter payload rather than
+// JSON.
+func IsBinary(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(bloomMagic))
+}
@@ -0,0 +1,184 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Read fixture: %v", err)
+	}
+	return data
+}
+
+// The fixtures are payloads as written by other releases; they must keep
+// decoding the same way.
+func TestFilterFormatFixtures(t *testing.T) {
+	a, b := "0x"+string(bytes.Repeat([]byte("ab"), 20)), "0x"+string(bytes.Repeat([]byte("cd"), 20))
+	want := BloomParams{Bits: 1024, Hashes: 3, Hash: HashFNV1a}
+
+	v1 := readFixture(t, "filter_v1.bin")
+	bf, err := DeserializeBloomFilter(v1)
+	if err != nil {
+		t.Fatalf("Deserialize v1 failed: %v", err)
+	}
+	if bf.Params() != want || bf.Version() != 7 || bf.Len() != 2 || !bf.Contains(a) || !bf.Contains(b) {
+		t.Errorf("Expected v7 with two entries and %+v, got v%d %d entries %+v", want, bf.Version(), bf.Len(), bf.Params())
+	}
+	if out, _ := bf.MarshalBinary(); !bytes.Equal(out, v1) {
+		t.Errorf("Expected re-encoding to reproduce the v1 fixture byte for byte, got %x", out)
+	}
+
+	legacy, err := ParseBloomFilter(readFixture(t, "filter_legacy.json"))
+	if err != nil {
+		t.Fatalf("Parse of a pre-header JSON payload failed: %v", err)
+	}
+	if legacy.Params() != want || legacy.Len() != 2 {
+		t.Errorf("Expected the legacy payload read with the default hash, got %+v", legacy.Params())
+	}
+	if err := bf.Merge(legacy); err != nil {
+		t.Errorf("Expected legacy and v1 filters to merge, got %v", err)
+	}
+
+	for _, name := range []string{"filter_v2.bin", "filter_future.json"} {
+		_, err := ParseBloomFilter(readFixture(t, name))
+		var fv *FormatVersionError
+		if !errors.As(err, &fv) || fv.Version != 2 {
+			t.Errorf("%s: expected a FormatVersionError for version 2, got %v", name, err)
+		}
+	}
+}
+
+// goldenFilter builds the filter the golden files encode, adding its
+// entries in the given order.
+func goldenFilter(order []int) *BloomFilter {
+	entries := []string{
+		"0x" + string(bytes.Repeat([]byte("ab"), 20)),
+		"0x" + string(bytes.Repeat([]byte("cd"), 20)),
+		"0x" + string(bytes.Repeat([]byte("01"), 20)),
+		"bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq",
+	}
+	bf := NewBloomFilterWithParams(BloomParams{Bits: 256, Hashes: 3, Hash: HashXXH64}, 0)
+	for _, i := range order {
+		bf.Add(entries[i])
+	}
+	bf.Remove(entries[order[0]])
+	bf.Add(entries[order[0]])
+	return bf
+}
+
+// The golden files pin the wire format: any change to the encoded bytes
+// of the same filter fails here.
+func TestGoldenEncodings(t *testing.T) {
+	encodings := map[string]func(*BloomFilter) ([]byte, error){
+		"golden_filter.json": (*BloomFilter).Serialize,
+		"golden_filter.bin":  (*BloomFilter).MarshalBinary,
+		"golden_bits.bin":    (*BloomFilter).MarshalBits,
+	}
+	orders := [][]int{{0, 1, 2, 3}, {3, 1, 0, 2}, {2, 3, 1, 0}}
+	for name, encode := range encodings {
+		var first []byte
+		for _, order := range orders {
+			// Repeated calls walk the entry map in different orders.
+			for i := 0; i < 5; i++ {
+				data, err := encode(goldenFilter(order))
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if first == nil {
+					first = data
+				} else if !bytes.Equal(data, first) {
+					t.Fatalf("%s: the same filter encoded differently:\n %x\n %x", name, first, data)
+				}
+			}
+		}
+		path := filepath.Join("testdata", name)
+		if *update {
+			if err := os.WriteFile(path, first, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if want := readFixture(t, name); !bytes.Equal(first, want) {
+			t.Errorf("%s: the wire format changed:\n got  %x\n want %x", name, first, want)
+		}
+	}
+
+	empty, _ := NewBloomFilterWithParams(BloomParams{Bits: 256, Hashes: 3, Hash: HashXXH64}, 0).Serialize()
+	if want := `{"format_version":1,"version":0,"entries":[],"count":0,"bits":256,"hashes":3,"hash":"xxh64"}`; string(empty) != want {
+		t.Errorf("Expected an empty filter to list no entries, got %s", empty)
+	}
+}
+
+func TestDeserializeRejectsMalformedHeaders(t *testing.T) {
+	v1 := readFixture(t, "filter_v1.bin")
+	mutate := func(f func([]byte) []byte) []byte {
+		return f(append([]byte(nil), v1...))
+	}
+	for name, data := range map[string][]byte{
+		"json":             []byte(`{"version":1}`),
+		"truncated":        v1[:20],
+		"unknown hash":     mutate(func(b []byte) []byte { b[5] = 9; return b }),
+		"zero bits":        mutate(func(b []byte) []byte { copy(b[10:18], make([]byte, 8)); return b }),
+		"count overstated": mutate(func(b []byte) []byte { b[25] = 3; return b }),
+		"trailing bytes":   append(append([]byte(nil), v1...), 0),
+		"empty entry":      mutate(func(b []byte) []byte { b[34], b[35] = 0, 0; return b[:34+2+2+42] }),
+		"duplicate entry":  mutate(func(b []byte) []byte { copy(b[34+2+42+2:], b[34+2:34+2+42]); return b }),
+	} {
+		want := ErrMalformed
+		if name == "unknown hash" {
+			want = ErrUnknownHash
+		}
+		if _, err := DeserializeBloomFilter(data); !errors.Is(err, want) {
+			t.Errorf("%s: expected %v, got %v", name, want, err)
+		}
+	}
+	if _, err := DeserializeBloomFilter([]byte("GZIP")); !errors.Is(err, ErrFilterMagic) || !errors.Is(err, ErrMalformed) {
+		t.Errorf("Expected ErrFilterMagic, got %v", err)
+	}
+}
+
+// FuzzDeserializeFilter feeds arbitrary payloads to the binary decoder: it
+// must never panic, and whatever it accepts must re-encode canonically,
+// to a payload that decodes to the same filter.
+func FuzzDeserializeFilter(f *testing.F) {
+	for _, name := range []string{"filter_v1.bin", "filter_v2.bin"} {
+		data, err := os.ReadFile(filepath.Join("testdata", name))
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte("AEGF"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		bf, err := DeserializeBloomFilter(data)
+		if err != nil {
+			return
+		}
+		out, err := bf.MarshalBinary()
+		if err != nil {
+			t.Fatalf("MarshalBinary of an accepted payload failed: %v", err)
+		}
+		again, err := DeserializeBloomFilter(out)
+		if err != nil {
+			t.Fatalf("Re-encoded payload does not decode: %v", err)
+		}
+		if again.Params() != bf.Params() || again.Version() != bf.Version() || !reflect.DeepEqual(again.entries, bf.entries) {
+			t.Fatal("Re-encoding changed the filter")
+		}
+		if out2, _ := again.MarshalBinary(); !bytes.Equal(out2, out) {
+			t.Fatalf("Encoding is not canonical:\n %x\n %x", out, out2)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+// Bloom filter hash strategies.
+//
+// The k bit positions of an entry in an m-bit filter come from two 64-bit
+// hashes of it by double hashing: position i is (h1 + i*h2) mod m.  A
+// Hasher computes the pair, and which one a filter uses is one of its
+// parameters, chosen by bloom.hash and named in every serialized filter
+// by an algorithm ID (see format.go), so server and clients always
+// set and test the same bits:
+//
+//	name      ID  h1
+//	fnv1a-64  1   FNV-1a over the entry, byte at a time
+//	xxh64     2   xxHash64 over the entry, four 8-byte lanes at a time
+//
+// For both h2 is h1 through the SplitMix64 finalizer, forced odd so it is
+// coprime with power-of-two bit counts.  xxh64 is the default for new
+// filters: its independent lanes keep a wide core busy where FNV-1a's
+// multiply chain stalls it.  A payload without a hash predates the field
+// and is FNV-1a.  IDs are never reused, and a payload naming an unknown
+// algorithm is refused rather than read with the wrong hash.
+
+package bloom
+
+import (
+	"github.com/cespare/xxhash/v2"
+)
+
+// Hasher returns the two hashes an entry's bit positions derive from.
+type Hasher interface {
+	Hash64x2(data []byte) (uint64, uint64)
+}
+
+// Hash algorithm names.
+const (
+	HashFNV1a = "fnv1a-64"
+	HashXXH64 = "xxh64"
+)
+
+// hashAlgorithm is a registered Hasher with its binary ID.
+type hashAlgorithm struct {
+	id     uint8
+	hasher Hasher
+}
+
+// hashAlgorithms are the algorithms a filter may be encoded with.
+var hashAlgorithms = map[string]hashAlgorithm{
+	HashFNV1a: {id: 1, hasher: fnv1aHasher{}},
+	HashXXH64: {id: 2, hasher: xxh64Hasher{}},
+}
+
+// HasherFor returns the Hasher of a named algorithm.
+func HasherFor(name string) (Hasher, bool) {
+	alg, ok := hashAlgorithms[name]
+	return alg.hasher, ok
+}
+
+// hashAlgorithmByID returns the name of the algorithm with a binary ID.
+func hashAlgorithmByID(id uint8) (string, bool) {
+	for name, alg := range hashAlgorithms {
+		if alg.id == id {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// fnv1aHasher is FNV-1a double hashing.
+type fnv1aHasher struct{}
+
+func (fnv1aHasher) Hash64x2(data []byte) (uint64, uint64) {
+	h := fnv1a(data)
+	return h, secondHash(h)
+}
+
+// fnv1a is FNV-1a over a string or its bytes.
+func fnv1a[T string | []byte](data T) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(data); i++ {
+		h ^= uint64(data[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+// xxh64Hasher is xxHash64 double hashing.
+type xxh64Hasher struct{}
+
+func (xxh64Hasher) Hash64x2(data []byte) (uint64, uint64) {
+	h := xxhash.Sum64(data)
+	return h, secondHash(h)
+}
+
+// secondHash derives h2 from h1 with the SplitMix64 finalizer.
+func secondHash(h uint64) uint64 {
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+	return h | 1
+}
+
+// Bits is the bit array an encoding's parameters describe: what a client
+// holding only the bits tests membership against.  Clients test
+// every transaction target and approval spender, so lookups allocate
+// nothing: Contains hashes the string itself rather than a copy of its
+// bytes, and both variants call the registered algorithms directly, since
+// an entry passed through the Hasher interface escapes to the heap.  A
+// new algorithm needs a case in hashString and hashBytes.
+type Bits struct {
+	params BloomParams
+	hasher Hasher
+	words  []uint64
+}
+
+// NewBits returns an empty bit array for params, false if its hash
+// algorithm is unknown.
+func NewBits(params BloomParams) (*Bits, bool) {
+	hasher, ok := HasherFor(params.Hash)
+	if !ok || params.Bits == 0 {
+		return nil, false
+	}
+	return &Bits{params: params, hasher: hasher, words: make([]uint64, (params.Bits+63)/64)}, true
+}
+
+// Add sets the bits of entry.
+func (b *Bits) Add(entry string) { b.set(b.hashString(entry)) }
+
+// AddBytes is Add for an entry held as bytes.
+func (b *Bits) AddBytes(entry []byte) { b.set(b.hashBytes(entry)) }
+
+// Contains reports whether every bit of entry is set.
+func (b *Bits) Contains(entry string) bool { return b.test(b.hashString(entry)) }
+
+// ContainsBytes is Contains for an entry held as bytes.
+func (b *Bits) ContainsBytes(entry []byte) bool { return b.test(b.hashBytes(entry)) }
+
+func (b *Bits) hashString(entry string) (uint64, uint64) {
+	var h uint64
+	switch b.hasher.(type) {
+	case fnv1aHasher:
+		h = fnv1a(entry)
+	case xxh64Hasher:
+		h = xxhash.Sum64String(entry)
+	default:
+		panic("bloom bits with an unregistered hasher") // NewBits admits none
+	}
+	return h, secondHash(h)
+}
+
+func (b *Bits) hashBytes(entry []byte) (uint64, uint64) {
+	var h uint64
+	switch b.hasher.(type) {
+	case fnv1aHasher:
+		h = fnv1a(entry)
+	case xxh64Hasher:
+		h = xxhash.Sum64(entry)
+	default:
+		panic("bloom bits with an unregistered hasher")
+	}
+	return h, secondHash(h)
+}
+
+// set sets the bits of the hash pair h1, h2.
+func (b *Bits) set(h1, h2 uint64) {
+	for i := uint64(0); i < uint64(b.params.Hashes); i++ {
+		bit := (h1 + i*h2) % b.params.Bits
+		b.words[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// test reports whether every bit of the hash pair h1, h2 is set.
+func (b *Bits) test(h1, h2 uint64) bool {
+	for i := uint64(0); i < uint64(b.params.Hashes); i++ {
+		bit := (h1 + i*h2) % b.params.Bits
+		if b.words[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
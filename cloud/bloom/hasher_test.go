@@ -0,0 +1,172 @@
+package bloom
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"testing"
+)
+
+// benchFilterSize is the entry count of the benchmark filters.
+const benchFilterSize = 100_000
+
+// address returns a distinct EVM address for label.
+func address(label string) string {
+	sum := sha256.Sum256([]byte(label))
+	return "0x" + hex.EncodeToString(sum[:20])
+}
+
+// paramsFor sizes a filter for n entries at false-positive rate p, hashed
+// with the named algorithm.
+func paramsFor(n uint, p float64, hash string) BloomParams {
+	params := BloomParamsFor(n, p)
+	params.Hash = hash
+	return params
+}
+
+func TestHashersMatchReferenceImplementations(t *testing.T) {
+	for _, data := range []string{"", "a", address("a"), strings.Repeat("0123456789", 10)} {
+		ref := fnv.New64a()
+		ref.Write([]byte(data))
+		if h1, h2 := (fnv1aHasher{}).Hash64x2([]byte(data)); h1 != ref.Sum64() || h2&1 == 0 {
+			t.Errorf("fnv1a-64 of %q: expected h1 %x and an odd h2, got %x, %x", data, ref.Sum64(), h1, h2)
+		}
+	}
+	if h1, _ := (xxh64Hasher{}).Hash64x2(nil); h1 != 0xef46db3751d8e999 {
+		t.Errorf("Expected the xxh64 of nothing to be ef46db3751d8e999, got %x", h1)
+	}
+}
+
+func TestBloomBitsFalsePositiveRate(t *testing.T) {
+	const n = 10000
+	for name := range hashAlgorithms {
+		params := paramsFor(n, 0.01, name)
+		bits, ok := NewBits(params)
+		if !ok {
+			t.Fatalf("%s: expected bits for %+v", name, params)
+		}
+		for i := 0; i < n; i++ {
+			bits.Add(address(fmt.Sprint("in", i)))
+		}
+		fp := 0
+		for i := 0; i < n; i++ {
+			if !bits.Contains(address(fmt.Sprint("in", i))) {
+				t.Fatalf("%s: expected no false negatives", name)
+			}
+			if bits.Contains(address(fmt.Sprint("out", i))) {
+				fp++
+			}
+		}
+		if rate := float64(fp) / n; rate > 0.02 {
+			t.Errorf("%s: expected about 1%% false positives, got %v", name, rate)
+		}
+	}
+	if _, ok := NewBits(BloomParams{Bits: 1024, Hashes: 3, Hash: "md5"}); ok {
+		t.Error("Expected no bits for an unknown hash")
+	}
+}
+
+func TestFilterHashSurvivesEncoding(t *testing.T) {
+	bf := NewBloomFilterWithParams(paramsFor(100, 0.01, HashXXH64), 0)
+	bf.Add(address("a"))
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data[5] != hashAlgorithms[HashXXH64].id {
+		t.Errorf("Expected the xxh64 ID in the header, got %d", data[5])
+	}
+	if got, err := DeserializeBloomFilter(data); err != nil || got.Params() != bf.Params() {
+		t.Errorf("Expected the binary round trip to keep %+v, got %v", bf.Params(), err)
+	}
+	js, _ := bf.Serialize()
+	if got, err := ParseBloomFilter(js); err != nil || got.Params() != bf.Params() {
+		t.Errorf("Expected the JSON round trip to keep %+v, got %v", bf.Params(), err)
+	}
+
+	if _, err := ParseBloomFilter([]byte(`{"format_version":1,"bits":1024,"hashes":3,"hash":"murmur3"}`)); !errors.Is(err, ErrUnknownHash) {
+		t.Error("Expected a JSON payload with an unknown hash refused")
+	}
+	fnvFilter := NewBloomFilterWithParams(paramsFor(100, 0.01, HashFNV1a), 0)
+	if err := fnvFilter.Merge(bf); !errors.Is(err, ErrIncompatibleParams) {
+		t.Error("Expected filters of different hashes not to merge")
+	}
+}
+
+// benchHashers runs fn against a filter of benchFilterSize entries per
+// hash algorithm.
+func benchHashers(b *testing.B, fn func(b *testing.B, bits *Bits, entries [][]byte)) {
+	entries := make([][]byte, benchFilterSize)
+	for i := range entries {
+		entries[i] = []byte(fmt.Sprintf("0x%040x", i))
+	}
+	for _, name := range []string{HashFNV1a, HashXXH64} {
+		bits, _ := NewBits(paramsFor(benchFilterSize, 0.001, name))
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			fn(b, bits, entries)
+		})
+	}
+}
+
+func BenchmarkBloomAdd(b *testing.B) {
+	benchHashers(b, func(b *testing.B, bits *Bits, entries [][]byte) {
+		for i := 0; i < b.N; i++ {
+			bits.AddBytes(entries[i%len(entries)])
+		}
+	})
+}
+
+func BenchmarkBloomContains(b *testing.B) {
+	benchHashers(b, func(b *testing.B, bits *Bits, entries [][]byte) {
+		for _, e := range entries {
+			bits.AddBytes(e)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bits.ContainsBytes(entries[i%len(entries)])
+		}
+	})
+}
+
+func TestBloomBitsLookupAllocatesNothing(t *testing.T) {
+	for name := range hashAlgorithms {
+		bits, _ := NewBits(paramsFor(1000, 0.01, name))
+		in, out := address("in"), []byte(address("out"))
+		bits.Add(in)
+		bits.AddBytes(out)
+		if !bits.ContainsBytes([]byte(in)) || !bits.Contains(string(out)) {
+			t.Errorf("%s: expected the string and byte variants to set the same bits", name)
+		}
+		allocs := testing.AllocsPerRun(100, func() {
+			bits.Contains(in)
+			bits.ContainsBytes(out)
+			bits.Add(in)
+		})
+		if allocs != 0 {
+			t.Errorf("%s: expected lookups and adds to allocate nothing, got %v allocs", name, allocs)
+		}
+	}
+}
+
+// BenchmarkContains measures lookups of string entries, the way clients
+// test transaction targets, and fails if one allocates.
+func BenchmarkContains(b *testing.B) {
+	benchHashers(b, func(b *testing.B, bits *Bits, entries [][]byte) {
+		keys := make([]string, len(entries))
+		for i, e := range entries {
+			bits.AddBytes(e)
+			keys[i] = string(e)
+		}
+		if allocs := testing.AllocsPerRun(100, func() { bits.Contains(keys[0]) }); allocs != 0 {
+			b.Fatalf("Expected Contains to allocate nothing, got %v allocs/op", allocs)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			bits.Contains(keys[i%len(keys)])
+		}
+	})
+}
@@ -0,0 +1,230 @@
+// Bit patches.
+//
+// A client holding only a filter's bits can be caught up with the XOR of
+// its bit array and the current one instead of with entries: a patch sets
+// and clears exactly the bits that changed, so unlike an add-list it
+// applies removals too, and it deflates to almost nothing when few bits
+// changed.  A bit array is encoded as a big-endian header and its words,
+// little-endian:
+//
+//	offset  size  field
+//	0       4     magic "AEGB"
+//	4       1     format version
+//	5       1     hash algorithm ID
+//	6       4     k, hashes per entry
+//	10      8     m, bits
+//	18      8     filter version
+//	26      8n    the n = ceil(m/64) words
+//
+// and a patch from one version of it to another as:
+//
+//	0       4     magic "AEGP"
+//	4       1     format version
+//	5       8     from version
+//	13      8     to version
+//	21      8     parameter hash (see ParamsHash)
+//	29            the XOR of the two word arrays, deflated
+//
+// A patch applies only to the bits of the version and parameters it was
+// made from.  Bits of different parameters cannot be patched into each
+// other at all, so a filter resized by a rebuild must be sent whole.
+
+package bloom
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	bitsMagic       = "AEGB"
+	patchMagic      = "AEGP"
+	bitsHeaderSize  = 26
+	patchHeaderSize = 29
+)
+
+var (
+	// ErrPatchParams is returned for a patch between, or applied to, bits
+	// of different parameters.
+	ErrPatchParams = errorf(ErrIncompatibleParams, "bloom: patch is for bits of other parameters")
+
+	// ErrPatchVersion is returned for a patch applied to bits of a version
+	// other than the one it starts from.
+	ErrPatchVersion = errors.New("bloom: patch does not start from this version")
+)
+
+// ParamsHash identifies the hash algorithm, k and m of params, for a
+// patch to name the bits it applies to.
+func ParamsHash(params BloomParams) uint64 {
+	var buf [13]byte
+	buf[0] = hashAlgorithms[params.Hash].id
+	binary.BigEndian.PutUint32(buf[1:], uint32(params.Hashes))
+	binary.BigEndian.PutUint64(buf[5:], params.Bits)
+	return fnv1a(buf[:])
+}
+
+// Params returns the parameters the bits were made with.
+func (b *Bits) Params() BloomParams { return b.params }
+
+// Clone returns a copy of the bits.
+func (b *Bits) Clone() *Bits {
+	return &Bits{params: b.params, hasher: b.hasher, words: append([]uint64(nil), b.words...)}
+}
+
+// Bits returns the bit array of the filter's entries and the version they
+// make up.
+func (bf *BloomFilter) Bits() (*Bits, uint64, error) {
+	bf.mu.RLock()
+	defer bf.mu.RUnlock()
+	bits, ok := NewBits(bf.params)
+	if !ok {
+		return nil, 0, errorf(ErrUnknownHash, "bloom: no bit array for %d bits hashed with %q", bf.params.Bits, bf.params.Hash)
+	}
+	for addr := range bf.entries {
+		bits.Add(addr)
+	}
+	return bits, bf.version, nil
+}
+
+// MarshalBits returns the encoding of the filter's bit array.
+func (bf *BloomFilter) MarshalBits() ([]byte, error) {
+	bits, version, err := bf.Bits()
+	if err != nil {
+		return nil, err
+	}
+	return EncodeBits(bits, version), nil
+}
+
+// DiffBits returns the patch from an encoded bit array of an earlier
+// version, such as one MarshalBits returned, to the filter's current one.
+func (bf *BloomFilter) DiffBits(prevVersionBits []byte) ([]byte, error) {
+	prev, from, err := DecodeBits(prevVersionBits)
+	if err != nil {
+		return nil, err
+	}
+	bits, version, err := bf.Bits()
+	if err != nil {
+		return nil, err
+	}
+	return Diff(prev, from, bits, version)
+}
+
+// EncodeBits writes the encoding of bits at a filter version.
+func EncodeBits(bits *Bits, version uint64) []byte {
+	buf := make([]byte, bitsHeaderSize, bitsHeaderSize+8*len(bits.words))
+	copy(buf, bitsMagic)
+	buf[4] = BloomFormatVersion
+	buf[5] = hashAlgorithms[bits.params.Hash].id
+	binary.BigEndian.PutUint32(buf[6:], uint32(bits.params.Hashes))
+	binary.BigEndian.PutUint64(buf[10:], bits.params.Bits)
+	binary.BigEndian.PutUint64(buf[18:], version)
+	for _, w := range bits.words {
+		buf = binary.LittleEndian.AppendUint64(buf, w)
+	}
+	return buf
+}
+
+// DecodeBits reads an encoded bit array, returning it and its version.
+func DecodeBits(data []byte) (*Bits, uint64, error) {
+	if len(data) < 5 || string(data[:4]) != bitsMagic {
+		return nil, 0, ErrFilterMagic
+	}
+	if err := checkFormatVersion(int(data[4])); err != nil {
+		return nil, 0, err
+	}
+	if len(data) < bitsHeaderSize {
+		return nil, 0, errorf(ErrMalformed, "bloom: bits header truncated at %d of %d bytes", len(data), bitsHeaderSize)
+	}
+	name, ok := hashAlgorithmByID(data[5])
+	if !ok {
+		return nil, 0, errorf(ErrUnknownHash, "bloom: unknown hash algorithm ID %d", data[5])
+	}
+	params := BloomParams{
+		Hash:   name,
+		Hashes: uint(binary.BigEndian.Uint32(data[6:])),
+		Bits:   binary.BigEndian.Uint64(data[10:]),
+	}
+	if params.Bits == 0 || params.Hashes == 0 {
+		return nil, 0, errorf(ErrMalformed, "bloom: bits header is missing bits and hashes")
+	}
+	body := data[bitsHeaderSize:]
+	if uint64(len(body)) != (params.Bits+63)/64*8 {
+		return nil, 0, errorf(ErrMalformed, "bloom: %d bytes of words for %d bits", len(body), params.Bits)
+	}
+	bits, _ := NewBits(params)
+	for i := range bits.words {
+		bits.words[i] = binary.LittleEndian.Uint64(body[8*i:])
+	}
+	return bits, binary.BigEndian.Uint64(data[18:]), nil
+}
+
+// Diff returns the patch from prev, the bits at version from, to next,
+// the bits at version to.
+func Diff(prev *Bits, from uint64, next *Bits, to uint64) ([]byte, error) {
+	if prev.params != next.params {
+		return nil, ErrPatchParams
+	}
+	buf := bytes.NewBuffer(make([]byte, patchHeaderSize, patchHeaderSize+64))
+	header := buf.Bytes()
+	copy(header, patchMagic)
+	header[4] = BloomFormatVersion
+	binary.BigEndian.PutUint64(header[5:], from)
+	binary.BigEndian.PutUint64(header[13:], to)
+	binary.BigEndian.PutUint64(header[21:], ParamsHash(next.params))
+
+	zw, _ := flate.NewWriter(buf, flate.BestCompression)
+	var word [8]byte
+	for i, w := range next.words {
+		binary.LittleEndian.PutUint64(word[:], w^prev.words[i])
+		zw.Write(word[:])
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ApplyPatch applies a patch to an encoded bit array of the version it
+// starts from, returning the encoded bit array of the version it ends at.
+func ApplyPatch(bits []byte, patch []byte) ([]byte, error) {
+	prev, version, err := DecodeBits(bits)
+	if err != nil {
+		return nil, err
+	}
+	if len(patch) < 5 || string(patch[:4]) != patchMagic {
+		return nil, ErrFilterMagic
+	}
+	if err := checkFormatVersion(int(patch[4])); err != nil {
+		return nil, err
+	}
+	if len(patch) < patchHeaderSize {
+		return nil, errorf(ErrMalformed, "bloom: patch header truncated at %d of %d bytes", len(patch), patchHeaderSize)
+	}
+	if binary.BigEndian.Uint64(patch[21:]) != ParamsHash(prev.params) {
+		return nil, ErrPatchParams
+	}
+	if binary.BigEndian.Uint64(patch[5:]) != version {
+		return nil, ErrPatchVersion
+	}
+
+	// The XOR is exactly as long as the words, and the deflated stream
+	// must end the patch: nothing more is inflated or accepted.
+	xor := make([]byte, 8*len(prev.words))
+	body := bytes.NewReader(patch[patchHeaderSize:])
+	zr := flate.NewReader(body)
+	defer zr.Close()
+	if _, err := io.ReadFull(zr, xor); err != nil {
+		return nil, errorf(ErrMalformed, "bloom: invalid patch body: %w", err)
+	}
+	if n, err := zr.Read(make([]byte, 1)); n != 0 || err != io.EOF || body.Len() != 0 {
+		return nil, errorf(ErrMalformed, "bloom: patch body is longer than the bits")
+	}
+	next := prev.Clone()
+	for i := range next.words {
+		next.words[i] ^= binary.LittleEndian.Uint64(xor[8*i:])
+	}
+	return EncodeBits(next, binary.BigEndian.Uint64(patch[13:])), nil
+}
@@ -0,0 +1,85 @@
+package bloom
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestBitPatchesRoundTripAcrossVersions(t *testing.T) {
+	bf := NewBloomFilterWithParams(paramsFor(1000, 0.01, HashXXH64), 0)
+	held, err := bf.MarshalBits() // a client's copy at v0
+	if err != nil {
+		t.Fatal(err)
+	}
+	for step := 0; step < 5; step++ {
+		for i := 0; i < 20; i++ {
+			bf.Add(address(fmt.Sprint(step, "-", i)))
+		}
+		if step > 0 {
+			bf.Remove(address(fmt.Sprint(step-1, "-", 0)))
+		}
+		patch, err := bf.DiffBits(held)
+		if err != nil {
+			t.Fatalf("step %d: %v", step, err)
+		}
+		if held, err = ApplyPatch(held, patch); err != nil {
+			t.Fatalf("step %d: %v", step, err)
+		}
+		want, _ := bf.MarshalBits()
+		if !bytes.Equal(held, want) {
+			t.Fatalf("step %d: expected the patched bits to equal the filter's", step)
+		}
+	}
+
+	bits, version, err := DecodeBits(held)
+	if err != nil || version != bf.Version() {
+		t.Fatalf("Expected bits at v%d, got v%d (%v)", bf.Version(), version, err)
+	}
+	if !bits.Contains(address("4-3")) || bits.Contains(address("3-0")) {
+		t.Error("Expected the patched bits to hold the last additions and not the removals")
+	}
+
+	unchanged, _ := bf.DiffBits(held)
+	full, _ := bf.MarshalBits()
+	if len(unchanged) > len(full)/20 {
+		t.Errorf("Expected a patch of nothing to deflate well below %d bytes, got %d", len(full), len(unchanged))
+	}
+}
+
+func TestBitPatchesRefuseMismatches(t *testing.T) {
+	bf := NewBloomFilterWithParams(paramsFor(1000, 0.01, HashXXH64), 0)
+	bf.Add(address("a"))
+	v1, _ := bf.MarshalBits()
+	bf.Add(address("b"))
+	v2, _ := bf.MarshalBits()
+	patch, err := bf.DiffBits(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ApplyPatch(v2, patch); !errors.Is(err, ErrPatchVersion) {
+		t.Errorf("Expected a patch from v1 refused on v2, got %v", err)
+	}
+
+	// A rebuild resizes the filter: nothing held before it can be patched.
+	rebuilt := NewBloomFilterWithParams(paramsFor(4000, 0.01, HashXXH64), 0)
+	rebuilt.Replace(map[string]bool{address("a"): true, address("b"): true}, rebuilt.Params())
+	if _, err := rebuilt.DiffBits(v2); !errors.Is(err, ErrPatchParams) || !errors.Is(err, ErrIncompatibleParams) {
+		t.Errorf("Expected a diff across a resize refused, got %v", err)
+	}
+	otherBits, _ := rebuilt.MarshalBits()
+	if _, err := ApplyPatch(otherBits, patch); !errors.Is(err, ErrPatchParams) {
+		t.Errorf("Expected a patch applied to bits of other parameters refused, got %v", err)
+	}
+
+	if _, err := ApplyPatch(v1, append(patch[:len(patch):len(patch)], 1, 2, 3)); !errors.Is(err, ErrMalformed) {
+		t.Error("Expected a patch with trailing garbage refused")
+	}
+	if _, _, err := DecodeBits(v1[:len(v1)-8]); !errors.Is(err, ErrMalformed) {
+		t.Error("Expected truncated bits refused")
+	}
+	if _, err := ApplyPatch(v1, v1); !errors.Is(err, ErrFilterMagic) {
+		t.Errorf("Expected bits refused as a patch, got %v", err)
+	}
+}
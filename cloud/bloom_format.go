@@ -0,0 +1,87 @@
+// Package swarm — Filter format header.
+//
+// Every serialized filter describes its bit size, hash count and hash
+// algorithm, so a client cannot compute membership wrong without noticing
+// (the format is described in the bloom package).  GET /filter with
+// Accept: application/octet-stream, Bloom format only, returns the binary
+// encoding, and the JSON payload carries format_version and hash beside
+// bits and hashes.
+//
+// GET /filter/params returns the header of the caller's filter without the
+// entries, so a client can check compatibility before subscribing.
+package swarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/aegis-protocol/swarm/bloom"
+)
+
+// contentTypeBinaryFilter selects the binary encoding on GET /filter.
+const contentTypeBinaryFilter = "application/octet-stream"
+
+// FilterPayload is the serialized filter, as BloomFilter.Serialize writes
+// it, GET /filter serves it, and exact.go and msgpack.go encode it.
+type FilterPayload struct {
+	FormatVersion int      `json:"format_version"`
+	Version       uint64   `json:"version"`
+	Entries       []string `json:"entries"`
+	Count         int      `json:"count"`
+	BloomParams
+}
+
+// filterParams returns the header snap is serialized with.
+func (snap filterSnapshot) filterParams() FilterParams {
+	return FilterParams{
+		FormatVersion: BloomFormatVersion,
+		BloomParams:   snap.params,
+		Count:         len(snap.entries),
+		Version:       snap.version,
+	}
+}
+
+// acceptsBinaryFilter reports whether the request's Accept header asks for
+// the binary encoding.
+func acceptsBinaryFilter(r *http.Request) bool {
+	for _, v := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(v), ";")
+		if strings.EqualFold(strings.TrimSpace(mediaType), contentTypeBinaryFilter) {
+			return true
+		}
+	}
+	return false
+}
+
+// signBinary signs the binary encoding of a snapshot.
+func (s *SwarmAggregator) signBinary(snap filterSnapshot) (FilterEnvelope, error) {
+	if snap.cached() {
+		return s.encodedState(snap.state, encodingBinary, s.signBinary)
+	}
+	data, err := bloom.EncodeBinary(snap.filterParams(), snap.entries)
+	if err != nil {
+		return FilterEnvelope{}, err
+	}
+	return s.signEnvelope(envelopeSnapshot, snap, data), nil
+}
+
+// handleFilterParams is the HTTP handler for GET /filter/params.  It takes
+// the same namespace key as GET /filter.
+func (s *SwarmAggregator) handleFilterParams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	ns, ok := s.requestNamespace(w, r)
+	if !ok {
+		return
+	}
+	snap := s.globalSnapshot()
+	if ns != "" {
+		snap = s.namespaceSnapshot(s.namespace(ns))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snap.filterParams())
+}
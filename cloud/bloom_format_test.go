@@ -0,0 +1,52 @@
+package swarm
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestFilterParamsAndBinaryFilter(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	addr := evmAddress("binary")
+	promote(agg, addr, "")
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/filter/params", nil))
+	var params FilterParams
+	json.NewDecoder(rec.Body).Decode(&params)
+	want := FilterParams{FormatVersion: BloomFormatVersion, BloomParams: agg.bloomFilter.Params(), Count: 1, Version: agg.bloomFilter.Version()}
+	if rec.Code != http.StatusOK || params != want || params.Hash != HashXXH64 {
+		t.Fatalf("Expected %+v, got %d %+v", want, rec.Code, params)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/filter", nil)
+	req.Header.Set("Accept", "application/octet-stream; q=1, application/json; q=0.5")
+	rec = httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	if ct := rec.Header().Get("Content-Type"); ct != contentTypeBinaryFilter {
+		t.Fatalf("Expected a binary filter, got %q", ct)
+	}
+	payload := rec.Body.Bytes()
+	bf, err := DeserializeBloomFilter(payload)
+	if err != nil || !bf.Contains(addr) || bf.Params() != params.BloomParams {
+		t.Fatalf("Expected the promoted address in the binary filter, got %v", err)
+	}
+	sig, _ := base64.StdEncoding.DecodeString(rec.Header().Get(headerFilterSignature))
+	version, _ := strconv.ParseUint(rec.Header().Get(headerFilterVersion), 10, 64)
+	if !ed25519.Verify(agg.signer.Active().Public(), filterSigningMessage(version, payload), sig) {
+		t.Error("Expected the binary payload signed")
+	}
+
+	rec = httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/filter", nil))
+	var p FilterPayload
+	json.NewDecoder(rec.Body).Decode(&p)
+	if p.FormatVersion != BloomFormatVersion || p.BloomParams != params.BloomParams {
+		t.Errorf("Expected the JSON payload to carry the header fields, got %+v", p)
+	}
+}
@@ -0,0 +1,269 @@
+// Package swarm — Per-chain promotion burn-rate alerts.
+//
+// A sudden burst of promotions on one chain is either a real mass
+// campaign or a poisoning attempt that slipped past consensus, and either
+// way an operator should hear of it before the filter has been pushed to
+// every subscriber many times over.  Every consensus promotion is counted
+// by chain, and the burn_rate maintenance task compares each chain's
+// promotion rate over the last alerts.burn_rate.short_window with its
+// rate over the long window before it, counting at least one promotion
+// there so a chain that was quiet is not infinitely over.  When the ratio
+// reaches alerts.burn_rate.multiplier with at least
+// alerts.burn_rate.min_promotions in the short window, the chain trips: a
+// high-priority burn_rate event goes to the alert sinks (see alert.go)
+// and aegis_promotion_burn_rate_tripped{chain} is set to 1.
+// aegis_promotion_burn_ratio{chain} has every chain's ratio as of the
+// last check, for alerting in Prometheus itself.
+//
+// A tripped chain stays tripped, and does not alert again, until an
+// admin acknowledges the alert with POST /admin/alerts/{id}/ack.  With
+// alerts.burn_rate.auto_review, promotions on it are meanwhile queued
+// for an analyst as under review.policy "review" (see review.go) rather
+// than added to the filter.  GET /admin/alerts lists the alerts, open
+// ones first.  Alerts are kept in memory only: a restart reopens nothing
+// and forgets the chains under review.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxBurnRateSamples bounds the promotions remembered per chain.
+	maxBurnRateSamples = 100000
+
+	// maxBurnRateAlerts bounds the acknowledged alerts kept for listing.
+	maxBurnRateAlerts = 100
+)
+
+// BurnRateAlert is a chain tripping the burn-rate detector.  Rates are
+// promotions per hour; ShortPromotions are those in the short window.
+type BurnRateAlert struct {
+	ID              string     `json:"id"`
+	ChainID         int        `json:"chain_id"`
+	TrippedAt       time.Time  `json:"tripped_at"`
+	ShortPromotions int        `json:"short_promotions"`
+	ShortRate       float64    `json:"short_rate"`
+	LongRate        float64    `json:"long_rate"`
+	Ratio           float64    `json:"ratio"`
+	Review          bool       `json:"review"` // the chain's promotions are queued for review
+	AckedAt         *time.Time `json:"acked_at,omitempty"`
+	AckedBy         string     `json:"acked_by,omitempty"`
+}
+
+// burnRateTracker counts promotions by chain and holds the alerts.
+type burnRateTracker struct {
+	mu         sync.Mutex
+	promotions map[int][]time.Time // chain -> promotion times, oldest first
+	open       map[int]*BurnRateAlert
+	acked      []*BurnRateAlert // oldest first
+}
+
+func newBurnRateTracker() *burnRateTracker {
+	return &burnRateTracker{promotions: make(map[int][]time.Time), open: make(map[int]*BurnRateAlert)}
+}
+
+// record counts a promotion on a chain.
+func (t *burnRateTracker) record(chainID int, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	times := append(t.promotions[chainID], at)
+	if over := len(times) - maxBurnRateSamples; over > 0 {
+		times = times[over:]
+	}
+	t.promotions[chainID] = times
+}
+
+// reviewing reports whether a chain's promotions go to review.
+func (t *burnRateTracker) reviewing(chainID int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	alert, ok := t.open[chainID]
+	return ok && alert.Review
+}
+
+// burnRatio is one chain's rates as of a check.
+type burnRatio struct {
+	chainID         int
+	ratio           float64
+	shortRt, longRt float64
+}
+
+// check forgets promotions older than the long window and returns every
+// chain's ratio, with the alerts for chains tripping now.
+func (t *burnRateTracker) check(now time.Time, cfg BurnRateConfig) ([]burnRatio, []BurnRateAlert) {
+	short, long := time.Duration(cfg.ShortWindow), time.Duration(cfg.LongWindow)
+	shortFrom, longFrom := now.Add(-short), now.Add(-short-long)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ratios []burnRatio
+	var tripped []BurnRateAlert
+	for chainID, times := range t.promotions {
+		drop := sort.Search(len(times), func(i int) bool { return times[i].After(longFrom) })
+		times = times[drop:]
+		if len(times) == 0 {
+			delete(t.promotions, chainID)
+			continue
+		}
+		t.promotions[chainID] = times
+		recent := len(times) - sort.Search(len(times), func(i int) bool { return times[i].After(shortFrom) })
+		r := burnRatio{
+			chainID: chainID,
+			shortRt: float64(recent) / short.Hours(),
+			longRt:  float64(max(len(times)-recent, 1)) / long.Hours(),
+		}
+		r.ratio = r.shortRt / r.longRt
+		ratios = append(ratios, r)
+		if _, open := t.open[chainID]; open || r.ratio < cfg.Multiplier || recent < cfg.MinPromotions {
+			continue
+		}
+		alert := &BurnRateAlert{
+			ID:              fmt.Sprintf("burn-%d-%d", chainID, now.Unix()),
+			ChainID:         chainID,
+			TrippedAt:       now,
+			ShortPromotions: recent,
+			ShortRate:       r.shortRt,
+			LongRate:        r.longRt,
+			Ratio:           r.ratio,
+			Review:          cfg.AutoReview,
+		}
+		t.open[chainID] = alert
+		tripped = append(tripped, *alert)
+	}
+	return ratios, tripped
+}
+
+// ack acknowledges an alert, reopening its chain's promotions, and
+// returns it.  Acknowledging it again changes nothing.
+func (t *burnRateTracker) ack(id, by string, now time.Time) (BurnRateAlert, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for chainID, alert := range t.open {
+		if alert.ID != id {
+			continue
+		}
+		alert.AckedAt, alert.AckedBy = &now, by
+		delete(t.open, chainID)
+		t.acked = append(t.acked, alert)
+		if over := len(t.acked) - maxBurnRateAlerts; over > 0 {
+			t.acked = t.acked[over:]
+		}
+		return *alert, true
+	}
+	for _, alert := range t.acked {
+		if alert.ID == id {
+			return *alert, true
+		}
+	}
+	return BurnRateAlert{}, false
+}
+
+// has reports whether an alert is known, open or acknowledged.
+func (t *burnRateTracker) has(id string) bool {
+	for _, alert := range t.list() {
+		if alert.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns the open alerts by chain, then the acknowledged ones,
+// newest first.
+func (t *burnRateTracker) list() []BurnRateAlert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]BurnRateAlert, 0, len(t.open)+len(t.acked))
+	for _, alert := range t.open {
+		out = append(out, *alert)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ChainID < out[j].ChainID })
+	for i := len(t.acked) - 1; i >= 0; i-- {
+		out = append(out, *t.acked[i])
+	}
+	return out
+}
+
+// checkBurnRate is the burn_rate maintenance task.
+func (s *SwarmAggregator) checkBurnRate(ctx context.Context) TaskStats {
+	cfg := s.current().Alerts.BurnRate
+	if cfg.Multiplier <= 0 {
+		return TaskStats{} // disabled by a reload
+	}
+	ratios, tripped := s.burnRate.check(s.maintenance.clock.Now(), cfg)
+	s.metrics.burnRatio.Reset()
+	for _, r := range ratios {
+		s.metrics.burnRatio.WithLabelValues(strconv.Itoa(r.chainID)).Set(r.ratio)
+	}
+	for _, alert := range tripped {
+		chain := strconv.Itoa(alert.ChainID)
+		s.metrics.burnRateTripped.WithLabelValues(chain).Set(1)
+		log.Printf("Promotion burn rate on chain %d is %.1fx its baseline (%d promotions in %s); alert %s",
+			alert.ChainID, alert.Ratio, alert.ShortPromotions, time.Duration(cfg.ShortWindow), alert.ID)
+		if alert.Review {
+			s.auditSystem(AuditEvent{Action: AuditBurnRateReview, Subject: alert.ID, Reason: "chain " + chain})
+		}
+		s.alerts.enqueue(PromotionEvent{
+			ChainID:    alert.ChainID,
+			PromotedAt: alert.TrippedAt,
+			Kind:       alertBurnRate,
+			Priority:   alertPriorityHigh,
+			AlertID:    alert.ID,
+			BurnRatio:  alert.Ratio,
+			Promotions: alert.ShortPromotions,
+			Review:     alert.Review,
+		})
+	}
+	return TaskStats{Items: len(ratios)}
+}
+
+// reviews reports whether a report meeting consensus is queued for an
+// analyst rather than promoted: by review policy, or because its chain
+// tripped the burn-rate detector.
+func (s *SwarmAggregator) reviews(report IOCReport) bool {
+	return s.current().Review.policyFor(report.Category) == PolicyReview || s.burnRate.reviewing(report.ChainID)
+}
+
+// handleAdminAlerts is the HTTP handler for GET /admin/alerts.
+func (s *SwarmAggregator) handleAdminAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"alerts": s.burnRate.list()})
+}
+
+// handleAdminAlertAck is the HTTP handler for POST /admin/alerts/{id}/ack.
+func (s *SwarmAggregator) handleAdminAlertAck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id, action, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/alerts/"), "/")
+	if !ok || id == "" || action != "ack" {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found")
+		return
+	}
+	if !s.burnRate.has(id) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "No such alert")
+		return
+	}
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditAlertAck, Subject: id}) {
+		return
+	}
+	alert, _ := s.burnRate.ack(id, auditActor(r), s.clock.Now())
+	s.metrics.burnRateTripped.WithLabelValues(strconv.Itoa(alert.ChainID)).Set(0)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(alert)
+}
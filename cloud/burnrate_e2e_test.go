@@ -0,0 +1,147 @@
+package swarm_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+// newBurnRateAggregator starts a test aggregator alerting on bursts of
+// five promotions at four times a chain's hourly rate.
+func newBurnRateAggregator(t *testing.T, autoReview bool) (*aegistest.Aggregator, *swarm.RecordingSink) {
+	agg := aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.TWAB = swarm.TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+		cfg.Alerts.BurnRate = swarm.BurnRateConfig{
+			ShortWindow:   swarm.Duration(5 * time.Minute),
+			LongWindow:    swarm.Duration(time.Hour),
+			Multiplier:    4,
+			MinPromotions: 5,
+			AutoReview:    autoReview,
+		}
+	}})
+	sink := &swarm.RecordingSink{}
+	agg.AddAlertSink(sink)
+	return agg, sink
+}
+
+// promoteLabel brings the address of a label on a chain to consensus.
+func promoteLabel(agg *aegistest.Aggregator, chainID int, label string) string {
+	addr := aegistest.Address(label)
+	for _, source := range []string{"agent-A", "agent-B"} {
+		agg.IngestReport(context.Background(), swarm.IOCReport{Address: addr, ChainID: chainID, Confidence: 0.9, Timestamp: agg.Clock.Now(), SourceID: source})
+	}
+	return addr
+}
+
+// burnAlerts flushes the alerts and returns the burn-rate ones.
+func burnAlerts(agg *aegistest.Aggregator, sink *swarm.RecordingSink) []swarm.PromotionEvent {
+	agg.FlushAlerts(context.Background())
+	var out []swarm.PromotionEvent
+	for _, e := range sink.Events() {
+		if e.Kind == swarm.AlertBurnRate {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// burnRateTripped is aegis_promotion_burn_rate_tripped for a chain.
+func burnRateTripped(t *testing.T, agg *aegistest.Aggregator, chain string) float64 {
+	return metric(t, agg, `aegis_promotion_burn_rate_tripped{chain="`+chain+`"}`)
+}
+
+func TestBurnRateTripsOnPromotionBurst(t *testing.T) {
+	agg, sink := newBurnRateAggregator(t, false)
+	ctx := context.Background()
+
+	// Steady promotions on chain 1 for an hour set its baseline.
+	for i := 0; i < 6; i++ {
+		promoteLabel(agg, 1, fmt.Sprint("steady-", i))
+		agg.Clock.Advance(10 * time.Minute)
+	}
+	agg.RunMaintenance(ctx)
+	if alerts := burnAlerts(agg, sink); len(alerts) != 0 {
+		t.Fatalf("Expected no alert at the baseline rate, got %+v", alerts)
+	}
+
+	// Four promotions are a burst, but too few to trip.
+	for i := 0; i < 4; i++ {
+		promoteLabel(agg, 137, fmt.Sprint("few-", i))
+	}
+	for i := 0; i < 10; i++ {
+		promoteLabel(agg, 1, fmt.Sprint("burst-", i))
+		agg.Clock.Advance(10 * time.Second)
+	}
+	agg.RunMaintenance(ctx)
+	agg.RunMaintenance(ctx) // still tripped, not alerted again
+	alerts := burnAlerts(agg, sink)
+	if len(alerts) != 1 || alerts[0].ChainID != 1 || alerts[0].Promotions != 10 || alerts[0].BurnRatio < 4 || alerts[0].AlertID == "" {
+		t.Fatalf("Expected one burn-rate alert for chain 1, got %+v", alerts)
+	}
+	if got := burnRateTripped(t, agg, "1"); got != 1 {
+		t.Errorf("Expected chain 1 tripped, got %v", got)
+	}
+	if got := metric(t, agg, `aegis_promotion_burn_ratio{chain="137"}`); got < 4 {
+		t.Errorf("Expected chain 137's ratio set though it did not trip, got %v", got)
+	}
+	if got := burnRateTripped(t, agg, "137"); got != 0 {
+		t.Errorf("Expected chain 137 not tripped, got %v", got)
+	}
+
+	// Without auto_review the chain keeps promoting.
+	addr := promoteLabel(agg, 1, "after")
+	if _, ok := agg.Confirmed(addr); !ok {
+		t.Error("Expected promotions to continue without auto_review")
+	}
+}
+
+func TestBurnRateAutoReviewUntilAcknowledged(t *testing.T) {
+	agg, sink := newBurnRateAggregator(t, true)
+	ctx := context.Background()
+	for i := 0; i < 8; i++ {
+		promoteLabel(agg, 1, fmt.Sprint("burst-", i))
+		agg.Clock.Advance(10 * time.Second)
+	}
+	agg.RunMaintenance(ctx)
+	alerts := burnAlerts(agg, sink)
+	if len(alerts) != 1 || !alerts[0].Review {
+		t.Fatalf("Expected an alert switching chain 1 to review, got %+v", alerts)
+	}
+	id := alerts[0].AlertID
+
+	held := promoteLabel(agg, 1, "held")
+	var review struct{ Items []swarm.ReviewItem }
+	getJSON(t, agg, "/admin/review", &review)
+	if _, ok := agg.Confirmed(held); ok || len(review.Items) != 1 || review.Items[0].Address != held {
+		t.Errorf("Expected chain 1's promotion queued for review, got %+v", review.Items)
+	}
+	if _, ok := agg.Confirmed(promoteLabel(agg, 137, "other")); !ok {
+		t.Error("Expected other chains to keep promoting")
+	}
+
+	_, data := agg.Do(http.MethodGet, "/admin/alerts", "")
+	var list struct{ Alerts []swarm.BurnRateAlert }
+	if err := json.Unmarshal(data, &list); err != nil || len(list.Alerts) != 1 || list.Alerts[0].ID != id || list.Alerts[0].AckedAt != nil {
+		t.Fatalf("Expected the open alert listed, got %s", data)
+	}
+	if status, _ := agg.Do(http.MethodPost, "/admin/alerts/burn-9-0/ack", ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 acknowledging an unknown alert, got %d", status)
+	}
+	status, data := agg.Do(http.MethodPost, "/admin/alerts/"+id+"/ack", "")
+	var acked swarm.BurnRateAlert
+	if err := json.Unmarshal(data, &acked); err != nil || status != http.StatusOK || acked.AckedAt == nil || acked.AckedBy != "aegistest-admin" {
+		t.Fatalf("Expected the alert acknowledged, got %d %s", status, data)
+	}
+	if got := burnRateTripped(t, agg, "1"); got != 0 {
+		t.Errorf("Expected chain 1 cleared, got %v", got)
+	}
+	if _, ok := agg.Confirmed(promoteLabel(agg, 1, "released")); !ok {
+		t.Error("Expected chain 1 promoting again once acknowledged")
+	}
+}
@@ -0,0 +1,184 @@
+// Package swarm — Message-bus ingestion.
+//
+// Large deployments already fan detections through a message bus, so the
+// aggregator can consume reports from a topic instead of POST /ingest.  A
+// BusSource adapts one bus (NATS JetStream today; Kafka fits the same
+// shape) and BusConsumer runs the shared loop: fetch a batch, ingest each
+// message, and only then ack it.  Messages that are not valid reports are
+// forwarded to a dead-letter destination and acked so they are not
+// redelivered forever.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// BusMessage is one message fetched from a bus.
+type BusMessage interface {
+	Data() []byte
+	// Ack commits the message so it will not be redelivered.
+	Ack() error
+}
+
+// BusSource is the bus-specific half of a consumer.
+type BusSource interface {
+	// Name identifies the consumer in logs and metrics.
+	Name() string
+	// Fetch returns up to max messages, waiting at most maxWait.  An empty
+	// result with a nil error means the wait elapsed.
+	Fetch(ctx context.Context, max int, maxWait time.Duration) ([]BusMessage, error)
+	// DeadLetter forwards an unprocessable message with the reason.
+	DeadLetter(ctx context.Context, msg BusMessage, reason string) error
+	// Lag reports how many messages are waiting for this consumer.
+	Lag(ctx context.Context) (uint64, error)
+	// Close releases the connection.
+	Close() error
+}
+
+// Consumer tuning defaults.
+const (
+	defaultBusBatch   = 100
+	defaultBusMaxWait = time.Second
+)
+
+// BusConsumer feeds messages from a BusSource into the aggregator.
+type BusConsumer struct {
+	agg     *SwarmAggregator
+	src     BusSource
+	batch   int
+	maxWait time.Duration
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewBusConsumer creates a consumer; call Start to begin consuming.
+func NewBusConsumer(agg *SwarmAggregator, src BusSource) *BusConsumer {
+	return &BusConsumer{
+		agg:     agg,
+		src:     src,
+		batch:   defaultBusBatch,
+		maxWait: defaultBusMaxWait,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start runs the consume loop in the background until Drain is called or
+// ctx is cancelled.
+func (c *BusConsumer) Start(ctx context.Context) {
+	go c.run(ctx)
+}
+
+// Drain stops fetching, waits for the in-flight batch to be processed and
+// acked, and closes the source.  Unfetched messages stay on the bus.
+func (c *BusConsumer) Drain(ctx context.Context) error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	select {
+	case <-c.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return c.src.Close()
+}
+
+func (c *BusConsumer) run(ctx context.Context) {
+	defer close(c.done)
+	name := c.src.Name()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		msgs, err := c.src.Fetch(ctx, c.batch, c.maxWait)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Bus consumer %s: fetch failed: %v", name, err)
+			select {
+			case <-time.After(c.maxWait):
+			case <-c.stop:
+				return
+			}
+			continue
+		}
+
+		// Once fetched, a batch is always finished, even during a drain, so
+		// nothing is left delivered-but-unacked.
+		for _, msg := range msgs {
+			c.metric(c.process(context.WithoutCancel(ctx), msg))
+		}
+
+		if lag, err := c.src.Lag(ctx); err == nil {
+			c.agg.metrics.busLag.WithLabelValues(name).Set(float64(lag))
+		}
+	}
+}
+
+func (c *BusConsumer) metric(outcome string) {
+	c.agg.metrics.busMessages.WithLabelValues(c.src.Name(), outcome).Inc()
+}
+
+// process ingests one message and acks it, returning the outcome.
+func (c *BusConsumer) process(ctx context.Context, msg BusMessage) string {
+	report, err := decodeBusReport(msg.Data())
+	if err != nil {
+		if dlErr := c.src.DeadLetter(ctx, msg, err.Error()); dlErr != nil {
+			// Leave it unacked so the bus redelivers it later.
+			log.Printf("Bus consumer %s: dead-letter failed: %v", c.src.Name(), dlErr)
+			return busOutcomeAckFailed
+		}
+		if err := msg.Ack(); err != nil {
+			return busOutcomeAckFailed
+		}
+		return busOutcomeDeadLettered
+	}
+
+	outcome := busOutcomeIngested
+	if added, err := c.agg.SubmitReport(ctx, report); err != nil {
+		outcome = busOutcomeRejected // acked: redelivery would only be rejected again
+	} else if added {
+		outcome = busOutcomePromoted
+	}
+	if err := msg.Ack(); err != nil {
+		log.Printf("Bus consumer %s: ack failed: %v", c.src.Name(), err)
+		return busOutcomeAckFailed
+	}
+	return outcome
+}
+
+var errBusMissingAddress = errors.New("report has no address")
+
+// decodeBusReport parses a JSON IOCReport, applying the same defaults and
+// address normalization as POST /ingest.  An invalid address is a bad
+// message, so it is dead-lettered rather than counted as rejected.
+func decodeBusReport(data []byte) (IOCReport, error) {
+	var report IOCReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return IOCReport{}, err
+	}
+	if report.Address == "" {
+		return IOCReport{}, errBusMissingAddress
+	}
+	address, err := NormalizeAddress(report.ChainID, report.Address)
+	if err != nil {
+		return IOCReport{}, err
+	}
+	report.Address = address
+	if report.Timestamp.IsZero() {
+		report.Timestamp = time.Now()
+	}
+	return report, nil
+}
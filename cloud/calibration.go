@@ -0,0 +1,201 @@
+// Package swarm — Source confidence calibration.
+//
+// A source's confidence is only worth what its past reports turned out to
+// be worth: an agent that says 0.95 about addresses the swarm never
+// confirms should not weigh like one whose 0.95s are promoted.  Each
+// source has a calibration curve, its outcomes binned by the confidence
+// it reported, a tenth wide.  An outcome is counted per source for every
+// address it reported, at its best confidence there: promoted when the
+// address reaches consensus, not when its TWAB history is collected idle
+// without ever being promoted (see maintenance.go).
+//
+// Once a source's curve holds calibration.min_outcomes outcomes, every
+// report it sends weighs in TWAB (see TWABConfig.contribution) with the
+// promoted share of its confidence's bin, as smoothed by calibrationPrior
+// pseudo-outcomes at the raw confidence, so a sparse bin stays close to
+// identity.  Until then, for sources past calibration.max_sources, and for
+// namespaced reports, the raw confidence is weighed.  Mean confidence and
+// the confidence thresholds of the TWAB gates are the raw ones.
+//
+// Curves are listed with their source in GET /admin/sources and kept in
+// exported state (see snapshot.go).
+package swarm
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	// calibrationBins is the number of confidence bins in a curve.
+	calibrationBins = 10
+
+	// calibrationPrior is the pseudo-outcomes at the raw confidence each
+	// bin starts from.
+	calibrationPrior = 5
+)
+
+// SourceCalibration is one source's calibration curve: per bin, the
+// outcomes counted and how many were promotions.
+type SourceCalibration struct {
+	SourceID string               `json:"source_id"`
+	Outcomes [calibrationBins]int `json:"outcomes"`
+	Promoted [calibrationBins]int `json:"promoted"`
+}
+
+// CalibrationCurve is a source's curve as GET /admin/sources shows it.
+// Applied is whether its reports are weighed calibrated yet.
+type CalibrationCurve struct {
+	Outcomes int              `json:"outcomes"`
+	Applied  bool             `json:"applied"`
+	Bins     []CalibrationBin `json:"bins"`
+}
+
+// CalibrationBin is one bin of a curve, for confidences in [From, To).
+// Calibrated is what the bin's midpoint maps to.
+type CalibrationBin struct {
+	From       float64 `json:"from"`
+	To         float64 `json:"to"`
+	Outcomes   int     `json:"outcomes"`
+	Promoted   int     `json:"promoted"`
+	Calibrated float64 `json:"calibrated"`
+}
+
+// calibrationBin returns the bin a confidence falls in.
+func calibrationBin(confidence float64) int {
+	return min(max(int(confidence*calibrationBins), 0), calibrationBins-1)
+}
+
+// total returns the outcomes counted in every bin.
+func (c *SourceCalibration) total() int {
+	n := 0
+	for _, outcomes := range c.Outcomes {
+		n += outcomes
+	}
+	return n
+}
+
+// applies reports whether the curve holds enough outcomes to be applied.
+func (c *SourceCalibration) applies(cfg CalibrationConfig) bool {
+	return cfg.MinOutcomes > 0 && c.total() >= cfg.MinOutcomes
+}
+
+// calibrate maps a raw confidence through the curve's bin for it.
+func (c *SourceCalibration) calibrate(raw float64) float64 {
+	bin := calibrationBin(raw)
+	return (float64(c.Promoted[bin]) + calibrationPrior*raw) / (float64(c.Outcomes[bin]) + calibrationPrior)
+}
+
+// curve renders the source's curve for GET /admin/sources.
+func (c *SourceCalibration) curve(cfg CalibrationConfig) CalibrationCurve {
+	out := CalibrationCurve{Outcomes: c.total(), Applied: c.applies(cfg), Bins: make([]CalibrationBin, calibrationBins)}
+	for i := range out.Bins {
+		from, to := float64(i)/calibrationBins, float64(i+1)/calibrationBins
+		out.Bins[i] = CalibrationBin{
+			From:       from,
+			To:         to,
+			Outcomes:   c.Outcomes[i],
+			Promoted:   c.Promoted[i],
+			Calibrated: math.Round(c.calibrate((from+to)/2)*1000) / 1000,
+		}
+	}
+	return out
+}
+
+// valid reports whether an imported curve is consistent.
+func (c *SourceCalibration) valid() bool {
+	for i := range c.Outcomes {
+		if c.Promoted[i] < 0 || c.Promoted[i] > c.Outcomes[i] {
+			return false
+		}
+	}
+	return c.SourceID != ""
+}
+
+// calibrationTracker holds the curve of every calibrated source.
+type calibrationTracker struct {
+	mu      sync.Mutex
+	sources map[string]*SourceCalibration
+}
+
+func newCalibrationTracker() *calibrationTracker {
+	return &calibrationTracker{sources: make(map[string]*SourceCalibration)}
+}
+
+// calibrate returns a source's confidence mapped through its curve, or
+// raw while the curve is too sparse to apply.
+func (t *calibrationTracker) calibrate(source string, raw float64, cfg CalibrationConfig) float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.sources[source]
+	if !ok || !c.applies(cfg) {
+		return raw
+	}
+	return c.calibrate(raw)
+}
+
+// record counts an outcome for each source at its best confidence.  A
+// source seen first once max_sources are tracked is left uncalibrated.
+func (t *calibrationTracker) record(sources map[string]float64, promoted bool, cfg CalibrationConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for source, confidence := range sources {
+		c, ok := t.sources[source]
+		if !ok {
+			if len(t.sources) >= cfg.MaxSources {
+				continue
+			}
+			c = &SourceCalibration{SourceID: source}
+			t.sources[source] = c
+		}
+		bin := calibrationBin(confidence)
+		c.Outcomes[bin]++
+		if promoted {
+			c.Promoted[bin]++
+		}
+	}
+}
+
+// curve returns a source's curve, if it has one.
+func (t *calibrationTracker) curve(source string, cfg CalibrationConfig) (CalibrationCurve, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.sources[source]
+	if !ok {
+		return CalibrationCurve{}, false
+	}
+	return c.curve(cfg), true
+}
+
+// list copies the curves, by source.
+func (t *calibrationTracker) list() []SourceCalibration {
+	t.mu.Lock()
+	out := make([]SourceCalibration, 0, len(t.sources))
+	for _, c := range t.sources {
+		out = append(out, *c)
+	}
+	t.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].SourceID < out[j].SourceID })
+	return out
+}
+
+// restore replaces the curves with imported ones.
+func (t *calibrationTracker) restore(curves []SourceCalibration) {
+	sources := make(map[string]*SourceCalibration, len(curves))
+	for i := range curves {
+		c := curves[i]
+		sources[c.SourceID] = &c
+	}
+	t.mu.Lock()
+	t.sources = sources
+	t.mu.Unlock()
+}
+
+// weight is the confidence a report adds to its source's TWAB weight.
+func (r IOCReport) weight() float64 {
+	if r.Calibrated > 0 {
+		return r.Calibrated
+	}
+	return r.Confidence
+}
@@ -0,0 +1,93 @@
+package swarm_test
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+// newCalibratedAggregator starts a test aggregator calibrating sources
+// from ten outcomes, with addresses idle after an hour.
+func newCalibratedAggregator(t *testing.T) *aegistest.Aggregator {
+	return aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.TWAB = swarm.TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+		cfg.Maintenance.TWABIdleTTL = swarm.Duration(time.Hour)
+		cfg.Calibration.MinOutcomes = 10
+	}})
+}
+
+func TestOverconfidentSourceLosesWeight(t *testing.T) {
+	agg := newCalibratedAggregator(t)
+	ctx := context.Background()
+	report := func(addr, source string) {
+		agg.IngestReport(ctx, swarm.IOCReport{Address: addr, ChainID: 1, Confidence: 0.95, Timestamp: agg.Clock.Now(), SourceID: source})
+	}
+
+	// The honest source's reports are all confirmed; the liar's, alone,
+	// never are and go idle.
+	for i := 0; i < 12; i++ {
+		good := aegistest.Address(fmt.Sprintf("good-%d", i))
+		report(good, "honest")
+		report(good, "peer")
+		bad := aegistest.Address(fmt.Sprintf("bad-%d", i))
+		report(bad, "liar")
+		if i == 0 {
+			if w := agg.SourceWeight(bad, "liar"); w != 0.95 {
+				t.Fatalf("Expected a source without history weighed raw, got %v", w)
+			}
+		}
+	}
+	agg.Advance(2 * time.Hour)
+
+	both := aegistest.Address("both")
+	report(both, "honest")
+	report(both, "liar")
+	report(both, "newcomer")
+	honest, liar, newcomer := agg.SourceWeight(both, "honest"), agg.SourceWeight(both, "liar"), agg.SourceWeight(both, "newcomer")
+	if honest < 0.95 || liar > 0.3 || newcomer != 0.95 {
+		t.Errorf("Expected the liar's weight to drop and the others' kept, got honest %v, liar %v, newcomer %v", honest, liar, newcomer)
+	}
+	want := (0 + swarm.CalibrationPrior*0.95) / (12 + swarm.CalibrationPrior)
+	if math.Abs(liar-want) > 1e-9 {
+		t.Errorf("Expected the liar weighed %v, got %v", want, liar)
+	}
+	if d, _ := twabDetail(t, agg, both); math.Abs(d.MeanConfidence-0.95) > 1e-9 {
+		t.Errorf("Expected the mean confidence kept raw, got %v", d.MeanConfidence)
+	}
+
+	var body swarm.SourcesReport
+	getJSON(t, agg, "/admin/sources", &body)
+	curves := map[string]*swarm.CalibrationCurve{}
+	for _, st := range body.Sources {
+		curves[st.SourceID] = st.Calibration
+	}
+	// Promoting the shared address counted for everyone reporting it.
+	if c := curves["liar"]; c == nil || !c.Applied || c.Outcomes != 13 || c.Bins[9].Outcomes != 13 || c.Bins[9].Promoted != 1 || c.Bins[9].Calibrated > 0.35 {
+		t.Errorf("Unexpected liar curve %+v", c)
+	}
+	if c := curves["honest"]; c == nil || c.Bins[9].Promoted != 13 {
+		t.Errorf("Unexpected honest curve %+v", c)
+	}
+	if c := curves["newcomer"]; c != nil {
+		t.Errorf("Expected no curve for a source without outcomes, got %+v", c)
+	}
+
+	dst := newCalibratedAggregator(t)
+	_, state := agg.Do(http.MethodGet, "/admin/snapshot/export", "")
+	if status, data := dst.Do(http.MethodPost, "/admin/snapshot/import", string(state)); status != http.StatusOK {
+		t.Fatalf("Import failed: %d %s", status, data)
+	}
+	want = (1 + swarm.CalibrationPrior*0.95) / (13 + swarm.CalibrationPrior)
+	if got := dst.Calibrate("liar", 0.95, 10); math.Abs(got-want) > 1e-9 {
+		t.Errorf("Expected the imported curve to calibrate the liar to %v, got %v", want, got)
+	}
+	if got := dst.Calibrate("liar", 0.95, 0); got != 0.95 {
+		t.Errorf("Expected calibration disabled at zero outcomes, got %v", got)
+	}
+}
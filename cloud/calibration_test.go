@@ -0,0 +1,15 @@
+package swarm
+
+import "testing"
+
+func TestImportRejectsInconsistentCalibration(t *testing.T) {
+	var st exportedState
+	bad := SourceCalibration{SourceID: "liar"}
+	bad.Outcomes[3], bad.Promoted[3] = 1, 2
+	if err := st.add(StateRecord{Kind: stateCalibration, Calibration: &bad}); err == nil {
+		t.Error("Expected more promotions than outcomes rejected")
+	}
+	if err := st.add(StateRecord{Kind: stateCalibration, Calibration: &SourceCalibration{}}); err == nil {
+		t.Error("Expected a curve without a source rejected")
+	}
+}
@@ -0,0 +1,138 @@
+// Package swarm — Chain registry.
+//
+// Reports carry a numeric chain ID, and a typo such as 10 for 100 would
+// otherwise start a consensus track of its own.  wellKnownChains names
+// the mainnets, major L2s, and alt-L1s in use; chains in the config
+// declares more, such as private or test chains, and may rename a
+// well-known one.  Both are re-read on reload.
+//
+// A report for a chain in neither is rejected as unknown_chain, unless
+// allow_unknown_chains is set (the default), when it is accepted and its
+// chain is named "unknown" in responses.  Either way it is counted in
+// aegis_unknown_chain_reports_total.  Chain ID 0, no chain declared, is
+// always accepted and has no name.  GET /check, GET /stats, and the
+// summary of each push name the chains they list, and GET /health lists
+// the registry.
+package swarm
+
+import (
+	"fmt"
+	"sort"
+)
+
+// unknownChainName names a chain outside the registry in responses.
+const unknownChainName = "unknown"
+
+// Outcomes recorded in aegis_unknown_chain_reports_total.
+const (
+	chainOutcomeAccepted = "accepted"
+	chainOutcomeRejected = "rejected"
+)
+
+// wellKnownChains maps chain IDs to display names.
+var wellKnownChains = map[int]string{
+	1:            "Ethereum",
+	10:           "OP Mainnet",
+	25:           "Cronos",
+	56:           "BNB Smart Chain",
+	100:          "Gnosis",
+	137:          "Polygon",
+	250:          "Fantom",
+	324:          "zkSync Era",
+	1101:         "Polygon zkEVM",
+	5000:         "Mantle",
+	8453:         "Base",
+	17000:        "Holesky",
+	42161:        "Arbitrum One",
+	42170:        "Arbitrum Nova",
+	42220:        "Celo",
+	43114:        "Avalanche C-Chain",
+	59144:        "Linea",
+	81457:        "Blast",
+	534352:       "Scroll",
+	7777777:      "Zora",
+	11155111:     "Sepolia",
+	ChainTron:    "Tron",
+	ChainBitcoin: "Bitcoin",
+	ChainSolana:  "Solana",
+}
+
+// ChainError reports a report for a chain outside the registry.
+type ChainError struct {
+	ChainID int
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("unknown chain %d; declare it under chains in the config", e.ChainID)
+}
+
+// ChainInfo is a registered chain, as listed by GET /health.
+type ChainInfo struct {
+	ChainID int    `json:"chain_id"`
+	Name    string `json:"chain_name"`
+}
+
+// chainName returns the name of a registered chain.
+func (c *Config) chainName(chainID int) (string, bool) {
+	if name, ok := c.Chains[chainID]; ok {
+		return name, true
+	}
+	name, ok := wellKnownChains[chainID]
+	return name, ok
+}
+
+// displayChainName is the chain_name of a chain in responses: empty for
+// chain ID 0, unknownChainName outside the registry.
+func (c *Config) displayChainName(chainID int) string {
+	if chainID == 0 {
+		return ""
+	}
+	if name, ok := c.chainName(chainID); ok {
+		return name
+	}
+	return unknownChainName
+}
+
+// chainNames names each of the chains listed in counts.
+func (c *Config) chainNames(counts map[int]ChangeCounts) map[int]string {
+	names := make(map[int]string, len(counts))
+	for id := range counts {
+		if name := c.displayChainName(id); name != "" {
+			names[id] = name
+		}
+	}
+	return names
+}
+
+// registeredChains lists the registry by chain ID.
+func (c *Config) registeredChains() []ChainInfo {
+	chains := make([]ChainInfo, 0, len(wellKnownChains)+len(c.Chains))
+	for id, name := range wellKnownChains {
+		if _, declared := c.Chains[id]; !declared {
+			chains = append(chains, ChainInfo{ChainID: id, Name: name})
+		}
+	}
+	for id, name := range c.Chains {
+		chains = append(chains, ChainInfo{ChainID: id, Name: name})
+	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i].ChainID < chains[j].ChainID })
+	return chains
+}
+
+// checkChain rejects a report for an unknown chain unless
+// allow_unknown_chains is set, counting those it lets through.
+func (s *SwarmAggregator) checkChain(report IOCReport) error {
+	if report.ChainID == 0 {
+		return nil
+	}
+	cfg := s.current()
+	if _, ok := cfg.chainName(report.ChainID); ok {
+		return nil
+	}
+	if !cfg.AllowUnknownChains {
+		s.metrics.unknownChainReports.WithLabelValues(chainOutcomeRejected).Inc()
+		return &ChainError{ChainID: report.ChainID}
+	}
+	s.metrics.unknownChainReports.WithLabelValues(chainOutcomeAccepted).Inc()
+	return nil
+}
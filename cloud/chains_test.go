@@ -0,0 +1,131 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func getJSON(t *testing.T, agg *SwarmAggregator, path string, v interface{}) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET %s: %d %s", path, rec.Code, rec.Body)
+	}
+	json.NewDecoder(rec.Body).Decode(v)
+}
+
+func TestUnknownChainRejected(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AllowUnknownChains = false
+	cfg.Chains = map[int]string{31337: "Anvil"}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+
+	var body ErrorResponse
+	rec := postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1000,"confidence":0.9,"source_id":"agent-A"}`, evmAddress("typo")))
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusUnprocessableEntity || body.Error.Code != CodeUnknownChain {
+		t.Fatalf("Expected 422 %s, got %d %+v", CodeUnknownChain, rec.Code, body)
+	}
+	if got := testutil.ToFloat64(agg.metrics.unknownChainReports.WithLabelValues(chainOutcomeRejected)); got != 1 {
+		t.Errorf("Expected the rejection counted, got %v", got)
+	}
+
+	for _, chainID := range []int{0, 100, 31337} {
+		report := IOCReport{Address: evmAddress("known"), ChainID: chainID, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"}
+		if _, err := agg.SubmitReport(context.Background(), report); err != nil {
+			t.Errorf("Chain %d: expected the report accepted, got %v", chainID, err)
+		}
+	}
+}
+
+func TestUnknownChainAcceptedAndTagged(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	ctx := context.Background()
+	for _, chainID := range []int{1000, 10} {
+		report := IOCReport{Address: evmAddress(fmt.Sprint(chainID)), ChainID: chainID, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"}
+		if _, err := agg.SubmitReport(ctx, report); err != nil {
+			t.Fatalf("Chain %d: expected the report accepted, got %v", chainID, err)
+		}
+	}
+	if got := testutil.ToFloat64(agg.metrics.unknownChainReports.WithLabelValues(chainOutcomeAccepted)); got != 1 {
+		t.Errorf("Expected one unknown-chain report counted, got %v", got)
+	}
+
+	var check checkChainBody
+	getJSON(t, agg, "/check?address="+evmAddress("1000"), &check)
+	if check.ChainID != 1000 || check.ChainName != unknownChainName {
+		t.Errorf("Expected the unknown chain tagged in /check, got %+v", check)
+	}
+	getJSON(t, agg, "/check?chain_id=10&address="+evmAddress("unseen"), &check)
+	if check.ChainID != 10 || check.ChainName != "OP Mainnet" {
+		t.Errorf("Expected the queried chain named in /check, got %+v", check)
+	}
+
+	var stats ConsensusStats
+	getJSON(t, agg, "/stats", &stats)
+	names := map[int]string{}
+	for _, c := range stats.TopChains {
+		names[c.ChainID] = c.ChainName
+	}
+	if names[10] != "OP Mainnet" || names[1000] != unknownChainName {
+		t.Errorf("Expected chains named in /stats, got %+v", stats.TopChains)
+	}
+}
+
+// checkChainBody is the part of /check these tests read.
+type checkChainBody struct {
+	ChainID   int    `json:"chain_id"`
+	ChainName string `json:"chain_name"`
+}
+
+func TestChainRegistryConfigAndReload(t *testing.T) {
+	agg, path := newReloadableAggregator(t, `
+allow_unknown_chains: false
+chains:
+  31337: Anvil
+`)
+	var health struct {
+		Chains             []ChainInfo `json:"chains"`
+		AllowUnknownChains bool        `json:"allow_unknown_chains"`
+	}
+	getJSON(t, agg, "/health", &health)
+	declared := false
+	for _, c := range health.Chains {
+		declared = declared || c == ChainInfo{ChainID: 31337, Name: "Anvil"}
+	}
+	if !declared || health.AllowUnknownChains || len(health.Chains) != len(wellKnownChains)+1 {
+		t.Errorf("Expected the declared chain listed in /health, got %+v", health)
+	}
+
+	ch := agg.Subscribe("summary")
+	defer agg.Unsubscribe("summary")
+	agg.Block(context.Background(), AdminAction{Address: evmAddress("anvil"), ChainID: 31337})
+	if env := recvEnvelope(t, ch); env.Summary == nil || env.Summary.ChainNames[31337] != "Anvil" {
+		t.Errorf("Expected the push summary to name the chain, got %+v", env.Summary)
+	}
+
+	report := IOCReport{Address: evmAddress("devnet"), ChainID: 1337, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"}
+	if _, err := agg.SubmitReport(context.Background(), report); err == nil {
+		t.Fatal("Expected an undeclared chain rejected")
+	}
+	writeConfigFile(t, path, `
+allow_unknown_chains: false
+chains:
+  31337: Anvil
+  1337: Devnet
+`)
+	if _, err := agg.Reload(context.Background(), auditActorSystem); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := agg.SubmitReport(context.Background(), report); err != nil {
+		t.Errorf("Expected the chain declared by reload accepted, got %v", err)
+	}
+}
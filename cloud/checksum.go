@@ -0,0 +1,35 @@
+// Package swarm — Payload integrity checksums.
+//
+// Proxies have been seen truncating or mangling large WebSocket messages,
+// and a client without trusted keys would apply what arrived.  Every
+// signed envelope therefore carries payload_crc32c, the CRC-32C of its
+// payload bytes in hex, computed once when the payload is serialized;
+// GET /filter sends the same value in X-Aegis-Payload-CRC32C.  It is
+// independent of the signature and cheap enough for every client to
+// check: the SDK drops a push that fails it and fetches a fresh snapshot
+// instead.
+//
+// State streams (see snapshot.go) end with a checksum record over every
+// byte before it, so a damaged persistence.state_file is refused at
+// startup rather than loaded in part.
+package swarm
+
+import (
+	"encoding/hex"
+	"hash/crc32"
+)
+
+// headerPayloadChecksum carries the checksum of a GET /filter payload.
+const headerPayloadChecksum = "X-Aegis-Payload-CRC32C"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// payloadChecksum returns the hex CRC-32C of data.
+func payloadChecksum(data []byte) string {
+	return checksumHex(crc32.Checksum(data, castagnoli))
+}
+
+func checksumHex(sum uint32) string {
+	b := [4]byte{byte(sum >> 24), byte(sum >> 16), byte(sum >> 8), byte(sum)}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,76 @@
+package swarm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPayloadChecksumOnPushesAndFilter(t *testing.T) {
+	agg := NewSwarmAggregator()
+	ch := agg.Subscribe("checksum")
+	defer agg.Unsubscribe("checksum")
+	agg.Block(context.Background(), AdminAction{Address: evmAddress("checked"), ChainID: 1})
+
+	env := recvEnvelope(t, ch)
+	if env.PayloadChecksum == "" || env.PayloadChecksum != payloadChecksum(env.Payload) {
+		t.Errorf("Expected the push to carry its payload checksum, got %q", env.PayloadChecksum)
+	}
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/filter", nil))
+	if got := rec.Header().Get(headerPayloadChecksum); got != payloadChecksum(rec.Body.Bytes()) {
+		t.Errorf("Expected %s to match the body, got %q", headerPayloadChecksum, got)
+	}
+}
+
+func TestStateFileChecksum(t *testing.T) {
+	src := newSnapshotAggregator()
+	src.Block(context.Background(), AdminAction{Address: evmAddress("saved"), ChainID: 1})
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	if err := src.SaveStateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, _ := os.ReadFile(path)
+
+	dst := newSnapshotAggregator()
+	if n, err := dst.LoadStateFile(context.Background(), path); err != nil || n != 1 || !dst.bloomFilter.Contains(evmAddress("saved")) {
+		t.Fatalf("Expected the saved state loaded, got %d %v", n, err)
+	}
+
+	corrupt := strings.Replace(string(saved), `"chain_id":1`, `"chain_id":7`, 1)
+	os.WriteFile(path, []byte(corrupt), 0o600)
+	fresh := newSnapshotAggregator()
+	_, err := fresh.LoadStateFile(context.Background(), path)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("Expected a checksum mismatch, got %v", err)
+	}
+	if fresh.BloomFilterLen() != 0 {
+		t.Error("Expected nothing loaded from a corrupted file")
+	}
+	if _, err := os.Stat(path + ".corrupt"); err != nil {
+		t.Errorf("Expected the corrupted file moved aside: %v", err)
+	}
+	if n, err := fresh.LoadStateFile(context.Background(), path); err != nil || n != 0 {
+		t.Errorf("Expected a missing file to load nothing, got %d %v", n, err)
+	}
+}
+
+func TestStateStreamVersion1WithoutChecksum(t *testing.T) {
+	src := newSnapshotAggregator()
+	src.Block(context.Background(), AdminAction{Address: evmAddress("legacy"), ChainID: 1})
+	lines := strings.Split(strings.TrimSpace(exportState(t, src)), "\n")
+	legacy := strings.Replace(strings.Join(lines[:len(lines)-1], "\n"), `"version":2`, `"version":1`, 1)
+
+	dst := newSnapshotAggregator()
+	if rec := adminRequest(t, dst, "admin-secret", http.MethodPost, "/admin/snapshot/import", legacy); rec.Code != http.StatusOK {
+		t.Fatalf("Expected a version 1 stream imported, got %d %s", rec.Code, rec.Body)
+	}
+	if !dst.bloomFilter.Contains(evmAddress("legacy")) {
+		t.Error("Expected the legacy stream's entry imported")
+	}
+}
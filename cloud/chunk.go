@@ -0,0 +1,123 @@
+// Package swarm — Chunked WebSocket delivery of large messages.
+//
+// A serialized envelope larger than push.chunk_size is sent as a run of
+// chunk envelopes instead: kind "chunk", with chunk_index and chunk_count,
+// the SHA-256 of the whole message as checksum, and a slice of its bytes.
+// The client concatenates the chunks, checks the checksum, and handles
+// the result as the envelope it is.  Chunks are produced once per push,
+// when it is encoded, and shared by every subscriber.
+//
+// Subscriber channels still carry whole messages, so a slow subscriber
+// drops pushes at whole-payload granularity, and only the connection's
+// writer splits them.  It writes one message's chunks in order before
+// taking the next, so chunks of two messages are never intermixed.  If a
+// newer push is queued while a live push is still being written, the rest
+// of the older one is abandoned and the newer one starts at chunk zero;
+// live pushes are full snapshots, so nothing is lost.  Clients discard a
+// partial run when a chunk breaks it.  Messages sent on connect, which may
+// be resume deltas, are always written whole, and so is every message to
+// a msgpack subscription (see encoding.go), as one binary frame.
+package swarm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// chunkCacheSize is the number of recently chunked messages kept: enough
+// for every format of a global push and a few namespace pushes.
+const chunkCacheSize = 8
+
+type chunkedMessage struct {
+	data   []byte
+	frames [][]byte
+}
+
+// chunker splits messages above size into encoded chunk envelopes,
+// remembering the most recent so subscribers share them.
+type chunker struct {
+	size int // zero disables chunking
+
+	mu     sync.Mutex
+	recent [chunkCacheSize]chunkedMessage
+	next   int
+}
+
+func newChunker(size int) *chunker {
+	return &chunker{size: size}
+}
+
+// sameMessage reports whether a and b are the same slice.  Every
+// subscriber of a push is handed the same slice, so identity suffices.
+func sameMessage(a, b []byte) bool {
+	return len(a) == len(b) && len(a) > 0 && &a[0] == &b[0]
+}
+
+// frames returns the chunk envelopes for data, or nil if it is sent whole.
+func (c *chunker) frames(data []byte) [][]byte {
+	if c.size <= 0 || len(data) <= c.size {
+		return nil
+	}
+	c.mu.Lock()
+	for _, m := range c.recent {
+		if sameMessage(m.data, data) {
+			c.mu.Unlock()
+			return m.frames
+		}
+	}
+	c.mu.Unlock()
+
+	frames := splitMessage(data, c.size)
+	c.mu.Lock()
+	c.recent[c.next] = chunkedMessage{data: data, frames: frames}
+	c.next = (c.next + 1) % chunkCacheSize
+	c.mu.Unlock()
+	return frames
+}
+
+// splitMessage encodes data as chunk envelopes of at most size bytes each.
+func splitMessage(data []byte, size int) [][]byte {
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	count := (len(data) + size - 1) / size
+	frames := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := min((i+1)*size, len(data))
+		frame, err := json.Marshal(FilterEnvelope{
+			Kind:       envelopeChunk,
+			ChunkIndex: i,
+			ChunkCount: count,
+			Checksum:   checksum,
+			Chunk:      data[i*size : end],
+		})
+		if err != nil {
+			panic(err) // a FilterEnvelope always marshals
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// wsSend writes one message, chunked if it is large, reporting whether
+// the connection is usable.  superseded, if set, is checked between
+// chunks; once it reports true the remaining chunks are skipped.
+func (s *SwarmAggregator) wsSend(conn *websocket.Conn, data []byte, superseded func() bool) bool {
+	frames := s.chunks.frames(data)
+	if frames == nil {
+		return wsWrite(conn, data)
+	}
+	for i, frame := range frames {
+		if i > 0 && superseded != nil && superseded() {
+			s.metrics.chunksSuperseded.Inc()
+			return true
+		}
+		if !wsWrite(conn, frame) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,159 @@
+package swarm
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/client"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSplitMessageReassembles(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 25))
+	frames := splitMessage(data, 64)
+	if len(frames) != 4 {
+		t.Fatalf("Expected 250 bytes in 4 chunks of 64, got %d", len(frames))
+	}
+	var a client.Assembler
+	for i, frame := range frames {
+		got, err := a.Add(frame)
+		if err != nil || (i < len(frames)-1) != (got == nil) {
+			t.Fatalf("Chunk %d: got %q (%v)", i, got, err)
+		}
+		if got != nil && !bytes.Equal(got, data) {
+			t.Errorf("Reassembled %q", got)
+		}
+	}
+
+	c := newChunker(64)
+	if c.frames(data[:64]) != nil {
+		t.Error("Expected a message of exactly chunk_size sent whole")
+	}
+	first := c.frames(data)
+	if again := c.frames(data); &again[0] != &first[0] {
+		t.Error("Expected the chunks of one push shared between subscribers")
+	}
+	if newChunker(0).frames(data) != nil {
+		t.Error("Expected chunk_size 0 to disable chunking")
+	}
+}
+
+func TestWatchReassemblesChunkedPushes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Push.ChunkSize = 256
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	for i := 0; i < 20; i++ {
+		blockAll(agg, evmAddress(fmt.Sprintf("seed-%d", i)))
+	}
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	c, err := client.New(client.Config{
+		BaseURL:     srv.URL,
+		APIKey:      testSubscriberSecret,
+		MinBackoff:  10 * time.Millisecond,
+		TrustedKeys: []ed25519.PublicKey{agg.signer.Active().Public()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	next := func() client.FilterUpdate {
+		select {
+		case u := <-updates:
+			return u
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for an update")
+			return client.FilterUpdate{}
+		}
+	}
+	if u := next(); u.Count != 20 {
+		t.Fatalf("Expected the 20-entry snapshot, got %+v", u)
+	}
+	// Wait for the subscription before pushing.
+	deadline := time.Now().Add(2 * time.Second)
+	for len(agg.ListSubscribers()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	live := evmAddress("live")
+	blockAll(agg, live)
+	if u := next(); u.Count != 21 || !c.Contains(live) {
+		t.Errorf("Expected the chunked push of 21 entries applied, got %+v", u)
+	}
+
+	// A raw subscriber sees the same snapshot as chunks.
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	var first FilterEnvelope
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&first); err != nil || first.Kind != envelopeChunk || first.ChunkIndex != 0 || first.ChunkCount < 2 {
+		t.Fatalf("Expected the first of several chunks, got %+v (%v)", first, err)
+	}
+	conn.Close()
+}
+
+func TestNewerPushSupersedesPartlySentOne(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Push.ChunkSize = 16
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	older := []byte(`{"kind":"snapshot","version":1,"payload":"` + strings.Repeat("a", 80) + `"}`)
+	newer := []byte(`{"kind":"snapshot","version":2,"payload":"` + strings.Repeat("b", 80) + `"}`)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := wsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sent := 0
+		agg.wsSend(conn, older, func() bool { sent++; return sent > 2 })
+		agg.wsSend(conn, newer, nil)
+		conn.ReadMessage() // hold the connection until the client is done
+	}))
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var a client.Assembler
+	var got [][]byte
+	for len(got) == 0 {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		msg, err := a.Add(data)
+		if err != nil {
+			t.Fatalf("Unexpected reassembly error: %v", err)
+		}
+		if msg != nil {
+			got = append(got, msg)
+		}
+	}
+	if !bytes.Equal(got[0], newer) {
+		t.Errorf("Expected only the newer version delivered, got %q", got[0])
+	}
+	if n := testutil.ToFloat64(agg.metrics.chunksSuperseded); n != 1 {
+		t.Errorf("Expected push_chunks_superseded_total 1, got %v", n)
+	}
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+)
+
+// ErrChunkChecksum is returned when chunks reassemble into a message that
+// does not match their checksum.
+var ErrChunkChecksum = errors.New("aegis: reassembled chunks do not match their checksum")
+
+// Assembler reassembles the chunk envelopes the aggregator splits large
+// messages into.  Feed it every message received on /ws, in order.  A run
+// of chunks is abandoned when a chunk does not follow on from it, as
+// happens when the aggregator supersedes a partly sent push with a newer
+// one.  The zero value is ready to use.
+type Assembler struct {
+	checksum string
+	count    int
+	parts    [][]byte
+}
+
+// Add takes the next message and returns the complete envelope it
+// finishes: the message itself if it is not a chunk, the reassembled
+// envelope if it is the last chunk of a run, and nil otherwise.
+func (a *Assembler) Add(msg []byte) ([]byte, error) {
+	var env struct {
+		Kind       string `json:"kind"`
+		ChunkIndex int    `json:"chunk_index"`
+		ChunkCount int    `json:"chunk_count"`
+		Checksum   string `json:"checksum"`
+		Chunk      []byte `json:"chunk"`
+	}
+	if err := json.Unmarshal(msg, &env); err != nil {
+		return nil, err
+	}
+	if env.Kind != KindChunk {
+		a.reset()
+		return msg, nil
+	}
+
+	if env.ChunkIndex == 0 {
+		a.reset()
+		a.checksum, a.count = env.Checksum, env.ChunkCount
+	} else if env.Checksum != a.checksum || env.ChunkIndex != len(a.parts) || a.count == 0 {
+		a.reset()
+		return nil, nil
+	}
+	a.parts = append(a.parts, env.Chunk)
+	if len(a.parts) < a.count {
+		return nil, nil
+	}
+
+	whole := bytes.Join(a.parts, nil)
+	checksum := a.checksum
+	a.reset()
+	sum := sha256.Sum256(whole)
+	if hex.EncodeToString(sum[:]) != checksum {
+		return nil, ErrChunkChecksum
+	}
+	return whole, nil
+}
+
+func (a *Assembler) reset() {
+	a.checksum, a.count, a.parts = "", 0, nil
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// splitForTest chunks msg the way the aggregator does.
+func splitForTest(t *testing.T, msg []byte, size int) [][]byte {
+	t.Helper()
+	sum := sha256.Sum256(msg)
+	count := (len(msg) + size - 1) / size
+	var frames [][]byte
+	for i := 0; i < count; i++ {
+		end := min((i+1)*size, len(msg))
+		frame, err := json.Marshal(FilterEnvelope{Kind: KindChunk, ChunkIndex: i, ChunkCount: count, Checksum: hex.EncodeToString(sum[:]), Chunk: msg[i*size : end]})
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+func TestAssemblerReassemblesChunks(t *testing.T) {
+	msg := []byte(`{"kind":"snapshot","version":7,"payload":{"entries":["` + strings.Repeat("0xab", 100) + `"]}}`)
+	frames := splitForTest(t, msg, 64)
+	var a Assembler
+	for i, frame := range frames {
+		got, err := a.Add(frame)
+		if err != nil {
+			t.Fatalf("Chunk %d: unexpected error %v", i, err)
+		}
+		if last := i == len(frames)-1; last != (got != nil) {
+			t.Fatalf("Chunk %d of %d: got message %v", i, len(frames), got != nil)
+		}
+		if got != nil && !bytes.Equal(got, msg) {
+			t.Errorf("Reassembled %q, want %q", got, msg)
+		}
+	}
+
+	plain := []byte(`{"kind":"snapshot","version":8}`)
+	if got, err := a.Add(plain); err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("Expected an unchunked envelope passed through, got %q (%v)", got, err)
+	}
+}
+
+func TestAssemblerRejectsChecksumMismatch(t *testing.T) {
+	frames := splitForTest(t, []byte(`{"kind":"snapshot","version":1,"payload":"original"}`), 16)
+	var last FilterEnvelope
+	json.Unmarshal(frames[len(frames)-1], &last)
+	last.Chunk = bytes.ToUpper(last.Chunk)
+	frames[len(frames)-1], _ = json.Marshal(last)
+
+	var a Assembler
+	var err error
+	for _, frame := range frames {
+		var got []byte
+		if got, err = a.Add(frame); got != nil {
+			t.Fatalf("Expected no message from tampered chunks, got %q", got)
+		}
+	}
+	if !errors.Is(err, ErrChunkChecksum) {
+		t.Errorf("Expected ErrChunkChecksum, got %v", err)
+	}
+}
+
+func TestAssemblerDropsSupersededRun(t *testing.T) {
+	older := splitForTest(t, []byte(`{"kind":"snapshot","version":1,"payload":"`+strings.Repeat("a", 60)+`"}`), 16)
+	newer := []byte(`{"kind":"snapshot","version":2,"payload":"` + strings.Repeat("b", 60) + `"}`)
+
+	var a Assembler
+	for _, frame := range older[:2] {
+		if got, err := a.Add(frame); got != nil || err != nil {
+			t.Fatalf("Expected a partial run, got %q (%v)", got, err)
+		}
+	}
+	var got []byte
+	for _, frame := range splitForTest(t, newer, 16) {
+		var err error
+		if got, err = a.Add(frame); err != nil {
+			t.Fatalf("Unexpected error %v", err)
+		}
+	}
+	if !bytes.Equal(got, newer) {
+		t.Fatalf("Expected the newer version reassembled, got %q", got)
+	}
+
+	// A stray chunk of the abandoned run must not resurrect it.
+	if got, err := a.Add(older[2]); got != nil || err != nil {
+		t.Errorf("Expected the stray chunk ignored, got %q (%v)", got, err)
+	}
+}
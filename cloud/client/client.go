@@ -0,0 +1,480 @@
+// Package client is the Go SDK for the Aegis Swarm Aggregator.
+//
+// A Client submits IOC reports, performs remote checks, and — through
+// Watch — keeps a local copy of the consensus filter in sync with the
+// aggregator's WebSocket pushes, so Contains is an O(1) in-memory lookup
+// between updates.  A CompositeFilter layers the caller's own blocklists
+// and allowlists over that filter.  Events streams the aggregator's
+// promotion events for forwarding to a SIEM.  Failed calls match the
+// sentinels of errors.go with errors.Is.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IOCReport is an anonymous Indicator of Compromise report.
+type IOCReport struct {
+	Address    string    `json:"address"`
+	Selector   string    `json:"selector,omitempty"`
+	ChainID    int       `json:"chain_id"`
+	Category   string    `json:"category,omitempty"`
+	Confidence float64   `json:"confidence"`
+	Timestamp  time.Time `json:"timestamp"`
+	SourceID   string    `json:"source_id"`
+
+	// ReportID, if set, makes resubmitting the report idempotent: the
+	// aggregator answers a retry with the original result.
+	ReportID string `json:"report_id,omitempty"`
+
+	// Evidence the report cites; at most eight items.
+	Evidence []Evidence `json:"evidence,omitempty"`
+
+	// IndicatorType is what Address holds: "domain", "url" or
+	// "bytecode_hash", or an on-chain address when empty.
+	IndicatorType string `json:"indicator_type,omitempty"`
+
+	// Severity rates what was seen from 1, a suspicious pattern, to 5, an
+	// active drainer; zero leaves it unrated.  The aggregator may promote
+	// severe reports on fewer sources.
+	Severity int `json:"severity,omitempty"`
+}
+
+// Evidence is an item a report cites: Type is "tx_hash" (0x followed by
+// 64 hex digits), "url", or "signature_request".  ChainID defaults to the
+// report's.
+type Evidence struct {
+	Type    string `json:"type"`
+	Value   string `json:"value"`
+	ChainID int    `json:"chain_id,omitempty"`
+}
+
+// Report statuses.  A recorded report counted towards consensus; a
+// duplicate repeated the report ID of one already recorded, and carries
+// that report's result; a rejected one was refused.  Only batch results
+// are rejected: a single rejected report is an *HTTPError.
+const (
+	StatusRecorded  = "recorded"
+	StatusDuplicate = "duplicate"
+	StatusRejected  = "rejected"
+)
+
+// Reason codes of recorded and duplicate reports.  A rejected report's
+// reason code is the error code the aggregator refuses such a report
+// with, e.g. "invalid_address" or "source_banned".
+const (
+	ReasonInFilter       = "in_filter"       // the address is in the filter
+	ReasonBelowThreshold = "below_threshold" // the address has not reached consensus
+	ReasonWithheld       = "withheld"        // consensus reached, but held back from the filter
+	ReasonQueued         = "queued"          // queued; the outcome is not yet known
+	ReasonReplayed       = "replayed"        // a duplicate of an earlier report
+)
+
+// ReportResult is the aggregator's response to one report.
+type ReportResult struct {
+	Status         string  `json:"status"`
+	ReasonCode     string  `json:"reason_code"`
+	Message        string  `json:"message,omitempty"` // rejected reports only
+	AddedToFilter  bool    `json:"added_to_filter"`
+	ConsensusScore float64 `json:"consensus_score"`
+	FilterVersion  uint64  `json:"filter_version"`
+
+	// IngestID is set when the aggregator queued the report; the outcome
+	// is then not yet known and AddedToFilter is false.
+	IngestID string `json:"ingest_id,omitempty"`
+
+	// Deprecated: Accepted is true unless Status is StatusRejected.
+	Accepted bool `json:"accepted"`
+}
+
+// fillStatus derives Status from Accepted for aggregators that predate
+// it.
+func (r *ReportResult) fillStatus() {
+	switch {
+	case r.Status != "":
+	case r.Accepted:
+		r.Status = StatusRecorded
+	default:
+		r.Status = StatusRejected
+	}
+}
+
+// CheckResult is the aggregator's response to a remote check.
+type CheckResult struct {
+	Address       string          `json:"address"` // the aggregator's key, e.g. "domain:example.com"
+	IndicatorType string          `json:"indicator_type,omitempty"`
+	Flagged       bool            `json:"flagged"`
+	FilterVersion uint64          `json:"filter_version"`
+	Provenance    json.RawMessage `json:"provenance,omitempty"`
+
+	// ChainID and ChainName are the chain of the confirmed entry, or the
+	// one asked about; ChainName is "unknown" for a chain the aggregator
+	// does not know.
+	ChainID   int    `json:"chain_id,omitempty"`
+	ChainName string `json:"chain_name,omitempty"`
+
+	// ConsensusScore is the address's consensus score in [0, 1], for
+	// callers applying their own thresholds, e.g. warn at 0.5.
+	ConsensusScore float64 `json:"consensus_score"`
+}
+
+// Config configures a Client.
+type Config struct {
+	// BaseURL is the aggregator root, e.g. "https://swarm.example.com".
+	BaseURL string
+
+	// APIKey is sent as a bearer token when non-empty.
+	APIKey string
+
+	// HTTPClient overrides the default client (30s timeout).
+	HTTPClient *http.Client
+
+	// MinBackoff and MaxBackoff bound the Watch reconnect delay.
+	// Defaults: 500ms and 30s.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// TrustedKeys, when non-empty, makes the client verify every filter
+	// snapshot and push against these aggregator signing keys and discard
+	// anything that does not verify.  Include the previous key while the
+	// aggregator rotates.
+	TrustedKeys []ed25519.PublicKey
+
+	// Tier selects the filter Watch and Snapshot fetch: "main" (the
+	// default), "staging", or "both" for canary clients of an aggregator
+	// with staging enabled.
+	Tier string
+
+	// Deltas is how resume deltas are applied: "exact" (the default)
+	// applies their additions and removals to the local set; "bloom" is
+	// for callers mirroring updates into a structure that cannot delete,
+	// such as a Bloom filter of their own.  With "bloom" a delta that
+	// removes anything is replaced by a full snapshot, reported as a
+	// Resync, so FilterUpdate.Removed is never set.
+	Deltas string
+}
+
+// Delta modes for Config.Deltas.
+const (
+	DeltasExact = "exact"
+	DeltasBloom = "bloom"
+)
+
+// Client talks to one aggregator.  It is safe for concurrent use.
+type Client struct {
+	base   *url.URL
+	apiKey string
+	http   *http.Client
+	minBO  time.Duration
+	maxBO  time.Duration
+	tier   string
+	deltas string
+
+	trusted map[string]ed25519.PublicKey
+
+	mu       sync.RWMutex
+	version  uint64
+	instance string // aggregator instance that numbered version, if any
+	epoch    uint64 // aggregator epoch version is from, zero if unknown
+	params   BloomParams
+	entries  map[string]struct{}
+	synced   bool
+}
+
+// New creates a Client from cfg.
+func New(cfg Config) (*Client, error) {
+	base, err := url.Parse(strings.TrimRight(cfg.BaseURL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, errorf(ErrInvalidConfig, "invalid base URL %q", cfg.BaseURL)
+	}
+
+	c := &Client{
+		base:    base,
+		apiKey:  cfg.APIKey,
+		http:    cfg.HTTPClient,
+		minBO:   cfg.MinBackoff,
+		maxBO:   cfg.MaxBackoff,
+		tier:    cfg.Tier,
+		deltas:  cfg.Deltas,
+		entries: make(map[string]struct{}),
+	}
+	if c.deltas != "" && c.deltas != DeltasExact && c.deltas != DeltasBloom {
+		return nil, errorf(ErrInvalidConfig, "invalid deltas mode %q, want %q or %q", cfg.Deltas, DeltasExact, DeltasBloom)
+	}
+	if len(cfg.TrustedKeys) > 0 {
+		c.trusted = make(map[string]ed25519.PublicKey, len(cfg.TrustedKeys))
+		for _, pub := range cfg.TrustedKeys {
+			c.trusted[KeyID(pub)] = pub
+		}
+	}
+	if c.http == nil {
+		c.http = &http.Client{Timeout: 30 * time.Second}
+	}
+	if c.minBO <= 0 {
+		c.minBO = 500 * time.Millisecond
+	}
+	if c.maxBO < c.minBO {
+		c.maxBO = 30 * time.Second
+	}
+	return c, nil
+}
+
+// Report submits a single IOC report.
+func (c *Client) Report(ctx context.Context, report IOCReport) (ReportResult, error) {
+	var res ReportResult
+	err := c.do(ctx, http.MethodPost, "/ingest", report, &res)
+	res.fillStatus()
+	return res, err
+}
+
+// ReportBatch submits several reports in one request.  Results are in the
+// same order as reports.
+func (c *Client) ReportBatch(ctx context.Context, reports []IOCReport) ([]ReportResult, error) {
+	var resp struct {
+		Results []ReportResult `json:"results"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/ingest/batch", reports, &resp); err != nil {
+		return nil, err
+	}
+	for i := range resp.Results {
+		resp.Results[i].fillStatus()
+	}
+	return resp.Results, nil
+}
+
+// Check asks the aggregator whether an address is flagged.
+func (c *Client) Check(ctx context.Context, address string, chainID int) (CheckResult, error) {
+	q := url.Values{}
+	q.Set("address", address)
+	q.Set("chain_id", strconv.Itoa(chainID))
+
+	var res CheckResult
+	err := c.do(ctx, http.MethodGet, "/check?"+q.Encode(), nil, &res)
+	return res, err
+}
+
+// CheckIndicator asks the aggregator whether an indicator of another type
+// than an address, such as a "domain", is flagged.
+func (c *Client) CheckIndicator(ctx context.Context, indicatorType, value string) (CheckResult, error) {
+	q := url.Values{}
+	q.Set("address", value)
+	q.Set("type", indicatorType)
+
+	var res CheckResult
+	err := c.do(ctx, http.MethodGet, "/check?"+q.Encode(), nil, &res)
+	return res, err
+}
+
+// Feedback disputes an address the filter flags but the caller knows to
+// be legitimate.  Reason is required; Evidence is free text, such as a
+// link to the address's verified source.
+type Feedback struct {
+	Address  string `json:"address"`
+	ChainID  int    `json:"chain_id,omitempty"`
+	Reason   string `json:"reason"`
+	Evidence string `json:"evidence,omitempty"`
+}
+
+// FeedbackResult is the aggregator's response to a dispute.  Duplicate
+// means the key had disputed the address already; Escalated that this
+// dispute sent it to review or out of the filter.
+type FeedbackResult struct {
+	Address   string `json:"address"`
+	InFilter  bool   `json:"in_filter"`
+	Disputes  int    `json:"disputes"`
+	Duplicate bool   `json:"duplicate"`
+	Escalated bool   `json:"escalated"`
+}
+
+// Dispute reports an address as a false positive.  It needs a subscriber
+// or enterprise key.
+func (c *Client) Dispute(ctx context.Context, fb Feedback) (FeedbackResult, error) {
+	var res FeedbackResult
+	err := c.do(ctx, http.MethodPost, "/feedback", fb, &res)
+	return res, err
+}
+
+// Snapshot downloads the full current filter and replaces the local copy,
+// whatever its parameters.  The payload checksum header is verified first,
+// failing with ErrChecksumMismatch, and with TrustedKeys configured the
+// signature headers too.  A payload in a format version the SDK
+// cannot read fails with a *FormatVersionError.
+func (c *Client) Snapshot(ctx context.Context) (FilterUpdate, error) {
+	path := "/filter"
+	if c.tier != "" {
+		path += "?" + url.Values{"tier": {c.tier}}.Encode()
+	}
+	resp, err := c.send(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return FilterUpdate{}, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return FilterUpdate{}, err
+	}
+
+	h := resp.Header
+	if err := VerifyChecksum(h.Get(HeaderPayloadChecksum), data); err != nil {
+		return FilterUpdate{}, err
+	}
+	payload, err := decodeFilterPayload(data)
+	if err != nil {
+		return FilterUpdate{}, err
+	}
+	if err := c.verify(h.Get(HeaderFilterKeyID), h.Get(HeaderFilterSignature), payload.Version, data); err != nil {
+		return FilterUpdate{}, err
+	}
+	update := payload.update(true)
+	update.Instance = h.Get(HeaderFilterInstance)
+	update.LogicalVersion, _ = strconv.ParseUint(h.Get(HeaderFilterLogicalVersion), 10, 64)
+	update.InstanceUUID = h.Get(HeaderInstanceUUID)
+	update.Epoch, _ = strconv.ParseUint(h.Get(HeaderFilterEpoch), 10, 64)
+	c.apply(update)
+	return update, nil
+}
+
+// Contains reports whether the address is in the locally synced filter,
+// in any spelling the aggregator keys the same (see addressKey).  It
+// returns false until the first snapshot has been applied.  It does not
+// allocate for addresses of up to maxAddressKey bytes.
+func (c *Client) Contains(address string) bool {
+	var buf [maxAddressKey]byte
+	key := appendAddressKey(buf[:0], address)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.entries[string(key)]
+	return ok
+}
+
+// maxAddressKey is the longest address Contains folds on the stack, that
+// of a bech32 address.
+const maxAddressKey = 90
+
+// addressKey reduces an address to the spelling the aggregator keys its
+// filter by: EVM addresses and bech32 Bitcoin addresses lowercased,
+// anything else as given.
+func addressKey(address string) string {
+	address = strings.TrimSpace(address)
+	if foldsCase(address) {
+		return strings.ToLower(address)
+	}
+	return address
+}
+
+// appendAddressKey appends addressKey(address) to dst.  The aggregator
+// only keys ASCII addresses lowercased, so only ASCII is folded.
+func appendAddressKey(dst []byte, address string) []byte {
+	address = strings.TrimSpace(address)
+	if !foldsCase(address) {
+		return append(dst, address...)
+	}
+	for i := 0; i < len(address); i++ {
+		c := address[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// foldsCase reports whether the aggregator keys a trimmed address
+// lowercased: an EVM address or a bech32 Bitcoin one.
+func foldsCase(address string) bool {
+	return len(address) == 42 && strings.EqualFold(address[:2], "0x") ||
+		len(address) >= 3 && strings.EqualFold(address[:3], "bc1")
+}
+
+// Version returns the version of the locally synced filter.
+func (c *Client) Version() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
+
+// Epoch returns the aggregator epoch the local version is from, zero
+// before the first snapshot or from an aggregator predating epochs.
+func (c *Client) Epoch() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.epoch
+}
+
+// verify checks a signed filter payload against the trusted keys.  It is
+// a no-op when no keys are configured.
+func (c *Client) verify(keyID, signature string, version uint64, payload []byte) error {
+	if len(c.trusted) == 0 {
+		return nil
+	}
+	if keyID == "" || signature == "" {
+		return ErrUnsigned
+	}
+	pub, ok := c.trusted[keyID]
+	if !ok {
+		return ErrKeyMismatch
+	}
+	return VerifySignature(pub, keyID, signature, version, payload)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// send performs the request and turns non-2xx answers into *HTTPError.
+// The caller must close the body of a successful response.
+func (c *Client) send(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base.String()+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	c.authorize(req.Header)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newHTTPError(resp.StatusCode, data)
+	}
+	return resp, nil
+}
+
+func (c *Client) authorize(h http.Header) {
+	if c.apiKey != "" {
+		h.Set("Authorization", "Bearer "+c.apiKey)
+	}
+}
@@ -0,0 +1,398 @@
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, f *FakeServer) *Client {
+	t.Helper()
+	c, err := New(Config{BaseURL: f.URL, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	return c
+}
+
+func nextUpdate(t *testing.T, updates <-chan FilterUpdate) FilterUpdate {
+	t.Helper()
+	select {
+	case u, ok := <-updates:
+		if !ok {
+			t.Fatal("Update channel closed")
+		}
+		return u
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for filter update")
+	}
+	return FilterUpdate{}
+}
+
+func TestReportBatchAndCheck(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	f.Promote = func(r IOCReport) bool { return r.Confidence >= 0.9 }
+	c := newTestClient(t, f)
+	ctx := context.Background()
+
+	res, err := c.Report(ctx, IOCReport{Address: "0xLow", ChainID: 1, Confidence: 0.5, SourceID: "a"})
+	if err != nil || res.Status != StatusRecorded || res.ReasonCode != ReasonBelowThreshold || res.AddedToFilter {
+		t.Fatalf("Unexpected result %+v (%v)", res, err)
+	}
+
+	results, err := c.ReportBatch(ctx, []IOCReport{
+		{Address: "0xB1", ChainID: 1, Confidence: 0.95, SourceID: "a"},
+		{Address: "0xB2", ChainID: 1, Confidence: 0.1, SourceID: "b"},
+	})
+	if err != nil || len(results) != 2 || !results[0].AddedToFilter || results[1].AddedToFilter {
+		t.Fatalf("Unexpected batch results %+v (%v)", results, err)
+	}
+	if results[0].ReasonCode != ReasonInFilter || results[0].FilterVersion != 1 {
+		t.Errorf("Expected 0xB1 in the filter at version 1, got %+v", results[0])
+	}
+	if len(f.Reports()) != 3 {
+		t.Errorf("Expected 3 reports at the server, got %d", len(f.Reports()))
+	}
+
+	check, err := c.Check(ctx, "0xB1", 1)
+	if err != nil || !check.Flagged {
+		t.Errorf("Expected 0xB1 flagged, got %+v (%v)", check, err)
+	}
+}
+
+func TestReportResultStatusFromLegacyAggregator(t *testing.T) {
+	for _, tc := range []struct {
+		body string
+		want string
+	}{
+		{`{"accepted":true,"added_to_filter":false}`, StatusRecorded},
+		{`{"accepted":false}`, StatusRejected},
+		{`{"status":"duplicate","reason_code":"replayed","accepted":true}`, StatusDuplicate},
+	} {
+		var res ReportResult
+		if err := json.Unmarshal([]byte(tc.body), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.fillStatus(); res.Status != tc.want {
+			t.Errorf("%s: expected status %q, got %q", tc.body, tc.want, res.Status)
+		}
+	}
+}
+
+func TestHTTPErrorsMatchSentinels(t *testing.T) {
+	for _, tc := range []struct {
+		status int
+		code   string
+		want   []error
+	}{
+		{http.StatusTooManyRequests, "rate_limited", []error{ErrRateLimited}},
+		{http.StatusForbidden, "source_banned", []error{ErrForbidden, ErrSourceBanned}},
+		{http.StatusServiceUnavailable, "standby", []error{ErrUnavailable, ErrStandby}},
+		{http.StatusNotImplemented, "read_only_mirror", []error{ErrStandby}},
+		{http.StatusUnprocessableEntity, "invalid_address", []error{ErrBadRequest}},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			w.Write([]byte(`{"error":{"code":"` + tc.code + `","message":"no"}}`))
+		}))
+		c, _ := New(Config{BaseURL: srv.URL})
+		_, err := c.Report(context.Background(), IOCReport{Address: "0xA", ChainID: 1, SourceID: "a"})
+		srv.Close()
+
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != tc.status || httpErr.Code != tc.code {
+			t.Errorf("%d %s: expected an *HTTPError with the code, got %v", tc.status, tc.code, err)
+		}
+		for _, want := range tc.want {
+			if !errors.Is(err, want) {
+				t.Errorf("%d %s: expected errors.Is(err, %v)", tc.status, tc.code, want)
+			}
+		}
+		if errors.Is(err, ErrNotFound) {
+			t.Errorf("%d %s: matched ErrNotFound", tc.status, tc.code)
+		}
+	}
+
+	if _, err := New(Config{BaseURL: "not a url"}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a bad base URL, got %v", err)
+	}
+	if _, err := NewCompositeFilter(nil, CompositeConfig{Precedence: []Layer{LayerLocal, LayerLocal}}); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a repeated layer, got %v", err)
+	}
+}
+
+func TestWatchKeepsLocalFilterInSync(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	f.Add("0xSeed")
+	c := newTestClient(t, f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+
+	if u := nextUpdate(t, updates); u.Version != 1 || !c.Contains("0xSeed") {
+		t.Fatalf("Expected initial snapshot v1, got %+v", u)
+	}
+
+	f.Add("0xLive")
+	if u := nextUpdate(t, updates); u.Version != 2 || !c.Contains("0xLive") || c.Contains("0xNope") {
+		t.Fatalf("Expected pushed update v2, got %+v", u)
+	}
+
+	cancel()
+	for range updates {
+	}
+}
+
+func TestContainsMatchesAnyCaseOfAnEVMAddress(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	f.Add("0x52908400098527886e0f7030069857d2e4169ee7") // stored lowercased, as the aggregator does
+	c := newTestClient(t, f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	nextUpdate(t, updates)
+	for _, address := range []string{"0x52908400098527886E0F7030069857D2E4169EE7", " 0x52908400098527886e0f7030069857d2e4169ee7"} {
+		if !c.Contains(address) {
+			t.Errorf("Expected %q found under its lowercase spelling", address)
+		}
+	}
+	cancel()
+	for range updates {
+	}
+}
+
+func TestContainsDoesNotAllocate(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	f.Add("0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed")
+	c := newTestClient(t, f)
+	if _, err := c.Snapshot(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	checksummed := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed" // EIP-55
+	allocs := testing.AllocsPerRun(100, func() {
+		if !c.Contains(checksummed) {
+			t.Fatal("Expected the checksummed spelling found")
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("Expected Contains not to allocate, got %v allocations", allocs)
+	}
+}
+
+func TestWatchResumesWithDeltaAfterReconnect(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	// The backoff must outlast the Add below so the client reconnects to v2.
+	c, _ := New(Config{BaseURL: f.URL, MinBackoff: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	nextUpdate(t, updates) // empty snapshot, v0
+
+	f.DropConnections()
+	f.Add("0xMissed1", "0xMissed2") // v2 while disconnected: a gap of two
+
+	u := nextUpdate(t, updates)
+	if u.Resync || u.Version != 2 || len(u.Added) != 2 {
+		t.Fatalf("Expected delta v0->v2 with two additions, got %+v", u)
+	}
+	if f.SnapshotRequests() != 0 {
+		t.Errorf("Expected no snapshot fetch when resuming, got %d", f.SnapshotRequests())
+	}
+	if !c.Contains("0xMissed1") || !c.Contains("0xMissed2") {
+		t.Error("Local filter missing entries added during the disconnect")
+	}
+
+	f.Add("0xAfter")
+	if u := nextUpdate(t, updates); u.Resync || u.Version != 3 {
+		t.Errorf("Expected ordinary push v3 after resume, got %+v", u)
+	}
+}
+
+func TestWatchAppliesRemovalsByDeltaMode(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		deltas    string
+		remove    bool
+		resync    bool
+		snapshots int
+	}{
+		{"exact applies removals", DeltasExact, true, false, 0},
+		{"bloom applies additions", DeltasBloom, false, false, 0},
+		{"bloom resyncs on removals", DeltasBloom, true, true, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := NewFakeServer()
+			defer f.Close()
+			f.Add("0xKeep", "0xGone")
+			// The backoff must outlast the changes below.
+			c, err := New(Config{BaseURL: f.URL, MinBackoff: 200 * time.Millisecond, Deltas: tc.deltas})
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			updates, err := c.Watch(ctx)
+			if err != nil {
+				t.Fatalf("Watch failed: %v", err)
+			}
+			nextUpdate(t, updates)
+			before := f.SnapshotRequests()
+
+			f.DropConnections()
+			if tc.remove {
+				f.Remove("0xGone")
+			}
+			f.Add("0xNew")
+
+			u := nextUpdate(t, updates)
+			if u.Resync != tc.resync || f.SnapshotRequests()-before != tc.snapshots {
+				t.Fatalf("Expected resync=%v with %d snapshot fetches, got %+v and %d", tc.resync, tc.snapshots, u, f.SnapshotRequests()-before)
+			}
+			if tc.remove && !tc.resync && (len(u.Removed) != 1 || u.Removed[0] != "0xGone") {
+				t.Errorf("Expected the removal in the delta, got %+v", u)
+			}
+			if tc.deltas == DeltasBloom && len(u.Removed) != 0 {
+				t.Errorf("Expected a bloom-mode client never shown removals, got %+v", u)
+			}
+			if !c.Contains("0xKeep") || !c.Contains("0xNew") || c.Contains("0xGone") != !tc.remove {
+				t.Errorf("Local filter out of sync: %+v", u.Entries)
+			}
+		})
+	}
+}
+
+func TestWatchResyncsWhenHistoryIsGone(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	c, _ := New(Config{BaseURL: f.URL, MinBackoff: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	nextUpdate(t, updates)
+
+	f.DropConnections()
+	f.Add("0xMissed")
+	f.ForgetHistory()
+
+	if u := nextUpdate(t, updates); !u.Resync || u.Version != 1 || !c.Contains("0xMissed") {
+		t.Fatalf("Expected resync snapshot at v1, got %+v", u)
+	}
+}
+
+func TestWatchResyncsAfterRestart(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	f.Add("0xOld1", "0xOld2")
+	c, _ := New(Config{BaseURL: f.URL, MinBackoff: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	before := nextUpdate(t, updates)
+
+	// A restart loses the filter; the new lineage overtakes the old
+	// version, so only the epoch tells the two apart.
+	f.Restart()
+	f.Add("0xNew1", "0xNew2", "0xNew3")
+
+	u := nextUpdate(t, updates)
+	if !u.Resync || u.Version != 3 || u.Epoch == before.Epoch || u.Epoch != f.Epoch() {
+		t.Fatalf("Expected a resync snapshot at v3 of the new epoch, got %+v", u)
+	}
+	if c.Contains("0xOld1") || !c.Contains("0xNew1") || c.Epoch() != f.Epoch() {
+		t.Errorf("Expected only the new lineage's entries, got %v", u.Entries)
+	}
+}
+
+func TestWatchResyncsAfterCorruptedPush(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	c := newTestClient(t, f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	nextUpdate(t, updates)
+	before := f.SnapshotRequests()
+
+	f.CorruptNextPush()
+	f.Add("0xA1")
+	if u := nextUpdate(t, updates); u.Version != 1 || !c.Contains("0xA1") || c.Contains("0xA0") {
+		t.Fatalf("Expected the corrupted push replaced by a snapshot at v1, got %+v", u)
+	}
+	if f.SnapshotRequests() != before+1 {
+		t.Errorf("Expected one snapshot fetch, got %d", f.SnapshotRequests()-before)
+	}
+}
+
+func TestWatchSurfacesDialErrors(t *testing.T) {
+	f := NewFakeServer()
+	c := newTestClient(t, f)
+	f.Close()
+
+	if _, err := c.Watch(context.Background()); err == nil {
+		t.Error("Expected Watch to fail against a closed server")
+	}
+}
+
+func TestTrustedKeysVerifySnapshotsAndPushes(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	f.Add("0xSigned")
+
+	c, _ := New(Config{BaseURL: f.URL, TrustedKeys: []ed25519.PublicKey{f.PublicKey()}})
+	if _, err := c.Snapshot(context.Background()); err != nil || !c.Contains("0xSigned") {
+		t.Fatalf("Expected verified snapshot, got %v", err)
+	}
+
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	untrusting, _ := New(Config{BaseURL: f.URL, TrustedKeys: []ed25519.PublicKey{other}})
+	if _, err := untrusting.Snapshot(context.Background()); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch for an untrusted key, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := untrusting.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	f.Add("0xLive")
+	select {
+	case u := <-updates:
+		t.Errorf("Expected unverifiable pushes to be dropped, got %+v", u)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,372 @@
+package client
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aegis-protocol/swarm/bloom"
+)
+
+// Layer is where a CompositeFilter verdict came from.
+type Layer string
+
+// Layers of a CompositeFilter.  LayerSwarm is the filter a Client keeps
+// in sync; LayerLocal are the caller's private blocklists and
+// LayerAllowlist its allowlists.
+const (
+	LayerSwarm     Layer = "swarm"
+	LayerLocal     Layer = "local"
+	LayerAllowlist Layer = "allowlist"
+)
+
+// DefaultPrecedence is the order layers are consulted in when
+// CompositeConfig.Precedence is empty: a local allowlist overrides any
+// block, and a local block is reported rather than the swarm's.
+var DefaultPrecedence = []Layer{LayerAllowlist, LayerLocal, LayerSwarm}
+
+// ListEntry is one address on a local list.  ChainID zero matches the
+// address on any chain.
+type ListEntry struct {
+	Address  string `json:"address"`
+	ChainID  int    `json:"chain_id,omitempty"`
+	Category string `json:"category,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+// Verdict is what a CompositeFilter says of an address.  Layer is the
+// first layer in precedence order that matched, empty when none did, and
+// List the local list's name.  Entry is the matching entry of an exact
+// list; a Bloom list or the swarm filter only has the address.
+type Verdict struct {
+	Blocked bool
+	Layer   Layer
+	List    string
+	Entry   ListEntry
+}
+
+// CompositeConfig configures a CompositeFilter.
+type CompositeConfig struct {
+	// Precedence is the order layers are consulted in, each at most
+	// once; the first that matches decides.  A layer left out is never
+	// consulted.  Default: DefaultPrecedence.
+	Precedence []Layer
+}
+
+// CompositeFilter merges the swarm filter with local blocklists and
+// allowlists behind one Check.  Local lists hold exact entries or a Bloom
+// filter, and those loaded from a file are reloaded by Reload or
+// WatchLists when the file changes.  It is safe for concurrent use.
+type CompositeFilter struct {
+	swarm      *Client
+	precedence []Layer
+
+	mu    sync.RWMutex
+	lists []*localList // in the order added
+}
+
+// localList is one named local list.
+type localList struct {
+	name  string
+	layer Layer // LayerLocal or LayerAllowlist
+	exact map[string][]ListEntry
+	bits  *bloom.Bits
+
+	path    string // file it was loaded from, if any
+	modTime time.Time
+	size    int64
+}
+
+// NewCompositeFilter returns a CompositeFilter over swarm, which may be
+// nil for local lists alone.
+func NewCompositeFilter(swarm *Client, cfg CompositeConfig) (*CompositeFilter, error) {
+	precedence := cfg.Precedence
+	if len(precedence) == 0 {
+		precedence = DefaultPrecedence
+	}
+	seen := make(map[Layer]bool, len(precedence))
+	for _, layer := range precedence {
+		if layer != LayerSwarm && layer != LayerLocal && layer != LayerAllowlist {
+			return nil, errorf(ErrInvalidConfig, "unknown layer %q in precedence", layer)
+		}
+		if seen[layer] {
+			return nil, errorf(ErrInvalidConfig, "layer %q appears twice in precedence", layer)
+		}
+		seen[layer] = true
+	}
+	return &CompositeFilter{swarm: swarm, precedence: append([]Layer(nil), precedence...)}, nil
+}
+
+// Check returns the verdict for an address on a chain.
+func (f *CompositeFilter) Check(address string, chainID int) Verdict {
+	key := addressKey(address)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, layer := range f.precedence {
+		if layer == LayerSwarm {
+			if f.swarm != nil && f.swarm.Contains(key) {
+				return Verdict{Blocked: true, Layer: LayerSwarm, Entry: ListEntry{Address: key}}
+			}
+			continue
+		}
+		for _, list := range f.lists {
+			if list.layer != layer {
+				continue
+			}
+			if entry, ok := list.match(key, chainID); ok {
+				return Verdict{Blocked: layer == LayerLocal, Layer: layer, List: list.name, Entry: entry}
+			}
+		}
+	}
+	return Verdict{}
+}
+
+// AddList adds, or replaces by name, a local list of exact entries.
+// layer is LayerLocal for a blocklist or LayerAllowlist.
+func (f *CompositeFilter) AddList(name string, layer Layer, entries []ListEntry) error {
+	if err := checkListLayer(layer); err != nil {
+		return err
+	}
+	f.put(&localList{name: name, layer: layer, exact: indexEntries(entries)})
+	return nil
+}
+
+// AddBloom adds, or replaces by name, a local list held as a Bloom
+// filter of addresses.  Its matches carry no chain or metadata.
+func (f *CompositeFilter) AddBloom(name string, layer Layer, bits *bloom.Bits) error {
+	if err := checkListLayer(layer); err != nil {
+		return err
+	}
+	if bits == nil {
+		return errorf(ErrInvalidConfig, "nil Bloom filter")
+	}
+	f.put(&localList{name: name, layer: layer, bits: bits})
+	return nil
+}
+
+// LoadFile adds, or replaces by name, a local list read from a file,
+// which Reload and WatchLists then keep current.  The extension selects
+// the format: ".csv" rows of address, chain_id, category, note, an
+// optional header row first; ".json" an array of ListEntry; ".bloom" a
+// bit array as bloom.EncodeBits writes it.
+func (f *CompositeFilter) LoadFile(name string, layer Layer, path string) error {
+	if err := checkListLayer(layer); err != nil {
+		return err
+	}
+	list, err := readListFile(name, layer, path)
+	if err != nil {
+		return err
+	}
+	f.put(list)
+	return nil
+}
+
+// ListReload is the outcome of reloading one file-backed list.  On
+// error the list keeps what it held before.
+type ListReload struct {
+	Name string
+	Path string
+	Err  error
+}
+
+// Reload rereads every file-backed list whose file changed since it was
+// loaded, by modification time or size, and returns what was reloaded.
+func (f *CompositeFilter) Reload() []ListReload {
+	f.mu.RLock()
+	var stale []*localList
+	for _, list := range f.lists {
+		if list.path == "" {
+			continue
+		}
+		info, err := os.Stat(list.path)
+		if err != nil || !info.ModTime().Equal(list.modTime) || info.Size() != list.size {
+			stale = append(stale, list)
+		}
+	}
+	f.mu.RUnlock()
+
+	var out []ListReload
+	for _, old := range stale {
+		list, err := readListFile(old.name, old.layer, old.path)
+		out = append(out, ListReload{Name: old.name, Path: old.path, Err: err})
+		if err == nil {
+			f.replace(old, list)
+		}
+	}
+	return out
+}
+
+// WatchLists calls Reload every interval until ctx is done and sends
+// each list reloaded on the returned channel, which is closed when the
+// watch ends.  Reloads are dropped while the channel is not read.
+func (f *CompositeFilter) WatchLists(ctx context.Context, interval time.Duration) <-chan ListReload {
+	reloads := make(chan ListReload, 16)
+	go func() {
+		defer close(reloads)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, r := range f.Reload() {
+				select {
+				case reloads <- r:
+				default:
+				}
+			}
+		}
+	}()
+	return reloads
+}
+
+// put adds a list, replacing one of the same name in place.
+func (f *CompositeFilter) put(list *localList) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, l := range f.lists {
+		if l.name == list.name {
+			f.lists[i] = list
+			return
+		}
+	}
+	f.lists = append(f.lists, list)
+}
+
+// replace swaps a reloaded list in for old, unless old was replaced or
+// removed meanwhile.
+func (f *CompositeFilter) replace(old, list *localList) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, l := range f.lists {
+		if l == old {
+			f.lists[i] = list
+			return
+		}
+	}
+}
+
+// match looks an address up, an entry for its chain winning over one for
+// any chain.
+func (l *localList) match(key string, chainID int) (ListEntry, bool) {
+	if l.bits != nil {
+		return ListEntry{Address: key}, l.bits.Contains(key)
+	}
+	var wildcard ListEntry
+	found := false
+	for _, entry := range l.exact[key] {
+		if entry.ChainID == chainID {
+			return entry, true
+		}
+		if entry.ChainID == 0 && !found {
+			wildcard, found = entry, true
+		}
+	}
+	return wildcard, found
+}
+
+func checkListLayer(layer Layer) error {
+	if layer != LayerLocal && layer != LayerAllowlist {
+		return errorf(ErrInvalidConfig, "local list layer %q, want %q or %q", layer, LayerLocal, LayerAllowlist)
+	}
+	return nil
+}
+
+// indexEntries keys entries by address.
+func indexEntries(entries []ListEntry) map[string][]ListEntry {
+	exact := make(map[string][]ListEntry, len(entries))
+	for _, entry := range entries {
+		entry.Address = addressKey(entry.Address)
+		exact[entry.Address] = append(exact[entry.Address], entry)
+	}
+	return exact
+}
+
+// readListFile loads a list from a file in the format its extension
+// names.
+func readListFile(name string, layer Layer, path string) (*localList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	list := &localList{name: name, layer: layer, path: path, modTime: info.ModTime(), size: info.Size()}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".csv":
+		entries, err := readCSVList(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		list.exact = indexEntries(entries)
+	case ".json":
+		var entries []ListEntry
+		if err := json.NewDecoder(file).Decode(&entries); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		list.exact = indexEntries(entries)
+	case ".bloom":
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return nil, err
+		}
+		if list.bits, _, err = bloom.DecodeBits(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unknown list format %q, want .csv, .json or .bloom", path, ext)
+	}
+	return list, nil
+}
+
+// readCSVList parses rows of address, chain_id, category, note; every
+// column after the address is optional.
+func readCSVList(r io.Reader) ([]ListEntry, error) {
+	records := csv.NewReader(r)
+	records.FieldsPerRecord = -1
+	records.TrimLeadingSpace = true
+	records.Comment = '#'
+	var entries []ListEntry
+	for first := true; ; first = false {
+		row, err := records.Read()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if first && strings.EqualFold(row[0], "address") {
+			continue
+		}
+		line, _ := records.FieldPos(0)
+		entry := ListEntry{Address: row[0]}
+		if entry.Address == "" {
+			return nil, fmt.Errorf("line %d: missing address", line)
+		}
+		if len(row) > 1 && row[1] != "" {
+			if entry.ChainID, err = strconv.Atoi(row[1]); err != nil {
+				return nil, fmt.Errorf("line %d: invalid chain_id %q", line, row[1])
+			}
+		}
+		if len(row) > 2 {
+			entry.Category = row[2]
+		}
+		if len(row) > 3 {
+			entry.Note = row[3]
+		}
+		entries = append(entries, entry)
+	}
+}
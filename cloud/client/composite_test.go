@@ -0,0 +1,181 @@
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/bloom"
+)
+
+const (
+	swarmAddr  = "0x1111111111111111111111111111111111111111"
+	sharedAddr = "0x2222222222222222222222222222222222222222"
+	localAddr  = "0x3333333333333333333333333333333333333333"
+)
+
+func newSyncedComposite(t *testing.T, cfg CompositeConfig) *CompositeFilter {
+	t.Helper()
+	f := NewFakeServer()
+	t.Cleanup(f.Close)
+	f.Add(swarmAddr, sharedAddr)
+	c := newTestClient(t, f)
+	if _, err := c.Snapshot(context.Background()); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	cf, err := NewCompositeFilter(c, cfg)
+	if err != nil {
+		t.Fatalf("NewCompositeFilter failed: %v", err)
+	}
+	return cf
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompositeDefaultPrecedence(t *testing.T) {
+	cf := newSyncedComposite(t, CompositeConfig{})
+	dir := t.TempDir()
+	blocklist := filepath.Join(dir, "private.csv")
+	writeFile(t, blocklist, "address,chain_id,category,note\n"+
+		"0x3333333333333333333333333333333333333333,1,drainer,from incident 42\n"+
+		sharedAddr+",,phishing,\n")
+	allowlist := filepath.Join(dir, "allow.json")
+	writeFile(t, allowlist, `[{"address": "0x2222222222222222222222222222222222222222", "chain_id": 137, "note": "our hot wallet"}]`)
+	if err := cf.LoadFile("private", LayerLocal, blocklist); err != nil {
+		t.Fatalf("Loading the CSV failed: %v", err)
+	}
+	if err := cf.LoadFile("allow", LayerAllowlist, allowlist); err != nil {
+		t.Fatalf("Loading the JSON failed: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		address string
+		chainID int
+		want    Verdict
+	}{
+		{"swarm only", swarmAddr, 1, Verdict{Blocked: true, Layer: LayerSwarm, Entry: ListEntry{Address: swarmAddr}}},
+		{"padded spelling", " 0X3333333333333333333333333333333333333333 ", 1,
+			Verdict{Blocked: true, Layer: LayerLocal, List: "private", Entry: ListEntry{Address: localAddr, ChainID: 1, Category: "drainer", Note: "from incident 42"}}},
+		{"local entry for another chain", localAddr, 56, Verdict{}},
+		{"local beats swarm", sharedAddr, 1,
+			Verdict{Blocked: true, Layer: LayerLocal, List: "private", Entry: ListEntry{Address: sharedAddr, Category: "phishing"}}},
+		{"allowlist beats both", sharedAddr, 137,
+			Verdict{Layer: LayerAllowlist, List: "allow", Entry: ListEntry{Address: sharedAddr, ChainID: 137, Note: "our hot wallet"}}},
+		{"unknown", "0x4444444444444444444444444444444444444444", 1, Verdict{}},
+	}
+	for _, tt := range tests {
+		if got := cf.Check(tt.address, tt.chainID); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCompositeConfiguredPrecedence(t *testing.T) {
+	// The swarm's block stands over the allowlist, and the swarm is
+	// reported ahead of a local block.
+	cf := newSyncedComposite(t, CompositeConfig{Precedence: []Layer{LayerSwarm, LayerAllowlist, LayerLocal}})
+	cf.AddList("private", LayerLocal, []ListEntry{{Address: sharedAddr, Category: "phishing"}, {Address: localAddr}})
+	cf.AddList("allow", LayerAllowlist, []ListEntry{{Address: sharedAddr}, {Address: localAddr, ChainID: 10}})
+
+	if v := cf.Check(sharedAddr, 1); !v.Blocked || v.Layer != LayerSwarm {
+		t.Errorf("Expected the swarm's verdict first, got %+v", v)
+	}
+	if v := cf.Check(localAddr, 10); v.Blocked || v.Layer != LayerAllowlist {
+		t.Errorf("Expected the allowlist over the local block, got %+v", v)
+	}
+	if v := cf.Check(localAddr, 1); !v.Blocked || v.Layer != LayerLocal {
+		t.Errorf("Expected the local block, got %+v", v)
+	}
+
+	// Leaving the swarm out consults local lists alone.
+	local, err := NewCompositeFilter(cf.swarm, CompositeConfig{Precedence: []Layer{LayerLocal}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := local.Check(swarmAddr, 1); v.Layer != "" {
+		t.Errorf("Expected the swarm layer skipped, got %+v", v)
+	}
+
+	for _, bad := range [][]Layer{{LayerSwarm, LayerSwarm}, {"remote"}} {
+		if _, err := NewCompositeFilter(nil, CompositeConfig{Precedence: bad}); err == nil {
+			t.Errorf("Expected precedence %v rejected", bad)
+		}
+	}
+	if err := cf.AddList("x", LayerSwarm, nil); err == nil {
+		t.Error("Expected a local list in the swarm layer rejected")
+	}
+}
+
+func TestCompositeBloomList(t *testing.T) {
+	bits, ok := bloom.NewBits(bloom.BloomParamsFor(100, 0.001))
+	if !ok {
+		t.Fatal("NewBits failed")
+	}
+	bits.Add(localAddr)
+	path := filepath.Join(t.TempDir(), "feed.bloom")
+	if err := os.WriteFile(path, bloom.EncodeBits(bits, 1), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cf, _ := NewCompositeFilter(nil, CompositeConfig{})
+	if err := cf.LoadFile("feed", LayerLocal, path); err != nil {
+		t.Fatalf("Loading the Bloom filter failed: %v", err)
+	}
+	if v := cf.Check(localAddr, 1); !v.Blocked || v.List != "feed" || v.Entry.Address != localAddr {
+		t.Errorf("Expected the Bloom list to match, got %+v", v)
+	}
+	if v := cf.Check(swarmAddr, 1); v.Layer != "" {
+		t.Errorf("Expected no match, got %+v", v)
+	}
+	if err := cf.LoadFile("feed", LayerLocal, filepath.Join(t.TempDir(), "feed.txt")); err == nil {
+		t.Error("Expected an unknown extension rejected")
+	}
+}
+
+func TestCompositeReloadsChangedFile(t *testing.T) {
+	cf, _ := NewCompositeFilter(nil, CompositeConfig{})
+	path := filepath.Join(t.TempDir(), "private.csv")
+	writeFile(t, path, localAddr+"\n")
+	if err := cf.LoadFile("private", LayerLocal, path); err != nil {
+		t.Fatal(err)
+	}
+	if got := cf.Reload(); len(got) != 0 {
+		t.Errorf("Expected nothing reloaded from an unchanged file, got %+v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloads := cf.WatchLists(ctx, 10*time.Millisecond)
+
+	writeFile(t, path, swarmAddr+",1,scam,\n")
+	select {
+	case r := <-reloads:
+		if r.Name != "private" || r.Err != nil {
+			t.Fatalf("Unexpected reload %+v", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the reload")
+	}
+	if v := cf.Check(localAddr, 1); v.Layer != "" {
+		t.Errorf("Expected the old entry gone, got %+v", v)
+	}
+	if v := cf.Check(swarmAddr, 1); !v.Blocked || v.Entry.Category != "scam" {
+		t.Errorf("Expected the new entry, got %+v", v)
+	}
+
+	// A broken file is reported and the list keeps its entries.
+	writeFile(t, path, swarmAddr+",not-a-chain\n")
+	if got := cf.Reload(); len(got) != 1 || got[0].Err == nil {
+		t.Errorf("Expected the broken reload reported, got %+v", got)
+	}
+	if v := cf.Check(swarmAddr, 1); !v.Blocked {
+		t.Errorf("Expected the previous entries kept, got %+v", v)
+	}
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Errors a Client's calls match with errors.Is.  An *HTTPError matches
+// the one for its status, and ErrSourceBanned or ErrStandby by its error
+// code as well, so callers need not parse bodies:
+//
+//	if errors.Is(err, client.ErrRateLimited) { back off }
+//
+// The filter's own errors are the bloom package's (bloom.ErrMalformed
+// and the rest); verification errors are in verify.go.
+var (
+	ErrBadRequest   = errors.New("aegis: request rejected as invalid")       // 400 and 422
+	ErrUnauthorized = errors.New("aegis: missing or unknown API key")        // 401
+	ErrForbidden    = errors.New("aegis: API key lacks the role")            // 403
+	ErrNotFound     = errors.New("aegis: not found")                         // 404
+	ErrConflict     = errors.New("aegis: conflicts with the server's state") // 409
+	ErrRateLimited  = errors.New("aegis: rate limited")                      // 429
+	ErrUnavailable  = errors.New("aegis: server unavailable")                // 503
+
+	// ErrSourceBanned is matched by a report refused because its source
+	// is banned.
+	ErrSourceBanned = errors.New("aegis: source is banned")
+
+	// ErrStandby is matched by a write refused by a warm standby or a
+	// read-only mirror; send it to the active aggregator instead.
+	ErrStandby = errors.New("aegis: aggregator does not accept writes")
+
+	// ErrInvalidConfig is wrapped by the errors of New and
+	// NewCompositeFilter for configurations they cannot run.
+	ErrInvalidConfig = errors.New("aegis: invalid configuration")
+)
+
+// statusErrors are the errors an *HTTPError matches by status.
+var statusErrors = map[int]error{
+	http.StatusBadRequest:          ErrBadRequest,
+	http.StatusUnprocessableEntity: ErrBadRequest,
+	http.StatusUnauthorized:        ErrUnauthorized,
+	http.StatusForbidden:           ErrForbidden,
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusConflict:            ErrConflict,
+	http.StatusTooManyRequests:     ErrRateLimited,
+	http.StatusServiceUnavailable:  ErrUnavailable,
+}
+
+// codeErrors are the errors an *HTTPError matches by error code.
+var codeErrors = map[string]error{
+	"source_banned":    ErrSourceBanned,
+	"standby":          ErrStandby,
+	"read_only_mirror": ErrStandby,
+}
+
+// HTTPError is returned when the aggregator answers with a non-2xx status.
+// Code is the error code of the body, if it has the aggregator's error
+// envelope.
+type HTTPError struct {
+	StatusCode int
+	Code       string
+	Body       string
+}
+
+// newHTTPError returns the error for a non-2xx answer with body.
+func newHTTPError(status int, body []byte) *HTTPError {
+	var envelope struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	json.Unmarshal(body, &envelope)
+	return &HTTPError{StatusCode: status, Code: envelope.Error.Code, Body: string(body)}
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("aegis: server returned %d: %s", e.StatusCode, strings.TrimSpace(e.Body))
+}
+
+// Is matches the errors for the status and code.
+func (e *HTTPError) Is(target error) bool {
+	return target != nil && (statusErrors[e.StatusCode] == target || codeErrors[e.Code] == target)
+}
+
+// kindError is an error of a sentinel kind with a message of its own.
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string { return e.err.Error() }
+
+func (e *kindError) Unwrap() []error { return []error{e.kind, e.err} }
+
+// errorf formats an error, as fmt.Errorf does, that is also kind.
+func errorf(kind error, format string, args ...interface{}) error {
+	return &kindError{kind: kind, err: fmt.Errorf(format, args...)}
+}
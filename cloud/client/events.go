@@ -0,0 +1,193 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is one message of the aggregator's event stream.  Type is
+// "report_accepted", "promoted", "removed", "expired", "retracted",
+// "banned" or "disputed".
+type Event struct {
+	Schema   int       `json:"schema"`
+	Seq      uint64    `json:"seq"`      // per aggregator process
+	TypeSeq  uint64    `json:"type_seq"` // per process and type
+	Stream   string    `json:"stream"`   // names the process
+	Instance string    `json:"instance,omitempty"`
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+
+	Address      string     `json:"address,omitempty"`
+	ChainID      int        `json:"chain_id,omitempty"`
+	Category     string     `json:"category,omitempty"`
+	Confidence   float64    `json:"confidence,omitempty"`
+	SourceID     string     `json:"source_id,omitempty"`
+	Source       string     `json:"source,omitempty"`        // what promoted: "consensus" or "admin"
+	SourceTier   string     `json:"source_tier,omitempty"`   // of the report that reached consensus: "anonymous", "registered" or "trusted"
+	SeverityBand int        `json:"severity_band,omitempty"` // min_severity of the band promoted under, 0 for none
+	Tier         string     `json:"tier,omitempty"`          // filter entered: "main" or "staging"
+	FromTier     string     `json:"from_tier,omitempty"`     // filter left
+	Reason       string     `json:"reason,omitempty"`
+	Until        *time.Time `json:"until,omitempty"` // end of a ban
+
+	// Missed counts the events of this type the stream lost before this
+	// one, because the aggregator no longer held them when the client
+	// reconnected.  It is not set across an aggregator restart, when
+	// Stream changes.
+	Missed uint64 `json:"-"`
+}
+
+// EventsOptions selects the events Events streams.
+type EventsOptions struct {
+	// Types limits the stream to these event types; all when empty.
+	Types []string
+
+	// Since and Stream resume after an event received earlier, e.g. by a
+	// forwarder that persists the Seq and Stream of the last event it
+	// shipped.  Zero starts with the next event.
+	Since  uint64
+	Stream string
+}
+
+// eventCursor tracks where a stream is, to resume it and tell gaps.
+type eventCursor struct {
+	opts    EventsOptions
+	typeSeq map[string]uint64 // of the last event of each type in Stream
+}
+
+// next records ev and sets its Missed, reporting false for an event
+// already received.
+func (cur *eventCursor) next(ev *Event) bool {
+	if ev.Stream != cur.opts.Stream {
+		cur.opts.Stream, cur.typeSeq = ev.Stream, make(map[string]uint64)
+	} else if ev.Seq <= cur.opts.Since {
+		return false
+	}
+	if last, ok := cur.typeSeq[ev.Type]; ok && ev.TypeSeq > last+1 {
+		ev.Missed = ev.TypeSeq - last - 1
+	}
+	cur.typeSeq[ev.Type] = ev.TypeSeq
+	cur.opts.Since = ev.Seq
+	return true
+}
+
+func (cur *eventCursor) query() string {
+	q := url.Values{}
+	if len(cur.opts.Types) > 0 {
+		q.Set("type", strings.Join(cur.opts.Types, ","))
+	}
+	if cur.opts.Since > 0 || cur.opts.Stream != "" {
+		q.Set("since", strconv.FormatUint(cur.opts.Since, 10))
+		q.Set("stream", cur.opts.Stream)
+	}
+	return q.Encode()
+}
+
+// Events streams the aggregator's events (GET /events) until ctx is
+// cancelled, at which point the returned channel is closed.
+//
+// As with Watch, the first connection is made synchronously and dropped
+// ones are retried with exponential backoff.  Reconnects resume after the
+// last event received, so no event is delivered twice; those the
+// aggregator no longer held are counted in the next event's Missed.
+func (c *Client) Events(ctx context.Context, opts EventsOptions) (<-chan Event, error) {
+	cur := &eventCursor{opts: opts, typeSeq: make(map[string]uint64)}
+	resp, err := c.openEvents(ctx, cur.query())
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event, 16)
+	go c.eventsLoop(ctx, resp, cur, events)
+	return events, nil
+}
+
+func (c *Client) eventsLoop(ctx context.Context, resp *http.Response, cur *eventCursor, events chan<- Event) {
+	defer close(events)
+
+	for attempt := 0; ; {
+		if resp != nil {
+			readEvents(ctx, resp.Body, cur, events)
+			resp.Body.Close()
+			resp = nil
+			attempt = 0
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case <-time.After(c.backoff(attempt)):
+		case <-ctx.Done():
+			return
+		}
+		attempt++
+
+		var err error
+		if resp, err = c.openEvents(ctx, cur.query()); err != nil {
+			resp = nil
+		}
+	}
+}
+
+// readEvents delivers the events of a Server-Sent Events body until it
+// ends or ctx is cancelled.
+func readEvents(ctx context.Context, body io.Reader, cur *eventCursor, events chan<- Event) {
+	r := bufio.NewReader(body)
+	var data strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			data.WriteString(strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue // id, event, and comments
+		}
+		var ev Event
+		err = json.Unmarshal([]byte(data.String()), &ev)
+		data.Reset()
+		if err != nil || !cur.next(&ev) {
+			continue
+		}
+		select {
+		case events <- ev:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// openEvents opens the event stream.  The client's timeout would cut it
+// short, so only its transport is used.
+func (c *Client) openEvents(ctx context.Context, query string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base.String()+"/events?"+query, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	c.authorize(req.Header)
+
+	stream := &http.Client{Transport: c.http.Transport, Jar: c.http.Jar}
+	resp, err := stream.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newHTTPError(resp.StatusCode, data)
+	}
+	return resp, nil
+}
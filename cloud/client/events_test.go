@@ -0,0 +1,66 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventsResumesAndCountsMissed(t *testing.T) {
+	var mu sync.Mutex
+	var queries []string
+	sse := func(w http.ResponseWriter, seq, typeSeq uint64) {
+		fmt.Fprintf(w, "id: s1:%d\nevent: promoted\ndata: {\"schema\":1,\"seq\":%d,\"type_seq\":%d,\"stream\":\"s1\",\"type\":\"promoted\"}\n\n", seq, seq, typeSeq)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		queries = append(queries, r.URL.RawQuery)
+		n := len(queries)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		switch n {
+		case 1:
+			sse(w, 4, 1)
+			fmt.Fprint(w, ": ping\n\n")
+			sse(w, 6, 2)
+		case 2:
+			sse(w, 6, 2) // already received
+			sse(w, 9, 5)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+	c, err := New(Config{BaseURL: srv.URL, MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	events, err := c.Events(ctx, EventsOptions{Types: []string{"promoted"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []Event
+	for ev := range events {
+		if got = append(got, ev); len(got) == 3 {
+			cancel()
+		}
+	}
+	if len(got) != 3 || got[0].Seq != 4 || got[1].Seq != 6 || got[2].Seq != 9 {
+		t.Fatalf("Expected seqs 4, 6, 9 without the repeat, got %+v", got)
+	}
+	if got[1].Missed != 0 || got[2].Missed != 2 {
+		t.Errorf("Expected two promotions missed before seq 9, got %d %d", got[1].Missed, got[2].Missed)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if queries[0] != "type=promoted" || queries[1] != "since=6&stream=s1&type=promoted" {
+		t.Errorf("Expected the reconnect to resume after seq 6, got %q", queries)
+	}
+}
@@ -0,0 +1,434 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// FakeServer is an in-process stand-in for the aggregator, for tests of
+// code built on this package.  It serves /ingest, /ingest/batch, /check,
+// /filter, /filter/params, /limits, and /ws with the same wire formats as the real aggregator,
+// including signed envelopes and resume via /ws?last_version=, but
+// promotion is explicit (Add) or delegated to the Promote hook.  It
+// ignores ?deltas=, sending every subscriber the resume delta, so a
+// bloom-mode client has to resync on its own.  Restart simulates an
+// aggregator restarting without its saved state.
+type FakeServer struct {
+	// URL is the base URL to pass in Config.BaseURL.
+	URL string
+
+	// Promote, if set, is consulted for every ingested report; returning
+	// true adds the address to the filter and pushes it.
+	Promote func(IOCReport) bool
+
+	srv  *httptest.Server
+	priv ed25519.PrivateKey
+
+	mu               sync.Mutex
+	reports          []IOCReport
+	entries          map[string]bool
+	version          uint64
+	history          []fakeChange // change made at each version after historyBase
+	historyBase      uint64
+	params           BloomParams
+	instanceUUID     string
+	epoch            uint64
+	conns            map[*websocket.Conn]bool
+	snapshotRequests int
+	corruptNext      bool
+}
+
+// fakeChange is one address added or removed.
+type fakeChange struct {
+	address string
+	removed bool
+}
+
+// NewFakeServer starts a FakeServer.  Call Close when done.
+func NewFakeServer() *FakeServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	f := &FakeServer{
+		priv:    priv,
+		entries: make(map[string]bool),
+		params:  BloomParams{Bits: 1 << 20, Hashes: 7, Hash: DefaultHash},
+		conns:   make(map[*websocket.Conn]bool),
+	}
+	f.newIdentityLocked()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ingest", f.handleIngest)
+	mux.HandleFunc("/ingest/batch", f.handleIngestBatch)
+	mux.HandleFunc("/check", f.handleCheck)
+	mux.HandleFunc("/filter", f.handleFilter)
+	mux.HandleFunc("/filter/params", f.handleParams)
+	mux.HandleFunc("/limits", f.handleLimits)
+	mux.HandleFunc("/ws", f.handleWS)
+
+	f.srv = httptest.NewServer(mux)
+	f.URL = f.srv.URL
+	return f
+}
+
+// Close drops all subscribers and shuts the server down.
+func (f *FakeServer) Close() {
+	f.DropConnections()
+	f.srv.Close()
+}
+
+// Add promotes addresses into the filter, bumping the version once per
+// address, and pushes the resulting filter to connected subscribers.
+func (f *FakeServer) Add(addresses ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, addr := range addresses {
+		f.entries[addr] = true
+		f.version++
+		f.history = append(f.history, fakeChange{address: addr})
+	}
+	f.pushLocked()
+}
+
+// Remove demotes addresses from the filter, bumping the version once per
+// address, and pushes the resulting filter to connected subscribers.
+func (f *FakeServer) Remove(addresses ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, addr := range addresses {
+		delete(f.entries, addr)
+		f.version++
+		f.history = append(f.history, fakeChange{address: addr, removed: true})
+	}
+	f.pushLocked()
+}
+
+// ForgetHistory drops the retained changes, so subscribers reconnecting
+// from an older version get a full resync snapshot.
+func (f *FakeServer) ForgetHistory() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.history = nil
+	f.historyBase = f.version
+}
+
+// Restart simulates the aggregator restarting without its saved state:
+// the filter, its version and history are lost, the epoch advances, and
+// every subscriber is dropped.  Reconnecting clients name the old epoch
+// and get a resync snapshot.
+func (f *FakeServer) Restart() {
+	f.DropConnections()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries = make(map[string]bool)
+	f.version = 0
+	f.history = nil
+	f.historyBase = 0
+	f.newIdentityLocked()
+}
+
+// Epoch returns the server's current epoch.
+func (f *FakeServer) Epoch() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.epoch
+}
+
+// newIdentityLocked draws a new instance UUID and advances the epoch.
+func (f *FakeServer) newIdentityLocked() {
+	var id [16]byte
+	rand.Read(id[:])
+	f.instanceUUID = hex.EncodeToString(id[:])
+	f.epoch++
+}
+
+// SetParams changes the filter parameters, as a reconfigured aggregator
+// would after a restart, and pushes the filter to connected subscribers.
+// The version and history are kept.
+func (f *FakeServer) SetParams(p BloomParams) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.params = p
+	f.pushLocked()
+}
+
+// CorruptNextPush alters one byte of the next pushed payload after its
+// checksum is computed, as a misbehaving proxy would.  The payload stays
+// valid JSON, so only the checksum catches it.
+func (f *FakeServer) CorruptNextPush() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.corruptNext = true
+}
+
+// PublicKey returns the key the server signs filter payloads with.
+func (f *FakeServer) PublicKey() ed25519.PublicKey {
+	return f.priv.Public().(ed25519.PublicKey)
+}
+
+// Reports returns every report received so far.
+func (f *FakeServer) Reports() []IOCReport {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]IOCReport(nil), f.reports...)
+}
+
+// SnapshotRequests returns how many times GET /filter was called.
+func (f *FakeServer) SnapshotRequests() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.snapshotRequests
+}
+
+// Subscribers returns the number of connected WebSocket subscribers.
+func (f *FakeServer) Subscribers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.conns)
+}
+
+// DropConnections closes every WebSocket connection, simulating a network
+// failure.  Clients are expected to reconnect.
+func (f *FakeServer) DropConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for conn := range f.conns {
+		conn.Close()
+		delete(f.conns, conn)
+	}
+}
+
+func (f *FakeServer) payloadLocked() []byte {
+	p := filterPayload{FormatVersion: SupportedFormatVersion, Version: f.version, Entries: []string{}, BloomParams: f.params}
+	for addr := range f.entries {
+		p.Entries = append(p.Entries, addr)
+	}
+	sort.Strings(p.Entries)
+	p.Count = len(p.Entries)
+	data, _ := json.Marshal(p)
+	return data
+}
+
+func (f *FakeServer) sign(payload []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(f.priv, SigningMessage(f.version, payload)))
+}
+
+func (f *FakeServer) envelopeLocked() FilterEnvelope {
+	payload := f.payloadLocked()
+	return FilterEnvelope{
+		Kind:            KindSnapshot,
+		Version:         f.version,
+		ToVersion:       f.version,
+		KeyID:           KeyID(f.PublicKey()),
+		Signature:       f.sign(payload),
+		Payload:         payload,
+		PayloadChecksum: payloadChecksum(payload),
+		InstanceUUID:    f.instanceUUID,
+		Epoch:           f.epoch,
+	}
+}
+
+// resumeLocked is the reply to a subscriber reconnecting from last in
+// epoch: one delta netting everything since, or a resync snapshot.
+func (f *FakeServer) resumeLocked(last, epoch uint64) FilterEnvelope {
+	if (epoch != 0 && epoch != f.epoch) || last > f.version || last < f.historyBase {
+		env := f.envelopeLocked()
+		env.Resync = true
+		return env
+	}
+	d := filterDelta{Version: f.version, FromVersion: last, Added: []string{}, Removed: []string{}, BloomParams: f.params}
+	final := make(map[string]bool) // address -> removed
+	for _, c := range f.history[last-f.historyBase:] {
+		final[c.address] = c.removed
+	}
+	for addr, removed := range final {
+		if removed {
+			d.Removed = append(d.Removed, addr)
+		} else {
+			d.Added = append(d.Added, addr)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	d.RequiresResync = len(d.Removed) > 0
+	payload, _ := json.Marshal(d)
+	return FilterEnvelope{
+		Kind:            KindDelta,
+		Version:         f.version,
+		FromVersion:     last,
+		ToVersion:       f.version,
+		KeyID:           KeyID(f.PublicKey()),
+		Signature:       f.sign(payload),
+		Payload:         payload,
+		PayloadChecksum: payloadChecksum(payload),
+		InstanceUUID:    f.instanceUUID,
+		Epoch:           f.epoch,
+	}
+}
+
+func (f *FakeServer) pushLocked() {
+	env := f.envelopeLocked()
+	if f.corruptNext {
+		f.corruptNext = false
+		env.Payload = corruptPayload(env.Payload)
+	}
+	for conn := range f.conns {
+		if err := conn.WriteJSON(env); err != nil {
+			conn.Close()
+			delete(f.conns, conn)
+		}
+	}
+}
+
+// corruptPayload changes the first character of the first entry in a
+// copy of payload.
+func corruptPayload(payload []byte) []byte {
+	data := append([]byte(nil), payload...)
+	marker := []byte(`"entries":["`)
+	if i := bytes.Index(data, marker); i >= 0 && i+len(marker) < len(data) {
+		data[i+len(marker)] ^= 1
+	}
+	return data
+}
+
+func (f *FakeServer) ingest(r IOCReport) ReportResult {
+	f.mu.Lock()
+	f.reports = append(f.reports, r)
+	f.mu.Unlock()
+
+	res := ReportResult{Status: StatusRecorded, ReasonCode: ReasonBelowThreshold, Accepted: true}
+	if f.Promote != nil && f.Promote(r) {
+		f.Add(r.Address)
+		res.ReasonCode, res.AddedToFilter = ReasonInFilter, true
+	}
+	f.mu.Lock()
+	res.FilterVersion = f.version
+	f.mu.Unlock()
+	return res
+}
+
+func (f *FakeServer) handleIngest(w http.ResponseWriter, r *http.Request) {
+	var report IOCReport
+	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	json.NewEncoder(w).Encode(f.ingest(report))
+}
+
+func (f *FakeServer) handleIngestBatch(w http.ResponseWriter, r *http.Request) {
+	var reports []IOCReport
+	if err := json.NewDecoder(r.Body).Decode(&reports); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	results := make([]ReportResult, len(reports))
+	for i, report := range reports {
+		results[i] = f.ingest(report)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+func (f *FakeServer) handleCheck(w http.ResponseWriter, r *http.Request) {
+	address := r.URL.Query().Get("address")
+	f.mu.Lock()
+	res := CheckResult{Address: address, Flagged: f.entries[address], FilterVersion: f.version}
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(res)
+}
+
+func (f *FakeServer) handleFilter(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.snapshotRequests++
+	env := f.envelopeLocked()
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(HeaderFilterVersion, strconv.FormatUint(env.Version, 10))
+	w.Header().Set(HeaderFilterKeyID, env.KeyID)
+	w.Header().Set(HeaderFilterSignature, env.Signature)
+	w.Header().Set(HeaderPayloadChecksum, env.PayloadChecksum)
+	w.Header().Set(HeaderInstanceUUID, env.InstanceUUID)
+	w.Header().Set(HeaderFilterEpoch, strconv.FormatUint(env.Epoch, 10))
+	w.Write(env.Payload)
+}
+
+// fakeLimits is what the FakeServer serves at /limits: the aggregator's
+// defaults.
+const fakeLimits = `{"max_body_bytes":1048576,"max_report_body_bytes":65536,"max_batch_body_bytes":8388608,` +
+	`"max_batch_reports":1000,"max_import_body_bytes":268435456,"max_decompressed_bytes":33554432,` +
+	`"read_header_timeout":"10s","read_timeout":"1m0s","idle_timeout":"2m0s"}`
+
+func (f *FakeServer) handleLimits(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(fakeLimits))
+}
+
+func (f *FakeServer) handleParams(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	p := FilterParams{FormatVersion: SupportedFormatVersion, BloomParams: f.params, Count: len(f.entries), Version: f.version}
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(p)
+}
+
+func (f *FakeServer) handleWS(w http.ResponseWriter, r *http.Request) {
+	var last, epoch uint64
+	resume := r.URL.Query().Has("last_version")
+	if resume {
+		v, err := strconv.ParseUint(r.URL.Query().Get("last_version"), 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid last_version", http.StatusBadRequest)
+			return
+		}
+		last = v
+	}
+	if raw := r.URL.Query().Get("epoch"); raw != "" {
+		v, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			http.Error(w, "Invalid epoch", http.StatusBadRequest)
+			return
+		}
+		epoch = v
+	}
+
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	f.mu.Lock()
+	f.conns[conn] = true
+	env := f.envelopeLocked()
+	if resume {
+		env = f.resumeLocked(last, epoch)
+	}
+	err = conn.WriteJSON(env)
+	f.mu.Unlock()
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	// Drain until the client (or DropConnections) closes the socket.
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			f.mu.Lock()
+			delete(f.conns, conn)
+			f.mu.Unlock()
+			conn.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Limits are the aggregator's request limits, as served by GET /limits.
+// Byte counts and timeouts of zero are unlimited.
+type Limits struct {
+	MaxBodyBytes         int64 // routes without a limit of their own
+	MaxReportBodyBytes   int64 // POST /ingest
+	MaxBatchBodyBytes    int64 // POST /ingest/batch
+	MaxBatchReports      int   // reports per batch
+	MaxImportBodyBytes   int64 // feed import, merge, snapshot import
+	MaxDecompressedBytes int64 // a gzip body once inflated
+	ReadHeaderTimeout    time.Duration
+	ReadTimeout          time.Duration // to send a whole request
+	IdleTimeout          time.Duration
+}
+
+// Limits fetches the aggregator's request limits, to size batches by.
+func (c *Client) Limits(ctx context.Context) (Limits, error) {
+	var resp struct {
+		MaxBodyBytes         int64  `json:"max_body_bytes"`
+		MaxReportBodyBytes   int64  `json:"max_report_body_bytes"`
+		MaxBatchBodyBytes    int64  `json:"max_batch_body_bytes"`
+		MaxBatchReports      int    `json:"max_batch_reports"`
+		MaxImportBodyBytes   int64  `json:"max_import_body_bytes"`
+		MaxDecompressedBytes int64  `json:"max_decompressed_bytes"`
+		ReadHeaderTimeout    string `json:"read_header_timeout"`
+		ReadTimeout          string `json:"read_timeout"`
+		IdleTimeout          string `json:"idle_timeout"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/limits", nil, &resp); err != nil {
+		return Limits{}, err
+	}
+	l := Limits{
+		MaxBodyBytes:         resp.MaxBodyBytes,
+		MaxReportBodyBytes:   resp.MaxReportBodyBytes,
+		MaxBatchBodyBytes:    resp.MaxBatchBodyBytes,
+		MaxBatchReports:      resp.MaxBatchReports,
+		MaxImportBodyBytes:   resp.MaxImportBodyBytes,
+		MaxDecompressedBytes: resp.MaxDecompressedBytes,
+	}
+	for _, d := range []struct {
+		raw string
+		out *time.Duration
+	}{
+		{resp.ReadHeaderTimeout, &l.ReadHeaderTimeout},
+		{resp.ReadTimeout, &l.ReadTimeout},
+		{resp.IdleTimeout, &l.IdleTimeout},
+	} {
+		if d.raw == "" {
+			continue
+		}
+		var err error
+		if *d.out, err = time.ParseDuration(d.raw); err != nil {
+			return Limits{}, err
+		}
+	}
+	return l, nil
+}
@@ -0,0 +1,18 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimits(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	c := newTestClient(t, f)
+
+	l, err := c.Limits(context.Background())
+	if err != nil || l.MaxBatchReports != 1000 || l.MaxBatchBodyBytes != 8<<20 || l.ReadTimeout != time.Minute {
+		t.Errorf("Expected the server's limits, got %+v (%v)", l, err)
+	}
+}
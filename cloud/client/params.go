@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// SupportedFormatVersion is the newest filter format this SDK reads.
+const SupportedFormatVersion = 1
+
+// Hash algorithms this SDK knows.
+const (
+	HashFNV1a = "fnv1a-64"
+	HashXXH64 = "xxh64"
+)
+
+// DefaultHash is the hash algorithm assumed for payloads that predate
+// the format header.
+const DefaultHash = HashFNV1a
+
+// FormatVersionError is returned for a filter payload in a format version
+// this SDK cannot read.  Upgrade the SDK.
+type FormatVersionError struct {
+	Version int
+}
+
+func (e *FormatVersionError) Error() string {
+	return fmt.Sprintf("aegis: unsupported filter format version %d, this SDK reads up to %d", e.Version, SupportedFormatVersion)
+}
+
+// HashError is returned for a filter encoded with a hash algorithm this
+// SDK does not know.  Upgrade the SDK.
+type HashError struct {
+	Hash string
+}
+
+func (e *HashError) Error() string {
+	return fmt.Sprintf("aegis: unknown filter hash algorithm %q", e.Hash)
+}
+
+// BloomParams are the bit size, hash count and hash algorithm a filter is
+// encoded with.  A local filter is only updated in place by payloads with
+// the same parameters.
+type BloomParams struct {
+	Bits   uint64 `json:"bits"`
+	Hashes uint   `json:"hashes"`
+	Hash   string `json:"hash"`
+}
+
+// normalize fills in the hash of payloads without one.
+func (p BloomParams) normalize() BloomParams {
+	if p.Hash == "" && p.Bits != 0 {
+		p.Hash = DefaultHash
+	}
+	return p
+}
+
+// FilterParams is the header of the aggregator's filter, as served by
+// GET /filter/params.
+type FilterParams struct {
+	FormatVersion int `json:"format_version"`
+	BloomParams
+	Count   int    `json:"count"`
+	Version uint64 `json:"version"`
+}
+
+// checkFormat rejects a format version the SDK cannot read.  Zero means
+// a payload from before the header, which is version 1.
+func checkFormat(v int) error {
+	if v < 0 || v > SupportedFormatVersion {
+		return &FormatVersionError{Version: v}
+	}
+	return nil
+}
+
+// checkParams rejects a payload whose format version or hash algorithm
+// the SDK does not know.
+func checkParams(v int, p BloomParams) error {
+	if err := checkFormat(v); err != nil {
+		return err
+	}
+	switch p.Hash {
+	case "", HashFNV1a, HashXXH64:
+		return nil
+	}
+	return &HashError{Hash: p.Hash}
+}
+
+// Params fetches the aggregator's filter parameters without the entries,
+// failing with a *FormatVersionError or *HashError if this SDK cannot read
+// its format.
+// Call it before Watch to find out early.
+func (c *Client) Params(ctx context.Context) (FilterParams, error) {
+	var p FilterParams
+	if err := c.do(ctx, http.MethodGet, "/filter/params", nil, &p); err != nil {
+		return FilterParams{}, err
+	}
+	p.BloomParams = p.BloomParams.normalize()
+	return p, checkParams(p.FormatVersion, p.BloomParams)
+}
+
+// LocalParams returns the parameters of the locally synced filter, zero
+// before the first snapshot.
+func (c *Client) LocalParams() BloomParams {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.params
+}
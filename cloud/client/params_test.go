@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDecodeFilterPayloadFixtures(t *testing.T) {
+	want := BloomParams{Bits: 1024, Hashes: 3, Hash: DefaultHash}
+	for _, name := range []string{"filter_legacy.json", "filter_v1.json"} {
+		data, _ := os.ReadFile(filepath.Join("testdata", name))
+		p, err := decodeFilterPayload(data)
+		if err != nil || p.BloomParams != want || p.Version != 7 || len(p.Entries) != 2 {
+			t.Errorf("%s: expected v7 with two entries and %+v, got %+v (%v)", name, want, p, err)
+		}
+	}
+
+	data, _ := os.ReadFile(filepath.Join("testdata", "filter_future.json"))
+	_, err := decodeFilterPayload(data)
+	var fv *FormatVersionError
+	if !errors.As(err, &fv) || fv.Version != 2 {
+		t.Errorf("Expected a FormatVersionError for version 2, got %v", err)
+	}
+
+	_, err = decodeFilterPayload([]byte(`{"format_version":1,"version":7,"bits":1024,"hashes":3,"hash":"murmur3"}`))
+	var he *HashError
+	if !errors.As(err, &he) || he.Hash != "murmur3" {
+		t.Errorf("Expected a HashError for murmur3, got %v", err)
+	}
+	if p, err := decodeFilterPayload([]byte(`{"format_version":1,"version":7,"bits":1024,"hashes":3,"hash":"xxh64"}`)); err != nil || p.Hash != HashXXH64 {
+		t.Errorf("Expected xxh64 read, got %+v (%v)", p, err)
+	}
+}
+
+func TestParamsChangeForcesFullReplace(t *testing.T) {
+	f := NewFakeServer()
+	defer f.Close()
+	f.Add("0xBefore")
+	c, _ := New(Config{BaseURL: f.URL, MinBackoff: 200 * time.Millisecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p, err := c.Params(ctx)
+	if err != nil || p.FormatVersion != SupportedFormatVersion || p.Count != 1 || p.Hash != DefaultHash {
+		t.Fatalf("Expected the server's filter params, got %+v (%v)", p, err)
+	}
+	updates, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	if u := nextUpdate(t, updates); u.Params != p.BloomParams || c.LocalParams() != p.BloomParams {
+		t.Fatalf("Expected the local filter at %+v, got %+v", p.BloomParams, u.Params)
+	}
+
+	resized := BloomParams{Bits: 1 << 22, Hashes: 9, Hash: DefaultHash}
+	f.SetParams(resized)
+	if u := nextUpdate(t, updates); !u.Resync || u.Params != resized || c.LocalParams() != resized {
+		t.Fatalf("Expected a pushed snapshot with new params reported as a resync, got %+v", u)
+	}
+
+	// A delta across a parameter change is refused in favour of a fresh
+	// snapshot.
+	f.DropConnections()
+	f.Add("0xMissed")
+	f.SetParams(BloomParams{Bits: 1 << 23, Hashes: 9, Hash: DefaultHash})
+	if u := nextUpdate(t, updates); !u.Resync || !c.Contains("0xMissed") || u.Params.Bits != 1<<23 {
+		t.Fatalf("Expected a full replace at the new params, got %+v", u)
+	}
+	if f.SnapshotRequests() != 1 {
+		t.Errorf("Expected the delta replaced by one snapshot fetch, got %d", f.SnapshotRequests())
+	}
+}
@@ -0,0 +1,291 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// ErrStreamClosed is returned by StreamSession.Report when the session is
+// closed, or its connection drops before the report's status arrives.
+// The report may or may not have been recorded; sending it again with the
+// same ReportID is safe.
+var ErrStreamClosed = errors.New("aegis: stream closed")
+
+// streamFrame is one line of a /stream body, in either direction.
+type streamFrame struct {
+	Type     string          `json:"type"`
+	ID       string          `json:"id,omitempty"`
+	Report   *IOCReport      `json:"report,omitempty"`
+	Result   *ReportResult   `json:"result,omitempty"`
+	Envelope json.RawMessage `json:"envelope,omitempty"`
+}
+
+// StreamSession submits reports and receives filter updates over one
+// HTTP/2 request to POST /stream, for gateways whose egress allows
+// HTTP/2 but not WebSockets.
+type StreamSession struct {
+	c         *Client
+	ctx       context.Context
+	cancel    context.CancelFunc
+	transport http.RoundTripper
+	updates   chan FilterUpdate
+
+	mu     sync.Mutex
+	conn   *streamConn   // nil while reconnecting
+	ready  chan struct{} // closed once conn is set
+	nextID uint64
+}
+
+// streamConn is one /stream request.
+type streamConn struct {
+	body   *io.PipeWriter
+	resp   *http.Response
+	cancel context.CancelFunc
+	done   chan struct{} // closed when the connection drops
+
+	// The aggregator answers reports in order, so statuses are matched
+	// to the reports written first, even one it could not read the ID of.
+	writeMu sync.Mutex // held while writing, which flow control may block
+	mu      sync.Mutex
+	pending []chan ReportResult
+}
+
+// Stream opens a report stream, keeping the local filter in sync as Watch
+// does until ctx is cancelled or Close is called.  The aggregator must
+// serve HTTP/2: over TLS, or in cleartext with h2c for an http:// base URL.
+//
+// As with Watch, the first connection is made synchronously and dropped
+// ones are retried with exponential backoff, resuming from the local
+// version.
+func (c *Client) Stream(ctx context.Context) (*StreamSession, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	s := &StreamSession{
+		c:         c,
+		ctx:       ctx,
+		cancel:    cancel,
+		transport: c.streamTransport(),
+		updates:   make(chan FilterUpdate, 16),
+		ready:     make(chan struct{}),
+	}
+	conn, err := s.open()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go s.loop(conn)
+	return s, nil
+}
+
+// Updates returns the filter updates received, closed once the session
+// ends.
+func (s *StreamSession) Updates() <-chan FilterUpdate { return s.updates }
+
+// Close ends the session.
+func (s *StreamSession) Close() { s.cancel() }
+
+// Report submits a report and waits for its result, on the current
+// connection or, while reconnecting, the next.  As in ReportBatch, a
+// report the aggregator refused is returned as a result with Status
+// StatusRejected.
+func (s *StreamSession) Report(ctx context.Context, report IOCReport) (ReportResult, error) {
+	s.mu.Lock()
+	for s.conn == nil {
+		ready := s.ready
+		s.mu.Unlock()
+		select {
+		case <-ready:
+		case <-ctx.Done():
+			return ReportResult{}, ctx.Err()
+		case <-s.ctx.Done():
+			return ReportResult{}, ErrStreamClosed
+		}
+		s.mu.Lock()
+	}
+	conn := s.conn
+	s.nextID++
+	id := strconv.FormatUint(s.nextID, 10)
+	s.mu.Unlock()
+
+	result, err := conn.report(streamFrame{Type: "report", ID: id, Report: &report})
+	if err != nil {
+		return ReportResult{}, ErrStreamClosed
+	}
+	select {
+	case res := <-result:
+		return res, nil
+	case <-conn.done:
+		return ReportResult{}, ErrStreamClosed
+	case <-ctx.Done():
+		return ReportResult{}, ctx.Err()
+	}
+}
+
+func (s *StreamSession) loop(conn *streamConn) {
+	defer close(s.updates)
+	defer s.cancel()
+
+	for attempt := 0; ; {
+		if conn != nil {
+			s.serve(conn)
+			conn = nil
+			attempt = 0
+		}
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case <-time.After(s.c.backoff(attempt)):
+		case <-s.ctx.Done():
+			return
+		}
+		attempt++
+
+		var err error
+		if conn, err = s.open(); err != nil {
+			conn = nil
+		}
+	}
+}
+
+// serve makes conn the session's connection and reads it until it drops.
+func (s *StreamSession) serve(conn *streamConn) {
+	s.mu.Lock()
+	s.conn = conn
+	close(s.ready)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.conn, s.ready = nil, make(chan struct{})
+		s.mu.Unlock()
+		conn.close()
+	}()
+
+	var chunks Assembler
+	r := bufio.NewReader(conn.resp.Body)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		var frame streamFrame
+		if json.Unmarshal(line, &frame) != nil {
+			continue
+		}
+		switch frame.Type {
+		case "status":
+			if frame.Result == nil {
+				continue
+			}
+			frame.Result.fillStatus()
+			conn.resolve(*frame.Result)
+		case "filter":
+			update, ok := s.c.receive(s.ctx, &chunks, frame.Envelope)
+			if !ok {
+				continue
+			}
+			select {
+			case s.updates <- update:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// open starts a /stream request, resuming from the local filter.
+func (s *StreamSession) open() (*streamConn, error) {
+	u := *s.c.base
+	u.Path += "/stream"
+	u.RawQuery = s.c.subscriptionQuery().Encode()
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	body, w := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	s.c.authorize(req.Header)
+
+	resp, err := s.transport.RoundTrip(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer cancel()
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, newHTTPError(resp.StatusCode, data)
+	}
+	return &streamConn{body: w, resp: resp, cancel: cancel, done: make(chan struct{})}, nil
+}
+
+// streamTransport returns the transport /stream requests go through:
+// cleartext HTTP/2 for an http:// base URL, otherwise the client's own,
+// which negotiates HTTP/2 over TLS.
+func (c *Client) streamTransport() http.RoundTripper {
+	if c.base.Scheme == "http" {
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+			ReadIdleTimeout: time.Minute,
+		}
+	}
+	if c.http.Transport != nil {
+		return c.http.Transport
+	}
+	return http.DefaultTransport
+}
+
+// report sends a report frame, returning the channel its result is
+// delivered on.
+func (sc *streamConn) report(frame streamFrame) (<-chan ReportResult, error) {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return nil, err
+	}
+	result := make(chan ReportResult, 1)
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+	sc.mu.Lock()
+	sc.pending = append(sc.pending, result)
+	sc.mu.Unlock()
+	if _, err := sc.body.Write(append(data, '\n')); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// resolve delivers the result of the oldest report awaiting one.
+func (sc *streamConn) resolve(res ReportResult) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if len(sc.pending) > 0 {
+		sc.pending[0] <- res
+		sc.pending = sc.pending[1:]
+	}
+}
+
+func (sc *streamConn) close() {
+	close(sc.done)
+	sc.body.CloseWithError(ErrStreamClosed)
+	sc.cancel()
+	sc.resp.Body.Close()
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/aegis-protocol/swarm/types"
+)
+
+// Response headers carrying the signature of a GET /filter payload.
+const (
+	HeaderFilterVersion   = "X-Aegis-Filter-Version"
+	HeaderFilterKeyID     = "X-Aegis-Key-Id"
+	HeaderFilterSignature = "X-Aegis-Signature"
+
+	// Sent by aggregators that replicate with peers; not signed.
+	HeaderFilterInstance       = "X-Aegis-Instance"
+	HeaderFilterLogicalVersion = "X-Aegis-Logical-Version"
+
+	// The aggregator's instance UUID and epoch; not signed.
+	HeaderInstanceUUID = "X-Aegis-Instance-UUID"
+	HeaderFilterEpoch  = "X-Aegis-Epoch"
+
+	// HeaderPayloadChecksum is the CRC-32C of the payload (see
+	// VerifyChecksum).
+	HeaderPayloadChecksum = "X-Aegis-Payload-CRC32C"
+)
+
+var (
+	// ErrUnsigned is returned for envelopes without a signature or key ID.
+	ErrUnsigned = errors.New("aegis: filter payload is not signed")
+
+	// ErrKeyMismatch is returned when the envelope names a different key
+	// than the one supplied for verification.
+	ErrKeyMismatch = errors.New("aegis: filter payload signed by a different key")
+
+	// ErrBadSignature is returned when the signature does not verify.
+	ErrBadSignature = errors.New("aegis: filter signature verification failed")
+
+	// ErrChecksumMismatch is returned when a payload does not match its
+	// checksum, as when a proxy truncated or altered it.
+	ErrChecksumMismatch = errors.New("aegis: filter payload checksum mismatch")
+)
+
+// The signed envelope the aggregator pushes around every serialized
+// filter, or around a delta when resuming, and the change summary it
+// carries (see the types package, which the aggregator shares).
+type (
+	FilterEnvelope = types.FilterEnvelope
+	ChangeCounts   = types.ChangeCounts
+	FilterSummary  = types.FilterSummary
+)
+
+// Envelope kinds.
+const (
+	KindSnapshot = types.KindSnapshot
+	KindDelta    = types.KindDelta
+	KindChunk    = types.KindChunk
+)
+
+// KeyID derives the identifier the aggregator uses for a public key: the
+// first eight bytes of its SHA-256, hex encoded.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// SigningMessage is the byte string that is signed for a filter payload:
+// the big-endian version followed by the SHA-256 of the payload.
+func SigningMessage(version uint64, payload []byte) []byte {
+	sum := sha256.Sum256(payload)
+	msg := make([]byte, 8, 8+len(sum))
+	binary.BigEndian.PutUint64(msg, version)
+	return append(msg, sum[:]...)
+}
+
+// VerifyFilterPayload checks that envelope was signed by pub and that the
+// payload inside it encodes the signed version.
+func VerifyFilterPayload(pub ed25519.PublicKey, envelope []byte) error {
+	var env FilterEnvelope
+	if err := json.Unmarshal(envelope, &env); err != nil {
+		return fmt.Errorf("aegis: invalid filter envelope: %w", err)
+	}
+	return verifyEnvelope(pub, env)
+}
+
+// VerifySignature checks a detached signature, as served in the GET
+// /filter response headers, over version and payload.
+func VerifySignature(pub ed25519.PublicKey, keyID, signature string, version uint64, payload []byte) error {
+	if keyID == "" || signature == "" {
+		return ErrUnsigned
+	}
+	if keyID != KeyID(pub) {
+		return ErrKeyMismatch
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || !ed25519.Verify(pub, SigningMessage(version, payload), sig) {
+		return ErrBadSignature
+	}
+
+	var inner struct {
+		Version uint64 `json:"version"`
+	}
+	if err := json.Unmarshal(payload, &inner); err != nil || inner.Version != version {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// VerifyChecksum checks a payload against its hex CRC-32C, as carried in
+// an envelope's PayloadChecksum or the HeaderPayloadChecksum header.  An
+// empty checksum, from an aggregator that sends none, passes.
+func VerifyChecksum(checksum string, payload []byte) error {
+	if checksum != "" && payloadChecksum(payload) != checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func payloadChecksum(payload []byte) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], crc32.Checksum(payload, castagnoli))
+	return hex.EncodeToString(b[:])
+}
+
+func verifyEnvelope(pub ed25519.PublicKey, env FilterEnvelope) error {
+	return VerifySignature(pub, env.KeyID, env.Signature, env.Version, env.Payload)
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func signedEnvelope(t *testing.T, priv ed25519.PrivateKey, version uint64, payload string) []byte {
+	t.Helper()
+	pub := priv.Public().(ed25519.PublicKey)
+	f := &FakeServer{priv: priv, version: version}
+	env := FilterEnvelope{Version: version, KeyID: KeyID(pub), Signature: f.sign([]byte(payload)), Payload: json.RawMessage(payload)}
+	data, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerifyFilterPayload(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(rand.Reader)
+	payload := `{"version":3,"entries":["0xA"],"count":1}`
+	env := signedEnvelope(t, priv, 3, payload)
+
+	if err := VerifyFilterPayload(pub, env); err != nil {
+		t.Fatalf("Expected valid envelope to verify, got %v", err)
+	}
+
+	tampered := bytes.Replace(env, []byte(`0xA`), []byte(`0xB`), 1)
+	if err := VerifyFilterPayload(pub, tampered); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected tampered payload to fail, got %v", err)
+	}
+
+	// A correctly signed payload that disagrees with the envelope version
+	// must not verify either.
+	mismatched := signedEnvelope(t, priv, 4, payload)
+	if err := VerifyFilterPayload(pub, mismatched); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected version mismatch to fail, got %v", err)
+	}
+
+	other, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := VerifyFilterPayload(other, env); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("Expected ErrKeyMismatch, got %v", err)
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	// The CRC-32C check value.
+	if err := VerifyChecksum("e3069283", []byte("123456789")); err != nil {
+		t.Errorf("Expected the check value to verify, got %v", err)
+	}
+	if err := VerifyChecksum("e3069283", []byte("123456780")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("Expected ErrChecksumMismatch, got %v", err)
+	}
+	if err := VerifyChecksum("", []byte("anything")); err != nil {
+		t.Errorf("Expected no checksum to pass, got %v", err)
+	}
+}
@@ -0,0 +1,378 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// FilterUpdate describes a change to the locally synced filter.  Entries
+// is always the complete filter after the update.
+type FilterUpdate struct {
+	Version uint64
+	Count   int
+	Entries []string
+
+	// Added and Removed are set when the update was a delta applied while
+	// resuming after a reconnect.
+	Added   []string
+	Removed []string
+
+	// Resync is set when the local filter was replaced wholesale after a
+	// reconnect, because the aggregator could not resume from the local
+	// version, or because the filter parameters changed.
+	Resync bool
+
+	// Rebuild is set when the aggregator rebuilt its filter from its
+	// confirmed set, possibly with new parameters; the local filter was
+	// replaced with the rebuilt one.
+	Rebuild bool
+
+	// Params are the parameters the filter is encoded with.
+	Params BloomParams
+
+	// Instance and LogicalVersion are set by aggregators replicating
+	// behind a load balancer.  Version is only comparable between updates
+	// from the same instance; LogicalVersion is comparable across them.
+	Instance       string
+	LogicalVersion uint64
+
+	// InstanceUUID and Epoch identify the aggregator's state.  The epoch
+	// changes when the aggregator restarts without its saved state or a
+	// failover lands on another lineage, its versions starting over.
+	// Versions are only comparable within one epoch; an update from
+	// another replaces the local filter as a resync.
+	InstanceUUID string
+	Epoch        uint64
+
+	// Summary is the aggregator's count of the changes since its previous
+	// push, by chain and category, when it sent one.  It is not covered by
+	// the signature.
+	Summary *FilterSummary
+}
+
+// filterPayload is the aggregator's serialized filter format.
+type filterPayload struct {
+	FormatVersion int      `json:"format_version"`
+	Version       uint64   `json:"version"`
+	Entries       []string `json:"entries"`
+	Count         int      `json:"count"`
+	BloomParams
+}
+
+// decodeFilterPayload decodes a snapshot payload, refusing format versions
+// and hash algorithms the SDK cannot read.
+func decodeFilterPayload(data []byte) (filterPayload, error) {
+	var p filterPayload
+	if err := json.Unmarshal(data, &p); err != nil {
+		return filterPayload{}, err
+	}
+	p.BloomParams = p.BloomParams.normalize()
+	return p, checkParams(p.FormatVersion, p.BloomParams)
+}
+
+func (p filterPayload) update(resync bool) FilterUpdate {
+	return FilterUpdate{Version: p.Version, Count: p.Count, Entries: p.Entries, Resync: resync, Params: p.BloomParams}
+}
+
+// identify sets the fields naming where an envelope came from.
+func (u *FilterUpdate) identify(env FilterEnvelope) {
+	u.Instance, u.LogicalVersion = env.Instance, env.LogicalVersion
+	u.InstanceUUID, u.Epoch = env.InstanceUUID, env.Epoch
+}
+
+// filterDelta is the payload of a delta envelope.  Deltas from
+// aggregators predating the format header carry no parameters.
+// RequiresResync is set on a delta that removes anything.
+type filterDelta struct {
+	Version        uint64   `json:"version"`
+	FromVersion    uint64   `json:"from_version"`
+	Added          []string `json:"added"`
+	Removed        []string `json:"removed"`
+	RequiresResync bool     `json:"requires_resync,omitempty"`
+	BloomParams
+}
+
+// requiresResync reports whether a client applying deltas in mode
+// cannot apply d.  An aggregator predating the flag is judged by the
+// removals themselves.
+func (d filterDelta) requiresResync(mode string) bool {
+	return mode == DeltasBloom && (d.RequiresResync || len(d.Removed) > 0)
+}
+
+// apply replaces the local filter with the update's entries.
+func (c *Client) apply(u FilterUpdate) {
+	entries := make(map[string]struct{}, len(u.Entries))
+	for _, addr := range u.Entries {
+		entries[addr] = struct{}{}
+	}
+
+	c.mu.Lock()
+	c.entries = entries
+	c.version = u.Version
+	c.instance = u.Instance
+	c.epoch = u.Epoch
+	c.params = u.Params
+	c.synced = true
+	c.mu.Unlock()
+}
+
+// applyDelta updates the local filter in place if it is still at the
+// delta's base version, as numbered by the same instance in the same
+// epoch, and encoded with the same parameters.
+func (c *Client) applyDelta(d filterDelta, env FilterEnvelope) (FilterUpdate, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.synced || c.version != d.FromVersion || c.instance != env.Instance || c.epoch != env.Epoch {
+		return FilterUpdate{}, false
+	}
+	if params := d.BloomParams.normalize(); params.Bits != 0 && params != c.params {
+		return FilterUpdate{}, false
+	}
+	for _, addr := range d.Added {
+		c.entries[addr] = struct{}{}
+	}
+	for _, addr := range d.Removed {
+		delete(c.entries, addr)
+	}
+	c.version = d.Version
+
+	entries := make([]string, 0, len(c.entries))
+	for addr := range c.entries {
+		entries = append(entries, addr)
+	}
+	sort.Strings(entries)
+	return FilterUpdate{
+		Version: d.Version,
+		Count:   len(entries),
+		Entries: entries,
+		Added:   d.Added,
+		Removed: d.Removed,
+		Params:  c.params,
+	}, true
+}
+
+// Watch subscribes to filter pushes and keeps the local copy in sync until
+// ctx is cancelled, at which point the returned channel is closed.
+//
+// The first connection attempt is made synchronously so configuration
+// errors (bad URL, rejected API key) surface immediately.  After that,
+// dropped connections are retried with exponential backoff.  Reconnects
+// resume from the local version: the aggregator replies with the deltas
+// since, or a full snapshot (Resync) when it can no longer cover the gap,
+// is not the instance or epoch the local version came from, or, with
+// Config.Deltas "bloom", would have to remove entries.  Versions compare
+// as (epoch, version): an envelope from another epoch is never stale, and
+// a delta from one is replaced with a full snapshot.
+func (c *Client) Watch(ctx context.Context) (<-chan FilterUpdate, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make(chan FilterUpdate, 16)
+	go c.watchLoop(ctx, conn, updates)
+	return updates, nil
+}
+
+func (c *Client) watchLoop(ctx context.Context, conn *websocket.Conn, updates chan<- FilterUpdate) {
+	defer close(updates)
+
+	for attempt := 0; ; {
+		if conn != nil {
+			c.readUntilClosed(ctx, conn, updates)
+			conn = nil
+			attempt = 0
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		select {
+		case <-time.After(c.backoff(attempt)):
+		case <-ctx.Done():
+			return
+		}
+		attempt++
+
+		var err error
+		if conn, err = c.dial(ctx); err != nil {
+			conn = nil
+		}
+	}
+}
+
+// readUntilClosed applies every envelope received on conn until the
+// connection fails or ctx is cancelled.  Chunked envelopes are
+// reassembled first; envelopes that fail their checksum or signature
+// verification are dropped.  A payload that fails its own checksum was
+// damaged in transit, so a fresh snapshot is fetched in its place.
+func (c *Client) readUntilClosed(ctx context.Context, conn *websocket.Conn, updates chan<- FilterUpdate) {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+
+	var chunks Assembler
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		update, ok := c.receive(ctx, &chunks, data)
+		if !ok {
+			continue
+		}
+		select {
+		case updates <- update:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// receive turns one message of a subscription into the update it makes,
+// if any, once chunks has reassembled it.
+func (c *Client) receive(ctx context.Context, chunks *Assembler, data []byte) (FilterUpdate, bool) {
+	msg, err := chunks.Add(data)
+	if err != nil || msg == nil {
+		return FilterUpdate{}, false
+	}
+	var env FilterEnvelope
+	if err := json.Unmarshal(msg, &env); err != nil {
+		return FilterUpdate{}, false
+	}
+	if VerifyChecksum(env.PayloadChecksum, env.Payload) != nil {
+		update, err := c.Snapshot(ctx)
+		return update, err == nil
+	}
+	if c.verify(env.KeyID, env.Signature, env.Version, env.Payload) != nil {
+		return FilterUpdate{}, false
+	}
+	return c.nextUpdate(ctx, env)
+}
+
+// nextUpdate decides what to do with a received envelope: apply it, skip
+// it as stale or in an unreadable format, or (for a delta that does not
+// follow on from the local version, epoch or parameters, or that removes
+// entries from a bloom-mode client) replace it with a fresh snapshot.  A
+// snapshot with different parameters or from another epoch replaces the
+// local filter and is reported as a resync.
+func (c *Client) nextUpdate(ctx context.Context, env FilterEnvelope) (FilterUpdate, bool) {
+	c.mu.RLock()
+	local, instance, epoch, synced, params := c.version, c.instance, c.epoch, c.synced, c.params
+	c.mu.RUnlock()
+
+	restarted := synced && env.Epoch != epoch
+	stale := synced && !restarted && env.Version <= local && !env.Resync && !env.Rebuild && env.Instance == instance
+
+	if env.Kind == KindDelta {
+		if stale {
+			return FilterUpdate{}, false
+		}
+		var delta filterDelta
+		if err := json.Unmarshal(env.Payload, &delta); err != nil {
+			return FilterUpdate{}, false
+		}
+		if delta.requiresResync(c.deltas) {
+			update, err := c.Snapshot(ctx)
+			if err != nil {
+				return FilterUpdate{}, false
+			}
+			return update, true
+		}
+		if update, ok := c.applyDelta(delta, env); ok {
+			update.identify(env)
+			update.Summary = env.Summary
+			return update, true
+		}
+		update, err := c.Snapshot(ctx)
+		if err != nil {
+			return FilterUpdate{}, false
+		}
+		return update, true
+	}
+
+	payload, err := decodeFilterPayload(env.Payload)
+	if err != nil {
+		return FilterUpdate{}, false
+	}
+	// A snapshot with new parameters is applied even at the local version.
+	resized := synced && payload.BloomParams != params
+	if stale && !resized {
+		return FilterUpdate{}, false
+	}
+	update := payload.update(env.Resync || resized || restarted)
+	update.Rebuild = env.Rebuild
+	update.identify(env)
+	update.Summary = env.Summary
+	c.apply(update)
+	return update, true
+}
+
+// backoff returns the jittered delay before reconnect attempt n.
+func (c *Client) backoff(n int) time.Duration {
+	d := c.minBO << uint(n)
+	if d <= 0 || d > c.maxBO {
+		d = c.maxBO
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// dial opens the /ws subscription.
+func (c *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	u := *c.base
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path += "/ws"
+	u.RawQuery = c.subscriptionQuery().Encode()
+
+	header := http.Header{}
+	c.authorize(header)
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if resp != nil {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			return nil, newHTTPError(resp.StatusCode, body)
+		}
+		return nil, fmt.Errorf("aegis: dial %s: %w", u.String(), err)
+	}
+	return conn, nil
+}
+
+// subscriptionQuery returns the query a subscription is opened with,
+// resuming from the local filter once there is one.
+func (c *Client) subscriptionQuery() url.Values {
+	q := url.Values{}
+	if c.tier != "" {
+		q.Set("tier", c.tier)
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.synced {
+		q.Set("last_version", strconv.FormatUint(c.version, 10))
+		if c.instance != "" {
+			q.Set("instance", c.instance)
+		}
+		if c.epoch != 0 {
+			q.Set("epoch", strconv.FormatUint(c.epoch, 10))
+		}
+		if c.deltas == DeltasBloom {
+			q.Set("deltas", c.deltas)
+		}
+	}
+	return q
+}
@@ -0,0 +1,116 @@
+// Package swarm — Time source.
+//
+// Everything time-based in consensus reads the aggregator's Clock rather
+// than time.Now: the receive stamp of each report, which the TWAB
+// time-span gate measures, quota windows, cooldowns, staging and review
+// deadlines, and the maintenance schedule that prunes and expires them.
+// In production it is the system clock; tests substitute a ManualClock
+// (see SetClock), so a gate an hour wide is crossed without waiting an
+// hour.
+// Socket deadlines, signing key and epoch stamps stay on the wall clock.
+package swarm
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the aggregator's time source.
+type Clock interface {
+	Now() time.Time
+	Ticker(d time.Duration) (tick <-chan time.Time, stop func())
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (systemClock) Ticker(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTicker(d)
+	return t.C, t.Stop
+}
+
+// ManualClock is a Clock moved by hand.  Its ticker fires only on Tick,
+// so the maintenance schedule runs a pass only when told to; whoever
+// advances it usually runs one directly instead (see RunMaintenance).
+// An After fires once Advance carries the clock past its deadline, so a
+// task budget lapses only when the clock is moved while the task runs.
+type ManualClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tick    chan time.Time
+	stopped bool // the ticker was stopped
+	timers  []manualTimer
+}
+
+type manualTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewManualClock returns a clock stopped at start.
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start, tick: make(chan time.Time)}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Ticker(time.Duration) (<-chan time.Time, func()) {
+	return c.tick, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.stopped = true
+	}
+}
+
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, manualTimer{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock on by d, firing the Afters it passes.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, t := range c.timers {
+		if t.at.After(c.now) {
+			pending = append(pending, t)
+		} else {
+			t.ch <- c.now
+		}
+	}
+	c.timers = pending
+}
+
+// Tick fires the ticker at the current time, blocking until the schedule
+// takes it.
+func (c *ManualClock) Tick() {
+	c.tick <- c.Now()
+}
+
+// Stopped reports whether the ticker was stopped.
+func (c *ManualClock) Stopped() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stopped
+}
+
+// SetClock replaces the aggregator's time source, maintenance schedule
+// included.  Call it before starting anything.
+func (s *SwarmAggregator) SetClock(clock Clock) {
+	s.clock, s.maintenance.clock = clock, clock
+}
@@ -0,0 +1,22 @@
+// Command aegis-sim replays a historical report stream through candidate
+// TWAB configurations offline.
+//
+// Usage:
+//
+//	aegis-sim -reports FILE [-reports FILE...] [-candidate CONFIG...]
+//	    [-known-bad FILE] [-known-good FILE] [-json]
+//
+// It is the same simulator as swarm-aggregator simulate (see
+// swarm.RunSimulate), for running where the server binary is not
+// installed.
+package main
+
+import (
+	"os"
+
+	"github.com/aegis-protocol/swarm"
+)
+
+func main() {
+	os.Exit(swarm.RunSimulate(os.Args[1:], os.Stdout, os.Stderr))
+}
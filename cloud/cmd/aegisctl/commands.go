@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// iocReport mirrors the aggregator's IOCReport wire format.
+type iocReport struct {
+	Address    string    `json:"address"`
+	Selector   string    `json:"selector,omitempty"`
+	ChainID    int       `json:"chain_id"`
+	Category   string    `json:"category,omitempty"`
+	Confidence float64   `json:"confidence"`
+	Timestamp  time.Time `json:"timestamp,omitempty"`
+	SourceID   string    `json:"source_id"`
+}
+
+// filterPayload mirrors the serialized Bloom filter.
+type filterPayload struct {
+	Version uint64   `json:"version"`
+	Entries []string `json:"entries"`
+	Count   int      `json:"count"`
+}
+
+func subcommandFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+// cmdReport submits one report from flags or a batch from -file (a JSON
+// array or one JSON report per line).
+func cmdReport(c *client, args []string, stdout io.Writer) error {
+	fs := subcommandFlags("report")
+	file := fs.String("file", "", "JSON array or JSONL file of reports ('-' for stdin)")
+	r := iocReport{}
+	fs.StringVar(&r.Address, "address", "", "reported address")
+	fs.IntVar(&r.ChainID, "chain-id", 1, "chain ID")
+	fs.StringVar(&r.Category, "category", "", "IOC category")
+	fs.Float64Var(&r.Confidence, "confidence", 1.0, "confidence in [0, 1]")
+	fs.StringVar(&r.SourceID, "source-id", "aegisctl", "reporting source ID")
+	fs.StringVar(&r.Selector, "selector", "", "function selector")
+	if err := fs.Parse(args); err != nil {
+		return usagef("report: %v", err)
+	}
+
+	var reports []iocReport
+	switch {
+	case *file != "":
+		var err error
+		if reports, err = readReports(*file); err != nil {
+			return err
+		}
+	case r.Address != "":
+		reports = []iocReport{r}
+	default:
+		return usagef("report: need -address or -file")
+	}
+
+	promoted := 0
+	for i, report := range reports {
+		var resp struct {
+			Accepted      bool `json:"accepted"`
+			AddedToFilter bool `json:"added_to_filter"`
+		}
+		if err := c.do(http.MethodPost, "/ingest", report, &resp); err != nil {
+			return fmt.Errorf("report %d (%s): %w", i, report.Address, err)
+		}
+		if resp.AddedToFilter {
+			promoted++
+		}
+		fmt.Fprintf(stdout, "%s\taccepted=%t\tadded_to_filter=%t\n", report.Address, resp.Accepted, resp.AddedToFilter)
+	}
+	if len(reports) > 1 {
+		fmt.Fprintf(stdout, "submitted %d reports, %d promoted\n", len(reports), promoted)
+	}
+	return nil
+}
+
+func readReports(path string) ([]iocReport, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '[' {
+		var reports []iocReport
+		if err := json.Unmarshal(trimmed, &reports); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		return reports, nil
+	}
+
+	var reports []iocReport
+	scanner := bufio.NewScanner(bytes.NewReader(trimmed))
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var r iocReport
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("parse %s line %d: %w", path, line, err)
+		}
+		reports = append(reports, r)
+	}
+	return reports, scanner.Err()
+}
+
+// cmdCheck prints the /check result for an address.
+func cmdCheck(c *client, args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return usagef("usage: aegisctl check <address>")
+	}
+	var raw json.RawMessage
+	if err := c.do(http.MethodGet, "/check?address="+url.QueryEscape(args[0]), nil, &raw); err != nil {
+		return err
+	}
+	return printJSON(stdout, raw)
+}
+
+// cmdFilter implements "filter pull".
+func cmdFilter(c *client, args []string, stdout io.Writer) error {
+	if len(args) == 0 || args[0] != "pull" {
+		return usagef("usage: aegisctl filter pull [-o FILE]")
+	}
+	fs := subcommandFlags("filter pull")
+	out := fs.String("o", "", "also write the raw payload to FILE")
+	if err := fs.Parse(args[1:]); err != nil {
+		return usagef("filter pull: %v", err)
+	}
+
+	var raw json.RawMessage
+	if err := c.do(http.MethodGet, "/filter", nil, &raw); err != nil {
+		return err
+	}
+	var payload filterPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return fmt.Errorf("decode filter: %w", err)
+	}
+	if *out != "" {
+		if err := os.WriteFile(*out, raw, 0o644); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(stdout, "version\t%d\ncount\t%d\n", payload.Version, payload.Count)
+	return nil
+}
+
+// cmdSubscribe prints each update envelope received on /ws, one per line.
+func cmdSubscribe(c *client, args []string, stdout io.Writer) error {
+	fs := subcommandFlags("subscribe")
+	count := fs.Int("count", 0, "exit after N messages (0 = run until disconnected)")
+	if err := fs.Parse(args); err != nil {
+		return usagef("subscribe: %v", err)
+	}
+
+	conn, err := c.dialWebSocket()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for n := 0; *count == 0 || n < *count; n++ {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return fmt.Errorf("subscription closed: %w", err)
+		}
+		fmt.Fprintf(stdout, "%s\n", bytes.TrimSpace(data))
+	}
+	return nil
+}
+
+// cmdAdmin implements "admin block|unblock|allowlist|rebuild".
+func cmdAdmin(c *client, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return usagef("usage: aegisctl admin <block|unblock|allowlist|rebuild> ...")
+	}
+
+	switch args[0] {
+	case "block":
+		fs := subcommandFlags("admin block")
+		chainID := fs.Int("chain-id", 1, "chain ID")
+		category := fs.String("category", "", "IOC category")
+		reason := fs.String("reason", "", "reason recorded with the block")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 1 {
+			return usagef("usage: aegisctl admin block [-chain-id N] [-category C] [-reason R] <address>")
+		}
+		return adminPost(c, stdout, "/admin/block", map[string]interface{}{
+			"address":  fs.Arg(0),
+			"chain_id": *chainID,
+			"category": *category,
+			"reason":   *reason,
+		})
+
+	case "unblock":
+		if len(args) != 2 {
+			return usagef("usage: aegisctl admin unblock <address>")
+		}
+		return adminPost(c, stdout, "/admin/unblock", map[string]interface{}{"address": args[1]})
+
+	case "allowlist":
+		return cmdAllowlist(c, args[1:], stdout)
+
+	case "rebuild":
+		fs := subcommandFlags("admin rebuild")
+		resize := fs.Bool("resize", false, "size the rebuilt filter for the current count")
+		bits := fs.Uint64("bits", 0, "bit-array size of the rebuilt filter")
+		hashes := fs.Uint("hashes", 0, "hash count of the rebuilt filter")
+		if err := fs.Parse(args[1:]); err != nil || fs.NArg() != 0 || (*bits == 0) != (*hashes == 0) {
+			return usagef("usage: aegisctl admin rebuild [-resize | -bits N -hashes K]")
+		}
+		body := map[string]interface{}{}
+		if *resize {
+			body["resize"] = true
+		}
+		if *bits != 0 {
+			body["bits"], body["hashes"] = *bits, *hashes
+		}
+		return adminPost(c, stdout, "/admin/rebuild", body)
+
+	default:
+		return usagef("unknown admin command %q", args[0])
+	}
+}
+
+func cmdAllowlist(c *client, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return usagef("usage: aegisctl admin allowlist <list|add|remove> [address]")
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		var resp struct {
+			Addresses []string `json:"addresses"`
+		}
+		if err := c.do(http.MethodGet, "/admin/allowlist", nil, &resp); err != nil {
+			return err
+		}
+		for _, addr := range resp.Addresses {
+			fmt.Fprintln(stdout, addr)
+		}
+		return nil
+	case args[0] == "add" && len(args) == 2:
+		return adminPost(c, stdout, "/admin/allowlist", map[string]interface{}{"address": args[1]})
+	case args[0] == "remove" && len(args) == 2:
+		var raw json.RawMessage
+		if err := c.do(http.MethodDelete, "/admin/allowlist?address="+url.QueryEscape(args[1]), nil, &raw); err != nil {
+			return err
+		}
+		return printJSON(stdout, raw)
+	default:
+		return usagef("usage: aegisctl admin allowlist <list|add|remove> [address]")
+	}
+}
+
+func adminPost(c *client, stdout io.Writer, path string, body interface{}) error {
+	var raw json.RawMessage
+	if err := c.do(http.MethodPost, path, body, &raw); err != nil {
+		return err
+	}
+	return printJSON(stdout, raw)
+}
+
+func printJSON(w io.Writer, raw []byte) error {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, bytes.TrimSpace(raw), "", "  "); err != nil {
+		_, err = fmt.Fprintf(w, "%s\n", raw)
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
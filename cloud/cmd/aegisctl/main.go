@@ -0,0 +1,145 @@
+// Command aegisctl operates an Aegis Swarm Aggregator.
+//
+// Usage:
+//
+//	aegisctl [-server URL] [-api-key KEY] [-config FILE] <command> [args]
+//
+// Commands:
+//
+//	report      submit an IOC from flags, or a batch from -file
+//	check       look up an address in the consensus filter
+//	filter pull download the current filter and print version and count
+//	subscribe   stream filter update envelopes from /ws
+//	admin       block, unblock, manage the allowlist, or rebuild the filter
+//
+// The server URL and API key resolve from flags, then the AEGIS_SERVER and
+// AEGIS_API_KEY environment variables, then the JSON config file
+// (default ~/.config/aegisctl/config.json).
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const defaultServer = "http://localhost:9090"
+
+// fileConfig is the on-disk config file format.
+type fileConfig struct {
+	Server string `json:"server"`
+	APIKey string `json:"api_key"`
+}
+
+// env abstracts os.Getenv so tests can supply their own environment.
+type env func(string) string
+
+// command is one aegisctl subcommand.
+type command func(c *client, args []string, stdout io.Writer) error
+
+var commands = map[string]command{
+	"report":    cmdReport,
+	"check":     cmdCheck,
+	"filter":    cmdFilter,
+	"subscribe": cmdSubscribe,
+	"admin":     cmdAdmin,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr, os.Getenv))
+}
+
+// run executes aegisctl and returns the process exit code.
+func run(args []string, stdout, stderr io.Writer, getenv env) int {
+	fs := flag.NewFlagSet("aegisctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	server := fs.String("server", "", "aggregator base URL (env AEGIS_SERVER)")
+	apiKey := fs.String("api-key", "", "API key (env AEGIS_API_KEY)")
+	configPath := fs.String("config", "", "path to JSON config file")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "usage: aegisctl [flags] <report|check|filter|subscribe|admin> [args]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := resolveConfig(*server, *apiKey, *configPath, getenv)
+	if err != nil {
+		fmt.Fprintf(stderr, "aegisctl: %v\n", err)
+		return 1
+	}
+
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "aegisctl: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return 2
+	}
+
+	if err := cmd(newClient(cfg), fs.Args()[1:], stdout); err != nil {
+		fmt.Fprintf(stderr, "aegisctl: %v\n", err)
+		var usage usageError
+		if errors.As(err, &usage) {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// resolveConfig applies flag > env > file > default precedence.
+func resolveConfig(server, apiKey, configPath string, getenv env) (fileConfig, error) {
+	var file fileConfig
+
+	path := configPath
+	if path == "" {
+		if home := getenv("HOME"); home != "" {
+			path = filepath.Join(home, ".config", "aegisctl", "config.json")
+		}
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(data, &file); err != nil {
+				return file, fmt.Errorf("parse config %s: %w", path, err)
+			}
+		case configPath != "" || !errors.Is(err, os.ErrNotExist):
+			// An explicitly named config file must exist.
+			return file, fmt.Errorf("read config: %w", err)
+		}
+	}
+
+	cfg := fileConfig{
+		Server: firstNonEmpty(server, getenv("AEGIS_SERVER"), file.Server, defaultServer),
+		APIKey: firstNonEmpty(apiKey, getenv("AEGIS_API_KEY"), file.APIKey),
+	}
+	return cfg, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// usageError marks errors caused by bad command-line arguments.
+type usageError struct{ msg string }
+
+func (e usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...interface{}) error {
+	return usageError{fmt.Sprintf(format, args...)}
+}
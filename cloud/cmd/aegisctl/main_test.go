@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeAggregator implements the subset of the aggregator HTTP API that
+// aegisctl talks to.  The server package is still package main and cannot
+// be imported here, so the wire contract is mirrored instead.
+type fakeAggregator struct {
+	mu        sync.Mutex
+	reports   []iocReport
+	filtered  map[string]bool
+	allowlist map[string]bool
+	version   uint64
+}
+
+func newFakeAggregator(t *testing.T) (*fakeAggregator, *httptest.Server) {
+	fa := &fakeAggregator{filtered: map[string]bool{}, allowlist: map[string]bool{}}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/ingest", func(w http.ResponseWriter, r *http.Request) {
+		var rep iocReport
+		if err := json.NewDecoder(r.Body).Decode(&rep); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+		fa.mu.Lock()
+		fa.reports = append(fa.reports, rep)
+		fa.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"accepted": true, "added_to_filter": false})
+	})
+	mux.HandleFunc("/check", func(w http.ResponseWriter, r *http.Request) {
+		fa.mu.Lock()
+		defer fa.mu.Unlock()
+		addr := r.URL.Query().Get("address")
+		json.NewEncoder(w).Encode(map[string]interface{}{"address": addr, "flagged": fa.filtered[addr]})
+	})
+	mux.HandleFunc("/filter", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(fa.payload())
+	})
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, fa.payload())
+		conn.WriteMessage(websocket.TextMessage, fa.payload())
+	})
+
+	admin := func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer admin-secret" {
+				http.Error(w, "Invalid API key", http.StatusUnauthorized)
+				return
+			}
+			h(w, r)
+		}
+	}
+	mux.HandleFunc("/admin/block", admin(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Address string }
+		json.NewDecoder(r.Body).Decode(&body)
+		fa.mu.Lock()
+		fa.filtered[body.Address] = true
+		fa.version++
+		fa.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"address": body.Address, "changed": true})
+	}))
+	mux.HandleFunc("/admin/allowlist", admin(func(w http.ResponseWriter, r *http.Request) {
+		fa.mu.Lock()
+		defer fa.mu.Unlock()
+		switch r.Method {
+		case http.MethodGet:
+			var addrs []string
+			for a := range fa.allowlist {
+				addrs = append(addrs, a)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"addresses": addrs})
+		case http.MethodPost:
+			var body struct{ Address string }
+			json.NewDecoder(r.Body).Decode(&body)
+			fa.allowlist[body.Address] = true
+			json.NewEncoder(w).Encode(map[string]interface{}{"address": body.Address, "changed": true})
+		}
+	}))
+
+	mux.HandleFunc("/admin/rebuild", admin(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Bits   uint64 `json:"bits"`
+			Hashes uint   `json:"hashes"`
+			Resize bool   `json:"resize"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		fa.mu.Lock()
+		defer fa.mu.Unlock()
+		fa.version++
+		json.NewEncoder(w).Encode(map[string]interface{}{"filter_version": fa.version, "bits": body.Bits, "hashes": body.Hashes, "resized": body.Resize})
+	}))
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return fa, srv
+}
+
+func (fa *fakeAggregator) payload() []byte {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	var entries []string
+	for a := range fa.filtered {
+		entries = append(entries, a)
+	}
+	data, _ := json.Marshal(filterPayload{Version: fa.version, Entries: entries, Count: len(entries)})
+	return data
+}
+
+func noEnv(string) string { return "" }
+
+func runCtl(t *testing.T, getenv env, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(args, &stdout, &stderr, getenv)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestReportSingleAndBatch(t *testing.T) {
+	fa, srv := newFakeAggregator(t)
+
+	code, out, errOut := runCtl(t, noEnv, "-server", srv.URL, "report", "-address", "0xEvil", "-chain-id", "10")
+	if code != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", code, errOut)
+	}
+	if !strings.Contains(out, "0xEvil\taccepted=true") {
+		t.Errorf("Unexpected output %q", out)
+	}
+
+	batch := filepath.Join(t.TempDir(), "batch.jsonl")
+	os.WriteFile(batch, []byte(`{"address":"0xA","chain_id":1,"source_id":"s1"}
+{"address":"0xB","chain_id":1,"source_id":"s2"}
+`), 0o644)
+	code, out, _ = runCtl(t, noEnv, "-server", srv.URL, "report", "-file", batch)
+	if code != 0 || !strings.Contains(out, "submitted 2 reports") {
+		t.Errorf("Batch report failed: code=%d out=%q", code, out)
+	}
+
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+	if len(fa.reports) != 3 || fa.reports[0].ChainID != 10 || fa.reports[2].SourceID != "s2" {
+		t.Errorf("Unexpected reports received: %+v", fa.reports)
+	}
+}
+
+func TestAdminBlockThenCheckAndFilterPull(t *testing.T) {
+	_, srv := newFakeAggregator(t)
+	environ := func(k string) string {
+		return map[string]string{"AEGIS_SERVER": srv.URL, "AEGIS_API_KEY": "admin-secret"}[k]
+	}
+
+	if code, _, errOut := runCtl(t, environ, "admin", "block", "-reason", "drainer", "0xBad"); code != 0 {
+		t.Fatalf("admin block failed: %s", errOut)
+	}
+
+	_, out, _ := runCtl(t, environ, "check", "0xBad")
+	if !strings.Contains(out, `"flagged": true`) {
+		t.Errorf("Expected flagged address, got %q", out)
+	}
+
+	_, out, _ = runCtl(t, environ, "filter", "pull")
+	if out != "version\t1\ncount\t1\n" {
+		t.Errorf("Unexpected filter pull output %q", out)
+	}
+
+	runCtl(t, environ, "admin", "allowlist", "add", "0xRouter")
+	_, out, _ = runCtl(t, environ, "admin", "allowlist", "list")
+	if out != "0xRouter\n" {
+		t.Errorf("Unexpected allowlist output %q", out)
+	}
+}
+
+func TestAdminRebuild(t *testing.T) {
+	_, srv := newFakeAggregator(t)
+	environ := func(k string) string {
+		return map[string]string{"AEGIS_SERVER": srv.URL, "AEGIS_API_KEY": "admin-secret"}[k]
+	}
+
+	code, out, errOut := runCtl(t, environ, "admin", "rebuild", "-bits", "4096", "-hashes", "3")
+	if code != 0 || !strings.Contains(out, `"bits": 4096`) || !strings.Contains(out, `"hashes": 3`) {
+		t.Fatalf("admin rebuild: exit %d, %q %s", code, out, errOut)
+	}
+	if _, out, _ = runCtl(t, environ, "admin", "rebuild", "-resize"); !strings.Contains(out, `"resized": true`) {
+		t.Errorf("Expected a resize request, got %q", out)
+	}
+	if code, _, _ := runCtl(t, environ, "admin", "rebuild", "-bits", "4096"); code != 2 {
+		t.Errorf("Expected usage error for bits without hashes, got exit %d", code)
+	}
+}
+
+func TestHTTPErrorExitsNonZero(t *testing.T) {
+	_, srv := newFakeAggregator(t)
+
+	if code, _, _ := runCtl(t, noEnv, "-server", srv.URL, "admin", "unblock", "0xBad"); code != 1 {
+		t.Errorf("Expected exit 1 on HTTP 404, got %d", code)
+	}
+	code, _, errOut := runCtl(t, noEnv, "-server", srv.URL, "-api-key", "wrong", "admin", "block", "0xBad")
+	if code != 1 || !strings.Contains(errOut, "server returned 401: Invalid API key") {
+		t.Errorf("Expected clear 401 message, got code=%d stderr=%q", code, errOut)
+	}
+
+	if code, _, _ := runCtl(t, noEnv, "-server", srv.URL, "bogus"); code != 2 {
+		t.Errorf("Expected usage exit 2 for unknown command, got %d", code)
+	}
+}
+
+func TestSubscribePrintsEnvelopes(t *testing.T) {
+	_, srv := newFakeAggregator(t)
+
+	code, out, errOut := runCtl(t, noEnv, "-server", srv.URL, "subscribe", "-count", "2")
+	if code != 0 {
+		t.Fatalf("subscribe failed: %s", errOut)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], `"version":0`) {
+		t.Errorf("Unexpected subscribe output %q", out)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"server":"http://file:1","api_key":"file-key"}`), 0o644)
+
+	cfg, err := resolveConfig("", "", path, noEnv)
+	if err != nil || cfg.Server != "http://file:1" || cfg.APIKey != "file-key" {
+		t.Errorf("Expected file values, got %+v (%v)", cfg, err)
+	}
+
+	environ := func(k string) string { return map[string]string{"AEGIS_SERVER": "http://env:2"}[k] }
+	cfg, _ = resolveConfig("", "", path, environ)
+	if cfg.Server != "http://env:2" || cfg.APIKey != "file-key" {
+		t.Errorf("Expected env to override file, got %+v", cfg)
+	}
+
+	cfg, _ = resolveConfig("http://flag:3", "flag-key", path, environ)
+	if cfg.Server != "http://flag:3" || cfg.APIKey != "flag-key" {
+		t.Errorf("Expected flags to win, got %+v", cfg)
+	}
+
+	if _, err := resolveConfig("", "", filepath.Join(t.TempDir(), "missing.json"), noEnv); err == nil {
+		t.Error("Expected error for a missing explicit config file")
+	}
+}
@@ -0,0 +1,25 @@
+// Command swarm-aggregator runs the Aegis Swarm Aggregator.
+//
+// Usage:
+//
+//	swarm-aggregator [-config FILE] [flags]
+//	swarm-aggregator simulate -reports FILE [flags]
+//
+// The server itself is the swarm package (see Run); this binary only
+// hands it the command line.  Run with -h for the configuration flags and
+// their environment variables.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/aegis-protocol/swarm"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "simulate" {
+		os.Exit(swarm.RunSimulate(os.Args[2:], os.Stdout, os.Stderr))
+	}
+	swarm.Run(flag.CommandLine, os.Args[1:])
+}
@@ -0,0 +1,207 @@
+// Package swarm — HTTP compression.
+//
+// Batch ingests and filter downloads are the largest bodies the API
+// moves, so their routes, and /export/stix and /stats, go through
+// withCompression.  A request body sent with Content-Encoding: gzip is
+// decompressed before the handler reads it; ingest body limits apply to
+// the decompressed body, and compression.max_decompressed_bytes caps it on
+// every route so a small compressed body cannot expand without bound.
+// Past the cap the request is answered 413, and an encoding other than
+// gzip is answered 415.
+//
+// A response is gzipped when the client sends Accept-Encoding: gzip and
+// the body reaches compression.min_response_bytes; smaller ones are sent
+// as they are, since the gzip framing would outweigh the saving.  Payloads
+// that are already compressed, or as dense as the binary Bloom filter, are
+// never gzipped.  Filter signatures cover the payload, not its encoding on
+// the wire, so they verify after the client decompresses.
+package swarm
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// incompressibleTypes are the Content-Type prefixes never gzipped.
+var incompressibleTypes = []string{
+	contentTypeBinaryFilter, // a Bloom bit array is close to random
+	"application/gzip",
+	"application/zip",
+	"application/zstd",
+	"image/",
+	"video/",
+}
+
+// withCompression decompresses gzip request bodies and gzips large
+// responses for clients that accept it.
+func (s *SwarmAggregator) withCompression(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := s.config.Compression
+		switch encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding"))); encoding {
+		case "", "identity":
+		case "gzip":
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, CodeInvalidBody, "Invalid gzip body")
+				return
+			}
+			defer gz.Close()
+			body := io.ReadCloser(gz)
+			if cfg.MaxDecompressedBytes > 0 {
+				body = http.MaxBytesReader(w, gz, cfg.MaxDecompressedBytes)
+			}
+			r.Body = body
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+		default:
+			writeError(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedEncoding,
+				fmt.Sprintf("Unsupported Content-Encoding %q", encoding))
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w, minSize: cfg.MinResponseBytes}
+		defer gw.Close()
+		next(gw, r)
+	}
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding allows gzip,
+// explicitly or through *.
+func acceptsGzip(r *http.Request) bool {
+	star := false
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(coding)) {
+		case "gzip":
+			return q > 0
+		case "*":
+			star = q > 0
+		}
+	}
+	return star
+}
+
+// gzipResponseWriter holds back the first minSize bytes of a response to
+// decide whether to gzip it.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	status  int // 0 until the handler writes a header
+	buf     []byte
+	decided bool
+	gz      *gzip.Writer // nil when sending uncompressed
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.decided {
+		return w.write(p)
+	}
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *gzipResponseWriter) write(p []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide sends the header, gzipping if the body was large enough and is
+// worth compressing, then the held-back bytes.
+func (w *gzipResponseWriter) decide(large bool) error {
+	w.decided = true
+	h := w.Header()
+	if large && w.compressible() {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.write(buf)
+	return err
+}
+
+// compressible reports whether the response may be gzipped.
+func (w *gzipResponseWriter) compressible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(w.buf)
+		h.Set("Content-Type", contentType)
+	}
+	for _, prefix := range incompressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// Flush sends what has been written so far, compressing it if the body
+// already reached minSize.
+func (w *gzipResponseWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf) >= w.minSize)
+	}
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends a response still held back, and ends the gzip stream.
+func (w *gzipResponseWriter) Close() error {
+	if !w.decided {
+		if err := w.decide(false); err != nil {
+			return err
+		}
+	}
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
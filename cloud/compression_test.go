@@ -0,0 +1,151 @@
+package swarm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newCompressionAggregator(minResponse int, maxDecompressed int64) *SwarmAggregator {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Ingest.Synchronous = true
+	cfg.Compression = CompressionConfig{MinResponseBytes: minResponse, MaxDecompressedBytes: maxDecompressed}
+	return NewSwarmAggregatorWithConfig(cfg)
+}
+
+func postGzip(agg *SwarmAggregator, path string, body []byte, encoding string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Encoding", encoding)
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGzipBatchIngest(t *testing.T) {
+	agg := newCompressionAggregator(1024, 4096)
+	addr := evmAddress("gzipped")
+	batch := fmt.Sprintf(`[{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-A"},{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-B"}]`, addr, addr)
+
+	if rec := postGzip(agg, "/ingest/batch", gzipBytes(t, []byte(batch)), "gzip"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for a gzipped batch, got %d %s", rec.Code, rec.Body)
+	}
+	if !agg.bloomFilter.Contains(addr) {
+		t.Error("Expected the decompressed batch to promote the address")
+	}
+
+	bomb := gzipBytes(t, []byte("["+strings.Repeat(" ", 1<<20)+"]"))
+	for name, tc := range map[string]struct {
+		body     []byte
+		encoding string
+		want     int
+		code     ErrorCode
+	}{
+		"bomb":        {bomb, "gzip", http.StatusRequestEntityTooLarge, CodePayloadTooLarge},
+		"not gzip":    {[]byte(batch), "gzip", http.StatusBadRequest, CodeInvalidBody},
+		"truncated":   {gzipBytes(t, []byte(batch))[:40], "gzip", http.StatusBadRequest, CodeInvalidBody},
+		"unsupported": {[]byte(batch), "br", http.StatusUnsupportedMediaType, CodeUnsupportedEncoding},
+	} {
+		rec := postGzip(agg, "/ingest/batch", tc.body, tc.encoding)
+		var body ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != tc.want || body.Error.Code != tc.code {
+			t.Errorf("%s: expected %d %s, got %d %+v", name, tc.want, tc.code, rec.Code, body)
+		}
+	}
+	if len(bomb) > 4096 {
+		t.Fatalf("Bomb is %d bytes compressed; the cap must be what rejects it", len(bomb))
+	}
+}
+
+func getFilter(agg *SwarmAggregator, query string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/filter"+query, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGzipResponses(t *testing.T) {
+	agg := newCompressionAggregator(1024, 0)
+	for i := 0; i < 50; i++ {
+		promote(agg, evmAddress(fmt.Sprintf("filter-%d", i)), "phishing")
+	}
+	gzipped := http.Header{"Accept-Encoding": {"br;q=1.0, gzip;q=0.8"}}
+
+	plain := getFilter(agg, "", nil)
+	rec := getFilter(agg, "", gzipped)
+	if rec.Header().Get("Content-Encoding") != "gzip" || !strings.Contains(rec.Header().Get("Vary"), "Accept-Encoding") {
+		t.Fatalf("Expected a gzipped filter, got headers %v", rec.Header())
+	}
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, plain.Body.Bytes()) || rec.Header().Get(headerFilterSignature) != plain.Header().Get(headerFilterSignature) {
+		t.Error("Expected the decompressed filter to match the uncompressed one")
+	}
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the content type kept, got %q", rec.Header().Get("Content-Type"))
+	}
+
+	for name, tc := range map[string]struct {
+		query  string
+		header http.Header
+	}{
+		"small error":   {"?format=nope", gzipped},
+		"binary filter": {"", http.Header{"Accept-Encoding": {"gzip"}, "Accept": {contentTypeBinaryFilter}}},
+		"refused":       {"", http.Header{"Accept-Encoding": {"gzip;q=0, *"}}},
+	} {
+		rec := getFilter(agg, tc.query, tc.header)
+		if enc := rec.Header().Get("Content-Encoding"); enc != "" {
+			t.Errorf("%s: expected no compression, got Content-Encoding %q", name, enc)
+		}
+		if rec.Code == http.StatusOK && !bytes.Equal(rec.Body.Bytes(), getFilter(agg, tc.query, http.Header{"Accept": tc.header["Accept"]}).Body.Bytes()) {
+			t.Errorf("%s: expected the body unchanged", name)
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, want := range map[string]bool{
+		"":                  false,
+		"gzip":              true,
+		"deflate, GZIP":     true,
+		"gzip;q=0":          false,
+		"*":                 true,
+		"*, gzip;q=0":       false,
+		"identity;q=1, br":  false,
+		"br;q=1, *;q=0.1":   true,
+		"gzip;q=0.5, *;q=0": true,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", header)
+		if got := acceptsGzip(req); got != want {
+			t.Errorf("Accept-Encoding %q: expected %v, got %v", header, want, got)
+		}
+	}
+}
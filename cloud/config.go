@@ -0,0 +1,1497 @@
+// Package swarm — Aggregator runtime configuration.
+//
+// Settings resolve in increasing precedence: built-in defaults, the
+// config file (JSON, or YAML for .yaml/.yml), AEGIS_* environment
+// variables, then command-line flags.  Every setting that can be
+// overridden is listed once in configFields with its flag and env var.
+package swarm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aegis-protocol/swarm/bloom"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the full aggregator configuration.
+type Config struct {
+	ListenAddr  string            `json:"listen_addr" yaml:"listen_addr"`
+	TLS         TLSConfig         `json:"tls" yaml:"tls"`
+	TWAB        TWABConfig        `json:"twab" yaml:"twab"`
+	Bloom       BloomConfig       `json:"bloom" yaml:"bloom"`
+	Push        PushConfig        `json:"push" yaml:"push"`
+	Ingest      IngestConfig      `json:"ingest" yaml:"ingest"`
+	RateLimit   RateLimitConfig   `json:"rate_limit" yaml:"rate_limit"`
+	Expiry      ExpiryConfig      `json:"expiry" yaml:"expiry"`
+	Cooldown    CooldownConfig    `json:"cooldown" yaml:"cooldown"`
+	SLO         SLOConfig         `json:"slo" yaml:"slo"`
+	Calibration CalibrationConfig `json:"calibration" yaml:"calibration"`
+	Retention   RetentionConfig   `json:"retention" yaml:"retention"`
+	Tripwire    TripwireConfig    `json:"tripwire" yaml:"tripwire"`
+	Maintenance MaintenanceConfig `json:"maintenance" yaml:"maintenance"`
+	Compression CompressionConfig `json:"compression" yaml:"compression"`
+	Watchlist   WatchlistConfig   `json:"watchlist" yaml:"watchlist"`
+	Feedback    FeedbackConfig    `json:"feedback" yaml:"feedback"`
+	Review      ReviewConfig      `json:"review" yaml:"review"`
+	Persistence PersistenceConfig `json:"persistence" yaml:"persistence"`
+	Alerts      AlertConfig       `json:"alerts" yaml:"alerts"`
+	Quota       QuotaConfig       `json:"quota" yaml:"quota"`
+	Replication ReplicationConfig `json:"replication" yaml:"replication"`
+	Mirror      MirrorConfig      `json:"mirror" yaml:"mirror"`
+	Standby     StandbyConfig     `json:"standby" yaml:"standby"`
+	Staging     StagingConfig     `json:"staging" yaml:"staging"`
+	Limits      LimitsConfig      `json:"limits" yaml:"limits"`
+	Events      EventsConfig      `json:"events" yaml:"events"`
+	Sanctions   SanctionsConfig   `json:"sanctions" yaml:"sanctions"`
+
+	// EvidenceVerification checks cited transactions on chain (see
+	// evidence_verify.go).
+	EvidenceVerification EvidenceVerificationConfig `json:"evidence_verification" yaml:"evidence_verification"`
+
+	// RequestTimeout bounds each HTTP request but the long-lived ones
+	// (see timeout.go); zero disables it.
+	RequestTimeout Duration `json:"request_timeout" yaml:"request_timeout"`
+
+	// Listeners replaces listen_addr with listeners serving chosen route
+	// groups (config file only; see listener.go).
+	Listeners []ListenerConfig `json:"listeners" yaml:"listeners"`
+
+	// Namespaces configures tenant namespaces by name (config file only).
+	Namespaces map[string]NamespaceConfig `json:"namespaces" yaml:"namespaces"`
+
+	// Shadow holds candidate TWAB thresholds by name, evaluated against
+	// every report without touching the filter (config file only).
+	Shadow map[string]TWABConfig `json:"shadow" yaml:"shadow"`
+
+	// Chains names chain IDs beyond the well-known ones, and
+	// AllowUnknownChains accepts reports for chains named nowhere (see
+	// chains.go).
+	Chains             map[int]string `json:"chains" yaml:"chains"`
+	AllowUnknownChains bool           `json:"allow_unknown_chains" yaml:"allow_unknown_chains"`
+}
+
+// TLSConfig enables HTTPS when both paths are set.  HTTPS listeners
+// negotiate HTTP/2; H2C serves it in cleartext on the others, for
+// POST /stream behind a proxy that terminates TLS (see duplex.go).
+type TLSConfig struct {
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+	H2C      bool   `json:"h2c" yaml:"h2c"`
+}
+
+// Enabled reports whether TLS is configured.
+func (c TLSConfig) Enabled() bool { return c.CertFile != "" && c.KeyFile != "" }
+
+// BloomConfig sizes the probabilistic filter encoding.  The exact filter
+// used today only reports the resulting parameters, which peers must
+// match to merge.  Once the estimated fraction of bits set reaches
+// RebuildFillRatio the filter is rebuilt resized (see rebuild.go); zero
+// never rebuilds.  MaxFilterEntries caps the confirmed set consensus may
+// grow, OverflowPolicy saying what a promotion past it does (see
+// filtercap.go); zero leaves it unbounded.  Hash names the algorithm
+// clients set and test bits with (see bloom/hasher.go); empty is FNV-1a.  With
+// AutoSizeHorizon the filter is resized ahead of the confirmed set's
+// growth, for AutoSizeHeadroom times its size projected that far ahead
+// (see autosize.go); zero disables it.
+type BloomConfig struct {
+	ExpectedItems     uint    `json:"expected_items" yaml:"expected_items"`
+	FalsePositiveRate float64 `json:"false_positive_rate" yaml:"false_positive_rate"`
+	RebuildFillRatio  float64 `json:"rebuild_fill_ratio" yaml:"rebuild_fill_ratio"`
+	Hash              string  `json:"hash" yaml:"hash"`
+
+	AutoSizeHorizon  Duration `json:"auto_size_horizon" yaml:"auto_size_horizon"`
+	AutoSizeHeadroom float64  `json:"auto_size_headroom" yaml:"auto_size_headroom"`
+
+	MaxFilterEntries int            `json:"max_filter_entries" yaml:"max_filter_entries"`
+	OverflowPolicy   OverflowPolicy `json:"overflow_policy" yaml:"overflow_policy"`
+}
+
+// PushConfig controls delivery to subscribers.
+type PushConfig struct {
+	// Debounce coalesces filter changes within the window into a single
+	// push.  Zero pushes on every change.
+	Debounce Duration `json:"debounce" yaml:"debounce"`
+
+	// SubscriberBuffer is the number of pushes queued per subscriber
+	// before further pushes to it are skipped.
+	SubscriberBuffer int `json:"subscriber_buffer" yaml:"subscriber_buffer"`
+
+	// MaxSubscriberBuffer caps the ?buffer= a subscription may ask for,
+	// by the role of its key; a role not listed may not ask for more than
+	// SubscriberBuffer (see subscribers.go).
+	MaxSubscriberBuffer map[Role]int `json:"max_subscriber_buffer" yaml:"max_subscriber_buffer"`
+
+	// EvictAfterDrops disconnects a subscriber after this many consecutive
+	// skipped pushes, and EvictAfterIdle after this long without a
+	// delivery while it is skipping them.  Zero disables either.
+	EvictAfterDrops int      `json:"evict_after_drops" yaml:"evict_after_drops"`
+	EvictAfterIdle  Duration `json:"evict_after_idle" yaml:"evict_after_idle"`
+
+	// ResumeHistory is the number of filter changes retained so a
+	// reconnecting subscriber can catch up with deltas.  Zero disables
+	// resume; every reconnect gets a full snapshot.
+	ResumeHistory int `json:"resume_history" yaml:"resume_history"`
+
+	// BitPatchHistory is the number of filter versions whose bits are
+	// retained so a subscriber holding only bits can resume with a bit
+	// patch (see bitpatch.go).  Zero disables bit patches.
+	BitPatchHistory int `json:"bit_patch_history" yaml:"bit_patch_history"`
+
+	// MaxSubscriptionsPerKey caps the WebSocket subscriptions one API key
+	// may hold open at once.  Zero is unlimited.
+	MaxSubscriptionsPerKey int `json:"max_subscriptions_per_key" yaml:"max_subscriptions_per_key"`
+
+	// ChunkSize splits WebSocket messages larger than this many bytes into
+	// chunk envelopes.  Zero sends every message whole.
+	ChunkSize int `json:"chunk_size" yaml:"chunk_size"`
+
+	// AckTimeout is how long a subscriber acknowledging deliveries may
+	// leave the latest version unacknowledged before it is sent the
+	// filter again, at most AckMaxRedeliveries times per version (see
+	// ack.go).  Zero never redelivers.
+	AckTimeout         Duration `json:"ack_timeout" yaml:"ack_timeout"`
+	AckMaxRedeliveries int      `json:"ack_max_redeliveries" yaml:"ack_max_redeliveries"`
+
+	// CategorySeverity rates the additions of each category, for
+	// subscriptions that batch pushes but take urgent ones at once;
+	// categories not listed are medium (see pushschedule.go).
+	CategorySeverity map[string]Severity `json:"category_severity" yaml:"category_severity"`
+}
+
+// maxBuffer is the largest subscriber buffer a key of role may ask for.
+func (c PushConfig) maxBuffer(role Role) int {
+	if n, ok := c.MaxSubscriberBuffer[role]; ok {
+		return n
+	}
+	return c.SubscriberBuffer
+}
+
+// IngestConfig controls how ingest requests are processed.  By default
+// the handler validates and queues a report and answers 202 at once;
+// Workers drain the queue into consensus.  Synchronous processes reports
+// in the handler instead, for tests and small deployments.
+type IngestConfig struct {
+	Synchronous bool `json:"synchronous" yaml:"synchronous"`
+	QueueSize   int  `json:"queue_size" yaml:"queue_size"`
+	Workers     int  `json:"workers" yaml:"workers"`
+
+	// MaxFutureSkew rejects reports timestamped further than this ahead
+	// of the server clock; nearer future timestamps are clamped to it.
+	// Zero only clamps.  See skew.go.
+	MaxFutureSkew Duration `json:"max_future_skew" yaml:"max_future_skew"`
+
+	// IdempotencyWindow is how long a report ID is remembered, and
+	// IdempotencyKeys how many are; zero keys disables replay detection.
+	// See idempotency.go.
+	IdempotencyWindow Duration `json:"idempotency_window" yaml:"idempotency_window"`
+	IdempotencyKeys   int      `json:"idempotency_keys" yaml:"idempotency_keys"`
+
+	// MaxBodyBytes caps the body of POST /ingest and MaxBatchBodyBytes
+	// that of /ingest/batch; zero is unbounded.  See ingest_limits.go.
+	MaxBodyBytes      int64 `json:"max_body_bytes" yaml:"max_body_bytes"`
+	MaxBatchBodyBytes int64 `json:"max_batch_body_bytes" yaml:"max_batch_body_bytes"`
+}
+
+// RateLimitConfig is a per-client token bucket on the ingest endpoints.
+// A zero rate disables it.
+type RateLimitConfig struct {
+	IngestPerSecond float64 `json:"ingest_per_second" yaml:"ingest_per_second"`
+	IngestBurst     int     `json:"ingest_burst" yaml:"ingest_burst"`
+}
+
+// QuotaConfig caps what a single SourceID may report.  A source over
+// either limit is banned for BanDuration, doubling with every repeat
+// offense up to MaxBanDuration.  Zero limits disable the check.
+type QuotaConfig struct {
+	ReportsPerHour        int      `json:"reports_per_hour" yaml:"reports_per_hour"`
+	UniqueAddressesPerDay int      `json:"unique_addresses_per_day" yaml:"unique_addresses_per_day"`
+	BanDuration           Duration `json:"ban_duration" yaml:"ban_duration"`
+	MaxBanDuration        Duration `json:"max_ban_duration" yaml:"max_ban_duration"`
+}
+
+// ReplicationConfig links aggregators behind one load balancer (see
+// replication.go).  Promotions are forwarded to every peer when Peers is
+// set; InstanceID is then required and must be unique among them.
+type ReplicationConfig struct {
+	InstanceID string   `json:"instance_id" yaml:"instance_id"`
+	Peers      []string `json:"peers" yaml:"peers"`
+
+	// PeerKey is the API key secret presented to peers; each peer must
+	// list it with the peer role.
+	PeerKey string `json:"peer_key" yaml:"peer_key"`
+
+	// Timeout bounds one delivery to a peer.
+	Timeout Duration `json:"timeout" yaml:"timeout"`
+}
+
+// MirrorConfig runs the aggregator as a read-only mirror of Upstream (see
+// mirror.go), presenting APIKey there.
+type MirrorConfig struct {
+	Upstream string `json:"upstream" yaml:"upstream"`
+	APIKey   string `json:"api_key" yaml:"api_key"`
+}
+
+// StandbyConfig runs the aggregator as the warm standby of Active (see
+// standby.go), presenting APIKey, which Active must list with the peer
+// role.  With FailoverAfter set, losing the link to Active for that long
+// promotes the standby to active; zero leaves that to POST
+// /admin/promote-to-active.
+type StandbyConfig struct {
+	Active        string   `json:"active" yaml:"active"`
+	APIKey        string   `json:"api_key" yaml:"api_key"`
+	FailoverAfter Duration `json:"failover_after" yaml:"failover_after"`
+}
+
+// ExpiryConfig sets how long a confirmed address survives without fresh
+// reports.  A zero TTL never expires; CategoryTTL overrides TTL for
+// entries of that category (zero there exempts the category).
+type ExpiryConfig struct {
+	TTL           Duration            `json:"ttl" yaml:"ttl"`
+	CategoryTTL   map[string]Duration `json:"category_ttl" yaml:"category_ttl"`
+	SweepInterval Duration            `json:"sweep_interval" yaml:"sweep_interval"`
+}
+
+// CooldownConfig sets how long a removed address is kept from
+// re-promotion, by why it was removed (see cooldown.go); zero disables a
+// reason.  At most MaxEntries addresses cool down at once.
+type CooldownConfig struct {
+	Unblocked  Duration `json:"unblocked" yaml:"unblocked"`
+	Retracted  Duration `json:"retracted" yaml:"retracted"`
+	Expired    Duration `json:"expired" yaml:"expired"`
+	Disputed   Duration `json:"disputed" yaml:"disputed"`
+	MaxEntries int      `json:"max_entries" yaml:"max_entries"`
+}
+
+// SLOConfig sets the promotion latency target GET /stats/slo reports
+// against, and how many promotion timelines are kept (see slo.go).
+type SLOConfig struct {
+	Target     Duration `json:"target" yaml:"target"`
+	MaxSamples int      `json:"max_samples" yaml:"max_samples"`
+}
+
+// CalibrationConfig sets when a source's confidence is mapped through its
+// calibration curve (see calibration.go): once the curve holds MinOutcomes
+// outcomes, zero weighing raw confidence always.  At most MaxSources
+// sources have curves.
+type CalibrationConfig struct {
+	MinOutcomes int `json:"min_outcomes" yaml:"min_outcomes"`
+	MaxSources  int `json:"max_sources" yaml:"max_sources"`
+}
+
+// RetentionConfig bounds how long raw reports are kept per data class
+// (see retention.go).  Retained reports of addresses never promoted are
+// deleted UnpromotedReports after they were received, and those of
+// promoted addresses compacted into the per-source aggregates after
+// PromotedReports; zero keeps them.  Pruning takes a TWAB shard's lock
+// for at most BatchSize addresses at a time.
+type RetentionConfig struct {
+	UnpromotedReports Duration `json:"unpromoted_reports" yaml:"unpromoted_reports"`
+	PromotedReports   Duration `json:"promoted_reports" yaml:"promoted_reports"`
+	BatchSize         int      `json:"batch_size" yaml:"batch_size"`
+}
+
+// TripwireConfig holds back consensus promotions of addresses resembling
+// a protected one, allowlisted or on the HighValueFile list (see
+// tripwire.go): within MaxDistance edits of its hex body, or sharing
+// its first PrefixLength hex digits.  Zero PrefixLength turns the prefix
+// test off, and zero MaxDistance only holds back the high-value
+// addresses themselves.  The HighValueFile is re-read on reload; the
+// thresholds need a restart.
+type TripwireConfig struct {
+	MaxDistance   int    `json:"max_distance" yaml:"max_distance"`
+	PrefixLength  int    `json:"prefix_length" yaml:"prefix_length"`
+	HighValueFile string `json:"high_value_file" yaml:"high_value_file"`
+}
+
+// MaintenanceConfig schedules background maintenance (see
+// maintenance.go).  Each task gets TaskBudget per run.  TWAB history of an
+// unconfirmed address with no reports received for TWABIdleTTL is
+// dropped; zero keeps it forever.
+type MaintenanceConfig struct {
+	Interval    Duration `json:"interval" yaml:"interval"`
+	TaskBudget  Duration `json:"task_budget" yaml:"task_budget"`
+	TWABIdleTTL Duration `json:"twab_idle_ttl" yaml:"twab_idle_ttl"`
+}
+
+// CompressionConfig tunes gzip on the HTTP API (see compression.go).
+// Responses smaller than MinResponseBytes are sent uncompressed, and a
+// gzip request body decompressing past MaxDecompressedBytes is rejected
+// (zero leaves it uncapped).
+type CompressionConfig struct {
+	MinResponseBytes     int   `json:"min_response_bytes" yaml:"min_response_bytes"`
+	MaxDecompressedBytes int64 `json:"max_decompressed_bytes" yaml:"max_decompressed_bytes"`
+}
+
+// WatchlistConfig tunes GET /watchlist (see watchlist.go).  The list keeps
+// the top Size pending addresses overall and per chain, with scores
+// halving every HalfLife since an address was last reported (zero never
+// decays).  Responses may be cached for MaxAge, and each API key may poll
+// RequestsPerHour times an hour (zero leaves it unlimited).
+type WatchlistConfig struct {
+	Size            int      `json:"size" yaml:"size"`
+	HalfLife        Duration `json:"half_life" yaml:"half_life"`
+	MaxAge          Duration `json:"max_age" yaml:"max_age"`
+	RequestsPerHour float64  `json:"requests_per_hour" yaml:"requests_per_hour"`
+}
+
+// FeedbackConfig tunes POST /feedback (see feedback.go).  An address
+// disputed by Threshold distinct keys is escalated.  Each key may send
+// RequestsPerHour disputes an hour (zero leaves it unlimited), and at most
+// MaxAddresses addresses carry open disputes.
+type FeedbackConfig struct {
+	Threshold       int     `json:"threshold" yaml:"threshold"`
+	RequestsPerHour float64 `json:"requests_per_hour" yaml:"requests_per_hour"`
+	MaxAddresses    int     `json:"max_addresses" yaml:"max_addresses"`
+}
+
+// ReviewConfig chooses between promoting on consensus and queueing for an
+// analyst (see review.go).  CategoryPolicy overrides Policy for reports of
+// that category.  A rejected address is not queued again for
+// RejectCooldown.
+type ReviewConfig struct {
+	Policy         PromotionPolicy            `json:"policy" yaml:"policy"`
+	CategoryPolicy map[string]PromotionPolicy `json:"category_policy" yaml:"category_policy"`
+	RejectCooldown Duration                   `json:"reject_cooldown" yaml:"reject_cooldown"`
+}
+
+// PersistenceConfig holds on-disk state locations.
+type PersistenceConfig struct {
+	// SigningKeyFile is the filter signing keyring; empty uses an
+	// ephemeral key.
+	SigningKeyFile string `json:"signing_key_file" yaml:"signing_key_file"`
+
+	// QuotaStateFile keeps per-source quota counters and bans across
+	// restarts; empty keeps them in memory only.
+	QuotaStateFile string `json:"quota_state_file" yaml:"quota_state_file"`
+
+	// AuditLogFile is the append-only JSONL audit log of state changes;
+	// empty disables auditing.
+	AuditLogFile string `json:"audit_log_file" yaml:"audit_log_file"`
+
+	// AllowlistFile lists addresses consensus may never promote, one per
+	// line, alongside those allowlisted through the admin API.  It is
+	// read at startup and on every reload (see reload.go).
+	AllowlistFile string `json:"allowlist_file" yaml:"allowlist_file"`
+
+	// ReviewQueueFile keeps the review queue and rejection cooldowns
+	// across restarts; empty keeps them in memory only.
+	ReviewQueueFile string `json:"review_queue_file" yaml:"review_queue_file"`
+
+	// StateFile keeps the confirmed set, TWAB, allowlist, bans, and
+	// cooldowns across restarts as a checksummed state stream (see
+	// snapshot.go); empty keeps them in memory only.
+	StateFile string `json:"state_file" yaml:"state_file"`
+}
+
+// AlertConfig controls promotion alerts.  Alerts are collected and
+// delivered once per Interval, at most MaxPerInterval per sink; the rest
+// are folded into the last one delivered.
+type AlertConfig struct {
+	// WebhookURL receives Slack-compatible JSON for each alert.
+	WebhookURL string `json:"webhook_url" yaml:"webhook_url"`
+
+	// Log writes each alert to the server log.
+	Log bool `json:"log" yaml:"log"`
+
+	// Categories limits alerts to these categories; empty alerts on all.
+	Categories []string `json:"categories" yaml:"categories"`
+
+	Interval       Duration `json:"interval" yaml:"interval"`
+	MaxPerInterval int      `json:"max_per_interval" yaml:"max_per_interval"`
+
+	// Timeout bounds a single delivery to a sink.
+	Timeout Duration `json:"timeout" yaml:"timeout"`
+
+	// BurnRate alerts on bursts of promotions on a chain.
+	BurnRate BurnRateConfig `json:"burn_rate" yaml:"burn_rate"`
+}
+
+// BurnRateConfig trips a chain's burn-rate alert when its promotions over
+// ShortWindow run at Multiplier times their rate over the LongWindow
+// before it, with at least MinPromotions in ShortWindow (see
+// burnrate.go); zero Multiplier disables the detector.  AutoReview queues
+// a tripped chain's promotions for review until the alert is
+// acknowledged.
+type BurnRateConfig struct {
+	ShortWindow   Duration `json:"short_window" yaml:"short_window"`
+	LongWindow    Duration `json:"long_window" yaml:"long_window"`
+	Multiplier    float64  `json:"multiplier" yaml:"multiplier"`
+	MinPromotions int      `json:"min_promotions" yaml:"min_promotions"`
+	AutoReview    bool     `json:"auto_review" yaml:"auto_review"`
+}
+
+// Duration is a time.Duration written as a Go duration string ("250ms")
+// in config files.
+type Duration time.Duration
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"500ms\": %w", err)
+	}
+	return d.set(s)
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	return d.set(node.Value)
+}
+
+func (d *Duration) set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// DefaultConfig returns the built-in defaults.
+func DefaultConfig() Config {
+	return Config{
+		ListenAddr:     ":9090",
+		RequestTimeout: Duration(30 * time.Second),
+
+		AllowUnknownChains: true,
+		TWAB:               DefaultTWABConfig(),
+		Bloom: BloomConfig{
+			ExpectedItems:     1000000,
+			FalsePositiveRate: 0.001,
+			Hash:              HashXXH64,
+			OverflowPolicy:    OverflowReject,
+		},
+		Push: PushConfig{
+			SubscriberBuffer: 16,
+			MaxSubscriberBuffer: map[Role]int{
+				RoleSubscriber: 64,
+				RoleEnterprise: 256,
+			},
+			EvictAfterDrops: 32,
+			EvictAfterIdle:  Duration(5 * time.Minute),
+			ResumeHistory:   defaultFilterHistory,
+			BitPatchHistory: 8,
+
+			MaxSubscriptionsPerKey: 8,
+			ChunkSize:              512 * 1024,
+
+			AckTimeout:         Duration(30 * time.Second),
+			AckMaxRedeliveries: 3,
+
+			CategorySeverity: map[string]Severity{
+				"drainer":         SeverityCritical,
+				sanctionsCategory: SeverityCritical,
+				"phishing":        SeverityHigh,
+			},
+		},
+		Ingest: IngestConfig{
+			QueueSize:         10000,
+			Workers:           4,
+			MaxFutureSkew:     Duration(5 * time.Minute),
+			IdempotencyWindow: Duration(10 * time.Minute),
+			IdempotencyKeys:   100000,
+			MaxBodyBytes:      64 << 10,
+			MaxBatchBodyBytes: 8 << 20,
+		},
+		Expiry: ExpiryConfig{SweepInterval: Duration(time.Minute)},
+		Cooldown: CooldownConfig{
+			Unblocked:  Duration(24 * time.Hour),
+			Retracted:  Duration(24 * time.Hour),
+			Expired:    Duration(time.Hour),
+			Disputed:   Duration(24 * time.Hour),
+			MaxEntries: 100000,
+		},
+		SLO:         SLOConfig{Target: Duration(15 * time.Minute), MaxSamples: 100000},
+		Calibration: CalibrationConfig{MinOutcomes: 20, MaxSources: 10000},
+		Retention:   RetentionConfig{BatchSize: 500},
+		Tripwire:    TripwireConfig{MaxDistance: 2},
+		Maintenance: MaintenanceConfig{
+			Interval:   Duration(time.Minute),
+			TaskBudget: Duration(10 * time.Second),
+		},
+		Compression: CompressionConfig{MinResponseBytes: 1024, MaxDecompressedBytes: 32 << 20},
+		Watchlist: WatchlistConfig{
+			Size:            100,
+			HalfLife:        Duration(24 * time.Hour),
+			MaxAge:          Duration(5 * time.Minute),
+			RequestsPerHour: 10,
+		},
+		Feedback: FeedbackConfig{Threshold: 3, RequestsPerHour: 20, MaxAddresses: 10000},
+		Review:   ReviewConfig{Policy: PolicyAuto, RejectCooldown: Duration(24 * time.Hour)},
+		Quota: QuotaConfig{
+			BanDuration:    Duration(time.Hour),
+			MaxBanDuration: Duration(7 * 24 * time.Hour),
+		},
+		Alerts: AlertConfig{
+			Interval:       Duration(10 * time.Second),
+			MaxPerInterval: 5,
+			Timeout:        Duration(5 * time.Second),
+			BurnRate: BurnRateConfig{
+				ShortWindow:   Duration(5 * time.Minute),
+				LongWindow:    Duration(6 * time.Hour),
+				MinPromotions: 20,
+			},
+		},
+		Replication: ReplicationConfig{Timeout: Duration(5 * time.Second)},
+		Staging:     StagingConfig{SoakPeriod: Duration(time.Hour)},
+		Sanctions:   SanctionsConfig{Interval: Duration(time.Hour), Timeout: Duration(30 * time.Second)},
+		EvidenceVerification: EvidenceVerificationConfig{
+			Confirmations:     12,
+			RequestsPerSecond: 10,
+			CacheSize:         10000,
+			Recheck:           Duration(time.Minute),
+			MaxChecks:         30,
+			Timeout:           Duration(10 * time.Second),
+		},
+		Limits: LimitsConfig{
+			MaxBodyBytes:       1 << 20,
+			MaxImportBodyBytes: 256 << 20,
+			ReadHeaderTimeout:  Duration(10 * time.Second),
+			ReadTimeout:        Duration(time.Minute),
+			IdleTimeout:        Duration(2 * time.Minute),
+		},
+		Events: EventsConfig{History: 10000, SubscriberBuffer: 256},
+	}
+}
+
+// configField is one setting overridable from the environment and flags.
+type configField struct {
+	flag  string
+	env   string
+	usage string
+	set   func(c *Config, v string) error
+}
+
+var configFields = []configField{
+	{"listen", "AEGIS_LISTEN_ADDR", "HTTP listen address", func(c *Config, v string) error {
+		c.ListenAddr = v
+		return nil
+	}},
+	{"request-timeout", "AEGIS_REQUEST_TIMEOUT", "bound on each HTTP request but WebSockets and long polls, e.g. 30s (0 disables)", func(c *Config, v string) error {
+		return c.RequestTimeout.set(v)
+	}},
+	{"tls-cert", "AEGIS_TLS_CERT", "TLS certificate file", func(c *Config, v string) error {
+		c.TLS.CertFile = v
+		return nil
+	}},
+	{"tls-key", "AEGIS_TLS_KEY", "TLS private key file", func(c *Config, v string) error {
+		c.TLS.KeyFile = v
+		return nil
+	}},
+	{"h2c", "AEGIS_H2C", "serve cleartext HTTP/2 on listeners without TLS (true/false)", func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.TLS.H2C = b
+		return err
+	}},
+	{"twab-min-reports", "AEGIS_TWAB_MIN_REPORTS", "reports required for promotion", intSetter(func(c *Config) *int { return &c.TWAB.MinReportCount })},
+	{"twab-min-span", "AEGIS_TWAB_MIN_SPAN_SECONDS", "seconds between first and last report required for promotion", floatSetter(func(c *Config) *float64 { return &c.TWAB.MinTimeSpanSeconds })},
+	{"twab-min-sources", "AEGIS_TWAB_MIN_SOURCES", "distinct sources required for promotion", intSetter(func(c *Config) *int { return &c.TWAB.MinDistinctSources })},
+	{"twab-min-networks", "AEGIS_TWAB_MIN_NETWORKS", "distinct reporter networks (/16, /48 or ASN) required for promotion (0 disables)", intSetter(func(c *Config) *int { return &c.TWAB.MinDistinctNetworks })},
+	{"twab-min-confidence", "AEGIS_TWAB_MIN_AVERAGE_CONFIDENCE", "mean report confidence required for promotion (0 disables)", floatSetter(func(c *Config) *float64 { return &c.TWAB.MinAverageConfidence })},
+	{"twab-min-weighted-score", "AEGIS_TWAB_MIN_WEIGHTED_SCORE", "summed source confidence required for promotion (0 disables)", floatSetter(func(c *Config) *float64 { return &c.TWAB.MinWeightedScore })},
+	{"twab-max-source-contribution", "AEGIS_TWAB_MAX_SOURCE_CONTRIBUTION", "share of twab-min-weighted-score one source may contribute, in (0, 1]", floatSetter(func(c *Config) *float64 { return &c.TWAB.MaxSourceContribution })},
+	{"twab-promotion-score", "AEGIS_TWAB_PROMOTION_SCORE", "consensus score required for promotion, in (0, 1]", floatSetter(func(c *Config) *float64 { return &c.TWAB.PromotionScore })},
+	{"twab-weight-reports", "AEGIS_TWAB_WEIGHT_REPORTS", "consensus score weight of the report count", floatSetter(func(c *Config) *float64 { return &c.TWAB.ScoreWeights.Reports })},
+	{"twab-weight-sources", "AEGIS_TWAB_WEIGHT_SOURCES", "consensus score weight of distinct sources", floatSetter(func(c *Config) *float64 { return &c.TWAB.ScoreWeights.Sources })},
+	{"twab-weight-span", "AEGIS_TWAB_WEIGHT_SPAN", "consensus score weight of the time span", floatSetter(func(c *Config) *float64 { return &c.TWAB.ScoreWeights.TimeSpan })},
+	{"twab-weight-confidence", "AEGIS_TWAB_WEIGHT_CONFIDENCE", "consensus score weight of the mean confidence", floatSetter(func(c *Config) *float64 { return &c.TWAB.ScoreWeights.Confidence })},
+	{"twab-retain-reports", "AEGIS_TWAB_RETAIN_REPORTS", "recent reports kept per address for the detail view", intSetter(func(c *Config) *int { return &c.TWAB.RetainReports })},
+	{"twab-trusted-confidence", "AEGIS_TWAB_TRUSTED_CONFIDENCE", "confidence with which one trusted-tier report promotes alone (0 disables)", floatSetter(func(c *Config) *float64 { return &c.TWAB.TrustedConfidence })},
+	{"twab-tier-weights", "AEGIS_TWAB_TIER_WEIGHTS", "weighted score weight of each source tier, e.g. anonymous=0.5,trusted=2", func(c *Config, v string) error {
+		c.TWAB.TierWeights = make(map[SourceTier]float64)
+		for _, pair := range strings.Split(v, ",") {
+			tier, weight, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || tier == "" {
+				return fmt.Errorf("invalid tier weight %q, want tier=weight", pair)
+			}
+			w, err := strconv.ParseFloat(weight, 64)
+			if err != nil {
+				return fmt.Errorf("invalid tier weight %q: %v", pair, err)
+			}
+			c.TWAB.TierWeights[SourceTier(tier)] = w
+		}
+		return nil
+	}},
+	{"twab-require-evidence", "AEGIS_TWAB_REQUIRE_EVIDENCE", "promote only addresses with at least one report citing evidence (true/false)", func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.TWAB.RequireEvidenceForPromotion = b
+		return err
+	}},
+	{"twab-require-verified-evidence", "AEGIS_TWAB_REQUIRE_VERIFIED_EVIDENCE", "promote only addresses citing a transaction verified on chain (true/false)", func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.TWAB.RequireVerifiedEvidence = b
+		return err
+	}},
+	{"bloom-expected-items", "AEGIS_BLOOM_EXPECTED_ITEMS", "expected filter size", func(c *Config, v string) error {
+		n, err := strconv.ParseUint(v, 10, 0)
+		c.Bloom.ExpectedItems = uint(n)
+		return err
+	}},
+	{"bloom-fp-rate", "AEGIS_BLOOM_FP_RATE", "target filter false-positive rate", floatSetter(func(c *Config) *float64 { return &c.Bloom.FalsePositiveRate })},
+	{"bloom-rebuild-fill-ratio", "AEGIS_BLOOM_REBUILD_FILL_RATIO", "rebuild the filter resized once this fraction of its bits is set (0 never)", floatSetter(func(c *Config) *float64 { return &c.Bloom.RebuildFillRatio })},
+	{"bloom-hash", "AEGIS_BLOOM_HASH", "hash algorithm of the filter encoding: xxh64 or fnv1a-64", func(c *Config, v string) error {
+		c.Bloom.Hash = v
+		return nil
+	}},
+	{"bloom-auto-size-horizon", "AEGIS_BLOOM_AUTO_SIZE_HORIZON", "resize the filter ahead of the confirmed set's growth projected this far, e.g. 2160h (0 never)", func(c *Config, v string) error {
+		return c.Bloom.AutoSizeHorizon.set(v)
+	}},
+	{"bloom-auto-size-headroom", "AEGIS_BLOOM_AUTO_SIZE_HEADROOM", "times the projected size an auto-sized filter is sized for, above 1", floatSetter(func(c *Config) *float64 { return &c.Bloom.AutoSizeHeadroom })},
+	{"max-filter-entries", "AEGIS_MAX_FILTER_ENTRIES", "cap on the confirmed set consensus may grow (0 unbounded)", intSetter(func(c *Config) *int { return &c.Bloom.MaxFilterEntries })},
+	{"filter-overflow-policy", "AEGIS_FILTER_OVERFLOW_POLICY", "what a promotion past max-filter-entries does: reject, evict, or rebuild", func(c *Config, v string) error {
+		c.Bloom.OverflowPolicy = OverflowPolicy(v)
+		return nil
+	}},
+	{"push-debounce", "AEGIS_PUSH_DEBOUNCE", "coalesce filter pushes within this window, e.g. 250ms", func(c *Config, v string) error {
+		return c.Push.Debounce.set(v)
+	}},
+	{"subscriber-buffer", "AEGIS_SUBSCRIBER_BUFFER", "pushes queued per subscriber", intSetter(func(c *Config) *int { return &c.Push.SubscriberBuffer })},
+	{"subscriber-evict-drops", "AEGIS_SUBSCRIBER_EVICT_DROPS", "disconnect a subscriber after this many consecutive skipped pushes (0 never)", intSetter(func(c *Config) *int { return &c.Push.EvictAfterDrops })},
+	{"subscriber-evict-idle", "AEGIS_SUBSCRIBER_EVICT_IDLE", "disconnect a subscriber skipping pushes after this long without a delivery, e.g. 5m (0 never)", func(c *Config, v string) error {
+		return c.Push.EvictAfterIdle.set(v)
+	}},
+	{"resume-history", "AEGIS_RESUME_HISTORY", "filter changes retained for subscriber resume", intSetter(func(c *Config) *int { return &c.Push.ResumeHistory })},
+	{"bit-patch-history", "AEGIS_BIT_PATCH_HISTORY", "filter versions whose bits are retained for bit-patch resume", intSetter(func(c *Config) *int { return &c.Push.BitPatchHistory })},
+	{"push-chunk-size", "AEGIS_PUSH_CHUNK_SIZE", "split WebSocket messages larger than this many bytes into chunks (0 never)", intSetter(func(c *Config) *int { return &c.Push.ChunkSize })},
+	{"push-ack-timeout", "AEGIS_PUSH_ACK_TIMEOUT", "resend the filter to an acknowledging subscriber that has not acked the latest version after this long, e.g. 30s (0 never)", func(c *Config, v string) error {
+		return c.Push.AckTimeout.set(v)
+	}},
+	{"push-ack-max-redeliveries", "AEGIS_PUSH_ACK_MAX_REDELIVERIES", "resends per unacknowledged version", intSetter(func(c *Config) *int { return &c.Push.AckMaxRedeliveries })},
+	{"push-category-severity", "AEGIS_PUSH_CATEGORY_SEVERITY", "severity of each category's additions for scheduled subscriptions, e.g. drainer=critical,phishing=high", func(c *Config, v string) error {
+		c.Push.CategorySeverity = make(map[string]Severity)
+		for _, pair := range strings.Split(v, ",") {
+			category, severity, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || category == "" {
+				return fmt.Errorf("invalid category severity %q, want category=severity", pair)
+			}
+			c.Push.CategorySeverity[category] = Severity(severity)
+		}
+		return nil
+	}},
+	{"subscriber-max-per-key", "AEGIS_SUBSCRIBER_MAX_PER_KEY", "concurrent WebSocket subscriptions allowed per API key (0 unlimited)", intSetter(func(c *Config) *int { return &c.Push.MaxSubscriptionsPerKey })},
+	{"ingest-sync", "AEGIS_INGEST_SYNC", "process reports in the request handler instead of queueing (true/false)", func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.Ingest.Synchronous = b
+		return err
+	}},
+	{"ingest-queue", "AEGIS_INGEST_QUEUE", "reports queued for the ingest workers before shedding load", intSetter(func(c *Config) *int { return &c.Ingest.QueueSize })},
+	{"ingest-workers", "AEGIS_INGEST_WORKERS", "ingest worker goroutines", intSetter(func(c *Config) *int { return &c.Ingest.Workers })},
+	{"ingest-max-future-skew", "AEGIS_INGEST_MAX_FUTURE_SKEW", "reject reports timestamped more than this ahead of server time, e.g. 5m (0 only clamps)", func(c *Config, v string) error {
+		return c.Ingest.MaxFutureSkew.set(v)
+	}},
+	{"ingest-idempotency-window", "AEGIS_INGEST_IDEMPOTENCY_WINDOW", "how long report IDs are remembered for replay detection, e.g. 10m", func(c *Config, v string) error {
+		return c.Ingest.IdempotencyWindow.set(v)
+	}},
+	{"ingest-idempotency-keys", "AEGIS_INGEST_IDEMPOTENCY_KEYS", "report IDs remembered for replay detection (0 disables)", intSetter(func(c *Config) *int { return &c.Ingest.IdempotencyKeys })},
+	{"ingest-max-body-bytes", "AEGIS_INGEST_MAX_BODY_BYTES", "largest POST /ingest body accepted (0 unbounded)", int64Setter(func(c *Config) *int64 { return &c.Ingest.MaxBodyBytes })},
+	{"ingest-max-batch-body-bytes", "AEGIS_INGEST_MAX_BATCH_BODY_BYTES", "largest POST /ingest/batch body accepted (0 unbounded)", int64Setter(func(c *Config) *int64 { return &c.Ingest.MaxBatchBodyBytes })},
+	{"max-body-bytes", "AEGIS_MAX_BODY_BYTES", "request body cap of routes without their own (0 unbounded)", int64Setter(func(c *Config) *int64 { return &c.Limits.MaxBodyBytes })},
+	{"max-import-body-bytes", "AEGIS_MAX_IMPORT_BODY_BYTES", "request body cap of feed import, merge, snapshot import and replication (0 unbounded)", int64Setter(func(c *Config) *int64 { return &c.Limits.MaxImportBodyBytes })},
+	{"read-header-timeout", "AEGIS_READ_HEADER_TIMEOUT", "time a client has to send request headers (0 disables)", func(c *Config, v string) error {
+		return c.Limits.ReadHeaderTimeout.set(v)
+	}},
+	{"read-timeout", "AEGIS_READ_TIMEOUT", "time a client has to send a whole request, WebSockets and long polls aside (0 disables)", func(c *Config, v string) error {
+		return c.Limits.ReadTimeout.set(v)
+	}},
+	{"idle-timeout", "AEGIS_IDLE_TIMEOUT", "how long an idle keep-alive connection is kept open (0 disables)", func(c *Config, v string) error {
+		return c.Limits.IdleTimeout.set(v)
+	}},
+	{"compression-min-response-bytes", "AEGIS_COMPRESSION_MIN_RESPONSE_BYTES", "smallest response gzipped for clients that accept it", intSetter(func(c *Config) *int { return &c.Compression.MinResponseBytes })},
+	{"compression-max-decompressed-bytes", "AEGIS_COMPRESSION_MAX_DECOMPRESSED_BYTES", "largest gzip request body accepted once decompressed (0 unbounded)", int64Setter(func(c *Config) *int64 { return &c.Compression.MaxDecompressedBytes })},
+	{"watchlist-size", "AEGIS_WATCHLIST_SIZE", "pending addresses kept on the watchlist, overall and per chain", intSetter(func(c *Config) *int { return &c.Watchlist.Size })},
+	{"watchlist-half-life", "AEGIS_WATCHLIST_HALF_LIFE", "time since the last report that halves a watchlist score, e.g. 24h (0 never decays)", func(c *Config, v string) error {
+		return c.Watchlist.HalfLife.set(v)
+	}},
+	{"watchlist-max-age", "AEGIS_WATCHLIST_MAX_AGE", "how long clients may cache the watchlist, e.g. 5m", func(c *Config, v string) error {
+		return c.Watchlist.MaxAge.set(v)
+	}},
+	{"watchlist-requests-per-hour", "AEGIS_WATCHLIST_REQUESTS_PER_HOUR", "watchlist polls per hour per API key (0 unlimited)", floatSetter(func(c *Config) *float64 { return &c.Watchlist.RequestsPerHour })},
+	{"feedback-threshold", "AEGIS_FEEDBACK_THRESHOLD", "distinct API keys disputing an address before it is escalated", intSetter(func(c *Config) *int { return &c.Feedback.Threshold })},
+	{"feedback-requests-per-hour", "AEGIS_FEEDBACK_REQUESTS_PER_HOUR", "disputes per hour per API key (0 unlimited)", floatSetter(func(c *Config) *float64 { return &c.Feedback.RequestsPerHour })},
+	{"feedback-max-addresses", "AEGIS_FEEDBACK_MAX_ADDRESSES", "addresses carrying open disputes at once", intSetter(func(c *Config) *int { return &c.Feedback.MaxAddresses })},
+	{"ingest-rate", "AEGIS_INGEST_RATE", "ingest requests per second per client (0 disables)", floatSetter(func(c *Config) *float64 { return &c.RateLimit.IngestPerSecond })},
+	{"ingest-burst", "AEGIS_INGEST_BURST", "ingest request burst per client", intSetter(func(c *Config) *int { return &c.RateLimit.IngestBurst })},
+	{"expiry-ttl", "AEGIS_EXPIRY_TTL", "drop confirmed addresses after this long without reports, e.g. 720h (0 never)", func(c *Config, v string) error {
+		return c.Expiry.TTL.set(v)
+	}},
+	{"expiry-category-ttl", "AEGIS_EXPIRY_CATEGORY_TTL", "per-category TTL overrides, e.g. phishing=72h,drainer=0s", func(c *Config, v string) error {
+		c.Expiry.CategoryTTL = make(map[string]Duration)
+		for _, pair := range strings.Split(v, ",") {
+			category, ttl, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || category == "" {
+				return fmt.Errorf("invalid category TTL %q, want category=duration", pair)
+			}
+			var d Duration
+			if err := d.set(ttl); err != nil {
+				return err
+			}
+			c.Expiry.CategoryTTL[category] = d
+		}
+		return nil
+	}},
+	{"expiry-sweep-interval", "AEGIS_EXPIRY_SWEEP_INTERVAL", "how often to sweep for expired addresses", func(c *Config, v string) error {
+		return c.Expiry.SweepInterval.set(v)
+	}},
+	{"cooldown-unblocked", "AEGIS_COOLDOWN_UNBLOCKED", "how long an unblocked address may not be promoted again (0 disables)", func(c *Config, v string) error {
+		return c.Cooldown.Unblocked.set(v)
+	}},
+	{"cooldown-retracted", "AEGIS_COOLDOWN_RETRACTED", "how long a staged address unblocked before graduating may not be promoted again (0 disables)", func(c *Config, v string) error {
+		return c.Cooldown.Retracted.set(v)
+	}},
+	{"cooldown-expired", "AEGIS_COOLDOWN_EXPIRED", "how long an expired address may not be promoted again (0 disables)", func(c *Config, v string) error {
+		return c.Cooldown.Expired.set(v)
+	}},
+	{"cooldown-disputed", "AEGIS_COOLDOWN_DISPUTED", "how long an address demoted on subscriber disputes may not be promoted again (0 disables)", func(c *Config, v string) error {
+		return c.Cooldown.Disputed.set(v)
+	}},
+	{"cooldown-max-entries", "AEGIS_COOLDOWN_MAX_ENTRIES", "removed addresses cooling down at once", intSetter(func(c *Config) *int { return &c.Cooldown.MaxEntries })},
+	{"slo-target", "AEGIS_SLO_TARGET", "first report to push latency promotions should stay within, e.g. 15m", func(c *Config, v string) error {
+		return c.SLO.Target.set(v)
+	}},
+	{"slo-max-samples", "AEGIS_SLO_MAX_SAMPLES", "promotion latency timelines kept for /stats/slo", intSetter(func(c *Config) *int { return &c.SLO.MaxSamples })},
+	{"calibration-min-outcomes", "AEGIS_CALIBRATION_MIN_OUTCOMES", "outcomes a source needs before its confidence is calibrated (0 disables)", intSetter(func(c *Config) *int { return &c.Calibration.MinOutcomes })},
+	{"calibration-max-sources", "AEGIS_CALIBRATION_MAX_SOURCES", "sources with a confidence calibration curve", intSetter(func(c *Config) *int { return &c.Calibration.MaxSources })},
+	{"maintenance-interval", "AEGIS_MAINTENANCE_INTERVAL", "how often to run background maintenance", func(c *Config, v string) error {
+		return c.Maintenance.Interval.set(v)
+	}},
+	{"maintenance-task-budget", "AEGIS_MAINTENANCE_TASK_BUDGET", "time each maintenance task may run per tick", func(c *Config, v string) error {
+		return c.Maintenance.TaskBudget.set(v)
+	}},
+	{"twab-idle-ttl", "AEGIS_TWAB_IDLE_TTL", "drop TWAB history of unconfirmed addresses idle this long (0 keeps it)", func(c *Config, v string) error {
+		return c.Maintenance.TWABIdleTTL.set(v)
+	}},
+	{"retention-unpromoted-reports", "AEGIS_RETENTION_UNPROMOTED_REPORTS", "delete retained reports of never-promoted addresses this long after receipt (0 keeps them)", func(c *Config, v string) error {
+		return c.Retention.UnpromotedReports.set(v)
+	}},
+	{"retention-promoted-reports", "AEGIS_RETENTION_PROMOTED_REPORTS", "compact retained reports of promoted addresses this long after receipt (0 keeps them)", func(c *Config, v string) error {
+		return c.Retention.PromotedReports.set(v)
+	}},
+	{"retention-batch-size", "AEGIS_RETENTION_BATCH_SIZE", "addresses pruned per TWAB shard lock", intSetter(func(c *Config) *int { return &c.Retention.BatchSize })},
+	{"tripwire-max-distance", "AEGIS_TRIPWIRE_MAX_DISTANCE", "edits from a protected address within which a promotion is queued for review", intSetter(func(c *Config) *int { return &c.Tripwire.MaxDistance })},
+	{"tripwire-prefix-length", "AEGIS_TRIPWIRE_PREFIX_LENGTH", "leading hex digits shared with a protected address that queue a promotion for review (0 disables)", intSetter(func(c *Config) *int { return &c.Tripwire.PrefixLength })},
+	{"high-value-file", "AEGIS_HIGH_VALUE_FILE", "file of high-value addresses the tripwire protects, one per line, re-read on reload", func(c *Config, v string) error {
+		c.Tripwire.HighValueFile = v
+		return nil
+	}},
+	{"promotion-policy", "AEGIS_PROMOTION_POLICY", "auto to promote on consensus, review to queue for an analyst", func(c *Config, v string) error {
+		c.Review.Policy = PromotionPolicy(v)
+		return nil
+	}},
+	{"promotion-category-policy", "AEGIS_PROMOTION_CATEGORY_POLICY", "per-category promotion policy overrides, e.g. sanctioned=review,drainer=auto", func(c *Config, v string) error {
+		c.Review.CategoryPolicy = make(map[string]PromotionPolicy)
+		for _, pair := range strings.Split(v, ",") {
+			category, policy, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || category == "" {
+				return fmt.Errorf("invalid category policy %q, want category=policy", pair)
+			}
+			c.Review.CategoryPolicy[category] = PromotionPolicy(policy)
+		}
+		return nil
+	}},
+	{"chains", "AEGIS_CHAINS", "chain IDs to accept beyond the well-known ones, e.g. 31337=Anvil,1337=Devnet", func(c *Config, v string) error {
+		c.Chains = make(map[int]string)
+		for _, pair := range strings.Split(v, ",") {
+			id, name, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			chainID, err := strconv.Atoi(id)
+			if !ok || err != nil {
+				return fmt.Errorf("invalid chain %q, want id=name", pair)
+			}
+			c.Chains[chainID] = name
+		}
+		return nil
+	}},
+	{"allow-unknown-chains", "AEGIS_ALLOW_UNKNOWN_CHAINS", "accept reports for chains outside the registry, naming them unknown", func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.AllowUnknownChains = b
+		return err
+	}},
+	{"staging", "AEGIS_STAGING", "hold new promotions in a staging filter before the main one (true/false)", func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.Staging.Enabled = b
+		return err
+	}},
+	{"staging-soak-period", "AEGIS_STAGING_SOAK_PERIOD", "how long a new promotion stays in staging before graduating", func(c *Config, v string) error {
+		return c.Staging.SoakPeriod.set(v)
+	}},
+	{"events-history", "AEGIS_EVENTS_HISTORY", "recent events kept for /events clients resuming with since", intSetter(func(c *Config) *int { return &c.Events.History })},
+	{"events-subscriber-buffer", "AEGIS_EVENTS_SUBSCRIBER_BUFFER", "events queued per /events connection before it is closed as too slow", intSetter(func(c *Config) *int { return &c.Events.SubscriberBuffer })},
+	{"review-reject-cooldown", "AEGIS_REVIEW_REJECT_COOLDOWN", "how long a rejected address is kept out of the review queue", func(c *Config, v string) error {
+		return c.Review.RejectCooldown.set(v)
+	}},
+	{"signing-key", "AEGIS_SIGNING_KEY_FILE", "filter signing keyring path (created if missing; empty for an ephemeral key)", func(c *Config, v string) error {
+		c.Persistence.SigningKeyFile = v
+		return nil
+	}},
+	{"quota-state", "AEGIS_QUOTA_STATE_FILE", "file persisting source quotas and bans across restarts", func(c *Config, v string) error {
+		c.Persistence.QuotaStateFile = v
+		return nil
+	}},
+	{"audit-log", "AEGIS_AUDIT_LOG_FILE", "append-only JSONL audit log of state changes (empty disables auditing)", func(c *Config, v string) error {
+		c.Persistence.AuditLogFile = v
+		return nil
+	}},
+	{"allowlist-file", "AEGIS_ALLOWLIST_FILE", "file of addresses never promoted, one per line, re-read on reload", func(c *Config, v string) error {
+		c.Persistence.AllowlistFile = v
+		return nil
+	}},
+	{"review-queue", "AEGIS_REVIEW_QUEUE_FILE", "file persisting the review queue across restarts", func(c *Config, v string) error {
+		c.Persistence.ReviewQueueFile = v
+		return nil
+	}},
+	{"state-file", "AEGIS_STATE_FILE", "file persisting the confirmed set, TWAB, allowlist, and bans across restarts", func(c *Config, v string) error {
+		c.Persistence.StateFile = v
+		return nil
+	}},
+	{"quota-reports-per-hour", "AEGIS_QUOTA_REPORTS_PER_HOUR", "reports per source per hour before a ban (0 disables)", intSetter(func(c *Config) *int { return &c.Quota.ReportsPerHour })},
+	{"quota-unique-per-day", "AEGIS_QUOTA_UNIQUE_PER_DAY", "unique addresses per source per day before a ban (0 disables)", intSetter(func(c *Config) *int { return &c.Quota.UniqueAddressesPerDay })},
+	{"quota-ban", "AEGIS_QUOTA_BAN", "first ban duration; doubles per repeat offense", func(c *Config, v string) error {
+		return c.Quota.BanDuration.set(v)
+	}},
+	{"quota-max-ban", "AEGIS_QUOTA_MAX_BAN", "longest ban duration", func(c *Config, v string) error {
+		return c.Quota.MaxBanDuration.set(v)
+	}},
+	{"alert-webhook", "AEGIS_ALERT_WEBHOOK_URL", "Slack-compatible webhook notified of promotions", func(c *Config, v string) error {
+		c.Alerts.WebhookURL = v
+		return nil
+	}},
+	{"alert-log", "AEGIS_ALERT_LOG", "log promotion alerts (true/false)", func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.Alerts.Log = b
+		return err
+	}},
+	{"alert-categories", "AEGIS_ALERT_CATEGORIES", "comma-separated categories to alert on (empty for all)", func(c *Config, v string) error {
+		c.Alerts.Categories = nil
+		for _, category := range strings.Split(v, ",") {
+			if category = strings.TrimSpace(category); category != "" {
+				c.Alerts.Categories = append(c.Alerts.Categories, category)
+			}
+		}
+		return nil
+	}},
+	{"alert-interval", "AEGIS_ALERT_INTERVAL", "window over which alerts are coalesced", func(c *Config, v string) error {
+		return c.Alerts.Interval.set(v)
+	}},
+	{"replication-instance", "AEGIS_REPLICATION_INSTANCE_ID", "this instance's ID among its replication peers", func(c *Config, v string) error {
+		c.Replication.InstanceID = v
+		return nil
+	}},
+	{"replication-peers", "AEGIS_REPLICATION_PEERS", "comma-separated peer aggregator URLs to replicate promotions to", func(c *Config, v string) error {
+		c.Replication.Peers = nil
+		for _, peer := range strings.Split(v, ",") {
+			if peer = strings.TrimSpace(peer); peer != "" {
+				c.Replication.Peers = append(c.Replication.Peers, peer)
+			}
+		}
+		return nil
+	}},
+	{"replication-peer-key", "AEGIS_REPLICATION_PEER_KEY", "API key secret presented to replication peers", func(c *Config, v string) error {
+		c.Replication.PeerKey = v
+		return nil
+	}},
+	{"mirror", "AEGIS_MIRROR_UPSTREAM", "upstream aggregator URL to serve a read-only mirror of", func(c *Config, v string) error {
+		c.Mirror.Upstream = v
+		return nil
+	}},
+	{"mirror-api-key", "AEGIS_MIRROR_API_KEY", "API key secret presented to the mirrored upstream", func(c *Config, v string) error {
+		c.Mirror.APIKey = v
+		return nil
+	}},
+	{"standby-of", "AEGIS_STANDBY_OF", "active aggregator URL to run as the warm standby of", func(c *Config, v string) error {
+		c.Standby.Active = v
+		return nil
+	}},
+	{"standby-api-key", "AEGIS_STANDBY_API_KEY", "peer-role API key secret presented to the active aggregator", func(c *Config, v string) error {
+		c.Standby.APIKey = v
+		return nil
+	}},
+	{"standby-failover-after", "AEGIS_STANDBY_FAILOVER_AFTER", "promote the standby once the link to the active has been down this long (0 waits for an admin)", func(c *Config, v string) error {
+		return c.Standby.FailoverAfter.set(v)
+	}},
+	{"sanctions-interval", "AEGIS_SANCTIONS_INTERVAL", "how often sanctions feeds are synced", func(c *Config, v string) error {
+		return c.Sanctions.Interval.set(v)
+	}},
+	{"evidence-confirmations", "AEGIS_EVIDENCE_CONFIRMATIONS", "blocks deep a cited transaction must be to verify", intSetter(func(c *Config) *int { return &c.EvidenceVerification.Confirmations })},
+	{"evidence-requests-per-second", "AEGIS_EVIDENCE_REQUESTS_PER_SECOND", "evidence verification RPC calls allowed per second", floatSetter(func(c *Config) *float64 { return &c.EvidenceVerification.RequestsPerSecond })},
+	{"alert-max-per-interval", "AEGIS_ALERT_MAX_PER_INTERVAL", "alerts delivered per sink each interval before coalescing", intSetter(func(c *Config) *int { return &c.Alerts.MaxPerInterval })},
+	{"alert-burn-rate-multiplier", "AEGIS_ALERT_BURN_RATE_MULTIPLIER", "short-window promotion rate, as a multiple of the long-window rate, that trips a chain's alert (0 disables)", floatSetter(func(c *Config) *float64 { return &c.Alerts.BurnRate.Multiplier })},
+	{"alert-burn-rate-short-window", "AEGIS_ALERT_BURN_RATE_SHORT_WINDOW", "window of the promotion rate checked for bursts", func(c *Config, v string) error {
+		return c.Alerts.BurnRate.ShortWindow.set(v)
+	}},
+	{"alert-burn-rate-long-window", "AEGIS_ALERT_BURN_RATE_LONG_WINDOW", "window before the short one giving the baseline promotion rate", func(c *Config, v string) error {
+		return c.Alerts.BurnRate.LongWindow.set(v)
+	}},
+	{"alert-burn-rate-min-promotions", "AEGIS_ALERT_BURN_RATE_MIN_PROMOTIONS", "promotions in the short window a chain needs to trip", intSetter(func(c *Config) *int { return &c.Alerts.BurnRate.MinPromotions })},
+	{"alert-burn-rate-auto-review", "AEGIS_ALERT_BURN_RATE_AUTO_REVIEW", "queue a tripped chain's promotions for review until its alert is acknowledged (true/false)", func(c *Config, v string) error {
+		b, err := strconv.ParseBool(v)
+		c.Alerts.BurnRate.AutoReview = b
+		return err
+	}},
+}
+
+func intSetter(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		*field(c) = n
+		return err
+	}
+}
+
+func int64Setter(field func(*Config) *int64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		*field(c) = n
+		return err
+	}
+}
+
+func floatSetter(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, v string) error {
+		f, err := strconv.ParseFloat(v, 64)
+		*field(c) = f
+		return err
+	}
+}
+
+// Config file location, by flag or env.
+const (
+	configFileFlag = "config"
+	configFileEnv  = "AEGIS_CONFIG"
+)
+
+// LoadConfig registers the config flags on fs, parses args, and resolves
+// the configuration.  The result is not yet validated.
+func LoadConfig(fs *flag.FlagSet, args []string, getenv func(string) string) (Config, error) {
+	src, err := ParseConfigSource(fs, args, getenv)
+	if err != nil {
+		return Config{}, err
+	}
+	return src.Load()
+}
+
+// ConfigSource is where a configuration comes from: a config file, the
+// environment, and the flags set on the command line.  Load resolves it
+// again each time, which is how a reload picks up an edited file.
+type ConfigSource struct {
+	file   string
+	flags  map[string]string // set config flags by name
+	getenv func(string) string
+}
+
+// ParseConfigSource registers the config flags on fs and parses args.
+func ParseConfigSource(fs *flag.FlagSet, args []string, getenv func(string) string) (ConfigSource, error) {
+	path := fs.String(configFileFlag, "", "config file (JSON or YAML); also "+configFileEnv)
+	values := make(map[string]*string, len(configFields))
+	for _, f := range configFields {
+		values[f.flag] = fs.String(f.flag, "", f.usage+"; also "+f.env)
+	}
+	if err := fs.Parse(args); err != nil {
+		return ConfigSource{}, err
+	}
+
+	src := ConfigSource{file: *path, flags: make(map[string]string), getenv: getenv}
+	if src.file == "" {
+		src.file = getenv(configFileEnv)
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		if v, ok := values[fl.Name]; ok {
+			src.flags[fl.Name] = *v
+		}
+	})
+	return src, nil
+}
+
+// Load resolves the configuration.  The result is not yet validated.
+func (src ConfigSource) Load() (Config, error) {
+	cfg := DefaultConfig()
+	if src.file != "" {
+		if err := cfg.loadFile(src.file); err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, f := range configFields {
+		if v := src.getenv(f.env); v != "" {
+			if err := f.set(&cfg, v); err != nil {
+				return Config{}, fmt.Errorf("%s: %w", f.env, err)
+			}
+		}
+	}
+
+	for _, f := range configFields {
+		if v, ok := src.flags[f.flag]; ok {
+			if err := f.set(&cfg, v); err != nil {
+				return Config{}, fmt.Errorf("-%s: %w", f.flag, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// loadFile overlays the file at path onto c.  Unknown keys are rejected
+// so typos do not silently fall back to defaults.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		err = dec.Decode(c)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(c)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// validateTWAB checks one set of TWAB thresholds, named by prefix.
+func validateTWAB(prefix string, t TWABConfig, fail func(string, ...interface{})) {
+	if t.MinReportCount < 1 {
+		fail("%s.min_report_count must be at least 1, got %d", prefix, t.MinReportCount)
+	}
+	if t.MinDistinctSources < 1 {
+		fail("%s.min_distinct_sources must be at least 1, got %d", prefix, t.MinDistinctSources)
+	}
+	if t.MinDistinctNetworks < 0 {
+		fail("%s.min_distinct_networks must not be negative, got %d", prefix, t.MinDistinctNetworks)
+	}
+	if t.MinTimeSpanSeconds < 0 {
+		fail("%s.min_time_span_seconds must not be negative, got %g", prefix, t.MinTimeSpanSeconds)
+	}
+	if t.MinAverageConfidence < 0 || t.MinAverageConfidence > 1 {
+		fail("%s.min_average_confidence must be between 0 and 1, got %g", prefix, t.MinAverageConfidence)
+	}
+	if t.MinWeightedScore < 0 {
+		fail("%s.min_weighted_score must not be negative, got %g", prefix, t.MinWeightedScore)
+	}
+	if t.MaxSourceContribution < 0 || t.MaxSourceContribution > 1 {
+		fail("%s.max_source_contribution must be between 0 and 1, got %g", prefix, t.MaxSourceContribution)
+	}
+	if t.RetainReports < 0 {
+		fail("%s.retain_reports must not be negative, got %d", prefix, t.RetainReports)
+	}
+	if w := t.ScoreWeights; w.Reports < 0 || w.Sources < 0 || w.TimeSpan < 0 || w.Confidence < 0 {
+		fail("%s.score_weights must not be negative, got %+v", prefix, w)
+	}
+	if t.PromotionScore < 0 || t.PromotionScore > 1 {
+		fail("%s.promotion_score must be between 0 and 1, got %g", prefix, t.PromotionScore)
+	}
+	if t.TrustedConfidence < 0 || t.TrustedConfidence > 1 {
+		fail("%s.trusted_confidence must be between 0 and 1, got %g", prefix, t.TrustedConfidence)
+	}
+	for tier, w := range t.TierWeights {
+		if !tier.valid() {
+			fail("%s.tier_weights: unknown source tier %q", prefix, tier)
+		} else if w < 0 {
+			fail("%s.tier_weights.%s must not be negative, got %g", prefix, tier, w)
+		}
+	}
+	for typ, override := range t.Types {
+		if typ == "" || !typ.valid() {
+			fail("%s.types: unknown indicator type %q", prefix, typ)
+			continue
+		}
+		if len(override.Types) > 0 {
+			fail("%s.types.%s may not have types of its own", prefix, typ)
+		}
+		validateTWAB(prefix+".types."+string(typ), override, fail)
+	}
+	seen := make(map[int]bool, len(t.SeverityBands))
+	for i, band := range t.SeverityBands {
+		name := fmt.Sprintf("%s.severity_bands.%d", prefix, i)
+		if band.MinSeverity < 1 || band.MinSeverity > maxReportSeverity {
+			fail("%s.min_severity must be between 1 and %d, got %d", name, maxReportSeverity, band.MinSeverity)
+		} else if seen[band.MinSeverity] {
+			fail("%s repeats min_severity %d", name, band.MinSeverity)
+		}
+		seen[band.MinSeverity] = true
+		if c := band.Config; len(c.Types) > 0 || len(c.CategoryOverrides) > 0 || len(c.SeverityBands) > 0 {
+			fail("%s may not have types, category_overrides or severity_bands of its own", name)
+		}
+		validateTWAB(name+".config", band.Config, fail)
+	}
+	for category, override := range t.CategoryOverrides {
+		if category == "" {
+			fail("%s.category_overrides: empty category", prefix)
+			continue
+		}
+		if len(override.Types) > 0 || len(override.CategoryOverrides) > 0 {
+			fail("%s.category_overrides.%s may not have types or category_overrides of its own", prefix, category)
+		}
+		validateTWAB(prefix+".category_overrides."+category, override, fail)
+	}
+}
+
+// Validate rejects values the aggregator cannot run with, reporting every
+// problem at once.
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		fail("listen_addr %q: %v", c.ListenAddr, err)
+	}
+	if c.RequestTimeout < 0 {
+		fail("request_timeout must not be negative")
+	}
+	validateListeners(c.Listeners, fail)
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls: cert_file and key_file must be set together")
+	}
+	validateTWAB("twab", c.TWAB, fail)
+	for name, candidate := range c.Shadow {
+		if !feedNamePattern.MatchString(name) {
+			fail("shadow: invalid candidate name %q", name)
+		}
+		validateTWAB("shadow."+name, candidate, fail)
+	}
+	if c.Bloom.ExpectedItems == 0 {
+		fail("bloom.expected_items must be positive")
+	}
+	if c.Bloom.FalsePositiveRate <= 0 || c.Bloom.FalsePositiveRate >= 1 {
+		fail("bloom.false_positive_rate must be between 0 and 1, got %g", c.Bloom.FalsePositiveRate)
+	}
+	if r := c.Bloom.RebuildFillRatio; r != 0 && (r < 0.5 || r >= 1) {
+		fail("bloom.rebuild_fill_ratio must be 0 or at least 0.5 and below 1, got %g", r)
+	}
+	if _, ok := bloom.HasherFor(c.Bloom.hash()); !ok {
+		fail("bloom.hash must be xxh64 or fnv1a-64, got %q", c.Bloom.Hash)
+	}
+	if c.Bloom.MaxFilterEntries < 0 {
+		fail("bloom.max_filter_entries must not be negative")
+	}
+	if c.Bloom.AutoSizeHorizon < 0 {
+		fail("bloom.auto_size_horizon must not be negative")
+	}
+	if h := c.Bloom.AutoSizeHeadroom; h != 0 && h <= 1 {
+		fail("bloom.auto_size_headroom must be 0 or above 1, got %g", h)
+	}
+	for id, name := range c.Chains {
+		if id <= 0 || name == "" {
+			fail("chains must map positive chain IDs to names, got %d=%q", id, name)
+		}
+	}
+	if !c.Bloom.OverflowPolicy.valid() {
+		fail("bloom.overflow_policy must be reject, evict, or rebuild, got %q", c.Bloom.OverflowPolicy)
+	}
+	if c.Push.Debounce < 0 {
+		fail("push.debounce must not be negative")
+	}
+	if c.Push.SubscriberBuffer < 1 {
+		fail("push.subscriber_buffer must be at least 1, got %d", c.Push.SubscriberBuffer)
+	}
+	for role, n := range c.Push.MaxSubscriberBuffer {
+		if !validRoles[role] || n < 1 {
+			fail("push.max_subscriber_buffer: want a buffer of at least 1 for a known role, got %s: %d", role, n)
+		}
+	}
+	if c.Push.EvictAfterDrops < 0 || c.Push.EvictAfterIdle < 0 {
+		fail("push.evict_after_drops and push.evict_after_idle must not be negative")
+	}
+	if c.Push.ResumeHistory < 0 {
+		fail("push.resume_history must not be negative, got %d", c.Push.ResumeHistory)
+	}
+	if c.Push.BitPatchHistory < 0 {
+		fail("push.bit_patch_history must not be negative, got %d", c.Push.BitPatchHistory)
+	}
+	if c.Push.ChunkSize < 0 {
+		fail("push.chunk_size must not be negative, got %d", c.Push.ChunkSize)
+	}
+	if c.Push.AckTimeout < 0 || c.Push.AckMaxRedeliveries < 0 {
+		fail("push.ack_timeout and push.ack_max_redeliveries must not be negative")
+	}
+	if c.Push.MaxSubscriptionsPerKey < 0 {
+		fail("push.max_subscriptions_per_key must not be negative, got %d", c.Push.MaxSubscriptionsPerKey)
+	}
+	for category, severity := range c.Push.CategorySeverity {
+		if !severity.valid() {
+			fail("push.category_severity[%s] must be low, medium, high or critical, got %q", category, severity)
+		}
+	}
+	if !c.Ingest.Synchronous && (c.Ingest.QueueSize < 1 || c.Ingest.Workers < 1) {
+		fail("ingest.queue_size and ingest.workers must be at least 1 unless ingest.synchronous is set")
+	}
+	if c.Ingest.MaxFutureSkew < 0 {
+		fail("ingest.max_future_skew must not be negative")
+	}
+	if c.Ingest.IdempotencyKeys < 0 || c.Ingest.IdempotencyWindow < 0 {
+		fail("ingest.idempotency_keys and ingest.idempotency_window must not be negative")
+	}
+	if c.Ingest.MaxBodyBytes < 0 || c.Ingest.MaxBatchBodyBytes < 0 {
+		fail("ingest.max_body_bytes and ingest.max_batch_body_bytes must not be negative")
+	}
+	if c.Limits.MaxBodyBytes < 0 || c.Limits.MaxImportBodyBytes < 0 {
+		fail("limits.max_body_bytes and limits.max_import_body_bytes must not be negative")
+	}
+	if c.Limits.ReadHeaderTimeout < 0 || c.Limits.ReadTimeout < 0 || c.Limits.IdleTimeout < 0 {
+		fail("limits.read_header_timeout, limits.read_timeout and limits.idle_timeout must not be negative")
+	}
+	if c.RateLimit.IngestPerSecond < 0 {
+		fail("rate_limit.ingest_per_second must not be negative")
+	}
+	if c.RateLimit.IngestPerSecond > 0 && c.RateLimit.IngestBurst < 1 {
+		fail("rate_limit.ingest_burst must be at least 1 when rate limiting is enabled")
+	}
+	if c.Expiry.TTL < 0 {
+		fail("expiry.ttl must not be negative")
+	}
+	for category, ttl := range c.Expiry.CategoryTTL {
+		if ttl < 0 {
+			fail("expiry.category_ttl[%s] must not be negative", category)
+		}
+	}
+	if c.Expiry.Enabled() && c.Expiry.SweepInterval <= 0 {
+		fail("expiry.sweep_interval must be positive when a TTL is set")
+	}
+	for reason, window := range map[string]Duration{"unblocked": c.Cooldown.Unblocked, "retracted": c.Cooldown.Retracted, "expired": c.Cooldown.Expired, "disputed": c.Cooldown.Disputed} {
+		if window < 0 {
+			fail("cooldown.%s must not be negative", reason)
+		}
+	}
+	if c.Cooldown.MaxEntries <= 0 {
+		fail("cooldown.max_entries must be positive, got %d", c.Cooldown.MaxEntries)
+	}
+	if c.SLO.Target <= 0 {
+		fail("slo.target must be positive, got %s", time.Duration(c.SLO.Target))
+	}
+	if c.SLO.MaxSamples <= 0 {
+		fail("slo.max_samples must be positive, got %d", c.SLO.MaxSamples)
+	}
+	if c.Calibration.MinOutcomes < 0 {
+		fail("calibration.min_outcomes must not be negative, got %d", c.Calibration.MinOutcomes)
+	}
+	if c.Calibration.MaxSources <= 0 {
+		fail("calibration.max_sources must be positive, got %d", c.Calibration.MaxSources)
+	}
+	if c.Compression.MinResponseBytes < 0 || c.Compression.MaxDecompressedBytes < 0 {
+		fail("compression.min_response_bytes and compression.max_decompressed_bytes must not be negative")
+	}
+	if c.Watchlist.Size <= 0 {
+		fail("watchlist.size must be positive")
+	}
+	if c.Watchlist.HalfLife < 0 || c.Watchlist.MaxAge < 0 || c.Watchlist.RequestsPerHour < 0 {
+		fail("watchlist.half_life, watchlist.max_age and watchlist.requests_per_hour must not be negative")
+	}
+	if c.Feedback.Threshold <= 0 || c.Feedback.MaxAddresses <= 0 {
+		fail("feedback.threshold and feedback.max_addresses must be positive")
+	}
+	if c.Feedback.RequestsPerHour < 0 {
+		fail("feedback.requests_per_hour must not be negative")
+	}
+	if c.Maintenance.Interval <= 0 || c.Maintenance.TaskBudget <= 0 {
+		fail("maintenance.interval and maintenance.task_budget must be positive")
+	}
+	if c.Maintenance.TWABIdleTTL < 0 {
+		fail("maintenance.twab_idle_ttl must not be negative")
+	}
+	if c.Retention.UnpromotedReports < 0 || c.Retention.PromotedReports < 0 {
+		fail("retention.unpromoted_reports and retention.promoted_reports must not be negative")
+	}
+	if c.Retention.BatchSize <= 0 {
+		fail("retention.batch_size must be positive, got %d", c.Retention.BatchSize)
+	}
+	if c.Tripwire.MaxDistance < 0 || c.Tripwire.MaxDistance > maxTripwireDistance {
+		fail("tripwire.max_distance must be between 0 and %d, got %d", maxTripwireDistance, c.Tripwire.MaxDistance)
+	}
+	if c.Tripwire.PrefixLength < 0 || c.Tripwire.PrefixLength > evmBodyLength {
+		fail("tripwire.prefix_length must be between 0 and %d, got %d", evmBodyLength, c.Tripwire.PrefixLength)
+	}
+	if !c.Review.Policy.valid() {
+		fail("review.policy must be auto or review, got %q", c.Review.Policy)
+	}
+	for category, policy := range c.Review.CategoryPolicy {
+		if !policy.valid() {
+			fail("review.category_policy[%s] must be auto or review, got %q", category, policy)
+		}
+	}
+	if c.Review.RejectCooldown < 0 {
+		fail("review.reject_cooldown must not be negative")
+	}
+	if c.Staging.Enabled && c.Staging.SoakPeriod <= 0 {
+		fail("staging.soak_period must be positive when staging is enabled")
+	}
+	if c.Events.History < 0 {
+		fail("events.history must not be negative, got %d", c.Events.History)
+	}
+	if c.Events.SubscriberBuffer < 1 {
+		fail("events.subscriber_buffer must be at least 1, got %d", c.Events.SubscriberBuffer)
+	}
+	if c.Quota.ReportsPerHour < 0 || c.Quota.UniqueAddressesPerDay < 0 {
+		fail("quota limits must not be negative")
+	}
+	if c.Quota.Enabled() && (c.Quota.BanDuration <= 0 || c.Quota.MaxBanDuration < c.Quota.BanDuration) {
+		fail("quota.ban_duration must be positive and no longer than quota.max_ban_duration")
+	}
+	for name := range c.Namespaces {
+		if !feedNamePattern.MatchString(name) || name == defaultNamespace {
+			fail("namespaces: invalid namespace name %q", name)
+		}
+	}
+	if c.Replication.InstanceID != "" && !feedNamePattern.MatchString(c.Replication.InstanceID) {
+		fail("replication.instance_id %q is not a valid name", c.Replication.InstanceID)
+	}
+	if c.Replication.Enabled() {
+		if c.Replication.InstanceID == "" {
+			fail("replication.instance_id is required when replication.peers is set")
+		}
+		if c.Replication.PeerKey == "" {
+			fail("replication.peer_key is required when replication.peers is set")
+		}
+		if c.Replication.Timeout <= 0 {
+			fail("replication.timeout must be positive")
+		}
+		for _, peer := range c.Replication.Peers {
+			if u, err := url.Parse(peer); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("replication.peers: %q must be an http(s) URL", peer)
+			}
+		}
+	}
+	if c.Mirror.Enabled() {
+		if u, err := url.Parse(c.Mirror.Upstream); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("mirror.upstream: %q must be an http(s) URL", c.Mirror.Upstream)
+		}
+		if c.Replication.Enabled() {
+			fail("mirror.upstream and replication.peers are mutually exclusive")
+		}
+	}
+	if c.Standby.Enabled() {
+		if u, err := url.Parse(c.Standby.Active); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("standby.active: %q must be an http(s) URL", c.Standby.Active)
+		}
+		if c.Mirror.Enabled() {
+			fail("standby.active and mirror.upstream are mutually exclusive")
+		}
+	}
+	if c.Standby.FailoverAfter < 0 {
+		fail("standby.failover_after must not be negative")
+	}
+	if c.Sanctions.Enabled() {
+		if c.Sanctions.Interval <= 0 {
+			fail("sanctions.interval must be positive")
+		}
+		if c.Sanctions.Timeout <= 0 {
+			fail("sanctions.timeout must be positive")
+		}
+		names := make(map[string]bool)
+		for _, feed := range c.Sanctions.Feeds {
+			if !feedNamePattern.MatchString(feed.Name) || names[feed.Name] {
+				fail("sanctions.feeds: invalid or duplicate feed name %q", feed.Name)
+			}
+			names[feed.Name] = true
+			if u, err := url.Parse(feed.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				fail("sanctions.feeds.%s: url %q must be an http(s) URL", feed.Name, feed.URL)
+			}
+			if feed.Format != sanctionsFormatSDN && feed.Format != sanctionsFormatList {
+				fail("sanctions.feeds.%s: format must be %s or %s, got %q", feed.Name, sanctionsFormatSDN, sanctionsFormatList, feed.Format)
+			}
+		}
+	}
+	if c.EvidenceVerification.Confirmations < 1 {
+		fail("evidence_verification.confirmations must be at least 1, got %d", c.EvidenceVerification.Confirmations)
+	}
+	if c.EvidenceVerification.RequestsPerSecond <= 0 {
+		fail("evidence_verification.requests_per_second must be positive, got %g", c.EvidenceVerification.RequestsPerSecond)
+	}
+	if c.EvidenceVerification.CacheSize < 0 {
+		fail("evidence_verification.cache_size must not be negative, got %d", c.EvidenceVerification.CacheSize)
+	}
+	if c.EvidenceVerification.Recheck <= 0 {
+		fail("evidence_verification.recheck must be positive")
+	}
+	if c.EvidenceVerification.MaxChecks < 1 {
+		fail("evidence_verification.max_checks must be at least 1, got %d", c.EvidenceVerification.MaxChecks)
+	}
+	if c.EvidenceVerification.Timeout <= 0 {
+		fail("evidence_verification.timeout must be positive")
+	}
+	for chain, cfg := range c.EvidenceVerification.Chains {
+		if chain <= 0 {
+			fail("evidence_verification.chains: chain ID must be positive, got %d", chain)
+		}
+		if u, err := url.Parse(cfg.RPCURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("evidence_verification.chains.%d: rpc_url %q must be an http(s) URL", chain, cfg.RPCURL)
+		}
+		if cfg.Confirmations < 0 {
+			fail("evidence_verification.chains.%d: confirmations must not be negative, got %d", chain, cfg.Confirmations)
+		}
+	}
+	if burn := c.Alerts.BurnRate; burn.Multiplier < 0 {
+		fail("alerts.burn_rate.multiplier must not be negative, got %g", burn.Multiplier)
+	} else if burn.Multiplier > 0 {
+		if burn.ShortWindow <= 0 || burn.LongWindow <= 0 {
+			fail("alerts.burn_rate.short_window and alerts.burn_rate.long_window must be positive")
+		}
+		if burn.MinPromotions < 1 {
+			fail("alerts.burn_rate.min_promotions must be at least 1, got %d", burn.MinPromotions)
+		}
+	}
+	if c.Alerts.Enabled() {
+		if c.Alerts.Interval <= 0 {
+			fail("alerts.interval must be positive")
+		}
+		if c.Alerts.MaxPerInterval < 1 {
+			fail("alerts.max_per_interval must be at least 1, got %d", c.Alerts.MaxPerInterval)
+		}
+		if c.Alerts.Timeout <= 0 {
+			fail("alerts.timeout must be positive")
+		}
+		if c.Alerts.WebhookURL != "" {
+			if u, err := url.Parse(c.Alerts.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				fail("alerts.webhook_url %q must be an http(s) URL", c.Alerts.WebhookURL)
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &configError{problems: errors.Join(errs...)}
+}
+
+// ErrInvalidConfig is matched by the errors of Validate, and of a reload
+// whose configuration does not load.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// configError is the error of a configuration that cannot run: its
+// problems, one per line.
+type configError struct {
+	problems error
+}
+
+func (e *configError) Error() string { return e.problems.Error() }
+
+func (e *configError) Unwrap() []error { return []error{ErrInvalidConfig, e.problems} }
+
+// Warnings lists settings that are valid but probably not what was meant.
+func (c Config) Warnings() []string {
+	var warnings []string
+	if c.TWAB.MinDistinctSources > c.TWAB.MinReportCount {
+		warnings = append(warnings, fmt.Sprintf(
+			"twab.min_distinct_sources (%d) exceeds twab.min_report_count (%d); promotion effectively needs %d reports",
+			c.TWAB.MinDistinctSources, c.TWAB.MinReportCount, c.TWAB.MinDistinctSources))
+	}
+	return warnings
+}
@@ -0,0 +1,159 @@
+package swarm
+
+import (
+	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func loadTestConfig(t *testing.T, args []string, env map[string]string) (Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return LoadConfig(fs, args, func(k string) string { return env[k] })
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	path := writeFile(t, "aegis.yaml", `
+listen_addr: ":7000"
+twab:
+  min_report_count: 5
+  min_distinct_sources: 3
+push:
+  debounce: 250ms
+`)
+
+	cfg, err := loadTestConfig(t, nil, nil)
+	if err != nil || cfg.ListenAddr != ":9090" || !reflect.DeepEqual(cfg.TWAB, DefaultTWABConfig()) {
+		t.Fatalf("Expected defaults, got %+v (%v)", cfg, err)
+	}
+
+	cfg, err = loadTestConfig(t, []string{"-config", path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ListenAddr != ":7000" || cfg.TWAB.MinReportCount != 5 || time.Duration(cfg.Push.Debounce) != 250*time.Millisecond {
+		t.Errorf("File values not applied: %+v", cfg)
+	}
+	if cfg.TWAB.MinTimeSpanSeconds != DefaultTWABConfig().MinTimeSpanSeconds {
+		t.Errorf("Keys absent from the file should keep their defaults, got %v", cfg.TWAB.MinTimeSpanSeconds)
+	}
+
+	env := map[string]string{configFileEnv: path, "AEGIS_LISTEN_ADDR": ":7001", "AEGIS_TWAB_MIN_REPORTS": "6"}
+	cfg, err = loadTestConfig(t, []string{"-listen", ":7002"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ListenAddr != ":7002" {
+		t.Errorf("Flag should beat env and file, got %s", cfg.ListenAddr)
+	}
+	if cfg.TWAB.MinReportCount != 6 || cfg.TWAB.MinDistinctSources != 3 {
+		t.Errorf("Env should beat file, file should beat default: %+v", cfg.TWAB)
+	}
+
+	if _, err := loadTestConfig(t, nil, map[string]string{"AEGIS_TWAB_MIN_REPORTS": "many"}); err == nil ||
+		!strings.Contains(err.Error(), "AEGIS_TWAB_MIN_REPORTS") {
+		t.Errorf("Expected env parse error naming the variable, got %v", err)
+	}
+}
+
+func TestConfigFileRejectsUnknownKeys(t *testing.T) {
+	path := writeFile(t, "aegis.json", `{"listen_addr": ":7000", "twab": {"min_reports": 2}}`)
+	if _, err := loadTestConfig(t, []string{"-config", path}, nil); err == nil {
+		t.Error("Expected a misspelled key to be rejected")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("Defaults must validate: %v", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.TWAB.MinTimeSpanSeconds = -1
+	cfg.TWAB.MaxSourceContribution = 2
+	cfg.TLS.CertFile = "cert.pem"
+	cfg.Push.SubscriberBuffer = 0
+	cfg.RateLimit.IngestPerSecond = 10
+	cfg.Alerts.WebhookURL = "hooks.slack.com/x"
+	cfg.Namespaces = map[string]NamespaceConfig{"default": {}}
+	cfg.Replication.Peers = []string{"http://peer:9090"}
+	cfg.Bloom.Hash = "md5"
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+	for _, want := range []string{"min_time_span_seconds", "max_source_contribution", "key_file", "subscriber_buffer", "ingest_burst", "webhook_url", "namespace name", "instance_id", "bloom.hash"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s, got:\n%v", want, err)
+		}
+	}
+
+	cfg = DefaultConfig()
+	cfg.TWAB.CategoryOverrides = map[string]TWABConfig{"sanctions": {MinDistinctSources: 1}}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "twab.category_overrides.sanctions.min_report_count") {
+		t.Errorf("Expected an incomplete category override rejected, got %v", err)
+	}
+
+	cfg = DefaultConfig()
+	cfg.TWAB.MinDistinctSources = cfg.TWAB.MinReportCount + 1
+	if cfg.Validate() != nil || len(cfg.Warnings()) != 1 {
+		t.Errorf("Expected sources > reports to warn, not fail: %v", cfg.Warnings())
+	}
+}
+
+func TestPushDebounceCoalesces(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Push.Debounce = Duration(50 * time.Millisecond)
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	ch := agg.Subscribe("sub")
+
+	for _, addr := range []string{evmAddress("a"), evmAddress("b"), evmAddress("c")} {
+		agg.IngestReport(context.Background(), IOCReport{Address: addr, ChainID: 1, Confidence: 1, Timestamp: time.Now(), SourceID: "agent-A"})
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("Expected a debounced push")
+	}
+	select {
+	case <-ch:
+		t.Error("Expected the three changes to coalesce into one push")
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestIngestRateLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimit = RateLimitConfig{IngestPerSecond: 0.001, IngestBurst: 2}
+	routes := NewSwarmAggregatorWithConfig(cfg).Routes()
+
+	codes := make([]int, 3)
+	for i := range codes {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`{"address":"0xA","source_id":"s"}`))
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		codes[i] = rec.Code
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("Expected burst of 2 then 429, got %v", codes)
+	}
+}
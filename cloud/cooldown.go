@@ -0,0 +1,190 @@
+// Package swarm — Cooldown of removed addresses.
+//
+// An address removed from the filter while reports keep trickling in
+// would be promoted again by the next one, churning the filter and
+// confusing clients.  So a removal starts a cooldown: for the window
+// configured for its reason, cooldown.unblocked (an admin unblock),
+// cooldown.retracted (a staged address unblocked before graduating),
+// cooldown.expired (a TTL expiry) or cooldown.disputed (a demotion on
+// subscriber feedback, see feedback.go), consensus may not promote the
+// address.
+// Reports during the window are still recorded, so the first one after
+// it promotes an address that still meets the thresholds.  Admin removals
+// default to a longer window than expiries; zero disables a reason.
+//
+// An admin block lifts the cooldown.  At most cooldown.max_entries
+// addresses cool down at once; past that the one ending soonest is
+// dropped.  Cooldowns are kept in the state file (see snapshot.go) and
+// shown by GET /address/{addr}; ended ones are pruned by maintenance.
+package swarm
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reasons a removed address cools down.
+const (
+	cooldownUnblocked = "unblocked"
+	cooldownRetracted = "retracted"
+	cooldownExpired   = "expired"
+	cooldownDisputed  = "disputed"
+)
+
+// Cooldown is a removed address kept from re-promotion until Until.
+type Cooldown struct {
+	Address string    `json:"address"`
+	Reason  string    `json:"reason"`
+	Since   time.Time `json:"since"`
+	Until   time.Time `json:"until"`
+}
+
+// windowFor returns the cooldown of a removal reason; zero means none.
+func (c CooldownConfig) windowFor(reason string) time.Duration {
+	switch reason {
+	case cooldownUnblocked:
+		return time.Duration(c.Unblocked)
+	case cooldownRetracted:
+		return time.Duration(c.Retracted)
+	case cooldownExpired:
+		return time.Duration(c.Expired)
+	case cooldownDisputed:
+		return time.Duration(c.Disputed)
+	}
+	return 0
+}
+
+// cooldownList holds the cooling addresses, with their ends in a
+// min-heap for pruning and for dropping the soonest when full.  A heap
+// item whose address was lifted or cooled again is skipped when it
+// surfaces.
+type cooldownList struct {
+	mu      sync.Mutex
+	max     int
+	entries map[string]Cooldown
+	ends    expiryQueue
+}
+
+func newCooldownList(max int) *cooldownList {
+	return &cooldownList{max: max, entries: make(map[string]Cooldown)}
+}
+
+// start cools address down for window from now, keeping a longer
+// cooldown it already has.
+func (l *cooldownList) start(address, reason string, now time.Time, window time.Duration) {
+	if window <= 0 {
+		return
+	}
+	c := Cooldown{Address: address, Reason: reason, Since: now, Until: now.Add(window)}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if have, ok := l.entries[address]; ok && !have.Until.Before(c.Until) {
+		return
+	}
+	l.addLocked(c)
+}
+
+// addLocked records c, dropping the cooldown ending soonest if that
+// leaves too many.
+func (l *cooldownList) addLocked(c Cooldown) {
+	l.entries[c.Address] = c
+	heap.Push(&l.ends, expiryItem{at: c.Until, address: c.Address})
+	for len(l.entries) > l.max && l.ends.Len() > 0 {
+		item := heap.Pop(&l.ends).(expiryItem)
+		if have, ok := l.entries[item.address]; ok && have.Until.Equal(item.at) {
+			delete(l.entries, item.address)
+		}
+	}
+}
+
+// active returns the cooldown of address, if one has not ended by now.
+func (l *cooldownList) active(address string, now time.Time) (Cooldown, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.entries[address]
+	if !ok || !now.Before(c.Until) {
+		return Cooldown{}, false
+	}
+	return c, true
+}
+
+// lift ends the cooldown of address, reporting whether it had one.
+func (l *cooldownList) lift(address string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.entries[address]
+	delete(l.entries, address)
+	return ok
+}
+
+// len returns the number of cooldowns, ended ones not yet pruned
+// included.
+func (l *cooldownList) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.entries)
+}
+
+// list copies the cooldowns not ended by now, sorted by address.
+func (l *cooldownList) list(now time.Time) []Cooldown {
+	l.mu.Lock()
+	out := make([]Cooldown, 0, len(l.entries))
+	for _, c := range l.entries {
+		if now.Before(c.Until) {
+			out = append(out, c)
+		}
+	}
+	l.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Address < out[j].Address })
+	return out
+}
+
+// restore replaces the cooldowns with cooldowns.
+func (l *cooldownList) restore(cooldowns []Cooldown) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = make(map[string]Cooldown, len(cooldowns))
+	l.ends = nil
+	for _, c := range cooldowns {
+		l.addLocked(c)
+	}
+}
+
+// prune drops the cooldowns ended by now.
+func (l *cooldownList) prune(ctx context.Context, now time.Time) TaskStats {
+	var stats TaskStats
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.ends.Len() > 0 && !l.ends[0].at.After(now) && ctx.Err() == nil {
+		item := heap.Pop(&l.ends).(expiryItem)
+		stats.Items++
+		if have, ok := l.entries[item.address]; ok && have.Until.Equal(item.at) {
+			delete(l.entries, item.address)
+		}
+	}
+	return stats
+}
+
+// startCooldown cools down an address removed for reason, for the window
+// the live config gives it.
+func (s *SwarmAggregator) startCooldown(address, reason string, now time.Time) {
+	s.cooldowns.start(address, reason, now, s.current().Cooldown.windowFor(reason))
+}
+
+// coolingDown reports whether consensus must not promote address now,
+// counting the suppression if so.
+func (s *SwarmAggregator) coolingDown(address string, now time.Time) bool {
+	c, ok := s.cooldowns.active(address, now)
+	if ok {
+		s.metrics.cooldownSuppressions.WithLabelValues(c.Reason).Inc()
+	}
+	return ok
+}
+
+// pruneCooldowns is the maintenance task dropping ended cooldowns.
+func (s *SwarmAggregator) pruneCooldowns(ctx context.Context) TaskStats {
+	return s.cooldowns.prune(ctx, s.maintenance.clock.Now())
+}
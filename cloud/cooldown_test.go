@@ -0,0 +1,124 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCooldownBlocksRePromotionUntilItEnds(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Cooldown.Unblocked = Duration(100 * time.Millisecond)
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	ctx := context.Background()
+	addr := evmAddress("flapping")
+	promote(agg, addr, "")
+	if !agg.Unblock(ctx, addr) {
+		t.Fatal("Expected the promoted address unblocked")
+	}
+
+	promote(agg, addr, "")
+	if agg.bloomFilter.Contains(addr) {
+		t.Fatal("Expected re-promotion blocked during the cooldown")
+	}
+	if got := testutil.ToFloat64(agg.metrics.cooldownSuppressions.WithLabelValues(cooldownUnblocked)); got != 2 {
+		t.Errorf("Expected 2 suppressed promotions, got %v", got)
+	}
+
+	var detail struct {
+		Cooldown *Cooldown   `json:"cooldown"`
+		TWAB     *TWABDetail `json:"twab"`
+	}
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/address/"+addr, nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &detail); err != nil || detail.Cooldown == nil || detail.Cooldown.Reason != cooldownUnblocked {
+		t.Fatalf("Expected the cooldown in the address detail, got %s (%v)", rec.Body, err)
+	}
+	if detail.TWAB == nil || detail.TWAB.ReportCount != 4 {
+		t.Errorf("Expected reports during the cooldown recorded, got %+v", detail.TWAB)
+	}
+
+	time.Sleep(time.Until(detail.Cooldown.Until))
+	agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-C"})
+	if !agg.bloomFilter.Contains(addr) {
+		t.Error("Expected the next report after the cooldown to promote")
+	}
+}
+
+func TestExpiredAddressCoolsDownUntilForceAdded(t *testing.T) {
+	agg := newExpiringAggregator(time.Hour, nil)
+	ctx := context.Background()
+	addr := evmAddress("stale")
+	promote(agg, addr, "")
+	at := time.Now().Add(2 * time.Hour)
+	if n := agg.ExpireDue(ctx, at); n != 1 {
+		t.Fatalf("Expected the address expired, expired %d", n)
+	}
+	c, ok := agg.cooldowns.active(addr, time.Now())
+	if !ok || c.Reason != cooldownExpired || !c.Until.Equal(at.Add(time.Hour)) {
+		t.Fatalf("Expected an expiry cooldown until an hour after expiring, got %+v", c)
+	}
+
+	promote(agg, addr, "")
+	if agg.bloomFilter.Contains(addr) {
+		t.Fatal("Expected re-promotion blocked during the cooldown")
+	}
+	agg.Block(ctx, AdminAction{Address: addr})
+	if !agg.bloomFilter.Contains(addr) {
+		t.Fatal("Expected an admin block to override the cooldown")
+	}
+	if _, ok := agg.cooldowns.active(addr, time.Now()); ok {
+		t.Error("Expected the block to lift the cooldown")
+	}
+
+	if d := DefaultConfig().Cooldown; d.Unblocked <= d.Expired {
+		t.Errorf("Expected admin removals to cool down longer than expiries by default, got %+v", d)
+	}
+}
+
+func TestCooldownListIsBounded(t *testing.T) {
+	now := time.Now()
+	l := newCooldownList(2)
+	l.start("0xA", cooldownUnblocked, now, time.Hour)
+	l.start("0xB", cooldownExpired, now, 10*time.Minute)
+	l.start("0xC", cooldownUnblocked, now, 2*time.Hour)
+	if _, ok := l.active("0xB", now); ok || l.len() != 2 {
+		t.Fatalf("Expected the cooldown ending soonest dropped, got %v", l.list(now))
+	}
+
+	l.start("0xA", cooldownExpired, now, time.Minute)
+	if c, _ := l.active("0xA", now); c.Reason != cooldownUnblocked {
+		t.Errorf("Expected the longer cooldown kept, got %+v", c)
+	}
+	if stats := l.prune(context.Background(), now.Add(90*time.Minute)); l.len() != 1 || stats.Items == 0 {
+		t.Errorf("Expected the ended cooldown pruned, got %v", l.list(now))
+	}
+}
+
+func TestCooldownSurvivesStateFile(t *testing.T) {
+	src := newSnapshotAggregator()
+	ctx := context.Background()
+	addr := evmAddress("removed")
+	src.Block(ctx, AdminAction{Address: addr})
+	src.Unblock(ctx, addr)
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	if err := src.SaveStateFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newSnapshotAggregator()
+	if _, err := dst.LoadStateFile(ctx, path); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := src.cooldowns.active(addr, time.Now())
+	if got, ok := dst.cooldowns.active(addr, time.Now()); !ok || !got.Until.Equal(want.Until) {
+		t.Errorf("Expected the cooldown %+v restored, got %+v", want, got)
+	}
+}
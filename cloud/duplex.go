@@ -0,0 +1,293 @@
+// Package swarm — Bidirectional report and filter stream.
+//
+// POST /stream lets a gateway submit reports and receive filter updates
+// over one long-lived HTTP/2 request, rather than separate POSTs and a
+// WebSocket through its egress proxy.  Both bodies are NDJSON: one JSON
+// frame per line, blank lines ignored.  The client sends
+//
+//	{"type":"report","id":"r1","report":{...}}   answered by a status frame
+//	{"type":"ack","version":42}                  with ?ack=1 (see ack.go)
+//
+// and the server
+//
+//	{"type":"status","id":"r1","result":{...}}   the /ingest result, or a rejection
+//	{"type":"filter","envelope":{...}}           what /ws would send
+//	{"type":"ping"}                              every wsPingInterval
+//
+// The query parameters, authentication and subscription limits are those
+// of /ws, resume included.  Each report is processed as an item of POST
+// /ingest/batch, rate limited on its own, and a report frame over
+// ingest.max_body_bytes is answered payload_too_large without ending the
+// stream.  Reports are processed in order, and a client that stops
+// reading its statuses stops the server reading its reports, so HTTP/2
+// flow control holds back both directions; filter updates a slow client
+// cannot take are dropped by the subscriber policy (see subscribers.go).
+// A client that half-closes its side keeps receiving updates until it
+// cancels the request.
+//
+// Plain HTTP/1.1 cannot carry both directions at once, so /stream needs
+// HTTP/2: negotiated on TLS listeners, and on plain TCP with tls.h2c.
+package swarm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+// streamPath is the bidirectional stream endpoint.
+const streamPath = "/stream"
+
+// contentTypeNDJSON is the media type of both /stream bodies.
+const contentTypeNDJSON = "application/x-ndjson"
+
+// streamStatusBuffer bounds the statuses waiting to be written before
+// the server stops reading reports.
+const streamStatusBuffer = 16
+
+// Frame types of /stream.
+const (
+	frameReport = "report"
+	frameAck    = "ack"
+	frameStatus = "status"
+	frameFilter = "filter"
+	framePing   = "ping"
+)
+
+// streamFrame is one line of a /stream body, in either direction.
+type streamFrame struct {
+	Type     string          `json:"type"`
+	ID       string          `json:"id,omitempty"`
+	Report   *IOCReport      `json:"report,omitempty"`
+	Version  uint64          `json:"version,omitempty"`
+	Result   *ingestResult   `json:"result,omitempty"`
+	Envelope json.RawMessage `json:"envelope,omitempty"`
+}
+
+// errFrameTooLarge is returned for a frame over the reader's limit.
+var errFrameTooLarge = errors.New("frame too large")
+
+// frameReader reads newline-delimited frames.
+type frameReader struct {
+	r   *bufio.Reader
+	max int // bytes per frame, zero for no limit
+}
+
+func newFrameReader(r io.Reader, max int64) *frameReader {
+	return &frameReader{r: bufio.NewReader(r), max: int(max)}
+}
+
+// next returns the next non-blank frame, trimmed.  A frame over the
+// limit is skipped to its end and reported as errFrameTooLarge, after
+// which reading can go on.  The last frame need not end in a newline.
+func (fr *frameReader) next() ([]byte, error) {
+	var frame []byte
+	tooLarge := false
+	for {
+		chunk, err := fr.r.ReadSlice('\n')
+		if !tooLarge {
+			frame = append(frame, chunk...)
+			if fr.max > 0 && len(bytes.TrimSpace(frame)) > fr.max {
+				frame, tooLarge = nil, true
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if tooLarge {
+			return nil, errFrameTooLarge
+		}
+		if frame = bytes.TrimSpace(frame); len(frame) > 0 {
+			return frame, nil // at EOF the next call reports it
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// writeStreamFrame writes one frame and its newline, reporting whether
+// the stream is still usable.
+func writeStreamFrame(w io.Writer, rc *http.ResponseController, frame streamFrame) bool {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		return false
+	}
+	rc.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err = w.Write(append(data, '\n'))
+	return err == nil
+}
+
+// handleStream is the HTTP handler for POST /stream.
+func (s *SwarmAggregator) handleStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if r.ProtoMajor < 2 {
+		writeError(w, r, http.StatusHTTPVersionNotSupported, CodeHTTP2Required, "/stream needs HTTP/2")
+		return
+	}
+	ns, ok := s.requestNamespace(w, r)
+	if !ok {
+		return
+	}
+	resume, foreign, lastVersion, ok := s.resumeQuery(w, r)
+	if !ok {
+		return
+	}
+	fs, ok := s.openFilterStream(w, r, "stream", resume, foreign, lastVersion)
+	if !ok {
+		return
+	}
+	defer fs.close()
+
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{}) // the stream outlives limits.read_timeout
+	w.Header().Set("Content-Type", contentTypeNDJSON)
+	w.Header().Set("X-Subscription-ID", fs.id)
+	w.WriteHeader(http.StatusOK)
+	if rc.Flush() != nil {
+		return
+	}
+
+	ctx := r.Context()
+	statuses := make(chan streamFrame, streamStatusBuffer)
+	go s.readStreamFrames(ctx, r, ns, fs, statuses)
+
+	envelopes, err := fs.initial()
+	if err != nil {
+		s.logs.Printf(logSerializeFailure, fs.id, "Failed to serialize bloom filter for %s: %v", fs.id, err)
+		return
+	}
+	for _, data := range envelopes {
+		if !writeStreamFrame(w, rc, streamFrame{Type: frameFilter, Envelope: data}) {
+			return
+		}
+		fs.sub.markSent(envelopeVersion(data), time.Now())
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	redeliver, stop := s.redeliveries(fs)
+	defer stop()
+
+	for {
+		var frame streamFrame
+		var sent uint64
+		select {
+		case now := <-redeliver:
+			data, err := s.redelivery(fs, now)
+			if err != nil {
+				s.logs.Printf(logSerializeFailure, fs.id, "Failed to serialize bloom filter for %s: %v", fs.id, err)
+				return
+			}
+			if data == nil {
+				continue
+			}
+			frame, sent = streamFrame{Type: frameFilter, Envelope: data}, envelopeVersion(data)
+		case data, ok := <-fs.ch:
+			if !ok {
+				return
+			}
+			frame = streamFrame{Type: frameFilter, Envelope: data}
+		case st, ok := <-statuses:
+			if !ok {
+				statuses = nil // half-closed: updates go on
+				continue
+			}
+			frame = st
+		case <-ping.C:
+			frame = streamFrame{Type: framePing}
+		case <-ctx.Done():
+			return
+		}
+		if !writeStreamFrame(w, rc, frame) {
+			return
+		}
+		if sent != 0 {
+			fs.sub.markSent(sent, time.Now())
+		}
+		if len(fs.ch) == 0 && len(statuses) == 0 && rc.Flush() != nil {
+			return
+		}
+	}
+}
+
+// readStreamFrames processes the client's frames in order until its body
+// ends, sending a status for each report, then closes statuses.
+func (s *SwarmAggregator) readStreamFrames(ctx context.Context, r *http.Request, ns string, fs *filterStream, statuses chan<- streamFrame) {
+	defer close(statuses)
+	frames := newFrameReader(r.Body, s.config.Ingest.MaxBodyBytes)
+	for {
+		data, err := frames.next()
+		var status streamFrame
+		switch {
+		case errors.Is(err, errFrameTooLarge):
+			status = streamFrame{Type: frameStatus, Result: &ingestResult{Status: IngestRejected, ReasonCode: string(CodePayloadTooLarge), Message: "Frame too large"}}
+		case err != nil:
+			return // the client half-closed, or went away
+		default:
+			var frame streamFrame
+			if err := json.Unmarshal(data, &frame); err != nil {
+				status = streamFrame{Type: frameStatus, Result: &ingestResult{Status: IngestRejected, ReasonCode: string(CodeInvalidBody), Message: "Invalid frame"}}
+				break
+			}
+			switch frame.Type {
+			case frameAck:
+				if fs.acks {
+					fs.sub.ack(frame.Version, time.Now())
+				}
+				continue
+			case frameReport:
+				res := s.streamReport(ctx, r, ns, frame.Report)
+				status = streamFrame{Type: frameStatus, ID: frame.ID, Result: &res}
+			default:
+				status = streamFrame{Type: frameStatus, ID: frame.ID, Result: &ingestResult{Status: IngestRejected, ReasonCode: string(CodeInvalidBody), Message: "Unknown frame type"}}
+			}
+		}
+		select {
+		case statuses <- status:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// streamReport processes one reported frame as a batch item.
+func (s *SwarmAggregator) streamReport(ctx context.Context, r *http.Request, ns string, report *IOCReport) ingestResult {
+	if report == nil || report.Address == "" {
+		return ingestResult{Status: IngestRejected, ReasonCode: string(CodeMissingAddress), Message: "Missing address"}
+	}
+	rep := *report
+	rep.Namespace, rep.Network, rep.SourceTier = ns, s.requestNetwork(r), s.requestSourceTier(r)
+	owned, original, err := s.claimIngest(ctx, idempotencyKey(rep, rep.ReportID))
+	if err != nil {
+		return rejectedResult(err)
+	}
+	if original != nil {
+		if ns == "" {
+			s.sourceStats.recordDuplicate(rep.SourceID, s.clock.Now())
+		}
+		return original.result.replayed()
+	}
+	if !s.limiter.allow(clientIP(r)) {
+		s.idempotency.abandon(owned)
+		return rejectedResult(ErrRateLimited)
+	}
+	res, err := s.acceptReport(ctx, rep)
+	if err != nil {
+		s.idempotency.abandon(owned)
+		return rejectedResult(err)
+	}
+	s.idempotency.finish(owned, s.ingestStatus(), res)
+	return res
+}
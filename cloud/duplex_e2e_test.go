@@ -0,0 +1,230 @@
+package swarm_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+	"github.com/aegis-protocol/swarm/client"
+	"golang.org/x/net/http2"
+)
+
+// startStreamAggregator starts an aggregator serving cleartext HTTP/2.
+func startStreamAggregator(t *testing.T) *aegistest.Aggregator {
+	return aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.TLS.H2C = true
+		cfg.Ingest.Synchronous = true
+		cfg.Ingest.MaxBodyBytes = 1024
+	}})
+}
+
+// streamFrame is a frame of a /stream response.
+type streamFrame struct {
+	Type   string `json:"type"`
+	ID     string `json:"id"`
+	Result struct {
+		Status        string `json:"status"`
+		ReasonCode    string `json:"reason_code"`
+		AddedToFilter bool   `json:"added_to_filter"`
+	} `json:"result"`
+	Envelope json.RawMessage `json:"envelope"`
+}
+
+// rawStream is a /stream request driven frame by frame.
+type rawStream struct {
+	t      *testing.T
+	body   *io.PipeWriter
+	frames *bufio.Scanner
+}
+
+func openRawStream(t *testing.T, agg *aegistest.Aggregator) *rawStream {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	body, w := io.Pipe()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, agg.URL+"/stream", body)
+	req.Header.Set("Authorization", "Bearer "+aegistest.SubscriberKey)
+	h2 := &http2.Transport{AllowHTTP: true, DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, addr)
+	}}
+	resp, err := h2.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Expected a stream, got %d %v", resp.StatusCode, resp.Header)
+	}
+	frames := bufio.NewScanner(resp.Body)
+	frames.Buffer(nil, 1<<20)
+	return &rawStream{t: t, body: w, frames: frames}
+}
+
+func (rs *rawStream) send(line string) {
+	rs.t.Helper()
+	if _, err := io.WriteString(rs.body, line+"\n"); err != nil {
+		rs.t.Fatal(err)
+	}
+}
+
+// next returns the next frame other than a ping.
+func (rs *rawStream) next() streamFrame {
+	rs.t.Helper()
+	for rs.frames.Scan() {
+		var frame streamFrame
+		if err := json.Unmarshal(rs.frames.Bytes(), &frame); err != nil {
+			rs.t.Fatalf("Invalid frame %s: %v", rs.frames.Bytes(), err)
+		}
+		if frame.Type != "ping" {
+			return frame
+		}
+	}
+	rs.t.Fatalf("Stream ended: %v", rs.frames.Err())
+	return streamFrame{}
+}
+
+// entries returns the addresses of a filter frame's snapshot.
+func (rs *rawStream) entries(frame streamFrame) []string {
+	rs.t.Helper()
+	var env struct {
+		Payload json.RawMessage `json:"payload"`
+	}
+	var payload struct {
+		Entries []string `json:"entries"`
+	}
+	if frame.Type != "filter" || json.Unmarshal(frame.Envelope, &env) != nil || json.Unmarshal(env.Payload, &payload) != nil {
+		rs.t.Fatalf("Expected a filter snapshot, got %+v", frame)
+	}
+	return payload.Entries
+}
+
+func TestStreamAcksReportsAndPushesFilter(t *testing.T) {
+	agg := startStreamAggregator(t)
+	rs := openRawStream(t, agg)
+	if entries := rs.entries(rs.next()); len(entries) != 0 {
+		t.Fatalf("Expected an empty initial filter, got %v", entries)
+	}
+
+	addr := aegistest.Address("streamed")
+	rs.send(fmt.Sprintf(`{"type":"report","id":"r1","report":{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-A"}}`, addr))
+	if st := rs.next(); st.Type != "status" || st.ID != "r1" || st.Result.Status != swarm.IngestRecorded || st.Result.AddedToFilter {
+		t.Fatalf("Expected r1 recorded below threshold, got %+v", st)
+	}
+	rs.send(fmt.Sprintf(`{"type":"report","id":"r1b","report":{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-B"}}`, addr))
+	if st := rs.next(); st.Type != "status" || st.ID != "r1b" || st.Result.AddedToFilter {
+		t.Fatalf("Expected r1b recorded below threshold, got %+v", st)
+	}
+	agg.Advance(2 * time.Minute)
+	rs.send("")
+	rs.send(`{"type":"report","id":"big","report":{"address":"` + strings.Repeat("a", 2000) + `"}}`)
+	rs.send(`{"type":"report","id":"r2","report":{"source_id":"agent-B"}}`)
+	rs.send(fmt.Sprintf(`{"type":"report","id":"r3","report":{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-A"}}`, addr))
+
+	var statuses []streamFrame
+	var pushed []string
+	for len(statuses) < 3 || pushed == nil {
+		switch frame := rs.next(); frame.Type {
+		case "status":
+			statuses = append(statuses, frame)
+		case "filter":
+			pushed = rs.entries(frame)
+		}
+	}
+	if st := statuses[0]; st.ID != "" || st.Result.ReasonCode != string(swarm.CodePayloadTooLarge) {
+		t.Errorf("Expected the oversized frame rejected, got %+v", st)
+	}
+	if st := statuses[1]; st.ID != "r2" || st.Result.ReasonCode != string(swarm.CodeMissingAddress) {
+		t.Errorf("Expected r2 rejected for its address, got %+v", st)
+	}
+	if st := statuses[2]; st.ID != "r3" || !st.Result.AddedToFilter {
+		t.Errorf("Expected r3 to promote, got %+v", st)
+	}
+	if len(pushed) != 1 || pushed[0] != addr {
+		t.Errorf("Expected the promotion pushed, got %v", pushed)
+	}
+
+	// Half-closed, the stream goes on sending updates.
+	rs.body.Close()
+	other := aegistest.Address("blocked-after-close")
+	agg.Block(context.Background(), swarm.AdminAction{Address: other, ChainID: 1})
+	if entries := rs.entries(rs.next()); len(entries) != 2 {
+		t.Errorf("Expected the block pushed after half-close, got %v", entries)
+	}
+}
+
+func TestStreamNeedsHTTP2(t *testing.T) {
+	agg := startStreamAggregator(t)
+	req, _ := http.NewRequest(http.MethodPost, agg.URL+"/stream", strings.NewReader("{}\n"))
+	req.Header.Set("Authorization", "Bearer "+aegistest.SubscriberKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body swarm.ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusHTTPVersionNotSupported || body.Error.Code != swarm.CodeHTTP2Required {
+		t.Errorf("Expected HTTP/1.1 refused, got %d %+v", resp.StatusCode, body)
+	}
+}
+
+func TestStreamSessionReportsAndReconnects(t *testing.T) {
+	agg := startStreamAggregator(t)
+	c, err := client.New(client.Config{BaseURL: agg.URL, APIKey: aegistest.SubscriberKey, MinBackoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	session, err := c.Stream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	<-session.Updates() // the initial filter
+
+	addr := aegistest.Address("session")
+	for _, source := range []string{"agent-A", "agent-B"} {
+		res, err := session.Report(ctx, client.IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, SourceID: source})
+		if err != nil || res.Status != client.StatusRecorded || res.AddedToFilter {
+			t.Errorf("Expected a recorded report, got %+v %v", res, err)
+		}
+	}
+	res, err := session.Report(ctx, client.IOCReport{Address: strings.Repeat("a", 2000)})
+	if err != nil || res.Status != client.StatusRejected || res.ReasonCode != string(swarm.CodePayloadTooLarge) {
+		t.Errorf("Expected the oversized report rejected, got %+v %v", res, err)
+	}
+
+	// A dropped stream reconnects, and a report caught in the drop can be
+	// sent again under its report ID.
+	agg.DropSubscriptions("aegistest-subscriber")
+	agg.Advance(2 * time.Minute)
+	for {
+		res, err = session.Report(ctx, client.IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, SourceID: "agent-B", ReportID: "agent-B-1"})
+		if !errors.Is(err, client.ErrStreamClosed) {
+			break
+		}
+	}
+	if err != nil || !res.AddedToFilter {
+		t.Errorf("Expected the report a window later to promote, got %+v %v", res, err)
+	}
+	for range session.Updates() {
+		if c.Contains(addr) {
+			break
+		}
+	}
+	if !c.Contains(addr) {
+		t.Error("Expected the promotion synced to the session's client")
+	}
+}
@@ -0,0 +1,31 @@
+package swarm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFrameReader(t *testing.T) {
+	fr := newFrameReader(strings.NewReader("\n  {\"a\":1}\r\n\n"+strings.Repeat("x", 5000)+"\n{\"b\":2}"), 64)
+	var got []string
+	for {
+		frame, err := fr.next()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errFrameTooLarge) {
+			got = append(got, "too large")
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(frame))
+	}
+	if want := []string{`{"a":1}`, "too large", `{"b":2}`}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("Expected frames %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,112 @@
+package swarm_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+// Helpers for the end-to-end tests, the *_e2e_test.go files.  These run
+// on aegistest and see the aggregator over its API; what no endpoint
+// shows they reach through the hooks in export_test.go.
+
+// promoteOver promotes address in category with reports from two sources
+// a minute apart, advancing the clock by that minute.
+func promoteOver(t *testing.T, h *aegistest.Aggregator, address, category string) {
+	t.Helper()
+	h.Report(swarm.IOCReport{Address: address, Category: category, SourceID: "agent-A"})
+	h.Report(swarm.IOCReport{Address: address, Category: category, SourceID: "agent-B"})
+	h.Advance(time.Minute)
+	h.Report(swarm.IOCReport{Address: address, Category: category, SourceID: "agent-A"})
+	if !check(t, h, address).Flagged {
+		t.Fatalf("Expected %s promoted", address)
+	}
+}
+
+// getJSON decodes the response to a GET with the admin key into v,
+// failing the test unless it is a 200.
+func getJSON(t *testing.T, h *aegistest.Aggregator, path string, v interface{}) {
+	t.Helper()
+	status, data := h.Do(http.MethodGet, path, "")
+	if err := json.Unmarshal(data, v); status != http.StatusOK || err != nil {
+		t.Fatalf("GET %s: expected 200, got %d: %s", path, status, data)
+	}
+}
+
+// checkResult is the part of a GET /check response the tests look at.
+type checkResult struct {
+	Flagged bool   `json:"flagged"`
+	Tier    string `json:"tier"`
+}
+
+// check returns what GET /check says of address.
+func check(t *testing.T, h *aegistest.Aggregator, address string) checkResult {
+	t.Helper()
+	var res checkResult
+	getJSON(t, h, "/check?address="+address, &res)
+	return res
+}
+
+// twabDetail returns the TWAB detail GET /address shows for address, if
+// it has reports.
+func twabDetail(t *testing.T, h *aegistest.Aggregator, address string) (swarm.TWABDetail, bool) {
+	t.Helper()
+	var resp struct {
+		TWAB *swarm.TWABDetail `json:"twab"`
+	}
+	getJSON(t, h, "/address/"+address, &resp)
+	if resp.TWAB == nil {
+		return swarm.TWABDetail{}, false
+	}
+	return *resp.TWAB, true
+}
+
+// filterParams returns the parameters GET /filter/params reports.
+func filterParams(t *testing.T, h *aegistest.Aggregator) swarm.FilterParams {
+	t.Helper()
+	var params swarm.FilterParams
+	getJSON(t, h, "/filter/params", &params)
+	return params
+}
+
+// metric returns the value of a series, such as
+// aegis_promotion_burn_ratio{chain="1"}, on the metrics listener, or zero
+// if it is not exported.
+func metric(t *testing.T, h *aegistest.Aggregator, series string) float64 {
+	t.Helper()
+	resp, err := http.Get(h.MetricsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	lines := bufio.NewScanner(resp.Body)
+	for lines.Scan() {
+		if value, ok := strings.CutPrefix(lines.Text(), series+" "); ok {
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				t.Fatalf("Invalid value for %s: %q", series, value)
+			}
+			return f
+		}
+	}
+	return 0
+}
+
+// waitFor polls cond until it holds, failing the test after five seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,165 @@
+// Package swarm — Response encodings.
+//
+// Responses a client may negotiate the encoding of go through one seam:
+// an Encoder, picked from the Accept header by negotiateEncoding among
+// those the endpoint offers.  JSON is the default everywhere; msgpack
+// (see msgpack.go) is offered by /check, /filter and ingest, protobuf
+// (see wire_proto.go) only by ingest, whose results have a schema.
+// Errors are always JSON.  WebSocket subscriptions choose theirs with
+// ?encoding= instead (see stream.go).
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// WireEncoding names an encoding of a response or push.
+type WireEncoding string
+
+const (
+	WireJSON     WireEncoding = "json"
+	WireMsgpack  WireEncoding = "msgpack"
+	WireProtobuf WireEncoding = "protobuf"
+)
+
+const contentTypeMsgpack = "application/msgpack"
+
+// Encoder marshals a response in one encoding.
+type Encoder interface {
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+}
+
+// protoWire is implemented by responses with a protobuf encoding.
+type protoWire interface {
+	wire() proto.Message
+}
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) ContentType() string { return contentTypeJSON }
+
+// Marshal ends the document with a newline, as json.Encoder does.
+func (jsonEncoder) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	return append(data, '\n'), err
+}
+
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) ContentType() string { return contentTypeMsgpack }
+
+func (msgpackEncoder) Marshal(v any) ([]byte, error) { return marshalMsgpack(v) }
+
+type protobufEncoder struct{}
+
+func (protobufEncoder) ContentType() string { return contentTypeProtobuf }
+
+func (protobufEncoder) Marshal(v any) ([]byte, error) {
+	m, ok := v.(protoWire)
+	if !ok {
+		return nil, fmt.Errorf("%T has no protobuf encoding", v)
+	}
+	return proto.Marshal(m.wire())
+}
+
+// encoderFor returns the Encoder of an encoding, JSON's if unknown.
+func encoderFor(e WireEncoding) Encoder {
+	switch e {
+	case WireMsgpack:
+		return msgpackEncoder{}
+	case WireProtobuf:
+		return protobufEncoder{}
+	}
+	return jsonEncoder{}
+}
+
+// mediaEncoding returns the encoding a media type names.
+func mediaEncoding(mediaType string) (WireEncoding, bool) {
+	mt, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return "", false
+	}
+	switch mt {
+	case contentTypeJSON:
+		return WireJSON, true
+	case contentTypeMsgpack, "application/x-msgpack", "application/vnd.msgpack":
+		return WireMsgpack, true
+	case contentTypeProtobuf, "application/protobuf":
+		return WireProtobuf, true
+	}
+	return "", false
+}
+
+// requestEncoding returns the encoding of the request body, JSON unless
+// its Content-Type names another.
+func requestEncoding(r *http.Request) WireEncoding {
+	if e, ok := mediaEncoding(r.Header.Get("Content-Type")); ok {
+		return e
+	}
+	return WireJSON
+}
+
+// negotiateEncoding picks the response encoding: the first one named by
+// Accept that offered includes, otherwise the request's own encoding if
+// offered, otherwise JSON.  JSON is always acceptable.
+func negotiateEncoding(r *http.Request, offered ...WireEncoding) WireEncoding {
+	acceptable := func(e WireEncoding) bool {
+		if e == WireJSON {
+			return true
+		}
+		for _, o := range offered {
+			if o == e {
+				return true
+			}
+		}
+		return false
+	}
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if e, ok := mediaEncoding(strings.TrimSpace(part)); ok && acceptable(e) {
+			return e
+		}
+	}
+	if e := requestEncoding(r); acceptable(e) {
+		return e
+	}
+	return WireJSON
+}
+
+// writeEncoded writes v as the response in enc.
+func writeEncoded(w http.ResponseWriter, r *http.Request, status int, enc Encoder, v any) {
+	data, err := enc.Marshal(v)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, fmt.Sprintf("Failed to encode response: %v", err))
+		return
+	}
+	w.Header().Set("Content-Type", enc.ContentType())
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+// parseStreamEncoding parses ?encoding= on a subscription.  Pushes have
+// no protobuf schema.
+func parseStreamEncoding(raw string) (WireEncoding, error) {
+	switch WireEncoding(raw) {
+	case "", WireJSON:
+		return WireJSON, nil
+	case WireMsgpack:
+		return WireMsgpack, nil
+	}
+	return "", fmt.Errorf("unknown encoding %q, want json or msgpack", raw)
+}
+
+// marshalEnvelope encodes an envelope for a subscriber in e.
+func marshalEnvelope(env FilterEnvelope, e WireEncoding) ([]byte, error) {
+	if e == WireMsgpack {
+		return appendMsgpackEnvelope(nil, env), nil
+	}
+	return json.Marshal(env)
+}
@@ -0,0 +1,227 @@
+package swarm
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	for _, tc := range []struct {
+		accept, contentType string
+		offered             []WireEncoding
+		want                WireEncoding
+	}{
+		{"", "", []WireEncoding{WireMsgpack}, WireJSON},
+		{"application/msgpack", "", []WireEncoding{WireMsgpack}, WireMsgpack},
+		{"application/x-msgpack", "", []WireEncoding{WireMsgpack}, WireMsgpack},
+		{"application/json, application/msgpack", "", []WireEncoding{WireMsgpack}, WireJSON},
+		{"application/x-protobuf", "", []WireEncoding{WireMsgpack}, WireJSON},
+		{"*/*", contentTypeMsgpack, []WireEncoding{WireMsgpack, WireProtobuf}, WireMsgpack},
+		{"application/x-protobuf, application/msgpack", "", []WireEncoding{WireMsgpack}, WireMsgpack},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept", tc.accept)
+		req.Header.Set("Content-Type", tc.contentType)
+		if got := negotiateEncoding(req, tc.offered...); got != tc.want {
+			t.Errorf("Accept %q, Content-Type %q: expected %s, got %s", tc.accept, tc.contentType, tc.want, got)
+		}
+	}
+}
+
+func TestMsgpackBatchIngestMatchesJSON(t *testing.T) {
+	reports := wireTestReports(300)
+	cfg := TWABConfig{MinReportCount: 3, MinDistinctSources: 2}
+	viaJSON, viaMsgpack := newTestAggregator(cfg), newTestAggregator(cfg)
+	body, err := marshalMsgpack(reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/ingest/batch", bytes.NewReader(encodeBatchJSON(t, reports)))
+	viaJSON.Routes().ServeHTTP(httptest.NewRecorder(), req)
+	req = httptest.NewRequest(http.MethodPost, "/ingest/batch", bytes.NewReader(body))
+	req.Header.Set("Content-Type", contentTypeMsgpack)
+	rec := httptest.NewRecorder()
+	viaMsgpack.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != contentTypeMsgpack {
+		t.Fatalf("Expected a msgpack answer, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	doc, err := msgpackDecode(rec.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := doc.(map[string]any); msgpackUint(res["accepted"]) != 300 || len(res["results"].([]any)) != 300 {
+		t.Errorf("Expected 300 results accepted, got %v", res["accepted"])
+	}
+	if viaJSON.bloomFilter.Len() == 0 || viaJSON.bloomFilter.Len() != viaMsgpack.bloomFilter.Len() {
+		t.Errorf("Expected the same filter, got %d and %d entries", viaJSON.bloomFilter.Len(), viaMsgpack.bloomFilter.Len())
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader([]byte{0x81, 0xa1}))
+	req.Header.Set("Content-Type", contentTypeMsgpack)
+	rec = httptest.NewRecorder()
+	viaMsgpack.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || rec.Header().Get("Content-Type") != contentTypeJSON {
+		t.Errorf("Expected a truncated body rejected in JSON, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestCheckAndFilterInMsgpack(t *testing.T) {
+	agg := NewSwarmAggregator()
+	addr := evmAddress("msgpack")
+	blockAll(agg, addr)
+
+	req := httptest.NewRequest(http.MethodGet, "/check?address="+addr, nil)
+	req.Header.Set("Accept", contentTypeMsgpack)
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	doc, err := msgpackDecode(rec.Body.Bytes())
+	if err != nil || rec.Header().Get("Content-Type") != contentTypeMsgpack {
+		t.Fatalf("Expected a msgpack check, got %s (%v)", rec.Header().Get("Content-Type"), err)
+	}
+	if m := doc.(map[string]any); m["flagged"] != true || m["address"] != addr {
+		t.Errorf("Expected %s flagged, got %v", addr, m)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/filter", nil)
+	req.Header.Set("Accept", contentTypeMsgpack)
+	rec = httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	payload := rec.Body.Bytes()
+	sig, _ := base64.StdEncoding.DecodeString(rec.Header().Get(headerFilterSignature))
+	version, _ := strconv.ParseUint(rec.Header().Get(headerFilterVersion), 10, 64)
+	if !ed25519.Verify(agg.signer.Active().Public(), filterSigningMessage(version, payload), sig) {
+		t.Error("Expected the msgpack payload signed")
+	}
+	if rec.Header().Get(headerPayloadChecksum) != payloadChecksum(payload) {
+		t.Error("Expected the checksum over the msgpack bytes")
+	}
+	data, err := msgpackToJSON(payload)
+	var p FilterPayload
+	if err != nil || json.Unmarshal(data, &p) != nil || len(p.Entries) != 1 || p.Entries[0] != addr {
+		t.Errorf("Expected the filter of %s, got %+v (%v)", addr, p, err)
+	}
+	if etag := rec.Header().Get("ETag"); !strings.Contains(etag, encodingMsgpack) {
+		t.Errorf("Expected an ETag of the msgpack encoding, got %q", etag)
+	}
+}
+
+func TestWebSocketMsgpackSubscription(t *testing.T) {
+	agg := NewSwarmAggregator()
+	blockAll(agg, evmAddress("seed"))
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws?encoding=msgpack", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	read := func() map[string]any {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		kind, data, err := conn.ReadMessage()
+		if err != nil || kind != websocket.BinaryMessage {
+			t.Fatalf("Expected a binary frame, got %d (%v)", kind, err)
+		}
+		doc, err := msgpackDecode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		env := doc.(map[string]any)
+		payload := env["payload"].([]byte)
+		sig, _ := base64.StdEncoding.DecodeString(env["signature"].(string))
+		if !ed25519.Verify(agg.signer.Active().Public(), filterSigningMessage(msgpackUint(env["version"]), payload), sig) {
+			t.Fatal("Expected the msgpack payload signed")
+		}
+		p, err := msgpackDecode(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.(map[string]any)
+	}
+	if p := read(); msgpackUint(p["count"]) != 1 {
+		t.Fatalf("Expected the one-entry snapshot, got %v", p)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(agg.ListSubscribers()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	blockAll(agg, evmAddress("live"))
+	if p := read(); msgpackUint(p["count"]) != 2 {
+		t.Errorf("Expected the two-entry push, got %v", p)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/sse/filter?encoding=msgpack", nil)
+	req.Header = header.Clone()
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected msgpack refused over SSE, got %d", rec.Code)
+	}
+}
+
+// benchFilterSize is the entry count of the benchmark payloads.
+const benchFilterSize = 100_000
+
+// BenchmarkEncodeFilter encodes a 100k-entry filter payload.  There is no
+// protobuf schema for it; see BenchmarkEncodeReportBatch for all three.
+func BenchmarkEncodeFilter(b *testing.B) {
+	entries := make([]string, benchFilterSize)
+	for i := range entries {
+		entries[i] = fmt.Sprintf("0x%040x", i)
+	}
+	p := FilterPayload{FormatVersion: BloomFormatVersion, Version: 1, Entries: entries, Count: len(entries), BloomParams: BloomParamsFor(benchFilterSize, 0.001)}
+	for _, enc := range []struct {
+		name    string
+		marshal func() ([]byte, error)
+	}{
+		{"json", func() ([]byte, error) { return json.Marshal(p) }},
+		{"msgpack", func() ([]byte, error) { return p.appendMsgpack(nil), nil }},
+	} {
+		benchEncode(b, enc.name, enc.marshal)
+	}
+}
+
+// BenchmarkEncodeReportBatch encodes a 100k-report ingest batch.
+func BenchmarkEncodeReportBatch(b *testing.B) {
+	reports := wireTestReports(benchFilterSize)
+	for _, enc := range []struct {
+		name    string
+		marshal func() ([]byte, error)
+	}{
+		{"json", func() ([]byte, error) { return json.Marshal(reports) }},
+		{"msgpack", func() ([]byte, error) { return marshalMsgpack(reports) }},
+		{"protobuf", func() ([]byte, error) { return encodeBatchProto(b, reports), nil }},
+	} {
+		benchEncode(b, enc.name, enc.marshal)
+	}
+}
+
+// benchEncode runs one encoding as a sub-benchmark, reporting the encoded
+// size.
+func benchEncode(b *testing.B, name string, marshal func() ([]byte, error)) {
+	b.Run(name, func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			data, err := marshal()
+			if err != nil {
+				b.Fatal(err)
+			}
+			size = len(data)
+		}
+		b.ReportMetric(float64(size), "bytes")
+	})
+}
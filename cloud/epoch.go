@@ -0,0 +1,87 @@
+// Package swarm — Instance identity and epoch.
+//
+// Filter versions restart when the aggregator does without its saved
+// state, and a load balancer failing over lands clients on a replica
+// with versions of its own, so a version alone cannot tell a client
+// whether its copy is stale or from another lineage.  Every aggregator
+// therefore has an Identity: a random instance UUID and an epoch.  Both
+// are saved with the state file (see snapshot.go) and kept when it is
+// restored, the filter version carrying on past the saved one; a start
+// without restored state draws a new UUID and a new epoch, taken from
+// the clock in milliseconds so it exceeds any earlier one.
+//
+// The identity is on every envelope, GET /filter (as headers),
+// /filter/version and /health.  Versions compare as (epoch, version):
+// a client seeing another epoch discards what it holds for a full
+// snapshot, and a resume naming another epoch, ?epoch= on /ws or the
+// event id on /sse/filter, is answered with a resync snapshot.  Unlike
+// the replication instance ID, which an operator assigns to a replica,
+// the UUID is generated and names the state.  Neither is signed.
+package swarm
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Response headers carrying the identity on GET /filter.
+const (
+	headerInstanceUUID = "X-Aegis-Instance-UUID"
+	headerFilterEpoch  = "X-Aegis-Epoch"
+)
+
+// Identity is the lineage of an aggregator's state.
+type Identity struct {
+	InstanceUUID string `json:"instance_uuid"`
+	Epoch        uint64 `json:"epoch"`
+}
+
+// lastEpoch is the newest epoch drawn, so aggregators started within one
+// millisecond, as in tests, still get increasing epochs.
+var lastEpoch atomic.Uint64
+
+// newIdentity draws the identity of a start without restored state.
+func newIdentity(now time.Time) Identity {
+	for {
+		prev := lastEpoch.Load()
+		epoch := max(uint64(now.UnixMilli()), prev+1)
+		if lastEpoch.CompareAndSwap(prev, epoch) {
+			return Identity{InstanceUUID: uuid.NewString(), Epoch: epoch}
+		}
+	}
+}
+
+// Identity returns the aggregator's identity.
+func (s *SwarmAggregator) Identity() Identity {
+	return *s.identity.Load()
+}
+
+// restoreIdentity adopts the identity saved in a state file, if it has
+// one, moving the filter to the saved version so the next one follows
+// it.  It reports whether it did.
+func (s *SwarmAggregator) restoreIdentity(h StateHeader) bool {
+	if h.InstanceUUID == "" || h.Epoch == 0 {
+		return false
+	}
+	s.identity.Store(&Identity{InstanceUUID: h.InstanceUUID, Epoch: h.Epoch})
+	s.bloomFilter.ContinueFrom(h.FilterVersion)
+	return true
+}
+
+// stamp sets the identity on an envelope.
+func (id Identity) stamp(env *FilterEnvelope) {
+	env.InstanceUUID, env.Epoch = id.InstanceUUID, id.Epoch
+}
+
+// foreignEpoch reports whether a resume names an epoch other than the
+// current one; an empty one, from a client predating epochs, does not.
+func (s *SwarmAggregator) foreignEpoch(raw string) bool {
+	if raw == "" {
+		return false
+	}
+	epoch, err := strconv.ParseUint(raw, 10, 64)
+	return err != nil || epoch != s.Identity().Epoch
+}
@@ -0,0 +1,161 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/client"
+	"github.com/gorilla/websocket"
+)
+
+func TestRestartWithoutStateStartsNewEpoch(t *testing.T) {
+	before := NewSwarmAggregator().Identity()
+	agg := NewSwarmAggregator()
+	id := agg.Identity()
+	if id.Epoch <= before.Epoch || id.InstanceUUID == "" || id.InstanceUUID == before.InstanceUUID {
+		t.Fatalf("Expected a later epoch and a new UUID than %+v, got %+v", before, id)
+	}
+	blockAll(agg, evmAddress("a"))
+
+	var version filterVersionResponse
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/filter/version", nil))
+	if json.Unmarshal(rec.Body.Bytes(), &version); version.Epoch != id.Epoch || version.InstanceUUID != id.InstanceUUID {
+		t.Errorf("Expected /filter/version to carry %+v, got %+v", id, version)
+	}
+
+	var health Identity
+	rec = httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if json.Unmarshal(rec.Body.Bytes(), &health); health != id {
+		t.Errorf("Expected /health to carry %+v, got %+v", id, health)
+	}
+
+	rec = httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/filter", nil))
+	if rec.Header().Get(headerInstanceUUID) != id.InstanceUUID || rec.Header().Get(headerFilterEpoch) != strconv.FormatUint(id.Epoch, 10) {
+		t.Errorf("Expected GET /filter to carry %+v, got %v", id, rec.Header())
+	}
+	if envs, _ := agg.Resume(0, DeltaExact); len(envs) != 1 || envs[0].Epoch != id.Epoch || envs[0].InstanceUUID != id.InstanceUUID {
+		t.Errorf("Expected the delta stamped with %+v, got %+v", id, envs)
+	}
+}
+
+func TestResumeFromAnotherEpochResyncs(t *testing.T) {
+	agg := NewSwarmAggregator()
+	blockAll(agg, "0xA", "0xB")
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?last_version=1"
+	epoch := agg.Identity().Epoch
+
+	for _, tc := range []struct {
+		query  string
+		resync bool
+	}{
+		{"", false},
+		{"&epoch=" + strconv.FormatUint(epoch, 10), false},
+		{"&epoch=" + strconv.FormatUint(epoch-1, 10), true},
+		{"&epoch=bogus", true},
+	} {
+		query, resync := tc.query, tc.resync
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+query, header)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var env FilterEnvelope
+		err = conn.ReadJSON(&env)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("Expected resume reply, got %v", err)
+		}
+		if env.Resync != resync || env.Epoch != epoch {
+			t.Errorf("Resuming with %q: expected resync=%v in epoch %d, got %+v", query, resync, epoch, env)
+		}
+	}
+}
+
+func TestRestoredStateKeepsIdentity(t *testing.T) {
+	src := newSnapshotAggregator()
+	blockAll(src, evmAddress("a"), evmAddress("b"), evmAddress("c"))
+	src.Unblock(context.Background(), evmAddress("c")) // v4, two entries
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	if err := src.SaveStateFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newSnapshotAggregator()
+	if _, err := dst.LoadStateFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	if dst.Identity() != src.Identity() {
+		t.Errorf("Expected the saved identity %+v, got %+v", src.Identity(), dst.Identity())
+	}
+	if v := dst.bloomFilter.Version(); v <= 4 {
+		t.Errorf("Expected the version to carry on past 4, got %d", v)
+	}
+}
+
+func TestWatchResyncsAcrossRestart(t *testing.T) {
+	var current atomic.Pointer[http.Handler]
+	serve := func(agg *SwarmAggregator) {
+		addTestSubscriber(agg)
+		h := agg.Routes()
+		current.Store(&h)
+	}
+	old := NewSwarmAggregator()
+	blockAll(old, evmAddress("old-1"), evmAddress("old-2"))
+	serve(old)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		(*current.Load()).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	c, err := client.New(client.Config{BaseURL: srv.URL, APIKey: testSubscriberSecret, MinBackoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := c.Watch(ctx)
+	if err != nil {
+		t.Fatalf("Watch failed: %v", err)
+	}
+	next := func() client.FilterUpdate {
+		select {
+		case u := <-updates:
+			return u
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for an update")
+			return client.FilterUpdate{}
+		}
+	}
+	if u := next(); u.Version != 2 || u.Epoch != old.Identity().Epoch {
+		t.Fatalf("Expected the v2 snapshot of the first epoch, got %+v", u)
+	}
+
+	// A restart without persistence whose versions overtake the old ones:
+	// resuming from v2 would otherwise yield a delta onto the wrong set.
+	restarted := NewSwarmAggregator()
+	blockAll(restarted, evmAddress("new-1"), evmAddress("new-2"), evmAddress("new-3"))
+	serve(restarted)
+	old.RevokeAPIKey("siem") // the old process going away drops its subscribers
+
+	u := next()
+	if !u.Resync || u.Version != 3 || u.Epoch != restarted.Identity().Epoch || u.InstanceUUID != restarted.Identity().InstanceUUID {
+		t.Fatalf("Expected a resync snapshot at v3 of the new epoch, got %+v", u)
+	}
+	if c.Contains(evmAddress("old-1")) || !c.Contains(evmAddress("new-3")) {
+		t.Errorf("Expected only the restarted aggregator's entries, got %v", u.Entries)
+	}
+}
@@ -0,0 +1,239 @@
+// Package swarm — HTTP error responses and panic recovery.
+//
+// Every handler reports failures through writeError, which renders
+// {"error": {"code", "message", "request_id"}}.  Codes are stable and
+// meant for programs; messages are for people.  Each request carries an
+// ID, taken from a well-formed X-Request-ID header or generated, that is
+// echoed in the response header, error bodies, and server logs.  A panic
+// in any handler is recovered into a 500 whose request ID the caller can
+// quote to operators.
+//
+// Failures that come from the aggregator's methods rather than from the
+// handler itself are typed: the sentinel errors each feature declares
+// (ErrQueueFull, ErrStateNotEmpty, ...) and the error types (*BanError,
+// *AddressError, *ValidationError, ...), all matched with errors.Is and
+// errors.As, so embedders branch on them as the handlers do.
+// writeAPIError answers any of them through errorResponse, the one place
+// an error becomes a status and code: apiErrors maps the sentinels, and
+// the bloom package's ones, and a switch the types.  Anything else is a
+// 500.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+
+	"github.com/aegis-protocol/swarm/bloom"
+	"github.com/google/uuid"
+)
+
+// ErrorCode identifies the kind of failure in an error response.
+type ErrorCode string
+
+const (
+	CodeBadRequest          ErrorCode = "bad_request"
+	CodeInvalidBody         ErrorCode = "invalid_body"
+	CodeInvalidParameter    ErrorCode = "invalid_parameter"
+	CodeMissingAddress      ErrorCode = "missing_address"
+	CodeInvalidAddress      ErrorCode = "invalid_address"
+	CodeInvalidIndicator    ErrorCode = "invalid_indicator"
+	CodeUnknownChain        ErrorCode = "unknown_chain"
+	CodeInvalidEvidence     ErrorCode = "invalid_evidence"
+	CodeInvalidReport       ErrorCode = "invalid_report"
+	CodeInvalidContent      ErrorCode = "invalid_content"
+	CodeInvalidConfig       ErrorCode = "invalid_config"
+	CodeTimestampSkew       ErrorCode = "timestamp_skew"
+	CodeUnauthorized        ErrorCode = "unauthorized"
+	CodeForbidden           ErrorCode = "forbidden"
+	CodeSourceBanned        ErrorCode = "source_banned"
+	CodeNotFound            ErrorCode = "not_found"
+	CodeMethodNotAllowed    ErrorCode = "method_not_allowed"
+	CodePayloadTooLarge     ErrorCode = "payload_too_large"
+	CodeUnsupportedEncoding ErrorCode = "unsupported_encoding"
+	CodeRateLimited         ErrorCode = "rate_limited"
+	CodeTooManySubs         ErrorCode = "too_many_subscriptions"
+	CodeQueueFull           ErrorCode = "queue_full"
+	CodeVersionGone         ErrorCode = "version_gone"
+	CodeStateNotEmpty       ErrorCode = "state_not_empty"
+	CodeTimeout             ErrorCode = "timeout"
+	CodeBodyTimeout         ErrorCode = "body_timeout"
+	CodeInternal            ErrorCode = "internal"
+	CodeReadOnlyMirror      ErrorCode = "read_only_mirror"
+	CodeStandby             ErrorCode = "standby"
+	CodeAlreadyActive       ErrorCode = "already_active"
+	CodeDuplicateSubscriber ErrorCode = "duplicate_subscriber"
+	CodeIncompatibleFilter  ErrorCode = "incompatible_filter"
+	CodeHTTP2Required       ErrorCode = "http2_required"
+)
+
+// headerRequestID carries the request ID in both directions.
+const headerRequestID = "X-Request-ID"
+
+// requestIDPattern bounds the caller-supplied IDs we are willing to echo.
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// ErrorBody is the "error" member of an error response.
+type ErrorBody struct {
+	Code      ErrorCode `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"`
+}
+
+// ErrorResponse is the body of every non-2xx JSON response.
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type requestIDContextKey struct{}
+
+// RequestIDFromContext returns the ID assigned to the request, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// writeError answers the request with the standard error envelope.
+func writeError(w http.ResponseWriter, r *http.Request, status int, code ErrorCode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: ErrorBody{
+		Code:      code,
+		Message:   message,
+		RequestID: RequestIDFromContext(r.Context()),
+	}})
+}
+
+// ValidationError reports a field of a request, such as a report field,
+// that breaks a limit or does not parse.
+type ValidationError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Field, e.Reason)
+}
+
+// apiErrors maps each sentinel error to the status and code it is
+// answered with.  Every exported sentinel of the package is listed.
+var apiErrors = []struct {
+	err    error
+	status int
+	code   ErrorCode
+}{
+	{ErrRateLimited, http.StatusTooManyRequests, CodeRateLimited},
+	{ErrQueueFull, http.StatusTooManyRequests, CodeQueueFull},
+	{ErrDisputesFull, http.StatusServiceUnavailable, CodeQueueFull},
+	{ErrDuplicateSubscriber, http.StatusConflict, CodeDuplicateSubscriber},
+	{ErrStateNotEmpty, http.StatusConflict, CodeStateNotEmpty},
+	{ErrInvalidConfig, http.StatusUnprocessableEntity, CodeInvalidConfig},
+	{ErrReloadUnavailable, http.StatusNotFound, CodeNotFound},
+	{ErrNotStandby, http.StatusNotFound, CodeNotFound},
+	{ErrAlreadyActive, http.StatusConflict, CodeAlreadyActive},
+	{ErrChainNotVerifiable, http.StatusUnprocessableEntity, CodeInvalidEvidence},
+	{bloom.ErrIncompatibleParams, http.StatusBadRequest, CodeIncompatibleFilter},
+	{bloom.ErrUnknownHash, http.StatusBadRequest, CodeInvalidBody},
+	{bloom.ErrMalformed, http.StatusBadRequest, CodeInvalidBody},
+	{bloom.ErrPatchVersion, http.StatusConflict, CodeVersionGone},
+}
+
+// writeAPIError answers a request that failed with err, with the
+// Retry-After a ban or a full queue calls for.
+func writeAPIError(w http.ResponseWriter, r *http.Request, err error) {
+	var ban *BanError
+	if errors.As(err, &ban) {
+		writeBanError(w, r, ban)
+		return
+	}
+	if errors.Is(err, ErrQueueFull) || errors.Is(err, ErrRateLimited) {
+		w.Header().Set("Retry-After", "1")
+	}
+	status, code, msg := errorResponse(err)
+	writeError(w, r, status, code, msg)
+}
+
+// errorResponse classifies an error into the status, code and message it
+// is answered with.  The code is also the reason code of a batch item
+// rejected with err.
+func errorResponse(err error) (int, ErrorCode, string) {
+	var (
+		ban      *BanError
+		invalid  *AddressError
+		chain    *ChainError
+		typed    *IndicatorError
+		evidence *EvidenceError
+		skew     *TimestampSkewError
+		fields   *ValidationError
+		content  *ContentError
+		format   *FormatVersionError
+	)
+	switch {
+	case errors.As(err, &ban):
+		return http.StatusForbidden, CodeSourceBanned, ban.Error()
+	case errors.As(err, &invalid):
+		return http.StatusUnprocessableEntity, CodeInvalidAddress, invalid.Error()
+	case errors.As(err, &chain):
+		return http.StatusUnprocessableEntity, CodeUnknownChain, chain.Error()
+	case errors.As(err, &typed):
+		return http.StatusUnprocessableEntity, CodeInvalidIndicator, typed.Error()
+	case errors.As(err, &evidence):
+		return http.StatusUnprocessableEntity, CodeInvalidEvidence, evidence.Error()
+	case errors.As(err, &fields):
+		return http.StatusUnprocessableEntity, CodeInvalidReport, fields.Error()
+	case errors.As(err, &content):
+		return http.StatusUnprocessableEntity, CodeInvalidContent, content.Error()
+	case errors.As(err, &skew):
+		return http.StatusBadRequest, CodeTimestampSkew, skew.Error()
+	case errors.As(err, &format):
+		return http.StatusBadRequest, CodeInvalidBody, format.Error()
+	case isContextError(err):
+		return http.StatusServiceUnavailable, CodeTimeout, "Request timed out"
+	}
+	for _, e := range apiErrors {
+		if errors.Is(err, e.err) {
+			return e.status, e.code, err.Error()
+		}
+	}
+	return http.StatusInternalServerError, CodeInternal, err.Error()
+}
+
+// withRequestID assigns the request ID and recovers panics from next.  A
+// request that already has an ID, because the middleware wraps a handler
+// that itself uses it, keeps that ID.
+func (s *SwarmAggregator) withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RequestIDFromContext(r.Context()) == "" {
+			id := r.Header.Get(headerRequestID)
+			if !requestIDPattern.MatchString(id) {
+				id = uuid.NewString()
+			}
+			w.Header().Set(headerRequestID, id)
+			r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id))
+		}
+		defer s.recoverPanic(w, r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recoverPanic turns a handler panic into a logged 500.  http.ErrAbortHandler
+// is re-raised, since it is how handlers deliberately drop a connection.
+func (s *SwarmAggregator) recoverPanic(w http.ResponseWriter, r *http.Request) {
+	rec := recover()
+	if rec == nil {
+		return
+	}
+	if rec == http.ErrAbortHandler {
+		panic(rec)
+	}
+	s.metrics.panics.Inc()
+	id := RequestIDFromContext(r.Context())
+	log.Printf("Panic serving %s %s (request %s): %v\n%s", r.Method, r.URL.Path, id, rec, debug.Stack())
+	writeError(w, r, http.StatusInternalServerError, CodeInternal, "Internal server error; quote request ID "+id+" when reporting it")
+}
@@ -0,0 +1,215 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/bloom"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func decodeErrorResponse(t *testing.T, resp *http.Response) ErrorBody {
+	t.Helper()
+	defer resp.Body.Close()
+	var body ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("Expected a JSON error envelope: %v", err)
+	}
+	return body.Error
+}
+
+func TestPanicRecoveredWithErrorEnvelope(t *testing.T) {
+	agg := NewSwarmAggregator()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/test/panic", func(w http.ResponseWriter, r *http.Request) {
+		var uninitialized map[string]int
+		uninitialized["boom"]++
+	})
+	mux.Handle("/", agg.Routes())
+	srv := httptest.NewServer(agg.withRequestID(mux))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/test/panic", nil)
+		req.Header.Set(headerRequestID, "req-42")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		if resp.StatusCode != http.StatusInternalServerError || resp.Header.Get(headerRequestID) != "req-42" {
+			t.Errorf("Expected 500 echoing the request ID, got %d %q", resp.StatusCode, resp.Header.Get(headerRequestID))
+		}
+		if e := decodeErrorResponse(t, resp); e.Code != CodeInternal || e.RequestID != "req-42" || e.Message == "" {
+			t.Errorf("Unexpected error body %+v", e)
+		}
+	}
+	if got := testutil.ToFloat64(agg.metrics.panics); got != 2 {
+		t.Errorf("Expected panics_total 2, got %v", got)
+	}
+
+	resp, err := http.Get(srv.URL + "/health")
+	if err != nil {
+		t.Fatalf("Server stopped serving after a panic: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected /health to keep serving, got %d", resp.StatusCode)
+	}
+}
+
+func TestHandlerErrorsShareEnvelope(t *testing.T) {
+	srv := httptest.NewServer(NewSwarmAggregator().Routes())
+	defer srv.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		code   ErrorCode
+	}{
+		{"/ingest", http.StatusMethodNotAllowed, CodeMethodNotAllowed},
+		{"/pending", http.StatusUnauthorized, CodeUnauthorized},
+		{"/check", http.StatusBadRequest, CodeMissingAddress},
+		{"/check?address=0xNotHex&chain_id=1", http.StatusUnprocessableEntity, CodeInvalidAddress},
+		{"/no-such-endpoint", http.StatusNotFound, CodeNotFound},
+	}
+	for _, tc := range tests {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", tc.path, err)
+		}
+		id := resp.Header.Get(headerRequestID)
+		e := decodeErrorResponse(t, resp)
+		if resp.StatusCode != tc.status || e.Code != tc.code {
+			t.Errorf("GET %s: expected %d %s, got %d %+v", tc.path, tc.status, tc.code, resp.StatusCode, e)
+		}
+		if id == "" || e.RequestID != id {
+			t.Errorf("GET %s: expected a generated request ID in header and body, got %q and %q", tc.path, id, e.RequestID)
+		}
+	}
+}
+
+// sentinels are the exported error values of the package, by name.
+var sentinels = map[string]error{
+	"ErrAlreadyActive":       ErrAlreadyActive,
+	"ErrChainNotVerifiable":  ErrChainNotVerifiable,
+	"ErrDisputesFull":        ErrDisputesFull,
+	"ErrDuplicateSubscriber": ErrDuplicateSubscriber,
+	"ErrFilterMagic":         ErrFilterMagic,
+	"ErrIncompatibleParams":  ErrIncompatibleParams,
+	"ErrInvalidConfig":       ErrInvalidConfig,
+	"ErrMalformedFilter":     ErrMalformedFilter,
+	"ErrNotStandby":          ErrNotStandby,
+	"ErrQueueFull":           ErrQueueFull,
+	"ErrRateLimited":         ErrRateLimited,
+	"ErrReloadUnavailable":   ErrReloadUnavailable,
+	"ErrStateNotEmpty":       ErrStateNotEmpty,
+	"ErrUnknownHash":         ErrUnknownHash,
+}
+
+// declaredSentinels lists the exported Err variables of the package's
+// non-test files.
+func declaredSentinels(t *testing.T) []string {
+	t.Helper()
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, ".", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatalf("Parse package: %v", err)
+	}
+	var names []string
+	for _, file := range pkgs["swarm"].Files {
+		for _, decl := range file.Decls {
+			gen, ok := decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.VAR {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				for _, name := range spec.(*ast.ValueSpec).Names {
+					if strings.HasPrefix(name.Name, "Err") && name.IsExported() {
+						names = append(names, name.Name)
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestEverySentinelHasAStatus(t *testing.T) {
+	declared := declaredSentinels(t)
+	var listed []string
+	for name := range sentinels {
+		listed = append(listed, name)
+	}
+	sort.Strings(listed)
+	if strings.Join(declared, ",") != strings.Join(listed, ",") {
+		t.Fatalf("Declared sentinels %v, the test lists %v", declared, listed)
+	}
+
+	for name, sentinel := range sentinels {
+		for _, err := range []error{sentinel, fmt.Errorf("context: %w", sentinel)} {
+			status, code, _ := errorResponse(err)
+			if status == http.StatusInternalServerError || code == CodeInternal {
+				t.Errorf("%s (%v): answered %d %s", name, err, status, code)
+			}
+		}
+	}
+	// The bloom package's sentinels arrive from filter parsing and patches.
+	for _, sentinel := range []error{bloom.ErrMalformed, bloom.ErrUnknownHash, bloom.ErrIncompatibleParams, bloom.ErrPatchVersion, bloom.ErrPatchParams} {
+		if status, _, _ := errorResponse(sentinel); status == http.StatusInternalServerError {
+			t.Errorf("%v: answered %d", sentinel, status)
+		}
+	}
+	if status, code, _ := errorResponse(errors.New("disk on fire")); status != http.StatusInternalServerError || code != CodeInternal {
+		t.Errorf("Untyped error answered %d %s, want 500 internal", status, code)
+	}
+}
+
+func TestErrorsMatchSentinels(t *testing.T) {
+	agg := NewSwarmAggregator()
+
+	if _, err := agg.TrySubscribe("wallet-1", SubscribeOptions{}); err != nil {
+		t.Fatalf("First subscribe: %v", err)
+	}
+	if _, err := agg.TrySubscribe("wallet-1", SubscribeOptions{}); !errors.Is(err, ErrDuplicateSubscriber) {
+		t.Errorf("Second subscribe with the ID: %v, want ErrDuplicateSubscriber", err)
+	}
+
+	if _, err := agg.Reload(context.Background(), "test"); !errors.Is(err, ErrReloadUnavailable) {
+		t.Errorf("Reload without a source: %v, want ErrReloadUnavailable", err)
+	}
+
+	cfg := DefaultConfig()
+	cfg.TWAB.MinReportCount = 0
+	err := cfg.Validate()
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("Validate: %v, want ErrInvalidConfig", err)
+	}
+	if status, code, _ := errorResponse(err); status != http.StatusUnprocessableEntity || code != CodeInvalidConfig {
+		t.Errorf("Invalid config answered %d %s", status, code)
+	}
+
+	promoteOn(agg, evmAddress("import-target"), 1, "drainer")
+	if err := agg.importState(context.Background(), agg.exportState(time.Now()), false); !errors.Is(err, ErrStateNotEmpty) {
+		t.Errorf("Import over state: %v, want ErrStateNotEmpty", err)
+	}
+
+	_, err = ParseBloomFilter([]byte("not a filter"))
+	if !errors.Is(err, ErrMalformedFilter) {
+		t.Errorf("ParseBloomFilter: %v, want ErrMalformedFilter", err)
+	}
+}
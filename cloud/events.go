@@ -0,0 +1,563 @@
+// Package swarm — Event stream for SIEM ingestion.
+//
+// The aggregator publishes a typed event for each change a security team
+// may want to follow: report_accepted for every report recorded toward
+// consensus, promoted when an address enters the main filter (by
+// consensus, an admin block, a trusted feed or sanctions list, a merge,
+// a peer or a mirror's upstream, or graduating from staging) or the
+// staging filter, removed when one is unblocked, allowlisted, evicted
+// from a filter or dropped upstream, expired at the end of its TTL,
+// retracted when a staged address is unblocked or allowlisted before it
+// graduates, banned when a source trips its quota, and disputed when
+// subscriber feedback escalates an address (see feedback.go).  A state
+// import replaces everything at once; it is audited as one action and is
+// not broken into events.
+//
+// GET /events streams them as Server-Sent Events or, on a WebSocket
+// upgrade, one JSON message each.  It takes the keys /ws does (see
+// subscriber_auth.go), counts against the same per-key limit, and is
+// closed when the key is revoked; namespaced keys are refused.  ?type=
+// takes a comma-separated list of the types to send, all by default.
+//
+// Every event carries its schema version, bumped only for changes that
+// are not additions, a seq numbered from 1 per process, and a type_seq
+// numbered from 1 per type, with stream naming the process so a restart
+// is not mistaken for a gap.  The last events.history events are kept: a
+// client reconnecting with ?since=N&stream=S, or an SSE Last-Event-ID, is
+// first sent those after N, or all of them if S is not the current
+// stream, and can tell from type_seq whether any fell out of the history
+// meanwhile.  A connection more than events.subscriber_buffer events
+// behind is closed, to resume the same way.
+//
+// The filter push path consumes the same events: promotions and removals
+// push the tiers they changed, once per batch.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// eventSchemaVersion is the schema of Event.
+const eventSchemaVersion = 1
+
+// EventType names a kind of event.
+type EventType string
+
+const (
+	EventReportAccepted EventType = "report_accepted"
+	EventPromoted       EventType = "promoted"
+	EventRemoved        EventType = "removed"
+	EventExpired        EventType = "expired"
+	EventRetracted      EventType = "retracted"
+	EventBanned         EventType = "banned"
+	EventDisputed       EventType = "disputed"
+)
+
+var eventTypeNames = map[EventType]bool{
+	EventReportAccepted: true,
+	EventPromoted:       true,
+	EventRemoved:        true,
+	EventExpired:        true,
+	EventRetracted:      true,
+	EventBanned:         true,
+	EventDisputed:       true,
+}
+
+// Reasons an address entered or was removed from a filter.
+const (
+	eventReasonImported    = "imported"   // added by a trusted feed
+	eventReasonSanctioned  = "sanctioned" // listed by a sanctions feed
+	eventReasonUnblocked   = "unblocked"
+	eventReasonAllowlisted = "allowlisted"
+	eventReasonEvicted     = "evicted"
+	eventReasonFilterCap   = "filter_cap" // staged, but the filter was full
+	eventReasonDelisted    = "delisted"   // dropped from a sanctions feed
+	eventReasonDisputed    = "disputed"   // demoted on subscriber feedback
+	eventReasonUpstream    = "upstream"   // dropped by a mirror's upstream
+)
+
+// Event is one message of the event stream.  TypeSeq numbers the events
+// of one type, so a client asking for some types can still tell a gap.
+// Tier is the filter an address entered and FromTier the one it left.
+type Event struct {
+	Schema   int       `json:"schema"`
+	Seq      uint64    `json:"seq"`
+	TypeSeq  uint64    `json:"type_seq"`
+	Stream   string    `json:"stream"`
+	Instance string    `json:"instance,omitempty"`
+	Type     EventType `json:"type"`
+	Time     time.Time `json:"time"`
+
+	Address      string           `json:"address,omitempty"`
+	ChainID      int              `json:"chain_id,omitempty"`
+	Category     string           `json:"category,omitempty"`
+	Confidence   float64          `json:"confidence,omitempty"`
+	SourceID     string           `json:"source_id,omitempty"`     // report_accepted and banned
+	Source       string           `json:"source,omitempty"`        // what promoted: consensus, admin or a feed
+	SourceTier   SourceTier       `json:"source_tier,omitempty"`   // of the report that reached consensus
+	SeverityBand int              `json:"severity_band,omitempty"` // min_severity of the band it promoted under
+	Tier         SubscriptionTier `json:"tier,omitempty"`
+	FromTier     SubscriptionTier `json:"from_tier,omitempty"`
+	Reason       string           `json:"reason,omitempty"`
+	Until        *time.Time       `json:"until,omitempty"` // end of a ban
+
+	pushed bool           // the change already went out with a rebuilt filter
+	report *StandbyReport // report_accepted: the report, for standbys (see standby.go)
+}
+
+// id is the SSE event ID, resumed from by Last-Event-ID.
+func (ev Event) id() string {
+	return ev.Stream + ":" + strconv.FormatUint(ev.Seq, 10)
+}
+
+// reportEvent describes the address of a report.
+func reportEvent(typ EventType, report IOCReport) Event {
+	return Event{
+		Type:       typ,
+		Address:    report.Address,
+		ChainID:    report.ChainID,
+		Category:   report.Category,
+		Confidence: report.Confidence,
+	}
+}
+
+// entryEvent describes a confirmed entry.
+func entryEvent(typ EventType, entry *ConfirmedEntry) Event {
+	return Event{
+		Type:       typ,
+		Address:    entry.Address,
+		ChainID:    entry.ChainID,
+		Category:   entry.Category,
+		Confidence: entry.Confidence,
+	}
+}
+
+// EventsConfig sizes the event stream.
+type EventsConfig struct {
+	// History is the number of recent events kept for reconnecting
+	// clients; zero keeps none.
+	History int `json:"history" yaml:"history"`
+
+	// SubscriberBuffer is the number of events queued per connection
+	// before it is closed as too slow.
+	SubscriberBuffer int `json:"subscriber_buffer" yaml:"subscriber_buffer"`
+}
+
+// eventTypes is a set of event types; nil holds every type.
+type eventTypes map[EventType]bool
+
+func (t eventTypes) match(typ EventType) bool {
+	return t == nil || t[typ]
+}
+
+// parseEventTypes reads the type parameters of /events, each a
+// comma-separated list.
+func parseEventTypes(values []string) (eventTypes, error) {
+	var types eventTypes
+	for _, value := range values {
+		for _, name := range strings.Split(value, ",") {
+			typ := EventType(strings.TrimSpace(name))
+			if !eventTypeNames[typ] {
+				return nil, fmt.Errorf("invalid event type %q", name)
+			}
+			if types == nil {
+				types = make(eventTypes)
+			}
+			types[typ] = true
+		}
+	}
+	return types, nil
+}
+
+// eventHandler is an in-process consumer of events.
+type eventHandler struct {
+	types eventTypes
+	fn    func(context.Context, []Event)
+}
+
+// eventSubscription is one /events connection.  ch is closed when the
+// subscription is dropped.
+type eventSubscription struct {
+	key     string // API key name
+	types   eventTypes
+	ch      chan Event
+	standby bool // a standby stream (see standby.go)
+}
+
+// eventResume is where a reconnecting client left off.
+type eventResume struct {
+	ok     bool
+	stream string
+	seq    uint64
+}
+
+// eventBus numbers events and fans them out to subscriptions and
+// handlers.
+type eventBus struct {
+	stream    string
+	instance  string
+	history   int
+	buffer    int
+	published *prometheus.CounterVec // type
+	dropped   prometheus.Counter
+
+	mu       sync.Mutex
+	seq      uint64               // of the last event published
+	typeSeq  map[EventType]uint64 // of the last event of each type
+	recent   []Event
+	subs     map[*eventSubscription]bool
+	handlers []eventHandler
+}
+
+func newEventBus(cfg EventsConfig, instance string, published *prometheus.CounterVec, dropped prometheus.Counter) *eventBus {
+	return &eventBus{
+		stream:    uuid.NewString(),
+		instance:  instance,
+		history:   cfg.History,
+		buffer:    cfg.SubscriberBuffer,
+		published: published,
+		dropped:   dropped,
+		typeSeq:   make(map[EventType]uint64),
+		subs:      make(map[*eventSubscription]bool),
+	}
+}
+
+// handle calls fn with the events of the given types from each publish,
+// after they are numbered and sent to subscriptions.
+func (b *eventBus) handle(fn func(context.Context, []Event), types ...EventType) {
+	set := make(eventTypes)
+	for _, typ := range types {
+		set[typ] = true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, eventHandler{types: set, fn: fn})
+}
+
+// publish numbers events, in order, and delivers them as one batch.
+func (b *eventBus) publish(ctx context.Context, events ...Event) {
+	if len(events) == 0 {
+		return
+	}
+	now := time.Now()
+	b.mu.Lock()
+	for i := range events {
+		ev := &events[i]
+		b.seq++
+		b.typeSeq[ev.Type]++
+		ev.Schema, ev.Seq, ev.TypeSeq = eventSchemaVersion, b.seq, b.typeSeq[ev.Type]
+		ev.Stream, ev.Instance = b.stream, b.instance
+		if ev.Time.IsZero() {
+			ev.Time = now
+		}
+		b.published.WithLabelValues(string(ev.Type)).Inc()
+		if b.history > 0 {
+			b.recent = append(b.recent, *ev)
+			if over := len(b.recent) - b.history; over > 0 {
+				b.recent = b.recent[over:]
+			}
+		}
+		for sub := range b.subs {
+			if !sub.types.match(ev.Type) {
+				continue
+			}
+			select {
+			case sub.ch <- *ev:
+			default:
+				b.unsubscribeLocked(sub)
+				b.dropped.Inc()
+			}
+		}
+	}
+	handlers := b.handlers
+	b.mu.Unlock()
+
+	for _, h := range handlers {
+		var matched []Event
+		for _, ev := range events {
+			if h.types.match(ev.Type) {
+				matched = append(matched, ev)
+			}
+		}
+		if len(matched) > 0 {
+			h.fn(ctx, matched)
+		}
+	}
+}
+
+// subscribe opens a subscription to the given types, returning with it
+// the retained events after from.
+func (b *eventBus) subscribe(key string, types eventTypes, from eventResume) (*eventSubscription, []Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &eventSubscription{key: key, types: types, ch: make(chan Event, b.buffer)}
+	b.subs[sub] = true
+	if !from.ok {
+		return sub, nil
+	}
+	since := from.seq
+	if from.stream != "" && from.stream != b.stream {
+		since = 0 // numbered by an earlier process
+	}
+	var backlog []Event
+	for _, ev := range b.recent {
+		if ev.Seq > since && types.match(ev.Type) {
+			backlog = append(backlog, ev)
+		}
+	}
+	return sub, backlog
+}
+
+// subscribeStandby opens a subscription to every event for a standby
+// stream.
+func (b *eventBus) subscribeStandby(key string) *eventSubscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sub := &eventSubscription{key: key, ch: make(chan Event, b.buffer), standby: true}
+	b.subs[sub] = true
+	return sub
+}
+
+// unsubscribeStandbys closes the standby streams, returning how many
+// there were.
+func (b *eventBus) unsubscribeStandbys() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for sub := range b.subs {
+		if sub.standby {
+			b.unsubscribeLocked(sub)
+			n++
+		}
+	}
+	return n
+}
+
+// unsubscribe closes a subscription if it is still open.
+func (b *eventBus) unsubscribe(sub *eventSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.unsubscribeLocked(sub)
+}
+
+func (b *eventBus) unsubscribeLocked(sub *eventSubscription) {
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// unsubscribeKey closes the subscriptions of an API key, returning how
+// many there were.
+func (b *eventBus) unsubscribeKey(key string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	for sub := range b.subs {
+		if sub.key == key {
+			b.unsubscribeLocked(sub)
+			n++
+		}
+	}
+	return n
+}
+
+// pushOnEvents is the filter push path: it pushes each tier a batch of
+// promotions and removals changed.
+func (s *SwarmAggregator) pushOnEvents(ctx context.Context, events []Event) {
+	var main, staging bool
+	for _, ev := range events {
+		for _, tier := range []SubscriptionTier{ev.Tier, ev.FromTier} {
+			switch tier {
+			case TierMain:
+				main = main || !ev.pushed
+			case TierStaging:
+				staging = true
+			}
+		}
+	}
+	if staging {
+		s.pushStaging(ctx)
+	}
+	if main {
+		s.pushToSubscribers(ctx)
+	}
+}
+
+// parseEventResume reads where a reconnecting /events client left off:
+// ?since= and ?stream=, or the SSE Last-Event-ID header.
+func parseEventResume(r *http.Request) (eventResume, error) {
+	q := r.URL.Query()
+	raw, stream := q.Get("since"), q.Get("stream")
+	if !q.Has("since") {
+		last := r.Header.Get("Last-Event-ID")
+		if last == "" {
+			return eventResume{}, nil
+		}
+		var found bool
+		if stream, raw, found = strings.Cut(last, ":"); !found {
+			stream, raw = "", last
+		}
+	}
+	seq, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return eventResume{}, fmt.Errorf("invalid since %q", raw)
+	}
+	return eventResume{ok: true, stream: stream, seq: seq}, nil
+}
+
+// handleEvents is the HTTP handler for GET /events.
+func (s *SwarmAggregator) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	types, err := parseEventTypes(r.URL.Query()["type"])
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	from, err := parseEventResume(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	secret := subscriberSecret(r)
+	key, ok := s.authorizeSecret(w, r, secret, RoleSubscriber)
+	if !ok {
+		return
+	}
+	if key.Namespace != "" {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "Namespaced keys have no event stream")
+		return
+	}
+	owner := key.Name()
+	if !s.keySubs.acquire(owner) {
+		writeError(w, r, http.StatusTooManyRequests, CodeTooManySubs, "Too many subscriptions for this API key")
+		return
+	}
+	defer s.keySubs.release(owner)
+
+	sub, backlog := s.events.subscribe(owner, types, from)
+	defer s.events.unsubscribe(sub)
+	// A key revoked since it was checked has already had its
+	// subscriptions closed; this one must not outlive it.
+	if _, ok := s.keys.Lookup(secret); !ok {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Invalid API key")
+		return
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		s.streamEventsWebSocket(w, r, sub, backlog)
+	} else {
+		s.streamEventsSSE(w, r, sub, backlog)
+	}
+}
+
+// streamEventsSSE sends events as Server-Sent Events until the client
+// goes away or the subscription is dropped.
+func (s *SwarmAggregator) streamEventsSSE(w http.ResponseWriter, r *http.Request, sub *eventSubscription, backlog []Event) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev Event) bool {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return false
+		}
+		rc.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", ev.id(), ev.Type, data)
+		return err == nil
+	}
+	for _, ev := range backlog {
+		if !send(ev) {
+			return
+		}
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.ch:
+			if !ok || !send(ev) {
+				return
+			}
+			if len(sub.ch) == 0 && rc.Flush() != nil {
+				return
+			}
+		case <-ping.C:
+			rc.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// streamEventsWebSocket sends events as WebSocket text messages until the
+// client goes away or the subscription is dropped.
+func (s *SwarmAggregator) streamEventsWebSocket(w http.ResponseWriter, r *http.Request, sub *eventSubscription, backlog []Event) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrade already wrote the error response
+	}
+	defer conn.Close()
+
+	// Clients send nothing; reading is how we notice one went away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(ev Event) bool {
+		data, err := json.Marshal(ev)
+		return err == nil && wsWrite(conn, data)
+	}
+	for _, ev := range backlog {
+		if !send(ev) {
+			return
+		}
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case ev, ok := <-sub.ch:
+			if !ok || !send(ev) {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package swarm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// readSSE reads n events from an SSE stream, with their IDs.
+func readSSE(t *testing.T, r *bufio.Reader, n int) ([]Event, []string) {
+	t.Helper()
+	var events []Event
+	var ids []string
+	var id, data string
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected %d events, read %d: %v", n, len(events), err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			var ev Event
+			if err := json.Unmarshal([]byte(data), &ev); err != nil {
+				t.Fatal(err)
+			}
+			events, ids = append(events, ev), append(ids, id)
+			id, data = "", ""
+		}
+	}
+	return events, ids
+}
+
+// openEvents opens GET /events with the test subscriber key.
+func openEvents(t *testing.T, srv *httptest.Server, query string, header http.Header) *http.Response {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events"+query, nil)
+	req.Header.Set("X-API-Key", testSubscriberSecret)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestEventsPublishedPerChange(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Quota.ReportsPerHour = 1
+	cfg.Expiry.TTL = Duration(time.Hour)
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	var got []Event
+	agg.events.handle(func(_ context.Context, events []Event) { got = append(got, events...) },
+		EventReportAccepted, EventPromoted, EventRemoved, EventExpired, EventRetracted, EventBanned)
+
+	ctx := context.Background()
+	report := IOCReport{Address: evmAddress("drainer"), ChainID: 1, Category: "drainer", Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"}
+	agg.SubmitReport(ctx, report)
+	report.Address = evmAddress("spam")
+	if _, err := agg.SubmitReport(ctx, report); err == nil {
+		t.Fatal("Expected the second report over quota")
+	}
+	agg.ExpireDue(ctx, time.Now().Add(2*time.Hour))
+
+	want := []EventType{EventReportAccepted, EventPromoted, EventBanned, EventExpired}
+	if len(got) != len(want) {
+		t.Fatalf("Expected events %v, got %+v", want, got)
+	}
+	for i, ev := range got {
+		if ev.Type != want[i] || ev.Seq != uint64(i+1) || ev.Schema != eventSchemaVersion || ev.Stream == "" {
+			t.Errorf("Event %d: expected %s seq %d, got %+v", i, want[i], i+1, ev)
+		}
+	}
+	if got[0].SourceID != "agent-A" || got[1].Source != provenanceConsensus || got[1].Tier != TierMain || got[1].Category != "drainer" {
+		t.Errorf("Unexpected report and promotion events %+v %+v", got[0], got[1])
+	}
+	if got[2].SourceID != "agent-A" || got[2].Until == nil || got[3].Address != evmAddress("drainer") || got[3].FromTier != TierMain {
+		t.Errorf("Unexpected ban and expiry events %+v %+v", got[2], got[3])
+	}
+}
+
+func TestEventStreamSSE(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	t.Cleanup(srv.Close) // after the streams opened below are closed
+
+	resp := openEvents(t, srv, "?type=promoted,removed", nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	address := evmAddress("streamed")
+	agg.IngestReport(context.Background(), IOCReport{Address: address, ChainID: 1, Confidence: 1.0, Timestamp: time.Now(), SourceID: "agent-A"})
+	agg.Unblock(context.Background(), address)
+
+	events, ids := readSSE(t, bufio.NewReader(resp.Body), 2)
+	if events[0].Type != EventPromoted || events[1].Type != EventRemoved || events[1].Reason != eventReasonUnblocked {
+		t.Errorf("Expected the promotion then the removal, got %+v", events)
+	}
+	if events[0].Seq != 2 || events[1].Seq != 3 || events[0].TypeSeq != 1 || events[1].TypeSeq != 1 {
+		t.Errorf("Expected the filtered report_accepted to leave seq 1 unsent, got %+v", events)
+	}
+	if ids[1] != events[1].Stream+":3" {
+		t.Errorf("Expected the SSE id to be stream:seq, got %q", ids[1])
+	}
+
+	for query, want := range map[string]int{"?type=bogus": http.StatusBadRequest, "?since=x": http.StatusBadRequest} {
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events"+query, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", query, want, rec.Code)
+		}
+	}
+	agg.keys.Add("tenant-secret", APIKey{ID: "siem", Namespace: "acme", Role: RoleSubscriber})
+	req := httptest.NewRequest(http.MethodGet, "/events", nil)
+	req.Header.Set("X-API-Key", "tenant-secret")
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a namespaced key refused, got %d", rec.Code)
+	}
+}
+
+func TestEventStreamResumesFromHistory(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Events.History = 2
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	t.Cleanup(srv.Close) // after the streams opened below are closed
+	for _, seed := range []string{"one", "two", "three"} {
+		agg.Block(context.Background(), AdminAction{Address: evmAddress(seed), ChainID: 1})
+	}
+	stream := agg.events.stream
+
+	events, _ := readSSE(t, bufio.NewReader(openEvents(t, srv, "?since=0&stream="+stream, nil).Body), 2)
+	if events[0].Seq != 2 || events[1].Address != evmAddress("three") {
+		t.Errorf("Expected the two retained events, seq 1 lost to the gap, got %+v", events)
+	}
+	events, _ = readSSE(t, bufio.NewReader(openEvents(t, srv, "", http.Header{"Last-Event-Id": {stream + ":2"}}).Body), 1)
+	if events[0].Seq != 3 {
+		t.Errorf("Expected Last-Event-ID to resume after seq 2, got %+v", events)
+	}
+	events, _ = readSSE(t, bufio.NewReader(openEvents(t, srv, "?since=3&stream=earlier", nil).Body), 1)
+	if events[0].Seq != 2 {
+		t.Errorf("Expected a since from another stream to replay the whole history, got %+v", events)
+	}
+}
+
+func TestEventStreamWebSocketClosedOnRevoke(t *testing.T) {
+	agg := NewSwarmAggregator()
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/events?type=promoted", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	// The subscription is taken before the upgrade is answered.
+	agg.Block(context.Background(), AdminAction{Address: evmAddress("ws-event"), ChainID: 1, Reason: "phishing kit"})
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var ev Event
+	if err := conn.ReadJSON(&ev); err != nil || ev.Type != EventPromoted || ev.Source != provenanceAdmin || ev.Reason != "phishing kit" {
+		t.Fatalf("Expected the admin promotion, got %+v (%v)", ev, err)
+	}
+	agg.RevokeAPIKey("siem")
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("Expected the stream closed with its key")
+	}
+}
+
+func TestEventBusDropsSlowSubscriber(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Events.SubscriberBuffer = 1
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	sub, _ := agg.events.subscribe("slow", nil, eventResume{})
+	agg.Block(context.Background(), AdminAction{Address: evmAddress("first"), ChainID: 1})
+	agg.Block(context.Background(), AdminAction{Address: evmAddress("second"), ChainID: 1})
+
+	if ev := <-sub.ch; ev.Address != evmAddress("first") {
+		t.Errorf("Expected the buffered event delivered, got %+v", ev)
+	}
+	if _, ok := <-sub.ch; ok {
+		t.Error("Expected the subscription closed once its buffer overflowed")
+	}
+	if got := testutil.ToFloat64(agg.metrics.eventSubscribersDropped); got != 1 {
+		t.Errorf("Expected one dropped subscriber counted, got %v", got)
+	}
+}
@@ -0,0 +1,174 @@
+// Package swarm — Report evidence.
+//
+// A report may cite evidence for its verdict: the hash of a transaction
+// that drained a victim, the URL of the phishing page, or a malicious
+// signature request.  Evidence is checked at admission, a report with
+// invalid evidence being rejected whole with an *EvidenceError: at most
+// maxEvidencePerReport items of at most maxEvidenceValueLen bytes each,
+// of a known type, with transaction hashes 0x followed by 64 hex digits
+// (lowercased) and URLs absolute http(s), stripped of their query string
+// and userinfo (see sanitize.go).  An item without a chain ID
+// takes the report's.
+//
+// Each TWAB entry keeps up to maxEntryEvidence unique items, the first
+// cited, and counts the reports that carried any; with
+// twab.require_evidence_for_promotion set, an address needs at least one
+// such report to promote.  Evidence is for analysts: it is shown in
+// GET /address/{addr} to global admin keys only, and is never part of a
+// filter payload, the confirmed record, or the retained reports.
+// Evidence is carried in JSON reports; the protobuf schema does not yet
+// have it.
+package swarm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// EvidenceType is the kind of an evidence item.
+type EvidenceType string
+
+const (
+	EvidenceTxHash           EvidenceType = "tx_hash"
+	EvidenceURL              EvidenceType = "url"
+	EvidenceSignatureRequest EvidenceType = "signature_request"
+)
+
+// Evidence limits.
+const (
+	maxEvidencePerReport = 8
+	maxEvidenceValueLen  = 2048
+	maxEntryEvidence     = 32
+)
+
+// Evidence is one item a report cites in support of its verdict.  Status
+// is set by the aggregator, never taken from the report.
+type Evidence struct {
+	Type    EvidenceType       `json:"type"`
+	Value   string             `json:"value"`
+	ChainID int                `json:"chain_id,omitempty"`
+	Status  VerificationStatus `json:"status,omitempty"`
+}
+
+// sameItem reports whether two items cite the same thing, whatever their
+// status.
+func (e Evidence) sameItem(other Evidence) bool {
+	return e.Type == other.Type && e.Value == other.Value && e.ChainID == other.ChainID
+}
+
+// EvidenceError reports a report whose evidence is invalid.  Index is the
+// offending item, or -1 when the evidence as a whole is.
+type EvidenceError struct {
+	Index  int
+	Reason string
+}
+
+func (e *EvidenceError) Error() string {
+	if e.Index < 0 {
+		return "invalid evidence: " + e.Reason
+	}
+	return fmt.Sprintf("invalid evidence item %d: %s", e.Index, e.Reason)
+}
+
+// checkEvidence validates and normalizes the evidence cited by a report.
+func (s *SwarmAggregator) checkEvidence(report *IOCReport) error {
+	if err := normalizeEvidence(report); err != nil {
+		s.metrics.invalidEvidence.Inc()
+		return err
+	}
+	s.sanitizeEvidence(report)
+	return nil
+}
+
+func normalizeEvidence(report *IOCReport) error {
+	if len(report.Evidence) > maxEvidencePerReport {
+		return &EvidenceError{Index: -1, Reason: fmt.Sprintf("%d items, at most %d allowed", len(report.Evidence), maxEvidencePerReport)}
+	}
+	for i := range report.Evidence {
+		ev := &report.Evidence[i]
+		ev.Value, ev.Status = strings.TrimSpace(ev.Value), ""
+		if ev.Value == "" {
+			return &EvidenceError{Index: i, Reason: "missing value"}
+		}
+		if len(ev.Value) > maxEvidenceValueLen {
+			return &EvidenceError{Index: i, Reason: fmt.Sprintf("value longer than %d bytes", maxEvidenceValueLen)}
+		}
+		switch ev.Type {
+		case EvidenceTxHash:
+			if !isTxHash(ev.Value) {
+				return &EvidenceError{Index: i, Reason: "want a transaction hash: 0x followed by 64 hex digits"}
+			}
+			ev.Value = strings.ToLower(ev.Value)
+		case EvidenceURL:
+			u, err := url.Parse(ev.Value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return &EvidenceError{Index: i, Reason: "want an absolute http or https URL"}
+			}
+		case EvidenceSignatureRequest:
+		default:
+			return &EvidenceError{Index: i, Reason: fmt.Sprintf("unknown type %q", ev.Type)}
+		}
+		if ev.ChainID == 0 {
+			ev.ChainID = report.ChainID
+		}
+	}
+	return nil
+}
+
+func isTxHash(value string) bool {
+	if len(value) != 66 || !strings.HasPrefix(value, "0x") {
+		return false
+	}
+	_, err := hex.DecodeString(value[2:])
+	return err == nil
+}
+
+// addEvidence records a report's evidence on the entry.  The caller holds
+// the shard lock.
+func (e *TWABEntry) addEvidence(items []Evidence) {
+	if len(items) == 0 {
+		return
+	}
+	e.EvidencedReports++
+	for _, item := range items {
+		if len(e.evidence) >= maxEntryEvidence {
+			return
+		}
+		seen := false
+		for _, have := range e.evidence {
+			if have.sameItem(item) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			e.evidence = append(e.evidence, item)
+		}
+	}
+}
+
+// verifiedEvidence counts the entry's items verified on chain.  The caller
+// holds the shard lock.
+func (e *TWABEntry) verifiedEvidence() int {
+	n := 0
+	for _, item := range e.evidence {
+		if item.Status == EvidenceVerified {
+			n++
+		}
+	}
+	return n
+}
+
+// isAnalyst reports whether a request presents a global admin key, and
+// may see evidence.
+func (s *SwarmAggregator) isAnalyst(r *http.Request) bool {
+	secret := presentedSecret(r)
+	if secret == "" {
+		return false
+	}
+	key, ok := s.keys.Lookup(secret)
+	return ok && key.Role == RoleAdmin && key.Namespace == ""
+}
@@ -0,0 +1,125 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testTxHash = "0xAB" + strings.Repeat("0f", 31)
+
+func TestEvidenceValidation(t *testing.T) {
+	for name, tc := range map[string]struct {
+		evidence []Evidence
+		valid    bool
+	}{
+		"tx hash":           {[]Evidence{{Type: EvidenceTxHash, Value: testTxHash}}, true},
+		"url":               {[]Evidence{{Type: EvidenceURL, Value: "https://phish.example/claim"}}, true},
+		"signature request": {[]Evidence{{Type: EvidenceSignatureRequest, Value: `{"primaryType":"Permit"}`}}, true},
+		"short tx hash":     {[]Evidence{{Type: EvidenceTxHash, Value: "0xabc"}}, false},
+		"non-hex tx hash":   {[]Evidence{{Type: EvidenceTxHash, Value: "0x" + strings.Repeat("zz", 32)}}, false},
+		"relative url":      {[]Evidence{{Type: EvidenceURL, Value: "/claim"}}, false},
+		"unknown type":      {[]Evidence{{Type: "screenshot", Value: "x"}}, false},
+		"empty value":       {[]Evidence{{Type: EvidenceSignatureRequest, Value: " "}}, false},
+		"oversized value":   {[]Evidence{{Type: EvidenceSignatureRequest, Value: strings.Repeat("x", maxEvidenceValueLen+1)}}, false},
+		"too many items":    {make([]Evidence, maxEvidencePerReport+1), false},
+	} {
+		report := IOCReport{ChainID: 1, Evidence: tc.evidence}
+		err := normalizeEvidence(&report)
+		var evErr *EvidenceError
+		if tc.valid != (err == nil) || (err != nil && !errors.As(err, &evErr)) {
+			t.Errorf("%s: expected valid=%v, got %v", name, tc.valid, err)
+		}
+	}
+
+	report := IOCReport{ChainID: 137, Evidence: []Evidence{{Type: EvidenceTxHash, Value: testTxHash}}}
+	normalizeEvidence(&report)
+	if ev := report.Evidence[0]; ev.Value != strings.ToLower(testTxHash) || ev.ChainID != 137 {
+		t.Errorf("Expected a lowercased hash on the report's chain, got %+v", ev)
+	}
+}
+
+func TestEvidenceStoredOnEntryAndShownToAnalysts(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	agg.keys.Add("admin-secret", APIKey{ID: "analyst", Role: RoleAdmin})
+	addr := evmAddress("drainer")
+	tx := Evidence{Type: EvidenceTxHash, Value: testTxHash}
+	for i := 0; i < 3; i++ {
+		report := IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-a"}
+		if i < 2 {
+			report.Evidence = []Evidence{tx} // cited twice, stored once
+		}
+		if _, err := agg.SubmitReport(context.Background(), report); err != nil {
+			t.Fatalf("SubmitReport failed: %v", err)
+		}
+	}
+
+	detail := func(secret string) TWABDetail {
+		req := httptest.NewRequest(http.MethodGet, "/address/"+addr, nil)
+		if secret != "" {
+			req.Header.Set("X-API-Key", secret)
+		}
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, req)
+		var resp struct {
+			TWAB TWABDetail `json:"twab"`
+		}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp.TWAB
+	}
+	d := detail("admin-secret")
+	if d.EvidencedReports != 2 || len(d.Evidence) != 1 || d.Evidence[0].Value != strings.ToLower(testTxHash) || d.Evidence[0].ChainID != 1 {
+		t.Errorf("Expected one unique item from two evidenced reports, got %+v", d)
+	}
+	if d := detail(""); d.Evidence != nil || d.ReportCount != 3 {
+		t.Errorf("Expected evidence withheld from anonymous callers, got %+v", d)
+	}
+
+	// Evidence never reaches subscribers.
+	for _, format := range []FilterFormat{FormatBloom, FormatExact} {
+		env, err := agg.signFormat(agg.globalSnapshot(), format)
+		if err != nil || strings.Contains(strings.ToLower(string(env.Payload)), strings.ToLower(testTxHash)) {
+			t.Errorf("Expected no evidence in the %s payload (%v)", format, err)
+		}
+	}
+}
+
+func TestEvidenceRejectedOnIngest(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	agg.config.Ingest.Synchronous = true
+	body := `{"address":"` + evmAddress("x") + `","chain_id":1,"confidence":0.9,"source_id":"a","evidence":[{"type":"tx_hash","value":"0x12"}]}`
+	req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), string(CodeInvalidEvidence)) {
+		t.Errorf("Expected 422 invalid_evidence, got %d: %s", rec.Code, rec.Body)
+	}
+	if agg.BloomFilterLen() != 0 {
+		t.Error("A report with invalid evidence must not be ingested")
+	}
+}
+
+func TestRequireEvidenceForPromotion(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2, RequireEvidenceForPromotion: true})
+	addr := evmAddress("needs-proof")
+	ctx := context.Background()
+	agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "a"})
+	if agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "b"}) {
+		t.Fatal("Expected no promotion without evidence")
+	}
+	explanation := agg.twab.Explain(addr, agg.config.TWAB)
+	if last := explanation.Gates[len(explanation.Gates)-1]; last.Gate != gateEvidence || last.Passed {
+		t.Errorf("Expected a failing evidence gate, got %+v", explanation.Gates)
+	}
+
+	report := IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "c",
+		Evidence: []Evidence{{Type: EvidenceURL, Value: "https://phish.example"}}}
+	if !agg.IngestReport(ctx, report) {
+		t.Error("Expected promotion once a report cites evidence")
+	}
+}
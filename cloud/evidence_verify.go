@@ -0,0 +1,474 @@
+// Package swarm — On-chain evidence verification.
+//
+// A transaction hash cited as evidence is only a claim.  With an
+// EvidenceVerifier, from evidence_verification.chains or registered with
+// SetEvidenceVerifier, each hash a global report cites is checked in the
+// background, and the item is annotated, on every address citing it, as
+// "verified" once the transaction is at least the configured number of
+// blocks deep, "invalid" if the chain does not know it, or "unverified"
+// while it is shallower, pending, or the check failed.  An unverified item
+// is checked again every evidence_verification.recheck, up to max_checks
+// times, so a transaction reorged back into the mempool stays unverified
+// and one dropped by the reorg turns invalid; a verified one is taken as
+// final.  With twab.require_verified_evidence, promotion needs at least
+// one verified item, and an address that meets every other gate is
+// promoted as soon as its evidence verifies.
+//
+// Checks share one rate limit (evidence_verification.requests_per_second)
+// and final results are cached (cache_size, least recently used evicted),
+// so a hash cited by many reports is looked up once.  A full queue drops
+// the check, leaving the item unannotated.  Without a verifier nothing is
+// checked and evidence carries no status.  aegis_evidence_verifications_total
+// counts checks by outcome.  Tenant namespace reports and evidence
+// restored from a snapshot are not checked.
+package swarm
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+)
+
+// VerificationStatus is what checking an evidence item on chain found.
+type VerificationStatus string
+
+const (
+	EvidenceVerified   VerificationStatus = "verified"
+	EvidenceUnverified VerificationStatus = "unverified"
+	EvidenceInvalid    VerificationStatus = "invalid"
+)
+
+// Check outcomes recorded in aegis_evidence_verifications_total, beside
+// the statuses.
+const (
+	verificationOutcomeError   = "error"
+	verificationOutcomeDropped = "dropped"
+)
+
+// verificationQueueSize bounds the checks waiting for the verifier.
+const verificationQueueSize = 4096
+
+// ErrChainNotVerifiable is returned by a verifier for a chain it cannot
+// check.  The item is left unannotated and not checked again.
+var ErrChainNotVerifiable = errors.New("chain not verifiable")
+
+// VerificationResult is the outcome of checking a transaction.  Block is
+// the block including it and Confirmations how many blocks deep it is,
+// counting its own; both are zero unless it was mined.
+type VerificationResult struct {
+	Status        VerificationStatus `json:"status"`
+	Block         uint64             `json:"block,omitempty"`
+	Confirmations uint64             `json:"confirmations,omitempty"`
+}
+
+// EvidenceVerifier checks a cited transaction on chain.  An error means
+// the check could not be made, not that the transaction is invalid.
+type EvidenceVerifier interface {
+	Verify(ctx context.Context, chainID int, txHash string) (VerificationResult, error)
+}
+
+// EvidenceVerificationConfig configures evidence checks.  Chains enables
+// the built-in JSON-RPC verifier for EVM chains; the rest applies to any
+// verifier.
+type EvidenceVerificationConfig struct {
+	Chains            map[int]VerificationChain `json:"chains" yaml:"chains"` // config file only
+	Confirmations     int                       `json:"confirmations" yaml:"confirmations"`
+	RequestsPerSecond float64                   `json:"requests_per_second" yaml:"requests_per_second"`
+	CacheSize         int                       `json:"cache_size" yaml:"cache_size"` // zero disables the cache
+	Recheck           Duration                  `json:"recheck" yaml:"recheck"`
+	MaxChecks         int                       `json:"max_checks" yaml:"max_checks"` // per item
+	Timeout           Duration                  `json:"timeout" yaml:"timeout"`       // of one RPC call
+}
+
+// Enabled reports whether any chain has an RPC endpoint.
+func (c EvidenceVerificationConfig) Enabled() bool { return len(c.Chains) > 0 }
+
+// VerificationChain is the RPC endpoint for one chain.  Zero
+// Confirmations uses evidence_verification.confirmations.
+type VerificationChain struct {
+	RPCURL        string `json:"rpc_url" yaml:"rpc_url"`
+	Confirmations int    `json:"confirmations" yaml:"confirmations"`
+}
+
+// verificationJob is one item to check for one address.  checks counts
+// the checks already made.
+type verificationJob struct {
+	address string
+	item    Evidence
+	checks  int
+}
+
+func (j verificationJob) key() string {
+	return j.address + "\x00" + verificationCacheKey(j.item)
+}
+
+func verificationCacheKey(item Evidence) string {
+	return strconv.Itoa(item.ChainID) + "\x00" + item.Value
+}
+
+// cachedVerification is one final result in the cache.
+type cachedVerification struct {
+	key    string
+	result VerificationResult
+}
+
+// evidenceVerification queues, rate-limits and caches evidence checks.
+type evidenceVerification struct {
+	limiter   *rate.Limiter
+	recheck   time.Duration
+	maxChecks int
+	cacheSize int
+	checks    *prometheus.CounterVec // outcome
+	queue     chan verificationJob
+
+	mu       sync.Mutex
+	verifier EvidenceVerifier         // nil checks nothing
+	pending  map[string]bool          // job key -> queued or awaiting a recheck
+	cache    map[string]*list.Element // cache key -> element holding *cachedVerification
+	lru      *list.List               // most recently used first
+}
+
+func newEvidenceVerification(cfg EvidenceVerificationConfig, checks *prometheus.CounterVec) *evidenceVerification {
+	v := &evidenceVerification{
+		limiter:   rate.NewLimiter(rate.Limit(cfg.RequestsPerSecond), 1),
+		recheck:   time.Duration(cfg.Recheck),
+		maxChecks: cfg.MaxChecks,
+		cacheSize: cfg.CacheSize,
+		checks:    checks,
+		queue:     make(chan verificationJob, verificationQueueSize),
+		pending:   make(map[string]bool),
+		cache:     make(map[string]*list.Element),
+		lru:       list.New(),
+	}
+	if cfg.Enabled() {
+		v.verifier = NewEVMVerifier(cfg)
+	}
+	return v
+}
+
+// SetEvidenceVerifier checks cited transactions with verifier, replacing
+// the one configured; nil stops checking.
+func (s *SwarmAggregator) SetEvidenceVerifier(verifier EvidenceVerifier) {
+	s.verification.mu.Lock()
+	defer s.verification.mu.Unlock()
+	s.verification.verifier = verifier
+}
+
+// queueEvidenceChecks queues the transaction hashes a report cites.
+func (s *SwarmAggregator) queueEvidenceChecks(report IOCReport) {
+	v := s.verification
+	for _, item := range report.Evidence {
+		if item.Type != EvidenceTxHash {
+			continue
+		}
+		job := verificationJob{address: report.Address, item: item}
+		v.mu.Lock()
+		claimed := v.verifier != nil && !v.pending[job.key()]
+		if claimed {
+			v.pending[job.key()] = true
+		}
+		v.mu.Unlock()
+		if claimed {
+			v.send(job)
+		}
+	}
+}
+
+// send queues a claimed job, dropping it if the queue is full.
+func (v *evidenceVerification) send(job verificationJob) {
+	select {
+	case v.queue <- job:
+	default:
+		v.checks.WithLabelValues(verificationOutcomeDropped).Inc()
+		v.release(job)
+	}
+}
+
+func (v *evidenceVerification) release(job verificationJob) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	delete(v.pending, job.key())
+}
+
+// cached returns the final result for an item, if it is cached.
+func (v *evidenceVerification) cached(item Evidence) (VerificationResult, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	el, ok := v.cache[verificationCacheKey(item)]
+	if !ok {
+		return VerificationResult{}, false
+	}
+	v.lru.MoveToFront(el)
+	return el.Value.(*cachedVerification).result, true
+}
+
+// store caches a final result.
+func (v *evidenceVerification) store(item Evidence, result VerificationResult) {
+	if v.cacheSize <= 0 {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key := verificationCacheKey(item)
+	if el, ok := v.cache[key]; ok {
+		el.Value.(*cachedVerification).result = result
+		v.lru.MoveToFront(el)
+		return
+	}
+	v.cache[key] = v.lru.PushFront(&cachedVerification{key: key, result: result})
+	for v.lru.Len() > v.cacheSize {
+		oldest := v.lru.Back()
+		v.lru.Remove(oldest)
+		delete(v.cache, oldest.Value.(*cachedVerification).key)
+	}
+}
+
+// runEvidenceVerification checks queued evidence until ctx is done.
+func (s *SwarmAggregator) runEvidenceVerification(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-s.verification.queue:
+			s.verifyEvidence(ctx, job)
+		}
+	}
+}
+
+// verifyEvidence checks one item, annotates it, and schedules a recheck
+// while it is unverified.
+func (s *SwarmAggregator) verifyEvidence(ctx context.Context, job verificationJob) {
+	v := s.verification
+	v.mu.Lock()
+	verifier := v.verifier
+	v.mu.Unlock()
+	if verifier == nil {
+		v.release(job)
+		return
+	}
+
+	result, cached := v.cached(job.item)
+	outcome := string(result.Status)
+	if !cached {
+		if err := v.limiter.Wait(ctx); err != nil {
+			v.release(job)
+			return
+		}
+		var err error
+		result, err = verifier.Verify(ctx, job.item.ChainID, job.item.Value)
+		if errors.Is(err, ErrChainNotVerifiable) {
+			v.release(job)
+			return
+		}
+		if err != nil {
+			log.Printf("Verifying evidence %s on chain %d: %v", job.item.Value, job.item.ChainID, err)
+			result, outcome = VerificationResult{Status: EvidenceUnverified}, verificationOutcomeError
+		} else {
+			outcome = string(result.Status)
+			if result.Status != EvidenceUnverified {
+				v.store(job.item, result)
+			}
+		}
+	}
+	v.checks.WithLabelValues(outcome).Inc()
+
+	changed, tracked := s.twab.annotateEvidence(job.address, job.item, result.Status)
+	job.checks++
+	if tracked && result.Status == EvidenceUnverified && job.checks < v.maxChecks {
+		time.AfterFunc(v.recheck, func() { v.send(job) })
+	} else {
+		v.release(job)
+	}
+	if changed && result.Status == EvidenceVerified {
+		s.promoteVerified(ctx, job.address)
+	}
+}
+
+// promoteVerified promotes an address whose evidence just verified, if
+// that was all it lacked.
+func (s *SwarmAggregator) promoteVerified(ctx context.Context, address string) {
+	if _, ok := s.Confirmed(address); ok {
+		return
+	}
+	if !s.twab.MeetsThreshold(address, s.current().TWAB) || s.coolingDown(address, s.clock.Now()) {
+		return
+	}
+	report, ok := s.twab.latest(address)
+	if !ok {
+		return
+	}
+	s.promoteConsensus(ctx, report, s.clock.Now(), s.reviews(report))
+}
+
+// annotateEvidence sets the status of an address's evidence item.
+// changed is false if it already had it; tracked is false if the address
+// no longer cites the item.
+func (t *TWAB) annotateEvidence(address string, item Evidence, status VerificationStatus) (changed, tracked bool) {
+	shard := t.shardFor(address)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, ok := shard.entries[address]
+	if !ok {
+		return false, false
+	}
+	for i := range entry.evidence {
+		if have := &entry.evidence[i]; have.sameItem(item) {
+			changed = have.Status != status
+			have.Status = status
+			return changed, true
+		}
+	}
+	return false, false
+}
+
+// latest returns the latest report retained for an address.
+func (t *TWAB) latest(address string) (IOCReport, bool) {
+	shard := t.shardFor(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, ok := shard.entries[address]
+	if !ok || len(entry.recent) == 0 {
+		return IOCReport{}, false
+	}
+	recent := entry.Recent()
+	return recent[len(recent)-1], true
+}
+
+// maxRPCResponseBytes bounds a JSON-RPC response.
+const maxRPCResponseBytes = 1 << 20
+
+// EVMVerifier checks transactions over the Ethereum JSON-RPC API: one
+// the node has mined is as deep as the chain head is above its block, and
+// one it knows nothing of, neither mined nor pending, is invalid.
+type EVMVerifier struct {
+	client        *http.Client
+	chains        map[int]VerificationChain
+	confirmations int
+}
+
+// NewEVMVerifier returns a verifier for the chains of cfg.
+func NewEVMVerifier(cfg EvidenceVerificationConfig) *EVMVerifier {
+	return &EVMVerifier{
+		client:        &http.Client{Timeout: time.Duration(cfg.Timeout)},
+		chains:        cfg.Chains,
+		confirmations: cfg.Confirmations,
+	}
+}
+
+// Verify implements EvidenceVerifier.
+func (v *EVMVerifier) Verify(ctx context.Context, chainID int, txHash string) (VerificationResult, error) {
+	chain, ok := v.chains[chainID]
+	if !ok {
+		return VerificationResult{}, fmt.Errorf("%w: no rpc endpoint for chain %d", ErrChainNotVerifiable, chainID)
+	}
+	want := chain.Confirmations
+	if want <= 0 {
+		want = v.confirmations
+	}
+
+	var receipt *struct {
+		BlockNumber string `json:"blockNumber"`
+	}
+	if err := v.call(ctx, chain.RPCURL, "eth_getTransactionReceipt", txHash, &receipt); err != nil {
+		return VerificationResult{}, err
+	}
+	if receipt == nil || receipt.BlockNumber == "" {
+		var tx *struct {
+			Hash string `json:"hash"`
+		}
+		if err := v.call(ctx, chain.RPCURL, "eth_getTransactionByHash", txHash, &tx); err != nil {
+			return VerificationResult{}, err
+		}
+		if tx == nil {
+			return VerificationResult{Status: EvidenceInvalid}, nil
+		}
+		return VerificationResult{Status: EvidenceUnverified}, nil // pending
+	}
+	block, err := parseQuantity(receipt.BlockNumber)
+	if err != nil {
+		return VerificationResult{}, fmt.Errorf("receipt block number: %w", err)
+	}
+
+	var head string
+	if err := v.call(ctx, chain.RPCURL, "eth_blockNumber", nil, &head); err != nil {
+		return VerificationResult{}, err
+	}
+	headBlock, err := parseQuantity(head)
+	if err != nil {
+		return VerificationResult{}, fmt.Errorf("block number: %w", err)
+	}
+	result := VerificationResult{Status: EvidenceUnverified, Block: block}
+	if headBlock >= block {
+		result.Confirmations = headBlock - block + 1
+	}
+	if result.Confirmations >= uint64(want) {
+		result.Status = EvidenceVerified
+	}
+	return result, nil
+}
+
+// call makes a JSON-RPC call with at most one parameter and decodes its
+// result into out.
+func (v *EVMVerifier) call(ctx context.Context, url, method string, param, out any) error {
+	params := []any{}
+	if param != nil {
+		params = append(params, param)
+	}
+	body, err := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: rpc endpoint answered %s", method, resp.Status)
+	}
+	var rpc struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxRPCResponseBytes)).Decode(&rpc); err != nil {
+		return fmt.Errorf("%s: decode response: %w", method, err)
+	}
+	if rpc.Error != nil {
+		return fmt.Errorf("%s: rpc error %d: %s", method, rpc.Error.Code, rpc.Error.Message)
+	}
+	if len(rpc.Result) == 0 {
+		return fmt.Errorf("%s: response has no result", method)
+	}
+	return json.Unmarshal(rpc.Result, out)
+}
+
+// parseQuantity parses a JSON-RPC hex quantity.
+func parseQuantity(s string) (uint64, error) {
+	if !strings.HasPrefix(s, "0x") {
+		return 0, fmt.Errorf("quantity %q is not 0x-prefixed hex", s)
+	}
+	return strconv.ParseUint(s[2:], 16, 64)
+}
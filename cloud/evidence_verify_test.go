@@ -0,0 +1,218 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockVerifier answers each hash with its queued results in turn, the
+// last one repeating.
+type mockVerifier struct {
+	mu      sync.Mutex
+	results map[string][]VerificationResult
+	calls   map[string]int
+}
+
+func newMockVerifier() *mockVerifier {
+	return &mockVerifier{results: make(map[string][]VerificationResult), calls: make(map[string]int)}
+}
+
+func (m *mockVerifier) answer(txHash string, results ...VerificationResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[txHash] = results
+}
+
+func (m *mockVerifier) Verify(ctx context.Context, chainID int, txHash string) (VerificationResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	results := m.results[txHash]
+	if len(results) == 0 {
+		return VerificationResult{}, errors.New("rpc unavailable")
+	}
+	n := m.calls[txHash]
+	m.calls[txHash]++
+	if n >= len(results) {
+		n = len(results) - 1
+	}
+	return results[n], nil
+}
+
+func (m *mockVerifier) callsFor(txHash string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.calls[txHash]
+}
+
+// newVerifyingAggregator returns an aggregator checking evidence with
+// verifier, rechecking every 50ms.
+func newVerifyingAggregator(t *testing.T, twab TWABConfig, verifier EvidenceVerifier) *SwarmAggregator {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.TWAB = twab
+	cfg.EvidenceVerification.Recheck = Duration(50 * time.Millisecond)
+	cfg.EvidenceVerification.RequestsPerSecond = 1000
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.SetEvidenceVerifier(verifier)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go agg.runEvidenceVerification(ctx)
+	return agg
+}
+
+// evidenceStatus waits for an address's evidence item to get status.
+func evidenceStatus(t *testing.T, agg *SwarmAggregator, address, txHash string, status VerificationStatus) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		detail, _ := agg.twab.Detail(address)
+		for _, item := range detail.Evidence {
+			if item.Value == txHash && item.Status == status {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s of %s %s, got %+v", txHash, address, status, detail.Evidence)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func txEvidence(txHash string) []Evidence {
+	return []Evidence{{Type: EvidenceTxHash, Value: txHash}}
+}
+
+func TestVerifiedEvidencePromotes(t *testing.T) {
+	verifier := newMockVerifier()
+	agg := newVerifyingAggregator(t, TWABConfig{MinReportCount: 2, MinDistinctSources: 2, RequireVerifiedEvidence: true}, verifier)
+	ctx := context.Background()
+	addr, tx := evmAddress("drainer"), "0x"+strings.Repeat("ab", 32)
+	verifier.answer(tx,
+		VerificationResult{Status: EvidenceUnverified, Block: 100, Confirmations: 3},
+		VerificationResult{Status: EvidenceVerified, Block: 100, Confirmations: 12},
+	)
+
+	claimed := []Evidence{{Type: EvidenceTxHash, Value: tx, Status: EvidenceVerified}}
+	for _, source := range []string{"agent-A", "agent-B"} {
+		if agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, SourceID: source, Timestamp: time.Now(), Evidence: claimed}) {
+			t.Fatal("Expected no promotion before the evidence is verified")
+		}
+	}
+	if explained := agg.twab.Explain(addr, agg.current().TWAB); explained.MeetsThreshold {
+		t.Fatalf("Expected the verified evidence gate to hold, got %+v", explained.Gates)
+	}
+
+	evidenceStatus(t, agg, addr, tx, EvidenceVerified)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := agg.Confirmed(addr); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the address promoted once its evidence verified")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if calls := verifier.callsFor(tx); calls != 2 {
+		t.Errorf("Expected the hash checked until verified, twice, got %d", calls)
+	}
+}
+
+func TestInvalidEvidenceCached(t *testing.T) {
+	verifier := newMockVerifier()
+	agg := newVerifyingAggregator(t, TWABConfig{MinReportCount: 1, MinDistinctSources: 1}, verifier)
+	ctx := context.Background()
+	tx := "0x" + strings.Repeat("cd", 32)
+	verifier.answer(tx, VerificationResult{Status: EvidenceInvalid})
+
+	for _, name := range []string{"phisher-1", "phisher-2"} {
+		addr := evmAddress(name)
+		agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, SourceID: "agent-A", Timestamp: time.Now(), Evidence: txEvidence(tx)})
+		evidenceStatus(t, agg, addr, tx, EvidenceInvalid)
+	}
+	if calls := verifier.callsFor(tx); calls != 1 {
+		t.Errorf("Expected the invalid hash looked up once, got %d", calls)
+	}
+
+	unchecked := "0x" + strings.Repeat("ef", 32) // the mock errors
+	addr := evmAddress("phisher-3")
+	agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, SourceID: "agent-A", Timestamp: time.Now(), Evidence: txEvidence(unchecked)})
+	evidenceStatus(t, agg, addr, unchecked, EvidenceUnverified)
+}
+
+// rpcFixture is a JSON-RPC node with one mined and one pending
+// transaction.
+type rpcFixture struct {
+	mu   sync.Mutex
+	head uint64
+}
+
+func (f *rpcFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method string   `json:"method"`
+		Params []string `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	head := f.head
+	f.mu.Unlock()
+	var result any
+	switch {
+	case req.Method == "eth_blockNumber":
+		result = "0x" + strconv.FormatUint(head, 16)
+	case req.Method == "eth_getTransactionReceipt" && req.Params[0] == minedTx:
+		result = map[string]string{"blockNumber": "0x64"} // 100
+	case req.Method == "eth_getTransactionByHash" && req.Params[0] == pendingTx:
+		result = map[string]any{"hash": pendingTx, "blockNumber": nil}
+	}
+	json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": 1, "result": result})
+}
+
+var (
+	minedTx   = "0x" + strings.Repeat("11", 32)
+	pendingTx = "0x" + strings.Repeat("22", 32)
+)
+
+func TestEVMVerifier(t *testing.T) {
+	fixture := &rpcFixture{head: 105}
+	srv := httptest.NewServer(fixture)
+	defer srv.Close()
+	cfg := DefaultConfig().EvidenceVerification
+	cfg.Chains = map[int]VerificationChain{1: {RPCURL: srv.URL}}
+	verifier := NewEVMVerifier(cfg)
+	ctx := context.Background()
+
+	if res, err := verifier.Verify(ctx, 1, minedTx); err != nil || res.Status != EvidenceUnverified || res.Block != 100 || res.Confirmations != 6 {
+		t.Errorf("Expected the shallow transaction unverified at 6 confirmations, got %+v, %v", res, err)
+	}
+	fixture.mu.Lock()
+	fixture.head = 111
+	fixture.mu.Unlock()
+	if res, err := verifier.Verify(ctx, 1, minedTx); err != nil || res.Status != EvidenceVerified || res.Confirmations != 12 {
+		t.Errorf("Expected the transaction verified at 12 confirmations, got %+v, %v", res, err)
+	}
+	if res, err := verifier.Verify(ctx, 1, pendingTx); err != nil || res.Status != EvidenceUnverified {
+		t.Errorf("Expected the pending transaction unverified, got %+v, %v", res, err)
+	}
+	if res, err := verifier.Verify(ctx, 1, "0x"+strings.Repeat("33", 32)); err != nil || res.Status != EvidenceInvalid {
+		t.Errorf("Expected the unknown transaction invalid, got %+v, %v", res, err)
+	}
+	if _, err := verifier.Verify(ctx, 56, minedTx); !errors.Is(err, ErrChainNotVerifiable) {
+		t.Errorf("Expected a chain without an endpoint not verifiable, got %v", err)
+	}
+	srv.Close()
+	if _, err := verifier.Verify(ctx, 1, minedTx); err == nil || errors.Is(err, ErrChainNotVerifiable) {
+		t.Errorf("Expected an unreachable endpoint to fail the check, got %v", err)
+	}
+}
@@ -0,0 +1,209 @@
+// Package swarm — Exact-set filter format.
+//
+// Clients that cannot act on a probabilistic structure can ask for the
+// confirmed set itself: format=exact on GET /filter and /ws, or
+// SubscribeOptions.Format.  An exact envelope (kind "exact") carries the
+// sorted addresses in chunks of exactChunkSize, each gzip-compressed on its
+// own so a client can verify the signature once and then inflate chunk by
+// chunk.  Each chunk also lists the consensus scores of its addresses, in
+// order, as of encoding (see score.go); an address added by an admin or a
+// feed may score below the promotion score, or zero.  Exact and Bloom
+// payloads for a version are always encoded from the same filterSnapshot,
+// and carry the same version number.  The exact format is served to any
+// key the Bloom format is: it is a different encoding of what a Bloom
+// payload already lists, since that carries its entries for clients to
+// rebuild the bits from, not a disclosure only some roles may see.
+package swarm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// FilterFormat selects how the filter is delivered.
+type FilterFormat string
+
+const (
+	FormatBloom FilterFormat = "bloom"
+	FormatExact FilterFormat = "exact"
+)
+
+// envelopeExact is the kind of an exact-set envelope.
+const envelopeExact = "exact"
+
+// exactChunkSize is the number of addresses per exact chunk.
+const exactChunkSize = 5000
+
+// parseFilterFormat parses a format parameter; empty means bloom.
+func parseFilterFormat(v string) (FilterFormat, error) {
+	switch FilterFormat(v) {
+	case "", FormatBloom:
+		return FormatBloom, nil
+	case FormatExact:
+		return FormatExact, nil
+	}
+	return "", fmt.Errorf("unknown filter format %q, want bloom or exact", v)
+}
+
+// filterSnapshot is the filter at one version, from which every format is
+// encoded.  Entries are sorted.  logical is the replication logical
+// version, zero for namespace filters.  scorer gives the consensus score
+// of an entry, and is only called when the exact format is encoded; nil
+// scores every entry zero.
+type filterSnapshot struct {
+	version uint64
+	logical uint64
+	entries []string
+	params  BloomParams
+	scorer  func(address string) float64
+	rebuild bool         // envelopes are flagged rebuild (see rebuild.go)
+	state   *FilterState // the capture snap is, if unaltered (see filterstate.go)
+}
+
+// globalSnapshot captures the global filter (see filterstate.go).
+func (s *SwarmAggregator) globalSnapshot() filterSnapshot {
+	return s.FilterSnapshot().snap
+}
+
+// ExactChunk is one compressed run of addresses.  Data is the base64 of
+// the gzip of the addresses joined by newlines; Scores holds their
+// consensus scores in the same order.
+type ExactChunk struct {
+	Entries int       `json:"entries"`
+	First   string    `json:"first"`
+	Last    string    `json:"last"`
+	Data    string    `json:"data"`
+	Scores  []float64 `json:"scores"`
+}
+
+// ExactPayload is the payload of an exact envelope.
+type ExactPayload struct {
+	Version  uint64       `json:"version"`
+	Count    int          `json:"count"`
+	Encoding string       `json:"encoding"`
+	Chunks   []ExactChunk `json:"chunks"`
+}
+
+// payload returns the Bloom payload of a snapshot.
+func (snap filterSnapshot) payload() FilterPayload {
+	return FilterPayload{
+		FormatVersion: BloomFormatVersion,
+		Version:       snap.version,
+		Entries:       snap.entries,
+		Count:         len(snap.entries),
+		BloomParams:   snap.params,
+	}
+}
+
+// signSnapshot signs the Bloom encoding of a snapshot.
+func (s *SwarmAggregator) signSnapshot(snap filterSnapshot) (FilterEnvelope, error) {
+	if snap.cached() {
+		return s.encodedState(snap.state, encodingJSON, s.signSnapshot)
+	}
+	data, err := json.Marshal(snap.payload())
+	if err != nil {
+		return FilterEnvelope{}, err
+	}
+	return s.signEnvelope(envelopeSnapshot, snap, data), nil
+}
+
+// signSnapshotMsgpack signs the Bloom payload of a snapshot in msgpack.
+func (s *SwarmAggregator) signSnapshotMsgpack(snap filterSnapshot) (FilterEnvelope, error) {
+	if snap.cached() {
+		return s.encodedState(snap.state, encodingMsgpack, s.signSnapshotMsgpack)
+	}
+	return s.signEnvelope(envelopeSnapshot, snap, snap.payload().appendMsgpack(nil)), nil
+}
+
+// signExact signs the exact encoding of a snapshot.
+func (s *SwarmAggregator) signExact(snap filterSnapshot) (FilterEnvelope, error) {
+	payload, err := snap.exactPayload()
+	if err != nil {
+		return FilterEnvelope{}, err
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return FilterEnvelope{}, err
+	}
+	return s.signEnvelope(envelopeExact, snap, data), nil
+}
+
+// exactPayload returns the exact payload of a snapshot.
+func (snap filterSnapshot) exactPayload() (ExactPayload, error) {
+	payload := ExactPayload{Version: snap.version, Count: len(snap.entries), Encoding: "gzip", Chunks: []ExactChunk{}}
+	for start := 0; start < len(snap.entries); start += exactChunkSize {
+		run := snap.entries[start:min(start+exactChunkSize, len(snap.entries))]
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write([]byte(strings.Join(run, "\n"))); err != nil {
+			return ExactPayload{}, err
+		}
+		if err := zw.Close(); err != nil {
+			return ExactPayload{}, err
+		}
+		scores := make([]float64, len(run))
+		if snap.scorer != nil {
+			for i, address := range run {
+				scores[i] = snap.scorer(address)
+			}
+		}
+		payload.Chunks = append(payload.Chunks, ExactChunk{
+			Entries: len(run),
+			First:   run[0],
+			Last:    run[len(run)-1],
+			Data:    base64.StdEncoding.EncodeToString(buf.Bytes()),
+			Scores:  scores,
+		})
+	}
+	return payload, nil
+}
+
+// signEnvelope signs a full-filter payload encoding snap.
+func (s *SwarmAggregator) signEnvelope(kind string, snap filterSnapshot, data []byte) FilterEnvelope {
+	keyID, sig := s.signer.Sign(snap.version, data)
+	env := FilterEnvelope{
+		Kind:      kind,
+		Version:   snap.version,
+		ToVersion: snap.version,
+		Instance:  s.config.Replication.InstanceID,
+		KeyID:     keyID,
+		Signature: sig,
+		Payload:   data,
+
+		PayloadChecksum: payloadChecksum(data),
+	}
+	if env.Instance != "" {
+		env.LogicalVersion = snap.logical
+	}
+	env.Rebuild = snap.rebuild
+	s.Identity().stamp(&env)
+	return env
+}
+
+// signFormat signs a snapshot in the given format.
+func (s *SwarmAggregator) signFormat(snap filterSnapshot, format FilterFormat) (FilterEnvelope, error) {
+	if format == FormatExact {
+		return s.signExact(snap)
+	}
+	return s.signSnapshot(snap)
+}
+
+// signEncoded signs a snapshot in the given format, its payload in the
+// given encoding.  Exact msgpack payloads are not cached, like JSON ones.
+func (s *SwarmAggregator) signEncoded(snap filterSnapshot, format FilterFormat, encoding WireEncoding) (FilterEnvelope, error) {
+	if encoding != WireMsgpack {
+		return s.signFormat(snap, format)
+	}
+	if format != FormatExact {
+		return s.signSnapshotMsgpack(snap)
+	}
+	payload, err := snap.exactPayload()
+	if err != nil {
+		return FilterEnvelope{}, err
+	}
+	return s.signEnvelope(envelopeExact, snap, payload.appendMsgpack(nil)), nil
+}
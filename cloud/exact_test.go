@@ -0,0 +1,128 @@
+package swarm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeExact inflates every chunk of an exact payload.
+func decodeExact(t *testing.T, payload []byte) (ExactPayload, []string) {
+	t.Helper()
+	var p ExactPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		t.Fatalf("Failed to decode exact payload: %v", err)
+	}
+	var entries []string
+	for _, c := range p.Chunks {
+		raw, _ := base64.StdEncoding.DecodeString(c.Data)
+		zr, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Fatalf("Failed to open chunk: %v", err)
+		}
+		text, _ := io.ReadAll(zr)
+		run := strings.Split(string(text), "\n")
+		if len(run) != c.Entries || run[0] != c.First || run[len(run)-1] != c.Last {
+			t.Errorf("Chunk header %d/%s/%s disagrees with its %d entries", c.Entries, c.First, c.Last, len(run))
+		}
+		entries = append(entries, run...)
+	}
+	return p, entries
+}
+
+func TestExactAndBloomShareSnapshot(t *testing.T) {
+	agg := NewSwarmAggregator()
+	var entries []string
+	for i := 0; i < exactChunkSize+10; i++ {
+		entries = append(entries, evmAddress(fmt.Sprintf("exact%d", i)))
+	}
+	sort.Strings(entries)
+	snap := filterSnapshot{version: 7, entries: entries, params: agg.bloomFilter.Params()}
+
+	bloom, err := agg.signSnapshot(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exact, err := agg.signExact(snap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bloom.Version != exact.Version || exact.Kind != envelopeExact {
+		t.Fatalf("Expected matching versions, got bloom %d exact %d (%s)", bloom.Version, exact.Version, exact.Kind)
+	}
+
+	var bp FilterPayload
+	json.Unmarshal(bloom.Payload, &bp)
+	p, got := decodeExact(t, exact.Payload)
+	if len(p.Chunks) != 2 || p.Count != len(entries) || p.Version != 7 {
+		t.Errorf("Expected %d entries in 2 chunks at version 7, got %+v", len(entries), p)
+	}
+	if strings.Join(got, ",") != strings.Join(bp.Entries, ",") {
+		t.Error("Exact and Bloom payloads list different entries")
+	}
+}
+
+func TestExactFilterServedLikeBloom(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	agg.keys.Add("sub-secret", APIKey{ID: "siem", Role: RoleSubscriber})
+	addr := evmAddress("exactfilter")
+	agg.IngestReport(context.Background(), IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"})
+
+	get := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/filter?format=exact", nil)
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, req)
+		return rec
+	}
+	for _, secret := range []string{"", "sub-secret"} {
+		rec := get(secret)
+		if rec.Code != http.StatusOK || rec.Header().Get(headerFilterVersion) != "1" {
+			t.Fatalf("Key %q: expected the exact filter at version 1, got %d %s", secret, rec.Code, rec.Header().Get(headerFilterVersion))
+		}
+		if _, got := decodeExact(t, rec.Body.Bytes()); len(got) != 1 || got[0] != addr {
+			t.Errorf("Key %q: expected [%s], got %v", secret, addr, got)
+		}
+	}
+	if rec := get("wrong-secret"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unknown key, got %d", rec.Code)
+	}
+}
+
+func TestExactSubscriberPushMatchesBloom(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	bloomCh := agg.Subscribe("bloom")
+	defer agg.Unsubscribe("bloom")
+	exactCh := agg.SubscribeWithOptions("exact", SubscribeOptions{Format: FormatExact})
+	defer agg.Unsubscribe("exact")
+
+	agg.IngestReport(context.Background(), IOCReport{Address: evmAddress("pushexact"), ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"})
+
+	recv := func(ch chan []byte) FilterEnvelope {
+		select {
+		case data := <-ch:
+			var env FilterEnvelope
+			json.Unmarshal(data, &env)
+			return env
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for a push")
+		}
+		return FilterEnvelope{}
+	}
+	b, e := recv(bloomCh), recv(exactCh)
+	if b.Kind != envelopeSnapshot || e.Kind != envelopeExact || b.Version != e.Version {
+		t.Errorf("Expected a snapshot and an exact push at one version, got %s@%d %s@%d", b.Kind, b.Version, e.Kind, e.Version)
+	}
+}
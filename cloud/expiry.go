@@ -0,0 +1,142 @@
+// Package swarm — TTL expiry of confirmed addresses.
+//
+// Threats go stale, so a confirmed address with no fresh reports for its
+// TTL (a global default, optionally overridden per category) is dropped
+// from the confirmed set and the filter.  Its TWAB history is forgotten
+// too: new reports start consensus over rather than re-promoting at once,
+// and cannot promote it for cooldown.expired (see cooldown.go).
+//
+// Pending expiries sit in a min-heap ordered by deadline, so a sweep only
+// touches entries that are actually due.  Refreshing an entry just moves
+// its ExpiresAt later; the stale heap item is re-queued when it surfaces.
+// Admin blocks never expire.
+package swarm
+
+import (
+	"container/heap"
+	"context"
+	"log"
+	"time"
+)
+
+// expiryItem is one scheduled deadline.
+type expiryItem struct {
+	at      time.Time
+	address string
+}
+
+// expiryQueue is a min-heap of deadlines implementing heap.Interface.
+type expiryQueue []expiryItem
+
+func (q expiryQueue) Len() int            { return len(q) }
+func (q expiryQueue) Less(i, j int) bool  { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x interface{}) { *q = append(*q, x.(expiryItem)) }
+func (q *expiryQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// ttlFor returns the TTL for a category; zero means never expire.
+func (c ExpiryConfig) ttlFor(category string) time.Duration {
+	if ttl, ok := c.CategoryTTL[category]; ok {
+		return time.Duration(ttl)
+	}
+	return time.Duration(c.TTL)
+}
+
+// Enabled reports whether any address can expire.
+func (c ExpiryConfig) Enabled() bool {
+	if c.TTL > 0 {
+		return true
+	}
+	for _, ttl := range c.CategoryTTL {
+		if ttl > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleExpiryLocked sets the deadline of a newly confirmed entry and
+// queues it.  Caller must hold s.mu.
+func (s *SwarmAggregator) scheduleExpiryLocked(entry *ConfirmedEntry, now time.Time) {
+	ttl := s.config.Expiry.ttlFor(entry.Category)
+	if ttl <= 0 {
+		return
+	}
+	at := now.Add(ttl)
+	entry.ExpiresAt = &at
+	heap.Push(&s.expiries, expiryItem{at: at, address: entry.Address})
+}
+
+// refreshExpiryLocked pushes back the deadline of an entry that received
+// a fresh report.  Caller must hold s.mu.
+func (s *SwarmAggregator) refreshExpiryLocked(entry *ConfirmedEntry, now time.Time) {
+	if entry.ExpiresAt == nil {
+		return
+	}
+	at := now.Add(s.config.Expiry.ttlFor(entry.Category))
+	if at.After(*entry.ExpiresAt) {
+		entry.ExpiresAt = &at
+	}
+}
+
+// ExpireDue removes every confirmed address whose deadline is at or before
+// now, bumping the filter version and pushing once if anything expired.
+// It returns the number of addresses expired.
+func (s *SwarmAggregator) ExpireDue(ctx context.Context, now time.Time) int {
+	if s.standby.passive() {
+		return 0 // the active expires, and the standby follows
+	}
+	var expired []Event
+
+	s.mu.Lock()
+	for s.expiries.Len() > 0 && !s.expiries[0].at.After(now) {
+		item := heap.Pop(&s.expiries).(expiryItem)
+		entry, ok := s.confirmed[item.address]
+		if !ok || entry.ExpiresAt == nil {
+			continue // unblocked, allowlisted, or replaced by an admin block
+		}
+		if entry.ExpiresAt.After(now) {
+			heap.Push(&s.expiries, expiryItem{at: *entry.ExpiresAt, address: item.address})
+			continue
+		}
+		delete(s.confirmed, item.address)
+		delete(s.feedTags, item.address)
+		s.filterRemoveLocked(entry)
+		s.twab.Forget(item.address)
+		s.startCooldown(item.address, cooldownExpired, now)
+		ev := entryEvent(EventExpired, entry)
+		ev.FromTier, ev.Time = TierMain, now
+		expired = append(expired, ev)
+	}
+	s.mu.Unlock()
+
+	for _, ev := range expired {
+		s.auditSystem(AuditEvent{Action: AuditExpire, Address: ev.Address, Time: now})
+	}
+	if len(expired) > 0 {
+		s.metrics.expired.Add(float64(len(expired)))
+		s.events.publish(ctx, expired...)
+	}
+	return len(expired)
+}
+
+// runExpirySweeper calls ExpireDue every sweep interval until ctx is done.
+func (s *SwarmAggregator) runExpirySweeper(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.config.Expiry.SweepInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if n := s.ExpireDue(ctx, now); n > 0 {
+				log.Printf("Expired %d stale addresses from the filter", n)
+			}
+		}
+	}
+}
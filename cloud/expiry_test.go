@@ -0,0 +1,99 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newExpiringAggregator(ttl time.Duration, categoryTTL map[string]Duration) *SwarmAggregator {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Expiry.TTL = Duration(ttl)
+	cfg.Expiry.CategoryTTL = categoryTTL
+	return NewSwarmAggregatorWithConfig(cfg)
+}
+
+func promote(agg *SwarmAggregator, addr, category string) {
+	for _, source := range []string{"agent-A", "agent-B"} {
+		agg.IngestReport(context.Background(), IOCReport{Address: addr, ChainID: 1, Category: category, Confidence: 0.9, Timestamp: time.Now(), SourceID: source})
+	}
+}
+
+func TestExpiryRemovesStaleAddressesAndRestartsConsensus(t *testing.T) {
+	agg := newExpiringAggregator(time.Hour, map[string]Duration{"phishing": Duration(10 * time.Minute), "sanctioned": 0})
+	ctx := context.Background()
+	promote(agg, evmAddress("default"), "")
+	promote(agg, evmAddress("phish"), "phishing")
+	promote(agg, evmAddress("ofac"), "sanctioned")
+	version := agg.bloomFilter.Version()
+
+	if n := agg.ExpireDue(ctx, time.Now()); n != 0 {
+		t.Fatalf("Nothing should expire yet, expired %d", n)
+	}
+	if n := agg.ExpireDue(ctx, time.Now().Add(15*time.Minute)); n != 1 || agg.bloomFilter.Contains(evmAddress("phish")) {
+		t.Fatalf("Expected only the phishing entry to expire at its category TTL, expired %d", n)
+	}
+	if agg.bloomFilter.Version() <= version {
+		t.Error("Expected expiry to bump the filter version")
+	}
+
+	// A single fresh report must not instantly re-promote the expired
+	// address: its TWAB history was forgotten.
+	agg.IngestReport(ctx, IOCReport{Address: evmAddress("phish"), ChainID: 1, Category: "phishing", Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"})
+	if agg.bloomFilter.Contains(evmAddress("phish")) {
+		t.Error("Expired address re-promoted without fresh consensus")
+	}
+
+	if n := agg.ExpireDue(ctx, time.Now().Add(48*time.Hour)); n != 1 {
+		t.Errorf("Expected the default-TTL entry to expire, expired %d", n)
+	}
+	if !agg.bloomFilter.Contains(evmAddress("ofac")) {
+		t.Error("Category with a zero TTL must never expire")
+	}
+	if got := testutil.ToFloat64(agg.metrics.expired); got != 2 {
+		t.Errorf("Expected expired counter 2, got %v", got)
+	}
+}
+
+func TestFreshReportsExtendExpiry(t *testing.T) {
+	agg := newExpiringAggregator(time.Hour, nil)
+	promote(agg, evmAddress("hot"), "")
+	first, _ := agg.Confirmed(evmAddress("hot"))
+
+	time.Sleep(5 * time.Millisecond)
+	agg.IngestReport(context.Background(), IOCReport{Address: evmAddress("hot"), ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-C"})
+	refreshed, _ := agg.Confirmed(evmAddress("hot"))
+	if !refreshed.ExpiresAt.After(*first.ExpiresAt) {
+		t.Fatalf("Expected fresh report to extend expiry past %v, got %v", first.ExpiresAt, refreshed.ExpiresAt)
+	}
+
+	// Due by the original deadline, but not by the refreshed one.
+	if n := agg.ExpireDue(context.Background(), first.ExpiresAt.Add(time.Millisecond)); n != 0 {
+		t.Errorf("Refreshed entry expired at its stale deadline")
+	}
+	if n := agg.ExpireDue(context.Background(), refreshed.ExpiresAt.Add(time.Millisecond)); n != 1 {
+		t.Errorf("Expected entry to expire at its refreshed deadline")
+	}
+}
+
+func TestCheckIncludesExpiresAt(t *testing.T) {
+	agg := newExpiringAggregator(time.Hour, nil)
+	promote(agg, evmAddress("bad"), "")
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?address="+evmAddress("bad"), nil))
+	var resp struct {
+		Flagged   bool       `json:"flagged"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if !resp.Flagged || resp.ExpiresAt == nil || time.Until(*resp.ExpiresAt) < 59*time.Minute {
+		t.Errorf("Expected expires_at about an hour out, got %+v", resp)
+	}
+}
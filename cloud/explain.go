@@ -0,0 +1,209 @@
+// Package swarm — Consensus explanations.
+//
+// TWAB.Explain breaks the threshold decision for an address into its
+// gates, each with the configured threshold, the observed value, and
+// whether it passed, alongside its consensus score (see score.go) and the
+// type or category override whose thresholds applied, if any, and the
+// source tiers its reports came from (see sourcetier.go).  The time-span
+// gate observes the span covered by twab.min_distinct_sources sources on
+// receive times, no more than the claimed span; both the claimed and the
+// received spans are shown beside it.  The severity
+// band applied (see severityband.go) is named by its min_severity.  With
+// twab.min_weighted_score it lists each source's tier and contribution to
+// the weighted score, raw and capped, in order of first report and
+// without source IDs.  GET
+// /explain serves it to reporters and admins, and POST /ingest?verbose=1
+// attaches it to the response so SDK developers see at once why a report
+// did not promote.
+package swarm
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Threshold gate names.
+const (
+	gateReportCount      = "report_count"
+	gateTimeSpan         = "time_span_seconds"
+	gateDistinctSources  = "distinct_sources"
+	gateDistinctNetworks = "distinct_networks"  // only with min_distinct_networks
+	gateMeanConfidence   = "average_confidence" // only with min_average_confidence
+	gateWeightedScore    = "weighted_score"     // only with min_weighted_score
+	gateEvidence         = "evidenced_reports"  // only with require_evidence_for_promotion
+	gateVerifiedEvidence = "verified_evidence"  // only with require_verified_evidence
+	gateTrusted          = "trusted_report"     // only with trusted_confidence
+)
+
+// ThresholdGate is the outcome of one consensus gate.
+type ThresholdGate struct {
+	Gate      string  `json:"gate"`
+	Threshold float64 `json:"threshold"`
+	Observed  float64 `json:"observed"`
+	Passed    bool    `json:"passed"`
+}
+
+// SourceContribution is one source's contribution to the weighted score:
+// the confidences of its reports summed, and that weighed by its tier and
+// capped at twab.max_source_contribution of the gate.
+type SourceContribution struct {
+	Tier   SourceTier `json:"tier"`
+	Raw    float64    `json:"raw"`
+	Capped float64    `json:"capped"`
+}
+
+// ThresholdExplanation is the full threshold decision for an address.
+// MeetsThreshold is true when ConsensusScore reaches PromotionScore and
+// the network, weighted score, average confidence and evidence gates, if
+// enabled, passed; with the
+// default score weights that is exactly when every gate passed.  It is
+// also true when the trusted report gate, if enabled, passed, whatever
+// the others.  An untracked address fails every gate with zero
+// observations.  Tiers counts the reports of each source tier.
+//
+// Category is the category most of the address's reports give, and
+// Override the thresholds applied in place of the configured ones, named
+// by their path under twab, e.g. "category_overrides.sanctions".
+//
+// ClaimedSpan and ReceivedSpan are the spans, in seconds, between the
+// first and last report by their claimed and their receive times; the
+// time-span gate observes at most the first, and the part of the second
+// covered by enough sources.
+type ThresholdExplanation struct {
+	Address        string               `json:"address"`
+	Tracked        bool                 `json:"tracked"`
+	Category       string               `json:"category,omitempty"`
+	Override       string               `json:"override,omitempty"`
+	MaxSeverity    int                  `json:"max_severity,omitempty"`
+	SeverityBand   int                  `json:"severity_band,omitempty"`
+	MeetsThreshold bool                 `json:"meets_threshold"`
+	ConsensusScore float64              `json:"consensus_score"`
+	PromotionScore float64              `json:"promotion_score"`
+	Gates          []ThresholdGate      `json:"gates"`
+	ClaimedSpan    float64              `json:"claimed_span_seconds"`
+	ReceivedSpan   float64              `json:"received_span_seconds"`
+	Tiers          map[SourceTier]int   `json:"tiers,omitempty"`
+	Contributions  []SourceContribution `json:"source_contributions,omitempty"`
+}
+
+// Explain reports how an address fares against each gate of config.  The
+// shard is only locked while the entry's counts are copied.
+func (t *TWAB) Explain(address string, config TWABConfig) ThresholdExplanation {
+	shard := t.shardFor(address)
+	shard.mu.RLock()
+	entry, tracked := shard.entries[address]
+	config, override, band := config.resolve(address, entry)
+	var reports, sources, networks, evidenced, verified, severity int
+	var span, claimed, received, mean, score float64
+	var weighted, trusted float64
+	var category string
+	var tiers map[SourceTier]int
+	var contributions []SourceContribution
+	if tracked {
+		reports, sources, networks, evidenced = entry.ReportCount, len(entry.Sources), len(entry.Networks), entry.EvidencedReports
+		verified = entry.verifiedEvidence()
+		span, mean, score = config.timeSpan(entry).Seconds(), entry.meanConfidence(), config.score(entry)
+		claimed, received = entry.claimedSpan().Seconds(), entry.receivedSpan().Seconds()
+		category, trusted, severity = entry.categoryGuess(), entry.BestTrusted, entry.MaxSeverity
+		tiers = make(map[SourceTier]int, len(entry.Tiers))
+		for tier, n := range entry.Tiers {
+			tiers[tier] = n
+		}
+		if config.MinWeightedScore > 0 {
+			weighted = config.weightedScore(entry)
+			for _, source := range entry.sourceOrder {
+				src := entry.Sources[source]
+				contributions = append(contributions, SourceContribution{Tier: src.Tier.orAnonymous(), Raw: src.Weight, Capped: config.contribution(src)})
+			}
+		}
+	}
+	shard.mu.RUnlock()
+
+	gates := []ThresholdGate{
+		{Gate: gateReportCount, Threshold: float64(config.MinReportCount), Observed: float64(reports)},
+		{Gate: gateTimeSpan, Threshold: config.MinTimeSpanSeconds, Observed: span},
+		{Gate: gateDistinctSources, Threshold: float64(config.MinDistinctSources), Observed: float64(sources)},
+	}
+	scored := len(gates) // gates folded into the score; the rest must pass too
+	if config.MinDistinctNetworks > 0 {
+		gates = append(gates, ThresholdGate{Gate: gateDistinctNetworks, Threshold: float64(config.MinDistinctNetworks), Observed: float64(networks)})
+	}
+	if config.MinWeightedScore > 0 {
+		gates = append(gates, ThresholdGate{Gate: gateWeightedScore, Threshold: config.MinWeightedScore, Observed: weighted})
+	}
+	if config.MinAverageConfidence > 0 {
+		gates = append(gates, ThresholdGate{Gate: gateMeanConfidence, Threshold: config.MinAverageConfidence, Observed: mean})
+	}
+	if config.RequireEvidenceForPromotion {
+		gates = append(gates, ThresholdGate{Gate: gateEvidence, Threshold: 1, Observed: float64(evidenced)})
+	}
+	if config.RequireVerifiedEvidence {
+		gates = append(gates, ThresholdGate{Gate: gateVerifiedEvidence, Threshold: 1, Observed: float64(verified)})
+	}
+	meets := tracked && score >= config.promotionScore()
+	for i := range gates {
+		gates[i].Passed = tracked && gates[i].Observed >= gates[i].Threshold
+		if i >= scored {
+			meets = meets && gates[i].Passed
+		}
+	}
+	if config.TrustedConfidence > 0 {
+		gate := ThresholdGate{Gate: gateTrusted, Threshold: config.TrustedConfidence, Observed: trusted, Passed: tracked && trusted >= config.TrustedConfidence}
+		gates = append(gates, gate)
+		meets = meets || gate.Passed
+	}
+	return ThresholdExplanation{
+		Address:        address,
+		Tracked:        tracked,
+		Category:       category,
+		Override:       override,
+		MaxSeverity:    severity,
+		SeverityBand:   band,
+		MeetsThreshold: meets,
+		ConsensusScore: score,
+		PromotionScore: config.promotionScore(),
+		Gates:          gates,
+		ClaimedSpan:    claimed,
+		ReceivedSpan:   received,
+		Tiers:          tiers,
+		Contributions:  contributions,
+	}
+}
+
+// explain explains an address against the TWAB of a namespace, "" being
+// the global swarm.
+func (s *SwarmAggregator) explain(ns, address string) ThresholdExplanation {
+	if ns == "" {
+		return s.twab.Explain(address, s.current().TWAB)
+	}
+	return s.namespace(ns).twab.Explain(address, s.current().TWAB)
+}
+
+// handleExplain is the HTTP handler for GET /explain?address=....  Keys
+// bound to a namespace are answered from that namespace's TWAB.
+func (s *SwarmAggregator) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		writeError(w, r, http.StatusBadRequest, CodeMissingAddress, "Missing address")
+		return
+	}
+	address, ok := normalizeQueryAddress(w, r, address)
+	if !ok {
+		return
+	}
+	key, _ := APIKeyFromContext(r.Context())
+	resp := struct {
+		ThresholdExplanation
+		Allowlisted bool `json:"allowlisted,omitempty"` // never promoted regardless of gates
+	}{ThresholdExplanation: s.explain(key.Namespace, address)}
+	s.mu.RLock()
+	resp.Allowlisted = s.allowlist[address]
+	s.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,176 @@
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestExplainGatesMatchThreshold(t *testing.T) {
+	cfg := TWABConfig{MinReportCount: 3, MinTimeSpanSeconds: 60, MinDistinctSources: 2}
+	tw := NewTWAB(cfg)
+	addr := evmAddress("explained")
+	base := time.Now()
+
+	if ex := tw.Explain(addr, cfg); ex.Tracked || ex.MeetsThreshold || len(ex.Gates) != 3 {
+		t.Fatalf("Expected an untracked address to fail every gate, got %+v", ex)
+	}
+
+	tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.9, Timestamp: base, SourceID: "agent-A"})
+	tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.9, Timestamp: base.Add(90 * time.Second), SourceID: "agent-A"})
+	ex := tw.Explain(addr, cfg)
+	want := map[string]ThresholdGate{
+		gateReportCount:     {Gate: gateReportCount, Threshold: 3, Observed: 2, Passed: false},
+		gateTimeSpan:        {Gate: gateTimeSpan, Threshold: 60, Observed: 90, Passed: true},
+		gateDistinctSources: {Gate: gateDistinctSources, Threshold: 2, Observed: 1, Passed: false},
+	}
+	for _, g := range ex.Gates {
+		if g != want[g.Gate] {
+			t.Errorf("Gate %s: got %+v, want %+v", g.Gate, g, want[g.Gate])
+		}
+	}
+	if ex.MeetsThreshold != tw.MeetsThreshold(addr, cfg) {
+		t.Errorf("Explain verdict %v disagrees with MeetsThreshold", ex.MeetsThreshold)
+	}
+
+	// A second source joining at the end covers no time until the window
+	// has passed again since its first report.
+	tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.9, Timestamp: base.Add(2 * time.Minute), SourceID: "agent-B"})
+	if ex := tw.Explain(addr, cfg); ex.MeetsThreshold || ex.Gates[1].Observed != 0 || ex.ClaimedSpan != 120 || ex.ReceivedSpan != 120 {
+		t.Errorf("Expected the late source to cover no time, got %+v", ex)
+	}
+	tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.9, Timestamp: base.Add(3 * time.Minute), SourceID: "agent-A"})
+	if ex := tw.Explain(addr, cfg); !ex.MeetsThreshold || !tw.MeetsThreshold(addr, cfg) {
+		t.Errorf("Expected every gate to pass, got %+v", ex)
+	}
+}
+
+func TestExplainEndpointAndVerboseIngest(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2})
+	agg.keys.Add("reporter-secret", APIKey{ID: "sdk", Role: RoleReporter})
+	agg.keys.Add("sub-secret", APIKey{ID: "siem", Role: RoleSubscriber})
+	addr := evmAddress("verbose")
+
+	rec := postIngest(agg, "/ingest?verbose=1", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-A"}`, addr))
+	var res ingestResult
+	json.NewDecoder(rec.Body).Decode(&res)
+	if rec.Code != http.StatusOK || res.Explanation == nil || res.Explanation.MeetsThreshold || !res.Explanation.Tracked {
+		t.Fatalf("Expected a failing explanation for a single report, got %d %+v", rec.Code, res.Explanation)
+	}
+	if g := res.Explanation.Gates[0]; g.Gate != gateReportCount || g.Observed != 1 || g.Passed {
+		t.Errorf("Expected the report count gate to fail at 1 of 2, got %+v", g)
+	}
+
+	rec = postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-B"}`, addr))
+	var plain ingestResult
+	json.NewDecoder(rec.Body).Decode(&plain)
+	if plain.Explanation != nil {
+		t.Error("Explanation attached without ?verbose=1")
+	}
+
+	get := func(secret string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/explain?address="+addr, nil)
+		req.Header.Set("Authorization", "Bearer "+secret)
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := get("sub-secret"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a subscriber, got %d", rec.Code)
+	}
+	rec = get("reporter-secret")
+	var ex ThresholdExplanation
+	json.NewDecoder(rec.Body).Decode(&ex)
+	if rec.Code != http.StatusOK || !ex.MeetsThreshold || ex.Address != addr {
+		t.Errorf("Expected a passing explanation, got %d %+v", rec.Code, ex)
+	}
+}
+
+func TestMinAverageConfidenceGate(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2, MinAverageConfidence: 0.6})
+	addr := evmAddress("speculative")
+
+	postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.3,"source_id":"agent-A"}`, addr))
+	rec := postIngest(agg, "/ingest?verbose=1", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.5,"source_id":"agent-B"}`, addr))
+	var res ingestResult
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.AddedToFilter || res.Explanation == nil || res.Explanation.MeetsThreshold {
+		t.Fatalf("Expected a low-confidence address held back, got %+v", res)
+	}
+	gates := res.Explanation.Gates
+	if g := gates[len(gates)-1]; g.Gate != gateMeanConfidence || g.Threshold != 0.6 || g.Observed != 0.4 || g.Passed {
+		t.Errorf("Expected the average confidence gate to fail at 0.4, got %+v", g)
+	}
+
+	// A confident third source lifts the mean to 0.6.
+	rec = postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":1.0,"source_id":"agent-C"}`, addr))
+	json.NewDecoder(rec.Body).Decode(&res)
+	if !res.AddedToFilter {
+		t.Error("Expected promotion once the mean reaches the threshold")
+	}
+
+	if ex := NewTWAB(DefaultTWABConfig()).Explain(addr, DefaultTWABConfig()); len(ex.Gates) != 3 {
+		t.Errorf("Expected no confidence gate when disabled, got %+v", ex.Gates)
+	}
+}
+
+func TestExplainCategoryOverrides(t *testing.T) {
+	cfg := TWABConfig{MinReportCount: 5, MinDistinctSources: 5, CategoryOverrides: map[string]TWABConfig{
+		"sanctions": {MinReportCount: 1, MinDistinctSources: 1},
+	}}
+	tw := NewTWAB(cfg)
+	sanctioned, phish := evmAddress("sanctioned"), evmAddress("phish")
+	tw.Record(sanctioned, IOCReport{ChainID: 1, Category: "sanctions", Confidence: 1, Timestamp: time.Now(), SourceID: "ofac-feed"})
+	tw.Record(phish, IOCReport{ChainID: 1, Category: "phishing", Confidence: 1, Timestamp: time.Now(), SourceID: "agent-A"})
+
+	ex := tw.Explain(sanctioned, cfg)
+	if !ex.MeetsThreshold || !tw.MeetsThreshold(sanctioned, cfg) || ex.Override != "category_overrides.sanctions" || ex.Category != "sanctions" {
+		t.Errorf("Expected the sanctions override to promote on one source, got %+v", ex)
+	}
+	ex = tw.Explain(phish, cfg)
+	if ex.MeetsThreshold || ex.Override != "" || ex.Gates[0].Threshold != 5 {
+		t.Errorf("Expected an unlisted category to fall through to the base thresholds, got %+v", ex)
+	}
+
+	tw.Record(sanctioned, IOCReport{ChainID: 1, Category: "phishing", Confidence: 1, Timestamp: time.Now(), SourceID: "agent-A"})
+	tw.Record(sanctioned, IOCReport{ChainID: 1, Category: "phishing", Confidence: 1, Timestamp: time.Now(), SourceID: "agent-B"})
+	if ex := tw.Explain(sanctioned, cfg); ex.MeetsThreshold || ex.Category != "phishing" || ex.Override != "" {
+		t.Errorf("Expected the majority category to choose the thresholds, got %+v", ex)
+	}
+}
+
+func TestSourceContributionCap(t *testing.T) {
+	// Each source counts for at most 1 of the 2.5 needed: three must agree.
+	cfg := TWABConfig{MinReportCount: 1, MinDistinctSources: 1, MinWeightedScore: 2.5, MaxSourceContribution: 0.4}
+	tw := NewTWAB(cfg)
+	addr := evmAddress("capped")
+	base := time.Now()
+	for i := 0; i < 5; i++ {
+		for _, source := range []string{"agent-A", "agent-B"} {
+			tw.Record(addr, IOCReport{ChainID: 1, Confidence: 1, Timestamp: base.Add(time.Duration(i) * time.Second), SourceID: source})
+		}
+	}
+	ex := tw.Explain(addr, cfg)
+	if ex.MeetsThreshold || tw.MeetsThreshold(addr, cfg) {
+		t.Fatalf("Expected two capped sources held back however much they report, got %+v", ex)
+	}
+	if g := ex.Gates[len(ex.Gates)-1]; g.Gate != gateWeightedScore || g.Threshold != 2.5 || g.Observed != 2 || g.Passed {
+		t.Errorf("Expected the weighted score gate to fail at 2, got %+v", g)
+	}
+	want := []SourceContribution{{Tier: SourceAnonymous, Raw: 5, Capped: 1}, {Tier: SourceAnonymous, Raw: 5, Capped: 1}}
+	if len(ex.Contributions) != len(want) || ex.Contributions[0] != want[0] || ex.Contributions[1] != want[1] {
+		t.Errorf("Expected raw and capped contributions %+v, got %+v", want, ex.Contributions)
+	}
+
+	tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.5, Timestamp: base.Add(time.Minute), SourceID: "agent-C"})
+	if ex := tw.Explain(addr, cfg); !ex.MeetsThreshold || !tw.MeetsThreshold(addr, cfg) {
+		t.Errorf("Expected a third source to promote, got %+v", ex)
+	}
+
+	if ex := NewTWAB(DefaultTWABConfig()).Explain(addr, DefaultTWABConfig()); ex.Contributions != nil {
+		t.Errorf("Expected no contributions without min_weighted_score, got %+v", ex.Contributions)
+	}
+}
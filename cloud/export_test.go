@@ -0,0 +1,68 @@
+package swarm
+
+import (
+	"context"
+	"sort"
+)
+
+// Hooks into unexported state for the end-to-end tests (the *_e2e_test.go
+// files), which run on aegistest in package swarm_test.
+
+// AutoSizeWarmup is how long the filter grows before it is first resized.
+const AutoSizeWarmup = autoSizeWarmup
+
+// FlushAlerts delivers the queued alerts now rather than on the next
+// interval.
+func (s *SwarmAggregator) FlushAlerts(ctx context.Context) {
+	s.alerts.flush(ctx)
+}
+
+// SourceWeight returns a source's TWAB weight for an address.
+func (s *SwarmAggregator) SourceWeight(address, source string) float64 {
+	shard := s.twab.shardFor(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	return shard.entries[address].Sources[source].Weight
+}
+
+// Calibrate returns the weight a source's raw confidence is given with
+// calibration.min_outcomes set to minOutcomes.
+func (s *SwarmAggregator) Calibrate(source string, confidence float64, minOutcomes int) float64 {
+	cfg := s.current().Calibration
+	cfg.MinOutcomes = minOutcomes
+	return s.calibration.calibrate(source, confidence, cfg)
+}
+
+// PromotionLatencies returns the promotion timeline the SLO is computed
+// from, oldest first.
+func (s *SwarmAggregator) PromotionLatencies() []PromotionLatency {
+	return s.latency.list()
+}
+
+// DropSubscriptions hangs up every global subscription of a key, as a
+// lost connection would, returning how many there were.
+func (s *SwarmAggregator) DropSubscriptions(key string) int {
+	return s.subscribers.unsubscribeKey(key)
+}
+
+// ConfirmedAddresses returns the sorted confirmed set.
+func (s *SwarmAggregator) ConfirmedAddresses() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]string, 0, len(s.confirmed))
+	for address := range s.confirmed {
+		out = append(out, address)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// AlertBurnRate is the kind of a burn-rate alert.
+const AlertBurnRate = alertBurnRate
+
+// RecordingSink collects the alerts it is notified of.
+type RecordingSink = recordingSink
+
+// CalibrationPrior is the pseudo-outcomes at the raw confidence each
+// calibration bin starts from.
+const CalibrationPrior = calibrationPrior
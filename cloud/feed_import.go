@@ -0,0 +1,360 @@
+// Package swarm — External threat feed import.
+//
+// Curated blocklists (OFAC SDN addresses, drainer registries) seed the
+// filter before organic SDK consensus exists.  A feed is imported either
+// in trusted mode, where entries go straight into the confirmed set, or
+// in untrusted mode, where each entry becomes a synthetic TWAB report from
+// the dedicated "feed:<name>" source and must still reach consensus.
+package swarm
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FeedMode selects how imported entries enter the aggregator.
+type FeedMode string
+
+const (
+	FeedTrusted   FeedMode = "trusted"
+	FeedUntrusted FeedMode = "untrusted"
+)
+
+// feedNamePattern restricts feed names to something safe to embed in a
+// SourceID and in logs.
+var feedNamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// maxImportErrors caps the per-row error messages returned in a summary.
+const maxImportErrors = 20
+
+// FeedEntry is a single row of an imported threat feed.
+type FeedEntry struct {
+	Address    string  `json:"address"`
+	ChainID    int     `json:"chain_id"`
+	Category   string  `json:"category"`
+	Confidence float64 `json:"confidence"`
+}
+
+// ImportSummary reports the outcome of a feed import.
+type ImportSummary struct {
+	Feed    string   `json:"feed"`
+	Mode    FeedMode `json:"mode"`
+	Added   int      `json:"added"`
+	Skipped int      `json:"skipped"`
+	Invalid int      `json:"invalid"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+func (sum *ImportSummary) invalidRow(format string, args ...interface{}) {
+	sum.Invalid++
+	if len(sum.Errors) < maxImportErrors {
+		sum.Errors = append(sum.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+// ParseFeed decodes a feed in "csv" or "json" format.  Rows that fail
+// validation are counted in the returned summary rather than aborting the
+// whole import; only an unreadable body is an error.
+func ParseFeed(r io.Reader, format string) ([]FeedEntry, ImportSummary, error) {
+	switch format {
+	case "csv":
+		return parseFeedCSV(r)
+	case "json":
+		return parseFeedJSON(r)
+	default:
+		return nil, ImportSummary{}, fmt.Errorf("unsupported feed format %q", format)
+	}
+}
+
+func parseFeedCSV(r io.Reader) ([]FeedEntry, ImportSummary, error) {
+	var (
+		entries []FeedEntry
+		sum     ImportSummary
+	)
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				sum.invalidRow("line %d: %v", line, err)
+				continue
+			}
+			return nil, sum, err
+		}
+		if line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), "address") {
+			continue // header row
+		}
+		if len(record) < 2 || len(record) > 4 {
+			sum.invalidRow("line %d: expected address,chain_id,category,confidence", line)
+			continue
+		}
+
+		entry := FeedEntry{Address: strings.TrimSpace(record[0]), Confidence: 1.0}
+		chainID, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			sum.invalidRow("line %d: invalid chain_id %q", line, record[1])
+			continue
+		}
+		entry.ChainID = chainID
+		if len(record) > 2 {
+			entry.Category = strings.TrimSpace(record[2])
+		}
+		if len(record) > 3 && strings.TrimSpace(record[3]) != "" {
+			confidence, err := strconv.ParseFloat(strings.TrimSpace(record[3]), 64)
+			if err != nil {
+				sum.invalidRow("line %d: invalid confidence %q", line, record[3])
+				continue
+			}
+			entry.Confidence = confidence
+		}
+		if msg := entry.validate(); msg != "" {
+			sum.invalidRow("line %d: %s", line, msg)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, sum, nil
+}
+
+func parseFeedJSON(r io.Reader) ([]FeedEntry, ImportSummary, error) {
+	var (
+		raw     []json.RawMessage
+		entries []FeedEntry
+		sum     ImportSummary
+	)
+
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, sum, fmt.Errorf("decode feed: %w", err)
+	}
+
+	for i, item := range raw {
+		entry := FeedEntry{Confidence: 1.0}
+		if err := json.Unmarshal(item, &entry); err != nil {
+			sum.invalidRow("item %d: %v", i, err)
+			continue
+		}
+		entry.Address = strings.TrimSpace(entry.Address)
+		if msg := entry.validate(); msg != "" {
+			sum.invalidRow("item %d: %s", i, msg)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, sum, nil
+}
+
+// validate returns a description of what is wrong with the entry, or "".
+// A valid entry's address is keyed as an address indicator on its chain,
+// so a feed cannot import a key reserved for another indicator type.
+func (e *FeedEntry) validate() string {
+	if e.Address == "" {
+		return "missing address"
+	}
+	address, err := IndicatorKey(IndicatorAddress, e.ChainID, e.Address)
+	if err != nil {
+		return err.Error()
+	}
+	e.Address = address
+	if e.Confidence < 0 || e.Confidence > 1 {
+		return fmt.Sprintf("confidence %v out of range [0, 1]", e.Confidence)
+	}
+	return ""
+}
+
+// detectFeedFormat picks "csv" or "json" from a content type or file name,
+// falling back to sniffing the first non-space byte of the body.
+func detectFeedFormat(hint string, body []byte) string {
+	hint = strings.ToLower(hint)
+	switch {
+	case strings.Contains(hint, "json"):
+		return "json"
+	case strings.Contains(hint, "csv"):
+		return "csv"
+	}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		return "json"
+	}
+	return "csv"
+}
+
+// feedSourceID is the synthetic SourceID used for untrusted feed reports.
+func feedSourceID(name string) string {
+	return "feed:" + name
+}
+
+// ImportFeed applies parsed feed entries to the aggregator.
+//
+// Re-importing the same feed is idempotent: an address already imported
+// from this feed (or, in trusted mode, already confirmed or allowlisted)
+// is skipped.
+// Each address a trusted import adds is published as a promoted event,
+// and subscribers receive at most one push per import.
+func (s *SwarmAggregator) ImportFeed(ctx context.Context, name string, mode FeedMode, entries []FeedEntry) (ImportSummary, error) {
+	return s.importFeed(ctx, name, mode, entries, eventReasonImported)
+}
+
+// importFeed is ImportFeed with the reason its promoted events carry.
+func (s *SwarmAggregator) importFeed(ctx context.Context, name string, mode FeedMode, entries []FeedEntry, reason string) (ImportSummary, error) {
+	sum := ImportSummary{Feed: name, Mode: mode}
+	if !feedNamePattern.MatchString(name) {
+		return sum, fmt.Errorf("invalid feed name %q", name)
+	}
+	if mode != FeedTrusted && mode != FeedUntrusted {
+		return sum, fmt.Errorf("invalid feed mode %q", mode)
+	}
+
+	source := feedSourceID(name)
+	now := s.clock.Now()
+	var pending []IOCReport
+	var promoted []Event
+
+	s.mu.Lock()
+	for _, entry := range entries {
+		if s.hasFeedTagLocked(entry.Address, source) {
+			sum.Skipped++
+			continue
+		}
+		tag := Provenance{
+			Source:     source,
+			Mode:       mode,
+			Category:   entry.Category,
+			ImportedAt: now,
+		}
+		s.feedTags[entry.Address] = append(s.feedTags[entry.Address], tag)
+
+		if mode == FeedTrusted {
+			if _, ok := s.confirmed[entry.Address]; ok || s.allowlist[entry.Address] {
+				sum.Skipped++
+				continue
+			}
+			confirmed := &ConfirmedEntry{
+				Address:    entry.Address,
+				ChainID:    entry.ChainID,
+				Category:   entry.Category,
+				Confidence: entry.Confidence,
+				PromotedAt: now,
+				Provenance: tag,
+			}
+			s.confirmed[entry.Address] = confirmed
+			s.scheduleExpiryLocked(confirmed, now)
+			s.filterAddLocked(confirmed)
+			ev := entryEvent(EventPromoted, confirmed)
+			ev.Source, ev.Tier, ev.Reason = source, TierMain, reason
+			promoted = append(promoted, ev)
+		} else {
+			pending = append(pending, IOCReport{
+				Address:    entry.Address,
+				ChainID:    entry.ChainID,
+				Category:   entry.Category,
+				Confidence: entry.Confidence,
+				Timestamp:  now,
+				SourceID:   source,
+			})
+		}
+		sum.Added++
+	}
+	s.mu.Unlock()
+
+	// Untrusted entries go through the normal consensus path.
+	for _, report := range pending {
+		s.IngestReport(ctx, report)
+	}
+
+	s.events.publish(ctx, promoted...)
+	return sum, nil
+}
+
+// hasFeedTagLocked reports whether the address was already imported from
+// the given feed source.  Caller must hold s.mu.
+func (s *SwarmAggregator) hasFeedTagLocked(address, source string) bool {
+	for _, tag := range s.feedTags[address] {
+		if tag.Source == source {
+			return true
+		}
+	}
+	return false
+}
+
+// ImportFeedFile imports a feed from disk, used by the -import-feed flag.
+func (s *SwarmAggregator) ImportFeedFile(ctx context.Context, path, name string, mode FeedMode) (ImportSummary, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return ImportSummary{}, fmt.Errorf("read feed %s: %w", path, err)
+	}
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return s.importBody(ctx, body, filepath.Ext(path), name, mode)
+}
+
+// importBody parses a raw feed body and applies it, merging parse-time
+// invalid rows into the final summary.
+func (s *SwarmAggregator) importBody(ctx context.Context, body []byte, formatHint, name string, mode FeedMode) (ImportSummary, error) {
+	entries, parsed, err := ParseFeed(bytes.NewReader(body), detectFeedFormat(formatHint, body))
+	if err != nil {
+		return parsed, err
+	}
+	sum, err := s.ImportFeed(ctx, name, mode, entries)
+	if err != nil {
+		return sum, err
+	}
+	sum.Invalid += parsed.Invalid
+	sum.Errors = append(parsed.Errors, sum.Errors...)
+	return sum, nil
+}
+
+// handleAdminImport is the HTTP handler for POST /admin/import.
+//
+// Query parameters: name (feed name, required), mode (trusted or
+// untrusted, default untrusted), and reason (for the audit log).  The body is CSV or a JSON array,
+// selected by Content-Type.
+func (s *SwarmAggregator) handleAdminImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	mode := FeedMode(r.URL.Query().Get("mode"))
+	if mode == "" {
+		mode = FeedUntrusted
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, r, err, "Failed to read body")
+		return
+	}
+
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditImport, Subject: name}) {
+		return
+	}
+	sum, err := s.importBody(r.Context(), body, r.Header.Get("Content-Type"), name, mode)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sum)
+}
@@ -0,0 +1,215 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	feedSanctioned = "0x8589427373d6d84e98730d7795d8f6f8731fda16"
+	feedDrainer    = "0x00000000000000000000000000000000000d7a1e"
+)
+
+const sampleFeedCSV = `address,chain_id,category,confidence
+` + feedSanctioned + `,1,sanctions,1.0
+` + feedDrainer + `,1,drainer,0.9
+not-a-row
+0xBadChain,mainnet,drainer,0.9
+0x00000000000000000000000000000000000000bc,1,drainer,1.5
+0x8589427373D6D84E98730D7795D8F6F8731FDA16,1,sanctions,1.0
+`
+
+func TestParseFeedCSVCountsInvalidRows(t *testing.T) {
+	entries, sum, err := ParseFeed(strings.NewReader(sampleFeedCSV), "csv")
+	if err != nil {
+		t.Fatalf("ParseFeed failed: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("Expected 3 valid entries, got %d", len(entries))
+	}
+	if sum.Invalid != 3 {
+		t.Errorf("Expected 3 invalid rows, got %d (%v)", sum.Invalid, sum.Errors)
+	}
+	if entries[1].Category != "drainer" || entries[1].Confidence != 0.9 {
+		t.Errorf("Unexpected entry %+v", entries[1])
+	}
+}
+
+func TestParseFeedRejectsReservedIndicatorKeys(t *testing.T) {
+	for format, body := range map[string]string{
+		"csv":  "address,chain_id,category,confidence\ndomain:x,0,phishing,1.0\nurl:https://x.test/,0,phishing,1.0\n",
+		"json": `[{"address":"domain:x","chain_id":0},{"address":"bytecode_hash:0xab","chain_id":0}]`,
+	} {
+		entries, sum, err := ParseFeed(strings.NewReader(body), format)
+		if err != nil {
+			t.Fatalf("%s: ParseFeed failed: %v", format, err)
+		}
+		if len(entries) != 0 || sum.Invalid != 2 {
+			t.Errorf("%s: expected both reserved keys rejected, got %+v and %+v", format, entries, sum)
+		}
+		for _, msg := range sum.Errors {
+			if !strings.Contains(msg, "reserved indicator type prefix") {
+				t.Errorf("%s: expected the reserved prefix named, got %q", format, msg)
+			}
+		}
+	}
+}
+
+func TestTrustedImportIsIdempotent(t *testing.T) {
+	agg := NewSwarmAggregator()
+	ch := agg.Subscribe("feed-sub")
+	defer agg.Unsubscribe("feed-sub")
+
+	entries, _, _ := ParseFeed(strings.NewReader(sampleFeedCSV), "csv")
+	sum, err := agg.ImportFeed(context.Background(), "ofac", FeedTrusted, entries)
+	if err != nil {
+		t.Fatalf("ImportFeed failed: %v", err)
+	}
+	if sum.Added != 2 || sum.Skipped != 1 {
+		t.Errorf("Expected 2 added and 1 skipped, got %+v", sum)
+	}
+	if agg.BloomFilterLen() != 2 {
+		t.Errorf("Expected 2 filter entries, got %d", agg.BloomFilterLen())
+	}
+
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("Subscriber did not receive push after trusted import")
+	}
+
+	again, err := agg.ImportFeed(context.Background(), "ofac", FeedTrusted, entries)
+	if err != nil {
+		t.Fatalf("Re-import failed: %v", err)
+	}
+	if again.Added != 0 || again.Skipped != 3 {
+		t.Errorf("Expected re-import to skip everything, got %+v", again)
+	}
+	select {
+	case <-ch:
+		t.Error("Idempotent re-import should not push")
+	default:
+	}
+
+	entry, ok := agg.Confirmed(feedSanctioned)
+	if !ok {
+		t.Fatal("Expected the sanctioned address to be confirmed")
+	}
+	if entry.Provenance.Source != "feed:ofac" || entry.Provenance.Mode != FeedTrusted {
+		t.Errorf("Unexpected provenance %+v", entry.Provenance)
+	}
+}
+
+func TestUntrustedImportRequiresConsensus(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{
+		MinReportCount:     2,
+		MinTimeSpanSeconds: 0.0,
+		MinDistinctSources: 2,
+	})
+
+	entries := []FeedEntry{{Address: evmAddress("listed"), ChainID: 1, Category: "drainer", Confidence: 0.8}}
+	sum, err := agg.ImportFeed(context.Background(), "drainer-registry", FeedUntrusted, entries)
+	if err != nil {
+		t.Fatalf("ImportFeed failed: %v", err)
+	}
+	if sum.Added != 1 {
+		t.Errorf("Expected 1 added, got %+v", sum)
+	}
+	if agg.BloomFilterLen() != 0 {
+		t.Fatal("Untrusted feed alone must not promote an address")
+	}
+
+	agg.IngestReport(context.Background(), IOCReport{
+		Address:    evmAddress("listed"),
+		ChainID:    1,
+		Confidence: 0.9,
+		Timestamp:  time.Now(),
+		SourceID:   "agent-A",
+	})
+	if agg.BloomFilterLen() != 1 {
+		t.Fatal("Feed report plus one SDK report should reach consensus")
+	}
+
+	entry, _ := agg.Confirmed(evmAddress("listed"))
+	if entry.Provenance.Source != provenanceConsensus {
+		t.Errorf("Expected consensus provenance, got %+v", entry.Provenance)
+	}
+	tags := agg.FeedTags(evmAddress("listed"))
+	if len(tags) != 1 || tags[0].Source != "feed:drainer-registry" {
+		t.Errorf("Expected feed tag, got %+v", tags)
+	}
+}
+
+func TestAdminImportEndpoint(t *testing.T) {
+	agg := NewSwarmAggregator()
+	agg.keys.Add("admin-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	agg.keys.Add("reporter-secret", APIKey{ID: "sdk", Role: RoleReporter})
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	body := `[{"address":"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed","chain_id":1,"category":"phishing","confidence":1.0},{"chain_id":1}]`
+	post := func(secret string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/admin/import?name=partner&mode=trusted", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+
+	if resp := post(""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without key, got %d", resp.StatusCode)
+	}
+	if resp := post("reporter-secret"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected 403 for reporter key, got %d", resp.StatusCode)
+	}
+
+	resp := post("admin-secret")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	var sum ImportSummary
+	if err := json.NewDecoder(resp.Body).Decode(&sum); err != nil {
+		t.Fatalf("Decode summary: %v", err)
+	}
+	if sum.Added != 1 || sum.Invalid != 1 {
+		t.Errorf("Expected 1 added and 1 invalid, got %+v", sum)
+	}
+
+	checkResp, err := http.Get(srv.URL + "/check?address=0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	defer checkResp.Body.Close()
+	var check struct {
+		Flagged    bool       `json:"flagged"`
+		Provenance Provenance `json:"provenance"`
+	}
+	json.NewDecoder(checkResp.Body).Decode(&check)
+	if !check.Flagged || check.Provenance.Source != "feed:partner" {
+		t.Errorf("Expected flagged with feed:partner provenance, got %+v", check)
+	}
+
+	detailResp, err := http.Get(srv.URL + "/address/0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed")
+	if err != nil {
+		t.Fatalf("Address detail failed: %v", err)
+	}
+	defer detailResp.Body.Close()
+	var detail struct {
+		Confirmed ConfirmedEntry `json:"confirmed"`
+	}
+	json.NewDecoder(detailResp.Body).Decode(&detail)
+	if detail.Confirmed.Provenance.Mode != FeedTrusted || detail.Confirmed.Category != "phishing" {
+		t.Errorf("Unexpected detail %+v", detail.Confirmed)
+	}
+}
@@ -0,0 +1,425 @@
+// Package swarm — Subscriber false-positive feedback.
+//
+// A subscriber whose filter blocks an address it knows to be legitimate
+// disputes it with POST /feedback and a JSON {address, chain_id, reason,
+// evidence} body, using its subscriber or enterprise key; namespaced keys
+// are refused.  Each key disputes an address once, repeats being answered
+// but not counted, and may send feedback.requests_per_hour disputes an
+// hour.
+//
+// Once feedback.threshold distinct keys dispute an address in the main
+// filter it is escalated, once, and a disputed event is published (see
+// events.go).  Under review.policy review, or if an admin or a feed put
+// it in the filter, the address is queued for review flagged promoted
+// (see review.go) and stays in the filter until an analyst decides.
+// Otherwise it is demoted from the filter and cools down for
+// cooldown.disputed (see cooldown.go).  Escalations are audited.
+//
+// GET /address/{addr} shows the open disputes of an address, their keys
+// and reasons to admins only.  GET /admin/disputes lists every address
+// with open disputes.  POST /admin/disputes/{address}/uphold takes the
+// address out of the filter and the review queue; /dismiss keeps it,
+// putting a demoted one back by an admin block.  Both close its disputes
+// and are audited, as is a review decision on it, which closes them
+// too.  Disputes are kept in memory, for at most feedback.max_addresses
+// addresses.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxDisputeReasonLen and maxDisputeEvidenceLen bound the free text of
+	// a dispute, in bytes.
+	maxDisputeReasonLen   = 1024
+	maxDisputeEvidenceLen = 4096
+)
+
+// What escalating an address did.
+const (
+	disputeReview  = "review"
+	disputeDemoted = "demoted"
+)
+
+// Outcomes of POST /feedback recorded in aegis_feedback_total.
+const (
+	feedbackRecorded  = "recorded"
+	feedbackDuplicate = "duplicate"
+	feedbackEscalated = "escalated"
+)
+
+// ErrDisputesFull is returned for a dispute of a new address once
+// feedback.max_addresses addresses carry open disputes.
+var ErrDisputesFull = errors.New("too many disputed addresses")
+
+// Feedback is the body of POST /feedback.
+type Feedback struct {
+	Address  string `json:"address"`
+	ChainID  int    `json:"chain_id,omitempty"`
+	Reason   string `json:"reason"`
+	Evidence string `json:"evidence,omitempty"`
+}
+
+// Dispute is one key's feedback on an address.
+type Dispute struct {
+	Key      string    `json:"key"`
+	Reason   string    `json:"reason"`
+	Evidence string    `json:"evidence,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// DisputeRecord is the open disputes of an address, oldest first.
+// Action is what escalating it did, once EscalatedAt is set.
+type DisputeRecord struct {
+	Address     string     `json:"address"`
+	ChainID     int        `json:"chain_id,omitempty"`
+	Disputes    []Dispute  `json:"disputes"`
+	EscalatedAt *time.Time `json:"escalated_at,omitempty"`
+	Action      string     `json:"action,omitempty"`
+
+	demoted *ConfirmedEntry // the entry taken out of the filter
+}
+
+// DisputeSummary is how GET /address/{addr} shows open disputes to
+// callers other than admins.
+type DisputeSummary struct {
+	Open        int        `json:"open"`
+	Since       time.Time  `json:"since"`
+	EscalatedAt *time.Time `json:"escalated_at,omitempty"`
+	Action      string     `json:"action,omitempty"`
+}
+
+func (d DisputeRecord) summary() DisputeSummary {
+	return DisputeSummary{Open: len(d.Disputes), Since: d.Disputes[0].Time, EscalatedAt: d.EscalatedAt, Action: d.Action}
+}
+
+// disputeTracker holds the open disputes by address.
+type disputeTracker struct {
+	mu      sync.Mutex
+	records map[string]*DisputeRecord
+}
+
+func newDisputeTracker() *disputeTracker {
+	return &disputeTracker{records: make(map[string]*DisputeRecord)}
+}
+
+// add records key's dispute of address, unless it disputed it already.
+// It reports the disputes now open and whether the address is to be
+// escalated: it reached threshold disputes while in the filter, and was
+// not escalated before.  A dispute of an address not yet disputed fails
+// with ErrDisputesFull once max addresses are.
+func (t *disputeTracker) add(address string, chainID int, d Dispute, threshold, max int, inFilter bool) (open int, counted, escalate bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec, ok := t.records[address]
+	if !ok {
+		if len(t.records) >= max {
+			return 0, false, false, ErrDisputesFull
+		}
+		rec = &DisputeRecord{Address: address, ChainID: chainID}
+		t.records[address] = rec
+	}
+	for _, have := range rec.Disputes {
+		if have.Key == d.Key {
+			return len(rec.Disputes), false, false, nil
+		}
+	}
+	rec.Disputes = append(rec.Disputes, d)
+	if inFilter && rec.EscalatedAt == nil && len(rec.Disputes) >= threshold {
+		at := d.Time
+		rec.EscalatedAt = &at
+		escalate = true
+	}
+	return len(rec.Disputes), true, escalate, nil
+}
+
+// escalated records what escalating address did.
+func (t *disputeTracker) escalated(address, action string, demoted *ConfirmedEntry) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rec, ok := t.records[address]; ok {
+		rec.Action, rec.demoted = action, demoted
+	}
+}
+
+// unescalate lets address be escalated again, when escalating it found
+// nothing to do.
+func (t *disputeTracker) unescalate(address string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rec, ok := t.records[address]; ok {
+		rec.EscalatedAt = nil
+	}
+}
+
+// get returns a copy of the open disputes of address.
+func (t *disputeTracker) get(address string) (DisputeRecord, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec, ok := t.records[address]
+	if !ok {
+		return DisputeRecord{}, false
+	}
+	out := *rec
+	out.Disputes = append([]Dispute(nil), rec.Disputes...)
+	return out, true
+}
+
+// list returns every address with open disputes, the longest open first.
+func (t *disputeTracker) list() []DisputeRecord {
+	t.mu.Lock()
+	out := make([]DisputeRecord, 0, len(t.records))
+	for _, rec := range t.records {
+		copied := *rec
+		copied.Disputes = append([]Dispute(nil), rec.Disputes...)
+		out = append(out, copied)
+	}
+	t.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i].Disputes[0].Time, out[j].Disputes[0].Time
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return out[i].Address < out[j].Address
+	})
+	return out
+}
+
+// close drops the disputes of address, returning them.
+func (t *disputeTracker) close(address string) (DisputeRecord, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	rec, ok := t.records[address]
+	if !ok {
+		return DisputeRecord{}, false
+	}
+	delete(t.records, address)
+	return *rec, true
+}
+
+// newFeedbackLimiter allows each API key requests_per_hour disputes an
+// hour, all of them at once after an idle hour.
+func newFeedbackLimiter(cfg FeedbackConfig) *ingestLimiter {
+	return newIngestLimiter(RateLimitConfig{
+		IngestPerSecond: cfg.RequestsPerHour / 3600,
+		IngestBurst:     int(math.Ceil(cfg.RequestsPerHour)),
+	})
+}
+
+// escalateDispute queues for review, or demotes, an address disputed by
+// open keys.
+func (s *SwarmAggregator) escalateDispute(ctx context.Context, address string, open int, now time.Time) bool {
+	entry, ok := s.Confirmed(address)
+	if !ok {
+		s.disputes.unescalate(address)
+		return false
+	}
+	cfg := s.current()
+	action := disputeDemoted
+	var demoted *ConfirmedEntry
+	if entry.Provenance.Source != provenanceConsensus || cfg.Review.policyFor(entry.Category) == PolicyReview {
+		action = disputeReview
+		s.review.offer(ReviewItem{
+			Address:     address,
+			ChainID:     entry.ChainID,
+			Category:    entry.Category,
+			Confidence:  entry.Confidence,
+			UpdatedAt:   now,
+			Explanation: s.twab.Explain(address, cfg.TWAB),
+			Promoted:    true,
+		})
+	} else if demoted = s.demoteDisputed(ctx, address, now); demoted == nil {
+		s.disputes.unescalate(address)
+		return false
+	}
+	s.disputes.escalated(address, action, demoted)
+
+	reason := fmt.Sprintf("disputed by %d keys, %s", open, action)
+	log.Printf("Escalated %s: %s", address, reason)
+	s.auditSystem(AuditEvent{Action: AuditDisputeEscalate, Address: address, Reason: reason, Time: now})
+	ev := entryEvent(EventDisputed, &entry)
+	ev.Time, ev.Reason = now, action
+	s.events.publish(ctx, ev)
+	s.metrics.feedback.WithLabelValues(feedbackEscalated).Inc()
+	return true
+}
+
+// demoteDisputed takes a disputed address out of the confirmed set and
+// the filter and starts its cooldown, returning the entry removed.
+func (s *SwarmAggregator) demoteDisputed(ctx context.Context, address string, now time.Time) *ConfirmedEntry {
+	s.mu.Lock()
+	entry, ok := s.confirmed[address]
+	if !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.confirmed, address)
+	s.filterRemoveLocked(entry)
+	s.mu.Unlock()
+	s.startCooldown(address, cooldownDisputed, now)
+
+	ev := entryEvent(EventRemoved, entry)
+	ev.FromTier, ev.Reason = TierMain, eventReasonDisputed
+	s.events.publish(ctx, ev)
+	return entry
+}
+
+// UpholdDispute closes the disputes of an address, taking it out of the
+// filter and the review queue.  It returns false if it had none.
+func (s *SwarmAggregator) UpholdDispute(ctx context.Context, address string) bool {
+	if _, ok := s.disputes.close(address); !ok {
+		return false
+	}
+	s.review.take(address, time.Time{})
+	s.Unblock(ctx, address)
+	return true
+}
+
+// DismissDispute closes the disputes of an address, keeping it in the
+// filter: a flagged review item is taken off the queue and a demoted
+// address blocked again.  It returns false if it had none.
+func (s *SwarmAggregator) DismissDispute(ctx context.Context, address, reason string) bool {
+	rec, ok := s.disputes.close(address)
+	if !ok {
+		return false
+	}
+	if s.review.flagged(address) {
+		s.review.take(address, s.reviewCooldownUntil(s.clock.Now()))
+	}
+	if rec.demoted != nil {
+		s.Block(ctx, AdminAction{Address: address, ChainID: rec.demoted.ChainID, Category: rec.demoted.Category, Reason: reason})
+	}
+	return true
+}
+
+// handleFeedback is the HTTP handler for POST /feedback.
+func (s *SwarmAggregator) handleFeedback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	key, _ := APIKeyFromContext(r.Context())
+	if key.Namespace != "" {
+		writeError(w, r, http.StatusForbidden, CodeForbidden, "Namespaced keys cannot dispute addresses")
+		return
+	}
+	var fb Feedback
+	if err := json.NewDecoder(r.Body).Decode(&fb); err != nil {
+		writeBodyError(w, r, err, "Invalid JSON")
+		return
+	}
+	if fb.Address == "" {
+		writeError(w, r, http.StatusBadRequest, CodeMissingAddress, "Missing address")
+		return
+	}
+	address, err := NormalizeAddress(fb.ChainID, fb.Address)
+	if err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, CodeInvalidAddress, err.Error())
+		return
+	}
+	fb.Reason = strings.TrimSpace(fb.Reason)
+	switch {
+	case fb.Reason == "":
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, "Missing reason")
+		return
+	case len(fb.Reason) > maxDisputeReasonLen || len(fb.Evidence) > maxDisputeEvidenceLen:
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, fmt.Sprintf("reason and evidence may be at most %d and %d bytes", maxDisputeReasonLen, maxDisputeEvidenceLen))
+		return
+	}
+
+	cfg := s.current().Feedback
+	if !s.disputeLimit.allow(key.Name()) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(3600/cfg.RequestsPerHour))))
+		writeError(w, r, http.StatusTooManyRequests, CodeRateLimited, "Too many disputes")
+		return
+	}
+
+	now := s.clock.Now()
+	_, inFilter := s.Confirmed(address)
+	d := Dispute{Key: key.Name(), Reason: fb.Reason, Evidence: fb.Evidence, Time: now}
+	open, counted, escalate, err := s.disputes.add(address, fb.ChainID, d, cfg.Threshold, cfg.MaxAddresses, inFilter)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	outcome := feedbackRecorded
+	if !counted {
+		outcome = feedbackDuplicate
+	}
+	s.metrics.feedback.WithLabelValues(outcome).Inc()
+	if escalate {
+		escalate = s.escalateDispute(r.Context(), address, open, now)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"address":   address,
+		"in_filter": inFilter,
+		"disputes":  open,
+		"duplicate": !counted,
+		"escalated": escalate,
+	})
+}
+
+// handleAdminDisputes is the HTTP handler for GET /admin/disputes.
+func (s *SwarmAggregator) handleAdminDisputes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"addresses": s.disputes.list()})
+}
+
+// handleAdminDisputeDecision is the HTTP handler for POST
+// /admin/disputes/{address}/uphold and /dismiss.  A chain_id query
+// parameter normalizes the address as on GET /check.
+func (s *SwarmAggregator) handleAdminDisputeDecision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	address, decision, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/disputes/"), "/")
+	if !ok || address == "" || (decision != "uphold" && decision != "dismiss") {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found")
+		return
+	}
+	address, ok = normalizeQueryAddress(w, r, address)
+	if !ok {
+		return
+	}
+	if _, ok := s.disputes.get(address); !ok {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Address has no open disputes")
+		return
+	}
+
+	action := AuditDisputeUphold
+	if decision == "dismiss" {
+		action = AuditDisputeDismiss
+	}
+	if !s.auditAdmin(w, r, AuditEvent{Action: action, Address: address}) {
+		return
+	}
+	if decision == "uphold" {
+		ok = s.UpholdDispute(r.Context(), address)
+	} else {
+		ok = s.DismissDispute(r.Context(), address, r.URL.Query().Get("reason"))
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Address has no open disputes")
+		return
+	}
+	writeAdminResult(w, address, true)
+}
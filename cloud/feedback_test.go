@@ -0,0 +1,203 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/client"
+)
+
+// newFeedbackAggregator returns an audited aggregator escalating on two
+// disputes, with subscriber keys "acme" and "globex" and reporter key
+// "agent".
+func newFeedbackAggregator(t *testing.T, cfg Config) *SwarmAggregator {
+	t.Helper()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Feedback.Threshold = 2
+	agg, _ := newAuditedAggregator(t, cfg)
+	agg.keys.Add("acme-secret", APIKey{ID: "acme", Role: RoleSubscriber})
+	agg.keys.Add("globex-secret", APIKey{ID: "globex", Role: RoleEnterprise})
+	agg.keys.Add("agent-secret", APIKey{ID: "agent", Role: RoleReporter})
+	return agg
+}
+
+type feedbackReply struct {
+	InFilter  bool `json:"in_filter"`
+	Disputes  int  `json:"disputes"`
+	Duplicate bool `json:"duplicate"`
+	Escalated bool `json:"escalated"`
+}
+
+func dispute(t *testing.T, agg *SwarmAggregator, secret, address string) (feedbackReply, int) {
+	t.Helper()
+	body := fmt.Sprintf(`{"address":%q,"chain_id":1,"reason":"our hot wallet","evidence":"https://example.com/wallets"}`, address)
+	rec := adminRequest(t, agg, secret, http.MethodPost, "/feedback", body)
+	var reply feedbackReply
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return reply, rec.Code
+}
+
+func TestDisputesDemoteAfterDistinctKeys(t *testing.T) {
+	agg := newFeedbackAggregator(t, DefaultConfig())
+	addr := evmAddress("hot-wallet")
+	promote(agg, addr, "")
+	sub, _ := agg.events.subscribe("test", eventTypes{EventDisputed: true}, eventResume{})
+
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+	c, err := client.New(client.Config{BaseURL: srv.URL, APIKey: "acme-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Dispute(context.Background(), client.Feedback{Address: addr, ChainID: 1, Reason: "our hot wallet"})
+	if err != nil || !res.InFilter || res.Disputes != 1 || res.Escalated {
+		t.Fatalf("Expected the first dispute recorded, got %+v (%v)", res, err)
+	}
+	if reply, _ := dispute(t, agg, "acme-secret", addr); !reply.Duplicate || reply.Disputes != 1 {
+		t.Errorf("Expected a repeat from the same key not counted, got %+v", reply)
+	}
+	if !agg.bloomFilter.Contains(addr) {
+		t.Fatal("Expected one key's disputes to leave the address in the filter")
+	}
+
+	if reply, _ := dispute(t, agg, "globex-secret", addr); !reply.Escalated || reply.Disputes != 2 {
+		t.Fatalf("Expected the second key's dispute to escalate, got %+v", reply)
+	}
+	if agg.bloomFilter.Contains(addr) {
+		t.Fatal("Expected the disputed address demoted")
+	}
+	if c, ok := agg.cooldowns.active(addr, time.Now()); !ok || c.Reason != cooldownDisputed {
+		t.Errorf("Expected a dispute cooldown, got %+v", c)
+	}
+	promote(agg, addr, "")
+	if agg.bloomFilter.Contains(addr) {
+		t.Error("Expected consensus not to re-promote a demoted address")
+	}
+	select {
+	case ev := <-sub.ch:
+		if ev.Address != addr || ev.Reason != disputeDemoted {
+			t.Errorf("Expected a disputed event for the demotion, got %+v", ev)
+		}
+	default:
+		t.Error("Expected a disputed event")
+	}
+
+	var public struct {
+		Disputes DisputeSummary `json:"disputes"`
+	}
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/address/"+addr, nil))
+	if json.Unmarshal(rec.Body.Bytes(), &public); public.Disputes.Open != 2 || public.Disputes.Action != disputeDemoted {
+		t.Errorf("Expected the open disputes on the address detail, got %s", rec.Body)
+	}
+	var detail struct {
+		Disputes DisputeRecord `json:"disputes"`
+	}
+	rec = adminRequest(t, agg, "ops-secret", http.MethodGet, "/address/"+addr, "")
+	if json.Unmarshal(rec.Body.Bytes(), &detail); len(detail.Disputes.Disputes) != 2 || detail.Disputes.Disputes[1].Key != "globex" {
+		t.Errorf("Expected admins shown each dispute, got %s", rec.Body)
+	}
+
+	if rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/disputes/"+addr+"/dismiss?reason=confirmed+drainer", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Dismiss: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if !agg.bloomFilter.Contains(addr) {
+		t.Error("Expected a dismissed dispute to put the address back")
+	}
+	if _, ok := agg.disputes.get(addr); ok {
+		t.Error("Expected the disputes closed")
+	}
+	if err := agg.audit.Close(); err != nil { // flushes the escalation
+		t.Fatal(err)
+	}
+	reopened, err := OpenAuditLogger(agg.audit.path, agg.metrics.auditEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	agg.audit = reopened
+	for _, action := range []AuditAction{AuditDisputeEscalate, AuditDisputeDismiss} {
+		if page := queryAudit(t, agg, "?action="+string(action)); len(page.Events) != 1 || page.Events[0].Address != addr {
+			t.Errorf("Expected one %s audited, got %+v", action, page.Events)
+		}
+	}
+}
+
+func TestDisputedAdminBlockGoesToReview(t *testing.T) {
+	agg := newFeedbackAggregator(t, DefaultConfig())
+	addr := evmAddress("blocked")
+	agg.Block(context.Background(), AdminAction{Address: addr, ChainID: 1, Reason: "ticket 42"})
+	dispute(t, agg, "acme-secret", addr)
+	if reply, _ := dispute(t, agg, "globex-secret", addr); !reply.Escalated {
+		t.Fatalf("Expected the dispute escalated, got %+v", reply)
+	}
+	if !agg.bloomFilter.Contains(addr) || !agg.review.flagged(addr) {
+		t.Fatal("Expected an admin block kept in the filter and flagged for review")
+	}
+
+	if rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/review/"+addr+"/reject", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Reject: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if agg.bloomFilter.Contains(addr) {
+		t.Error("Expected rejecting the flagged address to remove it")
+	}
+	if _, ok := agg.disputes.get(addr); ok {
+		t.Error("Expected the review decision to close the disputes")
+	}
+}
+
+func TestDisputeOfAddressNotInFilterDoesNotEscalate(t *testing.T) {
+	agg := newFeedbackAggregator(t, DefaultConfig())
+	addr := evmAddress("bloom-false-positive")
+	dispute(t, agg, "acme-secret", addr)
+	if reply, _ := dispute(t, agg, "globex-secret", addr); reply.InFilter || reply.Escalated {
+		t.Errorf("Expected nothing to escalate, got %+v", reply)
+	}
+	if rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/disputes/"+addr+"/uphold", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Uphold: expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/disputes/"+addr+"/uphold", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Upholding twice: expected 404, got %d", rec.Code)
+	}
+}
+
+func TestFeedbackIsValidatedAndRateLimited(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Feedback.RequestsPerHour = 1
+	agg := newFeedbackAggregator(t, cfg)
+	agg.keys.Add("tenant-secret", APIKey{ID: "tenant", Role: RoleSubscriber, Namespace: "acme"})
+
+	for _, tc := range []struct {
+		secret, body string
+		status       int
+	}{
+		{"agent-secret", `{"address":"0x1","reason":"x"}`, http.StatusForbidden},
+		{"tenant-secret", fmt.Sprintf(`{"address":%q,"reason":"x"}`, evmAddress("a")), http.StatusForbidden},
+		{"acme-secret", fmt.Sprintf(`{"address":%q}`, evmAddress("a")), http.StatusBadRequest},
+		{"acme-secret", `{"address":"not-an-address","chain_id":1,"reason":"x"}`, http.StatusUnprocessableEntity},
+	} {
+		if rec := adminRequest(t, agg, tc.secret, http.MethodPost, "/feedback", tc.body); rec.Code != tc.status {
+			t.Errorf("%s %s: expected %d, got %d", tc.secret, tc.body, tc.status, rec.Code)
+		}
+	}
+
+	if _, code := dispute(t, agg, "acme-secret", evmAddress("a")); code != http.StatusOK {
+		t.Fatalf("Expected the first dispute accepted, got %d", code)
+	}
+	rec := adminRequest(t, agg, "acme-secret", http.MethodPost, "/feedback", fmt.Sprintf(`{"address":%q,"reason":"x"}`, evmAddress("b")))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After past the hourly allowance, got %d", rec.Code)
+	}
+	if _, code := dispute(t, agg, "globex-secret", evmAddress("b")); code != http.StatusOK {
+		t.Errorf("Expected another key's allowance untouched, got %d", code)
+	}
+}
@@ -0,0 +1,178 @@
+// Package swarm — Filter size cap.
+//
+// bloom.max_filter_entries caps the confirmed set consensus may grow;
+// zero leaves it unbounded.  A consensus promotion of a new address that
+// finds the set at the cap is handled by bloom.overflow_policy:
+//
+//   - "reject" (the default) leaves the address out and queues it for
+//     review (see review.go), where an analyst can approve it once there
+//     is room;
+//   - "evict" removes the least confident consensus entry, the oldest of
+//     equals, to make room.  Its TWAB history is forgotten as on expiry,
+//     so the next report does not promote it straight back.  With no
+//     consensus entry to evict the promotion is rejected;
+//   - "rebuild" raises the cap to twice the current count and rebuilds
+//     the filter sized for it (see rebuild.go).  The raised cap lasts
+//     until restart.
+//
+// Blocks, feed imports, and promotions replicated from peers are not
+// capped, but count toward it.  Every hit is counted in
+// aegis_filter_cap_hits_total, raised as a high-priority alert, and
+// logged, sampled (see logsample.go); GET /health reports the cap, the policy, and the headroom left.
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// OverflowPolicy says what a promotion past the filter cap does.
+type OverflowPolicy string
+
+const (
+	OverflowReject  OverflowPolicy = "reject"
+	OverflowEvict   OverflowPolicy = "evict"
+	OverflowRebuild OverflowPolicy = "rebuild"
+)
+
+// valid reports whether p is a known policy; empty means reject.
+func (p OverflowPolicy) valid() bool {
+	return p == "" || p == OverflowReject || p == OverflowEvict || p == OverflowRebuild
+}
+
+// FilterCapacity is the filter_cap object of GET /health.
+type FilterCapacity struct {
+	MaxEntries int            `json:"max_entries"`
+	Policy     OverflowPolicy `json:"policy"`
+	Headroom   int            `json:"headroom"`
+}
+
+// capHit describes a promotion that found the confirmed set at the cap.
+type capHit struct {
+	policy   OverflowPolicy
+	limit    int
+	rejected bool
+	evicted  *ConfirmedEntry // copied, with the evict policy
+	raised   int             // new cap, with the rebuild policy
+}
+
+// filterCapLocked returns the cap in force, zero for none.  s.mu must be
+// held.
+func (s *SwarmAggregator) filterCapLocked() int {
+	limit := s.current().Bloom.MaxFilterEntries
+	if limit > 0 && s.raisedCap > limit {
+		return s.raisedCap
+	}
+	return limit
+}
+
+// filterCapacity reports the cap for /health; ok is false without one.
+func (s *SwarmAggregator) filterCapacity() (FilterCapacity, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	limit := s.filterCapLocked()
+	if limit == 0 {
+		return FilterCapacity{}, false
+	}
+	return FilterCapacity{
+		MaxEntries: limit,
+		Policy:     s.overflowPolicy(),
+		Headroom:   max(limit-len(s.confirmed), 0),
+	}, true
+}
+
+func (s *SwarmAggregator) overflowPolicy() OverflowPolicy {
+	if p := s.current().Bloom.OverflowPolicy; p != "" {
+		return p
+	}
+	return OverflowReject
+}
+
+// makeRoomLocked applies the overflow policy before a consensus promotion
+// adds to the confirmed set, returning nil if there is room.  s.mu must be
+// held.
+func (s *SwarmAggregator) makeRoomLocked() *capHit {
+	limit := s.filterCapLocked()
+	if limit == 0 || len(s.confirmed) < limit {
+		return nil
+	}
+	hit := &capHit{policy: s.overflowPolicy(), limit: limit}
+	switch hit.policy {
+	case OverflowEvict:
+		victim := s.evictionVictimLocked()
+		if victim == nil {
+			hit.rejected = true
+			break
+		}
+		delete(s.confirmed, victim.Address)
+		delete(s.feedTags, victim.Address)
+		s.filterRemoveLocked(victim)
+		s.twab.Forget(victim.Address)
+		copied := *victim
+		hit.evicted = &copied
+	case OverflowRebuild:
+		s.raisedCap = len(s.confirmed) * rebuildHeadroom
+		hit.raised = s.raisedCap
+	default:
+		hit.rejected = true
+	}
+	return hit
+}
+
+// evictionVictimLocked returns the least confident consensus entry, the
+// oldest of equals, or nil if there is none.  s.mu must be held.
+func (s *SwarmAggregator) evictionVictimLocked() *ConfirmedEntry {
+	var victim *ConfirmedEntry
+	for _, entry := range s.confirmed {
+		if entry.Provenance.Source != provenanceConsensus {
+			continue
+		}
+		if victim == nil || entry.Confidence < victim.Confidence ||
+			(entry.Confidence == victim.Confidence && entry.PromotedAt.Before(victim.PromotedAt)) {
+			victim = entry
+		}
+	}
+	return victim
+}
+
+// reportCapHit records a cap hit and, with the rebuild policy, rebuilds
+// the filter for the raised cap.  It reports whether the rebuilt filter
+// was pushed.
+func (s *SwarmAggregator) reportCapHit(ctx context.Context, report IOCReport, hit capHit, now time.Time) bool {
+	s.metrics.filterCapHits.WithLabelValues(string(hit.policy)).Inc()
+	s.alerts.enqueue(PromotionEvent{
+		Address:    report.Address,
+		ChainID:    report.ChainID,
+		Category:   report.Category,
+		PromotedAt: now,
+		Kind:       alertFilterCap,
+		Priority:   alertPriorityHigh,
+		MaxEntries: hit.limit,
+		Policy:     string(hit.policy),
+	})
+
+	switch {
+	case hit.rejected:
+		s.logs.Printf(logFilterCap, string(hit.policy), "Filter at its cap of %d entries; queued %s for review", hit.limit, report.Address)
+	case hit.evicted != nil:
+		s.logs.Printf(logFilterCap, string(hit.policy), "Filter at its cap of %d entries; evicted %s for %s", hit.limit, hit.evicted.Address, report.Address)
+		s.auditSystem(AuditEvent{
+			Action:  AuditFilterEvict,
+			Address: hit.evicted.Address,
+			Reason:  fmt.Sprintf("filter cap %d reached promoting %s", hit.limit, report.Address),
+			Time:    now,
+		})
+	case hit.raised > 0:
+		log.Printf("Filter at its cap of %d entries; raising it to %d", hit.limit, hit.raised)
+		params := s.config.Bloom.paramsFor(max(uint(hit.raised), s.config.Bloom.ExpectedItems))
+		if _, err := s.rebuildFilter(ctx, params); err != nil {
+			log.Printf("Failed to rebuild filter for cap %d: %v", hit.raised, err)
+			return false
+		}
+		s.auditSystem(AuditEvent{Action: AuditFilterRebuild, Reason: fmt.Sprintf("filter cap raised from %d to %d", hit.limit, hit.raised)})
+		return true
+	}
+	return false
+}
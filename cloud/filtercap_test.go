@@ -0,0 +1,146 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newCappedAggregator(policy OverflowPolicy) (*SwarmAggregator, *recordingSink) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Bloom.MaxFilterEntries = 2
+	cfg.Bloom.OverflowPolicy = policy
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	sink := &recordingSink{}
+	agg.AddAlertSink(sink)
+	return agg, sink
+}
+
+func promoteSeed(agg *SwarmAggregator, seed string, confidence float64) bool {
+	return agg.IngestReport(context.Background(), IOCReport{
+		Address: evmAddress(seed), ChainID: 1, Category: "drainer",
+		Confidence: confidence, Timestamp: time.Now(), SourceID: "agent-" + seed,
+	})
+}
+
+func filterCapHealth(t *testing.T, agg *SwarmAggregator) FilterCapacity {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	var resp struct {
+		FilterCap *FilterCapacity `json:"filter_cap"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.FilterCap == nil {
+		t.Fatalf("Expected filter_cap in /health, got %s", rec.Body)
+	}
+	return *resp.FilterCap
+}
+
+// expectCapAlert checks the alerts delivered lead with one high-priority
+// cap alert.
+func expectCapAlert(t *testing.T, agg *SwarmAggregator, sink *recordingSink, policy OverflowPolicy) {
+	t.Helper()
+	agg.alerts.flush(context.Background())
+	events := sink.Events()
+	if len(events) == 0 || events[0].Kind != alertFilterCap || events[0].Priority != alertPriorityHigh ||
+		events[0].Policy != string(policy) || events[0].MaxEntries != 2 || events[0].Address != evmAddress("third") {
+		t.Fatalf("Expected a high-priority cap alert first, got %+v", events)
+	}
+	if got := testutil.ToFloat64(agg.metrics.filterCapHits.WithLabelValues(string(policy))); got != 1 {
+		t.Errorf("Expected one cap hit counted, got %v", got)
+	}
+}
+
+func TestFilterCapRejectQueuesForReview(t *testing.T) {
+	agg, sink := newCappedAggregator(OverflowReject)
+	promoteSeed(agg, "first", 0.8)
+	if got := filterCapHealth(t, agg); got != (FilterCapacity{MaxEntries: 2, Policy: OverflowReject, Headroom: 1}) {
+		t.Errorf("Unexpected /health cap %+v", got)
+	}
+	promoteSeed(agg, "second", 0.8)
+	if promoteSeed(agg, "third", 0.8) {
+		t.Fatal("Expected the promotion past the cap rejected")
+	}
+	if agg.BloomFilterLen() != 2 || agg.bloomFilter.Contains(evmAddress("third")) {
+		t.Errorf("Expected the filter left at the cap, got %d entries", agg.BloomFilterLen())
+	}
+	items := agg.review.list()
+	if len(items) != 1 || items[0].Address != evmAddress("third") {
+		t.Errorf("Expected the rejected address queued for review, got %+v", items)
+	}
+	if got := filterCapHealth(t, agg); got.Headroom != 0 {
+		t.Errorf("Expected no headroom, got %+v", got)
+	}
+	expectCapAlert(t, agg, sink, OverflowReject)
+
+	agg.Unblock(context.Background(), evmAddress("first"))
+	if promoted, ok := agg.ApproveReview(context.Background(), evmAddress("third")); !ok || !promoted {
+		t.Errorf("Expected approval to promote once there is room, got %v %v", promoted, ok)
+	}
+}
+
+func TestFilterCapEvictsLeastConfident(t *testing.T) {
+	agg, sink := newCappedAggregator(OverflowEvict)
+	promoteSeed(agg, "first", 0.9)
+	promoteSeed(agg, "second", 0.6)
+	agg.Block(context.Background(), AdminAction{Address: evmAddress("blocked"), ChainID: 1})
+	if agg.BloomFilterLen() != 3 {
+		t.Fatalf("Expected blocks not capped, got %d entries", agg.BloomFilterLen())
+	}
+	if !promoteSeed(agg, "third", 0.8) {
+		t.Fatal("Expected the promotion past the cap to evict")
+	}
+	if agg.bloomFilter.Contains(evmAddress("second")) || !agg.bloomFilter.Contains(evmAddress("first")) ||
+		!agg.bloomFilter.Contains(evmAddress("blocked")) || !agg.bloomFilter.Contains(evmAddress("third")) {
+		t.Errorf("Expected only the least confident consensus entry evicted")
+	}
+	if agg.twab.MeetsThreshold(evmAddress("second"), agg.current().TWAB) {
+		t.Error("Expected the evicted address's reports forgotten")
+	}
+	if got := filterCapHealth(t, agg); got != (FilterCapacity{MaxEntries: 2, Policy: OverflowEvict, Headroom: 0}) {
+		t.Errorf("Unexpected /health cap %+v", got)
+	}
+	expectCapAlert(t, agg, sink, OverflowEvict)
+}
+
+func TestFilterCapRebuildRaisesCap(t *testing.T) {
+	agg, sink := newCappedAggregator(OverflowRebuild)
+	promoteSeed(agg, "first", 0.8)
+	promoteSeed(agg, "second", 0.8)
+	before := agg.bloomFilter.Version()
+	if !promoteSeed(agg, "third", 0.8) {
+		t.Fatal("Expected the promotion past the cap to go ahead")
+	}
+	if agg.BloomFilterLen() != 3 || agg.bloomFilter.Version() <= before {
+		t.Errorf("Expected the filter rebuilt with the new entry, got %d entries at v%d", agg.BloomFilterLen(), agg.bloomFilter.Version())
+	}
+	if got := testutil.ToFloat64(agg.metrics.filterRebuilds); got != 1 {
+		t.Errorf("Expected one rebuild, got %v", got)
+	}
+	if got := filterCapHealth(t, agg); got != (FilterCapacity{MaxEntries: 4, Policy: OverflowRebuild, Headroom: 1}) {
+		t.Errorf("Expected the cap raised, got %+v", got)
+	}
+	expectCapAlert(t, agg, sink, OverflowRebuild)
+}
+
+func TestFilterCapAlertsCoalesce(t *testing.T) {
+	agg, sink := newCappedAggregator(OverflowReject)
+	agg.alerts.config.MaxPerInterval = 1
+	promoteSeed(agg, "first", 0.8)
+	promoteSeed(agg, "second", 0.8)
+	promoteSeed(agg, "third", 0.8)
+	promoteSeed(agg, "fourth", 0.8)
+	agg.alerts.flush(context.Background())
+
+	events := sink.Events()
+	if len(events) != 2 || events[0].Kind != alertFilterCap || events[0].Coalesced != 1 || events[1].Kind != "" {
+		t.Fatalf("Expected one cap alert counting the repeat ahead of the promotions, got %+v", events)
+	}
+}
@@ -0,0 +1,173 @@
+// Package swarm — Consistent filter snapshots.
+//
+// FilterSnapshot captures the global filter under s.mu and a single
+// filter lock, so its version, entry count, entries and the time of its
+// last change always belong together; reading Len and Version one after
+// the other could pair a count with a version it never had.  /health,
+// GET /filter/version, GET /filter and its ETag, BloomFilterLen, and
+// every push and WebSocket snapshot of the global filter read it.
+//
+// The capture is kept until the filter changes, and its signed payload
+// is serialized on first use, once per encoding and signing key, then
+// shared, so repeated downloads and pushes of an unchanged filter are not
+// serialized again.  The exact format carries consensus scores, which
+// move without the filter changing, so it is neither cached nor tagged.
+//
+// GET /filter answers with a weak ETag naming the version, encoding,
+// signing key and payload checksum, and an If-None-Match of the current
+// one with 304 Not Modified.  GET /filter/version reports the global filter's version,
+// count and time of last change without its entries.
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FilterState is the global filter as of one version.  UpdatedAt is when
+// the filter reached Version, zero if it never changed.
+type FilterState struct {
+	Version        uint64
+	LogicalVersion uint64 // with replication (see replication.go)
+	Count          int
+	UpdatedAt      time.Time
+
+	snap filterSnapshot
+
+	mu     sync.Mutex
+	signed map[filterEncoding]FilterEnvelope
+}
+
+// filterEncoding is a signed encoding of a FilterState.
+type filterEncoding struct {
+	payload string // encodingJSON, encodingBinary or encodingMsgpack
+	keyID   string
+}
+
+// Payload encodings of a FilterState, as named in ETags.
+const (
+	encodingJSON    = "json"
+	encodingBinary  = "binary"
+	encodingMsgpack = "msgpack"
+)
+
+// FilterSnapshot captures the global filter, or returns the capture of
+// its current version if there is one.
+func (s *SwarmAggregator) FilterSnapshot() *FilterState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.filterStateLocked()
+}
+
+// filterStateLocked is FilterSnapshot with s.mu held, for reading or
+// writing.
+func (s *SwarmAggregator) filterStateLocked() *FilterState {
+	version := s.bloomFilter.Version()
+	logical := s.logicalVersionLocked(version)
+	if st := s.filterState.Load(); st != nil && st.Version == version && st.LogicalVersion == logical {
+		return st
+	}
+
+	entries, version, params, updated := s.bloomFilter.State()
+	st := &FilterState{
+		Version:        version,
+		LogicalVersion: logical,
+		Count:          len(entries),
+		UpdatedAt:      updated,
+		signed:         make(map[filterEncoding]FilterEnvelope),
+	}
+	st.snap = filterSnapshot{
+		version: version,
+		logical: logical,
+		entries: entries,
+		params:  params,
+		scorer:  s.ConsensusScore,
+		state:   st,
+	}
+	s.filterState.Store(st)
+	return st
+}
+
+// cached reports whether snap is a FilterState's capture, unaltered, so
+// its encodings can be shared.
+func (snap filterSnapshot) cached() bool {
+	return snap.state != nil && !snap.rebuild
+}
+
+// encodedState returns an encoding of st signed by the active key,
+// serializing it with encode on first use.
+func (s *SwarmAggregator) encodedState(st *FilterState, payload string, encode func(filterSnapshot) (FilterEnvelope, error)) (FilterEnvelope, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if env, ok := st.signed[filterEncoding{payload: payload, keyID: s.signer.Active().ID}]; ok {
+		return env, nil
+	}
+	snap := st.snap
+	snap.state = nil
+	env, err := encode(snap)
+	if err != nil {
+		return FilterEnvelope{}, err
+	}
+	st.signed[filterEncoding{payload: payload, keyID: env.KeyID}] = env
+	return env, nil
+}
+
+// filterETag is the ETag of a filter response with env, or empty for the
+// exact format.  It is weak, since compression changes the bytes sent, and
+// is defined over the canonical payload bytes (see the bloom package) by
+// their checksum, with the version and signing key, so every instance
+// serving the same filter tags it alike, and a restart that numbers
+// different entries with an old version does not match.
+func filterETag(env FilterEnvelope, format FilterFormat, encoding string) string {
+	if format == FormatExact {
+		return ""
+	}
+	return fmt.Sprintf(`W/"%d-%s-%s-%s"`, env.Version, encoding, env.KeyID, env.PayloadChecksum)
+}
+
+// etagMatches reports whether an If-None-Match header names etag.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// filterVersionResponse is the body of GET /filter/version.
+type filterVersionResponse struct {
+	Version        uint64     `json:"version"`
+	Instance       string     `json:"instance,omitempty"`
+	LogicalVersion uint64     `json:"logical_version,omitempty"`
+	InstanceUUID   string     `json:"instance_uuid"`
+	Epoch          uint64     `json:"epoch"`
+	Count          int        `json:"count"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+// handleFilterVersion is the HTTP handler for GET /filter/version.
+func (s *SwarmAggregator) handleFilterVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	st := s.FilterSnapshot()
+	identity := s.Identity()
+	resp := filterVersionResponse{Version: st.Version, InstanceUUID: identity.InstanceUUID, Epoch: identity.Epoch, Count: st.Count}
+	if instance := s.config.Replication.InstanceID; instance != "" {
+		resp.Instance, resp.LogicalVersion = instance, st.LogicalVersion
+	}
+	if !st.UpdatedAt.IsZero() {
+		resp.UpdatedAt = &st.UpdatedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(headerFilterVersion, strconv.FormatUint(st.Version, 10))
+	json.NewEncoder(w).Encode(resp)
+}
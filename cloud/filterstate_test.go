@@ -0,0 +1,195 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilterSnapshotConsistentUnderIngest(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Push.ResumeHistory = 10000
+	agg := NewSwarmAggregatorWithConfig(cfg)
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 150; i++ {
+				address := evmAddress(fmt.Sprintf("hammer-%d-%d", w, i))
+				agg.Block(context.Background(), AdminAction{Address: address, ChainID: 1})
+				if i%3 == 0 {
+					agg.Unblock(context.Background(), address)
+				}
+			}
+		}(w)
+	}
+	go func() { wg.Wait(); close(done) }()
+
+	type observed struct {
+		version uint64
+		count   int
+		path    string
+	}
+	var polled []observed
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		for _, path := range []string{"/health", "/filter/version"} {
+			rec := httptest.NewRecorder()
+			agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+			var resp struct {
+				Size    int    `json:"filter_size"`
+				Count   int    `json:"count"`
+				Version uint64 `json:"filter_version"`
+				Current uint64 `json:"version"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+			if path == "/health" {
+				polled = append(polled, observed{resp.Version, resp.Size, path})
+			} else {
+				polled = append(polled, observed{resp.Current, resp.Count, path})
+			}
+		}
+	}
+
+	changes, current, ok := agg.bloomFilter.ChangesSince(0)
+	if !ok || current != 800 {
+		t.Fatalf("Expected the whole history of 800 changes retained, got v%d (%v)", current, ok)
+	}
+	countAt := map[uint64]int{0: 0}
+	present := make(map[string]bool)
+	for _, c := range changes {
+		if c.Removed {
+			delete(present, c.Address)
+		} else {
+			present[c.Address] = true
+		}
+		countAt[c.Version] = len(present)
+	}
+	for _, o := range polled {
+		if want, ok := countAt[o.version]; !ok || want != o.count {
+			t.Fatalf("%s: v%d reported %d entries, the history had %d", o.path, o.version, o.count, want)
+		}
+	}
+	if len(polled) < 2 {
+		t.Errorf("Expected polls while ingesting, got %d", len(polled))
+	}
+}
+
+func TestFilterSnapshotCachedPerVersion(t *testing.T) {
+	agg := NewSwarmAggregator()
+	header := addTestSubscriber(agg)
+	ctx := context.Background()
+	agg.Block(ctx, AdminAction{Address: evmAddress("cached"), ChainID: 1})
+
+	st := agg.FilterSnapshot()
+	if agg.FilterSnapshot() != st || st.Version != 1 || st.Count != 1 || st.UpdatedAt.IsZero() {
+		t.Fatalf("Expected one cached capture of v1, got %+v", st)
+	}
+	first, err := agg.signedFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := agg.signedFilter()
+	if &first.Payload[0] != &second.Payload[0] || len(st.signed) != 1 {
+		t.Error("Expected the signed payload serialized once per version")
+	}
+
+	get := func(etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/filter", nil)
+		req.Header = header.Clone()
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, req)
+		return rec
+	}
+	rec := get("")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("Expected a tagged filter, got %d %q", rec.Code, etag)
+	}
+	if rec := get(etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 || rec.Header().Get(headerFilterVersion) != "1" {
+		t.Errorf("Expected 304 for the current tag, got %d", rec.Code)
+	}
+
+	agg.Block(ctx, AdminAction{Address: evmAddress("newer"), ChainID: 1})
+	if agg.FilterSnapshot() == st {
+		t.Error("Expected a new capture once the filter changed")
+	}
+	if rec := get(etag); rec.Code != http.StatusOK || rec.Header().Get("ETag") == etag {
+		t.Errorf("Expected the changed filter sent with a new tag, got %d %q", rec.Code, rec.Header().Get("ETag"))
+	}
+
+	var resp filterVersionResponse
+	getJSON(t, agg, "/filter/version", &resp)
+	if resp.Version != 2 || resp.Count != 2 || resp.UpdatedAt == nil || time.Since(*resp.UpdatedAt) > time.Minute {
+		t.Errorf("Unexpected /filter/version %+v", resp)
+	}
+}
+
+func TestFilterPayloadCanonicalAcrossInstances(t *testing.T) {
+	ctx := context.Background()
+	labels := []string{"alpha", "bravo", "charlie", "delta"}
+	build := func(order []int) *SwarmAggregator {
+		agg := NewSwarmAggregator()
+		for _, i := range order {
+			agg.Block(ctx, AdminAction{Address: evmAddress(labels[i]), ChainID: 1})
+		}
+		return agg
+	}
+	a, b := build([]int{0, 1, 2, 3}), build([]int{3, 1, 2, 0})
+
+	for name, sign := range map[string]func(*SwarmAggregator) (FilterEnvelope, error){
+		"json":    func(s *SwarmAggregator) (FilterEnvelope, error) { return s.signSnapshot(s.globalSnapshot()) },
+		"binary":  func(s *SwarmAggregator) (FilterEnvelope, error) { return s.signBinary(s.globalSnapshot()) },
+		"msgpack": func(s *SwarmAggregator) (FilterEnvelope, error) { return s.signSnapshotMsgpack(s.globalSnapshot()) },
+	} {
+		envA, errA := sign(a)
+		envB, errB := sign(b)
+		if errA != nil || errB != nil {
+			t.Fatalf("%s: signing failed: %v, %v", name, errA, errB)
+		}
+		if string(envA.Payload) != string(envB.Payload) || envA.PayloadChecksum != envB.PayloadChecksum {
+			t.Errorf("%s: expected the same filter encoded byte for byte alike on both instances", name)
+		}
+	}
+
+	// The aggregator's payloads are the bloom package's canonical ones.
+	env, _ := a.signSnapshot(a.globalSnapshot())
+	if want, _ := a.bloomFilter.Serialize(); string(env.Payload) != string(want) {
+		t.Errorf("Expected the JSON payload to be BloomFilter.Serialize's:\n %s\n %s", env.Payload, want)
+	}
+	bin, _ := a.signBinary(a.globalSnapshot())
+	if want, _ := a.bloomFilter.MarshalBinary(); string(bin.Payload) != string(want) {
+		t.Error("Expected the binary payload to be BloomFilter.MarshalBinary's")
+	}
+
+	// Tags name the payload checksum, so they agree where the bytes do.
+	checksum := func(s *SwarmAggregator) string {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/filter", nil)
+		req.Header = addTestSubscriber(s)
+		s.Routes().ServeHTTP(rec, req)
+		tag := strings.TrimSuffix(rec.Header().Get("ETag"), `"`)
+		return tag[strings.LastIndex(tag, "-")+1:]
+	}
+	if sumA, sumB := checksum(a), checksum(b); sumA != env.PayloadChecksum || sumB != sumA {
+		t.Errorf("Expected both ETags to carry checksum %s, got %q and %q", env.PayloadChecksum, sumA, sumB)
+	}
+}
@@ -0,0 +1,171 @@
+// Package swarm — Idempotent ingest.
+//
+// SDKs retry failed POSTs, and a retry after a timeout may repeat a report
+// the aggregator already counted.  A report can carry an ID, in the
+// Idempotency-Key header of POST /ingest or the report_id field (per item
+// in a batch; the header is ignored there), and the aggregator remembers
+// the response for each ID per namespace and source for
+// ingest.idempotency_window.  A replay gets the original status and result
+// back without being processed again or charged against the rate limit,
+// and is counted in aegis_ingest_replays_total.  A retry arriving while
+// the original is still being processed waits for its outcome.  Rejected
+// reports are not remembered, so a retry after an error is processed
+// afresh.
+//
+// The cache holds at most ingest.idempotency_keys IDs, evicting the least
+// recently used; zero disables it.
+package swarm
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// headerIdempotencyKey carries a single report's ID.
+const headerIdempotencyKey = "Idempotency-Key"
+
+// idempotentEntry is the outcome of one report ID, pending until done is
+// closed.
+type idempotentEntry struct {
+	key    string
+	stored time.Time
+	done   chan struct{}
+
+	// Written by the owner before done is closed; ok is false if the
+	// report was rejected.
+	ok     bool
+	status int
+	result ingestResult
+}
+
+// idempotencyCache is a bounded LRU of report outcomes.
+type idempotencyCache struct {
+	window time.Duration
+	max    int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // key -> element holding *idempotentEntry
+	lru     *list.List               // most recently used first
+}
+
+// newIdempotencyCache returns a cache, or nil if it is disabled.
+func newIdempotencyCache(cfg IngestConfig) *idempotencyCache {
+	if cfg.IdempotencyKeys <= 0 || cfg.IdempotencyWindow <= 0 {
+		return nil
+	}
+	return &idempotencyCache{
+		window:  time.Duration(cfg.IdempotencyWindow),
+		max:     cfg.IdempotencyKeys,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// idempotencyKey scopes a report ID to the report's namespace and source;
+// "" without an ID.
+func idempotencyKey(report IOCReport, id string) string {
+	if id == "" {
+		return ""
+	}
+	return report.Namespace + "\x00" + report.SourceID + "\x00" + id
+}
+
+// claim returns the entry for key.  owner is true if the entry is new and
+// the caller must resolve it with finish or abandon.  A nil cache or an
+// empty key yields a nil entry, which every method accepts.
+func (c *idempotencyCache) claim(key string) (e *idempotentEntry, owner bool) {
+	if c == nil || key == "" {
+		return nil, true
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*idempotentEntry)
+		if now.Sub(e.stored) < c.window {
+			c.lru.MoveToFront(el)
+			return e, false
+		}
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+	e = &idempotentEntry{key: key, stored: now, done: make(chan struct{})}
+	c.entries[key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*idempotentEntry).key)
+	}
+	return e, true
+}
+
+// prune drops outcomes older than the window, least recently used first,
+// stopping at the first one still in it.  It is a maintenance task (see
+// maintenance.go); claim also replaces expired entries it comes across.
+func (c *idempotencyCache) prune(ctx context.Context) TaskStats {
+	var stats TaskStats
+	if c == nil {
+		return stats
+	}
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for el := c.lru.Back(); el != nil && ctx.Err() == nil; el = c.lru.Back() {
+		e := el.Value.(*idempotentEntry)
+		stats.Items++
+		if now.Sub(e.stored) < c.window {
+			break
+		}
+		c.lru.Remove(el)
+		delete(c.entries, e.key)
+	}
+	return stats
+}
+
+// finish records the outcome of an owned entry.
+func (c *idempotencyCache) finish(e *idempotentEntry, status int, result ingestResult) {
+	if e == nil {
+		return
+	}
+	e.ok, e.status, e.result = true, status, result
+	close(e.done)
+}
+
+// abandon forgets an owned entry whose report was rejected.
+func (c *idempotencyCache) abandon(e *idempotentEntry) {
+	if e == nil {
+		return
+	}
+	c.mu.Lock()
+	if el, ok := c.entries[e.key]; ok && el.Value == e {
+		c.lru.Remove(el)
+		delete(c.entries, e.key)
+	}
+	c.mu.Unlock()
+	close(e.done)
+}
+
+// claimIngest claims a report ID for processing.  If it was already seen,
+// it waits for the original's outcome and returns it as original;
+// otherwise it returns the entry the caller owns, nil without an ID.  An
+// error means ctx ended while waiting.
+func (s *SwarmAggregator) claimIngest(ctx context.Context, key string) (owned, original *idempotentEntry, err error) {
+	for {
+		e, owner := s.idempotency.claim(key)
+		if owner {
+			return e, nil, nil
+		}
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		if e.ok {
+			s.metrics.ingestReplays.Inc()
+			return nil, e, nil
+		}
+		// The original was rejected and forgotten; process this one.
+	}
+}
@@ -0,0 +1,117 @@
+package swarm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func postIdempotent(agg *SwarmAggregator, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/ingest?verbose=1", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(headerIdempotencyKey, key)
+	}
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRetriedIngestIsReplayed(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 3, MinDistinctSources: 1}
+	cfg.RateLimit = RateLimitConfig{IngestPerSecond: 0.001, IngestBurst: 2}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	addr := evmAddress("retried")
+	body := fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-A"}`, addr)
+
+	first := postIdempotent(agg, "req-1", body)
+	retry := postIdempotent(agg, "req-1", body)
+	replayed := strings.Replace(first.Body.String(), `"status":"recorded","reason_code":"below_threshold"`, `"status":"duplicate","reason_code":"replayed"`, 1)
+	if first.Code != http.StatusOK || retry.Code != first.Code || retry.Body.String() != replayed {
+		t.Fatalf("Expected the original result replayed as a duplicate, got %d %s then %d %s", first.Code, first.Body, retry.Code, retry.Body)
+	}
+	if sum, _ := agg.twab.Summary(addr); sum.ReportCount != 1 {
+		t.Errorf("Expected the retry not counted, got %d reports", sum.ReportCount)
+	}
+	if n := testutil.ToFloat64(agg.metrics.ingestReplays); n != 1 {
+		t.Errorf("Expected 1 replay, got %v", n)
+	}
+
+	// The replay was not charged: one token remains for a new report.
+	if rec := postIdempotent(agg, "req-2", body); rec.Code != http.StatusOK {
+		t.Errorf("Expected the second token spent on a new report, got %d", rec.Code)
+	}
+	if rec := postIdempotent(agg, "req-3", body); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected 429 once the burst is spent, got %d", rec.Code)
+	}
+	if rec := postIdempotent(agg, "req-1", body); rec.Code != http.StatusOK {
+		t.Errorf("Expected a replay served even when rate limited, got %d", rec.Code)
+	}
+
+	// IDs are scoped to the source, and report_id works like the header.
+	other := fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-B","report_id":"req-1"}`, addr)
+	agg.limiter.configure(RateLimitConfig{})
+	postIdempotent(agg, "", other)
+	postIdempotent(agg, "", other)
+	if sum, _ := agg.twab.Summary(addr); sum.ReportCount != 3 {
+		t.Errorf("Expected one report from agent-B under the same ID, got %d in total", sum.ReportCount)
+	}
+}
+
+func TestRejectedReportIsNotRemembered(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 1})
+	bad := `{"address":"0x1234","chain_id":1,"source_id":"agent-A"}`
+	good := fmt.Sprintf(`{"address":%q,"chain_id":1,"source_id":"agent-A"}`, evmAddress("fixed"))
+	if rec := postIdempotent(agg, "req-1", bad); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected 422, got %d", rec.Code)
+	}
+	if rec := postIdempotent(agg, "req-1", good); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"accepted":true`) {
+		t.Errorf("Expected a retry after an error processed afresh, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestBatchItemsReplayByReportID(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 3, MinDistinctSources: 1})
+	a, b := evmAddress("batch-a"), evmAddress("batch-b")
+	item := func(addr, id string) string {
+		return fmt.Sprintf(`{"address":%q,"chain_id":1,"source_id":"agent-A","report_id":%q}`, addr, id)
+	}
+	postIngest(agg, "/ingest/batch", "["+item(a, "1")+","+item(b, "2")+"]")
+	rec := postIngest(agg, "/ingest/batch", "["+item(a, "1")+","+item(b, "3")+"]")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"accepted":2`) {
+		t.Fatalf("Expected both items answered, got %d %s", rec.Code, rec.Body)
+	}
+	if sum, _ := agg.twab.Summary(a); sum.ReportCount != 1 {
+		t.Errorf("Expected the replayed item not counted, got %d", sum.ReportCount)
+	}
+	if sum, _ := agg.twab.Summary(b); sum.ReportCount != 2 {
+		t.Errorf("Expected the new item counted, got %d", sum.ReportCount)
+	}
+}
+
+func TestIdempotencyCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newIdempotencyCache(IngestConfig{IdempotencyKeys: 2, IdempotencyWindow: Duration(60e9)})
+	for _, key := range []string{"a", "b"} {
+		e, _ := c.claim(key)
+		c.finish(e, http.StatusOK, ingestResult{Accepted: true})
+	}
+	c.claim("a") // touch a, so b is the oldest
+	e, _ := c.claim("c")
+	c.finish(e, http.StatusOK, ingestResult{})
+
+	for _, key := range []string{"a", "c"} {
+		if _, owner := c.claim(key); owner {
+			t.Errorf("Expected %s remembered", key)
+		}
+	}
+	if _, owner := c.claim("b"); !owner {
+		t.Error("Expected b evicted")
+	}
+	if newIdempotencyCache(IngestConfig{}) != nil {
+		t.Error("Expected zero keys to disable the cache")
+	}
+}
@@ -0,0 +1,202 @@
+// Package swarm — Indicator types.
+//
+// Reports carry more than on-chain addresses: a report's indicator_type
+// says what its address field holds.  "address" (the default when it is
+// omitted) is an on-chain address, normalized per chain as before;
+// "domain" is a hostname, mapped through IDNA to lowercase punycode;
+// "url" is an http or https URL, canonicalized; and "bytecode_hash" is
+// the 32-byte hash of contract bytecode, as 0x and lowercase hex.
+//
+// The filter and TWAB are keyed by type and normalized value, as
+// "domain:example.com".  Addresses keep their bare key so address-only
+// clients see no change, and an address may never itself begin with
+// another type's prefix.  Per-type thresholds go in twab.types; /check,
+// /address/ and /explain take a type parameter, and a WebSocket
+// subscription with ?type= is pushed only that type's entries.  Reports
+// ingested as protobuf are always addresses.
+package swarm
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// IndicatorType is the kind of value a report flags.
+type IndicatorType string
+
+const (
+	IndicatorAddress      IndicatorType = "address"
+	IndicatorDomain       IndicatorType = "domain"
+	IndicatorURL          IndicatorType = "url"
+	IndicatorBytecodeHash IndicatorType = "bytecode_hash"
+)
+
+// indicatorTypes is every indicator type.
+var indicatorTypes = []IndicatorType{IndicatorAddress, IndicatorDomain, IndicatorURL, IndicatorBytecodeHash}
+
+// valid reports whether t is a known type; empty is the default address.
+func (t IndicatorType) valid() bool {
+	if t == "" {
+		return true
+	}
+	for _, known := range indicatorTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+// orDefault returns t, or IndicatorAddress for empty.
+func (t IndicatorType) orDefault() IndicatorType {
+	if t == "" {
+		return IndicatorAddress
+	}
+	return t
+}
+
+// IndicatorError reports a value that is not valid for its indicator
+// type.  Invalid addresses are reported as an *AddressError instead.
+type IndicatorError struct {
+	Type   IndicatorType
+	Value  string
+	Reason string
+}
+
+func (e *IndicatorError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %s", e.Type, e.Value, e.Reason)
+}
+
+// IndicatorKey returns the filter and TWAB key of value as an indicator of
+// type t, normalizing it; chainID applies to addresses only.  A value
+// already given as a key is normalized again, so keys map to themselves.
+// Errors are an *AddressError or an *IndicatorError.
+func IndicatorKey(t IndicatorType, chainID int, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if t.orDefault() != IndicatorAddress {
+		value = strings.TrimPrefix(value, string(t)+":")
+	}
+	var normalized, reason string
+	switch t.orDefault() {
+	case IndicatorAddress:
+		address, err := NormalizeAddress(chainID, value)
+		if err != nil {
+			return "", err
+		}
+		if indicatorTypeOf(address) != IndicatorAddress {
+			return "", &AddressError{ChainID: chainID, Address: value, Reason: "reserved indicator type prefix"}
+		}
+		return address, nil
+	case IndicatorDomain:
+		normalized, reason = normalizeDomain(value)
+	case IndicatorURL:
+		normalized, reason = normalizeURL(value)
+	case IndicatorBytecodeHash:
+		normalized, reason = normalizeBytecodeHash(value)
+	default:
+		return "", &IndicatorError{Type: t, Value: value, Reason: "unknown indicator type"}
+	}
+	if reason != "" {
+		return "", &IndicatorError{Type: t, Value: value, Reason: reason}
+	}
+	return string(t) + ":" + normalized, nil
+}
+
+// indicatorTypeOf returns the type of a filter key.
+func indicatorTypeOf(key string) IndicatorType {
+	prefix, _, ok := strings.Cut(key, ":")
+	if !ok {
+		return IndicatorAddress
+	}
+	switch t := IndicatorType(prefix); t {
+	case IndicatorDomain, IndicatorURL, IndicatorBytecodeHash:
+		return t
+	}
+	return IndicatorAddress
+}
+
+// ofType returns the snapshot with only the entries of indicator type t.
+func (snap filterSnapshot) ofType(t IndicatorType) filterSnapshot {
+	entries := make([]string, 0, len(snap.entries))
+	for _, e := range snap.entries {
+		if indicatorTypeOf(e) == t {
+			entries = append(entries, e)
+		}
+	}
+	snap.entries, snap.state = entries, nil
+	return snap
+}
+
+// domainProfile maps hostnames as a resolver would look them up, and
+// checks the DNS length limits.
+var domainProfile = idna.New(idna.MapForLookup(), idna.VerifyDNSLength(true), idna.BidiRule())
+
+func normalizeDomain(value string) (string, string) {
+	ascii, err := domainProfile.ToASCII(strings.TrimSuffix(value, "."))
+	if err != nil {
+		return "", err.Error()
+	}
+	if !strings.Contains(ascii, ".") {
+		return "", "want a dotted hostname"
+	}
+	return ascii, ""
+}
+
+// normalizeURL lowercases the scheme, normalizes the host as a domain or
+// IP, drops user info, a default port and the fragment, and removes dot
+// segments from the path; the query is kept as sent.
+func normalizeURL(value string) (string, string) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", err.Error()
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" {
+		return "", "want an http or https URL"
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		host = ip.String()
+		if ip.To4() == nil {
+			host = "[" + host + "]"
+		}
+	} else {
+		var reason string
+		if host, reason = normalizeDomain(host); reason != "" {
+			return "", reason
+		}
+	}
+	if port := u.Port(); port != "" && !(scheme == "http" && port == "80" || scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	p := u.EscapedPath()
+	if p == "" {
+		p = "/"
+	} else if cleaned := path.Clean(p); strings.HasSuffix(p, "/") && cleaned != "/" {
+		p = cleaned + "/"
+	} else {
+		p = cleaned
+	}
+	out := scheme + "://" + host + p
+	if u.RawQuery != "" {
+		out += "?" + u.RawQuery
+	}
+	return out, ""
+}
+
+func normalizeBytecodeHash(value string) (string, string) {
+	body := strings.TrimPrefix(strings.TrimPrefix(value, "0x"), "0X")
+	if len(body) != 64 {
+		return "", "want 32 bytes of hex"
+	}
+	if _, err := hex.DecodeString(body); err != nil {
+		return "", "want 32 bytes of hex"
+	}
+	return "0x" + strings.ToLower(body), ""
+}
@@ -0,0 +1,120 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIndicatorKey(t *testing.T) {
+	hash := "0xABCDEF0123456789abcdef0123456789ABCDEF0123456789abcdef0123456789"
+	for _, tc := range []struct {
+		typ   IndicatorType
+		chain int
+		value string
+		want  string // "" expects an error
+	}{
+		{"", 1, "0x52908400098527886E0F7030069857D2E4169EE7", "0x52908400098527886e0f7030069857d2e4169ee7"},
+		{IndicatorAddress, 0, "domain:evil.example", ""},
+		{IndicatorDomain, 0, "Drainer.Example.", "domain:drainer.example"},
+		{IndicatorDomain, 0, "bücher.example", "domain:xn--bcher-kva.example"},
+		{IndicatorDomain, 0, "localhost", ""},
+		{IndicatorDomain, 0, "bad domain.example", ""},
+		{IndicatorURL, 0, "HTTPS://user@Claim.Example:443/a/../airdrop/?r=1#x", "url:https://claim.example/airdrop/?r=1"},
+		{IndicatorURL, 0, "http://192.0.2.1:8080", "url:http://192.0.2.1:8080/"},
+		{IndicatorURL, 0, "ftp://claim.example/", ""},
+		{IndicatorBytecodeHash, 1, hash[2:], "bytecode_hash:0xabcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789"},
+		{IndicatorBytecodeHash, 1, "0x1234", ""},
+		{"ens", 1, "vitalik.eth", ""},
+	} {
+		got, err := IndicatorKey(tc.typ, tc.chain, tc.value)
+		if tc.want == "" {
+			if err == nil {
+				t.Errorf("%s %q: expected an error, got %q", tc.typ, tc.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s %q: expected %q, got %q %v", tc.typ, tc.value, tc.want, got, err)
+		}
+		if typ := indicatorTypeOf(got); typ != tc.typ.orDefault() {
+			t.Errorf("%q: expected type %s, got %s", got, tc.typ.orDefault(), typ)
+		}
+	}
+}
+
+func TestDomainIndicatorsPromoteUnderTheirThresholds(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2, Types: map[IndicatorType]TWABConfig{
+		IndicatorDomain: {MinReportCount: 3, MinDistinctSources: 3},
+	}}
+	cfg.Ingest.Synchronous = true
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	ctx := context.Background()
+	report := func(typ IndicatorType, value, source string) {
+		if _, err := agg.SubmitReport(ctx, IOCReport{IndicatorType: typ, Address: value, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: source}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := evmAddress("typed")
+	report("", addr, "agent-A")
+	report("", addr, "agent-B")
+	report(IndicatorDomain, "Drainer.example", "agent-A")
+	report(IndicatorDomain, "drainer.EXAMPLE.", "agent-B")
+	if !agg.bloomFilter.Contains(addr) || agg.bloomFilter.Contains("domain:drainer.example") {
+		t.Fatal("Expected the address promoted and the domain held to its own threshold")
+	}
+	report(IndicatorDomain, "drainer.example", "agent-C")
+	if !agg.bloomFilter.Contains("domain:drainer.example") {
+		t.Fatal("Expected the domain promoted at its threshold")
+	}
+
+	check := func(query string) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?"+query, nil))
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("GET /check?%s: %d %v", query, rec.Code, resp)
+		}
+		return resp
+	}
+	if resp := check("type=domain&address=DRAINER.example"); resp["flagged"] != true || resp["address"] != "domain:drainer.example" || resp["indicator_type"] != "domain" {
+		t.Errorf("Expected the domain flagged, got %v", resp)
+	}
+	if resp := check("address=" + addr + "&chain_id=1"); resp["flagged"] != true || resp["indicator_type"] != "address" {
+		t.Errorf("Expected an untyped check of the address unchanged, got %v", resp)
+	}
+
+	rec := postIngest(agg, "/ingest", `{"indicator_type":"url","address":"ftp://drainer.example","chain_id":1,"confidence":0.9,"source_id":"agent-A"}`)
+	var body ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusUnprocessableEntity || body.Error.Code != CodeInvalidIndicator {
+		t.Errorf("Expected 422 %s for a bad URL, got %d %+v", CodeInvalidIndicator, rec.Code, body)
+	}
+}
+
+func TestTypedSubscriptionGetsOnlyItsType(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	ch := agg.SubscribeWithOptions("domains", SubscribeOptions{Format: FormatExact, IndicatorType: IndicatorDomain})
+	defer agg.Unsubscribe("domains")
+	all := agg.SubscribeWithOptions("all", SubscribeOptions{Format: FormatExact})
+	defer agg.Unsubscribe("all")
+
+	ctx := context.Background()
+	agg.SubmitReport(ctx, IOCReport{Address: evmAddress("untyped"), ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"})
+	recvEnvelope(t, ch)
+	recvEnvelope(t, all)
+	agg.SubmitReport(ctx, IOCReport{IndicatorType: IndicatorDomain, Address: "claim.example", Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"})
+
+	env := recvEnvelope(t, ch)
+	if _, entries := decodeExact(t, env.Payload); len(entries) != 1 || entries[0] != "domain:claim.example" || env.Summary != nil {
+		t.Errorf("Expected only the domain and no summary, got %v %+v", entries, env.Summary)
+	}
+	if _, entries := decodeExact(t, recvEnvelope(t, all).Payload); len(entries) != 2 {
+		t.Errorf("Expected an untyped subscription to get every entry, got %v", entries)
+	}
+}
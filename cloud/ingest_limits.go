@@ -0,0 +1,79 @@
+// Package swarm — Ingest input limits.
+//
+// Report bodies come from anyone holding (or not needing) a reporter key,
+// so nothing about them is trusted.  The body of POST /ingest is capped at
+// ingest.max_body_bytes and that of /ingest/batch at
+// ingest.max_batch_body_bytes; a larger body is answered with 413 before
+// it is decoded in full, so memory per request is bounded whatever the
+// encoding.  A body that does not decode is a 400.  Other routes have
+// body limits of their own (see limits.go).
+//
+// Decoded reports are then sanitized (see sanitize.go) and held to field
+// limits before anything is normalized or recorded: addresses, source
+// IDs (once an over-long one is hashed), categories, selectors
+// and report IDs (or Idempotency-Key headers) have maximum lengths, and
+// confidence must be a finite number in [0, 1], since an infinite or NaN confidence would poison the
+// TWAB sums behind every score of the address.  A report breaking a limit
+// is rejected with 422 and a *ValidationError.  Evidence has its own limits
+// (see evidence.go).
+package swarm
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+)
+
+// Report field limits, in bytes.
+const (
+	maxAddressLen  = 128
+	maxURLLen      = 2048 // the address of a url indicator
+	maxSourceIDLen = 128
+	maxCategoryLen = 64
+	maxSelectorLen = 128
+	maxReportIDLen = 128
+)
+
+// checkReportFields applies the field limits to a report.
+func checkReportFields(report IOCReport) error {
+	addressLen := maxAddressLen
+	if report.IndicatorType == IndicatorURL {
+		addressLen = maxURLLen
+	}
+	for _, f := range []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"address", report.Address, addressLen},
+		{"source_id", report.SourceID, maxSourceIDLen},
+		{"category", report.Category, maxCategoryLen},
+		{"selector", report.Selector, maxSelectorLen},
+		{"report_id", report.ReportID, maxReportIDLen},
+	} {
+		if len(f.value) > f.max {
+			return &ValidationError{Field: f.name, Reason: fmt.Sprintf("%d bytes, at most %d allowed", len(f.value), f.max)}
+		}
+	}
+	if c := report.Confidence; math.IsNaN(c) || c < 0 || c > 1 {
+		return &ValidationError{Field: "confidence", Reason: fmt.Sprintf("%v is not between 0 and 1", c)}
+	}
+	if report.Severity < 0 || report.Severity > maxReportSeverity {
+		return &ValidationError{Field: "severity", Reason: fmt.Sprintf("%d is not between 1 and %d", report.Severity, maxReportSeverity)}
+	}
+	return nil
+}
+
+// limitBody caps the request body at limit bytes; zero leaves it
+// unbounded.
+func limitBody(w http.ResponseWriter, r *http.Request, limit int64) {
+	if limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
+	}
+}
+
+// writeDecodeError answers a report body that failed to decode: 413 if it
+// was cut off at the size limit, 400 otherwise (see writeBodyError).
+func writeDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	writeBodyError(w, r, err, "Invalid report body")
+}
@@ -0,0 +1,157 @@
+package swarm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+)
+
+func newLimitedAggregator(maxBody, maxBatchBody int64) *SwarmAggregator {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Ingest.Synchronous = true
+	cfg.Ingest.MaxBodyBytes, cfg.Ingest.MaxBatchBodyBytes = maxBody, maxBatchBody
+	return NewSwarmAggregatorWithConfig(cfg)
+}
+
+func TestIngestBodyLimits(t *testing.T) {
+	agg := newLimitedAggregator(256, 1024)
+	report := fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-A"}`, evmAddress("limit"))
+	padded := report[:len(report)-1] + `,"category":"` + strings.Repeat("x", 300) + `"}`
+
+	for _, tc := range []struct {
+		path, body string
+		want       int
+		code       ErrorCode
+	}{
+		{"/ingest", report, http.StatusOK, ""},
+		{"/ingest", padded, http.StatusRequestEntityTooLarge, CodePayloadTooLarge},
+		{"/ingest/batch", "[" + report + "," + report + "]", http.StatusOK, ""},
+		{"/ingest/batch", "[" + strings.Repeat(report+",", 20) + report + "]", http.StatusRequestEntityTooLarge, CodePayloadTooLarge},
+		{"/ingest", `{"address":`, http.StatusBadRequest, CodeInvalidBody},
+	} {
+		rec := postIngest(agg, tc.path, tc.body)
+		if rec.Code != tc.want {
+			t.Errorf("%s %d bytes: expected %d, got %d %s", tc.path, len(tc.body), tc.want, rec.Code, rec.Body)
+			continue
+		}
+		if tc.code != "" {
+			var body ErrorResponse
+			json.NewDecoder(rec.Body).Decode(&body)
+			if body.Error.Code != tc.code {
+				t.Errorf("%s: expected code %s, got %+v", tc.path, tc.code, body)
+			}
+		}
+	}
+}
+
+func TestReportFieldLimits(t *testing.T) {
+	agg := newLimitedAggregator(0, 0)
+	valid := IOCReport{Address: evmAddress("fields"), ChainID: 1, Confidence: 0.5, SourceID: "agent-A"}
+	for name, mutate := range map[string]func(*IOCReport){
+		"confidence NaN":      func(r *IOCReport) { r.Confidence = math.NaN() },
+		"confidence negative": func(r *IOCReport) { r.Confidence = -0.1 },
+		"confidence above 1":  func(r *IOCReport) { r.Confidence = 1e308 },
+		"long category":       func(r *IOCReport) { r.Category = strings.Repeat("c", maxCategoryLen+1) },
+		"long address":        func(r *IOCReport) { r.ChainID, r.Address = 0, strings.Repeat("a", maxAddressLen+1) },
+	} {
+		report := valid
+		mutate(&report)
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(mustProto(t, reportToProto(report))))
+		req.Header.Set("Content-Type", contentTypeProtobuf)
+		req.Header.Set("Accept", contentTypeJSON)
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, req)
+		var body ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusUnprocessableEntity || body.Error.Code != CodeInvalidReport {
+			t.Errorf("%s: expected 422 %s, got %d %+v", name, CodeInvalidReport, rec.Code, body)
+		}
+	}
+
+	rec := postIngest(agg, "/ingest", `{"address":"`+valid.Address+`","chain_id":1,"confidence":0.5,"report_id":"`+strings.Repeat("r", maxReportIDLen+1)+`"}`)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("long report ID: expected 422, got %d %s", rec.Code, rec.Body)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(`{"address":"`+valid.Address+`","chain_id":1,"confidence":0.5}`))
+	req.Header.Set(headerIdempotencyKey, strings.Repeat("k", maxReportIDLen+1))
+	rec = httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("long Idempotency-Key: expected 422, got %d %s", rec.Code, rec.Body)
+	}
+
+	if got := agg.twab.Explain(valid.Address, agg.current().TWAB); got.Tracked {
+		t.Errorf("Expected no rejected report recorded, got %+v", got)
+	}
+}
+
+func mustProto(t testing.TB, m proto.Message) []byte {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// ingestStatuses are the answers the ingest handlers may give to any body.
+var ingestStatuses = map[int]bool{
+	http.StatusOK:                    true,
+	http.StatusAccepted:              true,
+	http.StatusBadRequest:            true,
+	http.StatusForbidden:             true,
+	http.StatusRequestEntityTooLarge: true,
+	http.StatusUnprocessableEntity:   true,
+	http.StatusTooManyRequests:       true,
+	http.StatusServiceUnavailable:    true,
+}
+
+// FuzzHandleIngest feeds arbitrary bodies through the ingest routes: they
+// must never panic, must answer with a client error rather than a server
+// one, and must be cut off at the body limit.
+func FuzzHandleIngest(f *testing.F) {
+	const maxBody, maxBatchBody = 4 << 10, 16 << 10
+	agg := newLimitedAggregator(maxBody, maxBatchBody)
+
+	report := IOCReport{Address: evmAddress("seed"), ChainID: 1, Confidence: 0.9, SourceID: "agent-A", Category: "phishing"}
+	jsonReport, _ := json.Marshal(report)
+	f.Add(jsonReport, false, false)
+	f.Add([]byte("["+string(jsonReport)+"]"), false, true)
+	f.Add(mustProto(f, reportToProto(report)), true, false)
+	f.Add(encodeBatchProto(f, []IOCReport{report, report}), true, true)
+	f.Add([]byte(`{"address":"0x1","chain_id":0,"confidence":-1e309}`), false, false)
+
+	f.Fuzz(func(t *testing.T, body []byte, protobuf, batch bool) {
+		path, limit := "/ingest", int64(maxBody)
+		if batch {
+			path, limit = "/ingest/batch", maxBatchBody
+		}
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+		if protobuf {
+			req.Header.Set("Content-Type", contentTypeProtobuf)
+		}
+		req.Header.Set("Accept", contentTypeJSON)
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, req)
+
+		if !ingestStatuses[rec.Code] {
+			t.Fatalf("Unexpected status %d for %q: %s", rec.Code, body, rec.Body)
+		}
+		if oversize := int64(len(body)) > limit; protobuf && oversize != (rec.Code == http.StatusRequestEntityTooLarge) {
+			t.Fatalf("%d byte body against a %d byte limit answered %d", len(body), limit, rec.Code)
+		}
+		if rec.Code == http.StatusRequestEntityTooLarge && int64(len(body)) <= limit {
+			t.Fatalf("%d byte body within the limit answered 413", len(body))
+		}
+		if !json.Valid(rec.Body.Bytes()) {
+			t.Fatalf("Response is not JSON: %q", rec.Body)
+		}
+	})
+}
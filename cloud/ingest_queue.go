@@ -0,0 +1,164 @@
+// Package swarm — Asynchronous ingest queue.
+//
+// A promotion serializes and signs the whole filter, and done inline it
+// shows up as a latency spike for whichever reporter triggered it.  With
+// the queue started, the ingest handlers only validate, check the source
+// quota, and enqueue, answering 202 with an ingest ID; a pool of workers
+// feeds the queue into IngestReport.  A full queue sheds load with 429
+// rather than blocking the handler.  Until StartIngestQueue is called (or
+// with ingest.synchronous) reports are processed in the handler.
+package swarm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrQueueFull is returned for a report the ingest queue has no room for.
+var ErrQueueFull = errors.New("ingest queue is full")
+
+// ingestJob is one queued report.  span is the handler's span, so the
+// worker's IngestReport joins the request's trace.
+type ingestJob struct {
+	id     string
+	report IOCReport
+	span   trace.SpanContext
+}
+
+type ingestQueue struct {
+	jobs    chan ingestJob
+	workers int
+	busy    atomic.Int64
+	done    chan struct{} // closed once every worker has exited
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// StartIngestQueue starts the ingest workers.  Call it before serving;
+// DrainIngestQueue stops them.
+func (s *SwarmAggregator) StartIngestQueue() {
+	cfg := s.config.Ingest
+	q := &ingestQueue{
+		jobs:    make(chan ingestJob, cfg.QueueSize),
+		workers: cfg.Workers,
+		done:    make(chan struct{}),
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range q.jobs {
+				q.busy.Add(1)
+				s.IngestReport(trace.ContextWithSpanContext(context.Background(), job.span), job.report)
+				q.busy.Add(-1)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(q.done)
+	}()
+	s.ingest = q
+}
+
+// DrainIngestQueue stops accepting reports and waits for the queued ones
+// to be processed, or for ctx to be done.
+func (s *SwarmAggregator) DrainIngestQueue(ctx context.Context) error {
+	q := s.ingest
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// enqueue adds a job without blocking, reporting whether there was room.
+func (q *ingestQueue) enqueue(job ingestJob) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+// acceptReport applies the source quota and then processes the report
+// inline or queues it.  Errors are a *BanError, an *AddressError, a
+// *ChainError, a *TimestampSkewError, or ErrQueueFull.
+func (s *SwarmAggregator) acceptReport(ctx context.Context, report IOCReport) (ingestResult, error) {
+	if err := ctx.Err(); err != nil {
+		return ingestResult{}, err // not charged against the quota
+	}
+	if err := s.admitReport(ctx, &report); err != nil {
+		return ingestResult{}, err
+	}
+	if s.ingest == nil {
+		return s.recordedResult(report, s.IngestReport(ctx, report)), nil
+	}
+	job := ingestJob{id: uuid.NewString(), report: report, span: trace.SpanContextFromContext(ctx)}
+	if !s.ingest.enqueue(job) {
+		s.metrics.ingestShed.Inc()
+		return ingestResult{}, ErrQueueFull
+	}
+	res := s.recordedResult(report, false)
+	res.ReasonCode, res.IngestID = reasonQueued, job.id
+	return res, nil
+}
+
+// recordedResult is the result of an admitted, normalized report; added
+// reports whether its address is in the filter.
+func (s *SwarmAggregator) recordedResult(report IOCReport, added bool) ingestResult {
+	explanation := s.explain(report.Namespace, report.Address)
+	filter := s.bloomFilter
+	if report.Namespace != "" {
+		filter = s.namespace(report.Namespace).filter
+	}
+	res := ingestResult{
+		Status:         IngestRecorded,
+		ReasonCode:     reasonBelowThreshold,
+		Accepted:       true,
+		AddedToFilter:  added,
+		ConsensusScore: explanation.ConsensusScore,
+		FilterVersion:  filter.Version(),
+	}
+	switch {
+	case added:
+		res.ReasonCode = reasonInFilter
+	case explanation.MeetsThreshold:
+		res.ReasonCode = reasonWithheld
+	}
+	return res
+}
+
+// ingestStatus is the success status for ingest responses: 202 when
+// reports are queued rather than processed.
+func (s *SwarmAggregator) ingestStatus() int {
+	if s.ingest != nil {
+		return http.StatusAccepted
+	}
+	return http.StatusOK
+}
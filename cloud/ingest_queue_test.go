@@ -0,0 +1,184 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func newAsyncAggregator(queueSize, workers int) *SwarmAggregator {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Ingest = IngestConfig{QueueSize: queueSize, Workers: workers}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.StartIngestQueue()
+	return agg
+}
+
+func postIngest(agg *SwarmAggregator, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAsyncIngestAcceptsThenProcesses(t *testing.T) {
+	agg := newAsyncAggregator(16, 2)
+
+	queued := evmAddress("queued")
+	rec := postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":1.0,"source_id":"agent-A"}`, queued))
+	var res ingestResult
+	json.NewDecoder(rec.Body).Decode(&res)
+	if rec.Code != http.StatusAccepted || !res.Accepted || res.IngestID == "" {
+		t.Fatalf("Expected 202 with an ingest ID, got %d %+v", rec.Code, res)
+	}
+
+	if err := agg.DrainIngestQueue(context.Background()); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if !agg.bloomFilter.Contains(queued) {
+		t.Error("Queued report was not processed before drain returned")
+	}
+	if rec := postIngest(agg, "/ingest", `{"address":"0xLate","source_id":"agent-A"}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a drained queue to refuse reports, got %d", rec.Code)
+	}
+}
+
+func TestAsyncIngestShedsLoadWhenFull(t *testing.T) {
+	agg := newAsyncAggregator(2, 0) // no workers: the queue only fills
+
+	for i := 0; i < 2; i++ {
+		if rec := postIngest(agg, "/ingest", fmt.Sprintf(`{"address":"0x%d","source_id":"agent-A"}`, i)); rec.Code != http.StatusAccepted {
+			t.Fatalf("Report %d: expected 202, got %d", i, rec.Code)
+		}
+	}
+	rec := postIngest(agg, "/ingest", `{"address":"0xShed","source_id":"agent-A"}`)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected 429 with Retry-After, got %d", rec.Code)
+	}
+	if got := testutil.ToFloat64(agg.metrics.ingestShed); got != 1 {
+		t.Errorf("Expected 1 shed report, got %v", got)
+	}
+	if rec := postIngest(agg, "/ingest/batch", `[{"address":"0xB1"}]`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected a fully shed batch to get 429, got %d", rec.Code)
+	}
+}
+
+// blockPromotions holds every promotion until release is closed, as a
+// slow push to a large filter would, signalling entered once one starts.
+func blockPromotions(agg *SwarmAggregator) (entered <-chan struct{}, release chan struct{}) {
+	in, release := make(chan struct{}, 1), make(chan struct{})
+	agg.events.handle(func(context.Context, []Event) {
+		select {
+		case in <- struct{}{}:
+		default:
+		}
+		<-release
+	}, EventPromoted)
+	return in, release
+}
+
+func TestInlineIngestWaitsForPromotion(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	entered, release := blockPromotions(agg)
+
+	done := make(chan int)
+	go func() {
+		done <- postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":1.0,"source_id":"agent-A"}`, evmAddress("inline"))).Code
+	}()
+	<-entered
+	select {
+	case code := <-done:
+		t.Fatalf("Expected the handler to wait for the promotion, got %d", code)
+	default:
+	}
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("Expected 200 once promoted, got %d", code)
+	}
+}
+
+func TestQueuedIngestAnswersDuringPromotion(t *testing.T) {
+	agg := newAsyncAggregator(4, 1)
+	entered, release := blockPromotions(agg)
+
+	first, second := evmAddress("first"), evmAddress("second")
+	postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":1.0,"source_id":"agent-A"}`, first))
+	<-entered // the only worker is stuck promoting the first report
+
+	rec := postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":1.0,"source_id":"agent-A"}`, second))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("Expected 202 while the worker is promoting, got %d", rec.Code)
+	}
+	if agg.bloomFilter.Contains(second) {
+		t.Error("Expected the second report still queued")
+	}
+
+	close(release)
+	if err := agg.DrainIngestQueue(context.Background()); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if !agg.bloomFilter.Contains(first) || !agg.bloomFilter.Contains(second) {
+		t.Error("Expected both reports promoted once the worker was released")
+	}
+}
+
+// BenchmarkIngestDuringPromotionStorm compares handler latency inline and
+// queued when every report promotes and pushes a large filter to a
+// subscriber, reporting the p99 alongside the mean.
+func BenchmarkIngestDuringPromotionStorm(b *testing.B) {
+	const preloaded = 10000
+	run := func(b *testing.B, agg *SwarmAggregator) {
+		for i := 0; i < preloaded; i++ {
+			agg.bloomFilter.Add(fmt.Sprintf("0xPre%05d", i))
+		}
+		sub := agg.Subscribe("load")
+		go func() {
+			for range sub {
+			}
+		}()
+		defer agg.Unsubscribe("load")
+
+		h := agg.Routes()
+		latencies := make([]time.Duration, b.N)
+		b.ResetTimer()
+		for i := range latencies {
+			body := fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":1.0,"source_id":"agent-A"}`, evmAddress(fmt.Sprint("storm", i)))
+			req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+			start := time.Now()
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			latencies[i] = time.Since(start)
+		}
+		b.StopTimer()
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		b.ReportMetric(float64(latencies[b.N*99/100].Nanoseconds()), "p99-ns")
+		agg.DrainIngestQueue(context.Background())
+	}
+
+	b.Run("inline", func(b *testing.B) {
+		run(b, newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1}))
+	})
+	b.Run("queued", func(b *testing.B) {
+		run(b, newAsyncAggregator(b.N+1, 2))
+	})
+}
+
+func BenchmarkIngestHandlerQueued(b *testing.B) {
+	agg := newAsyncAggregator(b.N+1, 4)
+	h := agg.Routes()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body := fmt.Sprintf(`{"address":"0xBench%d","source_id":"agent-A"}`, i)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body)))
+	}
+	b.StopTimer()
+	agg.DrainIngestQueue(context.Background())
+}
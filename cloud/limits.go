@@ -0,0 +1,131 @@
+// Package swarm — Request body limits and slow clients.
+//
+// Every request body is capped before a handler reads it: POST /ingest at
+// ingest.max_body_bytes, /ingest/batch at ingest.max_batch_body_bytes,
+// the bulk admin and peer endpoints (feed import, merge, snapshot import,
+// replication) at limits.max_import_body_bytes, and every other route at
+// limits.max_body_bytes.  A body past its cap is answered with 413
+// payload_too_large, whatever the handler was decoding; zero leaves a
+// route unbounded.  A gzip body is also held to
+// compression.max_decompressed_bytes once inflated (see compression.go).
+//
+// Each server reads request headers within limits.read_header_timeout
+// and the whole request within limits.read_timeout, so a client
+// trickling bytes cannot hold a connection; a body cut off by the read
+// timeout is answered 408 body_timeout where a response can still be
+// written.  It covers reading the request only, so WebSockets and long
+// polls outlive it.  Keep-alive connections are closed after
+// limits.idle_timeout.
+//
+// GET /limits, served on every listener, reports all of these so client
+// SDKs can size their batches.
+package swarm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// LimitsConfig bounds request bodies and how long clients may take to
+// send them.  Zero disables a limit.
+type LimitsConfig struct {
+	MaxBodyBytes       int64    `json:"max_body_bytes" yaml:"max_body_bytes"`
+	MaxImportBodyBytes int64    `json:"max_import_body_bytes" yaml:"max_import_body_bytes"`
+	ReadHeaderTimeout  Duration `json:"read_header_timeout" yaml:"read_header_timeout"`
+	ReadTimeout        Duration `json:"read_timeout" yaml:"read_timeout"`
+	IdleTimeout        Duration `json:"idle_timeout" yaml:"idle_timeout"`
+}
+
+// importPaths are the routes capped at limits.max_import_body_bytes.
+var importPaths = map[string]bool{
+	"/admin/import":          true,
+	"/admin/merge":           true,
+	"/admin/snapshot/import": true,
+	replicationPath:          true,
+}
+
+// bodyLimit returns the body cap of a route.
+func (c Config) bodyLimit(path string) int64 {
+	switch {
+	case path == "/ingest":
+		return c.Ingest.MaxBodyBytes
+	case path == "/ingest/batch":
+		return c.Ingest.MaxBatchBodyBytes
+	case importPaths[path]:
+		return c.Limits.MaxImportBodyBytes
+	case path == streamPath:
+		return 0 // each frame is held to ingest.max_body_bytes instead
+	}
+	return c.Limits.MaxBodyBytes
+}
+
+// withBodyLimit caps the body of requests to path.
+func (s *SwarmAggregator) withBodyLimit(path string, next http.HandlerFunc) http.HandlerFunc {
+	limit := s.config.bodyLimit(path)
+	return func(w http.ResponseWriter, r *http.Request) {
+		limitBody(w, r, limit)
+		next(w, r)
+	}
+}
+
+// writeBodyError answers a request whose body could not be read or
+// decoded: 413 past the size cap, 408 past the read timeout, and
+// otherwise 400 with message.
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var tooLarge *http.MaxBytesError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, r, http.StatusRequestEntityTooLarge, CodePayloadTooLarge,
+			fmt.Sprintf("Body exceeds %d bytes", tooLarge.Limit))
+	case errors.As(err, &netErr) && netErr.Timeout():
+		writeError(w, r, http.StatusRequestTimeout, CodeBodyTimeout, "Body not received in time")
+	default:
+		writeError(w, r, http.StatusBadRequest, CodeInvalidBody, message)
+	}
+}
+
+// applyServerLimits sets the timeouts of a server.
+func (c LimitsConfig) applyServerLimits(srv *http.Server) {
+	srv.ReadHeaderTimeout = time.Duration(c.ReadHeaderTimeout)
+	srv.ReadTimeout = time.Duration(c.ReadTimeout)
+	srv.IdleTimeout = time.Duration(c.IdleTimeout)
+}
+
+// Limits is the body of GET /limits.  Byte counts of zero are unlimited.
+type Limits struct {
+	MaxBodyBytes         int64    `json:"max_body_bytes"`
+	MaxReportBodyBytes   int64    `json:"max_report_body_bytes"`
+	MaxBatchBodyBytes    int64    `json:"max_batch_body_bytes"`
+	MaxBatchReports      int      `json:"max_batch_reports"`
+	MaxImportBodyBytes   int64    `json:"max_import_body_bytes"`
+	MaxDecompressedBytes int64    `json:"max_decompressed_bytes"`
+	ReadHeaderTimeout    Duration `json:"read_header_timeout"`
+	ReadTimeout          Duration `json:"read_timeout"`
+	IdleTimeout          Duration `json:"idle_timeout"`
+}
+
+// handleLimits is the HTTP handler for GET /limits.
+func (s *SwarmAggregator) handleLimits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	cfg := s.config
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Limits{
+		MaxBodyBytes:         cfg.Limits.MaxBodyBytes,
+		MaxReportBodyBytes:   cfg.Ingest.MaxBodyBytes,
+		MaxBatchBodyBytes:    cfg.Ingest.MaxBatchBodyBytes,
+		MaxBatchReports:      maxBatchSize,
+		MaxImportBodyBytes:   cfg.Limits.MaxImportBodyBytes,
+		MaxDecompressedBytes: cfg.Compression.MaxDecompressedBytes,
+		ReadHeaderTimeout:    cfg.Limits.ReadHeaderTimeout,
+		ReadTimeout:          cfg.Limits.ReadTimeout,
+		IdleTimeout:          cfg.Limits.IdleTimeout,
+	})
+}
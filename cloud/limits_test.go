@@ -0,0 +1,106 @@
+package swarm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyLimitsAnswer413(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Limits.MaxBodyBytes = 256
+	cfg.Ingest.MaxBodyBytes = 128
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.keys.Add("admin-secret", APIKey{ID: "ops", Role: RoleAdmin})
+
+	padding := strings.Repeat("x", 512)
+	for _, c := range []struct {
+		path, body string
+	}{
+		{"/ingest", fmt.Sprintf(`{"address":%q,"source_id":%q}`, evmAddress("big"), padding[:200])},
+		{"/admin/allowlist", fmt.Sprintf(`{"address":%q,"reason":%q}`, evmAddress("big"), padding)},
+		{"/admin/rebuild", fmt.Sprintf(`{"resize":true,"reason":%q}`, padding)},
+	} {
+		req := httptest.NewRequest(http.MethodPost, c.path, strings.NewReader(c.body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, req)
+		var body ErrorResponse
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusRequestEntityTooLarge || body.Error.Code != CodePayloadTooLarge || body.Error.RequestID == "" {
+			t.Errorf("%s: expected 413 %s in the error envelope, got %d %+v", c.path, CodePayloadTooLarge, rec.Code, body)
+		}
+	}
+
+	var limits Limits
+	getJSON(t, agg, "/limits", &limits)
+	if limits.MaxBodyBytes != 256 || limits.MaxReportBodyBytes != 128 || limits.MaxBatchReports != maxBatchSize ||
+		limits.MaxImportBodyBytes != cfg.Limits.MaxImportBodyBytes || limits.ReadTimeout != cfg.Limits.ReadTimeout {
+		t.Errorf("Unexpected /limits %+v", limits)
+	}
+}
+
+func TestSlowBodyHitsReadTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Listeners = []ListenerConfig{{Address: "tcp://127.0.0.1:0"}}
+	cfg.Limits.ReadTimeout = Duration(200 * time.Millisecond)
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	servers, err := agg.Serve(cfg, make(chan error, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer servers[0].Shutdown(context.Background())
+
+	conn, err := net.Dial("tcp", servers[0].Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "POST /ingest HTTP/1.1\r\nHost: aegis\r\nContent-Type: application/json\r\nContent-Length: 100\r\n\r\n{\"addr")
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(5 * time.Second))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("Expected an answer once the read timeout passed: %v", err)
+	}
+	var body ErrorResponse
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusRequestTimeout || body.Error.Code != CodeBodyTimeout {
+		t.Errorf("Expected 408 %s, got %d %+v", CodeBodyTimeout, resp.StatusCode, body)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the slow body cut off near the read timeout, took %v", elapsed)
+	}
+}
+
+func TestLongPollOutlastsReadTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Listeners = []ListenerConfig{{Address: "tcp://127.0.0.1:0"}}
+	cfg.Limits.ReadTimeout = Duration(100 * time.Millisecond)
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	servers, err := agg.Serve(cfg, make(chan error, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer servers[0].Shutdown(context.Background())
+
+	time.AfterFunc(400*time.Millisecond, func() {
+		agg.Block(context.Background(), AdminAction{Address: evmAddress("late"), ChainID: 1})
+	})
+	resp, err := http.Get(fmt.Sprintf("http://%s/filter/wait?version=0&timeout=5s", servers[0].Addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(headerFilterVersion) != "1" {
+		t.Errorf("Expected the long poll answered with the new filter, got %d v%q", resp.StatusCode, resp.Header.Get(headerFilterVersion))
+	}
+}
@@ -0,0 +1,207 @@
+// Package swarm — Listeners and route groups.
+//
+// By default the aggregator serves every endpoint on listen_addr.  The
+// listeners list (config file only) replaces that with any number of
+// listeners, each with an address, tcp://host:port or unix:///path, and
+// the route groups it serves:
+//
+//   - ingest: /ingest, /ingest/batch, /stream, /pending, /explain
+//   - subscribe: /filter, /filter/wait, /filter/params, /filter/diff, /ws,
+//     /check, /address/, /stats, /keys, STIX and TAXII export
+//   - admin: /admin/...
+//   - metrics: /metrics
+//   - replication: the peer endpoint
+//
+// An empty list serves every group.  /health and /limits are served
+// everywhere.  So a
+// sidecar can take ingest over a unix socket, serve subscribers on TCP,
+// and keep admin routes on localhost.  TLS, when configured, applies to
+// every TCP listener, and tls.h2c serves cleartext HTTP/2 on the others.
+//
+// A unix socket is created with socket_mode (default 0660) after removing
+// a stale socket left at its path, and is removed again on shutdown.
+// API key authorization applies on every listener as before; binding is
+// an extra layer, not a replacement.
+package swarm
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// RouteGroup names a set of endpoints a listener can serve.
+type RouteGroup string
+
+const (
+	RouteIngest      RouteGroup = "ingest"
+	RouteSubscribe   RouteGroup = "subscribe"
+	RouteAdmin       RouteGroup = "admin"
+	RouteMetrics     RouteGroup = "metrics"
+	RouteReplication RouteGroup = "replication"
+)
+
+// allRouteGroups is every route group, served when none are listed.
+var allRouteGroups = []RouteGroup{RouteIngest, RouteSubscribe, RouteAdmin, RouteMetrics, RouteReplication}
+
+// defaultSocketMode is the permission of a unix socket without socket_mode.
+const defaultSocketMode = "0660"
+
+// ListenerConfig is one listener.  Routes lists the route groups served,
+// all of them when empty.  SocketMode is the octal permission of a unix
+// socket.
+type ListenerConfig struct {
+	Address    string       `json:"address" yaml:"address"`
+	Routes     []RouteGroup `json:"routes" yaml:"routes"`
+	SocketMode string       `json:"socket_mode" yaml:"socket_mode"`
+}
+
+// groups returns the route groups the listener serves.
+func (l ListenerConfig) groups() []RouteGroup {
+	if len(l.Routes) == 0 {
+		return allRouteGroups
+	}
+	return l.Routes
+}
+
+// listenerConfigs returns the configured listeners, or one on listen_addr
+// serving everything.
+func (c Config) listenerConfigs() []ListenerConfig {
+	if len(c.Listeners) == 0 {
+		return []ListenerConfig{{Address: "tcp://" + c.ListenAddr}}
+	}
+	return c.Listeners
+}
+
+// parseListenAddress splits a listener address into its network and
+// address.  An address without a scheme is TCP.
+func parseListenAddress(raw string) (network, address string, err error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		scheme, rest = "tcp", raw
+	}
+	switch scheme {
+	case "tcp":
+		if _, _, err := net.SplitHostPort(rest); err != nil {
+			return "", "", err
+		}
+		return "tcp", rest, nil
+	case "unix":
+		if !strings.HasPrefix(rest, "/") {
+			return "", "", errors.New("unix socket path must be absolute")
+		}
+		return "unix", rest, nil
+	}
+	return "", "", fmt.Errorf("unknown scheme %q, want tcp or unix", scheme)
+}
+
+// validateListeners checks the listeners list.  Port 0 may repeat, as
+// each listener on it is given a port of its own.
+func validateListeners(listeners []ListenerConfig, fail func(string, ...interface{})) {
+	seen := make(map[string]bool)
+	for i, l := range listeners {
+		if _, _, err := parseListenAddress(l.Address); err != nil {
+			fail("listeners[%d].address %q: %v", i, l.Address, err)
+		} else if seen[l.Address] && !strings.HasSuffix(l.Address, ":0") {
+			fail("listeners[%d].address %q is listed twice", i, l.Address)
+		}
+		seen[l.Address] = true
+		for _, g := range l.Routes {
+			if !slices.Contains(allRouteGroups, g) {
+				fail("listeners[%d]: unknown route group %q", i, g)
+			}
+		}
+		if l.SocketMode != "" {
+			if _, err := strconv.ParseUint(l.SocketMode, 8, 32); err != nil {
+				fail("listeners[%d].socket_mode %q must be octal, e.g. 0660", i, l.SocketMode)
+			}
+		}
+	}
+}
+
+// listen opens a listener.  A unix socket replaces a stale socket at its
+// path, gets its configured mode, and is removed when the listener closes.
+func listen(l ListenerConfig) (net.Listener, error) {
+	network, address, err := parseListenAddress(l.Address)
+	if err != nil {
+		return nil, err
+	}
+	if network != "unix" {
+		return net.Listen(network, address)
+	}
+
+	if info, err := os.Lstat(address); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", address)
+		}
+		if err := os.Remove(address); err != nil {
+			return nil, err
+		}
+	}
+	mode := l.SocketMode
+	if mode == "" {
+		mode = defaultSocketMode
+	}
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("socket_mode %q: %w", mode, err)
+	}
+	ln, err := net.Listen("unix", address)
+	if err != nil {
+		return nil, err
+	}
+	ln.(*net.UnixListener).SetUnlinkOnClose(true)
+	if err := os.Chmod(address, fs.FileMode(perm)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Serve starts a server for each configured listener, in order, sending
+// the first serve error, if any, to errs.  Each server's Addr is the
+// address it is bound to.
+func (s *SwarmAggregator) Serve(cfg Config, errs chan<- error) ([]*http.Server, error) {
+	var servers []*http.Server
+	for _, l := range cfg.listenerConfigs() {
+		ln, err := listen(l)
+		if err != nil {
+			for _, srv := range servers {
+				srv.Close()
+			}
+			return nil, fmt.Errorf("listen on %s: %w", l.Address, err)
+		}
+		tls := cfg.TLS.Enabled() && ln.Addr().Network() == "tcp"
+		handler := s.RoutesFor(l.groups()...)
+		if cfg.TLS.H2C && !tls {
+			handler = h2c.NewHandler(handler, &http2.Server{})
+		}
+		srv := &http.Server{Addr: ln.Addr().String(), Handler: handler}
+		cfg.Limits.applyServerLimits(srv)
+		servers = append(servers, srv)
+		go func() {
+			var err error
+			if tls {
+				err = srv.ServeTLS(ln, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			} else {
+				err = srv.Serve(ln)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				select {
+				case errs <- err:
+				default:
+				}
+			}
+		}()
+	}
+	return servers, nil
+}
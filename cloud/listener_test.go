@@ -0,0 +1,126 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnixSocketIngestAndPrivateAdmin(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "aegis.sock")
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Listeners = []ListenerConfig{
+		{Address: "unix://" + sock, Routes: []RouteGroup{RouteIngest}, SocketMode: "0600"},
+		{Address: "tcp://127.0.0.1:0", Routes: []RouteGroup{RouteSubscribe}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.keys.Add("ops-secret", APIKey{ID: "ops", Role: RoleAdmin})
+
+	errs := make(chan error, 1)
+	servers, err := agg.Serve(cfg, errs)
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	defer func() {
+		for _, srv := range servers {
+			srv.Shutdown(context.Background())
+		}
+	}()
+
+	info, err := os.Stat(sock)
+	if err != nil || info.Mode()&fs.ModeSocket == 0 || info.Mode().Perm() != 0o600 {
+		t.Fatalf("Expected a socket with mode 0600, got %v (%v)", info, err)
+	}
+
+	unix := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	addr := evmAddress("sidecar")
+	resp, err := unix.Post("http://aegis/ingest", "application/json",
+		strings.NewReader(fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"gateway"}`, addr)))
+	if err != nil {
+		t.Fatalf("Ingest over the unix socket failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 over the unix socket, got %d", resp.StatusCode)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !agg.bloomFilter.Contains(addr) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !agg.bloomFilter.Contains(addr) {
+		t.Error("Expected the report ingested over the unix socket to promote")
+	}
+	if resp, err := unix.Get("http://aegis/filter"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the subscribe routes off the ingest socket, got %v (%v)", resp.StatusCode, err)
+	}
+
+	public := "http://" + servers[1].Addr
+	req, _ := http.NewRequest(http.MethodPost, public+"/admin/block", strings.NewReader(`{"address":"`+evmAddress("x")+`"}`))
+	req.Header.Set("X-API-Key", "ops-secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected admin routes unreachable on the public listener, got %d", resp.StatusCode)
+	}
+	if resp, err := http.Get(public + "/check?address=" + addr); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected /check served publicly, got %v (%v)", resp.StatusCode, err)
+	}
+
+	for _, srv := range servers {
+		srv.Shutdown(context.Background())
+	}
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("Expected the socket removed on shutdown, got %v", err)
+	}
+}
+
+func TestListenerConfigValidation(t *testing.T) {
+	for name, l := range map[string]ListenerConfig{
+		"relative socket": {Address: "unix://aegis.sock"},
+		"unknown scheme":  {Address: "udp://:9090"},
+		"missing port":    {Address: "tcp://localhost"},
+		"unknown group":   {Address: ":9090", Routes: []RouteGroup{"debug"}},
+		"bad mode":        {Address: "unix:///tmp/a.sock", SocketMode: "rw"},
+	} {
+		cfg := DefaultConfig()
+		cfg.Listeners = []ListenerConfig{l}
+		if cfg.Validate() == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "not-a-socket")
+	os.WriteFile(plain, nil, 0o600)
+	if _, err := listen(ListenerConfig{Address: "unix://" + plain}); err == nil {
+		t.Error("Expected listen to refuse to replace a regular file")
+	}
+
+	stale := filepath.Join(dir, "stale.sock")
+	ln, _ := net.Listen("unix", stale)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err := listen(ListenerConfig{Address: "unix://" + stale})
+	if err != nil {
+		t.Fatalf("Expected a stale socket replaced, got %v", err)
+	}
+	ln.Close()
+}
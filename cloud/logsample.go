@@ -0,0 +1,114 @@
+// Package swarm — Sampled logging for hot paths.
+//
+// Warnings logged per subscriber or per report, such as a slow subscriber
+// skipping a push, can repeat thousands of times a second during an
+// incident, and writing them becomes the bottleneck.  A logSampler
+// writes the first warning of each class and key (a subscriber or source
+// ID) in a window and only counts the repeats; at most logSampleKeys keys
+// per class are written in a window, and the rest only counted.  Every
+// maintenance tick closes the window, writing the last line of each
+// repeated warning with a "(repeated N times)" suffix and one line per
+// class for the keys that were not written.
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// logSampleKeys bounds the keys per class written in one window.
+const logSampleKeys = 20
+
+// Classes of sampled warnings.
+const (
+	logSlowSubscriber    = "slow_subscriber"
+	logEvictedSubscriber = "evicted_subscriber"
+	logSerializeFailure  = "serialize_failure"
+	logDroppedReport     = "dropped_report"
+	logFilterCap         = "filter_cap"
+)
+
+// logSample is a key's warning in the current window.
+type logSample struct {
+	message string // as first written
+	repeats int
+}
+
+// logClass is the current window of one class.
+type logClass struct {
+	keys       map[string]*logSample
+	suppressed int // warnings for keys past the cap
+	overflow   map[string]bool
+}
+
+type logSampler struct {
+	printf  func(format string, args ...interface{})
+	maxKeys int
+
+	mu      sync.Mutex
+	classes map[string]*logClass
+}
+
+// newLogSampler writes through printf, normally log.Printf.
+func newLogSampler(printf func(format string, args ...interface{}), maxKeys int) *logSampler {
+	return &logSampler{printf: printf, maxKeys: maxKeys, classes: make(map[string]*logClass)}
+}
+
+// Printf writes a warning of class for key, unless one was already
+// written for it this window.  Repeats are not formatted.
+func (l *logSampler) Printf(class, key, format string, args ...interface{}) {
+	l.mu.Lock()
+	c, ok := l.classes[class]
+	if !ok {
+		c = &logClass{keys: make(map[string]*logSample)}
+		l.classes[class] = c
+	}
+	if sample, ok := c.keys[key]; ok {
+		sample.repeats++
+		l.mu.Unlock()
+		return
+	}
+	if len(c.keys) >= l.maxKeys {
+		c.suppressed++
+		if c.overflow == nil {
+			c.overflow = make(map[string]bool)
+		}
+		c.overflow[key] = true
+		l.mu.Unlock()
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	c.keys[key] = &logSample{message: message}
+	l.mu.Unlock()
+	l.printf("%s", message)
+}
+
+// flush closes the window, writing the counts of what it held back.
+func (l *logSampler) flush(ctx context.Context) TaskStats {
+	l.mu.Lock()
+	classes := l.classes
+	l.classes = make(map[string]*logClass, len(classes))
+	l.mu.Unlock()
+
+	var stats TaskStats
+	names := make([]string, 0, len(classes))
+	for name := range classes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := classes[name]
+		stats.Items += len(c.keys)
+		for _, sample := range c.keys {
+			if sample.repeats > 0 {
+				l.printf("%s (repeated %d times)", sample.message, sample.repeats)
+			}
+		}
+		if c.suppressed > 0 {
+			l.printf("Suppressed %d %s warnings for %d more keys", c.suppressed, name, len(c.overflow))
+		}
+	}
+	return stats
+}
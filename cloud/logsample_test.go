@@ -0,0 +1,94 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureLogs returns a sampler whose lines are appended to the result.
+func captureLogs(maxKeys int) (*logSampler, *[]string) {
+	var lines []string
+	return newLogSampler(func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}, maxKeys), &lines
+}
+
+func TestLogSamplerCountsRepeats(t *testing.T) {
+	logs, lines := captureLogs(logSampleKeys)
+	for i := 0; i < 3; i++ {
+		logs.Printf(logSlowSubscriber, "ws-a", "Subscriber %s too slow, skipping push", "ws-a")
+	}
+	logs.Printf(logSlowSubscriber, "ws-b", "Subscriber %s too slow, skipping push", "ws-b")
+	logs.Printf(logDroppedReport, "ws-a", "Dropping report from %q", "ws-a")
+	if len(*lines) != 3 {
+		t.Fatalf("Expected one line per class and key, got %q", *lines)
+	}
+
+	logs.flush(context.Background())
+	if len(*lines) != 4 || (*lines)[3] != "Subscriber ws-a too slow, skipping push (repeated 2 times)" {
+		t.Fatalf("Expected the repeats counted at the tick, got %q", *lines)
+	}
+	logs.flush(context.Background())
+	logs.Printf(logSlowSubscriber, "ws-a", "Subscriber %s too slow, skipping push", "ws-a")
+	if len(*lines) != 5 {
+		t.Errorf("Expected a new window to write again, got %q", *lines)
+	}
+}
+
+func TestLogSamplerCapsKeysPerClass(t *testing.T) {
+	logs, lines := captureLogs(2)
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("ws-%d", i)
+		logs.Printf(logSlowSubscriber, id, "Subscriber %s too slow, skipping push", id)
+		logs.Printf(logSlowSubscriber, id, "Subscriber %s too slow, skipping push", id)
+	}
+	if len(*lines) != 2 {
+		t.Fatalf("Expected 2 keys written, got %q", *lines)
+	}
+	logs.flush(context.Background())
+	last := (*lines)[len(*lines)-1]
+	if len(*lines) != 5 || !strings.Contains(last, "Suppressed 6 slow_subscriber warnings for 3 more keys") {
+		t.Errorf("Expected the held-back keys summarized, got %q", *lines)
+	}
+}
+
+// BenchmarkBroadcastSlowSubscribers pushes to 5000 subscribers that each
+// drop every other push, logging a slow-subscriber warning each time.
+// "every" closes the sampling window after each push, as logging every
+// warning did; "sampled" keeps one window for the whole run.
+func BenchmarkBroadcastSlowSubscribers(b *testing.B) {
+	for _, mode := range []string{"every", "sampled"} {
+		b.Run(mode, func(b *testing.B) {
+			logs := newLogSampler(log.New(io.Discard, "", log.LstdFlags).Printf, logSampleKeys)
+			if mode == "every" {
+				logs.maxKeys = 1 << 30
+			}
+			ss := newSubscriberSet(logs)
+			var chans []chan []byte
+			for i := 0; i < 5000; i++ {
+				chans = append(chans, ss.subscribe(fmt.Sprintf("ws-%d", i), 1, SubscribeOptions{Format: FormatBloom}, time.Now()).ch)
+			}
+			msgs := pushMessages{}
+			for v := range ss.variants() {
+				msgs[v] = []byte(`{"kind":"snapshot"}`)
+			}
+			policy := evictionPolicy{}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ss.broadcast(msgs, pushEvent{version: uint64(2*i + 1), at: time.Now()}, policy)
+				ss.broadcast(msgs, pushEvent{version: uint64(2*i + 2), at: time.Now()}, policy) // dropped
+				for _, ch := range chans {
+					<-ch
+				}
+				if mode == "every" {
+					logs.flush(context.Background())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+// Package swarm — Long-polling for filter updates.
+//
+// Some enterprise networks strip WebSocket upgrades and cannot receive
+// webhooks.  GET /filter/wait?version=N&timeout=30s serves them: it
+// answers like GET /filter as soon as the filter version exceeds N, at
+// once if it already does, or with 204 No Content when the timeout
+// elapses first.  The timeout defaults to defaultWaitTimeout and is capped
+// at maxWaitTimeout; a client that disconnects stops waiting.
+//
+// Waiters are woken by the push path, not by polling the filter: each
+// subscriberSet publishes the version of every broadcast to a versionWatch,
+// which closes a channel shared by all its waiters and replaces it, so one
+// version bump releases every waiter at once.  Pushes are debounced as for
+// WebSocket subscribers.
+package swarm
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Long-poll timeouts.
+const (
+	defaultWaitTimeout = 30 * time.Second
+	maxWaitTimeout     = 60 * time.Second
+)
+
+// versionWatch broadcasts filter version bumps to any number of waiters.
+type versionWatch struct {
+	mu      sync.Mutex
+	version uint64        // latest version published
+	changed chan struct{} // closed and replaced when version increases
+	waiters int
+}
+
+func newVersionWatch() *versionWatch {
+	return &versionWatch{changed: make(chan struct{})}
+}
+
+// publish records a pushed version, waking every waiter if it is newer.
+func (v *versionWatch) publish(version uint64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if version <= v.version {
+		return
+	}
+	v.version = version
+	close(v.changed)
+	v.changed = make(chan struct{})
+}
+
+// wait blocks until a version after the given one is published, returning
+// it, or until ctx is done.
+func (v *versionWatch) wait(ctx context.Context, after uint64) (uint64, bool) {
+	v.mu.Lock()
+	v.waiters++
+	defer func() {
+		v.mu.Lock()
+		v.waiters--
+		v.mu.Unlock()
+	}()
+	for v.version <= after {
+		changed := v.changed
+		v.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return 0, false
+		}
+		v.mu.Lock()
+	}
+	version := v.version
+	v.mu.Unlock()
+	return version, true
+}
+
+// waiting reports whether anyone is waiting.
+func (v *versionWatch) waiting() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.waiters > 0
+}
+
+// parseWaitTimeout parses the timeout query parameter, capping it.
+func parseWaitTimeout(raw string) (time.Duration, bool) {
+	if raw == "" {
+		return defaultWaitTimeout, true
+	}
+	d, err := time.ParseDuration(raw)
+	if err != nil || d <= 0 {
+		return 0, false
+	}
+	return min(d, maxWaitTimeout), true
+}
+
+// handleFilterWait is the HTTP handler for GET /filter/wait.
+func (s *SwarmAggregator) handleFilterWait(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	query := r.URL.Query()
+	after, err := strconv.ParseUint(query.Get("version"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid version")
+		return
+	}
+	timeout, ok := parseWaitTimeout(query.Get("timeout"))
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid timeout: want a positive duration, e.g. 30s")
+		return
+	}
+	format, err := parseFilterFormat(query.Get("format"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	name, ok := s.requestNamespace(w, r)
+	if !ok {
+		return
+	}
+
+	snapshot, watch := s.globalSnapshot, s.subscribers.watch
+	if name != "" {
+		ns := s.namespace(name)
+		snapshot = func() filterSnapshot { return s.namespaceSnapshot(ns) }
+		watch = ns.subscribers.watch
+	}
+	snap := snapshot()
+	if snap.version <= after {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		if _, ok := watch.wait(ctx, after); !ok {
+			if r.Context().Err() != nil {
+				return // the client went away
+			}
+			w.Header().Set(headerFilterVersion, strconv.FormatUint(snap.version, 10))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		snap = snapshot()
+	}
+	s.writeFilter(w, r, snap, format)
+}
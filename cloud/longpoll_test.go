@@ -0,0 +1,113 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilterWaitReleasesEveryWaiterOnOneBump(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+	start := agg.bloomFilter.Version()
+
+	const waiters = 5
+	var wg sync.WaitGroup
+	codes := make([]int, waiters)
+	versions := make([]string, waiters)
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := http.Get(fmt.Sprintf("%s/filter/wait?version=%d&timeout=10s", srv.URL, start))
+			if err != nil {
+				t.Errorf("Waiter %d: %v", i, err)
+				return
+			}
+			resp.Body.Close()
+			codes[i], versions[i] = resp.StatusCode, resp.Header.Get(headerFilterVersion)
+		}(i)
+	}
+
+	// Promote once every waiter is parked on the watch.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		agg.subscribers.watch.mu.Lock()
+		n := agg.subscribers.watch.waiters
+		agg.subscribers.watch.mu.Unlock()
+		if n == waiters {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Only %d of %d waiters parked", n, waiters)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	began := time.Now()
+	agg.IngestReport(context.Background(), IOCReport{Address: evmAddress("wake"), ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"})
+	wg.Wait()
+
+	want := strconv.FormatUint(start+1, 10)
+	for i := range codes {
+		if codes[i] != http.StatusOK || versions[i] != want {
+			t.Errorf("Waiter %d: expected 200 at version %s, got %d at %q", i, want, codes[i], versions[i])
+		}
+	}
+	if elapsed := time.Since(began); elapsed > time.Second {
+		t.Errorf("Expected waiters released by the bump, took %v", elapsed)
+	}
+}
+
+func TestFilterWaitAnswersAtOnceWhenBehind(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	promote(agg, evmAddress("already"), "")
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/filter/wait?version=0", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(headerFilterSignature) == "" {
+		t.Errorf("Expected the signed filter at once, got %d", rec.Code)
+	}
+}
+
+func TestFilterWaitTimeoutAndDisconnect(t *testing.T) {
+	agg := NewSwarmAggregator()
+	v := agg.bloomFilter.Version()
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/filter/wait?version=%d&timeout=20ms", v), nil))
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204 after the timeout, got %d", rec.Code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/filter/wait?version=%d&timeout=1m", v), nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		agg.Routes().ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the handler to return when the client disconnects")
+	}
+
+	if d, _ := parseWaitTimeout("1h"); d != maxWaitTimeout {
+		t.Errorf("Expected the timeout capped at %v, got %v", maxWaitTimeout, d)
+	}
+	for _, bad := range []string{"version=x", "version=1&timeout=-1s", "version=1&timeout=soon"} {
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/filter/wait?"+bad, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", bad, rec.Code)
+		}
+	}
+}
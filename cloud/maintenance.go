@@ -0,0 +1,254 @@
+// Package swarm — Background maintenance.
+//
+// Caches and trackers that need periodic pruning register a Task with the
+// aggregator's Maintenance instead of doing the work lazily on the ingest
+// path.  Every maintenance.interval the tasks run one after another, each
+// with a context that is cancelled once it has used its budget
+// (maintenance.task_budget unless registered with its own).  A task that
+// overruns is abandoned to finish in the background and skipped until it
+// does, so one slow sweep cannot starve the others; a task that panics is
+// logged and counted, and runs again on the next tick.
+//
+// Durations, items processed, and failures are exported per task as
+// aegis_maintenance_task_duration_seconds,
+// aegis_maintenance_items_total, and aegis_maintenance_failures_total.
+// Close stops the schedule, cancelling the task in progress.
+package swarm
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Task is one maintenance job.  Run should return promptly once ctx is
+// done, reporting what it got through.
+type Task interface {
+	Run(ctx context.Context) TaskStats
+}
+
+// TaskFunc adapts a function to Task.
+type TaskFunc func(ctx context.Context) TaskStats
+
+// Run implements Task.
+func (f TaskFunc) Run(ctx context.Context) TaskStats { return f(ctx) }
+
+// TaskStats is what one run of a task did.
+type TaskStats struct {
+	Items int // entries examined
+}
+
+// Maintenance failure reasons recorded in aegis_maintenance_failures_total.
+const (
+	maintenanceFailurePanic   = "panic"
+	maintenanceFailureOverrun = "overrun"
+	maintenanceFailureSkipped = "skipped" // still running from an earlier tick
+)
+
+// maintenanceTask is a registered task.
+type maintenanceTask struct {
+	name    string
+	task    Task
+	budget  time.Duration
+	running atomic.Bool
+}
+
+// maintenanceMetrics are the collectors Maintenance reports to.
+type maintenanceMetrics struct {
+	durations *prometheus.HistogramVec // task
+	items     *prometheus.CounterVec   // task
+	failures  *prometheus.CounterVec   // task, reason
+}
+
+// Maintenance runs registered tasks on a schedule.
+type Maintenance struct {
+	config  MaintenanceConfig
+	clock   Clock
+	metrics maintenanceMetrics
+
+	mu    sync.Mutex
+	tasks []*maintenanceTask
+
+	started atomic.Bool
+	stop    sync.Once
+	quit    chan struct{}
+	done    chan struct{} // closed when the loop exits
+}
+
+func newMaintenance(config MaintenanceConfig, clock Clock, metrics maintenanceMetrics) *Maintenance {
+	return &Maintenance{
+		config:  config,
+		clock:   clock,
+		metrics: metrics,
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Register adds a task, run on every tick from the next one.  A zero
+// budget uses maintenance.task_budget.
+func (m *Maintenance) Register(name string, task Task, budget time.Duration) {
+	if budget <= 0 {
+		budget = time.Duration(m.config.TaskBudget)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tasks = append(m.tasks, &maintenanceTask{name: name, task: task, budget: budget})
+}
+
+// Start begins the schedule.  Calling it again has no effect.
+func (m *Maintenance) Start() {
+	if m.started.CompareAndSwap(false, true) {
+		go m.loop()
+	}
+}
+
+// Close stops the schedule and waits for the loop to exit.  A task
+// abandoned after overrunning its budget is not waited for.
+func (m *Maintenance) Close() {
+	m.stop.Do(func() { close(m.quit) })
+	if m.started.Load() {
+		<-m.done
+	}
+}
+
+func (m *Maintenance) loop() {
+	defer close(m.done)
+	tick, stopTicker := m.clock.Ticker(time.Duration(m.config.Interval))
+	defer stopTicker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-m.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	for {
+		select {
+		case <-m.quit:
+			return
+		case <-tick:
+			m.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce runs every task once, in registration order, within its budget.
+func (m *Maintenance) RunOnce(ctx context.Context) {
+	m.mu.Lock()
+	tasks := append([]*maintenanceTask(nil), m.tasks...)
+	m.mu.Unlock()
+	for _, t := range tasks {
+		if ctx.Err() != nil {
+			return
+		}
+		m.run(ctx, t)
+	}
+}
+
+// run runs one task, returning once it finishes, panics, or overruns.
+func (m *Maintenance) run(ctx context.Context, t *maintenanceTask) {
+	if !t.running.CompareAndSwap(false, true) {
+		m.metrics.failures.WithLabelValues(t.name, maintenanceFailureSkipped).Inc()
+		return
+	}
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	started := m.clock.Now()
+	finished := make(chan TaskStats, 1)
+	go func() {
+		defer t.running.Store(false)
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("Maintenance task %s panicked: %v\n%s", t.name, p, debug.Stack())
+				m.metrics.failures.WithLabelValues(t.name, maintenanceFailurePanic).Inc()
+				close(finished)
+			}
+		}()
+		finished <- t.task.Run(taskCtx)
+	}()
+
+	select {
+	case stats, ok := <-finished:
+		m.metrics.durations.WithLabelValues(t.name).Observe(m.clock.Now().Sub(started).Seconds())
+		if ok {
+			m.metrics.items.WithLabelValues(t.name).Add(float64(stats.Items))
+		}
+	case <-m.clock.After(t.budget):
+		log.Printf("Maintenance task %s overran its %s budget", t.name, t.budget)
+		m.metrics.failures.WithLabelValues(t.name, maintenanceFailureOverrun).Inc()
+	case <-ctx.Done():
+	}
+}
+
+// StartMaintenance starts the aggregator's maintenance schedule.
+func (s *SwarmAggregator) StartMaintenance() {
+	s.maintenance.Start()
+}
+
+// RunMaintenance runs every maintenance task once, at the clock's time.
+// A ManualClock never starts a pass itself; whoever advances it does.
+func (s *SwarmAggregator) RunMaintenance(ctx context.Context) {
+	s.maintenance.RunOnce(ctx)
+}
+
+// Close stops the aggregator's background maintenance.
+func (s *SwarmAggregator) Close() {
+	s.maintenance.Close()
+}
+
+// registerMaintenance registers the aggregator's own maintenance tasks.
+func (s *SwarmAggregator) registerMaintenance() {
+	s.maintenance.Register("log_sampler", TaskFunc(s.logs.flush), 0)
+	s.maintenance.Register("idempotency", TaskFunc(s.idempotency.prune), 0)
+	s.maintenance.Register("rate_limiter", TaskFunc(s.limiter.prune), 0)
+	s.maintenance.Register("watchlist_limiter", TaskFunc(s.watchLimiter.prune), 0)
+	s.maintenance.Register("feedback_limiter", TaskFunc(s.disputeLimit.prune), 0)
+	s.maintenance.Register("watchlist", TaskFunc(s.refreshWatchlist), 0)
+	s.maintenance.Register("cooldown", TaskFunc(s.pruneCooldowns), 0)
+	s.maintenance.Register("source_stats", TaskFunc(s.pruneSourceStats), 0)
+	s.maintenance.Register("push_schedule", TaskFunc(s.flushScheduledPushes), 0)
+	if s.config.Bloom.RebuildFillRatio > 0 {
+		s.maintenance.Register("filter_rebuild", TaskFunc(s.rebuildIfFull), 0)
+	}
+	if s.config.Bloom.AutoSizeHorizon > 0 {
+		s.maintenance.Register("filter_autosize", TaskFunc(s.autoSizeFilter), 0)
+	}
+	if s.config.Alerts.BurnRate.Multiplier > 0 {
+		s.maintenance.Register("burn_rate", TaskFunc(s.checkBurnRate), 0)
+	}
+	if s.config.Maintenance.TWABIdleTTL > 0 {
+		s.maintenance.Register("twab", TaskFunc(s.collectIdleTWAB), 0)
+	}
+	if s.config.Retention.UnpromotedReports > 0 || s.config.Retention.PromotedReports > 0 {
+		s.maintenance.Register("retention", TaskFunc(s.pruneReports), 0)
+	}
+	if s.staging != nil {
+		s.maintenance.Register("staging", TaskFunc(s.graduateStaged), 0)
+	}
+}
+
+// collectIdleTWAB drops the TWAB history of unconfirmed, unstaged
+// addresses with no reports for maintenance.twab_idle_ttl, counting each
+// against the calibration of the sources that reported it.
+func (s *SwarmAggregator) collectIdleTWAB(ctx context.Context) TaskStats {
+	before := s.maintenance.clock.Now().Add(-time.Duration(s.config.Maintenance.TWABIdleTTL))
+	return s.twab.CollectIdle(ctx, before, func(address string) bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		_, confirmed := s.confirmed[address]
+		_, staged := s.staged[address]
+		return confirmed || staged
+	}, func(sources map[string]float64) {
+		s.calibration.record(sources, false, s.current().Calibration)
+	})
+}
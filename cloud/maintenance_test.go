@@ -0,0 +1,240 @@
+package swarm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"golang.org/x/time/rate"
+)
+
+func newTestMaintenance(clock Clock) (*Maintenance, maintenanceMetrics) {
+	metrics := newMetrics(NewSwarmAggregator()).maintenance
+	cfg := DefaultConfig().Maintenance
+	return newMaintenance(cfg, clock, metrics), metrics
+}
+
+// countingTask counts its runs on a channel.
+func countingTask(runs chan<- string, name string, items int) Task {
+	return TaskFunc(func(context.Context) TaskStats {
+		runs <- name
+		return TaskStats{Items: items}
+	})
+}
+
+func expectRun(t *testing.T, runs <-chan string, want string) {
+	t.Helper()
+	select {
+	case got := <-runs:
+		if got != want {
+			t.Fatalf("Expected %s to run, got %s", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Timed out waiting for %s to run", want)
+	}
+}
+
+func TestMaintenanceRunsTasksOnTick(t *testing.T) {
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	m, metrics := newTestMaintenance(clock)
+	runs := make(chan string, 4)
+	m.Register("first", countingTask(runs, "first", 3), 0)
+	m.Register("second", countingTask(runs, "second", 5), 0)
+	m.Start()
+
+	select {
+	case got := <-runs:
+		t.Fatalf("Expected nothing to run before the first tick, got %s", got)
+	case <-time.After(20 * time.Millisecond):
+	}
+	for i := 0; i < 2; i++ {
+		clock.Tick()
+		expectRun(t, runs, "first")
+		expectRun(t, runs, "second")
+	}
+	m.Close()
+
+	if got := testutil.ToFloat64(metrics.items.WithLabelValues("first")); got != 6 {
+		t.Errorf("Expected 6 items recorded for first, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.items.WithLabelValues("second")); got != 10 {
+		t.Errorf("Expected 10 items recorded for second, got %v", got)
+	}
+	if got := testutil.CollectAndCount(metrics.durations); got != 2 {
+		t.Errorf("Expected durations for both tasks, got %d series", got)
+	}
+}
+
+func TestMaintenanceIsolatesPanics(t *testing.T) {
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	m, metrics := newTestMaintenance(clock)
+	runs := make(chan string, 4)
+	m.Register("panics", TaskFunc(func(context.Context) TaskStats {
+		runs <- "panics"
+		panic("boom")
+	}), 0)
+	m.Register("after", countingTask(runs, "after", 1), 0)
+	m.Start()
+	defer m.Close()
+
+	for i := 0; i < 2; i++ {
+		clock.Tick()
+		expectRun(t, runs, "panics")
+		expectRun(t, runs, "after")
+	}
+	if got := testutil.ToFloat64(metrics.failures.WithLabelValues("panics", maintenanceFailurePanic)); got != 2 {
+		t.Errorf("Expected 2 panics recorded, got %v", got)
+	}
+}
+
+func TestMaintenanceAbandonsOverrunningTask(t *testing.T) {
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	m, metrics := newTestMaintenance(clock)
+	runs := make(chan string, 4)
+	release := make(chan struct{})
+	cancelled := make(chan struct{})
+	m.Register("slow", TaskFunc(func(ctx context.Context) TaskStats {
+		runs <- "slow"
+		<-ctx.Done()
+		close(cancelled)
+		<-release // ignores cancellation past this point
+		return TaskStats{}
+	}), time.Second)
+	m.Register("fast", countingTask(runs, "fast", 1), 0)
+	m.Start()
+	defer m.Close()
+
+	clock.Tick()
+	expectRun(t, runs, "slow")
+	waitFor(t, "the slow task's budget", func() bool {
+		clock.mu.Lock()
+		defer clock.mu.Unlock()
+		return len(clock.timers) == 1
+	})
+	clock.Advance(time.Second)
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the overrunning task's context to be cancelled")
+	}
+	expectRun(t, runs, "fast")
+
+	clock.Tick()
+	expectRun(t, runs, "fast") // slow is still running, so skipped
+	if got := testutil.ToFloat64(metrics.failures.WithLabelValues("slow", maintenanceFailureOverrun)); got != 1 {
+		t.Errorf("Expected 1 overrun recorded, got %v", got)
+	}
+	if got := testutil.ToFloat64(metrics.failures.WithLabelValues("slow", maintenanceFailureSkipped)); got != 1 {
+		t.Errorf("Expected 1 skipped run recorded, got %v", got)
+	}
+	close(release)
+}
+
+func TestMaintenanceCloseStopsSchedule(t *testing.T) {
+	clock := NewManualClock(time.Unix(1700000000, 0))
+	m, _ := newTestMaintenance(clock)
+	m.Register("task", countingTask(make(chan string, 1), "task", 0), 0)
+	m.Start()
+
+	closed := make(chan struct{})
+	go func() {
+		m.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close did not return")
+	}
+	if !clock.Stopped() {
+		t.Error("Expected Close to stop the ticker")
+	}
+	select {
+	case clock.tick <- clock.Now():
+		t.Error("Expected no loop receiving ticks after Close")
+	default:
+	}
+
+	m.Close() // idempotent
+	unstarted, _ := newTestMaintenance(NewManualClock(time.Unix(1700000000, 0)))
+	unstarted.Close() // returns without a loop to wait for
+}
+
+func TestIdempotencyPrune(t *testing.T) {
+	c := newIdempotencyCache(IngestConfig{IdempotencyKeys: 10, IdempotencyWindow: Duration(time.Minute)})
+	for _, key := range []string{"old-1", "old-2", "fresh"} {
+		e, _ := c.claim(key)
+		c.finish(e, 200, ingestResult{})
+	}
+	for _, key := range []string{"old-1", "old-2"} {
+		c.entries[key].Value.(*idempotentEntry).stored = time.Now().Add(-2 * time.Minute)
+	}
+
+	if stats := c.prune(context.Background()); stats.Items != 3 {
+		t.Errorf("Expected 3 entries examined, got %d", stats.Items)
+	}
+	if _, ok := c.entries["fresh"]; !ok || len(c.entries) != 1 || c.lru.Len() != 1 {
+		t.Errorf("Expected only the fresh entry kept, got %d entries", len(c.entries))
+	}
+	var disabled *idempotencyCache
+	disabled.prune(context.Background())
+}
+
+func TestRateLimiterPrune(t *testing.T) {
+	l := newIngestLimiter(RateLimitConfig{IngestPerSecond: 0.001, IngestBurst: 2})
+	l.allow("idle")
+	l.allow("drained")
+	l.allow("drained")
+	l.clients["idle"] = rate.NewLimiter(l.limit, l.burst) // refilled
+
+	if stats := l.prune(context.Background()); stats.Items != 2 {
+		t.Errorf("Expected 2 buckets examined, got %d", stats.Items)
+	}
+	if _, ok := l.clients["drained"]; !ok || len(l.clients) != 1 {
+		t.Errorf("Expected only the drained bucket kept, got %v", l.clients)
+	}
+}
+
+func TestCollectIdleTWAB(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Maintenance.TWABIdleTTL = Duration(time.Hour)
+	agg := NewSwarmAggregatorWithConfig(cfg)
+
+	stale, confirmed, fresh := evmAddress("stale"), evmAddress("confirmed"), evmAddress("fresh")
+	old := time.Now().Add(-2 * time.Hour)
+	agg.twab.Record(stale, IOCReport{Address: stale, SourceID: "agent-A", Confidence: 0.9, Timestamp: old, ReceivedAt: old})
+	promote(agg, confirmed, "phishing")
+	agg.twab.Record(confirmed, IOCReport{Address: confirmed, SourceID: "agent-C", Confidence: 0.9, Timestamp: old, ReceivedAt: old})
+	agg.twab.Record(fresh, IOCReport{Address: fresh, SourceID: "agent-A", Confidence: 0.9, Timestamp: time.Now()})
+	for _, addr := range []string{stale, confirmed} {
+		shard := agg.twab.shardFor(addr)
+		shard.entries[addr].LastReceived = old
+	}
+
+	agg.maintenance.RunOnce(context.Background())
+	if _, ok := agg.twab.Summary(stale); ok {
+		t.Error("Expected the idle unconfirmed address to be collected")
+	}
+	for _, addr := range []string{confirmed, fresh} {
+		if _, ok := agg.twab.Summary(addr); !ok {
+			t.Errorf("Expected %s to be kept", addr)
+		}
+	}
+	if got := testutil.ToFloat64(agg.metrics.maintenance.items.WithLabelValues("twab")); got != 3 {
+		t.Errorf("Expected 3 TWAB entries examined, got %v", got)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after five seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,126 @@
+// Package swarm — Federated filter merge.
+//
+// Regional aggregators can push their filter to a central instance with
+// POST /admin/merge?region=<name>.  The peer is trusted: its entries join
+// the confirmed set directly, tagged with the region they came from, and
+// the local filter is unioned with the peer's.  Both filters must use the
+// same bit size and hash count.  Allowlisted addresses are never merged.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/aegis-protocol/swarm/bloom"
+)
+
+// mergeSourceID is the provenance Source for entries merged from a region.
+func mergeSourceID(region string) string {
+	return "merge:" + region
+}
+
+// MergeSummary reports the outcome of a merge.
+type MergeSummary struct {
+	Region  string `json:"region"`
+	Added   int    `json:"added"`
+	Skipped int    `json:"skipped"`
+	Version uint64 `json:"filter_version"`
+}
+
+// MergeFilter unions a peer's filter into the aggregator.  Each address
+// it adds is published as a promoted event, and subscribers receive one
+// push per merge that added anything.
+func (s *SwarmAggregator) MergeFilter(ctx context.Context, region string, peer *BloomFilter) (MergeSummary, error) {
+	sum := MergeSummary{Region: region}
+	if !feedNamePattern.MatchString(region) {
+		return sum, &ValidationError{Field: "region", Reason: fmt.Sprintf("%q is not a valid name", region)}
+	}
+	if err := s.bloomFilter.Compatible(peer); err != nil {
+		return sum, err // checked first so a mismatch leaves no partial state
+	}
+
+	addresses, _ := peer.Snapshot()
+
+	source := mergeSourceID(region)
+	now := s.clock.Now()
+	incoming := bloom.NewBloomFilterWithParams(peer.Params(), 0)
+	var promoted []Event
+
+	s.mu.Lock()
+	for _, addr := range addresses {
+		if s.allowlist[addr] {
+			sum.Skipped++
+			continue
+		}
+		tag := Provenance{Source: source, Region: region, ImportedAt: now}
+		if !s.hasFeedTagLocked(addr, source) {
+			s.feedTags[addr] = append(s.feedTags[addr], tag)
+		}
+		if entry, ok := s.confirmed[addr]; ok {
+			s.refreshExpiryLocked(entry, now)
+			sum.Skipped++
+			continue
+		}
+		entry := &ConfirmedEntry{
+			Address:    addr,
+			Confidence: 1.0,
+			PromotedAt: now,
+			Provenance: tag,
+		}
+		s.confirmed[addr] = entry
+		s.scheduleExpiryLocked(entry, now)
+		incoming.Add(addr)
+		ev := entryEvent(EventPromoted, entry)
+		ev.Source, ev.Tier = source, TierMain
+		promoted = append(promoted, ev)
+		sum.Added++
+	}
+	err := s.bloomFilter.Merge(incoming)
+	s.mu.Unlock()
+	if err != nil {
+		return sum, err
+	}
+
+	sum.Version = s.bloomFilter.Version()
+	s.events.publish(ctx, promoted...)
+	return sum, nil
+}
+
+// handleAdminMerge is the HTTP handler for POST /admin/merge.
+//
+// Query parameter region names the peer (required); reason is recorded in
+// the audit log.  The body is the
+// peer's serialized filter, as served by its GET /filter.
+func (s *SwarmAggregator) handleAdminMerge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, r, err, "Failed to read body")
+		return
+	}
+	peer, err := ParseBloomFilter(body)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	region := r.URL.Query().Get("region")
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditMerge, Subject: region}) {
+		return
+	}
+	sum, err := s.MergeFilter(r.Context(), region, peer)
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sum)
+}
@@ -0,0 +1,87 @@
+package swarm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBloomFilterMerge(t *testing.T) {
+	local, peer := NewBloomFilter(), NewBloomFilter()
+	local.Add("0xShared")
+	peer.Add("0xShared")
+	peer.Add("0xPeerOnly")
+
+	if err := local.Merge(peer); err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+	if !local.Contains("0xPeerOnly") || local.Len() != 2 || local.Version() != 2 {
+		t.Errorf("Expected union of 2 entries at v2, got %d at v%d", local.Len(), local.Version())
+	}
+
+	small := NewBloomFilterWithConfig(BloomConfig{ExpectedItems: 1000, FalsePositiveRate: 0.01}, 0)
+	small.Add("0xElsewhere")
+	err := local.Merge(small)
+	if err == nil || !strings.Contains(err.Error(), "bits") {
+		t.Fatalf("Expected a parameter mismatch error, got %v", err)
+	}
+	if local.Contains("0xElsewhere") || local.Version() != 2 {
+		t.Error("A rejected merge must not change the filter")
+	}
+}
+
+func TestAdminMergeTagsRegionAndPushesOnce(t *testing.T) {
+	agg := NewSwarmAggregator()
+	agg.keys.Add("admin-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	agg.Allow(context.Background(), "0xLocalRouter")
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+	pushes := agg.Subscribe("central")
+
+	eu := NewSwarmAggregator()
+	blockAll(eu, "0xEU1", "0xEU2", "0xLocalRouter")
+	payload, _ := eu.bloomFilter.Serialize()
+
+	merge := func(body []byte) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/admin/merge?region=eu", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := merge(payload)
+	var sum MergeSummary
+	json.NewDecoder(resp.Body).Decode(&sum)
+	if resp.StatusCode != http.StatusOK || sum.Added != 2 || sum.Skipped != 1 {
+		t.Fatalf("Unexpected merge result %d %+v", resp.StatusCode, sum)
+	}
+	if len(pushes) != 1 {
+		t.Errorf("Expected exactly one push per merge, got %d", len(pushes))
+	}
+	if agg.bloomFilter.Contains("0xLocalRouter") {
+		t.Error("Allowlisted address must not be merged")
+	}
+	if entry, _ := agg.Confirmed("0xEU1"); entry.Provenance.Source != "merge:eu" || entry.Provenance.Region != "eu" {
+		t.Errorf("Unexpected provenance %+v", entry.Provenance)
+	}
+
+	other := NewBloomFilterWithConfig(BloomConfig{ExpectedItems: 1000, FalsePositiveRate: 0.01}, 0)
+	other.Add("0xAPAC")
+	mismatched, _ := other.Serialize()
+	if resp := merge(mismatched); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for mismatched parameters, got %d", resp.StatusCode)
+	}
+	if resp := merge([]byte(`{"version":1,"entries":["0xNoParams"]}`)); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a payload without parameters, got %d", resp.StatusCode)
+	}
+	if len(pushes) != 1 {
+		t.Errorf("Rejected merges must not push, got %d pushes", len(pushes))
+	}
+}
@@ -0,0 +1,387 @@
+// Package swarm — Aegis Swarm metrics.
+//
+// Each aggregator owns a Prometheus registry, exposed on GET /metrics.
+// Keeping the registry per instance (rather than the global default) lets
+// tests run several aggregators side by side.
+package swarm
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "aegis"
+
+// Metrics holds the aggregator's collectors.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	reportsIngested prometheus.Counter
+	promotions      prometheus.Counter
+	expired         prometheus.Counter
+	quotaRejections prometheus.Counter
+	ingestShed      prometheus.Counter
+	ingestReplays   prometheus.Counter
+	skewRejections  prometheus.Counter
+
+	invalidAddresses    prometheus.Counter
+	unknownChainReports *prometheus.CounterVec // outcome
+	invalidEvidence     prometheus.Counter
+	sanitizations       *prometheus.CounterVec // rule
+	panics              prometheus.Counter
+
+	busMessages *prometheus.CounterVec // consumer, outcome
+	busLag      *prometheus.GaugeVec   // consumer
+
+	replicationEvents    *prometheus.CounterVec // peer, outcome
+	auditEvents          *prometheus.CounterVec // outcome
+	shadowPromotions     *prometheus.CounterVec // candidate
+	shadowVerdicts       *prometheus.CounterVec // candidate, outcome
+	chunksSuperseded     prometheus.Counter
+	pushRedeliveries     prometheus.Counter
+	pushCacheLookups     *prometheus.CounterVec // result
+	pushCacheHitRatio    prometheus.Histogram
+	promotionLatency     *prometheus.HistogramVec // chain, category
+	burnRatio            *prometheus.GaugeVec     // chain
+	burnRateTripped      *prometheus.GaugeVec     // chain
+	filterRebuilds       prometheus.Counter
+	filterCapHits        *prometheus.CounterVec // policy
+	tripwireHits         *prometheus.CounterVec // list
+	configReloads        *prometheus.CounterVec // outcome
+	stagingGraduations   prometheus.Counter
+	stagingSaves         *prometheus.CounterVec // reason
+	cooldownSuppressions *prometheus.CounterVec // reason
+	eventsPublished      *prometheus.CounterVec // type
+	sanctionsSyncs       *prometheus.CounterVec // feed, outcome
+	evidenceChecks       *prometheus.CounterVec // outcome
+	feedback             *prometheus.CounterVec // outcome
+
+	eventSubscribersDropped prometheus.Counter
+
+	maintenance maintenanceMetrics
+}
+
+// Bus message outcomes recorded in aegis_bus_messages_total.
+const (
+	busOutcomeIngested     = "ingested"
+	busOutcomePromoted     = "promoted"
+	busOutcomeDeadLettered = "dead_lettered"
+	busOutcomeAckFailed    = "ack_failed"
+	busOutcomeRejected     = "rejected" // source banned by quota
+)
+
+// newMetrics builds the registry for s.  Gauges that mirror aggregator
+// state are computed at scrape time.
+func newMetrics(s *SwarmAggregator) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		reportsIngested: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reports_ingested_total",
+			Help:      "IOC reports processed by IngestReport.",
+		}),
+		promotions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "promotions_total",
+			Help:      "Addresses promoted into the filter by consensus.",
+		}),
+		expired: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "expired_total",
+			Help:      "Confirmed addresses removed after their TTL lapsed.",
+		}),
+		quotaRejections: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "quota_rejections_total",
+			Help:      "Reports rejected because their source is banned.",
+		}),
+		ingestShed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "ingest_shed_total",
+			Help:      "Reports rejected with 429 because the ingest queue was full.",
+		}),
+		ingestReplays: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "ingest_replays_total",
+			Help:      "Retried reports answered from the idempotency cache without being processed again.",
+		}),
+		skewRejections: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "skew_rejections_total",
+			Help:      "Reports rejected because their timestamp is too far in the future.",
+		}),
+		invalidAddresses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "invalid_addresses_total",
+			Help:      "Reports rejected because the address is not valid for its chain.",
+		}),
+		unknownChainReports: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "unknown_chain_reports_total",
+			Help:      "Reports for chains outside the registry, by whether they were accepted or rejected.",
+		}, []string{"outcome"}),
+		invalidEvidence: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "invalid_evidence_total",
+			Help:      "Reports rejected because their evidence is invalid.",
+		}),
+		sanitizations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "report_sanitizations_total",
+			Help:      "Reports rejected or rewritten before storage, by sanitization rule.",
+		}, []string{"rule"}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "panics_total",
+			Help:      "Handler panics recovered into a 500.",
+		}),
+		busMessages: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bus_messages_total",
+			Help:      "Messages consumed from the message bus, by outcome.",
+		}, []string{"consumer", "outcome"}),
+		busLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "bus_lag_messages",
+			Help:      "Messages waiting on the message bus for this consumer.",
+		}, []string{"consumer"}),
+		replicationEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "replication_events_total",
+			Help:      "Promotions sent to peers, by peer URL, and received from them, by origin instance.",
+		}, []string{"peer", "outcome"}),
+		auditEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "audit_events_total",
+			Help:      "Audit log events, by whether they were written, failed, or dropped from a full queue.",
+		}, []string{"outcome"}),
+		shadowPromotions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "shadow_promotions_total",
+			Help:      "Addresses each shadow candidate's thresholds would have promoted.",
+		}, []string{"candidate"}),
+		shadowVerdicts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "shadow_verdicts_total",
+			Help:      "Shadow candidate verdicts, by whether they agreed with the primary thresholds.",
+		}, []string{"candidate", "outcome"}),
+		chunksSuperseded: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "push_chunks_superseded_total",
+			Help:      "Chunked pushes abandoned part-way because a newer push was queued for the subscriber.",
+		}),
+		pushRedeliveries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "push_redeliveries_total",
+			Help:      "Filters sent again to acknowledging subscribers that had not acknowledged the latest version in time.",
+		}),
+		pushCacheLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "push_cache_lookups_total",
+			Help:      "Push payloads offered to subscribers, by whether they were already encoded (hit) or encoded for them (miss).",
+		}, []string{"result"}),
+		pushCacheHitRatio: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "push_cache_hit_ratio",
+			Help:      "Fraction of each push's subscribers served an already encoded payload.",
+			Buckets:   []float64{0, 0.5, 0.75, 0.9, 0.95, 0.99, 1},
+		}),
+		burnRatio: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "promotion_burn_ratio",
+			Help:      "Each chain's promotion rate over alerts.burn_rate.short_window as a multiple of its rate over the long window before it.",
+		}, []string{"chain"}),
+		burnRateTripped: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "promotion_burn_rate_tripped",
+			Help:      "1 while a chain's promotion burn-rate alert is unacknowledged.",
+		}, []string{"chain"}),
+		promotionLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "promotion_latency_seconds",
+			Help:      "Time from the first report of a promoted address to the push carrying it, by chain and category.",
+			Buckets:   []float64{30, 60, 120, 300, 600, 900, 1800, 3600, 7200, 21600, 86400},
+		}, []string{"chain", "category"}),
+		filterRebuilds: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "filter_rebuilds_total",
+			Help:      "Rebuilds of the global filter from the confirmed set.",
+		}),
+		filterCapHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "filter_cap_hits_total",
+			Help:      "Consensus promotions that found the confirmed set at bloom.max_filter_entries, by the overflow policy applied.",
+		}, []string{"policy"}),
+		tripwireHits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tripwire_hits_total",
+			Help:      "Consensus promotions queued for review as near matches of a protected address, by the list it is on.",
+		}, []string{"list"}),
+		configReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "config_reloads_total",
+			Help:      "Configuration reloads, by whether they were applied or rejected as invalid.",
+		}, []string{"outcome"}),
+		stagingGraduations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "staging_graduations_total",
+			Help:      "Staged addresses promoted to the main filter at the end of their soak period.",
+		}),
+		stagingSaves: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "staging_saves_total",
+			Help:      "Staged addresses kept out of the main filter during their soak period, by why.",
+		}, []string{"reason"}),
+		cooldownSuppressions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "cooldown_suppressions_total",
+			Help:      "Promotions withheld because the address is cooling down after a removal, by why it was removed.",
+		}, []string{"reason"}),
+		eventsPublished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "events_published_total",
+			Help:      "Events published to the event stream, by type.",
+		}, []string{"type"}),
+		sanctionsSyncs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sanctions_syncs_total",
+			Help:      "Sanctions feed syncs, by feed and whether they were applied or failed.",
+		}, []string{"feed", "outcome"}),
+		evidenceChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "evidence_verifications_total",
+			Help:      "Transaction hash evidence checks, by outcome: a verification status, error, or dropped.",
+		}, []string{"outcome"}),
+		feedback: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "feedback_total",
+			Help:      "Subscriber disputes of flagged addresses, by outcome: recorded, duplicate, or escalated.",
+		}, []string{"outcome"}),
+		eventSubscribersDropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "event_subscribers_dropped_total",
+			Help:      "Event stream connections closed for falling events.subscriber_buffer events behind.",
+		}),
+		maintenance: maintenanceMetrics{
+			durations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+				Namespace: metricsNamespace,
+				Name:      "maintenance_task_duration_seconds",
+				Help:      "Time taken by background maintenance tasks that finished within their budget.",
+				Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+			}, []string{"task"}),
+			items: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "maintenance_items_total",
+				Help:      "Entries examined by background maintenance tasks.",
+			}, []string{"task"}),
+			failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "maintenance_failures_total",
+				Help:      "Maintenance task runs that panicked, overran their budget, or were skipped while still running.",
+			}, []string{"task", "reason"}),
+		},
+	}
+
+	m.registry.MustRegister(
+		m.reportsIngested,
+		m.promotions,
+		m.expired,
+		m.quotaRejections,
+		m.ingestShed,
+		m.ingestReplays,
+		m.skewRejections,
+		m.invalidAddresses,
+		m.unknownChainReports,
+		m.invalidEvidence,
+		m.sanitizations,
+		m.panics,
+		m.busMessages,
+		m.busLag,
+		m.replicationEvents,
+		m.auditEvents,
+		m.shadowPromotions,
+		m.shadowVerdicts,
+		m.chunksSuperseded,
+		m.pushRedeliveries,
+		m.pushCacheLookups,
+		m.pushCacheHitRatio,
+		m.promotionLatency,
+		m.burnRatio,
+		m.burnRateTripped,
+		m.filterRebuilds,
+		m.filterCapHits,
+		m.tripwireHits,
+		m.configReloads,
+		m.stagingGraduations,
+		m.stagingSaves,
+		m.cooldownSuppressions,
+		m.eventsPublished,
+		m.sanctionsSyncs,
+		m.evidenceChecks,
+		m.feedback,
+		m.eventSubscribersDropped,
+		m.maintenance.durations,
+		m.maintenance.items,
+		m.maintenance.failures,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "filter_entries",
+			Help:      "Addresses currently in the filter.",
+		}, func() float64 { return float64(s.bloomFilter.Len()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "filter_version",
+			Help:      "Current filter version.",
+		}, func() float64 { return float64(s.bloomFilter.Version()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "subscribers",
+			Help:      "Connected push subscribers.",
+		}, func() float64 { return float64(s.subscribers.len()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "subscriber_evictions_total",
+			Help:        "Push subscribers closed by the server, by reason: slow, for falling behind, or replaced, by a subscription with the same ID.",
+			ConstLabels: prometheus.Labels{"reason": "slow"},
+		}, func() float64 { evicted, _ := s.subscriberEvictions(); return float64(evicted) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   metricsNamespace,
+			Name:        "subscriber_evictions_total",
+			Help:        "Push subscribers closed by the server, by reason: slow, for falling behind, or replaced, by a subscription with the same ID.",
+			ConstLabels: prometheus.Labels{"reason": "replaced"},
+		}, func() float64 { _, replaced := s.subscriberEvictions(); return float64(replaced) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "review_queue_depth",
+			Help:      "Addresses that met consensus awaiting an analyst's decision.",
+		}, func() float64 { return float64(s.review.len()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "ingest_queue_depth",
+			Help:      "Reports waiting for an ingest worker.",
+		}, func() float64 {
+			if s.ingest == nil {
+				return 0
+			}
+			return float64(len(s.ingest.jobs))
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "ingest_worker_utilization",
+			Help:      "Fraction of ingest workers processing a report.",
+		}, func() float64 {
+			if s.ingest == nil || s.ingest.workers == 0 {
+				return 0
+			}
+			return float64(s.ingest.busy.Load()) / float64(s.ingest.workers)
+		}),
+	)
+	return m
+}
+
+// handleMetrics is the HTTP handler for GET /metrics.
+func (s *SwarmAggregator) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	promhttp.HandlerFor(s.metrics.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
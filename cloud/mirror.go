@@ -0,0 +1,232 @@
+// Package swarm — Read-only mirror mode.
+//
+// An aggregator started with mirror.upstream (--mirror) is a cheap read
+// replica for edge locations.  It subscribes to the upstream aggregator
+// through its public protocol, with the Go SDK: the /ws subscription
+// keeps the filter in sync, reconnecting with backoff and resuming from
+// the last upstream version, and is replaced by a resync snapshot when
+// the upstream restarts into another epoch.  Each update is diffed
+// against the confirmed set and applied as one local push, publishing a
+// promoted or removed event for each address it changes.  The /events
+// stream supplies what the filter lacks, the chain, category, confidence
+// and promotion time of each entry; an entry whose promotion predates the
+// mirror carries only its address.  mirror.api_key is presented upstream,
+// and needs the subscriber role for the event stream.
+//
+// The mirror serves /filter, /check, /filter/version, WebSocket and SSE
+// subscriptions, and the other read endpoints from its own copy, with its
+// own versions, instance UUID and epoch.  It never judges reports: the
+// ingest, admin and replication routes answer 501 with code
+// read_only_mirror, naming the upstream in the message and in the
+// X-Aegis-Upstream header.  GET /health reports, under mirror, the
+// upstream version and epoch last applied and staleness_seconds, the time
+// since the last upstream update (or since the mirror started, before
+// the first).
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aegis-protocol/swarm/client"
+)
+
+// provenanceMirror is the Source tag for entries copied from upstream.
+const provenanceMirror = "mirror"
+
+// headerUpstream names the upstream in a mirror's read-only refusals.
+const headerUpstream = "X-Aegis-Upstream"
+
+// Enabled reports whether the aggregator runs as a mirror.
+func (c MirrorConfig) Enabled() bool { return c.Upstream != "" }
+
+// MirrorHealth is the mirror object of GET /health.
+type MirrorHealth struct {
+	Upstream         string     `json:"upstream"`
+	Synced           bool       `json:"synced"`
+	UpstreamVersion  uint64     `json:"upstream_version"`
+	UpstreamEpoch    uint64     `json:"upstream_epoch"`
+	UpstreamInstance string     `json:"upstream_instance_uuid,omitempty"`
+	UpdatedAt        *time.Time `json:"last_update_at,omitempty"`
+	StalenessSeconds float64    `json:"staleness_seconds"`
+}
+
+// mirrorState tracks the upstream a mirror follows.
+type mirrorState struct {
+	upstream string
+
+	mu        sync.Mutex
+	started   time.Time // by StartMirror
+	updatedAt time.Time // of the last applied update, zero before the first
+	version   uint64    // upstream version last applied
+	epoch     uint64
+	instance  string                  // upstream instance UUID
+	meta      map[string]client.Event // address -> its upstream promotion
+}
+
+func newMirrorState(cfg MirrorConfig) *mirrorState {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return &mirrorState{upstream: cfg.Upstream, meta: make(map[string]client.Event)}
+}
+
+// health reports the mirror's staleness at now.
+func (m *mirrorState) health(now time.Time) MirrorHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := MirrorHealth{
+		Upstream:         m.upstream,
+		Synced:           !m.updatedAt.IsZero(),
+		UpstreamVersion:  m.version,
+		UpstreamEpoch:    m.epoch,
+		UpstreamInstance: m.instance,
+	}
+	since := m.started
+	if h.Synced {
+		updated := m.updatedAt
+		h.UpdatedAt, since = &updated, updated
+	}
+	h.StalenessSeconds = max(now.Sub(since).Seconds(), 0)
+	return h
+}
+
+// entry returns the confirmed entry of an address copied at now, with
+// its upstream promotion's details if the event stream delivered them.
+func (m *mirrorState) entry(address string, now time.Time) *ConfirmedEntry {
+	m.mu.Lock()
+	ev, ok := m.meta[address]
+	instance := m.instance
+	m.mu.Unlock()
+	entry := &ConfirmedEntry{
+		Address:    address,
+		PromotedAt: now,
+		Provenance: Provenance{Source: provenanceMirror, Instance: instance, ImportedAt: now},
+	}
+	if ok {
+		entry.ChainID, entry.Category, entry.Confidence = ev.ChainID, ev.Category, ev.Confidence
+		entry.PromotedAt, entry.Provenance.Category = ev.Time, ev.Category
+	}
+	return entry
+}
+
+// StartMirror subscribes to the upstream and keeps the local filter in
+// sync with it until ctx is done.  It returns an error if the first
+// connection fails; later ones are retried.
+func (s *SwarmAggregator) StartMirror(ctx context.Context) error {
+	s.mirror.mu.Lock()
+	s.mirror.started = s.clock.Now()
+	s.mirror.mu.Unlock()
+	c, err := client.New(client.Config{BaseURL: s.config.Mirror.Upstream, APIKey: s.config.Mirror.APIKey})
+	if err != nil {
+		return err
+	}
+	if events, err := c.Events(ctx, client.EventsOptions{Types: []string{string(EventPromoted), string(EventRemoved), string(EventExpired), string(EventRetracted)}}); err != nil {
+		log.Printf("Mirroring %s without entry details: %v", s.config.Mirror.Upstream, err)
+	} else {
+		go func() {
+			for ev := range events {
+				s.applyMirrorEvent(ev)
+			}
+		}()
+	}
+	updates, err := c.Watch(ctx)
+	if err != nil {
+		return fmt.Errorf("subscribe to upstream %s: %w", s.config.Mirror.Upstream, err)
+	}
+	go func() {
+		for u := range updates {
+			s.applyMirrorUpdate(ctx, u)
+		}
+	}()
+	return nil
+}
+
+// applyMirrorUpdate makes the confirmed set that of an upstream update,
+// and the filter's parameters the upstream's.
+func (s *SwarmAggregator) applyMirrorUpdate(ctx context.Context, u client.FilterUpdate) {
+	now := s.clock.Now()
+	want := make(map[string]bool, len(u.Entries))
+	for _, addr := range u.Entries {
+		want[addr] = true
+	}
+
+	s.mirror.mu.Lock()
+	s.mirror.updatedAt, s.mirror.version, s.mirror.epoch, s.mirror.instance = now, u.Version, u.Epoch, u.InstanceUUID
+	s.mirror.mu.Unlock()
+
+	var changes []Event
+	s.mu.Lock()
+	for addr, entry := range s.confirmed {
+		if !want[addr] {
+			delete(s.confirmed, addr)
+			s.filterRemoveLocked(entry)
+			ev := entryEvent(EventRemoved, entry)
+			ev.FromTier, ev.Reason = TierMain, eventReasonUpstream
+			changes = append(changes, ev)
+		}
+	}
+	for addr := range want {
+		if _, ok := s.confirmed[addr]; !ok {
+			entry := s.mirror.entry(addr, now)
+			s.confirmed[addr] = entry
+			s.filterAddLocked(entry)
+			ev := entryEvent(EventPromoted, entry)
+			ev.Source, ev.Tier = provenanceMirror, TierMain
+			changes = append(changes, ev)
+		}
+	}
+	s.mu.Unlock()
+
+	s.mirror.mu.Lock()
+	for _, ev := range changes {
+		if ev.Type == EventRemoved {
+			delete(s.mirror.meta, ev.Address)
+		}
+	}
+	s.mirror.mu.Unlock()
+
+	if params := BloomParams(u.Params); params.Bits != 0 && params != s.bloomFilter.Params() {
+		if _, err := s.rebuildFilter(ctx, params); err != nil {
+			log.Printf("Failed to take upstream filter parameters %+v: %v", params, err)
+		} else {
+			for i := range changes {
+				changes[i].pushed = true // the rebuild pushed
+			}
+		}
+	}
+	s.events.publish(ctx, changes...)
+}
+
+// applyMirrorEvent records the details of an upstream promotion, filling
+// them in on an entry already copied without them.
+func (s *SwarmAggregator) applyMirrorEvent(ev client.Event) {
+	if ev.Address == "" || ev.Tier == string(TierStaging) {
+		return
+	}
+	if EventType(ev.Type) != EventPromoted {
+		s.mirror.mu.Lock()
+		delete(s.mirror.meta, ev.Address)
+		s.mirror.mu.Unlock()
+		return
+	}
+	s.mirror.mu.Lock()
+	s.mirror.meta[ev.Address] = ev
+	s.mirror.mu.Unlock()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.confirmed[ev.Address]; ok && entry.ChainID == 0 && entry.Category == "" {
+		s.confirmed[ev.Address] = s.mirror.entry(ev.Address, entry.Provenance.ImportedAt)
+	}
+}
+
+// mirrorReadOnly answers requests a mirror leaves to its upstream.
+func (s *SwarmAggregator) mirrorReadOnly(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(headerUpstream, s.config.Mirror.Upstream)
+	writeError(w, r, http.StatusNotImplemented, CodeReadOnlyMirror, fmt.Sprintf("This aggregator is a read-only mirror; send %s to %s", r.URL.Path, s.config.Mirror.Upstream))
+}
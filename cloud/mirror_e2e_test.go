@@ -0,0 +1,139 @@
+package swarm_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+// startMirrorPair starts a primary processing reports inline and a
+// mirror of it, subscribed with the aegistest subscriber key.
+func startMirrorPair(t *testing.T) (primary, mirror *aegistest.Aggregator) {
+	primary = aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) { cfg.Ingest.Synchronous = true }})
+	mirror = aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.Mirror = swarm.MirrorConfig{Upstream: primary.URL, APIKey: aegistest.SubscriberKey}
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := mirror.StartMirror(ctx); err != nil {
+		t.Fatalf("StartMirror: %v", err)
+	}
+	return primary, mirror
+}
+
+// checkMirror polls the mirror's /check until address is flagged as want.
+func checkMirror(t *testing.T, mirror *aegistest.Aggregator, address string, want bool) map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, data := mirror.Do(http.MethodGet, "/check?address="+address, "")
+		var resp map[string]interface{}
+		json.Unmarshal(data, &resp)
+		if resp["flagged"] == want {
+			return resp
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %s flagged=%v on the mirror, got %s", address, want, data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMirrorFollowsPrimary(t *testing.T) {
+	primary, mirror := startMirrorPair(t)
+	addr := aegistest.Address("mirrored")
+	primary.Report(swarm.IOCReport{Address: addr, Category: "drainer", SourceID: "agent-A"})
+	primary.Report(swarm.IOCReport{Address: addr, Category: "drainer", SourceID: "agent-B"})
+	primary.Advance(2 * time.Minute)
+	primary.Report(swarm.IOCReport{Address: addr, Category: "drainer", SourceID: "agent-A"})
+	if !check(t, primary, addr).Flagged {
+		t.Fatal("Expected the primary to promote the address")
+	}
+
+	resp := checkMirror(t, mirror, addr, true)
+	if resp["tier"] != string(swarm.TierMain) {
+		t.Errorf("Expected the mirror to hold the address in its main filter, got %v", resp)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if entry, ok := mirror.Confirmed(addr); ok && entry.ChainID == 1 && entry.Category == "drainer" {
+			if entry.Provenance.Source != "mirror" || entry.Provenance.Instance != primary.Identity().InstanceUUID {
+				t.Errorf("Expected mirror provenance naming the primary, got %+v", entry.Provenance)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			entry, _ := mirror.Confirmed(addr)
+			t.Fatalf("Expected the promotion's details on the mirror, got %+v", entry)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	primary.Unblock(context.Background(), addr)
+	checkMirror(t, mirror, addr, false)
+
+	// A dropped subscription reconnects and resumes.
+	primary.DropSubscriptions("aegistest-subscriber")
+	other := aegistest.Address("after-reconnect")
+	primary.Block(context.Background(), swarm.AdminAction{Address: other, ChainID: 1})
+	checkMirror(t, mirror, other, true)
+}
+
+func TestMirrorRejectsWrites(t *testing.T) {
+	primary, mirror := startMirrorPair(t)
+	for _, path := range []string{"/ingest", "/ingest/batch", "/admin/block"} {
+		req, _ := http.NewRequest(http.MethodPost, mirror.URL+path, strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer "+aegistest.AdminKey)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body swarm.ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotImplemented || body.Error.Code != swarm.CodeReadOnlyMirror || resp.Header.Get("X-Aegis-Upstream") != primary.URL || !strings.Contains(body.Error.Message, primary.URL) {
+			t.Errorf("POST %s: expected 501 pointing at %s, got %d %+v %v", path, primary.URL, resp.StatusCode, body, resp.Header)
+		}
+	}
+	if status, _ := mirror.Do(http.MethodGet, "/filter/version", ""); status != http.StatusOK {
+		t.Errorf("Expected the mirror to serve /filter/version, got %d", status)
+	}
+}
+
+func TestMirrorHealthReportsStaleness(t *testing.T) {
+	primary, mirror := startMirrorPair(t)
+	var health struct {
+		Mirror *swarm.MirrorHealth `json:"mirror"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for health.Mirror == nil || !health.Mirror.Synced {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the mirror synced, got %+v", health.Mirror)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, data := mirror.Do(http.MethodGet, "/health", "")
+		json.Unmarshal(data, &health)
+	}
+	if health.Mirror.Upstream != primary.URL || health.Mirror.UpstreamEpoch != primary.Identity().Epoch || health.Mirror.StalenessSeconds != 0 {
+		t.Errorf("Expected a fresh mirror of the primary, got %+v", health.Mirror)
+	}
+
+	mirror.Clock.Advance(90 * time.Second)
+	_, data := mirror.Do(http.MethodGet, "/health", "")
+	json.Unmarshal(data, &health)
+	if health.Mirror.StalenessSeconds != 90 {
+		t.Errorf("Expected 90s of staleness, got %+v", health.Mirror)
+	}
+
+	cfg := swarm.DefaultConfig()
+	cfg.Mirror.Upstream = "ftp://primary"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "mirror.upstream") {
+		t.Errorf("Expected a non-http upstream rejected, got %v", err)
+	}
+}
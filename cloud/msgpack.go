@@ -0,0 +1,565 @@
+// Package swarm — MessagePack encoding.
+//
+// MessagePack (msgpack.org) is offered alongside JSON wherever a client
+// may negotiate the encoding (see encoding.go).  Its maps carry the JSON
+// field names and its values the JSON values, so a msgpack document is
+// the JSON one re-encoded: most types are marshaled by way of their JSON
+// form.  The filter payloads and the envelope, pushed to every subscriber,
+// are written directly instead; an envelope's payload and chunk are bin
+// values, so the payload is signed and checksummed over the exact bytes
+// a client receives.
+//
+// Decoding is the reverse trip: a msgpack body is converted to JSON and
+// handed to the JSON decoder, so it is accepted exactly when the
+// equivalent JSON would be.  The timestamp extension (type -1) decodes to
+// an RFC 3339 time.
+package swarm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// msgpackMaxDepth bounds the nesting msgpackDecode accepts.
+const msgpackMaxDepth = 64
+
+// msgpackTimestamp is the extension type of the msgpack timestamp.
+const msgpackTimestamp = -1
+
+var errMsgpackTruncated = errors.New("msgpack: unexpected end of data")
+
+// msgpackAppender is implemented by types written to msgpack directly
+// rather than by way of JSON.
+type msgpackAppender interface {
+	appendMsgpack(b []byte) []byte
+}
+
+// marshalMsgpack encodes v as msgpack.
+func marshalMsgpack(v any) ([]byte, error) {
+	if a, ok := v.(msgpackAppender); ok {
+		return a.appendMsgpack(nil), nil
+	}
+	if env, ok := v.(FilterEnvelope); ok {
+		return appendMsgpackEnvelope(nil, env), nil
+	}
+	return appendMsgpackJSON(nil, v)
+}
+
+// appendMsgpackJSON appends v as the msgpack of its JSON encoding.
+func appendMsgpackJSON(b []byte, v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	return appendMsgpackValue(b, doc), nil
+}
+
+// appendMsgpackValue appends a decoded JSON value.  Object keys are
+// written sorted, as encoding/json writes map keys.
+func appendMsgpackValue(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return appendMsgpackNil(b)
+	case bool:
+		return appendMsgpackBool(b, v)
+	case string:
+		return appendMsgpackString(b, v)
+	case json.Number:
+		if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return appendMsgpackInt(b, n)
+		}
+		if n, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return appendMsgpackUint(b, n)
+		}
+		f, _ := v.Float64()
+		return appendMsgpackFloat(b, f)
+	case []any:
+		b = appendMsgpackArrayHeader(b, len(v))
+		for _, e := range v {
+			b = appendMsgpackValue(b, e)
+		}
+		return b
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		b = appendMsgpackMapHeader(b, len(v))
+		for _, k := range keys {
+			b = appendMsgpackString(b, k)
+			b = appendMsgpackValue(b, v[k])
+		}
+		return b
+	}
+	panic(fmt.Sprintf("msgpack: unexpected JSON value %T", v))
+}
+
+func appendMsgpackNil(b []byte) []byte { return append(b, 0xc0) }
+
+func appendMsgpackBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+func appendMsgpackUint(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<7:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xcd), uint16(v))
+	case v <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), v)
+}
+
+func appendMsgpackInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return appendMsgpackUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(v))
+	case v >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(v))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(v))
+}
+
+func appendMsgpackFloat(b []byte, v float64) []byte {
+	return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+func appendMsgpackString(b []byte, v string) []byte {
+	n := len(v)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, v...)
+}
+
+func appendMsgpackBin(b []byte, v []byte) []byte {
+	n := len(v)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xc5), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, v...)
+}
+
+func appendMsgpackArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func appendMsgpackMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+// appendMsgpack writes the payload with the fields of its JSON encoding.
+func (p FilterPayload) appendMsgpack(b []byte) []byte {
+	size := 128
+	for _, e := range p.Entries {
+		size += len(e) + 5
+	}
+	b = slices.Grow(b, size)
+	b = appendMsgpackMapHeader(b, 7)
+	b = appendMsgpackString(b, "format_version")
+	b = appendMsgpackInt(b, int64(p.FormatVersion))
+	b = appendMsgpackString(b, "version")
+	b = appendMsgpackUint(b, p.Version)
+	b = appendMsgpackString(b, "entries")
+	if p.Entries == nil {
+		b = appendMsgpackNil(b)
+	} else {
+		b = appendMsgpackArrayHeader(b, len(p.Entries))
+		for _, e := range p.Entries {
+			b = appendMsgpackString(b, e)
+		}
+	}
+	b = appendMsgpackString(b, "count")
+	b = appendMsgpackInt(b, int64(p.Count))
+	b = appendMsgpackString(b, "bits")
+	b = appendMsgpackUint(b, p.Bits)
+	b = appendMsgpackString(b, "hashes")
+	b = appendMsgpackUint(b, uint64(p.Hashes))
+	b = appendMsgpackString(b, "hash")
+	return appendMsgpackString(b, p.Hash)
+}
+
+// appendMsgpack writes the payload with the fields of its JSON encoding.
+func (p ExactPayload) appendMsgpack(b []byte) []byte {
+	b = appendMsgpackMapHeader(b, 4)
+	b = appendMsgpackString(b, "version")
+	b = appendMsgpackUint(b, p.Version)
+	b = appendMsgpackString(b, "count")
+	b = appendMsgpackInt(b, int64(p.Count))
+	b = appendMsgpackString(b, "encoding")
+	b = appendMsgpackString(b, p.Encoding)
+	b = appendMsgpackString(b, "chunks")
+	b = appendMsgpackArrayHeader(b, len(p.Chunks))
+	for _, c := range p.Chunks {
+		b = appendMsgpackMapHeader(b, 5)
+		b = appendMsgpackString(b, "entries")
+		b = appendMsgpackInt(b, int64(c.Entries))
+		b = appendMsgpackString(b, "first")
+		b = appendMsgpackString(b, c.First)
+		b = appendMsgpackString(b, "last")
+		b = appendMsgpackString(b, c.Last)
+		b = appendMsgpackString(b, "data")
+		b = appendMsgpackString(b, c.Data)
+		b = appendMsgpackString(b, "scores")
+		b = appendMsgpackArrayHeader(b, len(c.Scores))
+		for _, score := range c.Scores {
+			b = appendMsgpackFloat(b, score)
+		}
+	}
+	return b
+}
+
+// appendMsgpackEnvelope appends env with the fields of its JSON encoding,
+// leaving out the same empty ones.  Payload and Chunk are bin values.
+func appendMsgpackEnvelope(b []byte, env FilterEnvelope) []byte {
+	type field struct {
+		name  string
+		value func([]byte) []byte
+		set   bool
+	}
+	str := func(v string) func([]byte) []byte {
+		return func(b []byte) []byte { return appendMsgpackString(b, v) }
+	}
+	num := func(v uint64) func([]byte) []byte {
+		return func(b []byte) []byte { return appendMsgpackUint(b, v) }
+	}
+	signed := func(v int) func([]byte) []byte {
+		return func(b []byte) []byte { return appendMsgpackInt(b, int64(v)) }
+	}
+	flag := func(b []byte) []byte { return appendMsgpackBool(b, true) }
+	bin := func(v []byte) func([]byte) []byte {
+		return func(b []byte) []byte { return appendMsgpackBin(b, v) }
+	}
+	fields := []field{
+		{"kind", str(env.Kind), true},
+		{"version", num(env.Version), true},
+		{"from_version", num(env.FromVersion), true},
+		{"to_version", num(env.ToVersion), true},
+		{"resync", flag, env.Resync},
+		{"rebuild", flag, env.Rebuild},
+		{"instance", str(env.Instance), env.Instance != ""},
+		{"logical_version", num(env.LogicalVersion), env.LogicalVersion != 0},
+		{"instance_uuid", str(env.InstanceUUID), env.InstanceUUID != ""},
+		{"epoch", num(env.Epoch), env.Epoch != 0},
+		{"key_id", str(env.KeyID), true},
+		{"signature", str(env.Signature), true},
+		{"payload", bin(env.Payload), len(env.Payload) > 0},
+		{"payload_crc32c", str(env.PayloadChecksum), env.PayloadChecksum != ""},
+		{"summary", func(b []byte) []byte {
+			out, err := appendMsgpackJSON(b, env.Summary)
+			if err != nil {
+				return appendMsgpackNil(b) // a summary always encodes
+			}
+			return out
+		}, env.Summary != nil},
+		{"chunk_index", signed(env.ChunkIndex), env.ChunkIndex != 0},
+		{"chunk_count", signed(env.ChunkCount), env.ChunkCount != 0},
+		{"checksum", str(env.Checksum), env.Checksum != ""},
+		{"chunk", bin(env.Chunk), len(env.Chunk) > 0},
+	}
+	n := 0
+	for _, f := range fields {
+		if f.set {
+			n++
+		}
+	}
+	b = appendMsgpackMapHeader(b, n)
+	for _, f := range fields {
+		if f.set {
+			b = f.value(appendMsgpackString(b, f.name))
+		}
+	}
+	return b
+}
+
+// msgpackDecode decodes one msgpack document into nil, bool, int64
+// (uint64 above its range), float64, string, []byte, time.Time, []any and
+// map[string]any values.  Bin values alias data.
+func msgpackDecode(data []byte) (any, error) {
+	d := msgpackDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, errors.New("msgpack: trailing data after document")
+	}
+	return v, nil
+}
+
+// msgpackToJSON re-encodes a msgpack document as JSON.
+func msgpackToJSON(data []byte) ([]byte, error) {
+	v, err := msgpackDecode(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *msgpackDecoder) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.pos < n {
+		return nil, errMsgpackTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+// length reads a big-endian length of size bytes.
+func (d *msgpackDecoder) length(size int) (int, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int(b[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(b)), nil
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(n) > uint64(len(d.data)) {
+		return 0, errMsgpackTruncated // every element takes at least a byte
+	}
+	return int(n), nil
+}
+
+func (d *msgpackDecoder) value(depth int) (any, error) {
+	if depth > msgpackMaxDepth {
+		return nil, errors.New("msgpack: document nested too deeply")
+	}
+	head, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := head[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return d.mapOf(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return d.arrayOf(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return d.str(int(c & 0x1f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := d.length(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return d.next(n)
+	case 0xc7, 0xc8, 0xc9:
+		n, err := d.length(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return d.ext(n)
+	case 0xca:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), nil
+	case 0xcb:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		b, err := d.next(1 << (c - 0xcc))
+		if err != nil {
+			return nil, err
+		}
+		n := bigEndian(b)
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		b, err := d.next(size)
+		if err != nil {
+			return nil, err
+		}
+		shift := 64 - 8*size
+		return int64(bigEndian(b)<<shift) >> shift, nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return d.ext(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, err := d.length(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return d.str(n)
+	case 0xdc, 0xdd:
+		n, err := d.length(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.arrayOf(n, depth)
+	case 0xde, 0xdf:
+		n, err := d.length(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.mapOf(n, depth)
+	}
+	return nil, fmt.Errorf("msgpack: invalid type byte 0x%02x", c)
+}
+
+func bigEndian(b []byte) uint64 {
+	var n uint64
+	for _, c := range b {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+func (d *msgpackDecoder) str(n int) (string, error) {
+	b, err := d.next(n)
+	return string(b), err
+}
+
+func (d *msgpackDecoder) arrayOf(n, depth int) ([]any, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	out := make([]any, n)
+	for i := range out {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = v
+	}
+	return out, nil
+}
+
+func (d *msgpackDecoder) mapOf(n, depth int) (map[string]any, error) {
+	if n > len(d.data)-d.pos {
+		return nil, errMsgpackTruncated
+	}
+	out := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		k, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("msgpack: map key is %T, want string", k)
+		}
+		if out[key], err = d.value(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// ext decodes an extension value of n data bytes; only the timestamp is
+// understood.
+func (d *msgpackDecoder) ext(n int) (any, error) {
+	typ, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(typ[0]) != msgpackTimestamp {
+		return nil, fmt.Errorf("msgpack: unsupported extension type %d", int8(typ[0]))
+	}
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(b)), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(b)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)).UTC(), nil
+	case 12:
+		return time.Unix(int64(binary.BigEndian.Uint64(b[4:])), int64(binary.BigEndian.Uint32(b))).UTC(), nil
+	}
+	return nil, fmt.Errorf("msgpack: invalid timestamp of %d bytes", n)
+}
+
+// msgpackUint returns a decoded integer as a uint64, zero if it is not a
+// non-negative integer.
+func msgpackUint(v any) uint64 {
+	switch v := v.(type) {
+	case int64:
+		if v > 0 {
+			return uint64(v)
+		}
+	case uint64:
+		return v
+	}
+	return 0
+}
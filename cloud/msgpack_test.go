@@ -0,0 +1,117 @@
+package swarm
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMsgpackRoundTripsJSONValues(t *testing.T) {
+	for _, v := range []any{
+		nil, true, false,
+		int64(0), int64(127), int64(128), int64(-1), int64(-32), int64(-33), int64(-129), int64(math.MinInt64),
+		int64(math.MaxUint16 + 1), uint64(math.MaxUint64), 0.5,
+		"", strings.Repeat("x", 31), strings.Repeat("x", 32), strings.Repeat("x", 256), strings.Repeat("x", math.MaxUint16+1),
+		[]any{int64(1), "two", []any{}},
+		map[string]any{"a": int64(1), "b": map[string]any{"c": nil}},
+		make([]any, 16),
+	} {
+		b, err := appendMsgpackJSON(nil, v)
+		if err != nil {
+			t.Fatalf("Encoding %v: %v", v, err)
+		}
+		got, err := msgpackDecode(b)
+		if err != nil || !reflect.DeepEqual(got, v) {
+			t.Errorf("Expected %v back, got %v (%v)", v, got, err)
+		}
+	}
+}
+
+func TestMsgpackFilterPayloadMatchesJSON(t *testing.T) {
+	p := FilterPayload{FormatVersion: BloomFormatVersion, Version: 7, Entries: []string{evmAddress("a"), evmAddress("b")}, Count: 2, BloomParams: BloomParams{Bits: 1 << 20, Hashes: 7, Hash: HashFNV1a}}
+	direct, err := msgpackToJSON(p.appendMsgpack(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := json.Marshal(p)
+	var a, b any
+	json.Unmarshal(direct, &a)
+	json.Unmarshal(want, &b)
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("Expected the JSON fields\n%s\ngot\n%s", want, direct)
+	}
+}
+
+func TestMsgpackEnvelopeCarriesPayloadAsBin(t *testing.T) {
+	payload := []byte{0x81, 0xa1, 'k', 0x01}
+	env := FilterEnvelope{Kind: envelopeSnapshot, Version: 3, ToVersion: 3, KeyID: "k1", Signature: "sig", Payload: payload, PayloadChecksum: payloadChecksum(payload), Resync: true}
+	doc, err := msgpackDecode(appendMsgpackEnvelope(nil, env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := doc.(map[string]any)
+	if !bytes.Equal(m["payload"].([]byte), payload) || m["resync"] != true || msgpackUint(m["version"]) != 3 {
+		t.Errorf("Expected the payload bytes and fields, got %v", m)
+	}
+	for _, omitted := range []string{"rebuild", "instance", "summary", "chunk"} {
+		if _, ok := m[omitted]; ok {
+			t.Errorf("Expected empty %s left out, got %v", omitted, m)
+		}
+	}
+	if v := envelopeVersion(appendMsgpackEnvelope(nil, env)); v != 3 {
+		t.Errorf("Expected the msgpack envelope at version 3, got %d", v)
+	}
+}
+
+func TestMsgpackDecodesTimestamps(t *testing.T) {
+	for _, tc := range []struct {
+		data []byte
+		want time.Time
+	}{
+		{[]byte{0xd6, 0xff, 0x66, 0x32, 0x2e, 0x40}, time.Unix(0x66322e40, 0)},
+		{[]byte{0xd7, 0xff, 0x00, 0x00, 0x00, 0x04, 0x66, 0x32, 0x2e, 0x40}, time.Unix(0x66322e40, 1)},
+		{[]byte{0xc7, 12, 0xff, 0, 0, 0, 2, 0, 0, 0, 0, 0x66, 0x32, 0x2e, 0x40}, time.Unix(0x66322e40, 2)},
+	} {
+		got, err := msgpackDecode(tc.data)
+		if err != nil || !got.(time.Time).Equal(tc.want) {
+			t.Errorf("Expected %v, got %v (%v)", tc.want, got, err)
+		}
+	}
+}
+
+func TestMsgpackRejectsMalformedInput(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"truncated string": {0xa5, 'a'},
+		"truncated array":  {0xdd, 0xff, 0xff, 0xff, 0xff},
+		"trailing data":    {0xc0, 0xc0},
+		"integer key":      {0x81, 0x01, 0x01},
+		"unknown ext":      {0xd4, 0x05, 0x00},
+		"invalid byte":     {0xc1},
+		"too deep":         bytes.Repeat([]byte{0x91}, msgpackMaxDepth+2),
+	} {
+		if _, err := msgpackDecode(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestMsgpackExactPayloadMatchesJSON(t *testing.T) {
+	agg := NewSwarmAggregator()
+	blockAll(agg, evmAddress("a"), evmAddress("b"))
+	p, err := agg.globalSnapshot().exactPayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	direct, err := msgpackToJSON(p.appendMsgpack(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got ExactPayload
+	if err := json.Unmarshal(direct, &got); err != nil || !reflect.DeepEqual(got, p) {
+		t.Errorf("Expected %+v, got %+v (%v)", p, got, err)
+	}
+}
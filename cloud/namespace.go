@@ -0,0 +1,206 @@
+// Package swarm — Tenant namespaces.
+//
+// An API key may be bound to a namespace (configured as "ns/id:role:secret").
+// Reports presented with a namespaced key are recorded in the namespace's
+// own TWAB and filter and never reach the global consensus; subscribers
+// holding one receive the namespace's filter instead of the global one,
+// with the global entries merged in when the namespace sets merge_global.
+// Keys without a namespace belong to the default namespace, which is the
+// global swarm.
+package swarm
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// defaultNamespace names the global swarm in key configuration.
+const defaultNamespace = "default"
+
+// NamespaceConfig holds the options of one tenant namespace.
+type NamespaceConfig struct {
+	// MergeGlobal includes the global consensus in the namespace's pushes.
+	MergeGlobal bool `json:"merge_global" yaml:"merge_global"`
+}
+
+// namespace is the isolated consensus state of one tenant.
+type namespace struct {
+	name        string
+	mergeGlobal bool
+	twab        *TWAB
+	filter      *BloomFilter
+
+	subscribers *subscriberSet
+
+	pushMu      sync.Mutex
+	pushPending bool // a debounced push is scheduled
+}
+
+// namespace returns the state of a tenant namespace, creating it on first
+// use.  Namespaces missing from the config get the default options.
+func (s *SwarmAggregator) namespace(name string) *namespace {
+	s.nsMu.Lock()
+	defer s.nsMu.Unlock()
+
+	if ns, ok := s.namespaces[name]; ok {
+		return ns
+	}
+	ns := &namespace{
+		name:        name,
+		mergeGlobal: s.config.Namespaces[name].MergeGlobal,
+		twab:        NewTWAB(s.config.TWAB),
+		filter:      NewBloomFilterWithConfig(s.config.Bloom, 0),
+		subscribers: newSubscriberSet(s.logs),
+	}
+	s.namespaces[name] = ns
+	return ns
+}
+
+// namespaceList returns every namespace, sorted by name.
+func (s *SwarmAggregator) namespaceList() []*namespace {
+	s.nsMu.Lock()
+	defer s.nsMu.Unlock()
+
+	list := make([]*namespace, 0, len(s.namespaces))
+	for _, ns := range s.namespaces {
+		list = append(list, ns)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list
+}
+
+// requestNamespace resolves the namespace of an optionally presented API
+// key: "" for no key or the default namespace.  An unknown key is answered
+// with 401 and ok false.
+func (s *SwarmAggregator) requestNamespace(w http.ResponseWriter, r *http.Request) (string, bool) {
+	secret := presentedSecret(r)
+	if secret == "" {
+		return "", true
+	}
+	key, ok := s.keys.Lookup(secret)
+	if !ok {
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Invalid API key")
+		return "", false
+	}
+	return key.Namespace, true
+}
+
+// ingestNamespace is IngestReport for a tenant's report.  Only the
+// namespace's state changes, though the global allowlist still applies.
+func (s *SwarmAggregator) ingestNamespace(ctx context.Context, report IOCReport) bool {
+	ns := s.namespace(report.Namespace)
+
+	_, recordSpan := s.tracer.Start(ctx, spanTWABRecord)
+	ns.twab.Record(report.Address, report)
+	recordSpan.End()
+
+	_, thresholdSpan := s.tracer.Start(ctx, spanTWABThreshold)
+	promoted := ns.twab.MeetsThreshold(report.Address, s.current().TWAB)
+	thresholdSpan.SetAttributes(attrPromoted.Bool(promoted))
+	thresholdSpan.End()
+	if !promoted {
+		return false
+	}
+
+	s.mu.RLock()
+	allowed := s.allowlist[report.Address]
+	s.mu.RUnlock()
+	if allowed {
+		return false
+	}
+
+	ns.filter.Add(report.Address)
+	s.schedulePush(ctx, &ns.pushMu, &ns.pushPending, func(ctx context.Context) {
+		s.pushNamespace(ctx, ns)
+	})
+	return true
+}
+
+// namespaceSnapshot captures the filter served to a namespace's
+// subscribers.  Merged with the global filter, its version is the sum of
+// both versions, so it still advances whenever either side changes.
+func (s *SwarmAggregator) namespaceSnapshot(ns *namespace) filterSnapshot {
+	entries, version := ns.filter.Snapshot()
+	if ns.mergeGlobal {
+		global := s.FilterSnapshot()
+		version += global.Version
+		entries = mergeSorted(entries, global.snap.entries)
+	}
+	scorer := func(address string) float64 {
+		score := ns.twab.ConsensusScore(address, s.current().TWAB)
+		if ns.mergeGlobal {
+			score = max(score, s.ConsensusScore(address))
+		}
+		return score
+	}
+	return filterSnapshot{version: version, entries: entries, params: ns.filter.Params(), scorer: scorer}
+}
+
+// mergeSorted returns the union of two sorted address lists, sorted.
+func mergeSorted(a, b []string) []string {
+	out := make([]string, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			out = append(out, a[i])
+			i++
+		case a[i] > b[j]:
+			out = append(out, b[j])
+			j++
+		default:
+			out = append(out, a[i])
+			i, j = i+1, j+1
+		}
+	}
+	out = append(out, a[i:]...)
+	return append(out, b[j:]...)
+}
+
+// pushNamespace sends the namespace's filter to its subscribers.
+func (s *SwarmAggregator) pushNamespace(ctx context.Context, ns *namespace) {
+	_, span := s.tracer.Start(ctx, spanPush)
+	defer span.End()
+
+	snap := s.namespaceSnapshot(ns)
+	msgs, err := s.broadcastPush(ns.subscribers, snap, nil)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+	span.SetAttributes(attrPayloadSize.Int(msgs.size(FormatBloom)))
+}
+
+// pushMergingNamespaces forwards a global filter change to the namespaces
+// that merge it.
+func (s *SwarmAggregator) pushMergingNamespaces(ctx context.Context) {
+	for _, ns := range s.namespaceList() {
+		if ns.mergeGlobal && (ns.subscribers.len() > 0 || ns.subscribers.watch.waiting()) {
+			s.pushNamespace(ctx, ns)
+		}
+	}
+}
+
+// namespaceHealth is the per-namespace section of /health.
+type namespaceHealth struct {
+	FilterSize    int    `json:"filter_size"`
+	FilterVersion uint64 `json:"filter_version"`
+	MergeGlobal   bool   `json:"merge_global"`
+	Subscribers   int    `json:"subscribers"`
+}
+
+// namespacesHealth reports the size of every namespace.
+func (s *SwarmAggregator) namespacesHealth() map[string]namespaceHealth {
+	out := make(map[string]namespaceHealth)
+	for _, ns := range s.namespaceList() {
+		out[ns.name] = namespaceHealth{
+			FilterSize:    ns.filter.Len(),
+			FilterVersion: ns.filter.Version(),
+			MergeGlobal:   ns.mergeGlobal,
+			Subscribers:   ns.subscribers.len(),
+		}
+	}
+	return out
+}
@@ -0,0 +1,203 @@
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// newTenantAggregator promotes on a single report and serves the global
+// swarm plus the acme and globex tenants; acme merges the global filter.
+func newTenantAggregator(t *testing.T) *SwarmAggregator {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Namespaces = map[string]NamespaceConfig{"acme": {MergeGlobal: true}}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	keys, err := ParseAPIKeys("ops:admin:admin-secret,acme/sdk:reporter:acme-secret,globex/sdk:reporter:globex-secret,acme/siem:subscriber:acme-feed,globex/siem:subscriber:globex-feed,default/sdk:reporter:global-secret")
+	if err != nil {
+		t.Fatalf("ParseAPIKeys failed: %v", err)
+	}
+	agg.keys = keys
+	return agg
+}
+
+func postTenantReport(agg *SwarmAggregator, secret, address string) *httptest.ResponseRecorder {
+	body := fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":1.0,"source_id":"agent-A"}`, address)
+	req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+secret)
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNamespaceIngestIsolation(t *testing.T) {
+	agg := newTenantAggregator(t)
+	acmeAddr, globexAddr, globalAddr := evmAddress("acme-drainer"), evmAddress("globex-drainer"), evmAddress("global-drainer")
+
+	for secret, addr := range map[string]string{"acme-secret": acmeAddr, "globex-secret": globexAddr, "global-secret": globalAddr} {
+		if rec := postTenantReport(agg, secret, addr); rec.Code != http.StatusOK {
+			t.Fatalf("Ingest with %s: expected 200, got %d", secret, rec.Code)
+		}
+	}
+	if rec := postTenantReport(agg, "unknown-secret", acmeAddr); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for an unknown key, got %d", rec.Code)
+	}
+
+	acme, globex := agg.namespace("acme"), agg.namespace("globex")
+	if !acme.filter.Contains(acmeAddr) || acme.filter.Len() != 1 {
+		t.Errorf("Expected acme's filter to hold only its own report, got %d entries", acme.filter.Len())
+	}
+	if !globex.filter.Contains(globexAddr) || globex.filter.Len() != 1 {
+		t.Errorf("Expected globex's filter to hold only its own report, got %d entries", globex.filter.Len())
+	}
+	if !agg.bloomFilter.Contains(globalAddr) || agg.BloomFilterLen() != 1 {
+		t.Errorf("Expected the global filter to hold only the default namespace's report, got %d entries", agg.BloomFilterLen())
+	}
+	if _, ok := agg.twab.Summary(acmeAddr); ok {
+		t.Error("Tenant report leaked into the global TWAB")
+	}
+	if _, ok := globex.twab.Summary(acmeAddr); ok {
+		t.Error("acme report leaked into globex's TWAB")
+	}
+	if _, ok := agg.Confirmed(acmeAddr); ok {
+		t.Error("Tenant report leaked into the global confirmed set")
+	}
+}
+
+func TestNamespacePushIsolation(t *testing.T) {
+	agg := newTenantAggregator(t)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	dial := func(secret string) *websocket.Conn {
+		header := http.Header{"Authorization": {"Bearer " + secret}}
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		var initial FilterEnvelope
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if err := conn.ReadJSON(&initial); err != nil {
+			t.Fatalf("Expected initial snapshot: %v", err)
+		}
+		return conn
+	}
+	readEntries := func(conn *websocket.Conn, wait time.Duration) ([]string, error) {
+		conn.SetReadDeadline(time.Now().Add(wait))
+		var env FilterEnvelope
+		if err := conn.ReadJSON(&env); err != nil {
+			return nil, err
+		}
+		var payload FilterPayload
+		err := json.Unmarshal(env.Payload, &payload)
+		return payload.Entries, err
+	}
+
+	acme, globex := dial("acme-feed"), dial("globex-feed")
+	defer acme.Close()
+	defer globex.Close()
+
+	secret := evmAddress("acme-only")
+	postTenantReport(agg, "acme-secret", secret)
+	if entries, err := readEntries(acme, 2*time.Second); err != nil || len(entries) != 1 || entries[0] != secret {
+		t.Fatalf("Expected acme push with its entry, got %v, %v", entries, err)
+	}
+	if entries, err := readEntries(globex, 200*time.Millisecond); err == nil {
+		t.Fatalf("globex received acme's push: %v", entries)
+	}
+
+	global := evmAddress("global-consensus")
+	postTenantReport(agg, "global-secret", global)
+	entries, err := readEntries(acme, 2*time.Second)
+	if err != nil || len(entries) != 2 || !strings.Contains(strings.Join(entries, ","), global) {
+		t.Errorf("Expected merge_global push to add the global entry, got %v, %v", entries, err)
+	}
+}
+
+func TestUnsubscribeRemovesNamespaceSubscribers(t *testing.T) {
+	agg := newTenantAggregator(t)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	header := http.Header{"Authorization": {"Bearer globex-feed"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	var env FilterEnvelope
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&env); err != nil {
+		t.Fatalf("Expected initial snapshot: %v", err)
+	}
+
+	var id string
+	for _, info := range agg.ListSubscribers() {
+		if info.Namespace == "globex" {
+			id = info.ID
+		}
+	}
+	if id == "" {
+		t.Fatalf("Expected a globex subscriber, got %+v", agg.ListSubscribers())
+	}
+	agg.Unsubscribe(id)
+	if subs := agg.ListSubscribers(); len(subs) != 0 {
+		t.Errorf("Expected the namespace subscriber removed, got %+v", subs)
+	}
+
+	postTenantReport(agg, "globex-secret", evmAddress("globex-after"))
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if err := conn.ReadJSON(&env); err == nil {
+		t.Errorf("Expected nothing delivered after unsubscribing, got version %d", env.Version)
+	}
+}
+
+func TestNamespaceFilterWithoutMergeExcludesGlobal(t *testing.T) {
+	agg := newTenantAggregator(t)
+	postTenantReport(agg, "global-secret", evmAddress("global-consensus"))
+
+	req := httptest.NewRequest(http.MethodGet, "/filter", nil)
+	req.Header.Set("X-API-Key", "globex-secret")
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	var payload FilterPayload
+	json.NewDecoder(rec.Body).Decode(&payload)
+	if rec.Code != http.StatusOK || payload.Count != 0 {
+		t.Errorf("Expected globex's empty filter, got %d %+v", rec.Code, payload)
+	}
+}
+
+func TestHealthReportsNamespacesToAdminsOnly(t *testing.T) {
+	agg := newTenantAggregator(t)
+	postTenantReport(agg, "globex-secret", evmAddress("globex-drainer"))
+
+	health := func(secret string) map[string]json.RawMessage {
+		req := httptest.NewRequest(http.MethodGet, "/health", nil)
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, req)
+		var resp map[string]json.RawMessage
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+
+	for _, secret := range []string{"", "acme-secret"} {
+		if _, ok := health(secret)["namespaces"]; ok {
+			t.Errorf("Namespaces exposed to key %q", secret)
+		}
+	}
+	var namespaces map[string]namespaceHealth
+	json.Unmarshal(health("admin-secret")["namespaces"], &namespaces)
+	if namespaces["globex"].FilterSize != 1 || !namespaces["acme"].MergeGlobal {
+		t.Errorf("Unexpected namespace health %+v", namespaces)
+	}
+}
@@ -0,0 +1,149 @@
+// Package swarm — NATS JetStream report consumer.
+//
+// Reports are read from a JetStream stream through a durable pull
+// consumer with explicit acks, so a message is only removed once
+// IngestReport has run.  The stream is created on first start if the
+// operator has not provisioned it.
+package swarm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATSConfig configures the NATS consumer.  An empty URL disables it.
+type NATSConfig struct {
+	URL               string
+	Stream            string
+	Subject           string
+	DeadLetterSubject string
+	Durable           string
+}
+
+// DefaultNATSConfig returns the default stream, subjects, and durable name.
+func DefaultNATSConfig() NATSConfig {
+	return NATSConfig{
+		Stream:            "AEGIS_REPORTS",
+		Subject:           "aegis.reports",
+		DeadLetterSubject: "aegis.reports.dlq",
+		Durable:           "aegis-aggregator",
+	}
+}
+
+// NATSConfigFromEnv reads AEGIS_NATS_URL, AEGIS_NATS_STREAM,
+// AEGIS_NATS_SUBJECT, AEGIS_NATS_DLQ_SUBJECT, and AEGIS_NATS_DURABLE over
+// the defaults.
+func NATSConfigFromEnv() NATSConfig {
+	cfg := DefaultNATSConfig()
+	cfg.URL = os.Getenv("AEGIS_NATS_URL")
+	for env, field := range map[string]*string{
+		"AEGIS_NATS_STREAM":      &cfg.Stream,
+		"AEGIS_NATS_SUBJECT":     &cfg.Subject,
+		"AEGIS_NATS_DLQ_SUBJECT": &cfg.DeadLetterSubject,
+		"AEGIS_NATS_DURABLE":     &cfg.Durable,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*field = v
+		}
+	}
+	return cfg
+}
+
+// headerDeadLetterReason carries why a message was dead-lettered.
+const headerDeadLetterReason = "Aegis-Dead-Letter-Reason"
+
+// NATSSource is a BusSource backed by a JetStream pull consumer.
+type NATSSource struct {
+	cfg  NATSConfig
+	nc   *nats.Conn
+	cons jetstream.Consumer
+}
+
+// NewNATSSource connects to NATS and binds the durable consumer, creating
+// the stream if it does not exist.
+func NewNATSSource(ctx context.Context, cfg NATSConfig) (*NATSSource, error) {
+	nc, err := nats.Connect(cfg.URL, nats.Name("aegis-aggregator"))
+	if err != nil {
+		return nil, fmt.Errorf("connect to NATS at %s: %w", cfg.URL, err)
+	}
+	js, err := jetstream.New(nc)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	stream, err := js.Stream(ctx, cfg.Stream)
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		stream, err = js.CreateStream(ctx, jetstream.StreamConfig{
+			Name:     cfg.Stream,
+			Subjects: []string{cfg.Subject},
+		})
+	}
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("bind stream %s: %w", cfg.Stream, err)
+	}
+
+	cons, err := stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       cfg.Durable,
+		FilterSubject: cfg.Subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+	})
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("bind consumer %s: %w", cfg.Durable, err)
+	}
+	return &NATSSource{cfg: cfg, nc: nc, cons: cons}, nil
+}
+
+// Name implements BusSource.
+func (n *NATSSource) Name() string { return "nats:" + n.cfg.Durable }
+
+// Fetch implements BusSource.
+func (n *NATSSource) Fetch(ctx context.Context, max int, maxWait time.Duration) ([]BusMessage, error) {
+	batch, err := n.cons.Fetch(max, jetstream.FetchMaxWait(maxWait))
+	if err != nil {
+		return nil, err
+	}
+	var msgs []BusMessage
+	for msg := range batch.Messages() {
+		msgs = append(msgs, msg)
+	}
+	if err := batch.Error(); err != nil && !errors.Is(err, nats.ErrTimeout) {
+		// Unacked messages are redelivered once their ack wait expires.
+		return nil, err
+	}
+	return msgs, nil
+}
+
+// DeadLetter implements BusSource by republishing the raw message, with
+// the reason in a header, on the dead-letter subject.
+func (n *NATSSource) DeadLetter(ctx context.Context, msg BusMessage, reason string) error {
+	out := nats.NewMsg(n.cfg.DeadLetterSubject)
+	out.Data = msg.Data()
+	out.Header.Set(headerDeadLetterReason, reason)
+	if err := n.nc.PublishMsg(out); err != nil {
+		return err
+	}
+	return n.nc.Flush()
+}
+
+// Lag implements BusSource.
+func (n *NATSSource) Lag(ctx context.Context) (uint64, error) {
+	info, err := n.cons.Info(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return info.NumPending, nil
+}
+
+// Close implements BusSource.
+func (n *NATSSource) Close() error {
+	return n.nc.Drain()
+}
@@ -0,0 +1,86 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func startEmbeddedNATS(t *testing.T) *server.Server {
+	t.Helper()
+	ns, err := server.NewServer(&server.Options{Port: -1, JetStream: true, StoreDir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Failed to create NATS server: %v", err)
+	}
+	ns.Start()
+	if !ns.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(ns.Shutdown)
+	return ns
+}
+
+func TestNATSConsumerPromotesAndDeadLetters(t *testing.T) {
+	ns := startEmbeddedNATS(t)
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2})
+
+	cfg := DefaultNATSConfig()
+	cfg.URL = ns.ClientURL()
+	src, err := NewNATSSource(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("NewNATSSource failed: %v", err)
+	}
+	consumer := NewBusConsumer(agg, src)
+	consumer.maxWait = 50 * time.Millisecond
+	consumer.Start(context.Background())
+
+	nc, err := nats.Connect(ns.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	dlq, err := nc.SubscribeSync(cfg.DeadLetterSubject)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, source := range []string{"agent-A", "agent-B"} {
+		data, _ := json.Marshal(IOCReport{Address: evmAddress("bus"), ChainID: 1, Confidence: 0.9, SourceID: source})
+		if err := nc.Publish(cfg.Subject, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nc.Publish(cfg.Subject, []byte("not json"))
+	nc.Flush()
+
+	dead, err := dlq.NextMsg(2 * time.Second)
+	if err != nil {
+		t.Fatalf("Expected malformed message on the dead-letter subject: %v", err)
+	}
+	if string(dead.Data) != "not json" || dead.Header.Get(headerDeadLetterReason) == "" {
+		t.Errorf("Unexpected dead letter %q %v", dead.Data, dead.Header)
+	}
+
+	if err := consumer.Drain(context.Background()); err != nil {
+		t.Fatalf("Drain failed: %v", err)
+	}
+	if _, ok := agg.Confirmed(evmAddress("bus")); !ok {
+		t.Error("Expected 0xBus to be promoted from bus reports")
+	}
+
+	name := src.Name()
+	if got := testutil.ToFloat64(agg.metrics.busMessages.WithLabelValues(name, busOutcomePromoted)); got != 1 {
+		t.Errorf("Expected 1 promoted message, got %v", got)
+	}
+	if got := testutil.ToFloat64(agg.metrics.busMessages.WithLabelValues(name, busOutcomeDeadLettered)); got != 1 {
+		t.Errorf("Expected 1 dead-lettered message, got %v", got)
+	}
+	if got := testutil.ToFloat64(agg.metrics.busLag.WithLabelValues(name)); got != 0 {
+		t.Errorf("Expected no lag after draining, got %v", got)
+	}
+}
@@ -0,0 +1,96 @@
+// Package swarm — Network origin of reports.
+//
+// min_distinct_sources alone can be met by one operator running many SDK
+// instances with different source IDs from the same machine.  Each report
+// received over HTTP is therefore tagged with the network it came from,
+// and twab.min_distinct_networks requires reports from that many distinct
+// networks before an address is promoted.
+//
+// A network is the reporter's autonomous system when a NetworkResolver
+// registered with SetNetworkResolver knows it, and otherwise the /16 of
+// its IPv4 address or the /48 of its IPv6 one.  Only a keyed hash of the
+// network is kept, never the address: the key is generated when the
+// aggregator starts, so the hashes cannot be matched against a list of
+// prefixes, nor across restarts.  Reports without a remote address, from
+// the message bus or recorded in-process, all count as one unknown
+// network.
+package swarm
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// networkUnknown is the network of reports that arrived without a remote
+// address.
+const networkUnknown = "unknown"
+
+// NetworkResolver maps a reporter's IP to its autonomous system number,
+// for deployments with a GeoIP/ASN database.
+type NetworkResolver interface {
+	ASN(ip net.IP) (asn uint32, ok bool)
+}
+
+// networkHasher buckets remote addresses into network hashes.
+type networkHasher struct {
+	key []byte
+
+	mu       sync.RWMutex
+	resolver NetworkResolver // nil uses prefixes only
+}
+
+func newNetworkHasher() *networkHasher {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("generate network key: %v", err))
+	}
+	return &networkHasher{key: key}
+}
+
+// network returns the hash of the network ip belongs to.
+func (h *networkHasher) network(ip net.IP) string {
+	if ip == nil {
+		return networkUnknown
+	}
+	h.mu.RLock()
+	resolver := h.resolver
+	h.mu.RUnlock()
+
+	var bucket string
+	if asn, ok := resolveASN(resolver, ip); ok {
+		bucket = fmt.Sprintf("asn:%d", asn)
+	} else if v4 := ip.To4(); v4 != nil {
+		bucket = v4.Mask(net.CIDRMask(16, 32)).String() + "/16"
+	} else {
+		bucket = ip.Mask(net.CIDRMask(48, 128)).String() + "/48"
+	}
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(bucket))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func resolveASN(resolver NetworkResolver, ip net.IP) (uint32, bool) {
+	if resolver == nil {
+		return 0, false
+	}
+	return resolver.ASN(ip)
+}
+
+// SetNetworkResolver makes reports from addresses the resolver knows
+// count by autonomous system instead of by prefix.
+func (s *SwarmAggregator) SetNetworkResolver(resolver NetworkResolver) {
+	s.networks.mu.Lock()
+	defer s.networks.mu.Unlock()
+	s.networks.resolver = resolver
+}
+
+// requestNetwork is the network hash of the request's remote address.
+func (s *SwarmAggregator) requestNetwork(r *http.Request) string {
+	return s.networks.network(net.ParseIP(clientIP(r)))
+}
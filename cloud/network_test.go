@@ -0,0 +1,98 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newNetworkGatedAggregator() *SwarmAggregator {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 3, MinDistinctSources: 3, MinDistinctNetworks: 2}
+	cfg.Ingest.Synchronous = true
+	return NewSwarmAggregatorWithConfig(cfg)
+}
+
+func ingestFrom(agg *SwarmAggregator, remote, addr, source string) int {
+	body := fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":%q}`, addr, source)
+	req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+	req.RemoteAddr = remote
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestManySourcesFromOneNetworkFailGate(t *testing.T) {
+	agg := newNetworkGatedAggregator()
+	addr := evmAddress("one-box")
+	for i, remote := range []string{"203.0.113.7:4000", "203.0.200.9:4001", "203.0.1.1:4002", "203.0.4.4:4003"} {
+		if code := ingestFrom(agg, remote, addr, fmt.Sprintf("agent-%d", i)); code != http.StatusOK {
+			t.Fatalf("Ingest: got %d", code)
+		}
+	}
+	if agg.bloomFilter.Contains(addr) {
+		t.Fatal("Expected four sources from one /16 not to promote")
+	}
+	explanation := agg.twab.Explain(addr, agg.current().TWAB)
+	gate := explanation.Gates[len(explanation.Gates)-1]
+	if gate.Gate != gateDistinctNetworks || gate.Observed != 1 || gate.Passed || explanation.MeetsThreshold {
+		t.Errorf("Expected the network gate to fail with one network, got %+v", explanation)
+	}
+
+	ingestFrom(agg, "198.51.100.1:4000", addr, "agent-elsewhere")
+	if !agg.bloomFilter.Contains(addr) {
+		t.Errorf("Expected a report from a second network to promote, got %+v", agg.twab.Explain(addr, agg.current().TWAB))
+	}
+	if summary, _ := agg.twab.Summary(addr); summary.DistinctNetworks != 2 {
+		t.Errorf("Expected 2 distinct networks, got %d", summary.DistinctNetworks)
+	}
+}
+
+func TestReportsWithoutAddressShareOneNetwork(t *testing.T) {
+	agg := newNetworkGatedAggregator()
+	addr := evmAddress("bus")
+	for i := 0; i < 5; i++ {
+		agg.IngestReport(context.Background(), IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: fmt.Sprintf("agent-%d", i)})
+	}
+	if agg.bloomFilter.Contains(addr) {
+		t.Error("Expected reports without a remote address to count as one network")
+	}
+}
+
+type fakeResolver map[string]uint32
+
+func (f fakeResolver) ASN(ip net.IP) (uint32, bool) {
+	asn, ok := f[ip.String()]
+	return asn, ok
+}
+
+func TestNetworkBuckets(t *testing.T) {
+	h := newNetworkHasher()
+	network := func(ip string) string { return h.network(net.ParseIP(ip)) }
+
+	if network("192.0.2.1") != network("192.0.250.250") || network("192.0.2.1") == network("192.1.2.1") {
+		t.Error("Expected IPv4 addresses bucketed by /16")
+	}
+	if network("2001:db8:1::1") != network("2001:db8:1:ffff::2") || network("2001:db8:1::1") == network("2001:db8:2::1") {
+		t.Error("Expected IPv6 addresses bucketed by /48")
+	}
+	if h.network(nil) != networkUnknown {
+		t.Errorf("Expected no address to be the unknown network, got %q", h.network(nil))
+	}
+	if got := network("192.0.2.1"); strings.Contains(got, "192") || len(got) != 32 {
+		t.Errorf("Expected an opaque hash, got %q", got)
+	}
+	if newNetworkHasher().network(net.ParseIP("192.0.2.1")) == network("192.0.2.1") {
+		t.Error("Expected hashes keyed per aggregator")
+	}
+
+	h.resolver = fakeResolver{"192.0.2.1": 64500, "198.51.100.1": 64500}
+	if network("192.0.2.1") != network("198.51.100.1") || network("192.0.2.1") == network("192.0.2.2") {
+		t.Error("Expected resolved addresses bucketed by ASN and the rest by prefix")
+	}
+}
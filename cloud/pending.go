@@ -0,0 +1,143 @@
+// Package swarm — Pending-consensus listing.
+//
+// GET /pending shows what is "brewing": addresses with reports that have
+// not yet crossed the TWAB threshold, so analysts can investigate before
+// consensus forms.
+package swarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+// Page size bounds for GET /pending.
+const (
+	defaultPendingLimit = 50
+	maxPendingLimit     = 500
+)
+
+// Sort orders accepted by GET /pending; all are descending.
+const (
+	pendingSortScore    = "score"
+	pendingSortReports  = "reports"
+	pendingSortLastSeen = "last_seen"
+)
+
+// PendingEntry is one address awaiting consensus.
+type PendingEntry struct {
+	Address         string  `json:"address"`
+	TimeSpanSeconds float64 `json:"time_span_seconds"`
+	TWABSummary
+}
+
+// PendingOptions selects a page of pending entries.
+type PendingOptions struct {
+	ChainID int // 0 for all chains
+	Sort    string
+	Offset  int
+	Limit   int
+}
+
+// Pending returns a sorted page of tracked addresses that are not in the
+// filter, along with the total number matching before pagination.  Ties
+// are broken by address so pages are stable.
+func (s *SwarmAggregator) Pending(opts PendingOptions) ([]PendingEntry, int) {
+	var matched []PendingEntry
+	for _, e := range s.twab.Snapshot() {
+		if opts.ChainID != 0 && e.ChainID != opts.ChainID {
+			continue
+		}
+		if s.bloomFilter.Contains(e.Address) {
+			continue
+		}
+		matched = append(matched, PendingEntry{
+			Address:         e.Address,
+			TimeSpanSeconds: e.TimeSpan().Seconds(),
+			TWABSummary:     e.TWABSummary,
+		})
+	}
+
+	less := pendingLess(opts.Sort)
+	sort.Slice(matched, func(i, j int) bool {
+		if less(matched[i], matched[j]) {
+			return true
+		}
+		if less(matched[j], matched[i]) {
+			return false
+		}
+		return matched[i].Address < matched[j].Address
+	})
+
+	total := len(matched)
+	if opts.Offset >= total {
+		return []PendingEntry{}, total
+	}
+	end := total
+	if opts.Limit > 0 && opts.Offset+opts.Limit < end {
+		end = opts.Offset + opts.Limit
+	}
+	return matched[opts.Offset:end], total
+}
+
+// pendingLess orders entries "before" for a descending sort key.
+func pendingLess(key string) func(a, b PendingEntry) bool {
+	switch key {
+	case pendingSortReports:
+		return func(a, b PendingEntry) bool { return a.ReportCount > b.ReportCount }
+	case pendingSortLastSeen:
+		return func(a, b PendingEntry) bool { return a.LastSeen.After(b.LastSeen) }
+	default:
+		return func(a, b PendingEntry) bool { return a.WeightedScore > b.WeightedScore }
+	}
+}
+
+// handlePending is the HTTP handler for
+// GET /pending?limit=&offset=&sort=score|reports|last_seen&chain_id=.
+func (s *SwarmAggregator) handlePending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	opts := PendingOptions{Sort: pendingSortScore, Limit: defaultPendingLimit}
+	if v := q.Get("sort"); v != "" {
+		switch v {
+		case pendingSortScore, pendingSortReports, pendingSortLastSeen:
+			opts.Sort = v
+		default:
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid sort")
+			return
+		}
+	}
+	for name, dst := range map[string]*int{"limit": &opts.Limit, "offset": &opts.Offset, "chain_id": &opts.ChainID} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid "+name)
+			return
+		}
+		*dst = n
+	}
+	if opts.Limit == 0 {
+		opts.Limit = defaultPendingLimit
+	}
+	if opts.Limit > maxPendingLimit {
+		opts.Limit = maxPendingLimit
+	}
+
+	items, total := s.Pending(opts)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"items":  items,
+		"total":  total,
+		"limit":  opts.Limit,
+		"offset": opts.Offset,
+		"sort":   opts.Sort,
+	})
+}
@@ -0,0 +1,94 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPendingSortStableAndExcludesPromoted(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 3, MinDistinctSources: 2})
+	ctx := context.Background()
+	base := time.Now()
+	report := func(addr, source string, conf float64, offset time.Duration) {
+		agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: 1, Confidence: conf, Timestamp: base.Add(offset), SourceID: source})
+	}
+
+	// tieA and tieB have identical scores; the address breaks the tie.
+	tieA := "0x00000000000000000000000000000000000000aa"
+	tieB := "0x00000000000000000000000000000000000000bb"
+	report(tieB, "agent-A", 0.5, 0)
+	report(tieA, "agent-A", 0.5, time.Second)
+	report(evmAddress("high"), "agent-A", 0.9, 2*time.Second)
+	report(evmAddress("high"), "agent-B", 0.8, 3*time.Second)
+
+	for i := 0; i < 5; i++ {
+		page, total := agg.Pending(PendingOptions{Sort: pendingSortScore})
+		if total != 3 || page[0].Address != evmAddress("high") || page[1].Address != tieA || page[2].Address != tieB {
+			t.Fatalf("Unexpected score order %+v", page)
+		}
+	}
+
+	page, _ := agg.Pending(PendingOptions{Sort: pendingSortLastSeen, Offset: 1, Limit: 1})
+	if len(page) != 1 || page[0].Address != tieA {
+		t.Errorf("Expected second-most-recent 0xTieA on page 2, got %+v", page)
+	}
+
+	report(evmAddress("high"), "agent-C", 0.7, 4*time.Second) // crosses the threshold
+	if _, ok := agg.Confirmed(evmAddress("high")); !ok {
+		t.Fatal("Expected 0xHigh to be promoted")
+	}
+	page, total := agg.Pending(PendingOptions{Sort: pendingSortReports})
+	if total != 2 {
+		t.Errorf("Expected promoted address to leave the pending list, got %+v", page)
+	}
+	for _, e := range page {
+		if e.Address == evmAddress("high") {
+			t.Error("Promoted 0xHigh still listed as pending")
+		}
+	}
+}
+
+func TestPendingEndpointRequiresReporterAndCapsLimit(t *testing.T) {
+	agg := NewSwarmAggregator()
+	agg.keys.Add("reporter-secret", APIKey{ID: "sdk", Role: RoleReporter})
+	agg.keys.Add("sub-secret", APIKey{ID: "siem", Role: RoleSubscriber})
+	agg.IngestReport(context.Background(), IOCReport{Address: evmAddress("brewing"), ChainID: 1, Confidence: 0.6, Timestamp: time.Now(), SourceID: "agent-A"})
+	routes := agg.Routes()
+
+	get := func(secret, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/pending"+query, nil)
+		if secret != "" {
+			req.Header.Set("Authorization", "Bearer "+secret)
+		}
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("sub-secret", ""); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for subscriber, got %d", rec.Code)
+	}
+	if rec := get("reporter-secret", "?sort=bogus"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown sort, got %d", rec.Code)
+	}
+
+	rec := get("reporter-secret", "?limit=100000&chain_id=1")
+	var resp struct {
+		Items []PendingEntry `json:"items"`
+		Total int            `json:"total"`
+		Limit int            `json:"limit"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || resp.Limit != maxPendingLimit || resp.Total != 1 || resp.Items[0].Address != evmAddress("brewing") {
+		t.Errorf("Unexpected response %d %+v", rec.Code, resp)
+	}
+
+	json.NewDecoder(get("reporter-secret", "?chain_id=137").Body).Decode(&resp)
+	if resp.Total != 0 {
+		t.Errorf("Expected chain filter to exclude chain 1 entries, got %+v", resp)
+	}
+}
@@ -0,0 +1,119 @@
+// Package swarm — Subscriber delivery pumps.
+//
+// Every subscriber has a pump, a goroutine that owns its outbound
+// channel: it alone sends on it and closes it, so a push can never race
+// the channel's close.  A broadcast does no delivery itself.  Holding the
+// set's read lock, it only appends a version-stamped note to each
+// subscriber's mailbox, the push's message in the subscriber's variant
+// (see subscribers.go), and wakes the pump.  The pump then applies the
+// subscriber's delivery policy in version order: the schedule that may
+// hold the push (see pushschedule.go), the queue or latest mode, the drop
+// counters and the ack bookkeeping, evicting the subscriber when the
+// policy gives up on it.  A slow subscriber therefore costs a broadcast
+// one append, whatever its policy.
+//
+// Unsubscribing signals the pump, which drains its mailbox, discarding
+// the pushes still in it since nobody will read them, closes the channel
+// and exits; nothing is sent to it after.  The channel returned by
+// Subscribe is the pump's output, so callers reading it see what they
+// always did: every push delivered, then the close.
+package swarm
+
+import "sync"
+
+// pushNote is one entry of a pump's mailbox: a push to deliver, or a
+// barrier to release once everything before it is delivered.
+type pushNote struct {
+	data      []byte
+	push      pushEvent
+	policy    evictionPolicy
+	scheduled bool          // a held push now due, which is not held again
+	barrier   chan struct{} // closed when reached, all other fields unset
+}
+
+// mailbox queues the notes for one pump.  Appending never blocks: the
+// pump drains it whole each time it wakes.
+type mailbox struct {
+	mu    sync.Mutex
+	notes []pushNote
+	wake  chan struct{} // buffered 1
+	stop  chan struct{} // closed by unsubscribe
+}
+
+func newMailbox() *mailbox {
+	return &mailbox{wake: make(chan struct{}, 1), stop: make(chan struct{})}
+}
+
+// post appends a note and wakes the pump.  The caller holds the set's
+// lock, read or write, so the pump has not been stopped.
+func (m *mailbox) post(note pushNote) {
+	m.mu.Lock()
+	m.notes = append(m.notes, note)
+	m.mu.Unlock()
+	select {
+	case m.wake <- struct{}{}:
+	default: // already awake or about to be
+	}
+}
+
+// take empties the mailbox.
+func (m *mailbox) take() []pushNote {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	notes := m.notes
+	m.notes = nil
+	return notes
+}
+
+// pump delivers the subscriber's notes until it is unsubscribed, then
+// closes its channel.
+func (ss *subscriberSet) pump(sub *subscriber) {
+	defer close(sub.ch)
+	for {
+		select {
+		case <-sub.mail.wake:
+			ss.deliver(sub, sub.mail.take())
+		case <-sub.mail.stop:
+			// Unsubscribed under the set's lock: nothing more is posted.
+			for _, note := range sub.mail.take() {
+				if note.barrier != nil {
+					close(note.barrier)
+				}
+			}
+			return
+		}
+	}
+}
+
+// deliver applies the subscriber's delivery policy to each note in turn.
+// Once the policy evicts the subscriber the rest are discarded.
+func (ss *subscriberSet) deliver(sub *subscriber, notes []pushNote) {
+	evicted := false
+	for _, note := range notes {
+		switch {
+		case note.barrier != nil:
+			close(note.barrier)
+		case evicted:
+		case !note.scheduled && sub.hold(note.data, note.push):
+		case sub.offer(note.data, note.push.version, note.policy, ss.logs, note.push.at):
+			ss.evict(sub)
+			evicted = true
+		}
+	}
+}
+
+// settle waits until every subscriber's pump has delivered everything
+// posted to it so far.
+func (ss *subscriberSet) settle() {
+	var barriers []chan struct{}
+	ss.mu.RLock()
+	for _, sub := range ss.subs {
+		barrier := make(chan struct{})
+		sub.mail.post(pushNote{barrier: barrier})
+		barriers = append(barriers, barrier)
+	}
+	ss.mu.RUnlock()
+	for _, barrier := range barriers {
+		<-barrier
+	}
+}
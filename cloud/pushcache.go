@@ -0,0 +1,132 @@
+// Package swarm — Push payload cache.
+//
+// A push goes to every subscriber in the encoding it asked for, but most
+// subscribers ask for the same few: a format, with or without the
+// summary, of every entry or one indicator type, in JSON or msgpack (a
+// pushVariant).  Each subscriberSet keeps a pushCache of the messages of
+// the version it last pushed, keyed by variant.  A variant is encoded the
+// first time a push finds a subscriber wanting it, signed once per
+// format, indicator type and encoding, and served from the cache after
+// that until the version advances and the cache is discarded.  So the
+// serialization work of a push is bounded by the number of distinct
+// variants, not subscribers.  Pushes are not compressed, so compression
+// is not part of the key.
+//
+// Every push records the fraction of its subscribers served a message
+// already encoded in aegis_push_cache_hit_ratio, and each lookup in
+// aegis_push_cache_lookups_total.
+package swarm
+
+import "sync"
+
+// Push cache lookup results recorded in aegis_push_cache_lookups_total.
+const (
+	pushCacheHit  = "hit"
+	pushCacheMiss = "miss"
+)
+
+// pushCache holds the messages of one filter version, by variant.
+type pushCache struct {
+	mu      sync.Mutex
+	version uint64
+	signed  map[pushVariant]FilterEnvelope // by variant without summary
+	msgs    pushMessages
+}
+
+func newPushCache() *pushCache {
+	return &pushCache{signed: make(map[pushVariant]FilterEnvelope), msgs: make(pushMessages)}
+}
+
+// messages returns the message of each variant at snap's version,
+// encoding those not cached yet, and how many it encoded.  A snapshot
+// older than the cached version, from a push overtaken by a newer one,
+// is encoded without being cached.
+func (c *pushCache) messages(s *SwarmAggregator, variants map[pushVariant]bool, snap filterSnapshot, summary *FilterSummary) (pushMessages, int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cache := c
+	switch {
+	case snap.version < c.version:
+		cache = newPushCache()
+	case snap.version > c.version:
+		c.version = snap.version
+		c.signed = make(map[pushVariant]FilterEnvelope)
+		c.msgs = make(pushMessages)
+	}
+
+	out := make(pushMessages, len(variants))
+	built := 0
+	for v := range variants {
+		if data, ok := cache.msgs[v]; ok {
+			out[v] = data
+			continue
+		}
+		data, err := cache.encode(s, v, snap, summary)
+		if err != nil {
+			return nil, built, err
+		}
+		cache.msgs[v], out[v] = data, data
+		built++
+	}
+	return out, built, nil
+}
+
+// encode encodes one variant, signing it unless another variant differing
+// only in the summary already has been.  The caller holds c.mu.
+func (c *pushCache) encode(s *SwarmAggregator, v pushVariant, snap filterSnapshot, summary *FilterSummary) ([]byte, error) {
+	signing := pushVariant{format: v.format, indicator: v.indicator, encoding: v.encoding}
+	env, ok := c.signed[signing]
+	if !ok {
+		typed := snap
+		if v.indicator != "" {
+			typed = snap.ofType(v.indicator)
+		}
+		var err error
+		if env, err = s.signEncoded(typed, v.format, v.encoding); err != nil {
+			return nil, err
+		}
+		c.signed[signing] = env
+	}
+	if v.summary {
+		env.Summary = summary
+	}
+	data, err := marshalEnvelope(env, v.encoding)
+	if err != nil {
+		return nil, err
+	}
+	if v.encoding != WireMsgpack {
+		s.chunks.frames(data) // split ahead of delivery
+	}
+	return data, nil
+}
+
+// encodePush returns a snapshot's message in every variant ss has
+// subscribers for, with summary in those that want it, and how many
+// variants it had to encode.
+func (s *SwarmAggregator) encodePush(ss *subscriberSet, snap filterSnapshot, summary *FilterSummary) (pushMessages, int, error) {
+	return ss.cache.messages(s, ss.variants(), snap, summary)
+}
+
+// broadcastPush encodes a snapshot for the subscribers of ss and delivers
+// it, recording how much of it the cache served.
+func (s *SwarmAggregator) broadcastPush(ss *subscriberSet, snap filterSnapshot, summary *FilterSummary) (pushMessages, error) {
+	msgs, built, err := s.encodePush(ss, snap, summary)
+	if err != nil {
+		return nil, err
+	}
+	offered := ss.broadcast(msgs, pushEvent{version: snap.version, at: s.clock.Now()}, newEvictionPolicy(s.config.Push))
+	s.recordPushCache(offered, built)
+	return msgs, nil
+}
+
+// recordPushCache records a push that offered its message to offered
+// subscribers after encoding built variants.
+func (s *SwarmAggregator) recordPushCache(offered, built int) {
+	if offered == 0 {
+		return
+	}
+	misses := min(built, offered)
+	s.metrics.pushCacheLookups.WithLabelValues(pushCacheMiss).Add(float64(misses))
+	s.metrics.pushCacheLookups.WithLabelValues(pushCacheHit).Add(float64(offered - misses))
+	s.metrics.pushCacheHitRatio.Observe(float64(offered-misses) / float64(offered))
+}
@@ -0,0 +1,111 @@
+package swarm
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// pushProfiles are four distinct subscriber preferences.
+var pushProfiles = []SubscribeOptions{
+	{},
+	{NoSummary: true},
+	{Encoding: WireMsgpack},
+	{IndicatorType: IndicatorAddress},
+}
+
+// subscribeProfiles subscribes n latest-mode subscribers spread over the
+// profiles, returning their channels.
+func subscribeProfiles(agg *SwarmAggregator, n int) []chan []byte {
+	chans := make([]chan []byte, n)
+	for i := range chans {
+		opts := pushProfiles[i%len(pushProfiles)]
+		opts.Buffer, opts.Mode = 1, SubscriberLatest
+		chans[i] = agg.SubscribeWithOptions(fmt.Sprintf("sub-%d", i), opts)
+	}
+	return chans
+}
+
+func pushCacheLookups(agg *SwarmAggregator, result string) float64 {
+	return testutil.ToFloat64(agg.metrics.pushCacheLookups.WithLabelValues(result))
+}
+
+func TestPushEncodesEachProfileOnce(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2})
+	chans := subscribeProfiles(agg, 40)
+	promote(agg, evmAddress("a"), "")
+
+	pushed := make([][]byte, len(chans))
+	for i, ch := range chans {
+		pushed[i] = <-ch
+		if first := pushed[i%len(pushProfiles)]; !bytes.Equal(pushed[i], first) {
+			t.Fatalf("Expected subscriber %d the same push as the first of its profile", i)
+		}
+	}
+	if hits, misses := pushCacheLookups(agg, pushCacheHit), pushCacheLookups(agg, pushCacheMiss); hits != 36 || misses != 4 {
+		t.Errorf("Expected 4 encodings served to 40 subscribers, got %v hits and %v misses", hits, misses)
+	}
+
+	agg.pushNow(context.Background()) // the same version again
+	if misses := pushCacheLookups(agg, pushCacheMiss); misses != 4 {
+		t.Errorf("Expected a repeated version served from the cache, got %v misses", misses)
+	}
+	promote(agg, evmAddress("b"), "")
+	if misses := pushCacheLookups(agg, pushCacheMiss); misses != 8 {
+		t.Errorf("Expected a new version encoded afresh, got %v misses", misses)
+	}
+}
+
+func TestPushCacheDoesNotCacheOvertakenVersions(t *testing.T) {
+	agg := NewSwarmAggregator()
+	agg.Subscribe("sub")
+	snap := agg.globalSnapshot()
+	snap.version = 5
+	if _, built, err := agg.encodePush(agg.subscribers, snap, nil); err != nil || built != 1 {
+		t.Fatalf("Expected version 5 encoded, got %d (%v)", built, err)
+	}
+	old := snap
+	old.version = 4
+	if _, built, _ := agg.encodePush(agg.subscribers, old, nil); built != 1 {
+		t.Errorf("Expected an older version encoded, got %d", built)
+	}
+	if _, built, _ := agg.encodePush(agg.subscribers, snap, nil); built != 0 || agg.subscribers.cache.version != 5 {
+		t.Errorf("Expected version 5 still cached, encoded %d", built)
+	}
+}
+
+// BenchmarkPushProfiles pushes a new version to subscribers spread over
+// four profiles.  encodes/op stays at four however many subscribers
+// there are.
+func BenchmarkPushProfiles(b *testing.B) {
+	for _, n := range []int{4, 100, 1000} {
+		b.Run(fmt.Sprintf("subscribers=%d", n), func(b *testing.B) {
+			agg := NewSwarmAggregator()
+			for i := 0; i < 1000; i++ {
+				agg.bloomFilter.Add(fmt.Sprintf("0x%040x", i))
+			}
+			chans := subscribeProfiles(agg, n)
+			snap := agg.globalSnapshot()
+			encodes := 0
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				snap.version++
+				msgs, built, err := agg.encodePush(agg.subscribers, snap, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				agg.subscribers.broadcast(msgs, pushEvent{version: snap.version, at: time.Now()}, evictionPolicy{})
+				encodes += built
+				for _, ch := range chans {
+					<-ch
+				}
+			}
+			b.ReportMetric(float64(encodes)/float64(b.N), "encodes/op")
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Package swarm — Ordered filter pushes.
+//
+// A change to the global filter is pushed after s.mu is released, so two
+// racing promotions could each capture the filter and broadcast in the
+// opposite order: a subscriber sent version 7 and then 6, or 7 twice.
+// Every global push goes through a pushQueue instead.  The snapshot is
+// captured atomically (see filterstate.go) and offered to the queue,
+// which broadcasts one snapshot at a time, and only one newer than the
+// last it broadcast: an older or equal one is dropped, its state being
+// contained in what was or is about to be sent.  Snapshots offered while
+// a broadcast is under way are coalesced into the newest.
+//
+// Subscribers of the global filter, and of the namespaces and tier that
+// merge it, therefore observe strictly increasing versions, and every
+// version's state reaches them, folded into a later snapshot at worst;
+// the summary of that push covers every change coalesced (see
+// summary.go).  A rebuild or import offers its snapshot before releasing
+// s.mu, so no plain push of the same version can overtake its rebuild
+// flag, which coalescing preserves.
+//
+// There is no worker goroutine: the caller that finds the queue idle
+// drains it, so an uncontended push is broadcast before the change that
+// caused it returns, as it always was.
+package swarm
+
+import (
+	"context"
+	"sync"
+)
+
+// pushQueue orders the broadcasts of global filter snapshots.
+type pushQueue struct {
+	mu       sync.Mutex
+	pending  *filterSnapshot // newest not yet broadcast
+	ctx      context.Context // of the offer pending came from
+	sent     uint64          // version of the last broadcast
+	draining bool
+}
+
+// offer queues snap to be broadcast, unless a snapshot at least as new is
+// already sent or pending.  It may be called with s.mu held.
+func (q *pushQueue) offer(ctx context.Context, snap filterSnapshot) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if snap.version <= q.sent {
+		return
+	}
+	if q.pending != nil {
+		if snap.version <= q.pending.version {
+			q.pending.rebuild = q.pending.rebuild || (snap.rebuild && snap.version == q.pending.version)
+			return
+		}
+		snap.rebuild = snap.rebuild || q.pending.rebuild
+	}
+	q.pending, q.ctx = &snap, ctx
+}
+
+// drain broadcasts the pending snapshots in order, unless another caller
+// already is, in which case that caller broadcasts them.
+func (q *pushQueue) drain(broadcast func(context.Context, filterSnapshot)) {
+	q.mu.Lock()
+	if q.draining {
+		q.mu.Unlock()
+		return
+	}
+	q.draining = true
+	for q.pending != nil {
+		snap, ctx := *q.pending, q.ctx
+		q.pending, q.ctx, q.sent = nil, nil, snap.version
+		q.mu.Unlock()
+		broadcast(ctx, snap)
+		q.mu.Lock()
+	}
+	q.draining = false
+	q.mu.Unlock()
+}
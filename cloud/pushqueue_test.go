@@ -0,0 +1,81 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrentPromotionsPushedInVersionOrder(t *testing.T) {
+	const workers, promotions = 16, 500
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Push.SubscriberBuffer = promotions + 1
+	agg := NewSwarmAggregatorWithConfig(cfg)
+
+	var readers sync.WaitGroup
+	received := make([][]uint64, 3)
+	for i := range received {
+		ch := agg.Subscribe(fmt.Sprintf("ordered-%d", i))
+		readers.Add(1)
+		go func(i int) {
+			defer readers.Done()
+			for data := range ch {
+				received[i] = append(received[i], envelopeVersion(data))
+			}
+		}(i)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := w; i < promotions; i += workers {
+				agg.IngestReport(context.Background(), IOCReport{Address: evmAddress(fmt.Sprintf("ordered-%d", i)), ChainID: 1, Confidence: 1, Timestamp: time.Now(), SourceID: "agent-A"})
+			}
+		}(w)
+	}
+	wg.Wait()
+	for i := range received {
+		agg.Unsubscribe(fmt.Sprintf("ordered-%d", i))
+	}
+	readers.Wait()
+
+	if v := agg.bloomFilter.Version(); v != promotions {
+		t.Fatalf("Expected %d versions, got %d", promotions, v)
+	}
+	for i, versions := range received {
+		if len(versions) == 0 || versions[len(versions)-1] != promotions {
+			t.Errorf("Subscriber %d: expected the last push at v%d, got %v", i, promotions, versions)
+			continue
+		}
+		for j := 1; j < len(versions); j++ {
+			if versions[j] <= versions[j-1] {
+				t.Errorf("Subscriber %d: v%d pushed after v%d", i, versions[j], versions[j-1])
+				break
+			}
+		}
+	}
+}
+
+func TestPushQueueKeepsRebuildFlagWhenCoalescing(t *testing.T) {
+	var q pushQueue
+	ctx := context.Background()
+	q.offer(ctx, filterSnapshot{version: 2, rebuild: true})
+	q.offer(ctx, filterSnapshot{version: 3})
+	q.offer(ctx, filterSnapshot{version: 1})
+
+	var sent []filterSnapshot
+	q.drain(func(_ context.Context, snap filterSnapshot) { sent = append(sent, snap) })
+	if len(sent) != 1 || sent[0].version != 3 || !sent[0].rebuild {
+		t.Fatalf("Expected v3 sent once, flagged rebuild, got %+v", sent)
+	}
+	q.offer(ctx, filterSnapshot{version: 3})
+	q.drain(func(_ context.Context, snap filterSnapshot) { sent = append(sent, snap) })
+	if len(sent) != 1 {
+		t.Errorf("Expected a version already sent dropped, got %+v", sent)
+	}
+}
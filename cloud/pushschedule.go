@@ -0,0 +1,189 @@
+// Package swarm — Scheduled push delivery.
+//
+// A subscriber on a metered link may ask for ?max_frequency=15m to be
+// sent at most one push per window instead of one per change.  Pushes
+// are whole snapshots, so a push arriving inside the window is held in
+// place of any held before it, and the subscriber's pending deltas are
+// the filter versions it is behind.  The push_schedule maintenance task
+// delivers a held push once the window since the last delivery has
+// passed.  With &urgent_severity=high a push adding an entry at least
+// that severe is delivered at once, taking whatever was held with it and
+// restarting the window.  Severity comes from the entry's category under
+// push.category_severity; only global filter pushes, which carry a
+// summary of their changes (see summary.go), can be urgent.
+//
+// The filter a connection starts from, or its resume reply, is never
+// held, so a reconnecting subscriber catches up immediately; the window
+// starts from it.  A scheduled subscriber's pushes carry no summary,
+// since that of a held push covers its own changes only.  GET
+// /admin/subscribers shows each one's schedule, pending deltas, and the
+// time the oldest of them was held.
+package swarm
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Severity rates the additions of a category.
+type Severity string
+
+const (
+	SeverityLow      Severity = "low"
+	SeverityMedium   Severity = "medium"
+	SeverityHigh     Severity = "high"
+	SeverityCritical Severity = "critical"
+)
+
+// severityRank orders the severities.
+var severityRank = map[Severity]int{SeverityLow: 1, SeverityMedium: 2, SeverityHigh: 3, SeverityCritical: 4}
+
+func (s Severity) valid() bool { return severityRank[s] > 0 }
+
+// atLeast reports whether s is known and at least as severe as min.
+func (s Severity) atLeast(min Severity) bool {
+	return s.valid() && severityRank[s] >= severityRank[min]
+}
+
+// maxPushFrequency caps ?max_frequency=.
+const maxPushFrequency = 24 * time.Hour
+
+// PushSchedule is how often a subscriber wants pushes.  The zero value
+// sends every push.
+type PushSchedule struct {
+	MaxFrequency   time.Duration // between deliveries
+	UrgentSeverity Severity      // additions at least this severe bypass MaxFrequency; "" for none
+}
+
+// pushEvent is one push offered to a subscriberSet.
+type pushEvent struct {
+	version  uint64
+	severity Severity // of the most severe addition pushed; "" if unknown
+	at       time.Time
+}
+
+// hold keeps data, at push, for later delivery if the subscriber is
+// scheduled and push falls inside its window without being urgent,
+// reporting whether it did.  Otherwise anything held is dropped, being
+// contained in data, and the window restarts.
+func (sub *subscriber) hold(data []byte, push pushEvent) bool {
+	if sub.schedule.MaxFrequency <= 0 {
+		return false
+	}
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	urgent := sub.schedule.UrgentSeverity != "" && push.severity.atLeast(sub.schedule.UrgentSeverity)
+	if urgent || push.at.Sub(sub.flushedAt) >= sub.schedule.MaxFrequency {
+		sub.held, sub.heldVersion, sub.heldSince = nil, 0, time.Time{}
+		sub.flushedAt = push.at
+		return false
+	}
+	if sub.held == nil {
+		sub.heldSince = push.at
+	}
+	sub.held, sub.heldVersion = data, push.version
+	return true
+}
+
+// takeDue returns the push held for the subscriber, and its version, once
+// its window has passed at now.
+func (sub *subscriber) takeDue(now time.Time) ([]byte, uint64, bool) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.held == nil || now.Sub(sub.flushedAt) < sub.schedule.MaxFrequency {
+		return nil, 0, false
+	}
+	data, version := sub.held, sub.heldVersion
+	sub.held, sub.heldVersion, sub.heldSince = nil, 0, time.Time{}
+	sub.flushedAt = now
+	return data, version, true
+}
+
+// flushDue posts the pushes held for subscribers whose window has passed
+// at now to their pumps, returning the number posted.
+func (ss *subscriberSet) flushDue(now time.Time, policy evictionPolicy) int {
+	flushed := 0
+	ss.mu.RLock()
+	for _, sub := range ss.subs {
+		data, version, ok := sub.takeDue(now)
+		if !ok {
+			continue
+		}
+		flushed++
+		sub.mail.post(pushNote{data: data, push: pushEvent{version: version, at: now}, policy: policy, scheduled: true})
+	}
+	ss.mu.RUnlock()
+	return flushed
+}
+
+// flushScheduledPushes is the push_schedule maintenance task.
+func (s *SwarmAggregator) flushScheduledPushes(ctx context.Context) TaskStats {
+	now, policy := s.clock.Now(), newEvictionPolicy(s.config.Push)
+	sets := []*subscriberSet{s.subscribers}
+	if s.staging != nil {
+		sets = append(sets, s.staging.subscribers, s.staging.both)
+	}
+	for _, ns := range s.namespaceList() {
+		sets = append(sets, ns.subscribers)
+	}
+	flushed := 0
+	for _, ss := range sets {
+		if ctx.Err() != nil {
+			break
+		}
+		flushed += ss.flushDue(now, policy)
+	}
+	return TaskStats{Items: flushed}
+}
+
+// severity returns the severity of a category's additions.
+func (c PushConfig) severity(category string) Severity {
+	if severity, ok := c.CategorySeverity[category]; ok {
+		return severity
+	}
+	return SeverityMedium
+}
+
+// pushSeverity returns the severity of the most severe addition a push
+// summary counts, "" for none.
+func (s *SwarmAggregator) pushSeverity(summary *FilterSummary) Severity {
+	if summary == nil {
+		return ""
+	}
+	cfg := s.current().Push
+	var most Severity
+	for category, counts := range summary.Categories {
+		if severity := cfg.severity(category); counts.Added > 0 && !most.atLeast(severity) {
+			most = severity
+		}
+	}
+	return most
+}
+
+// requestSchedule reads the ?max_frequency= and ?urgent_severity= of a
+// subscription, answering the request itself if they are invalid.
+func requestSchedule(w http.ResponseWriter, r *http.Request) (PushSchedule, bool) {
+	q := r.URL.Query()
+	var schedule PushSchedule
+	if q.Has("max_frequency") {
+		d, err := time.ParseDuration(q.Get("max_frequency"))
+		if err != nil || d <= 0 || d > maxPushFrequency {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid max_frequency, want a duration up to 24h")
+			return PushSchedule{}, false
+		}
+		schedule.MaxFrequency = d
+	}
+	if q.Has("urgent_severity") {
+		schedule.UrgentSeverity = Severity(q.Get("urgent_severity"))
+		if !schedule.UrgentSeverity.valid() {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid urgent_severity, want low, medium, high or critical")
+			return PushSchedule{}, false
+		}
+		if schedule.MaxFrequency == 0 {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "urgent_severity needs max_frequency")
+			return PushSchedule{}, false
+		}
+	}
+	return schedule, true
+}
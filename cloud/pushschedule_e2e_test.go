@@ -0,0 +1,95 @@
+package swarm_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+// startScheduleHarness starts a test aggregator processing reports inline,
+// so each promotion is pushed before Report returns.
+func startScheduleHarness(t *testing.T) *aegistest.Aggregator {
+	return aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) { cfg.Ingest.Synchronous = true }})
+}
+
+// scheduledSubscriber returns the one subscriber with a schedule.
+func scheduledSubscriber(t *testing.T, h *aegistest.Aggregator) swarm.SubscriberInfo {
+	t.Helper()
+	for _, info := range h.ListSubscribers() {
+		if info.MaxFrequency > 0 {
+			return info
+		}
+	}
+	t.Fatal("Expected a scheduled subscriber")
+	return swarm.SubscriberInfo{}
+}
+
+func TestScheduledPushesCoalesceUntilTheWindowPasses(t *testing.T) {
+	h := startScheduleHarness(t)
+	conn, _ := h.DialWSQuery("max_frequency=15m&urgent_severity=high")
+	promoteOver(t, h, aegistest.Address("mixer-1"), "mixer")
+	promoteOver(t, h, aegistest.Address("mixer-2"), "mixer")
+
+	info := scheduledSubscriber(t, h)
+	if info.PendingDeltas != 2 || info.Delivered != 0 || info.PendingSince == nil {
+		t.Fatalf("Expected 2 pending deltas and nothing delivered, got %+v", info)
+	}
+	h.Advance(12 * time.Minute) // 14 minutes in
+	if info := scheduledSubscriber(t, h); info.PendingDeltas != 2 || info.Delivered != 0 {
+		t.Fatalf("Expected the push held until 15 minutes, got %+v", info)
+	}
+
+	h.Advance(time.Minute)
+	env := h.ReadPush(conn)
+	if env.Version != h.FilterSnapshot().Version || env.Summary != nil {
+		t.Errorf("Expected one push of version %d without a summary, got version %d", h.FilterSnapshot().Version, env.Version)
+	}
+	if info := scheduledSubscriber(t, h); info.PendingDeltas != 0 || info.Delivered != 1 {
+		t.Errorf("Expected the held push flushed, got %+v", info)
+	}
+}
+
+func TestUrgentAdditionsBypassTheSchedule(t *testing.T) {
+	h := startScheduleHarness(t)
+	conn, _ := h.DialWSQuery("max_frequency=15m&urgent_severity=high")
+	promoteOver(t, h, aegistest.Address("mixer"), "mixer")
+	promoteOver(t, h, aegistest.Address("drainer"), "drainer") // critical
+
+	if env := h.ReadPush(conn); env.Version != 2 {
+		t.Errorf("Expected the urgent push to carry both versions, got version %d", env.Version)
+	}
+	if info := scheduledSubscriber(t, h); info.PendingDeltas != 0 || info.Delivered != 1 {
+		t.Errorf("Expected nothing left pending, got %+v", info)
+	}
+
+	// The urgent push restarted the window.
+	promoteOver(t, h, aegistest.Address("mixer-later"), "mixer")
+	if info := scheduledSubscriber(t, h); info.PendingDeltas != 1 {
+		t.Errorf("Expected a medium addition held after the urgent push, got %+v", info)
+	}
+
+	// A reconnect is caught up at once, whatever its schedule.
+	_, env := h.DialWSQuery("max_frequency=15m&last_version=2")
+	if env.Version != h.FilterSnapshot().Version {
+		t.Errorf("Expected a reconnect sent version %d, got %d", h.FilterSnapshot().Version, env.Version)
+	}
+}
+
+func TestPushScheduleValidation(t *testing.T) {
+	h := startScheduleHarness(t)
+	for _, query := range []string{"max_frequency=0s", "max_frequency=48h", "max_frequency=soon", "urgent_severity=high", "max_frequency=1m&urgent_severity=severe"} {
+		if status, body := h.Do(http.MethodGet, "/ws?"+query, ""); status != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", query, status, body)
+		}
+	}
+
+	cfg := swarm.DefaultConfig()
+	cfg.Push.CategorySeverity = map[string]swarm.Severity{"drainer": "urgent"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "push.category_severity") {
+		t.Errorf("Expected push.category_severity validated, got %v", err)
+	}
+}
@@ -0,0 +1,417 @@
+// Package swarm — Per-source report quotas and bans.
+//
+// Rate limiting stops one client flooding the endpoint; quotas stop one
+// SourceID abusing TWAB, by reporting thousands of distinct benign
+// addresses or hammering the same few.  Each source is counted in coarse
+// fixed windows (an hour for reports, a day for unique addresses).  A
+// source over quota is banned and its reports rejected with 403; every
+// repeat offense doubles the ban, up to the configured maximum.
+//
+// Memory is bounded: unique addresses are kept as 64-bit hashes and stop
+// accumulating at the limit, and at most maxTrackedSources sources are
+// tracked.  With persistence.quota_state_file set, counters and bans are
+// saved periodically and on shutdown, so a restart does not reset an
+// attacker's clock.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// maxTrackedSources bounds the per-source quota state.
+	maxTrackedSources = 10000
+
+	quotaHour = time.Hour
+	quotaDay  = 24 * time.Hour
+
+	// quotaSaveInterval is how often quota state is persisted.
+	quotaSaveInterval = time.Minute
+)
+
+// Enabled reports whether any quota is set.
+func (c QuotaConfig) Enabled() bool {
+	return c.ReportsPerHour > 0 || c.UniqueAddressesPerDay > 0
+}
+
+// BanError is returned for reports from a banned source.
+type BanError struct {
+	SourceID string
+	Until    time.Time
+
+	reason string // set only on the report that caused the ban
+}
+
+func (e *BanError) Error() string {
+	return fmt.Sprintf("source %q is banned until %s", e.SourceID, e.Until.UTC().Format(time.RFC3339))
+}
+
+// Ban is the GET /admin/bans representation of a banned source.
+type Ban struct {
+	SourceID    string    `json:"source_id"`
+	Reason      string    `json:"reason"`
+	Offenses    int       `json:"offenses"`
+	BannedUntil time.Time `json:"banned_until"`
+}
+
+// sourceQuota is the state of one source.  It is also the persisted form.
+type sourceQuota struct {
+	HourStart    time.Time `json:"hour_start"`
+	HourReports  int       `json:"hour_reports"`
+	DayStart     time.Time `json:"day_start"`
+	DayAddresses []uint64  `json:"day_addresses"` // sorted hashes
+
+	Offenses    int       `json:"offenses"`
+	BannedUntil time.Time `json:"banned_until"`
+	Reason      string    `json:"reason,omitempty"`
+}
+
+// idle reports whether the state carries nothing worth keeping.
+func (q *sourceQuota) idle(now time.Time) bool {
+	return q.Offenses == 0 && now.Sub(q.HourStart) >= quotaHour && now.Sub(q.DayStart) >= quotaDay
+}
+
+// seen records an address hash, reporting the unique count.  Hashes stop
+// accumulating once past limit.
+func (q *sourceQuota) seen(h uint64, limit int) int {
+	i := sort.Search(len(q.DayAddresses), func(i int) bool { return q.DayAddresses[i] >= h })
+	if i < len(q.DayAddresses) && q.DayAddresses[i] == h {
+		return len(q.DayAddresses)
+	}
+	if len(q.DayAddresses) > limit {
+		return len(q.DayAddresses) + 1
+	}
+	q.DayAddresses = append(q.DayAddresses, 0)
+	copy(q.DayAddresses[i+1:], q.DayAddresses[i:])
+	q.DayAddresses[i] = h
+	return len(q.DayAddresses)
+}
+
+type quotaTracker struct {
+	config QuotaConfig
+
+	mu      sync.Mutex
+	sources map[string]*sourceQuota
+
+	saveMu sync.Mutex // serializes writers of the state file
+}
+
+func newQuotaTracker(cfg QuotaConfig) *quotaTracker {
+	return &quotaTracker{config: cfg, sources: make(map[string]*sourceQuota)}
+}
+
+func addressHash(address string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(address))
+	return h.Sum64()
+}
+
+// admit counts a report from source and returns a *BanError if the source
+// is banned, including by this report.
+func (t *quotaTracker) admit(source, address string, now time.Time) error {
+	if !t.config.Enabled() {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q, ok := t.sources[source]
+	if !ok {
+		t.makeRoomLocked(now)
+		q = &sourceQuota{HourStart: now, DayStart: now}
+		t.sources[source] = q
+	}
+	if now.Before(q.BannedUntil) {
+		return &BanError{SourceID: source, Until: q.BannedUntil}
+	}
+
+	if now.Sub(q.HourStart) >= quotaHour {
+		q.HourStart, q.HourReports = now, 0
+	}
+	if now.Sub(q.DayStart) >= quotaDay {
+		q.DayStart, q.DayAddresses = now, nil
+	}
+
+	q.HourReports++
+	if limit := t.config.ReportsPerHour; limit > 0 && q.HourReports > limit {
+		return t.banLocked(source, q, now, fmt.Sprintf("more than %d reports in an hour", limit))
+	}
+	if limit := t.config.UniqueAddressesPerDay; limit > 0 && q.seen(addressHash(address), limit) > limit {
+		return t.banLocked(source, q, now, fmt.Sprintf("more than %d unique addresses in a day", limit))
+	}
+	return nil
+}
+
+// banLocked bans source for the ban duration doubled per prior offense,
+// and starts its windows afresh for when the ban lifts.
+func (t *quotaTracker) banLocked(source string, q *sourceQuota, now time.Time, reason string) error {
+	q.Offenses++
+	d := time.Duration(t.config.BanDuration)
+	max := time.Duration(t.config.MaxBanDuration)
+	for i := 1; i < q.Offenses && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	q.BannedUntil = now.Add(d)
+	q.Reason = reason
+	q.HourStart, q.HourReports = q.BannedUntil, 0
+	q.DayStart, q.DayAddresses = q.BannedUntil, nil
+	log.Printf("Banned source %q until %s: %s (offense %d)", source, q.BannedUntil.Format(time.RFC3339), reason, q.Offenses)
+	return &BanError{SourceID: source, Until: q.BannedUntil, reason: reason}
+}
+
+// makeRoomLocked frees a slot when the tracker is full: idle sources go
+// first, then any source that is not currently banned.
+func (t *quotaTracker) makeRoomLocked(now time.Time) {
+	if len(t.sources) < maxTrackedSources {
+		return
+	}
+	for id, q := range t.sources {
+		if q.idle(now) {
+			delete(t.sources, id)
+		}
+	}
+	for id, q := range t.sources {
+		if len(t.sources) < maxTrackedSources {
+			return
+		}
+		if !now.Before(q.BannedUntil) {
+			delete(t.sources, id)
+		}
+	}
+}
+
+// Bans returns the currently banned sources, sorted by ID.
+func (t *quotaTracker) Bans(now time.Time) []Ban {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bans := []Ban{}
+	for id, q := range t.sources {
+		if now.Before(q.BannedUntil) {
+			bans = append(bans, Ban{SourceID: id, Reason: q.Reason, Offenses: q.Offenses, BannedUntil: q.BannedUntil})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].SourceID < bans[j].SourceID })
+	return bans
+}
+
+// SourceQuotaStatus is where a source stands against its quotas, as
+// shown by GET /admin/sources.  A zero limit is unset.
+type SourceQuotaStatus struct {
+	HourReports           int        `json:"hour_reports"`
+	ReportsPerHour        int        `json:"reports_per_hour,omitempty"`
+	DayAddresses          int        `json:"day_addresses"`
+	UniqueAddressesPerDay int        `json:"unique_addresses_per_day,omitempty"`
+	Offenses              int        `json:"offenses,omitempty"`
+	BannedUntil           *time.Time `json:"banned_until,omitempty"`
+}
+
+// status returns the quota standing of source, false if quotas are off
+// or the source is not tracked.
+func (t *quotaTracker) status(source string, now time.Time) (SourceQuotaStatus, bool) {
+	if !t.config.Enabled() {
+		return SourceQuotaStatus{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q, ok := t.sources[source]
+	if !ok {
+		return SourceQuotaStatus{}, false
+	}
+	st := SourceQuotaStatus{
+		ReportsPerHour:        t.config.ReportsPerHour,
+		UniqueAddressesPerDay: t.config.UniqueAddressesPerDay,
+		Offenses:              q.Offenses,
+	}
+	if now.Sub(q.HourStart) < quotaHour {
+		st.HourReports = q.HourReports
+	}
+	if now.Sub(q.DayStart) < quotaDay {
+		st.DayAddresses = len(q.DayAddresses)
+	}
+	if now.Before(q.BannedUntil) {
+		until := q.BannedUntil
+		st.BannedUntil = &until
+	}
+	return st, true
+}
+
+// Lift clears a source's ban and its offense history.  It returns false
+// if the source was not banned.
+func (t *quotaTracker) Lift(source string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	q, ok := t.sources[source]
+	if !ok || !now.Before(q.BannedUntil) {
+		return false
+	}
+	delete(t.sources, source)
+	return true
+}
+
+// quotaState is the on-disk form of the tracker.
+type quotaState struct {
+	Sources map[string]*sourceQuota `json:"sources"`
+}
+
+// save writes the tracker state to path atomically.
+func (t *quotaTracker) save(path string) error {
+	t.saveMu.Lock()
+	defer t.saveMu.Unlock()
+
+	t.mu.Lock()
+	data, err := json.Marshal(quotaState{Sources: t.sources})
+	t.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// writeFileAtomic replaces the file at path with data through a temporary
+// file, creating its directory if needed.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load replaces the tracker state with the file at path.  A missing file
+// is not an error.
+func (t *quotaTracker) load(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state quotaState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("quota state %s: %w", path, err)
+	}
+	if state.Sources == nil {
+		state.Sources = make(map[string]*sourceQuota)
+	}
+	t.mu.Lock()
+	t.sources = state.Sources
+	t.mu.Unlock()
+	return nil
+}
+
+// runQuotaPersister saves quota state every quotaSaveInterval until ctx
+// is done.  The final save on shutdown is left to the caller.
+func (s *SwarmAggregator) runQuotaPersister(ctx context.Context, path string) {
+	ticker := time.NewTicker(quotaSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.quotas.save(path); err != nil {
+				log.Printf("Failed to save quota state: %v", err)
+			}
+		}
+	}
+}
+
+// SubmitReport applies the source quota and then ingests the report.  It
+// is the entry point for reports from the network; a *BanError,
+// *ChainError, or *AddressError means the report was rejected.
+func (s *SwarmAggregator) SubmitReport(ctx context.Context, report IOCReport) (bool, error) {
+	if err := s.admitReport(ctx, &report); err != nil {
+		return false, err
+	}
+	return s.IngestReport(ctx, report), nil
+}
+
+// admitReport checks the report's chain, normalizes its address and
+// evidence, applies the timestamp skew policy, and counts the report
+// against its source's quota.  Rejections from the global swarm are
+// counted for GET /admin/sources.
+func (s *SwarmAggregator) admitReport(ctx context.Context, report *IOCReport) (err error) {
+	defer func() {
+		if err != nil && report.Namespace == "" {
+			s.sourceStats.recordRejected(report.SourceID, s.clock.Now())
+		}
+	}()
+	report.Sanitized = nil
+	if err := s.checkChain(*report); err != nil {
+		return err
+	}
+	if err := s.normalizeReport(report); err != nil {
+		return err
+	}
+	if err := s.checkEvidence(report); err != nil {
+		return err
+	}
+	now := s.clock.Now()
+	if err := s.checkTimestamp(report, now); err != nil {
+		return err
+	}
+	err = s.quotas.admit(report.SourceID, report.Address, now)
+	if err != nil {
+		s.metrics.quotaRejections.Inc()
+	}
+	if ban, ok := err.(*BanError); ok && ban.reason != "" {
+		s.auditSystem(AuditEvent{Action: AuditBan, Subject: ban.SourceID, Reason: ban.reason, Time: now})
+		until := ban.Until
+		s.events.publish(ctx, Event{Type: EventBanned, Time: now, SourceID: ban.SourceID, Reason: ban.reason, Until: &until})
+	}
+	return err
+}
+
+// writeBanError answers a rejected ingest with 403 and Retry-After.
+func writeBanError(w http.ResponseWriter, r *http.Request, ban *BanError) {
+	retry := int(time.Until(ban.Until).Seconds()) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(retry))
+	writeError(w, r, http.StatusForbidden, CodeSourceBanned, ban.Error())
+}
+
+// handleAdminBans is the HTTP handler for GET and DELETE /admin/bans.
+// DELETE takes the source to unban in the source query parameter, and an
+// optional reason for the audit log.
+func (s *SwarmAggregator) handleAdminBans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"bans": s.quotas.Bans(s.clock.Now())})
+	case http.MethodDelete:
+		source := r.URL.Query().Get("source")
+		if source == "" {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Missing source")
+			return
+		}
+		if !s.auditAdmin(w, r, AuditEvent{Action: AuditBanLift, Subject: source}) {
+			return
+		}
+		if !s.quotas.Lift(source, s.clock.Now()) {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "Source is not banned")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"lifted": source})
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+	}
+}
@@ -0,0 +1,166 @@
+package swarm
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testQuota(reportsPerHour, uniquePerDay int) QuotaConfig {
+	return QuotaConfig{
+		ReportsPerHour:        reportsPerHour,
+		UniqueAddressesPerDay: uniquePerDay,
+		BanDuration:           Duration(time.Hour),
+		MaxBanDuration:        Duration(3 * time.Hour),
+	}
+}
+
+func TestQuotaBansAndEscalates(t *testing.T) {
+	q := newQuotaTracker(testQuota(3, 0))
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if err := q.admit("spammer", "0xSame", now); err != nil {
+			t.Fatalf("Report %d within quota rejected: %v", i, err)
+		}
+	}
+	var ban *BanError
+	if err := q.admit("spammer", "0xSame", now); !errors.As(err, &ban) || !ban.Until.Equal(now.Add(time.Hour)) {
+		t.Fatalf("Expected a one-hour ban, got %v", err)
+	}
+	if err := q.admit("honest", "0xSame", now); err != nil {
+		t.Errorf("Other sources must be unaffected: %v", err)
+	}
+
+	// Once the ban lapses the source starts afresh; a second offense
+	// doubles the ban, a third is capped at the maximum.
+	for offense, want := range []time.Duration{2 * time.Hour, 3 * time.Hour} {
+		now = ban.Until
+		for i := 0; i < 3; i++ {
+			if err := q.admit("spammer", "0xSame", now); err != nil {
+				t.Fatalf("Offense %d: report after ban lapsed rejected: %v", offense+2, err)
+			}
+		}
+		if err := q.admit("spammer", "0xSame", now); !errors.As(err, &ban) || ban.Until.Sub(now) != want {
+			t.Fatalf("Offense %d: expected a %v ban, got %v", offense+2, want, err)
+		}
+	}
+}
+
+func TestQuotaUniqueAddressesPerDay(t *testing.T) {
+	q := newQuotaTracker(testQuota(0, 2))
+	now := time.Now()
+	for _, addr := range []string{"0xA", "0xB", "0xA", "0xB"} {
+		if err := q.admit("src", addr, now); err != nil {
+			t.Fatalf("Repeat addresses must not count twice: %v", err)
+		}
+	}
+	if err := q.admit("src", "0xC", now); err == nil {
+		t.Fatal("Expected a ban for a third unique address")
+	}
+
+	// A new day resets the count.
+	q = newQuotaTracker(testQuota(0, 2))
+	q.admit("src", "0xA", now)
+	q.admit("src", "0xB", now)
+	if err := q.admit("src", "0xC", now.Add(25*time.Hour)); err != nil {
+		t.Errorf("Expected a fresh daily window, got %v", err)
+	}
+}
+
+func TestQuotaStateIsBounded(t *testing.T) {
+	q := newQuotaTracker(testQuota(1, 0))
+	now := time.Now()
+	q.admit("bad", "0x1", now)
+	q.admit("bad", "0x1", now) // banned
+	for i := 0; i < maxTrackedSources+50; i++ {
+		q.admit(fmt.Sprintf("src-%d", i), "0x1", now)
+	}
+	if len(q.sources) > maxTrackedSources {
+		t.Errorf("Tracked %d sources, limit %d", len(q.sources), maxTrackedSources)
+	}
+	if len(q.Bans(now)) != 1 {
+		t.Error("Eviction must keep banned sources")
+	}
+}
+
+func TestQuotaStateSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	now := time.Now()
+
+	q := newQuotaTracker(testQuota(2, 0))
+	q.admit("attacker", "0x1", now)
+	q.admit("attacker", "0x1", now)
+	q.admit("attacker", "0x1", now) // banned
+	q.admit("patient", "0x1", now)
+	q.admit("patient", "0x1", now) // at quota
+	if err := q.save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	restarted := newQuotaTracker(testQuota(2, 0))
+	if err := restarted.load(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if err := restarted.admit("attacker", "0x1", now); err == nil {
+		t.Error("Ban must survive a restart")
+	}
+	if err := restarted.admit("patient", "0x1", now); err == nil {
+		t.Error("Counters must survive a restart")
+	}
+	if err := newQuotaTracker(testQuota(2, 0)).load(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("A missing state file must not be an error: %v", err)
+	}
+}
+
+func TestBannedSourceGets403AndAdminCanLift(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Quota = testQuota(1, 0)
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.keys.Add("admin-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	do := func(method, path, body string) *http.Response {
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		return resp
+	}
+	report := fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.5,"source_id":"mallory"}`, evmAddress("spam"))
+
+	if resp := do(http.MethodPost, "/ingest", report); resp.StatusCode != http.StatusOK {
+		t.Fatalf("First report should be accepted, got %d", resp.StatusCode)
+	}
+	resp := do(http.MethodPost, "/ingest", report)
+	if resp.StatusCode != http.StatusForbidden || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("Expected 403 with Retry-After, got %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodPost, "/ingest/batch", "["+report+"]"); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected an all-banned batch to be rejected, got %d", resp.StatusCode)
+	}
+
+	if bans := agg.quotas.Bans(time.Now()); len(bans) != 1 || bans[0].SourceID != "mallory" {
+		t.Fatalf("Expected mallory banned, got %+v", bans)
+	}
+	if resp := do(http.MethodGet, "/admin/bans", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("GET /admin/bans returned %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodDelete, "/admin/bans?source=mallory", ""); resp.StatusCode != http.StatusOK {
+		t.Fatalf("DELETE /admin/bans returned %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodDelete, "/admin/bans?source=mallory", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Lifting twice should 404, got %d", resp.StatusCode)
+	}
+	if resp := do(http.MethodPost, "/ingest", report); resp.StatusCode != http.StatusOK {
+		t.Errorf("Lifted source should be accepted again, got %d", resp.StatusCode)
+	}
+}
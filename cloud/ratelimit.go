@@ -0,0 +1,107 @@
+// Package swarm — Ingest rate limiting.
+//
+// A token bucket per client IP protects the ingest endpoints from a
+// single noisy reporter.  Buckets are forgotten wholesale once too many
+// clients are tracked, which bounds memory at the cost of briefly
+// refilling everyone, and when a reload changes the limits.
+package swarm
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ErrRateLimited is returned for a request its client's bucket has no
+// token for.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// maxTrackedClients bounds the per-client bucket map.
+const maxTrackedClients = 10000
+
+type ingestLimiter struct {
+	mu      sync.Mutex
+	limit   rate.Limit // zero disables limiting
+	burst   int
+	clients map[string]*rate.Limiter
+}
+
+func newIngestLimiter(cfg RateLimitConfig) *ingestLimiter {
+	l := &ingestLimiter{}
+	l.configure(cfg)
+	return l
+}
+
+// configure applies new limits, forgetting every client's bucket.
+func (l *ingestLimiter) configure(cfg RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.limit, l.burst = 0, cfg.IngestBurst
+	if cfg.IngestPerSecond > 0 {
+		l.limit = rate.Limit(cfg.IngestPerSecond)
+	}
+	l.clients = make(map[string]*rate.Limiter)
+}
+
+func (l *ingestLimiter) allow(client string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit == 0 {
+		return true
+	}
+
+	lim, ok := l.clients[client]
+	if !ok {
+		if len(l.clients) >= maxTrackedClients {
+			l.clients = make(map[string]*rate.Limiter)
+		}
+		lim = rate.NewLimiter(l.limit, l.burst)
+		l.clients[client] = lim
+	}
+	return lim.Allow()
+}
+
+// prune forgets the buckets of clients idle long enough to have refilled,
+// which would be created full anyway.  It is a maintenance task (see
+// maintenance.go).
+func (l *ingestLimiter) prune(ctx context.Context) TaskStats {
+	var stats TaskStats
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client, lim := range l.clients {
+		if ctx.Err() != nil {
+			break
+		}
+		stats.Items++
+		if lim.TokensAt(now) >= float64(l.burst) {
+			delete(l.clients, client)
+		}
+	}
+	return stats
+}
+
+// clientIP is the remote host of the request, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// allowIngest charges an ingest request to its client's bucket, answering
+// 429 if it is empty.  Handlers call it once they know the request is not
+// an idempotent replay (see idempotency.go), which is never charged.
+func (s *SwarmAggregator) allowIngest(w http.ResponseWriter, r *http.Request) bool {
+	if !s.limiter.allow(clientIP(r)) {
+		writeAPIError(w, r, ErrRateLimited)
+		return false
+	}
+	return true
+}
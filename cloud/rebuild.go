@@ -0,0 +1,187 @@
+// Package swarm — Filter rebuild.
+//
+// The confirmed set is authoritative; the global filter follows it change
+// by change, and its encoding was sized for bloom.expected_items when the
+// aggregator started.  RebuildFilter recomputes the filter from the
+// confirmed set, optionally with new parameters, and swaps it in as one
+// new version.  The confirmed set is copied under a read lock, so
+// promotions carry on while the new entry set is built; the changes made
+// to the filter meanwhile are then replayed onto it under the write lock
+// just before the swap, so none is lost.  When the change history no
+// longer covers that window the copy is redone under the write lock.
+//
+// The rebuilt filter is pushed straight away as a full snapshot flagged
+// rebuild, so subscribers replace their copy rather than merging into it.
+// Its change history starts afresh: subscribers resuming from an earlier
+// version get a resync snapshot, and the next push carries no summary.
+//
+// POST /admin/rebuild triggers a rebuild; with bloom.rebuild_fill_ratio
+// set, a maintenance task also rebuilds, resized for the current count,
+// once the estimated fraction of bits set in the encoding reaches it.
+// Rebuilds are audited and counted in aegis_filter_rebuilds_total.
+// Namespace filters are not rebuilt.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/aegis-protocol/swarm/bloom"
+)
+
+// rebuildHeadroom is how many times the current count a resized filter
+// is sized for, so it does not fill up again at once.
+const rebuildHeadroom = 2
+
+// RebuildResult describes a rebuilt filter.
+type RebuildResult struct {
+	Version   uint64  `json:"filter_version"`
+	Entries   int     `json:"entries"`
+	FillRatio float64 `json:"fill_ratio"`
+	BloomParams
+}
+
+// RebuildFilter rebuilds the global filter from the confirmed set with
+// params, or the current parameters if params is zero, and pushes it.
+func (s *SwarmAggregator) RebuildFilter(params BloomParams) error {
+	_, err := s.rebuildFilter(context.Background(), params)
+	return err
+}
+
+// rebuildParams checks the parameters of a rebuild, filling in the
+// current ones for zero and the configured hash for none.
+func (s *SwarmAggregator) rebuildParams(params BloomParams) (BloomParams, error) {
+	if params == (BloomParams{}) {
+		return s.bloomFilter.Params(), nil
+	}
+	if params.Hash == "" {
+		params.Hash = s.config.Bloom.hash()
+	}
+	if params.Bits == 0 || params.Hashes == 0 {
+		return params, errors.New("bloom parameters need bits and hashes")
+	}
+	if _, ok := bloom.HasherFor(params.Hash); !ok {
+		return params, fmt.Errorf("unknown hash algorithm %q", params.Hash)
+	}
+	return params, nil
+}
+
+func (s *SwarmAggregator) rebuildFilter(ctx context.Context, params BloomParams) (RebuildResult, error) {
+	params, err := s.rebuildParams(params)
+	if err != nil {
+		return RebuildResult{}, err
+	}
+
+	s.mu.RLock()
+	entries := s.confirmedAddressesLocked()
+	since := s.bloomFilter.Version()
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	if changes, _, ok := s.bloomFilter.ChangesSince(since); ok {
+		for _, c := range changes {
+			if c.Removed {
+				delete(entries, c.Address)
+			} else {
+				entries[c.Address] = true
+			}
+		}
+	} else {
+		entries = s.confirmedAddressesLocked()
+	}
+	version := s.bloomFilter.Replace(entries, params)
+	s.bitPatches.reset() // subscribers holding bits resync (see bitpatch.go)
+	snap := s.filterStateLocked().snap
+	snap.rebuild = true
+	s.pushes.offer(ctx, snap) // before any push of this version (see pushqueue.go)
+	s.mu.Unlock()
+
+	s.metrics.filterRebuilds.Inc()
+	log.Printf("Rebuilt filter at v%d: %d entries in %d bits, %d %s hashes", version, len(entries), params.Bits, params.Hashes, params.Hash)
+	s.pushes.drain(s.broadcastSnapshot)
+	return RebuildResult{Version: version, Entries: len(entries), FillRatio: s.bloomFilter.FillRatio(), BloomParams: params}, nil
+}
+
+// confirmedAddressesLocked returns the confirmed addresses as a filter
+// entry set.  s.mu must be held.
+func (s *SwarmAggregator) confirmedAddressesLocked() map[string]bool {
+	entries := make(map[string]bool, len(s.confirmed))
+	for addr := range s.confirmed {
+		entries[addr] = true
+	}
+	return entries
+}
+
+// resizedParams sizes the filter encoding for the current count with
+// headroom, and at least bloom.expected_items.
+func (s *SwarmAggregator) resizedParams() BloomParams {
+	n := max(uint(s.bloomFilter.Len())*rebuildHeadroom, s.config.Bloom.ExpectedItems)
+	return s.config.Bloom.paramsFor(n)
+}
+
+// rebuildIfFull is the maintenance task rebuilding the filter, resized,
+// once its fill ratio reaches bloom.rebuild_fill_ratio.
+func (s *SwarmAggregator) rebuildIfFull(ctx context.Context) TaskStats {
+	fill := s.bloomFilter.FillRatio()
+	if fill < s.config.Bloom.RebuildFillRatio {
+		return TaskStats{}
+	}
+	res, err := s.rebuildFilter(ctx, s.resizedParams())
+	if err != nil {
+		log.Printf("Failed to rebuild filter at fill ratio %.2f: %v", fill, err)
+		return TaskStats{}
+	}
+	s.auditSystem(AuditEvent{Action: AuditFilterRebuild, Reason: fmt.Sprintf("fill ratio %.2f", fill)})
+	return TaskStats{Items: res.Entries}
+}
+
+// rebuildRequest is the optional body of POST /admin/rebuild: explicit
+// parameters, or resize to size them for the current count.  Neither
+// keeps the current parameters.
+type rebuildRequest struct {
+	BloomParams
+	Resize bool `json:"resize"`
+}
+
+// handleAdminRebuild is the HTTP handler for POST /admin/rebuild.
+func (s *SwarmAggregator) handleAdminRebuild(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req rebuildRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeBodyError(w, r, err, "Invalid JSON body")
+			return
+		}
+	}
+	params := req.BloomParams
+	if req.Resize {
+		if params != (BloomParams{}) {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Give either resize or parameters, not both")
+			return
+		}
+		params = s.resizedParams()
+	}
+	params, err := s.rebuildParams(params)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditFilterRebuild}) {
+		return
+	}
+	res, err := s.rebuildFilter(r.Context(), params)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,146 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRebuildFilterFromConfirmedSet(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2})
+	kept := evmAddress("kept")
+	promote(agg, kept, "phishing")
+	agg.bloomFilter.Add(evmAddress("drifted")) // never confirmed
+	before := agg.bloomFilter.Version()
+
+	ch := agg.Subscribe("rebuild")
+	defer agg.Unsubscribe("rebuild")
+	params := BloomParams{Bits: 1 << 12, Hashes: 3}
+	if err := agg.RebuildFilter(params); err != nil {
+		t.Fatalf("RebuildFilter: %v", err)
+	}
+
+	entries, version := agg.bloomFilter.Snapshot()
+	if len(entries) != 1 || entries[0] != kept || version != before+1 {
+		t.Errorf("Expected only the confirmed address at v%d, got %v at v%d", before+1, entries, version)
+	}
+	if got := agg.bloomFilter.Params(); got != (BloomParams{Bits: 1 << 12, Hashes: 3, Hash: HashXXH64}) {
+		t.Errorf("Expected the new parameters, got %+v", got)
+	}
+	env := recvEnvelope(t, ch)
+	if !env.Rebuild || env.Kind != envelopeSnapshot || env.Version != version || env.Summary != nil {
+		t.Errorf("Expected a rebuild snapshot at v%d without a summary, got %+v", version, env)
+	}
+	if _, _, ok := agg.bloomFilter.ChangesSince(before); ok {
+		t.Error("Expected history from before the rebuild to be gone")
+	}
+
+	if err := agg.RebuildFilter(BloomParams{}); err != nil || agg.bloomFilter.Params().Bits != 1<<12 {
+		t.Errorf("Expected zero parameters to keep the current ones, got %v %+v", err, agg.bloomFilter.Params())
+	}
+	for _, bad := range []BloomParams{{Bits: 1024}, {Bits: 1024, Hashes: 2, Hash: "md5"}} {
+		if err := agg.RebuildFilter(bad); err == nil {
+			t.Errorf("Expected %+v to be rejected", bad)
+		}
+	}
+}
+
+func TestRebuildKeepsConcurrentPromotions(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2})
+	for i := 0; i < 200; i++ {
+		promote(agg, evmAddress(fmt.Sprintf("before-%d", i)), "")
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				promote(agg, evmAddress(fmt.Sprintf("during-%d-%d", w, i)), "")
+			}
+		}(w)
+	}
+	for i := 0; i < 20; i++ {
+		if err := agg.RebuildFilter(BloomParams{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	if got, want := agg.BloomFilterLen(), 600; got != want {
+		t.Fatalf("Expected %d entries after rebuilding during promotions, got %d", want, got)
+	}
+	for addr := range agg.confirmed {
+		if !agg.bloomFilter.Contains(addr) {
+			t.Fatalf("Confirmed %s is missing from the rebuilt filter", addr)
+		}
+	}
+}
+
+func TestAdminRebuild(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Bloom.ExpectedItems = 10
+	agg, _ := newAuditedAggregator(t, cfg)
+	for i := 0; i < 30; i++ {
+		promote(agg, evmAddress(fmt.Sprintf("admin-%d", i)), "")
+	}
+
+	rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/rebuild?reason=saturated", `{"resize":true}`)
+	var res RebuildResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("POST /admin/rebuild: %d %v", rec.Code, err)
+	}
+	if want := cfg.Bloom.paramsFor(60); res.BloomParams != want || res.Entries != 30 {
+		t.Errorf("Expected 30 entries resized to %+v, got %+v", want, res)
+	}
+	if page := queryAudit(t, agg, "?action=filter_rebuild"); len(page.Events) != 1 || page.Events[0].Reason != "saturated" {
+		t.Errorf("Expected the rebuild audited, got %+v", page.Events)
+	}
+
+	for body, want := range map[string]int{
+		`{"resize":true,"bits":64}`: http.StatusBadRequest,
+		`{"bits":64}`:               http.StatusBadRequest,
+		`{`:                         http.StatusBadRequest,
+		``:                          http.StatusOK,
+	} {
+		if rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/rebuild", body); rec.Code != want {
+			t.Errorf("Body %q: expected %d, got %d %s", body, want, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestRebuildWhenFull(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Bloom.ExpectedItems = 10
+	cfg.Bloom.RebuildFillRatio = 0.5
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	for i := 0; i < 5; i++ {
+		promote(agg, evmAddress(fmt.Sprintf("full-%d", i)), "")
+	}
+
+	agg.maintenance.RunOnce(context.Background())
+	if testutil.ToFloat64(agg.metrics.filterRebuilds) != 0 {
+		t.Fatalf("Expected no rebuild at fill ratio %.2f", agg.bloomFilter.FillRatio())
+	}
+	for i := 5; i < 20; i++ {
+		promote(agg, evmAddress(fmt.Sprintf("full-%d", i)), "")
+	}
+	if fill := agg.bloomFilter.FillRatio(); fill < 0.5 {
+		t.Fatalf("Expected the filter past the threshold, fill ratio %.2f", fill)
+	}
+	agg.maintenance.RunOnce(context.Background())
+	if testutil.ToFloat64(agg.metrics.filterRebuilds) != 1 || agg.bloomFilter.Params() != cfg.Bloom.paramsFor(40) {
+		t.Errorf("Expected one rebuild resized for 40 entries, got params %+v", agg.bloomFilter.Params())
+	}
+	if fill := agg.bloomFilter.FillRatio(); fill >= 0.5 {
+		t.Errorf("Expected the rebuilt filter below the threshold, fill ratio %.2f", fill)
+	}
+}
@@ -0,0 +1,330 @@
+// Package swarm — Configuration reload.
+//
+// SIGHUP, or POST /admin/reload from an admin key, resolves the
+// configuration again from the same file, environment, and flags the
+// aggregator started with, validates it, and applies the settings that
+// can change while running: the twab thresholds, rate_limit, push.debounce,
+// the review policies, the chain registry, persistence.allowlist_file, and
+// tripwire.high_value_file, whose files are re-read even if their paths
+// are unchanged.  Every other setting that differs from the running
+// configuration is reported as requiring a restart and left as it is;
+// twab.retain_reports is among them, since it sizes rings already
+// allocated.  A configuration that fails to load or validate changes
+// nothing.
+//
+// The running configuration sits behind an atomic pointer read on every
+// use, so new thresholds apply from the very next report.  Each reload is
+// audited before it is applied, and counted in aegis_config_reloads_total.
+//
+// The allowlist file holds one address per line; blank lines and lines
+// starting with # are ignored.  Its addresses are allowlisted alongside
+// those added through the admin API, and those dropped from the file are
+// taken off the allowlist on the next reload.  The high-value file of the
+// tripwire (see tripwire.go) has the same format.
+package swarm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Reload outcomes recorded in aegis_config_reloads_total.
+const (
+	reloadOutcomeApplied  = "applied"
+	reloadOutcomeRejected = "rejected"
+)
+
+// ErrReloadUnavailable is returned by Reload without a config source.
+var ErrReloadUnavailable = errors.New("reload unavailable: no config source")
+
+// current returns the running configuration.  It must not be modified.
+func (s *SwarmAggregator) current() *Config {
+	return s.live.Load()
+}
+
+// SetConfigSource sets where Reload reads the configuration from.
+func (s *SwarmAggregator) SetConfigSource(src ConfigSource) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	s.configSource = &src
+}
+
+// withReloadable returns running with the reloadable settings of loaded.
+func withReloadable(running, loaded Config) Config {
+	retain := running.TWAB.RetainReports
+	running.TWAB = loaded.TWAB
+	running.TWAB.RetainReports = retain
+	running.RateLimit = loaded.RateLimit
+	running.Push.Debounce = loaded.Push.Debounce
+	running.Review = loaded.Review
+	running.Persistence.AllowlistFile = loaded.Persistence.AllowlistFile
+	running.Tripwire.HighValueFile = loaded.Tripwire.HighValueFile
+	running.Chains = loaded.Chains
+	running.AllowUnknownChains = loaded.AllowUnknownChains
+	return running
+}
+
+// configDiff names the settings that differ between a and b, by their
+// config file keys: "section.key" within a section, the top-level key
+// otherwise.
+func configDiff(a, b Config) []string {
+	var out []string
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		name := configKey(va.Type().Field(i))
+		fa, fb := va.Field(i), vb.Field(i)
+		if fa.Kind() != reflect.Struct {
+			if !reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+				out = append(out, name)
+			}
+			continue
+		}
+		for j := 0; j < fa.NumField(); j++ {
+			if !reflect.DeepEqual(fa.Field(j).Interface(), fb.Field(j).Interface()) {
+				out = append(out, name+"."+configKey(fa.Type().Field(j)))
+			}
+		}
+	}
+	return out
+}
+
+func configKey(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+// ReloadResult is the outcome of a reload.  Applied and RequiresRestart
+// list setting keys; Allowlist and HighValue are set when the allowlist
+// and the high-value file were read.
+type ReloadResult struct {
+	Applied         []string             `json:"applied"`
+	RequiresRestart []string             `json:"requires_restart"`
+	Allowlist       *AllowlistFileResult `json:"allowlist,omitempty"`
+	HighValue       *AllowlistFileResult `json:"high_value,omitempty"`
+}
+
+// AllowlistFileResult is the change a read of the allowlist file, or of
+// the high-value file, made.
+type AllowlistFileResult struct {
+	Path    string `json:"path"`
+	Entries int    `json:"entries"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+}
+
+// reloadPlan is a validated configuration ready to apply.
+type reloadPlan struct {
+	next      Config
+	allowlist map[string]bool // nil without an allowlist file
+	highValue map[string]bool // nil without a high-value file
+	result    ReloadResult
+}
+
+// planReload loads and validates the configuration.  The caller holds
+// reloadMu.
+func (s *SwarmAggregator) planReload() (*reloadPlan, error) {
+	if s.configSource == nil {
+		return nil, ErrReloadUnavailable
+	}
+	loaded, err := s.configSource.Load()
+	if err == nil {
+		err = loaded.Validate()
+	}
+	var allowlist map[string]bool
+	if path := loaded.Persistence.AllowlistFile; err == nil && path != "" {
+		allowlist, err = readAddressFile("allowlist", path)
+	}
+	var highValue map[string]bool
+	if path := loaded.Tripwire.HighValueFile; err == nil && path != "" {
+		highValue, err = readAddressFile("high-value", path)
+	}
+	if err != nil {
+		s.metrics.configReloads.WithLabelValues(reloadOutcomeRejected).Inc()
+		if !errors.Is(err, ErrInvalidConfig) {
+			err = &configError{problems: err}
+		}
+		return nil, err
+	}
+
+	running := *s.current()
+	next := withReloadable(running, loaded)
+	return &reloadPlan{
+		next:      next,
+		allowlist: allowlist,
+		highValue: highValue,
+		result: ReloadResult{
+			Applied:         append([]string{}, configDiff(running, next)...),
+			RequiresRestart: append([]string{}, configDiff(next, loaded)...),
+		},
+	}, nil
+}
+
+// applyReload swaps in a planned configuration.  The caller holds
+// reloadMu.
+func (s *SwarmAggregator) applyReload(ctx context.Context, plan *reloadPlan) ReloadResult {
+	previous := s.current()
+	s.live.Store(&plan.next)
+	if plan.next.RateLimit != previous.RateLimit {
+		s.limiter.configure(plan.next.RateLimit)
+	}
+	if path := plan.next.Persistence.AllowlistFile; path != "" || previous.Persistence.AllowlistFile != "" {
+		res := s.setFileAllowlist(ctx, plan.allowlist)
+		res.Path = path
+		plan.result.Allowlist = &res
+	}
+	if path := plan.next.Tripwire.HighValueFile; path != "" || previous.Tripwire.HighValueFile != "" {
+		res := s.setHighValue(path, plan.highValue)
+		plan.result.HighValue = &res
+	}
+	s.metrics.configReloads.WithLabelValues(reloadOutcomeApplied).Inc()
+	return plan.result
+}
+
+// Reload re-reads and applies the configuration, auditing it as actor.
+// It fails with ErrReloadUnavailable without a config source, and with
+// an error matching ErrInvalidConfig for a configuration that does not
+// load; nothing changes then.
+func (s *SwarmAggregator) Reload(ctx context.Context, actor string) (ReloadResult, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	plan, err := s.planReload()
+	if err != nil {
+		return ReloadResult{}, err
+	}
+	ev := AuditEvent{Actor: actor, Action: AuditConfigReload, Subject: strings.Join(plan.result.Applied, ",")}
+	if err := s.audit.Record(ev); err != nil {
+		return ReloadResult{}, fmt.Errorf("audit: %w", err)
+	}
+	return s.applyReload(ctx, plan), nil
+}
+
+// LoadAllowlistFile reads persistence.allowlist_file, if set, at startup.
+func (s *SwarmAggregator) LoadAllowlistFile(ctx context.Context) (AllowlistFileResult, error) {
+	path := s.current().Persistence.AllowlistFile
+	if path == "" {
+		return AllowlistFileResult{}, nil
+	}
+	addrs, err := readAddressFile("allowlist", path)
+	if err != nil {
+		return AllowlistFileResult{}, err
+	}
+	res := s.setFileAllowlist(ctx, addrs)
+	res.Path = path
+	return res, nil
+}
+
+// readAddressFile parses an allowlist file, or a file of another kind in
+// its format, into normalized addresses.
+func readAddressFile(kind, path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	out := make(map[string]bool)
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		address, err := NormalizeAddress(0, text)
+		if err != nil {
+			return nil, fmt.Errorf("%s file %s line %d: %w", kind, path, line, err)
+		}
+		out[address] = true
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("%s file %s: %w", kind, path, err)
+	}
+	return out, nil
+}
+
+// setFileAllowlist makes addrs the allowlist entries owned by the file,
+// pulling newly allowlisted addresses out of the filter as Allow does.
+func (s *SwarmAggregator) setFileAllowlist(ctx context.Context, addrs map[string]bool) AllowlistFileResult {
+	res := AllowlistFileResult{Entries: len(addrs)}
+	var removed []Event
+	s.mu.Lock()
+	for addr := range addrs {
+		if s.fileAllow[addr] {
+			continue
+		}
+		s.fileAllow[addr] = true
+		if !s.allowlist[addr] {
+			s.allowlist[addr] = true
+			s.tripwire.add(protectedAllowlist, addr)
+			res.Added++
+		}
+		if entry, ok := s.confirmed[addr]; ok {
+			delete(s.confirmed, addr)
+			s.filterRemoveLocked(entry)
+			ev := entryEvent(EventRemoved, entry)
+			ev.FromTier, ev.Reason = TierMain, eventReasonAllowlisted
+			removed = append(removed, ev)
+		}
+	}
+	for addr := range s.fileAllow {
+		if addrs[addr] {
+			continue
+		}
+		delete(s.fileAllow, addr)
+		if s.allowlist[addr] {
+			delete(s.allowlist, addr)
+			s.tripwire.remove(protectedAllowlist, addr)
+			res.Removed++
+		}
+	}
+	s.mu.Unlock()
+
+	s.events.publish(ctx, removed...)
+	return res
+}
+
+// runReloader reloads on every signal until ctx is done.
+func (s *SwarmAggregator) runReloader(ctx context.Context, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-signals:
+			res, err := s.Reload(ctx, auditActorSystem)
+			if err != nil {
+				log.Printf("Config reload failed, keeping the running configuration: %v", err)
+				continue
+			}
+			log.Printf("Config reloaded: applied %v; requires restart %v", res.Applied, res.RequiresRestart)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handleAdminReload is the HTTP handler for POST /admin/reload.
+func (s *SwarmAggregator) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+	plan, err := s.planReload()
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditConfigReload, Subject: strings.Join(plan.result.Applied, ",")}) {
+		return
+	}
+	res := s.applyReload(r.Context(), plan)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,150 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newReloadableAggregator starts an audited aggregator from a config file
+// written with body, returning the file's path.
+func newReloadableAggregator(t *testing.T, body string) (*SwarmAggregator, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "aegis.yaml")
+	writeConfigFile(t, path, body)
+	src, err := ParseConfigSource(flag.NewFlagSet("test", flag.ContinueOnError), []string{"-config", path}, func(string) string { return "" })
+	if err != nil {
+		t.Fatalf("ParseConfigSource failed: %v", err)
+	}
+	cfg, err := src.Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	agg, _ := newAuditedAggregator(t, cfg)
+	agg.SetConfigSource(src)
+	return agg, path
+}
+
+func writeConfigFile(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadAppliesThresholdsToNextIngest(t *testing.T) {
+	agg, path := newReloadableAggregator(t, `
+listen_addr: ":9090"
+twab:
+  min_report_count: 3
+  min_distinct_sources: 2
+`)
+	addr := evmAddress("reloaded")
+	ctx := context.Background()
+	report := IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"}
+	if agg.IngestReport(ctx, report) {
+		t.Fatal("Expected no promotion under the starting thresholds")
+	}
+
+	writeConfigFile(t, path, `
+listen_addr: ":9191"
+twab:
+  min_report_count: 1
+  min_time_span_seconds: 0
+  min_distinct_sources: 1
+`)
+	rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/reload?reason=lower+thresholds", "")
+	var res ReloadResult
+	json.NewDecoder(rec.Body).Decode(&res)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if !slices.Contains(res.Applied, "twab.min_report_count") || !slices.Contains(res.RequiresRestart, "listen_addr") {
+		t.Errorf("Expected thresholds applied and listen_addr left for a restart, got %+v", res)
+	}
+	if agg.current().ListenAddr != ":9090" {
+		t.Errorf("Expected the listen address unchanged, got %q", agg.current().ListenAddr)
+	}
+
+	report.Timestamp = time.Now()
+	if !agg.IngestReport(ctx, report) {
+		t.Error("Expected the very next report to promote under the reloaded thresholds")
+	}
+
+	page := queryAudit(t, agg, "?action=config_reload")
+	if len(page.Events) != 1 || page.Events[0].Actor != "ops" || page.Events[0].Reason != "lower thresholds" {
+		t.Errorf("Expected one audited reload by ops, got %+v", page.Events)
+	}
+	if n := testutil.ToFloat64(agg.metrics.configReloads.WithLabelValues(reloadOutcomeApplied)); n != 1 {
+		t.Errorf("Expected 1 applied reload, got %v", n)
+	}
+}
+
+func TestReloadRejectsInvalidConfig(t *testing.T) {
+	agg, path := newReloadableAggregator(t, "twab:\n  min_report_count: 3\n")
+	writeConfigFile(t, path, "twab:\n  min_average_confidence: 2\n")
+
+	rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/reload", "")
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422, got %d: %s", rec.Code, rec.Body)
+	}
+	if agg.current().TWAB.MinReportCount != 3 {
+		t.Error("An invalid configuration must change nothing")
+	}
+	if n := testutil.ToFloat64(agg.metrics.configReloads.WithLabelValues(reloadOutcomeRejected)); n != 1 {
+		t.Errorf("Expected 1 rejected reload, got %v", n)
+	}
+	if page := queryAudit(t, agg, "?action=config_reload"); len(page.Events) != 0 {
+		t.Errorf("Expected no audit entry for a rejected reload, got %+v", page.Events)
+	}
+
+	if rec := adminRequest(t, NewSwarmAggregator(), "", http.MethodPost, "/admin/reload", ""); rec.Code == http.StatusOK {
+		t.Error("Expected reload to require an admin key")
+	}
+}
+
+func TestReloadAllowlistFile(t *testing.T) {
+	dir := t.TempDir()
+	allowPath := filepath.Join(dir, "allowlist.txt")
+	router, kept := evmAddress("router"), evmAddress("kept")
+	writeConfigFile(t, allowPath, "# known routers\n"+kept+"\n")
+	agg, path := newReloadableAggregator(t, "twab:\n  min_report_count: 1\n  min_time_span_seconds: 0\n  min_distinct_sources: 1\npersistence:\n  allowlist_file: "+allowPath+"\n")
+	if _, err := agg.LoadAllowlistFile(context.Background()); err != nil {
+		t.Fatalf("LoadAllowlistFile failed: %v", err)
+	}
+	promote(agg, router, "")
+
+	writeConfigFile(t, allowPath, router+"\n")
+	res, err := agg.Reload(context.Background(), auditActorSystem)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if a := res.Allowlist; a == nil || a.Added != 1 || a.Removed != 1 || a.Entries != 1 {
+		t.Errorf("Expected one address added and one removed, got %+v", res.Allowlist)
+	}
+	if agg.bloomFilter.Contains(router) {
+		t.Error("Expected a newly allowlisted address pulled from the filter")
+	}
+	if slices.Contains(agg.Allowlist(), kept) {
+		t.Error("Expected an address dropped from the file taken off the allowlist")
+	}
+	if len(res.Applied) != 0 {
+		t.Errorf("Expected no setting changes, got %v", res.Applied)
+	}
+
+	writeConfigFile(t, path, "persistence:\n  allowlist_file: "+filepath.Join(dir, "missing.txt")+"\n")
+	if _, err := agg.Reload(context.Background(), auditActorSystem); err == nil {
+		t.Error("Expected an unreadable allowlist file to fail the reload")
+	}
+	if !slices.Contains(agg.Allowlist(), router) {
+		t.Error("A failed reload must keep the allowlist")
+	}
+}
@@ -0,0 +1,315 @@
+// Package swarm — Peer replication of promotions.
+//
+// Several aggregators can run behind one load balancer.  Each is given an
+// instance ID and the URLs of the others (replication.peers), and forwards
+// every address it promotes by consensus to each peer's POST
+// /internal/replicate, authenticated with a peer-role API key.  Peers must
+// form a full mesh: a receiver applies the promotion but never forwards
+// it again, and refuses events claiming its own instance ID, so an event
+// cannot loop.  Applying an event is idempotent; an address already
+// confirmed is left as it is.  Only consensus promotions replicate; admin
+// actions, feed imports, merges, and expiry stay local to the instance
+// that performs them.
+//
+// Filter versions stay per-instance, since resume deltas are only
+// meaningful against the instance that numbered them.  Envelopes also
+// carry the instance ID and a logical version: the sum of a version
+// vector with this instance's own changes and, for each peer, that
+// peer's count as of the last event it sent.  Instances that have
+// exchanged every event, with no local-only changes since, report the
+// same logical version, so a client switched between them by the load
+// balancer does not see it go backwards.  A subscriber resuming with
+// another instance's ID is sent a resync snapshot.
+package swarm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// replicationPath is the internal endpoint peers post promotions to.
+	replicationPath = "/internal/replicate"
+
+	// replicationQueueSize bounds the promotions waiting for one peer.
+	// Promotions beyond it are dropped and counted.
+	replicationQueueSize = 4096
+
+	// replicationBatchSize caps the promotions sent in one request.
+	replicationBatchSize = 100
+
+	replicationMinBackoff = 100 * time.Millisecond
+	replicationMaxBackoff = 30 * time.Second
+)
+
+// Replication outcomes recorded in aegis_replication_events_total.
+const (
+	replicationOutcomeSent     = "sent"
+	replicationOutcomeFailed   = "failed" // one failed attempt; the batch is retried
+	replicationOutcomeDropped  = "dropped"
+	replicationOutcomeApplied  = "applied"
+	replicationOutcomeSkipped  = "skipped"
+	replicationOutcomeRejected = "rejected"
+)
+
+// provenanceReplicaPrefix starts the Source of an address replicated from
+// a peer instance.
+const provenanceReplicaPrefix = "replica:"
+
+// Enabled reports whether any peers are configured.
+func (c ReplicationConfig) Enabled() bool { return len(c.Peers) > 0 }
+
+// ReplicatedPromotion is one promotion forwarded to a peer.  OriginVersion
+// is the origin's own version vector component once the promotion was
+// applied there.
+type ReplicatedPromotion struct {
+	Origin        string    `json:"origin"`
+	OriginVersion uint64    `json:"origin_version"`
+	Address       string    `json:"address"`
+	ChainID       int       `json:"chain_id"`
+	Category      string    `json:"category,omitempty"`
+	Confidence    float64   `json:"confidence"`
+	PromotedAt    time.Time `json:"promoted_at"`
+}
+
+// replicator forwards local promotions to every peer, one goroutine and
+// queue per peer, so a slow peer never holds up ingest or the others.
+type replicator struct {
+	config  ReplicationConfig
+	client  *http.Client
+	queues  map[string]chan ReplicatedPromotion // peer URL -> pending
+	metrics *prometheus.CounterVec
+}
+
+func newReplicator(cfg ReplicationConfig, metrics *prometheus.CounterVec) *replicator {
+	r := &replicator{
+		config:  cfg,
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout)},
+		queues:  make(map[string]chan ReplicatedPromotion, len(cfg.Peers)),
+		metrics: metrics,
+	}
+	for _, peer := range cfg.Peers {
+		r.queues[strings.TrimRight(peer, "/")] = make(chan ReplicatedPromotion, replicationQueueSize)
+	}
+	return r
+}
+
+// enqueue queues a promotion for every peer.  It never blocks.
+func (r *replicator) enqueue(event ReplicatedPromotion) {
+	for peer, q := range r.queues {
+		select {
+		case q <- event:
+		default:
+			r.metrics.WithLabelValues(peer, replicationOutcomeDropped).Inc()
+		}
+	}
+}
+
+// run delivers queued promotions until ctx is done.
+func (r *replicator) run(ctx context.Context) {
+	for peer, q := range r.queues {
+		go r.deliver(ctx, peer, q)
+	}
+}
+
+// deliver sends promotions to one peer in batches, retrying a failed
+// batch with exponential backoff until it is accepted or ctx is done.
+func (r *replicator) deliver(ctx context.Context, peer string, q chan ReplicatedPromotion) {
+	for {
+		var batch []ReplicatedPromotion
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-q:
+			batch = append(batch, event)
+		}
+	fill:
+		for len(batch) < replicationBatchSize {
+			select {
+			case event := <-q:
+				batch = append(batch, event)
+			default:
+				break fill
+			}
+		}
+
+		for backoff := replicationMinBackoff; ; backoff = min(2*backoff, replicationMaxBackoff) {
+			err := r.send(ctx, peer, batch)
+			if err == nil {
+				r.metrics.WithLabelValues(peer, replicationOutcomeSent).Add(float64(len(batch)))
+				break
+			}
+			r.metrics.WithLabelValues(peer, replicationOutcomeFailed).Inc()
+			log.Printf("Replication to %s failed, retrying in %s: %v", peer, backoff, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+		}
+	}
+}
+
+// send posts one batch to a peer.
+func (r *replicator) send(ctx context.Context, peer string, batch []ReplicatedPromotion) error {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, peer+replicationPath, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+r.config.PeerKey)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("peer returned %s", resp.Status)
+	}
+	return nil
+}
+
+// StartReplication starts forwarding promotions to the configured peers
+// until ctx is done.  Promotions made before it is called are still
+// delivered, up to the queue size.
+func (s *SwarmAggregator) StartReplication(ctx context.Context) {
+	if s.replicator != nil {
+		s.replicator.run(ctx)
+	}
+}
+
+// ownVersionLocked is this instance's own version vector component: the
+// filter changes it made itself.  Caller must hold s.mu.
+func (s *SwarmAggregator) ownVersionLocked() uint64 {
+	return s.bloomFilter.Version() - s.replicaChanges
+}
+
+// logicalVersionLocked is the sum of the version vector for a filter at
+// version.  Caller must hold s.mu.
+func (s *SwarmAggregator) logicalVersionLocked(version uint64) uint64 {
+	logical := version - s.replicaChanges
+	for _, v := range s.peerVersions {
+		logical += v
+	}
+	return logical
+}
+
+// replicatePromotion forwards a fresh consensus promotion to the peers.
+func (s *SwarmAggregator) replicatePromotion(entry ConfirmedEntry, ownVersion uint64) {
+	if s.replicator == nil {
+		return
+	}
+	s.replicator.enqueue(ReplicatedPromotion{
+		Origin:        s.config.Replication.InstanceID,
+		OriginVersion: ownVersion,
+		Address:       entry.Address,
+		ChainID:       entry.ChainID,
+		Category:      entry.Category,
+		Confidence:    entry.Confidence,
+		PromotedAt:    entry.PromotedAt,
+	})
+}
+
+// ReplicationSummary reports the outcome of applying a batch from a peer.
+type ReplicationSummary struct {
+	Applied  int `json:"applied"`
+	Skipped  int `json:"skipped"`
+	Rejected int `json:"rejected"`
+}
+
+// ApplyReplicated applies promotions received from peers.  Events from
+// this instance or with an invalid address are rejected; addresses that
+// are already confirmed or allowlisted are skipped.  Each address applied
+// is published as a promoted event, and subscribers receive one push per
+// batch that added anything.
+func (s *SwarmAggregator) ApplyReplicated(ctx context.Context, events []ReplicatedPromotion) ReplicationSummary {
+	var sum ReplicationSummary
+	var promoted []Event
+	now := s.clock.Now()
+
+	s.mu.Lock()
+	for _, ev := range events {
+		outcome, entry := s.applyReplicatedLocked(ev, now)
+		switch outcome {
+		case replicationOutcomeApplied:
+			sum.Applied++
+			promotion := entryEvent(EventPromoted, entry)
+			promotion.Source, promotion.Tier = entry.Provenance.Source, TierMain
+			promoted = append(promoted, promotion)
+		case replicationOutcomeSkipped:
+			sum.Skipped++
+		default:
+			sum.Rejected++
+		}
+		s.metrics.replicationEvents.WithLabelValues(ev.Origin, outcome).Inc()
+	}
+	s.mu.Unlock()
+
+	s.events.publish(ctx, promoted...)
+	return sum
+}
+
+// applyReplicatedLocked applies one promotion and returns its outcome,
+// with the entry it added when applied.  Caller must hold s.mu.
+func (s *SwarmAggregator) applyReplicatedLocked(ev ReplicatedPromotion, now time.Time) (string, *ConfirmedEntry) {
+	address, err := NormalizeAddress(ev.ChainID, ev.Address)
+	if ev.Origin == "" || ev.Origin == s.config.Replication.InstanceID || err != nil {
+		return replicationOutcomeRejected, nil
+	}
+	if ev.OriginVersion > s.peerVersions[ev.Origin] {
+		s.peerVersions[ev.Origin] = ev.OriginVersion
+	}
+	if s.allowlist[address] {
+		return replicationOutcomeSkipped, nil
+	}
+	if entry, ok := s.confirmed[address]; ok {
+		s.refreshExpiryLocked(entry, now)
+		return replicationOutcomeSkipped, nil
+	}
+	entry := &ConfirmedEntry{
+		Address:    address,
+		ChainID:    ev.ChainID,
+		Category:   ev.Category,
+		Confidence: ev.Confidence,
+		PromotedAt: ev.PromotedAt,
+		Provenance: Provenance{Source: provenanceReplicaPrefix + ev.Origin, Instance: ev.Origin, ImportedAt: now},
+	}
+	s.confirmed[address] = entry
+	s.scheduleExpiryLocked(entry, now)
+	s.filterAddLocked(entry)
+	s.replicaChanges++
+	return replicationOutcomeApplied, entry
+}
+
+// handleReplicate is the HTTP handler for POST /internal/replicate.  The
+// body is a JSON array of ReplicatedPromotion.
+func (s *SwarmAggregator) handleReplicate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var events []ReplicatedPromotion
+	if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+		writeBodyError(w, r, err, "Invalid replication body")
+		return
+	}
+	if len(events) > maxBatchSize {
+		writeError(w, r, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Batch too large")
+		return
+	}
+	sum := s.ApplyReplicated(r.Context(), events)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sum)
+}
@@ -0,0 +1,171 @@
+package swarm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testPeerSecret = "peer-secret"
+
+// startReplicaSet runs one aggregator per instance ID, each replicating
+// to all the others over HTTP.
+func startReplicaSet(t *testing.T, ids ...string) []*SwarmAggregator {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	handlers := make([]http.Handler, len(ids))
+	urls := make([]string, len(ids))
+	for i := range ids {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		urls[i] = srv.URL
+	}
+
+	aggs := make([]*SwarmAggregator, len(ids))
+	for i, id := range ids {
+		cfg := DefaultConfig()
+		cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+		cfg.Replication = ReplicationConfig{InstanceID: id, PeerKey: testPeerSecret, Timeout: Duration(time.Second)}
+		for j, u := range urls {
+			if j != i {
+				cfg.Replication.Peers = append(cfg.Replication.Peers, u)
+			}
+		}
+		agg := NewSwarmAggregatorWithConfig(cfg)
+		agg.keys.Add(testPeerSecret, APIKey{ID: "peer", Role: RolePeer})
+		handlers[i] = agg.Routes()
+		agg.StartReplication(ctx)
+		aggs[i] = agg
+	}
+	return aggs
+}
+
+func confirmedSet(agg *SwarmAggregator) string {
+	agg.mu.RLock()
+	defer agg.mu.RUnlock()
+	var out []string
+	for addr := range agg.confirmed {
+		out = append(out, addr)
+	}
+	sort.Strings(out)
+	return strings.Join(out, ",")
+}
+
+func promoteLocally(agg *SwarmAggregator, addr string) {
+	for _, source := range []string{"agent-A", "agent-B"} {
+		agg.IngestReport(context.Background(), IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: source})
+	}
+}
+
+func TestReplicasConvergeOnConfirmedSet(t *testing.T) {
+	aggs := startReplicaSet(t, "east", "west")
+	east, west := aggs[0], aggs[1]
+	x, y := evmAddress("east-only"), evmAddress("west-only")
+	promoteLocally(east, x)
+	promoteLocally(west, y)
+
+	want := strings.Join([]string{x, y}, ",")
+	if y < x {
+		want = strings.Join([]string{y, x}, ",")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for confirmedSet(east) != want || confirmedSet(west) != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("Replicas did not converge: east=%s west=%s", confirmedSet(east), confirmedSet(west))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if entry, _ := east.Confirmed(y); entry.Provenance.Source != "replica:west" || entry.Provenance.Instance != "west" {
+		t.Errorf("Expected replica provenance, got %+v", entry.Provenance)
+	}
+	if e, w := east.globalSnapshot(), west.globalSnapshot(); e.logical != w.logical || e.logical != 2 {
+		t.Errorf("Expected both logical versions at 2, got east=%d west=%d", e.logical, w.logical)
+	}
+	env, err := east.signedFilter()
+	if err != nil || env.Instance != "east" || env.LogicalVersion != 2 {
+		t.Errorf("Expected the envelope to carry instance and logical version, got %+v (%v)", env, err)
+	}
+}
+
+func TestApplyReplicatedIsIdempotentAndRejectsLoops(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Replication.InstanceID = "east"
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	ctx := context.Background()
+	ev := ReplicatedPromotion{Origin: "west", OriginVersion: 1, Address: evmAddress("twice"), ChainID: 1, Confidence: 1, PromotedAt: time.Now()}
+
+	if sum := agg.ApplyReplicated(ctx, []ReplicatedPromotion{ev}); sum.Applied != 1 {
+		t.Fatalf("Expected the promotion applied, got %+v", sum)
+	}
+	version := agg.bloomFilter.Version()
+	if sum := agg.ApplyReplicated(ctx, []ReplicatedPromotion{ev}); sum.Skipped != 1 || agg.bloomFilter.Version() != version {
+		t.Errorf("Expected a repeat to be skipped without a version bump, got %+v at v%d", sum, agg.bloomFilter.Version())
+	}
+
+	looped := ev
+	looped.Origin, looped.Address = "east", evmAddress("looped")
+	if sum := agg.ApplyReplicated(ctx, []ReplicatedPromotion{looped}); sum.Rejected != 1 {
+		t.Errorf("Expected an event from this instance to be rejected, got %+v", sum)
+	}
+	if _, ok := agg.Confirmed(looped.Address); ok {
+		t.Error("A looped event must not be applied")
+	}
+}
+
+func TestReplicateEndpointRequiresPeerRole(t *testing.T) {
+	agg := NewSwarmAggregator()
+	agg.keys.Add("sub-secret", APIKey{ID: "siem", Role: RoleSubscriber})
+	routes := agg.Routes()
+
+	for secret, want := range map[string]int{"": http.StatusUnauthorized, "sub-secret": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodPost, replicationPath, strings.NewReader(`[]`))
+		if secret != "" {
+			req.Header.Set("X-API-Key", secret)
+		}
+		rec := httptest.NewRecorder()
+		routes.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Key %q: expected %d, got %d", secret, want, rec.Code)
+		}
+	}
+}
+
+func TestResumeFromAnotherInstanceResyncs(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Replication.InstanceID = "east"
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	blockAll(agg, "0xA", "0xB")
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?last_version=1"
+
+	for instance, resync := range map[string]bool{"east": false, "west": true} {
+		conn, _, err := websocket.DefaultDialer.Dial(wsURL+"&instance="+instance, header)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var env FilterEnvelope
+		err = conn.ReadJSON(&env)
+		conn.Close()
+		if err != nil {
+			t.Fatalf("Expected resume reply, got %v", err)
+		}
+		if env.Resync != resync || env.Instance != "east" {
+			t.Errorf("Resuming from %s: expected resync=%v, got %+v", instance, resync, env)
+		}
+	}
+}
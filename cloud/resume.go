@@ -0,0 +1,172 @@
+// Package swarm — Subscriber resume.
+//
+// A subscriber that reconnects with ?last_version=N on /ws is caught up
+// with deltas instead of a full filter download.  The filter retains its
+// most recent changes (push.resume_history); when those reach back to N
+// the aggregator replies with a chain of signed deltas from N to the
+// current version, each at most maxDeltaChanges long.  When they don't,
+// or N is ahead of the filter, it replies with a full snapshot marked
+// resync so the client knows to discard its local copy.
+//
+// A Bloom filter cannot delete, so a delta names its changes exactly: the
+// addresses added and removed, never filter bits.  A client holding the
+// exact set applies both.  A client holding only the bits can apply the
+// additions but not the removals, so a delta that removes anything is
+// marked requires_resync, and such a client must fetch a full snapshot
+// instead of applying it.  A subscriber says which it is with ?deltas=:
+// "exact", the default, or "bloom", which is sent a resync snapshot in
+// place of any resume reply that would require one.  With "bits" it is
+// instead sent a patch of the bits themselves (see bitpatch.go).
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// maxDeltaChanges bounds the changes carried by one delta envelope.
+const maxDeltaChanges = 500
+
+// DeltaMode is how a subscriber applies deltas.
+type DeltaMode string
+
+const (
+	DeltaExact DeltaMode = "exact" // applies additions and removals
+	DeltaBloom DeltaMode = "bloom" // applies additions only
+	DeltaBits  DeltaMode = "bits"  // applies bit patches
+)
+
+// parseDeltaMode parses a deltas parameter; empty means exact.
+func parseDeltaMode(v string) (DeltaMode, error) {
+	switch DeltaMode(v) {
+	case "", DeltaExact:
+		return DeltaExact, nil
+	case DeltaBloom, DeltaBits:
+		return DeltaMode(v), nil
+	}
+	return "", fmt.Errorf("unknown deltas mode %q, want exact, bloom or bits", v)
+}
+
+// FilterDelta is the payload of a delta envelope: the net change from
+// FromVersion to Version, and the parameters of the filter it applies to.
+// RequiresResync is set when anything was removed, which a client holding
+// only Bloom bits cannot apply.
+type FilterDelta struct {
+	Version        uint64   `json:"version"`
+	FromVersion    uint64   `json:"from_version"`
+	Added          []string `json:"added"`
+	Removed        []string `json:"removed"`
+	RequiresResync bool     `json:"requires_resync,omitempty"`
+	BloomParams
+}
+
+// newFilterDelta collapses a contiguous run of changes following from into
+// net additions and removals, each sorted.
+func newFilterDelta(from uint64, changes []FilterChange) FilterDelta {
+	final := make(map[string]bool, len(changes)) // address -> removed
+	for _, c := range changes {
+		final[c.Address] = c.Removed
+	}
+
+	d := FilterDelta{Version: from, FromVersion: from, Added: []string{}, Removed: []string{}}
+	if len(changes) > 0 {
+		d.Version = changes[len(changes)-1].Version
+	}
+	for addr, removed := range final {
+		if removed {
+			d.Removed = append(d.Removed, addr)
+		} else {
+			d.Added = append(d.Added, addr)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	d.RequiresResync = len(d.Removed) > 0
+	return d
+}
+
+// signedDelta signs a delta payload.
+func (s *SwarmAggregator) signedDelta(d FilterDelta) (FilterEnvelope, error) {
+	d.BloomParams = s.bloomFilter.Params()
+	data, err := json.Marshal(d)
+	if err != nil {
+		return FilterEnvelope{}, err
+	}
+	keyID, sig := s.signer.Sign(d.Version, data)
+	env := FilterEnvelope{
+		Kind:        envelopeDelta,
+		Version:     d.Version,
+		FromVersion: d.FromVersion,
+		ToVersion:   d.Version,
+		Instance:    s.config.Replication.InstanceID,
+		KeyID:       keyID,
+		Signature:   sig,
+		Payload:     data,
+
+		PayloadChecksum: payloadChecksum(data),
+	}
+	s.Identity().stamp(&env)
+	return env, nil
+}
+
+// Resume returns the envelopes that bring a subscriber at lastVersion up
+// to date, oldest first.  A subscriber already current gets a single empty
+// delta so it knows the resume succeeded.  With DeltaBloom, deltas that
+// require a resync are replaced by a resync snapshot; with DeltaBits the
+// reply is a single patch envelope, or a resync snapshot.
+func (s *SwarmAggregator) Resume(lastVersion uint64, mode DeltaMode) ([]FilterEnvelope, error) {
+	envs, err := s.resumeDeltas(lastVersion, mode)
+	if err != nil || s.config.Replication.InstanceID == "" {
+		return envs, err
+	}
+	last := &envs[len(envs)-1]
+	if last.LogicalVersion == 0 {
+		s.mu.RLock()
+		last.LogicalVersion = s.logicalVersionLocked(last.Version)
+		s.mu.RUnlock()
+	}
+	return envs, nil
+}
+
+// resumeDeltas builds the Resume reply, a resync snapshot when the
+// history does not reach back to lastVersion, or when it removes anything
+// and the subscriber applies deltas as Bloom bits.
+func (s *SwarmAggregator) resumeDeltas(lastVersion uint64, mode DeltaMode) ([]FilterEnvelope, error) {
+	if mode == DeltaBits {
+		return s.resumeBits(lastVersion)
+	}
+	changes, current, ok := s.bloomFilter.ChangesSince(lastVersion)
+	if ok && mode == DeltaBloom && newFilterDelta(lastVersion, changes).RequiresResync {
+		ok = false
+	}
+	if !ok {
+		env, err := s.signedFilter()
+		if err != nil {
+			return nil, err
+		}
+		env.Resync = true
+		return []FilterEnvelope{env}, nil
+	}
+	if len(changes) == 0 {
+		env, err := s.signedDelta(newFilterDelta(current, nil))
+		if err != nil {
+			return nil, err
+		}
+		return []FilterEnvelope{env}, nil
+	}
+
+	var envs []FilterEnvelope
+	from := lastVersion
+	for len(changes) > 0 {
+		n := min(len(changes), maxDeltaChanges)
+		env, err := s.signedDelta(newFilterDelta(from, changes[:n]))
+		if err != nil {
+			return nil, err
+		}
+		envs = append(envs, env)
+		from = env.ToVersion
+		changes = changes[n:]
+	}
+	return envs, nil
+}
@@ -0,0 +1,155 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/client"
+	"github.com/gorilla/websocket"
+)
+
+func blockAll(agg *SwarmAggregator, addresses ...string) {
+	for _, addr := range addresses {
+		agg.Block(context.Background(), AdminAction{Address: addr})
+	}
+}
+
+func TestResumeWithinHistory(t *testing.T) {
+	agg := NewSwarmAggregator()
+	blockAll(agg, "0xA", "0xB", "0xC")       // v3
+	agg.Unblock(context.Background(), "0xA") // v4
+
+	envs, err := agg.Resume(1, DeltaExact)
+	if err != nil || len(envs) != 1 {
+		t.Fatalf("Expected one delta, got %d (%v)", len(envs), err)
+	}
+	env := envs[0]
+	if env.Kind != envelopeDelta || env.Resync || env.FromVersion != 1 || env.ToVersion != 4 {
+		t.Fatalf("Unexpected envelope %+v", env)
+	}
+	data, _ := json.Marshal(env)
+	if err := client.VerifyFilterPayload(agg.signer.Active().Public(), data); err != nil {
+		t.Errorf("Delta failed verification: %v", err)
+	}
+	var delta FilterDelta
+	json.Unmarshal(env.Payload, &delta)
+	if strings.Join(delta.Added, ",") != "0xB,0xC" || strings.Join(delta.Removed, ",") != "0xA" {
+		t.Errorf("Unexpected delta %+v", delta)
+	}
+
+	// Already current: an empty delta confirms the resume.
+	envs, _ = agg.Resume(4, DeltaExact)
+	if len(envs) != 1 || envs[0].Kind != envelopeDelta || envs[0].FromVersion != 4 || envs[0].ToVersion != 4 {
+		t.Errorf("Expected empty delta at v4, got %+v", envs)
+	}
+}
+
+func TestResumeSplitsLongGapsIntoDeltaChain(t *testing.T) {
+	agg := NewSwarmAggregator()
+	for i := 0; i < maxDeltaChanges+10; i++ {
+		blockAll(agg, fmt.Sprintf("0x%04d", i))
+	}
+
+	envs, err := agg.Resume(0, DeltaExact)
+	if err != nil || len(envs) != 2 {
+		t.Fatalf("Expected two deltas, got %d (%v)", len(envs), err)
+	}
+	if envs[0].FromVersion != 0 || envs[0].ToVersion != maxDeltaChanges ||
+		envs[1].FromVersion != maxDeltaChanges || envs[1].ToVersion != maxDeltaChanges+10 {
+		t.Errorf("Deltas do not chain: %d->%d, %d->%d",
+			envs[0].FromVersion, envs[0].ToVersion, envs[1].FromVersion, envs[1].ToVersion)
+	}
+}
+
+func TestResumeBeyondHistory(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Push.ResumeHistory = 2
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	blockAll(agg, "0xA", "0xB", "0xC", "0xD") // v4, history keeps v3 and v4
+
+	envs, err := agg.Resume(1, DeltaExact)
+	if err != nil || len(envs) != 1 {
+		t.Fatalf("Expected one snapshot, got %d (%v)", len(envs), err)
+	}
+	if env := envs[0]; env.Kind != envelopeSnapshot || !env.Resync || env.FromVersion != 0 || env.ToVersion != 4 {
+		t.Errorf("Expected resync snapshot at v4, got %+v", env)
+	}
+
+	if envs, _ := agg.Resume(2, DeltaExact); len(envs) != 1 || envs[0].Kind != envelopeDelta {
+		t.Errorf("Expected a delta from the oldest retained base, got %+v", envs)
+	}
+}
+
+func TestResumeFromFutureVersionOverWebSocket(t *testing.T) {
+	agg := NewSwarmAggregator()
+	blockAll(agg, "0xA")
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?last_version=1042", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var env FilterEnvelope
+	if err := conn.ReadJSON(&env); err != nil {
+		t.Fatalf("Expected resume reply, got %v", err)
+	}
+	if env.Kind != envelopeSnapshot || !env.Resync || env.ToVersion != 1 {
+		t.Errorf("Expected resync snapshot at v1, got %+v", env)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(wsURL+"?last_version=latest", header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed last_version, got %v", err)
+	}
+}
+
+func TestResumeSendsBloomSubscribersResyncForRemovals(t *testing.T) {
+	agg := NewSwarmAggregator()
+	blockAll(agg, "0xA", "0xB")              // v2
+	agg.Unblock(context.Background(), "0xA") // v3
+	blockAll(agg, "0xC")                     // v4
+
+	envs, _ := agg.Resume(1, DeltaExact)
+	var delta FilterDelta
+	if len(envs) != 1 || envs[0].Kind != envelopeDelta || json.Unmarshal(envs[0].Payload, &delta) != nil || !delta.RequiresResync {
+		t.Fatalf("Expected an exact subscriber sent the removal as a delta requiring resync, got %+v", envs)
+	}
+	if envs, _ := agg.Resume(1, DeltaBloom); len(envs) != 1 || envs[0].Kind != envelopeSnapshot || !envs[0].Resync || envs[0].ToVersion != 4 {
+		t.Errorf("Expected a bloom subscriber sent a resync snapshot instead, got %+v", envs)
+	}
+	envs, _ = agg.Resume(3, DeltaBloom)
+	delta = FilterDelta{}
+	if len(envs) != 1 || envs[0].Kind != envelopeDelta || json.Unmarshal(envs[0].Payload, &delta) != nil || delta.RequiresResync || len(delta.Added) != 1 {
+		t.Errorf("Expected a bloom subscriber sent an additions-only delta, got %+v %+v", envs, delta)
+	}
+
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?last_version=1&deltas=bloom", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var env FilterEnvelope
+	if err := conn.ReadJSON(&env); err != nil || env.Kind != envelopeSnapshot || !env.Resync {
+		t.Errorf("Expected ?deltas=bloom answered with a resync snapshot, got %+v (%v)", env, err)
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(wsURL+"?last_version=1&deltas=bytes", header); err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown deltas mode, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// Package swarm — Raw report retention.
+//
+// Raw reports are only kept for as long as they serve a purpose.  TWAB
+// retains each address's latest twab.retain_reports reports for the
+// detail view, and the state file persists them (see snapshot.go), so
+// without a bound an address reported once is remembered forever.  The
+// retention maintenance task prunes them by data class, by when the
+// server received each report (its claimed time for reports imported
+// without one):
+//
+//   - Addresses not promoted, neither confirmed nor staged: retained
+//     reports older than retention.unpromoted_reports are deleted.  The
+//     entry's aggregates stay until it is collected idle (see
+//     maintenance.go).
+//   - Promoted addresses: retained reports older than
+//     retention.promoted_reports are compacted, dropped from the entry
+//     with only the per-source aggregates every gate and summary is
+//     computed from left, and counted as the entry's compacted_reports.
+//
+// Zero keeps a class.  Reports persist nowhere but in TWAB and the state
+// file written from it; the aggregator keeps no database of them, so what
+// a run prunes is gone from the next state file too.  The confirmed set and the audit log are never
+// pruned.  Each shard is scanned under its read lock and pruned under
+// its write lock at most retention.batch_size addresses at a time, so
+// ingest waits for one batch and not the walk.  /health reports the last
+// run under "retention".
+package swarm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RetentionStats is one retention run, as /health reports it.
+type RetentionStats struct {
+	At               time.Time `json:"at"`
+	DurationMS       float64   `json:"duration_ms"`
+	Entries          int       `json:"entries"` // entries scanned
+	DeletedReports   int       `json:"deleted_reports"`
+	CompactedReports int       `json:"compacted_reports"`
+	CompactedEntries int       `json:"compacted_entries"`
+}
+
+// retentionState holds the stats of the last retention run.
+type retentionState struct {
+	mu   sync.Mutex
+	last *RetentionStats
+}
+
+func (r *retentionState) set(stats RetentionStats) {
+	r.mu.Lock()
+	r.last = &stats
+	r.mu.Unlock()
+}
+
+func (r *retentionState) get() *RetentionStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.last
+}
+
+// receivedAt is when a retained report was received, or its claimed
+// time if that was not kept.
+func receivedAt(report IOCReport) time.Time {
+	if !report.ReceivedAt.IsZero() {
+		return report.ReceivedAt
+	}
+	return report.Timestamp
+}
+
+// pruneBefore drops the retained reports received before cutoff and
+// returns how many it dropped.  The caller holds the shard lock.
+func (e *TWABEntry) pruneBefore(cutoff time.Time) int {
+	recent := e.Recent()
+	kept := recent[:0]
+	for _, report := range recent {
+		if !receivedAt(report).Before(cutoff) {
+			kept = append(kept, report)
+		}
+	}
+	dropped := len(recent) - len(kept)
+	if dropped > 0 {
+		// Below capacity the ring appends, so it starts over at slot 0.
+		e.recent, e.next = kept, 0
+	}
+	return dropped
+}
+
+// oldestReceived returns when the oldest retained report was received.
+// The caller holds the shard lock.
+func (e *TWABEntry) oldestReceived() (time.Time, bool) {
+	if len(e.recent) == 0 {
+		return time.Time{}, false
+	}
+	return receivedAt(e.recent[e.next%len(e.recent)]), true
+}
+
+// PruneReports drops the retained reports received before their class's
+// cutoff: unpromotedBefore, deleted, for addresses promoted is false for,
+// and promotedBefore, compacted, for the rest.  A zero cutoff keeps its
+// class.  promoted is called with no shard locked, and at most batch
+// entries are pruned per write lock.
+func (t *TWAB) PruneReports(ctx context.Context, unpromotedBefore, promotedBefore time.Time, batch int, promoted func(address string) bool) RetentionStats {
+	var stats RetentionStats
+	for i := range t.shards {
+		if ctx.Err() != nil {
+			break
+		}
+		shard := &t.shards[i]
+		var candidates []string
+		shard.mu.RLock()
+		for addr, entry := range shard.entries {
+			stats.Entries++
+			oldest, ok := entry.oldestReceived()
+			if ok && (oldest.Before(unpromotedBefore) || oldest.Before(promotedBefore)) {
+				candidates = append(candidates, addr)
+			}
+		}
+		shard.mu.RUnlock()
+
+		type prune struct {
+			address  string
+			cutoff   time.Time
+			promoted bool
+		}
+		var todo []prune
+		for _, addr := range candidates {
+			if promoted(addr) {
+				if !promotedBefore.IsZero() {
+					todo = append(todo, prune{addr, promotedBefore, true})
+				}
+			} else if !unpromotedBefore.IsZero() {
+				todo = append(todo, prune{addr, unpromotedBefore, false})
+			}
+		}
+		for start := 0; start < len(todo); start += batch {
+			shard.mu.Lock()
+			for _, p := range todo[start:min(start+batch, len(todo))] {
+				entry, ok := shard.entries[p.address]
+				if !ok {
+					continue
+				}
+				n := entry.pruneBefore(p.cutoff)
+				if !p.promoted {
+					stats.DeletedReports += n
+				} else if n > 0 {
+					entry.CompactedReports += n
+					stats.CompactedReports += n
+					stats.CompactedEntries++
+				}
+			}
+			shard.mu.Unlock()
+		}
+	}
+	return stats
+}
+
+// pruneReports is the retention maintenance task.
+func (s *SwarmAggregator) pruneReports(ctx context.Context) TaskStats {
+	cfg := s.current().Retention
+	now := s.maintenance.clock.Now()
+	var unpromotedBefore, promotedBefore time.Time
+	if cfg.UnpromotedReports > 0 {
+		unpromotedBefore = now.Add(-time.Duration(cfg.UnpromotedReports))
+	}
+	if cfg.PromotedReports > 0 {
+		promotedBefore = now.Add(-time.Duration(cfg.PromotedReports))
+	}
+	if unpromotedBefore.IsZero() && promotedBefore.IsZero() {
+		return TaskStats{} // disabled by a reload
+	}
+	stats := s.twab.PruneReports(ctx, unpromotedBefore, promotedBefore, cfg.BatchSize, func(address string) bool {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		_, confirmed := s.confirmed[address]
+		_, staged := s.staged[address]
+		return confirmed || staged
+	})
+	stats.At = now
+	stats.DurationMS = float64(s.maintenance.clock.Now().Sub(now).Microseconds()) / 1000
+	s.retention.set(stats)
+	return TaskStats{Items: stats.Entries}
+}
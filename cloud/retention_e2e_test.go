@@ -0,0 +1,137 @@
+package swarm_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+// newRetainingAggregator starts a test aggregator keeping unpromoted
+// reports a week and promoted ones a month.
+func newRetainingAggregator(t *testing.T) *aegistest.Aggregator {
+	return aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.TWAB = swarm.TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+		cfg.Retention = swarm.RetentionConfig{
+			UnpromotedReports: swarm.Duration(7 * 24 * time.Hour),
+			PromotedReports:   swarm.Duration(30 * 24 * time.Hour),
+			BatchSize:         1,
+		}
+	}})
+}
+
+// savedTWAB returns the TWAB records of a state file by address.
+func savedTWAB(t *testing.T, path string) map[string]*swarm.TWABState {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	out := make(map[string]*swarm.TWABState)
+	lines := bufio.NewScanner(f)
+	lines.Buffer(nil, 1<<20)
+	lines.Scan() // the header
+	for lines.Scan() {
+		var rec struct {
+			Kind string          `json:"kind"`
+			TWAB json.RawMessage `json:"twab"`
+		}
+		if err := json.Unmarshal(lines.Bytes(), &rec); err != nil {
+			t.Fatalf("Invalid state line %s: %v", lines.Bytes(), err)
+		}
+		if rec.Kind != "twab" {
+			continue
+		}
+		var st swarm.TWABState
+		if err := json.Unmarshal(rec.TWAB, &st); err != nil {
+			t.Fatalf("Invalid TWAB record %s: %v", rec.TWAB, err)
+		}
+		out[st.Address] = &st
+	}
+	if err := lines.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestRetentionPrunesReportsByClass(t *testing.T) {
+	agg := newRetainingAggregator(t)
+	ctx := context.Background()
+	report := func(addr, source string) {
+		agg.IngestReport(ctx, swarm.IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: agg.Clock.Now(), SourceID: source})
+	}
+	promoted := aegistest.Address("promoted")
+	report(promoted, "agent-A")
+	report(promoted, "agent-B")
+	pending := aegistest.Address("pending")
+	report(pending, "agent-A")
+	report(aegistest.Address("pending-too"), "agent-A")
+
+	// Past the unpromoted period only the pending reports go.
+	agg.Clock.Advance(8 * 24 * time.Hour)
+	fresh := aegistest.Address("fresh")
+	report(fresh, "agent-A")
+	agg.RunMaintenance(ctx)
+
+	if d, ok := twabDetail(t, agg, pending); !ok || len(d.RecentReports) != 0 || d.ReportCount != 1 || d.CompactedReports != 0 {
+		t.Errorf("Expected the pending report deleted and its aggregates kept, got %+v", d)
+	}
+	if d, _ := twabDetail(t, agg, fresh); len(d.RecentReports) != 1 {
+		t.Errorf("Expected a report within the period kept, got %+v", d)
+	}
+	if d, _ := twabDetail(t, agg, promoted); len(d.RecentReports) != 2 || d.CompactedReports != 0 {
+		t.Errorf("Expected the promoted reports kept until their period, got %+v", d)
+	}
+	var health struct{ Retention *swarm.RetentionStats }
+	getJSON(t, agg, "/health", &health)
+	if st := health.Retention; st == nil || st.DeletedReports != 2 || st.CompactedReports != 0 || st.Entries != 4 || !st.At.Equal(agg.Clock.Now()) {
+		t.Errorf("Unexpected retention stats %+v", health.Retention)
+	}
+
+	// Past the promoted period they are compacted into the aggregates.
+	agg.Clock.Advance(23 * 24 * time.Hour)
+	agg.RunMaintenance(ctx)
+	d, _ := twabDetail(t, agg, promoted)
+	if len(d.RecentReports) != 0 || d.CompactedReports != 2 || d.ReportCount != 2 || d.DistinctSources != 2 || d.MeanConfidence != 0.9 {
+		t.Errorf("Expected the promoted reports compacted into queryable aggregates, got %+v", d)
+	}
+	if _, ok := agg.Confirmed(promoted); !ok {
+		t.Error("Expected the confirmed set untouched")
+	}
+	getJSON(t, agg, "/health", &health)
+	if st := health.Retention; st == nil || st.CompactedReports != 2 || st.CompactedEntries != 1 || st.DeletedReports != 1 {
+		t.Errorf("Unexpected retention stats %+v", health.Retention)
+	}
+
+	// The state file, the only store reports persist to, holds what is
+	// left: no pruned report, and every aggregate.
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	if err := agg.SaveStateFile(path); err != nil {
+		t.Fatal(err)
+	}
+	saved := savedTWAB(t, path)
+	if st := saved[pending]; st == nil || len(st.Recent) != 0 || st.ReportCount != 1 {
+		t.Errorf("Expected the pending entry saved without its report, got %+v", st)
+	}
+	if st := saved[promoted]; st == nil || len(st.Recent) != 0 || st.CompactedReports != 2 || st.ReportCount != 2 || len(st.Sources) != 2 {
+		t.Errorf("Expected the promoted entry saved as aggregates only, got %+v", st)
+	}
+
+	// Compaction survives a restart through the state file.
+	dst := newRetainingAggregator(t)
+	_, state := agg.Do(http.MethodGet, "/admin/snapshot/export", "")
+	if status, data := dst.Do(http.MethodPost, "/admin/snapshot/import", string(state)); status != http.StatusOK {
+		t.Fatalf("Import failed: %d %s", status, data)
+	}
+	if d, _ := twabDetail(t, dst, promoted); d.CompactedReports != 2 || len(d.RecentReports) != 0 {
+		t.Errorf("Expected the compacted count imported, got %+v", d)
+	}
+}
@@ -0,0 +1,16 @@
+package swarm
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRetentionDisabledByDefault(t *testing.T) {
+	agg := NewSwarmAggregator()
+	var health map[string]interface{}
+	agg.maintenance.RunOnce(context.Background())
+	getJSON(t, agg, "/health", &health)
+	if _, ok := health["retention"]; ok {
+		t.Errorf("Expected no retention stats without a period, got %v", health["retention"])
+	}
+}
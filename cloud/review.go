@@ -0,0 +1,384 @@
+// Package swarm — Analyst review of promotions.
+//
+// review.policy chooses how an address that meets the TWAB threshold is
+// promoted: "auto" (the default) adds it to the filter at once, "review"
+// puts it in a queue for an analyst instead.  review.category_policy
+// overrides the policy for reports of a category, so a deployment can
+// auto-promote drainers but review sanctions, or the reverse.  The
+// category is that of the report that met the threshold.  A chain that
+// tripped the burn-rate detector with alerts.burn_rate.auto_review is
+// reviewed whatever its policy until the alert is acknowledged (see
+// burnrate.go), and an address resembling a protected one is reviewed
+// whatever the policy, with reason near_protected (see tripwire.go).
+//
+// GET /admin/review lists the queue, oldest first, each item with the
+// Explain breakdown of its latest qualifying report.  POST
+// /admin/review/{address}/approve promotes the address and pushes the
+// filter; POST /admin/review/{address}/reject drops it and suppresses it
+// for review.reject_cooldown, during which further reports do not queue it
+// again.  Both take an optional reason query parameter and are audited.
+// Allowlisting or blocking an address takes it off the queue, and a
+// decision closes the disputes of a disputed one (see feedback.go).
+//
+// An address promoted by consensus that a later report leaves short of
+// the thresholds, e.g. because its majority category now selects stricter
+// twab.category_overrides, stays in the filter but is queued flagged
+// promoted: approving keeps it and rejecting removes it, and either
+// keeps it off the queue for review.reject_cooldown.
+//
+// Only global consensus is reviewed: tenant namespaces, feed imports,
+// merges, and promotions replicated from peers are applied as before.
+// With persistence.review_queue_file set, the queue and the cooldowns are
+// saved whenever an address joins or leaves the queue, so they survive a
+// restart; an explanation refreshed by later reports is saved with the
+// next such change.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PromotionPolicy says what happens to an address that meets consensus.
+type PromotionPolicy string
+
+const (
+	PolicyAuto   PromotionPolicy = "auto"
+	PolicyReview PromotionPolicy = "review"
+)
+
+// valid reports whether p is a known policy; empty means auto.
+func (p PromotionPolicy) valid() bool {
+	return p == "" || p == PolicyAuto || p == PolicyReview
+}
+
+// policyFor returns the policy for reports of a category.
+func (c ReviewConfig) policyFor(category string) PromotionPolicy {
+	if p, ok := c.CategoryPolicy[category]; ok && p != "" {
+		return p
+	}
+	return c.Policy
+}
+
+// ReviewItem is an address awaiting an analyst's decision.
+type ReviewItem struct {
+	Address     string               `json:"address"`
+	ChainID     int                  `json:"chain_id"`
+	Category    string               `json:"category,omitempty"`
+	Confidence  float64              `json:"confidence"`
+	QueuedAt    time.Time            `json:"queued_at"`
+	UpdatedAt   time.Time            `json:"updated_at"`
+	Explanation ThresholdExplanation `json:"explanation"`
+
+	// Promoted flags an address already in the filter that no longer
+	// meets the thresholds.
+	Promoted bool `json:"promoted,omitempty"`
+
+	// Reason is near_protected for an address the tripwire held back,
+	// with the protected address it resembles (see tripwire.go).
+	Reason    string     `json:"reason,omitempty"`
+	NearMatch *NearMatch `json:"near_match,omitempty"`
+}
+
+// reviewState is the persisted form of the queue.
+type reviewState struct {
+	Items     []ReviewItem         `json:"items"`
+	Cooldowns map[string]time.Time `json:"cooldowns"`
+}
+
+// reviewQueue holds the addresses awaiting review and the rejected ones
+// still cooling down.
+type reviewQueue struct {
+	mu        sync.Mutex
+	path      string // persistence file; empty keeps the queue in memory
+	items     map[string]*ReviewItem
+	cooldowns map[string]time.Time // rejected address -> queueable again
+}
+
+func newReviewQueue() *reviewQueue {
+	return &reviewQueue{items: make(map[string]*ReviewItem), cooldowns: make(map[string]time.Time)}
+}
+
+// offer queues an item, or refreshes the one queued for its address.  It
+// reports whether the address joined the queue; a rejected address still
+// cooling down is not queued.
+func (q *reviewQueue) offer(item ReviewItem) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if queued, ok := q.items[item.Address]; ok {
+		queued.Category, queued.Confidence = item.Category, item.Confidence
+		queued.UpdatedAt, queued.Explanation = item.UpdatedAt, item.Explanation
+		queued.Promoted = item.Promoted
+		queued.Reason, queued.NearMatch = item.Reason, item.NearMatch
+		return false
+	}
+	if until, ok := q.cooldowns[item.Address]; ok {
+		if item.UpdatedAt.Before(until) {
+			return false
+		}
+		delete(q.cooldowns, item.Address)
+	}
+	item.QueuedAt = item.UpdatedAt
+	q.items[item.Address] = &item
+	q.saveLocked()
+	return true
+}
+
+// take removes an address from the queue, starting a cooldown until
+// cooldownUntil if it is not zero.
+func (q *reviewQueue) take(address string, cooldownUntil time.Time) (ReviewItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.items[address]
+	if !ok {
+		return ReviewItem{}, false
+	}
+	delete(q.items, address)
+	if !cooldownUntil.IsZero() {
+		q.cooldowns[address] = cooldownUntil
+	}
+	q.saveLocked()
+	return *item, true
+}
+
+// queued reports whether an address awaits review.
+func (q *reviewQueue) queued(address string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.items[address]
+	return ok
+}
+
+// flagged reports whether an address awaits review flagged promoted.
+func (q *reviewQueue) flagged(address string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.items[address]
+	return ok && item.Promoted
+}
+
+// list returns the queue, oldest first.
+func (q *reviewQueue) list() []ReviewItem {
+	q.mu.Lock()
+	out := make([]ReviewItem, 0, len(q.items))
+	for _, item := range q.items {
+		out = append(out, *item)
+	}
+	q.mu.Unlock()
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].QueuedAt.Equal(out[j].QueuedAt) {
+			return out[i].QueuedAt.Before(out[j].QueuedAt)
+		}
+		return out[i].Address < out[j].Address
+	})
+	return out
+}
+
+func (q *reviewQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// open loads the queue saved at path, if any, and saves every later
+// change there.
+func (q *reviewQueue) open(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	var state reviewState
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("review queue %s: %w", path, err)
+		}
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.path = path
+	for i := range state.Items {
+		q.items[state.Items[i].Address] = &state.Items[i]
+	}
+	for address, until := range state.Cooldowns {
+		q.cooldowns[address] = until
+	}
+	return nil
+}
+
+// saveLocked writes the queue to its file, dropping lapsed cooldowns.
+// Failures are logged: the queue stays authoritative in memory.
+func (q *reviewQueue) saveLocked() {
+	if q.path == "" {
+		return
+	}
+	now := time.Now()
+	state := reviewState{Items: make([]ReviewItem, 0, len(q.items)), Cooldowns: make(map[string]time.Time)}
+	for _, item := range q.items {
+		state.Items = append(state.Items, *item)
+	}
+	for address, until := range q.cooldowns {
+		if now.Before(until) {
+			state.Cooldowns[address] = until
+		} else {
+			delete(q.cooldowns, address)
+		}
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = writeFileAtomic(q.path, data)
+	}
+	if err != nil {
+		log.Printf("Failed to save review queue: %v", err)
+	}
+}
+
+// queueForReview offers an address that met consensus to the review
+// queue, with the breakdown of its current TWAB entry.
+func (s *SwarmAggregator) queueForReview(report IOCReport, now time.Time) {
+	s.review.offer(ReviewItem{
+		Address:     report.Address,
+		ChainID:     report.ChainID,
+		Category:    report.Category,
+		Confidence:  report.Confidence,
+		UpdatedAt:   now,
+		Explanation: s.twab.Explain(report.Address, s.current().TWAB),
+	})
+}
+
+// flagDisqualified queues an address promoted by consensus whose report
+// did not meet the thresholds, flagged promoted, leaving it in the
+// filter.
+func (s *SwarmAggregator) flagDisqualified(report IOCReport, now time.Time) {
+	entry, ok := s.Confirmed(report.Address)
+	if !ok || entry.Provenance.Source != provenanceConsensus {
+		return
+	}
+	explanation := s.twab.Explain(report.Address, s.current().TWAB)
+	if s.review.offer(ReviewItem{
+		Address:     report.Address,
+		ChainID:     report.ChainID,
+		Category:    report.Category,
+		Confidence:  report.Confidence,
+		UpdatedAt:   now,
+		Explanation: explanation,
+		Promoted:    true,
+	}) {
+		log.Printf("Flagged %s for review: promoted, but no longer meets the %s thresholds", report.Address, thresholdsName(explanation.Override))
+	}
+}
+
+// thresholdsName names the TWAB thresholds of an Explain override.
+func thresholdsName(override string) string {
+	if override == "" {
+		return "twab"
+	}
+	return "twab." + override
+}
+
+// reviewCooldownUntil is when an address decided on now may be queued
+// again, zero for no cooldown.
+func (s *SwarmAggregator) reviewCooldownUntil(now time.Time) time.Time {
+	if cooldown := time.Duration(s.current().Review.RejectCooldown); cooldown > 0 {
+		return now.Add(cooldown)
+	}
+	return time.Time{}
+}
+
+// ApproveReview promotes a queued address into the filter, or keeps a
+// flagged one there.  ok is false if it was not queued; promoted is false
+// if it has since been allowlisted or removed.
+func (s *SwarmAggregator) ApproveReview(ctx context.Context, address string) (promoted, ok bool) {
+	now := s.clock.Now()
+	if s.review.flagged(address) {
+		if _, ok := s.review.take(address, s.reviewCooldownUntil(now)); !ok {
+			return false, false
+		}
+		s.disputes.close(address)
+		_, promoted = s.Confirmed(address)
+		return promoted, true
+	}
+	item, ok := s.review.take(address, time.Time{})
+	if !ok {
+		return false, false
+	}
+	s.disputes.close(address)
+	report := IOCReport{Address: item.Address, ChainID: item.ChainID, Category: item.Category, Confidence: item.Confidence}
+	return s.promoteConsensus(ctx, report, now, false), true
+}
+
+// RejectReview drops a queued address, removing a flagged one from the
+// filter, and keeps it from being queued again for
+// review.reject_cooldown.  It returns false if it was not queued.
+func (s *SwarmAggregator) RejectReview(ctx context.Context, address string) bool {
+	item, ok := s.review.take(address, s.reviewCooldownUntil(s.clock.Now()))
+	if ok {
+		s.disputes.close(address)
+	}
+	if ok && item.Promoted {
+		s.Unblock(ctx, address)
+	}
+	return ok
+}
+
+// handleAdminReview is the HTTP handler for GET /admin/review.
+func (s *SwarmAggregator) handleAdminReview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"items": s.review.list()})
+}
+
+// handleAdminReviewDecision is the HTTP handler for POST
+// /admin/review/{address}/approve and /reject.  A chain_id query
+// parameter normalizes the address as on GET /check.
+func (s *SwarmAggregator) handleAdminReviewDecision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	address, decision, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/admin/review/"), "/")
+	if !ok || address == "" || (decision != "approve" && decision != "reject") {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Not found")
+		return
+	}
+	address, ok = normalizeQueryAddress(w, r, address)
+	if !ok {
+		return
+	}
+	if !s.review.queued(address) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Address is not awaiting review")
+		return
+	}
+
+	action := AuditReviewApprove
+	if decision == "reject" {
+		action = AuditReviewReject
+	}
+	if !s.auditAdmin(w, r, AuditEvent{Action: action, Address: address}) {
+		return
+	}
+	var changed bool
+	if decision == "approve" {
+		changed, ok = s.ApproveReview(r.Context(), address)
+	} else {
+		changed = s.RejectReview(r.Context(), address)
+		ok = changed
+	}
+	if !ok {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "Address is not awaiting review")
+		return
+	}
+	writeAdminResult(w, address, changed)
+}
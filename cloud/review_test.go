@@ -0,0 +1,180 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newReviewAggregator(t *testing.T) *SwarmAggregator {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Review = ReviewConfig{
+		Policy:         PolicyReview,
+		CategoryPolicy: map[string]PromotionPolicy{"drainer": PolicyAuto},
+		RejectCooldown: Duration(time.Hour),
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	agg, _ := newAuditedAggregator(t, cfg)
+	return agg
+}
+
+func reviewQueueOf(t *testing.T, agg *SwarmAggregator) []ReviewItem {
+	t.Helper()
+	rec := adminRequest(t, agg, "ops-secret", http.MethodGet, "/admin/review", "")
+	var body struct {
+		Items []ReviewItem `json:"items"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /admin/review: %d %v", rec.Code, err)
+	}
+	return body.Items
+}
+
+func TestReviewQueueApproveAndReject(t *testing.T) {
+	agg := newReviewAggregator(t)
+	phish, drainer, benign := evmAddress("phish"), evmAddress("drainer"), evmAddress("benign")
+
+	promote(agg, phish, "phishing")
+	promote(agg, drainer, "drainer")
+	if agg.bloomFilter.Contains(phish) || !agg.bloomFilter.Contains(drainer) {
+		t.Fatal("Expected the phishing address held for review and the drainer promoted")
+	}
+	items := reviewQueueOf(t, agg)
+	if len(items) != 1 || items[0].Address != phish || items[0].Category != "phishing" || !items[0].Explanation.MeetsThreshold {
+		t.Fatalf("Expected the phishing address queued with its explanation, got %+v", items)
+	}
+
+	version := agg.bloomFilter.Version()
+	if rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/review/"+phish+"/approve?reason=confirmed+drain", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Approve: expected 200, got %d %s", rec.Code, rec.Body)
+	}
+	if !agg.bloomFilter.Contains(phish) || agg.bloomFilter.Version() != version+1 || len(reviewQueueOf(t, agg)) != 0 {
+		t.Error("Expected the approved address promoted and dequeued")
+	}
+	if entry, _ := agg.Confirmed(phish); entry.Category != "phishing" || entry.Provenance.Source != provenanceConsensus {
+		t.Errorf("Expected a consensus entry for the approved address, got %+v", entry)
+	}
+
+	promote(agg, benign, "phishing")
+	if rec := adminRequest(t, agg, "oncall-secret", http.MethodPost, "/admin/review/"+benign+"/reject", ""); rec.Code != http.StatusOK {
+		t.Fatalf("Reject: expected 200, got %d %s", rec.Code, rec.Body)
+	}
+	promote(agg, benign, "phishing")
+	if agg.bloomFilter.Contains(benign) || len(reviewQueueOf(t, agg)) != 0 {
+		t.Error("Expected the rejected address neither promoted nor queued again during its cooldown")
+	}
+	if rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/review/"+benign+"/approve", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 approving an address not in the queue, got %d", rec.Code)
+	}
+
+	approvals := queryAudit(t, agg, "?action=review_approve").Events
+	if len(approvals) != 1 || approvals[0].Actor != "ops" || approvals[0].Address != phish || approvals[0].Reason != "confirmed drain" {
+		t.Errorf("Expected the approval audited, got %+v", approvals)
+	}
+	if rejections := queryAudit(t, agg, "?action=review_reject").Events; len(rejections) != 1 || rejections[0].Actor != "oncall" {
+		t.Errorf("Expected the rejection audited, got %+v", rejections)
+	}
+}
+
+func TestAllowlistingDropsQueuedAddress(t *testing.T) {
+	agg := newReviewAggregator(t)
+	router := evmAddress("router")
+	promote(agg, router, "")
+	adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/allowlist", `{"address":"`+router+`"}`)
+	if items := reviewQueueOf(t, agg); len(items) != 0 {
+		t.Errorf("Expected the allowlisted address off the queue, got %+v", items)
+	}
+}
+
+func TestReviewQueueSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "review.json")
+	queued, rejected := evmAddress("queued"), evmAddress("rejected")
+
+	first := newReviewAggregator(t)
+	if err := first.review.open(path); err != nil {
+		t.Fatal(err)
+	}
+	promote(first, queued, "")
+	promote(first, rejected, "")
+	first.RejectReview(context.Background(), rejected)
+
+	second := newReviewAggregator(t)
+	if err := second.review.open(path); err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	items := reviewQueueOf(t, second)
+	if len(items) != 1 || items[0].Address != queued || items[0].Explanation.Gates == nil {
+		t.Fatalf("Expected the queued address restored with its explanation, got %+v", items)
+	}
+	promote(second, rejected, "")
+	if len(reviewQueueOf(t, second)) != 1 {
+		t.Error("Expected the rejection cooldown restored")
+	}
+}
+
+func TestReviewConfigValidation(t *testing.T) {
+	for name, review := range map[string]ReviewConfig{
+		"unknown policy":    {Policy: "manual"},
+		"unknown category":  {CategoryPolicy: map[string]PromotionPolicy{"phishing": "later"}},
+		"negative cooldown": {RejectCooldown: Duration(-time.Second)},
+	} {
+		cfg := DefaultConfig()
+		cfg.Review = review
+		if cfg.Validate() == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestPromotedAddressFlaggedWhenCategoryFlips(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1, CategoryOverrides: map[string]TWABConfig{
+		"suspicious-approval": {MinReportCount: 5, MinDistinctSources: 5, MinTimeSpanSeconds: 6 * 3600},
+	}}
+	cfg.Review.RejectCooldown = Duration(time.Hour)
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate failed: %v", err)
+	}
+	agg, _ := newAuditedAggregator(t, cfg)
+	ctx := context.Background()
+	report := func(address, category, source string) {
+		agg.IngestReport(ctx, IOCReport{Address: address, ChainID: 1, Category: category, Confidence: 0.9, Timestamp: time.Now(), SourceID: source})
+	}
+	kept, dropped := evmAddress("kept"), evmAddress("dropped")
+	for _, address := range []string{kept, dropped} {
+		report(address, "drainer", "agent-A")
+		report(address, "drainer", "agent-B")
+		report(address, "suspicious-approval", "agent-C")
+		if !agg.bloomFilter.Contains(address) || len(reviewQueueOf(t, agg)) != 0 {
+			t.Fatal("Expected the address promoted under the base thresholds")
+		}
+	}
+	for _, address := range []string{kept, dropped} {
+		report(address, "suspicious-approval", "agent-D") // a tie goes to the latest
+	}
+
+	items := reviewQueueOf(t, agg)
+	if len(items) != 2 || !items[0].Promoted || items[0].Explanation.Override != "category_overrides.suspicious-approval" {
+		t.Fatalf("Expected both addresses flagged under the stricter override, got %+v", items)
+	}
+	if !agg.bloomFilter.Contains(kept) || !agg.bloomFilter.Contains(dropped) {
+		t.Fatal("Expected flagged addresses left in the filter")
+	}
+
+	adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/review/"+kept+"/approve", "")
+	adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/review/"+dropped+"/reject", "")
+	if !agg.bloomFilter.Contains(kept) || agg.bloomFilter.Contains(dropped) || len(reviewQueueOf(t, agg)) != 0 {
+		t.Error("Expected approval to keep the address and rejection to remove it")
+	}
+	report(kept, "suspicious-approval", "agent-E")
+	if len(reviewQueueOf(t, agg)) != 0 {
+		t.Error("Expected an approved address not flagged again during the cooldown")
+	}
+}
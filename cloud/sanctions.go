@@ -0,0 +1,384 @@
+// Package swarm — Sanctions list sync.
+//
+// Compliance deployments want the filter to carry the current OFAC SDN
+// digital currency addresses without anyone importing them.  With
+// sanctions.feeds set, each feed is fetched every sanctions.interval and
+// the addresses it lists are kept in the confirmed set as a trusted feed
+// (see feed_import.go) named "feed:<name>", in the "sanctions" category.
+// A sync is diffed against what the feed listed the time before: an
+// address it adds is promoted at once and one it drops is demoted, unless
+// consensus, an admin, or another feed put it in the filter.
+//
+// A feed is read in one of two formats.  "sdn" is the Treasury SDN list
+// (sdn.csv or sdn.xml), where each address appears as "Digital Currency
+// Address - <ticker> <address>"; the ticker names the chain (sdnChains),
+// and addresses on chains the registry cannot validate, such as XMR, are
+// skipped.  "list" is one address per line, with # comments, all on the
+// feed's chain_id.
+//
+// A fetch that fails, answers other than 2xx, or lists no address at all
+// changes nothing, so a network failure never clears what the last
+// successful sync put in the filter.  aegis_sanctions_syncs_total counts
+// syncs by feed and outcome, and GET /health shows each feed's last
+// successful sync and, if the latest failed, why.
+package swarm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Sanctions feed formats.
+const (
+	sanctionsFormatSDN  = "sdn"
+	sanctionsFormatList = "list"
+)
+
+// sanctionsCategory is the category synced addresses are confirmed with.
+const sanctionsCategory = "sanctions"
+
+// Sync outcomes recorded in aegis_sanctions_syncs_total.
+const (
+	sanctionsOutcomeSynced = "synced"
+	sanctionsOutcomeFailed = "failed"
+)
+
+// SanctionsConfig lists the sanctions feeds to keep in the filter.
+type SanctionsConfig struct {
+	Feeds    []SanctionsFeed `json:"feeds" yaml:"feeds"` // config file only
+	Interval Duration        `json:"interval" yaml:"interval"`
+	Timeout  Duration        `json:"timeout" yaml:"timeout"` // of one fetch
+}
+
+// Enabled reports whether any feed is configured.
+func (c SanctionsConfig) Enabled() bool { return len(c.Feeds) > 0 }
+
+// SanctionsFeed is one list to sync.  ChainID applies to the list format
+// only; zero accepts EVM addresses on no chain in particular.
+type SanctionsFeed struct {
+	Name    string `json:"name" yaml:"name"`
+	URL     string `json:"url" yaml:"url"`
+	Format  string `json:"format" yaml:"format"`
+	ChainID int    `json:"chain_id" yaml:"chain_id"`
+}
+
+// sdnChains maps SDN digital currency tickers to chain IDs.  Stablecoins
+// are listed under their token's ticker, so their chain is told from the
+// address instead (see sdnChainOf).
+var sdnChains = map[string]int{
+	"ETH": 1,
+	"XBT": ChainBitcoin,
+	"TRX": ChainTron,
+	"SOL": ChainSolana,
+	"BSC": 56,
+	"ARB": 42161,
+}
+
+// sdnAddressPattern finds the addresses of an SDN list, in either the CSV
+// remarks or the XML id elements.
+var sdnAddressPattern = regexp.MustCompile(`Digital Currency Address - ([A-Z0-9]+)(?:</idType>\s*<idNumber>|\s+)([A-Za-z0-9]+)`)
+
+// sdnChainOf returns the chain of an SDN address, false if it is on none
+// the registry validates.
+func sdnChainOf(ticker, address string) (int, bool) {
+	if chain, ok := sdnChains[ticker]; ok {
+		return chain, true
+	}
+	if ticker == "USDT" || ticker == "USDC" {
+		if strings.HasPrefix(address, "0x") {
+			return 1, true
+		}
+		if strings.HasPrefix(address, "T") {
+			return ChainTron, true
+		}
+	}
+	return 0, false
+}
+
+// SanctionsSyncResult is what one sync of a feed changed.
+type SanctionsSyncResult struct {
+	Feed    string `json:"feed"`
+	Entries int    `json:"entries"` // addresses the feed lists
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Skipped int    `json:"skipped"` // on chains not synced
+	Invalid int    `json:"invalid"`
+}
+
+// SanctionsFeedStatus is a feed's sync state as shown by GET /health.
+type SanctionsFeedStatus struct {
+	Feed          string     `json:"feed"`
+	Entries       int        `json:"entries"`
+	LastSyncedAt  *time.Time `json:"last_synced_at,omitempty"` // nil until a sync succeeds
+	LastAttemptAt *time.Time `json:"last_attempt_at,omitempty"`
+	LastError     string     `json:"last_error,omitempty"` // of the latest attempt, if it failed
+}
+
+// sanctionsSync fetches the configured feeds and remembers how each went.
+type sanctionsSync struct {
+	config  SanctionsConfig
+	client  *http.Client
+	metrics *prometheus.CounterVec // feed, outcome
+
+	mu     sync.Mutex
+	status map[string]*SanctionsFeedStatus // feed name -> status
+}
+
+// newSanctionsSync returns the sync for cfg, or nil if no feed is set.
+func newSanctionsSync(cfg SanctionsConfig, metrics *prometheus.CounterVec) *sanctionsSync {
+	if !cfg.Enabled() {
+		return nil
+	}
+	s := &sanctionsSync{
+		config:  cfg,
+		client:  &http.Client{Timeout: time.Duration(cfg.Timeout)},
+		metrics: metrics,
+		status:  make(map[string]*SanctionsFeedStatus, len(cfg.Feeds)),
+	}
+	for _, feed := range cfg.Feeds {
+		s.status[feed.Name] = &SanctionsFeedStatus{Feed: feed.Name}
+	}
+	return s
+}
+
+// fetch downloads and parses a feed, reading at most limit bytes.
+func (s *sanctionsSync) fetch(ctx context.Context, feed SanctionsFeed, limit int64) ([]FeedEntry, SanctionsSyncResult, error) {
+	res := SanctionsSyncResult{Feed: feed.Name}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feed.URL, nil)
+	if err != nil {
+		return nil, res, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, res, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, res, fmt.Errorf("feed returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, res, err
+	}
+	if int64(len(body)) > limit {
+		return nil, res, fmt.Errorf("feed is over %d bytes", limit)
+	}
+
+	entries := parseSanctions(body, feed, &res)
+	if len(entries) == 0 {
+		return nil, res, errors.New("feed lists no addresses")
+	}
+	return entries, res, nil
+}
+
+// parseSanctions reads the addresses of a feed body, once each, counting
+// those skipped or invalid in res.
+func parseSanctions(body []byte, feed SanctionsFeed, res *SanctionsSyncResult) []FeedEntry {
+	var entries []FeedEntry
+	seen := make(map[string]bool)
+	add := func(address string, chainID int) {
+		entry := FeedEntry{Address: address, ChainID: chainID, Category: sanctionsCategory, Confidence: 1}
+		if entry.validate() != "" {
+			res.Invalid++
+			return
+		}
+		if !seen[entry.Address] {
+			seen[entry.Address] = true
+			entries = append(entries, entry)
+		}
+	}
+
+	if feed.Format == sanctionsFormatSDN {
+		for _, m := range sdnAddressPattern.FindAllSubmatch(body, -1) {
+			chain, ok := sdnChainOf(string(m[1]), string(m[2]))
+			if !ok {
+				res.Skipped++
+				continue
+			}
+			add(string(m[2]), chain)
+		}
+		return entries
+	}
+	sc := bufio.NewScanner(bytes.NewReader(body))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			add(line, feed.ChainID)
+		}
+	}
+	return entries
+}
+
+// record notes the outcome of a sync of feed.
+func (s *sanctionsSync) record(feed string, now time.Time, entries int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.status[feed]
+	st.LastAttemptAt = &now
+	if err != nil {
+		st.LastError = err.Error()
+		s.metrics.WithLabelValues(feed, sanctionsOutcomeFailed).Inc()
+		return
+	}
+	st.Entries, st.LastSyncedAt, st.LastError = entries, &now, ""
+	s.metrics.WithLabelValues(feed, sanctionsOutcomeSynced).Inc()
+}
+
+// health returns the status of every feed, in configuration order.
+func (s *sanctionsSync) health() []SanctionsFeedStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]SanctionsFeedStatus, 0, len(s.config.Feeds))
+	for _, feed := range s.config.Feeds {
+		out = append(out, *s.status[feed.Name])
+	}
+	return out
+}
+
+// SyncSanctions syncs every sanctions feed once.  A feed that fails is
+// logged and left as it was.
+func (s *SwarmAggregator) SyncSanctions(ctx context.Context) []SanctionsSyncResult {
+	if s.sanctions == nil {
+		return nil
+	}
+	var out []SanctionsSyncResult
+	for _, feed := range s.sanctions.config.Feeds {
+		res, err := s.syncSanctionsFeed(ctx, feed)
+		if err != nil {
+			log.Printf("Sanctions feed %s not synced, keeping its %d addresses: %v", feed.Name, s.sanctionsEntries(feed.Name), err)
+			continue
+		}
+		if res.Added > 0 || res.Removed > 0 {
+			log.Printf("Synced sanctions feed %s: %d addresses, %d added, %d removed", feed.Name, res.Entries, res.Added, res.Removed)
+		}
+		out = append(out, res)
+	}
+	return out
+}
+
+// sanctionsEntries is the address count of a feed's last successful sync.
+func (s *SwarmAggregator) sanctionsEntries(feed string) int {
+	s.sanctions.mu.Lock()
+	defer s.sanctions.mu.Unlock()
+	return s.sanctions.status[feed].Entries
+}
+
+// syncSanctionsFeed fetches a feed and applies the difference.
+func (s *SwarmAggregator) syncSanctionsFeed(ctx context.Context, feed SanctionsFeed) (SanctionsSyncResult, error) {
+	entries, res, err := s.sanctions.fetch(ctx, feed, s.current().Limits.MaxImportBodyBytes)
+	if err != nil {
+		s.sanctions.record(feed.Name, s.clock.Now(), 0, err)
+		return res, err
+	}
+	res.Entries = len(entries)
+	res.Removed = s.delistFeed(ctx, feed.Name, entries)
+	sum, err := s.importFeed(ctx, feed.Name, FeedTrusted, entries, eventReasonSanctioned) // skips those already listed
+	if err != nil {
+		s.sanctions.record(feed.Name, s.clock.Now(), 0, err)
+		return res, err
+	}
+	res.Added = sum.Added
+	s.sanctions.record(feed.Name, s.clock.Now(), res.Entries, nil)
+	if res.Added > 0 || res.Removed > 0 {
+		s.auditSystem(AuditEvent{Action: AuditSanctionsSync, Subject: feed.Name, Reason: fmt.Sprintf("%d added, %d removed", res.Added, res.Removed)})
+	}
+	return res, nil
+}
+
+// delistFeed forgets the addresses a feed listed before but no longer
+// does, demoting those the feed put in the filter, and returns how many
+// it demoted.  One another trusted feed still lists stays, attributed to
+// that feed.
+func (s *SwarmAggregator) delistFeed(ctx context.Context, name string, entries []FeedEntry) int {
+	source := feedSourceID(name)
+	listed := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		listed[entry.Address] = true
+	}
+
+	var removed []Event
+	now := s.clock.Now()
+	s.mu.Lock()
+	delisted := make(map[string]bool)
+	for address := range s.feedTags {
+		if !listed[address] && s.hasFeedTagLocked(address, source) {
+			delisted[address] = true
+		}
+	}
+	for address, entry := range s.confirmed {
+		if !listed[address] && entry.Provenance.Source == source {
+			delisted[address] = true
+		}
+	}
+	for address := range delisted {
+		var kept []Provenance
+		for _, tag := range s.feedTags[address] {
+			if tag.Source != source {
+				kept = append(kept, tag)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.feedTags, address)
+		} else {
+			s.feedTags[address] = kept
+		}
+
+		entry, ok := s.confirmed[address]
+		if !ok || entry.Provenance.Source != source {
+			continue
+		}
+		if tag, ok := trustedTag(kept); ok {
+			moved := *entry
+			moved.Provenance = tag
+			s.confirmed[address] = &moved
+			continue
+		}
+		delete(s.confirmed, address)
+		s.filterRemoveLocked(entry)
+		ev := entryEvent(EventRemoved, entry)
+		ev.FromTier, ev.Reason, ev.Time = TierMain, eventReasonDelisted, now
+		removed = append(removed, ev)
+	}
+	s.mu.Unlock()
+
+	if len(removed) > 0 {
+		s.events.publish(ctx, removed...)
+	}
+	return len(removed)
+}
+
+// trustedTag returns the first trusted feed among tags.
+func trustedTag(tags []Provenance) (Provenance, bool) {
+	for _, tag := range tags {
+		if tag.Mode == FeedTrusted {
+			return tag, true
+		}
+	}
+	return Provenance{}, false
+}
+
+// runSanctionsSync syncs the sanctions feeds at once and then every
+// sanctions.interval until ctx is done.
+func (s *SwarmAggregator) runSanctionsSync(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(s.config.Sanctions.Interval))
+	defer ticker.Stop()
+	for {
+		s.SyncSanctions(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sanctionsFixture serves a feed body that tests swap out, or a 503.
+type sanctionsFixture struct {
+	mu   sync.Mutex
+	body string
+	down bool
+}
+
+func (f *sanctionsFixture) set(body string, down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.body, f.down = body, down
+}
+
+func (f *sanctionsFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		http.Error(w, "maintenance", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprint(w, f.body)
+}
+
+// sdnRow is an sdn.csv row whose remarks list digital currency addresses.
+func sdnRow(name string, addresses ...string) string {
+	var remarks []string
+	for _, a := range addresses {
+		remarks = append(remarks, "Digital Currency Address - "+a+";")
+	}
+	return fmt.Sprintf(`36216,"%s","individual","CYBER2","-0-","-0-","-0-","-0-","-0-","-0-","-0-","%s"`+"\n", name, strings.Join(remarks, " "))
+}
+
+// newSanctionsAggregator returns an aggregator syncing feeds.
+func newSanctionsAggregator(t *testing.T, feeds ...SanctionsFeed) *SwarmAggregator {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Sanctions.Feeds = feeds
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	return NewSwarmAggregatorWithConfig(cfg)
+}
+
+func sanctionsHealth(t *testing.T, agg *SwarmAggregator) []SanctionsFeedStatus {
+	t.Helper()
+	var resp struct {
+		Sanctions []SanctionsFeedStatus `json:"sanctions"`
+	}
+	getJSON(t, agg, "/health", &resp)
+	return resp.Sanctions
+}
+
+func TestSanctionsSyncAddsAndRemoves(t *testing.T) {
+	fixture := &sanctionsFixture{}
+	srv := httptest.NewServer(fixture)
+	defer srv.Close()
+	agg := newSanctionsAggregator(t, SanctionsFeed{Name: "ofac", URL: srv.URL, Format: sanctionsFormatSDN})
+	ctx := context.Background()
+	first, second, third := evmAddress("lazarus-1"), evmAddress("lazarus-2"), evmAddress("lazarus-3")
+
+	fixture.set(sdnRow("LAZARUS", "ETH "+first, "XMR 4A1mQ5m9JDd")+sdnRow("GARANTEX", "USDT "+second), false)
+	res := agg.SyncSanctions(ctx)
+	if len(res) != 1 || res[0].Entries != 2 || res[0].Added != 2 || res[0].Skipped != 1 {
+		t.Fatalf("Expected two addresses added and XMR skipped, got %+v", res)
+	}
+	for _, addr := range []string{first, second} {
+		entry, ok := agg.Confirmed(addr)
+		if !ok || entry.Category != sanctionsCategory || entry.Provenance.Source != "feed:ofac" || entry.Provenance.Mode != FeedTrusted {
+			t.Errorf("Expected %s confirmed as a trusted sanctions entry, got %+v", addr, entry)
+		}
+	}
+	health := sanctionsHealth(t, agg)
+	if len(health) != 1 || health[0].Feed != "ofac" || health[0].Entries != 2 || health[0].LastSyncedAt == nil || health[0].LastError != "" {
+		t.Fatalf("Expected /health to show the sync, got %+v", health)
+	}
+
+	fixture.set(sdnRow("LAZARUS", "ETH "+first, "ETH "+third), false)
+	res = agg.SyncSanctions(ctx)
+	if len(res) != 1 || res[0].Added != 1 || res[0].Removed != 1 {
+		t.Fatalf("Expected one added and one removed, got %+v", res)
+	}
+	if _, ok := agg.Confirmed(third); !ok {
+		t.Error("Expected the address new to the feed promoted")
+	}
+	if _, ok := agg.Confirmed(second); ok {
+		t.Error("Expected the delisted address demoted")
+	}
+	if version := agg.bloomFilter.Version(); agg.SyncSanctions(ctx)[0].Added != 0 || agg.bloomFilter.Version() != version {
+		t.Error("Expected an unchanged feed to change nothing")
+	}
+}
+
+func TestSanctionsSyncPublishesPromotedAndRemoved(t *testing.T) {
+	fixture := &sanctionsFixture{}
+	srv := httptest.NewServer(fixture)
+	defer srv.Close()
+	agg := newSanctionsAggregator(t, SanctionsFeed{Name: "ofac", URL: srv.URL, Format: sanctionsFormatSDN})
+	var got []Event
+	agg.events.handle(func(_ context.Context, events []Event) { got = append(got, events...) }, EventPromoted, EventRemoved)
+	addr, next := evmAddress("lazarus-1"), evmAddress("lazarus-2")
+
+	fixture.set(sdnRow("LAZARUS", "ETH "+addr), false)
+	agg.SyncSanctions(context.Background())
+	fixture.set(sdnRow("GARANTEX", "ETH "+next), false)
+	agg.SyncSanctions(context.Background())
+
+	if len(got) != 3 {
+		t.Fatalf("Expected a listing, a delisting and a listing, got %+v", got)
+	}
+	if ev := got[0]; ev.Type != EventPromoted || ev.Address != addr || ev.Tier != TierMain || ev.Source != "feed:ofac" || ev.Reason != eventReasonSanctioned {
+		t.Errorf("Expected the listing published as promoted by the feed, got %+v", ev)
+	}
+	if ev := got[1]; ev.Type != EventRemoved || ev.Address != addr || ev.Reason != eventReasonDelisted {
+		t.Errorf("Expected the delisting published as removed, got %+v", ev)
+	}
+}
+
+func TestSanctionsSyncKeepsEntriesWhenFetchFails(t *testing.T) {
+	fixture := &sanctionsFixture{}
+	srv := httptest.NewServer(fixture)
+	defer srv.Close()
+	agg := newSanctionsAggregator(t, SanctionsFeed{Name: "sanctioned-eth", URL: srv.URL, Format: sanctionsFormatList, ChainID: 1})
+	ctx := context.Background()
+	addr := evmAddress("tornado")
+
+	fixture.set("# sanctioned addresses\n"+addr+"\n\nnot-an-address\n", false)
+	if res := agg.SyncSanctions(ctx); len(res) != 1 || res[0].Added != 1 || res[0].Invalid != 1 {
+		t.Fatalf("Expected one address added and one invalid, got %+v", res)
+	}
+	synced := *sanctionsHealth(t, agg)[0].LastSyncedAt
+
+	for _, fail := range []struct {
+		name string
+		body string
+		down bool
+	}{
+		{"server error", "", true},
+		{"empty list", "# nothing today\n", false},
+	} {
+		fixture.set(fail.body, fail.down)
+		if res := agg.SyncSanctions(ctx); len(res) != 0 {
+			t.Errorf("%s: expected no sync applied, got %+v", fail.name, res)
+		}
+		if _, ok := agg.Confirmed(addr); !ok {
+			t.Errorf("%s: expected the synced address kept", fail.name)
+		}
+		health := sanctionsHealth(t, agg)[0]
+		if health.LastError == "" || health.Entries != 1 || !health.LastSyncedAt.Equal(synced) {
+			t.Errorf("%s: expected the failure shown beside the last successful sync, got %+v", fail.name, health)
+		}
+	}
+
+	srv.Close()
+	agg.SyncSanctions(ctx)
+	if _, ok := agg.Confirmed(addr); !ok {
+		t.Error("Expected the synced address kept while the feed is unreachable")
+	}
+}
@@ -0,0 +1,130 @@
+// Package swarm — Report sanitization.
+//
+// Whatever a report carries is recorded in TWAB and persisted with its
+// state, so before anything is stored a report is cleaned of content it
+// should never have held.  A report whose address, selector, category,
+// source ID, report ID or evidence holds invalid UTF-8 or a control
+// character is rejected whole with 422 invalid_content and a
+// *ContentError.  Other content is rewritten rather than rejected: a
+// source ID longer than maxSourceIDLen is replaced by a fixed-length
+// digest of itself, so the same agent still counts as one source, and a
+// URL cited as evidence loses its query string and userinfo, which carry
+// session tokens and credentials far more often than anything an analyst
+// needs.  Field limits are applied after (see ingest_limits.go).
+//
+// Each rewrite is named in the report's Sanitized notes, which stay with
+// it in the entry's retained reports and in exported state, and every
+// decision is counted in aegis_report_sanitizations_total by rule.
+package swarm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Sanitization rules, the labels of the metric and, for rewrites, the
+// notes on the report.
+const (
+	sanitizeInvalidUTF8    = "invalid_utf8"
+	sanitizeControlChar    = "control_character"
+	sanitizeSourceIDHashed = "source_id_hashed"
+	sanitizeEvidenceURL    = "evidence_url_stripped"
+)
+
+// A hashed source ID is the prefix and the first hashedSourceIDHexDigits
+// hex digits of the SHA-256 of the original.
+const (
+	hashedSourceIDPrefix    = "sha256:"
+	hashedSourceIDHexDigits = 32
+)
+
+// ContentError reports a report field holding content that is never
+// stored.
+type ContentError struct {
+	Field  string
+	Reason string
+
+	rule string // the sanitization rule broken
+}
+
+func (e *ContentError) Error() string {
+	return fmt.Sprintf("invalid report %s: %s", e.Field, e.Reason)
+}
+
+// sanitizeReport rejects a report holding invalid UTF-8 or control
+// characters, and hashes an over-long source ID.  Running it again on its
+// result changes nothing.
+func (s *SwarmAggregator) sanitizeReport(report *IOCReport) error {
+	if err := checkContent(*report); err != nil {
+		s.metrics.sanitizations.WithLabelValues(err.rule).Inc()
+		return err
+	}
+	if len(report.SourceID) > maxSourceIDLen {
+		report.SourceID = hashSourceID(report.SourceID)
+		s.noteSanitized(report, sanitizeSourceIDHashed)
+	}
+	return nil
+}
+
+// sanitizeEvidence strips the query string and userinfo from the URLs a
+// report cites, once they have been validated.
+func (s *SwarmAggregator) sanitizeEvidence(report *IOCReport) {
+	stripped := false
+	for i := range report.Evidence {
+		ev := &report.Evidence[i]
+		if ev.Type != EvidenceURL {
+			continue
+		}
+		u, err := url.Parse(ev.Value)
+		if err != nil || (u.User == nil && u.RawQuery == "" && !u.ForceQuery) {
+			continue
+		}
+		u.User, u.RawQuery, u.ForceQuery = nil, "", false
+		ev.Value, stripped = u.String(), true
+	}
+	if stripped {
+		s.noteSanitized(report, sanitizeEvidenceURL)
+	}
+}
+
+func (s *SwarmAggregator) noteSanitized(report *IOCReport, rule string) {
+	report.Sanitized = append(report.Sanitized, rule)
+	s.metrics.sanitizations.WithLabelValues(rule).Inc()
+}
+
+// hashSourceID returns the fixed-length digest standing in for an
+// over-long source ID.
+func hashSourceID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hashedSourceIDPrefix + hex.EncodeToString(sum[:])[:hashedSourceIDHexDigits]
+}
+
+// checkContent finds the first field of a report holding invalid UTF-8 or
+// a control character.
+func checkContent(report IOCReport) *ContentError {
+	fields := []struct{ name, value string }{
+		{"address", report.Address},
+		{"selector", report.Selector},
+		{"category", report.Category},
+		{"source_id", report.SourceID},
+		{"report_id", report.ReportID},
+	}
+	for i, ev := range report.Evidence {
+		fields = append(fields, struct{ name, value string }{fmt.Sprintf("evidence[%d]", i), ev.Value})
+	}
+	for _, f := range fields {
+		if !utf8.ValidString(f.value) {
+			return &ContentError{Field: f.name, Reason: "invalid UTF-8", rule: sanitizeInvalidUTF8}
+		}
+		for i, r := range f.value {
+			if unicode.IsControl(r) {
+				return &ContentError{Field: f.name, Reason: fmt.Sprintf("control character %U at byte %d", r, i), rule: sanitizeControlChar}
+			}
+		}
+	}
+	return nil
+}
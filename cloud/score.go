@@ -0,0 +1,113 @@
+// Package swarm — Consensus score.
+//
+// Besides the pass/fail threshold, every address has a consensus score in
+// [0, 1] so clients can apply their own risk appetite, e.g. warn at 0.5
+// and block at 0.9.  The score is the weighted mean of four components,
+// each in [0, 1]:
+//
+//   - reports: report count over twab.min_report_count
+//   - sources: distinct sources over twab.min_distinct_sources
+//   - time_span: time span over twab.min_time_span_seconds
+//   - confidence: the mean report confidence
+//
+// Each ratio is capped at 1, and a zero threshold counts as met.  The
+// weights are twab.score_weights; source reputation is not tracked yet,
+// so it has no component.
+//
+// Promotion is "score >= twab.promotion_score", followed by the optional
+// min_average_confidence and evidence gates.  The defaults, weights 1, 1,
+// 1, 0 and promotion score 1, are the original gates exactly: the mean
+// reaches 1 only when every weighted component is saturated, that is when
+// every threshold is met.  Lowering the promotion score, or weighing
+// confidence, promotes on partial agreement instead.
+//
+// The score is served by /check, /address/{addr}, the exact filter format
+// (one score per address, in chunk order) and PromotionEvent.
+package swarm
+
+import "math"
+
+// ScoreWeights weighs the components of the consensus score.  All zero
+// means DefaultScoreWeights.
+type ScoreWeights struct {
+	Reports    float64 `json:"reports" yaml:"reports"`
+	Sources    float64 `json:"sources" yaml:"sources"`
+	TimeSpan   float64 `json:"time_span" yaml:"time_span"`
+	Confidence float64 `json:"confidence" yaml:"confidence"`
+}
+
+// DefaultScoreWeights reproduce the pass/fail gates at promotion score 1.
+func DefaultScoreWeights() ScoreWeights {
+	return ScoreWeights{Reports: 1, Sources: 1, TimeSpan: 1}
+}
+
+// weights returns the score weights in effect.
+func (c TWABConfig) weights() ScoreWeights {
+	if c.ScoreWeights == (ScoreWeights{}) {
+		return DefaultScoreWeights()
+	}
+	return c.ScoreWeights
+}
+
+// promotionScore returns the score promotion needs; zero means 1.
+func (c TWABConfig) promotionScore() float64 {
+	if c.PromotionScore <= 0 {
+		return 1
+	}
+	return c.PromotionScore
+}
+
+// progress is observed over threshold, capped at 1; a zero threshold is
+// always met.
+func progress(observed, threshold float64) float64 {
+	if observed >= threshold {
+		return 1
+	}
+	return observed / threshold
+}
+
+// score computes an entry's consensus score.  The caller holds the shard
+// lock.
+func (c TWABConfig) score(entry *TWABEntry) float64 {
+	w := c.weights()
+	components := [...]struct{ weight, value float64 }{
+		{w.Reports, progress(float64(entry.ReportCount), float64(c.MinReportCount))},
+		{w.Sources, progress(float64(len(entry.Sources)), float64(c.MinDistinctSources))},
+		{w.TimeSpan, progress(c.timeSpan(entry).Seconds(), c.MinTimeSpanSeconds)},
+		{w.Confidence, math.Min(entry.meanConfidence(), 1)},
+	}
+	var sum, total float64
+	saturated := true
+	for _, comp := range components {
+		sum += comp.weight * comp.value
+		total += comp.weight
+		saturated = saturated && (comp.weight == 0 || comp.value == 1)
+	}
+	// Only a fully saturated entry scores 1; rounding in the sum must not
+	// lift one a hair short of a threshold past promotion score 1.
+	if saturated {
+		return 1
+	}
+	return math.Min(sum/total, math.Nextafter(1, 0))
+}
+
+// ConsensusScore returns an address's consensus score under config, zero
+// if it has no reports.
+func (t *TWAB) ConsensusScore(address string, config TWABConfig) float64 {
+	shard := t.shardFor(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, ok := shard.entries[address]
+	if !ok {
+		return 0
+	}
+	config, _ = config.forEntry(address, entry)
+	return config.score(entry)
+}
+
+// ConsensusScore returns an address's global consensus score under the
+// running thresholds.
+func (s *SwarmAggregator) ConsensusScore(address string) float64 {
+	return s.twab.ConsensusScore(address, s.current().TWAB)
+}
@@ -0,0 +1,117 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConsensusScoreComponents(t *testing.T) {
+	cfg := TWABConfig{MinReportCount: 4, MinDistinctSources: 2, MinTimeSpanSeconds: 100}
+	tw := NewTWAB(cfg)
+	addr := evmAddress("scored")
+	base := time.Now()
+	if s := tw.ConsensusScore(addr, cfg); s != 0 {
+		t.Errorf("Expected an untracked address to score 0, got %v", s)
+	}
+
+	tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.9, Timestamp: base, SourceID: "agent-A"})
+	tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.9, Timestamp: base.Add(50 * time.Second), SourceID: "agent-A"})
+	if s := tw.ConsensusScore(addr, cfg); s != 0.5 {
+		t.Errorf("Expected half of every threshold to score 0.5, got %v", s)
+	}
+	cfg.ScoreWeights = ScoreWeights{Reports: 1, Sources: 1, TimeSpan: 1, Confidence: 1}
+	if s := tw.ConsensusScore(addr, cfg); s != 0.6 {
+		t.Errorf("Expected mean confidence 0.9 to lift the score to 0.6, got %v", s)
+	}
+	cfg.ScoreWeights = ScoreWeights{Sources: 1}
+	if s := tw.ConsensusScore(addr, cfg); s != 0.5 {
+		t.Errorf("Expected only the sources component to count, got %v", s)
+	}
+}
+
+// The default weights at promotion score 1 must reproduce the original
+// all-gates-pass rule exactly.
+func TestDefaultScoreReproducesGates(t *testing.T) {
+	cfg := TWABConfig{MinReportCount: 3, MinDistinctSources: 2, MinTimeSpanSeconds: 60}
+	base := time.Now()
+	for reports := 1; reports <= 4; reports++ {
+		for sources := 1; sources <= 3; sources++ {
+			for _, span := range []time.Duration{0, 59999 * time.Millisecond, time.Minute, time.Hour} {
+				tw := NewTWAB(cfg)
+				addr := evmAddress("grid")
+				for i := 0; i < reports; i++ {
+					ts := base
+					if i == reports-1 {
+						ts = base.Add(span)
+					}
+					tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.5, Timestamp: ts, SourceID: string(rune('A' + i%sources))})
+				}
+				distinct, actual := min(reports, sources), span
+				if reports == 1 {
+					actual = 0
+				}
+				gates := reports >= cfg.MinReportCount && distinct >= cfg.MinDistinctSources && actual.Seconds() >= cfg.MinTimeSpanSeconds
+				if got := tw.MeetsThreshold(addr, cfg); got != gates {
+					t.Errorf("%d reports, %d sources, span %v: score promotion %v, gates %v", reports, distinct, span, got, gates)
+				}
+			}
+		}
+	}
+}
+
+func TestPartialScorePromotionAndExposure(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 4, MinDistinctSources: 2, PromotionScore: 0.75})
+	sink := &recordingSink{}
+	agg.AddAlertSink(sink)
+	addr := evmAddress("partial")
+	ctx := context.Background()
+
+	// One report of four from one source of two: (0.25 + 0.5 + 1) / 3.
+	if agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"}) {
+		t.Fatal("Expected no promotion at score 0.58")
+	}
+	// A second source reaches (0.5 + 1 + 1) / 3, past 0.75.
+	if !agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-B"}) {
+		t.Fatal("Expected promotion at score 0.83")
+	}
+	want := 2.5 / 3
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?address="+addr, nil))
+	var check struct {
+		Flagged bool    `json:"flagged"`
+		Score   float64 `json:"consensus_score"`
+	}
+	json.NewDecoder(rec.Body).Decode(&check)
+	if !check.Flagged || check.Score != want {
+		t.Errorf("Expected /check to flag at score %v, got %+v", want, check)
+	}
+
+	rec = httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/address/"+addr, nil))
+	var detail struct {
+		TWAB TWABDetail `json:"twab"`
+	}
+	json.NewDecoder(rec.Body).Decode(&detail)
+	if detail.TWAB.ConsensusScore != want {
+		t.Errorf("Expected the address detail to carry score %v, got %v", want, detail.TWAB.ConsensusScore)
+	}
+
+	env, err := agg.signFormat(agg.globalSnapshot(), FormatExact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := decodeExact(t, env.Payload)
+	if len(p.Chunks) != 1 || len(p.Chunks[0].Scores) != 1 || p.Chunks[0].Scores[0] != want {
+		t.Errorf("Expected one score of %v in the exact payload, got %+v", want, p.Chunks)
+	}
+
+	agg.alerts.flush(ctx)
+	if events := sink.Events(); len(events) != 1 || events[0].Score != want {
+		t.Errorf("Expected the promotion event to carry score %v, got %+v", want, events)
+	}
+}
@@ -0,0 +1,59 @@
+// Package swarm — Severity bands.
+//
+// A report may rate how severe what it saw is, from 1, a suspicious
+// pattern, to 5, an active drainer.  twab.severity_bands scales the
+// promotion thresholds with it: each band gives the thresholds for
+// addresses whose highest reported severity is at least its
+// min_severity, the band with the highest such min_severity applying,
+// e.g.
+//
+//	severity_bands:
+//	  - min_severity: 5   # two sources in ten minutes
+//	    config: {min_report_count: 2, min_distinct_sources: 2, min_time_span_seconds: 600}
+//	  - min_severity: 1   # five sources over a day
+//	    config: {min_report_count: 5, min_distinct_sources: 5, min_time_span_seconds: 86400}
+//
+// Addresses below every band, or never given a severity, keep the
+// thresholds the bands are listed in.  The band is chosen afresh each
+// time a report is recorded, so one severe report for an address that
+// already has many mild ones puts it under the tighter band at once.
+// /explain names the band applied, by its min_severity, as do promotion
+// events and alerts.
+package swarm
+
+// maxReportSeverity is the highest severity a report may give.
+const maxReportSeverity = 5
+
+// SeverityBand is the thresholds of addresses reported at least
+// MinSeverity.
+type SeverityBand struct {
+	MinSeverity int        `json:"min_severity" yaml:"min_severity"`
+	Config      TWABConfig `json:"config" yaml:"config"`
+}
+
+// bandFor returns the index of the band applying to an address reported
+// at most severity, if any.
+func (c TWABConfig) bandFor(severity int) (int, bool) {
+	best, found := 0, false
+	for i, band := range c.SeverityBands {
+		if band.MinSeverity <= severity && (!found || band.MinSeverity > c.SeverityBands[best].MinSeverity) {
+			best, found = i, true
+		}
+	}
+	return best, found
+}
+
+// SeverityBand returns the MinSeverity of the band whose thresholds apply
+// to an address under config, zero for none.
+func (t *TWAB) SeverityBand(address string, config TWABConfig) int {
+	shard := t.shardFor(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, ok := shard.entries[address]
+	if !ok {
+		return 0
+	}
+	_, _, band := config.resolve(address, entry)
+	return band
+}
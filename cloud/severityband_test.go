@@ -0,0 +1,103 @@
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bandedTWAB asks five sources over a day, or under its bands two sources
+// in ten minutes for severity 5 and the same five over a day for 1 to 4.
+var bandedTWAB = TWABConfig{
+	MinReportCount: 5, MinDistinctSources: 5, MinTimeSpanSeconds: 86400,
+	SeverityBands: []SeverityBand{
+		{MinSeverity: 1, Config: TWABConfig{MinReportCount: 5, MinDistinctSources: 5, MinTimeSpanSeconds: 86400}},
+		{MinSeverity: 5, Config: TWABConfig{MinReportCount: 2, MinDistinctSources: 2, MinTimeSpanSeconds: 600}},
+	},
+}
+
+// recordSeverities records one report per severity, from a new source
+// each, five minutes apart from base.
+func recordSeverities(tw *TWAB, addr string, base time.Time, severities ...int) {
+	for i, severity := range severities {
+		tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.9, Severity: severity, Timestamp: base.Add(time.Duration(i) * 5 * time.Minute), SourceID: fmt.Sprintf("agent-%d", i)})
+	}
+}
+
+func TestSeverityBandsScalePromotionSpeed(t *testing.T) {
+	tw := NewTWAB(bandedTWAB)
+	base := time.Now()
+
+	critical := evmAddress("active-drainer")
+	recordSeverities(tw, critical, base, 5, 5, 5, 5)
+	ex := tw.Explain(critical, bandedTWAB)
+	if !ex.MeetsThreshold || ex.SeverityBand != 5 || ex.MaxSeverity != 5 || ex.Override != "severity_bands.1" {
+		t.Errorf("Expected severity 5 promoted on two sources in ten minutes, got %+v", ex)
+	}
+
+	mild := evmAddress("suspicious-pattern")
+	recordSeverities(tw, mild, base, 1, 1, 1)
+	if ex := tw.Explain(mild, bandedTWAB); ex.MeetsThreshold || ex.SeverityBand != 1 {
+		t.Errorf("Expected severity 1 held to five sources over a day, got %+v", ex)
+	}
+
+	unrated := evmAddress("unrated")
+	recordSeverities(tw, unrated, base, 0, 0, 0)
+	if ex := tw.Explain(unrated, bandedTWAB); ex.MeetsThreshold || ex.SeverityBand != 0 || ex.Override != "" {
+		t.Errorf("Expected unrated reports under the base thresholds, got %+v", ex)
+	}
+}
+
+func TestLateSevereReportTightensBandAtOnce(t *testing.T) {
+	tw := NewTWAB(bandedTWAB)
+	addr := evmAddress("escalating")
+	base := time.Now()
+	recordSeverities(tw, addr, base, 1, 2, 1, 2)
+	if tw.MeetsThreshold(addr, bandedTWAB) {
+		t.Fatal("Expected many low-severity reports in twenty minutes held back")
+	}
+	tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.9, Severity: 5, Timestamp: base.Add(25 * time.Minute), SourceID: "agent-late"})
+	if !tw.MeetsThreshold(addr, bandedTWAB) || tw.SeverityBand(addr, bandedTWAB) != 5 {
+		t.Errorf("Expected a late severity 5 report to promote under its band, got band %d", tw.SeverityBand(addr, bandedTWAB))
+	}
+}
+
+func TestPromotionEventNamesSeverityBand(t *testing.T) {
+	agg := newTestAggregator(bandedTWAB)
+	var promoted []Event
+	agg.events.handle(func(_ context.Context, events []Event) { promoted = append(promoted, events...) }, EventPromoted)
+
+	addr := evmAddress("drainer")
+	base := time.Now().Add(-time.Hour)
+	for i, source := range []string{"agent-A", "agent-B", "agent-A"} {
+		agg.IngestReport(context.Background(), IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Severity: 5, Timestamp: base.Add(time.Duration(i/2) * 10 * time.Minute), SourceID: source})
+	}
+	if len(promoted) != 1 || promoted[0].SeverityBand != 5 {
+		t.Errorf("Expected one promotion under band 5, got %+v", promoted)
+	}
+}
+
+func TestSeverityValidation(t *testing.T) {
+	agg := newTestAggregator(bandedTWAB)
+	agg.config.Ingest.Synchronous = true
+	rec := postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"severity":6,"source_id":"agent-A"}`, evmAddress("too-severe")))
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "severity") {
+		t.Errorf("Expected severity 6 rejected, got %d %s", rec.Code, rec.Body)
+	}
+
+	cfg := DefaultConfig()
+	cfg.TWAB.SeverityBands = []SeverityBand{
+		{MinSeverity: 0, Config: DefaultTWABConfig()},
+		{MinSeverity: 3, Config: DefaultTWABConfig()},
+		{MinSeverity: 3, Config: TWABConfig{MinReportCount: 1, MinDistinctSources: 1, SeverityBands: []SeverityBand{{MinSeverity: 4}}}},
+	}
+	err := cfg.Validate()
+	for _, want := range []string{"severity_bands.0.min_severity", "severity_bands.2 repeats min_severity 3", "severity_bands.2 may not have"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q reported, got %v", want, err)
+		}
+	}
+}
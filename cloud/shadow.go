@@ -0,0 +1,172 @@
+// Package swarm — Shadow evaluation of candidate TWAB thresholds.
+//
+// Before tightening or loosening twab thresholds in production, operators
+// can configure named candidates under shadow.  Every report to the
+// global swarm is run against each candidate's thresholds as well as the
+// real ones, on the same recorded entry, and the candidate's verdicts are
+// only counted: they never touch the filter, the confirmed set, alerts,
+// or peers.  Verdicts are threshold verdicts alone; the allowlist is not
+// consulted on either side, and tenant namespaces are not evaluated.
+//
+// For each candidate the evaluator keeps the addresses it would have
+// promoted and those on which it currently disagrees with the primary
+// thresholds, as of each address's latest report.  GET /admin/shadow
+// reports both; aegis_shadow_verdicts_total counts every comparison.
+package swarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Shadow verdict outcomes recorded in aegis_shadow_verdicts_total.
+const (
+	shadowOutcomeAgree         = "agree"
+	shadowOutcomePrimaryOnly   = "primary_only"
+	shadowOutcomeCandidateOnly = "candidate_only"
+)
+
+// ShadowDivergence is an address on which the primary and a candidate
+// disagree.  Since is when they started to.
+type ShadowDivergence struct {
+	Address   string    `json:"address"`
+	Primary   bool      `json:"primary"`
+	Candidate bool      `json:"candidate"`
+	Since     time.Time `json:"since"`
+}
+
+// shadowCandidate is one candidate's thresholds and what they decided.
+type shadowCandidate struct {
+	name     string
+	config   TWABConfig
+	promoted map[string]bool             // addresses the candidate would have promoted
+	diverged map[string]ShadowDivergence // address -> current disagreement
+}
+
+// shadowEvaluator runs every report against the candidates.  A nil
+// *shadowEvaluator evaluates nothing.
+type shadowEvaluator struct {
+	candidates []*shadowCandidate // sorted by name
+	promotions *prometheus.CounterVec
+	verdicts   *prometheus.CounterVec
+
+	mu      sync.Mutex // guards the maps of every candidate and primary
+	primary map[string]bool
+}
+
+// newShadowEvaluator returns an evaluator for the configured candidates,
+// or nil if there are none.
+func newShadowEvaluator(candidates map[string]TWABConfig, promotions, verdicts *prometheus.CounterVec) *shadowEvaluator {
+	if len(candidates) == 0 {
+		return nil
+	}
+	e := &shadowEvaluator{promotions: promotions, verdicts: verdicts, primary: make(map[string]bool)}
+	for name, cfg := range candidates {
+		e.candidates = append(e.candidates, &shadowCandidate{
+			name:     name,
+			config:   cfg,
+			promoted: make(map[string]bool),
+			diverged: make(map[string]ShadowDivergence),
+		})
+	}
+	sort.Slice(e.candidates, func(i, j int) bool { return e.candidates[i].name < e.candidates[j].name })
+	return e
+}
+
+// evaluate compares each candidate's verdict on an address just recorded
+// in tw with the primary verdict.
+func (e *shadowEvaluator) evaluate(tw *TWAB, address string, primary bool, now time.Time) {
+	if e == nil {
+		return
+	}
+	verdicts := make([]bool, len(e.candidates))
+	for i, c := range e.candidates {
+		verdicts[i] = tw.MeetsThreshold(address, c.config)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if primary {
+		e.primary[address] = true
+	}
+	for i, c := range e.candidates {
+		verdict := verdicts[i]
+		if verdict && !c.promoted[address] {
+			c.promoted[address] = true
+			e.promotions.WithLabelValues(c.name).Inc()
+		}
+		outcome := shadowOutcomeAgree
+		switch {
+		case verdict == primary:
+			delete(c.diverged, address)
+		case primary:
+			outcome = shadowOutcomePrimaryOnly
+		default:
+			outcome = shadowOutcomeCandidateOnly
+		}
+		if outcome != shadowOutcomeAgree {
+			if d, ok := c.diverged[address]; !ok || d.Candidate != verdict {
+				c.diverged[address] = ShadowDivergence{Address: address, Primary: primary, Candidate: verdict, Since: now}
+			}
+		}
+		e.verdicts.WithLabelValues(c.name, outcome).Inc()
+	}
+}
+
+// ShadowReport is one candidate's standing against the primary thresholds.
+type ShadowReport struct {
+	Candidate       string             `json:"candidate"`
+	Config          TWABConfig         `json:"config"`
+	Promoted        int                `json:"promoted"`
+	PrimaryPromoted int                `json:"primary_promoted"`
+	Divergences     []ShadowDivergence `json:"divergences"`
+}
+
+// reports returns a report per candidate, by name, with divergences
+// sorted by address.
+func (e *shadowEvaluator) reports() []ShadowReport {
+	out := []ShadowReport{}
+	if e == nil {
+		return out
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, c := range e.candidates {
+		r := ShadowReport{
+			Candidate:       c.name,
+			Config:          c.config,
+			Promoted:        len(c.promoted),
+			PrimaryPromoted: len(e.primary),
+			Divergences:     make([]ShadowDivergence, 0, len(c.diverged)),
+		}
+		for _, d := range c.diverged {
+			r.Divergences = append(r.Divergences, d)
+		}
+		sort.Slice(r.Divergences, func(i, j int) bool { return r.Divergences[i].Address < r.Divergences[j].Address })
+		out = append(out, r)
+	}
+	return out
+}
+
+// ShadowReports returns the divergence report of every shadow candidate.
+func (s *SwarmAggregator) ShadowReports() []ShadowReport {
+	return s.shadow.reports()
+}
+
+// handleAdminShadow is the HTTP handler for GET /admin/shadow.
+func (s *SwarmAggregator) handleAdminShadow(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"primary":    s.current().TWAB,
+		"candidates": s.ShadowReports(),
+	})
+}
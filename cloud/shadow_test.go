@@ -0,0 +1,119 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestShadowCandidatesEvaluateWithoutTouchingFilter(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Shadow = map[string]TWABConfig{
+		"strict": {MinReportCount: 3, MinDistinctSources: 3},
+		"loose":  {MinReportCount: 1, MinDistinctSources: 1},
+	}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	ctx := context.Background()
+
+	// Three addresses reported by two sources, one by three, one by one.
+	report := func(addr string, sources int) {
+		for i := 0; i < sources; i++ {
+			agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: fmt.Sprintf("agent-%d", i)})
+		}
+	}
+	for i := 0; i < 3; i++ {
+		report(evmAddress(fmt.Sprintf("pair-%d", i)), 2)
+	}
+	triple, single := evmAddress("triple"), evmAddress("single")
+	report(triple, 3)
+	report(single, 1)
+
+	if agg.BloomFilterLen() != 4 {
+		t.Fatalf("Expected the primary thresholds alone to decide the filter, got %d entries", agg.BloomFilterLen())
+	}
+
+	byName := map[string]ShadowReport{}
+	for _, r := range agg.ShadowReports() {
+		byName[r.Candidate] = r
+	}
+	strict, loose := byName["strict"], byName["loose"]
+	if strict.PrimaryPromoted != 4 || strict.Promoted != 1 || strict.Promoted >= strict.PrimaryPromoted {
+		t.Errorf("Expected the strict candidate to promote 1 of the primary's 4, got %+v", strict)
+	}
+	if len(strict.Divergences) != 3 {
+		t.Errorf("Expected the three pairs to diverge under strict, got %+v", strict.Divergences)
+	}
+	for _, d := range strict.Divergences {
+		if !d.Primary || d.Candidate || d.Address == triple {
+			t.Errorf("Expected primary-only divergences, got %+v", d)
+		}
+	}
+	if loose.Promoted != 5 || len(loose.Divergences) != 1 || loose.Divergences[0].Address != single || !loose.Divergences[0].Candidate {
+		t.Errorf("Expected the loose candidate to also promote the single-source address, got %+v", loose)
+	}
+	if _, ok := agg.Confirmed(single); ok {
+		t.Error("A shadow verdict must not confirm an address")
+	}
+
+	if got := testutil.ToFloat64(agg.metrics.shadowPromotions.WithLabelValues("strict")); got != 1 {
+		t.Errorf("Expected shadow_promotions_total{strict} 1, got %v", got)
+	}
+	if got := testutil.ToFloat64(agg.metrics.shadowVerdicts.WithLabelValues("loose", shadowOutcomeCandidateOnly)); got != 5 {
+		t.Errorf("Expected each first report to be candidate-only for loose, got %v", got)
+	}
+}
+
+func TestShadowDivergenceClearsOnAgreement(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Shadow = map[string]TWABConfig{"strict": {MinReportCount: 3, MinDistinctSources: 3}}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	addr := evmAddress("catches-up")
+	for i := 0; i < 3; i++ {
+		agg.IngestReport(context.Background(), IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: fmt.Sprintf("agent-%d", i)})
+		if i == 1 && len(agg.ShadowReports()[0].Divergences) != 1 {
+			t.Fatal("Expected a divergence once only the primary promotes")
+		}
+	}
+	if r := agg.ShadowReports()[0]; len(r.Divergences) != 0 || r.Promoted != 1 {
+		t.Errorf("Expected agreement after the third source, got %+v", r)
+	}
+}
+
+func TestShadowEndpoint(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Shadow = map[string]TWABConfig{"strict": {MinReportCount: 5, MinDistinctSources: 3}}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.keys.Add("admin-secret", APIKey{ID: "ops", Role: RoleAdmin})
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/shadow", nil)
+	req.Header.Set("X-API-Key", "admin-secret")
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	var resp struct {
+		Primary    TWABConfig     `json:"primary"`
+		Candidates []ShadowReport `json:"candidates"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 with a report, got %d (%v)", rec.Code, err)
+	}
+	if !reflect.DeepEqual(resp.Primary, cfg.TWAB) || len(resp.Candidates) != 1 || resp.Candidates[0].Config.MinReportCount != 5 {
+		t.Errorf("Unexpected shadow report: %+v", resp)
+	}
+
+	bad := DefaultConfig()
+	bad.Shadow = map[string]TWABConfig{"no sources": {MinReportCount: 1}}
+	err := bad.Validate()
+	if err == nil || !strings.Contains(err.Error(), "shadow: invalid candidate name") || !strings.Contains(err.Error(), "shadow.no sources.min_distinct_sources") {
+		t.Errorf("Expected the candidate name and thresholds rejected, got %v", err)
+	}
+}
@@ -0,0 +1,295 @@
+// Package swarm — Aegis Swarm filter signing.
+//
+// Every serialized filter is signed with the aggregator's Ed25519 key so
+// clients can tell a genuine push from one injected by a compromised relay.
+// The signature covers the big-endian filter version followed by the
+// SHA-256 of the payload.  Keys are identified by a short hash of the
+// public key; the active key is the newest in the keyring, and retired
+// keys stay listed on GET /keys so in-flight payloads remain verifiable
+// across a rotation.
+package swarm
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aegis-protocol/swarm/types"
+)
+
+// Response headers carrying the signature of a GET /filter payload.
+const (
+	headerFilterVersion   = "X-Aegis-Filter-Version"
+	headerFilterKeyID     = "X-Aegis-Key-Id"
+	headerFilterSignature = "X-Aegis-Signature"
+
+	// Set only when the aggregator has an instance ID (see replication.go).
+	headerFilterInstance       = "X-Aegis-Instance"
+	headerFilterLogicalVersion = "X-Aegis-Logical-Version"
+)
+
+// FilterEnvelope wraps a signed payload: the serialized filter, or for a
+// resuming subscriber a FilterDelta (see the types package).
+type FilterEnvelope = types.FilterEnvelope
+
+// Envelope kinds.
+const (
+	envelopeSnapshot = types.KindSnapshot
+	envelopeDelta    = types.KindDelta
+	envelopeChunk    = types.KindChunk
+)
+
+// SigningKey is one Ed25519 keypair in the keyring.
+type SigningKey struct {
+	ID        string
+	Private   ed25519.PrivateKey
+	CreatedAt time.Time
+}
+
+// Public returns the public half of the key.
+func (k SigningKey) Public() ed25519.PublicKey {
+	return k.Private.Public().(ed25519.PublicKey)
+}
+
+// PublicKeyInfo is the GET /keys representation of a signing key.
+type PublicKeyInfo struct {
+	KeyID     string    `json:"key_id"`
+	Algorithm string    `json:"algorithm"`
+	PublicKey string    `json:"public_key"`
+	CreatedAt time.Time `json:"created_at"`
+	Active    bool      `json:"active"`
+}
+
+// persistedKey is the on-disk form of a SigningKey.
+type persistedKey struct {
+	KeyID     string    `json:"key_id"`
+	Seed      string    `json:"seed"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Keyring holds the aggregator's signing keys, oldest first.  The last key
+// is the active one.  A keyring with a path persists every change.
+type Keyring struct {
+	mu   sync.RWMutex
+	path string
+	keys []SigningKey
+}
+
+// signingKeyID derives a key identifier: the first eight bytes of the
+// SHA-256 of the public key, hex encoded.
+func signingKeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// filterSigningMessage is the byte string signed for a filter payload.
+func filterSigningMessage(version uint64, payload []byte) []byte {
+	sum := sha256.Sum256(payload)
+	msg := make([]byte, 8, 8+len(sum))
+	binary.BigEndian.PutUint64(msg, version)
+	return append(msg, sum[:]...)
+}
+
+func generateSigningKey() (SigningKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{ID: signingKeyID(pub), Private: priv, CreatedAt: time.Now().UTC()}, nil
+}
+
+// NewEphemeralKeyring creates an in-memory keyring with one fresh key.
+// Its signatures do not survive a restart.
+func NewEphemeralKeyring() (*Keyring, error) {
+	key, err := generateSigningKey()
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{keys: []SigningKey{key}}, nil
+}
+
+// LoadOrCreateKeyring loads the keyring at path, generating and persisting
+// a new key when the file does not exist yet.
+func LoadOrCreateKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := generateSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		kr := &Keyring{path: path, keys: []SigningKey{key}}
+		if err := kr.save(); err != nil {
+			return nil, err
+		}
+		return kr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Keys []persistedKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid keyring %s: %w", path, err)
+	}
+	if len(file.Keys) == 0 {
+		return nil, fmt.Errorf("keyring %s has no keys", path)
+	}
+
+	kr := &Keyring{path: path}
+	for _, pk := range file.Keys {
+		seed, err := base64.StdEncoding.DecodeString(pk.Seed)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("keyring %s: invalid seed for key %s", path, pk.KeyID)
+		}
+		key := SigningKey{Private: ed25519.NewKeyFromSeed(seed), CreatedAt: pk.CreatedAt}
+		key.ID = signingKeyID(key.Public())
+		if pk.KeyID != "" && pk.KeyID != key.ID {
+			return nil, fmt.Errorf("keyring %s: key ID %s does not match its seed", path, pk.KeyID)
+		}
+		kr.keys = append(kr.keys, key)
+	}
+	return kr, nil
+}
+
+// save writes the keyring to its path, if any, readable only by the owner.
+// The caller holds mu (or exclusive access).
+func (kr *Keyring) save() error {
+	if kr.path == "" {
+		return nil
+	}
+	file := struct {
+		Keys []persistedKey `json:"keys"`
+	}{}
+	for _, key := range kr.keys {
+		file.Keys = append(file.Keys, persistedKey{
+			KeyID:     key.ID,
+			Seed:      base64.StdEncoding.EncodeToString(key.Private.Seed()),
+			CreatedAt: key.CreatedAt,
+		})
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := kr.path + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(kr.path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, kr.path)
+}
+
+// Active returns the key new payloads are signed with.
+func (kr *Keyring) Active() SigningKey {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.keys[len(kr.keys)-1]
+}
+
+// Rotate generates a new active key.  Previous keys are retained for
+// verification only.
+func (kr *Keyring) Rotate() (SigningKey, error) {
+	key, err := generateSigningKey()
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	kr.keys = append(kr.keys, key)
+	if err := kr.save(); err != nil {
+		kr.keys = kr.keys[:len(kr.keys)-1]
+		return SigningKey{}, err
+	}
+	return key, nil
+}
+
+// Sign signs a payload of the given version with the active key.
+func (kr *Keyring) Sign(version uint64, payload []byte) (keyID, signature string) {
+	key := kr.Active()
+	sig := ed25519.Sign(key.Private, filterSigningMessage(version, payload))
+	return key.ID, base64.StdEncoding.EncodeToString(sig)
+}
+
+// PublicKeys lists every key in the keyring, newest first.
+func (kr *Keyring) PublicKeys() []PublicKeyInfo {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	infos := make([]PublicKeyInfo, 0, len(kr.keys))
+	for i := len(kr.keys) - 1; i >= 0; i-- {
+		key := kr.keys[i]
+		infos = append(infos, PublicKeyInfo{
+			KeyID:     key.ID,
+			Algorithm: "ed25519",
+			PublicKey: base64.StdEncoding.EncodeToString(key.Public()),
+			CreatedAt: key.CreatedAt,
+			Active:    i == len(kr.keys)-1,
+		})
+	}
+	return infos
+}
+
+// signedFilter serializes the filter and signs the result.
+func (s *SwarmAggregator) signedFilter() (FilterEnvelope, error) {
+	return s.signSnapshot(s.globalSnapshot())
+}
+
+// signedFilterJSON returns the encoded envelope pushed to subscribers.
+func (s *SwarmAggregator) signedFilterJSON() ([]byte, error) {
+	env, err := s.signedFilter()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(env)
+}
+
+// handleKeys is the HTTP handler for GET /keys.
+func (s *SwarmAggregator) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active_key_id": s.signer.Active().ID,
+		"keys":          s.signer.PublicKeys(),
+	})
+}
+
+// handleAdminRotateKey is the HTTP handler for POST /admin/keys/rotate.
+func (s *SwarmAggregator) handleAdminRotateKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditSigningRotate}) {
+		return
+	}
+	key, err := s.signer.Rotate()
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to rotate signing key")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"active_key_id": key.ID,
+		"public_key":    base64.StdEncoding.EncodeToString(key.Public()),
+	})
+}
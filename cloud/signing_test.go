@@ -0,0 +1,80 @@
+package swarm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/client"
+)
+
+func TestTamperedFilterPayloadFailsVerification(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 1, MinDistinctSources: 1})
+	agg.IngestReport(context.Background(), IOCReport{Address: evmAddress("bad"), ChainID: 1, Confidence: 1.0, Timestamp: time.Now(), SourceID: "agent-A"})
+
+	env, err := agg.signedFilterJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := agg.signer.Active().Public()
+	if err := client.VerifyFilterPayload(pub, env); err != nil {
+		t.Fatalf("Expected genuine envelope to verify, got %v", err)
+	}
+
+	tampered := bytes.Replace(env, []byte(evmAddress("bad")), []byte(evmAddress("gud")), 1)
+	if err := client.VerifyFilterPayload(pub, tampered); !errors.Is(err, client.ErrBadSignature) {
+		t.Errorf("Expected tampered payload to fail verification, got %v", err)
+	}
+}
+
+func TestFilterHeadersCarrySignature(t *testing.T) {
+	agg := NewSwarmAggregator()
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/filter", nil))
+
+	h := rec.Header()
+	version, _ := strconv.ParseUint(h.Get(headerFilterVersion), 10, 64)
+	err := client.VerifySignature(agg.signer.Active().Public(), h.Get(headerFilterKeyID), h.Get(headerFilterSignature), version, rec.Body.Bytes())
+	if err != nil {
+		t.Errorf("GET /filter signature did not verify: %v", err)
+	}
+}
+
+func TestKeyringPersistsAndRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	kr, err := LoadOrCreateKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadOrCreateKeyring failed: %v", err)
+	}
+	first := kr.Active()
+
+	reloaded, err := LoadOrCreateKeyring(path)
+	if err != nil || reloaded.Active().ID != first.ID {
+		t.Fatalf("Expected the persisted key %s after reload, got %v", first.ID, err)
+	}
+
+	rotated, err := reloaded.Rotate()
+	if err != nil || rotated.ID == first.ID {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	agg := NewSwarmAggregator()
+	agg.signer, _ = LoadOrCreateKeyring(path)
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/keys", nil))
+	var resp struct {
+		ActiveKeyID string          `json:"active_key_id"`
+		Keys        []PublicKeyInfo `json:"keys"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.ActiveKeyID != rotated.ID || len(resp.Keys) != 2 || !resp.Keys[0].Active || resp.Keys[1].KeyID != first.ID {
+		t.Errorf("Unexpected /keys response %+v", resp)
+	}
+}
@@ -0,0 +1,400 @@
+// Package swarm — Offline TWAB simulation.
+//
+// Simulate replays a historical report stream through a fresh TWAB per
+// candidate configuration, in report-timestamp order with each report
+// taken as received at its timestamp, and reports what each candidate
+// would have promoted, when, and how long consensus took.  Given known-bad
+// and known-good indicators it also scores each candidate's precision and
+// recall.  Only the TWAB gate is simulated: allowlists, quotas, skew and
+// expiry are not, and since exported reports carry no network every
+// report counts as one unknown network.
+//
+// It runs standalone as aegis-sim, or as a subcommand of the aggregator
+// binary:
+//
+//	aegis-sim -reports FILE [-reports FILE...] \
+//	    [-candidate CONFIG...] [-known-bad FILE] [-known-good FILE] [-json]
+//	swarm-aggregator simulate -reports FILE ...
+//
+// Report files hold IOCReports as JSON lines or one JSON array.  Each
+// candidate is an aggregator config file whose twab section is replayed,
+// named by its file name; with none, the defaults are.  Ground-truth files
+// list one indicator key per line, as the filter holds them, with blank
+// lines and # comments ignored.  The text output lists each candidate's
+// promoted keys one per line after its summary, so two runs diff cleanly.
+package swarm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SimulationCandidate is a named TWAB configuration to replay under.
+type SimulationCandidate struct {
+	Name string
+	TWAB TWABConfig
+}
+
+// GroundTruth labels indicator keys as known bad or known good.
+type GroundTruth struct {
+	Bad  map[string]bool
+	Good map[string]bool
+}
+
+// Ground-truth labels of a SimulatedPromotion.
+const (
+	labelBad  = "bad"
+	labelGood = "good"
+)
+
+// SimulatedPromotion is one indicator a candidate would have promoted.
+type SimulatedPromotion struct {
+	Address         string    `json:"address"`
+	ChainID         int       `json:"chain_id"`
+	PromotedAt      time.Time `json:"promoted_at"`
+	TimeToConsensus Duration  `json:"time_to_consensus"` // since its first report
+	Reports         int       `json:"reports"`
+	Label           string    `json:"label,omitempty"`
+}
+
+// ConsensusTimes summarizes the time-to-consensus distribution.
+type ConsensusTimes struct {
+	P50 Duration `json:"p50"`
+	P90 Duration `json:"p90"`
+	P99 Duration `json:"p99"`
+	Max Duration `json:"max"`
+}
+
+// SimulationResult is the outcome of replaying under one candidate.
+type SimulationResult struct {
+	Candidate       string               `json:"candidate"`
+	Reports         int                  `json:"reports"`
+	Invalid         int                  `json:"invalid"`    // reports skipped
+	Indicators      int                  `json:"indicators"` // distinct keys reported
+	Promotions      int                  `json:"promotions"`
+	TimeToConsensus ConsensusTimes       `json:"time_to_consensus"`
+	Promoted        []SimulatedPromotion `json:"promoted"` // sorted by address
+
+	// Set only with ground truth.  False negatives are known-bad keys
+	// reported but not promoted; promotions of unlabeled keys count
+	// toward neither precision nor recall.
+	TruePositives  int      `json:"true_positives,omitempty"`
+	FalsePositives int      `json:"false_positives,omitempty"`
+	FalseNegatives int      `json:"false_negatives,omitempty"`
+	Precision      *float64 `json:"precision,omitempty"`
+	Recall         *float64 `json:"recall,omitempty"`
+}
+
+// prepareReplay normalizes reports to their indicator keys, receives
+// each at its timestamp, and orders them by it.  It returns the reports
+// skipped as invalid.
+func prepareReplay(reports []IOCReport) ([]IOCReport, int) {
+	out := make([]IOCReport, 0, len(reports))
+	invalid := 0
+	for _, report := range reports {
+		if report.Timestamp.IsZero() || checkReportFields(report) != nil {
+			invalid++
+			continue
+		}
+		key, err := IndicatorKey(report.IndicatorType, report.ChainID, report.Address)
+		if err != nil {
+			invalid++
+			continue
+		}
+		report.Address = key
+		report.ReceivedAt = report.Timestamp
+		out = append(out, report)
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Timestamp.Before(out[j].Timestamp) })
+	return out, invalid
+}
+
+// Simulate replays reports under each candidate.  truth may be nil.
+func Simulate(reports []IOCReport, candidates []SimulationCandidate, truth *GroundTruth) []SimulationResult {
+	replay, invalid := prepareReplay(reports)
+	results := make([]SimulationResult, 0, len(candidates))
+	for _, c := range candidates {
+		res := simulateOne(replay, c, truth)
+		res.Invalid = invalid
+		results = append(results, res)
+	}
+	return results
+}
+
+func simulateOne(replay []IOCReport, c SimulationCandidate, truth *GroundTruth) SimulationResult {
+	res := SimulationResult{Candidate: c.Name, Reports: len(replay), Promoted: []SimulatedPromotion{}}
+	twab := NewTWAB(c.TWAB)
+	seen := make(map[string]bool)
+	promoted := make(map[string]bool)
+	for _, report := range replay {
+		key := report.Address
+		seen[key] = true
+		if promoted[key] {
+			continue
+		}
+		twab.Record(key, report)
+		if !twab.MeetsThreshold(key, c.TWAB) {
+			continue
+		}
+		promoted[key] = true
+		summary, _ := twab.Summary(key)
+		res.Promoted = append(res.Promoted, SimulatedPromotion{
+			Address:         key,
+			ChainID:         report.ChainID,
+			PromotedAt:      report.Timestamp,
+			TimeToConsensus: Duration(report.Timestamp.Sub(summary.FirstSeen)),
+			Reports:         summary.ReportCount,
+		})
+	}
+	res.Indicators = len(seen)
+	res.Promotions = len(res.Promoted)
+	res.TimeToConsensus = consensusTimes(res.Promoted)
+	sort.Slice(res.Promoted, func(i, j int) bool { return res.Promoted[i].Address < res.Promoted[j].Address })
+
+	if truth == nil {
+		return res
+	}
+	for i := range res.Promoted {
+		p := &res.Promoted[i]
+		switch {
+		case truth.Bad[p.Address]:
+			p.Label = labelBad
+			res.TruePositives++
+		case truth.Good[p.Address]:
+			p.Label = labelGood
+			res.FalsePositives++
+		}
+	}
+	for key := range truth.Bad {
+		if seen[key] && !promoted[key] {
+			res.FalseNegatives++
+		}
+	}
+	if n := res.TruePositives + res.FalsePositives; n > 0 {
+		precision := float64(res.TruePositives) / float64(n)
+		res.Precision = &precision
+	}
+	if n := res.TruePositives + res.FalseNegatives; n > 0 {
+		recall := float64(res.TruePositives) / float64(n)
+		res.Recall = &recall
+	}
+	return res
+}
+
+// consensusTimes takes nearest-rank percentiles of the promotions' times
+// to consensus.
+func consensusTimes(promoted []SimulatedPromotion) ConsensusTimes {
+	if len(promoted) == 0 {
+		return ConsensusTimes{}
+	}
+	times := make([]Duration, len(promoted))
+	for i, p := range promoted {
+		times[i] = p.TimeToConsensus
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	rank := func(q float64) Duration {
+		i := int(math.Ceil(q*float64(len(times)))) - 1
+		return times[max(0, i)]
+	}
+	return ConsensusTimes{P50: rank(0.5), P90: rank(0.9), P99: rank(0.99), Max: times[len(times)-1]}
+}
+
+// readSimulationReports reads IOCReports as JSON lines or a JSON array.
+func readSimulationReports(r io.Reader) ([]IOCReport, error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(br)
+	if first == '[' {
+		var reports []IOCReport
+		err := dec.Decode(&reports)
+		return reports, err
+	}
+	var reports []IOCReport
+	for {
+		var report IOCReport
+		err := dec.Decode(&report)
+		if err == io.EOF {
+			return reports, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("report %d: %w", len(reports)+1, err)
+		}
+		reports = append(reports, report)
+	}
+}
+
+// peekNonSpace returns the first non-space byte without consuming it.
+func peekNonSpace(br *bufio.Reader) (byte, error) {
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(b)) {
+			return b, br.UnreadByte()
+		}
+	}
+}
+
+// readKeySet reads a ground-truth file of indicator keys.
+func readKeySet(path string) (map[string]bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]bool)
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, err := IndicatorKey(indicatorTypeOf(line), 0, line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n+1, err)
+		}
+		keys[key] = true
+	}
+	return keys, nil
+}
+
+// loadCandidate reads the twab section of an aggregator config file.
+func loadCandidate(path string) (SimulationCandidate, error) {
+	cfg := DefaultConfig()
+	if err := cfg.loadFile(path); err != nil {
+		return SimulationCandidate{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return SimulationCandidate{}, fmt.Errorf("%s: %w", path, err)
+	}
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return SimulationCandidate{Name: name, TWAB: cfg.TWAB}, nil
+}
+
+// fileList is a repeatable flag of file paths.
+type fileList []string
+
+func (l *fileList) String() string { return strings.Join(*l, ",") }
+
+func (l *fileList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// RunSimulate is the simulate subcommand, returning the exit status.
+func RunSimulate(args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var reportFiles, candidateFiles fileList
+	fs.Var(&reportFiles, "reports", "report stream, JSON lines or a JSON array (repeatable)")
+	fs.Var(&candidateFiles, "candidate", "aggregator config file whose twab section to replay (repeatable; default the built-in defaults)")
+	badFile := fs.String("known-bad", "", "file of known-bad indicator keys, one per line")
+	goodFile := fs.String("known-good", "", "file of known-good indicator keys, one per line")
+	asJSON := fs.Bool("json", false, "write the results as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := simulateFiles(reportFiles, candidateFiles, *badFile, *goodFile, *asJSON, stdout, stderr); err != nil {
+		fmt.Fprintf(stderr, "simulate: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func simulateFiles(reportFiles, candidateFiles []string, badFile, goodFile string, asJSON bool, stdout, stderr io.Writer) error {
+	if len(reportFiles) == 0 {
+		return errors.New("at least one -reports file is required")
+	}
+	var reports []IOCReport
+	for _, path := range reportFiles {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		more, err := readSimulationReports(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		reports = append(reports, more...)
+	}
+	candidates := []SimulationCandidate{{Name: "default", TWAB: DefaultConfig().TWAB}}
+	if len(candidateFiles) > 0 {
+		candidates = candidates[:0]
+		for _, path := range candidateFiles {
+			c, err := loadCandidate(path)
+			if err != nil {
+				return err
+			}
+			candidates = append(candidates, c)
+		}
+	}
+
+	var truth *GroundTruth
+	if badFile != "" || goodFile != "" {
+		truth = &GroundTruth{Bad: map[string]bool{}, Good: map[string]bool{}}
+		var err error
+		if badFile != "" {
+			if truth.Bad, err = readKeySet(badFile); err != nil {
+				return err
+			}
+		}
+		if goodFile != "" {
+			if truth.Good, err = readKeySet(goodFile); err != nil {
+				return err
+			}
+		}
+	}
+
+	results := Simulate(reports, candidates, truth)
+	if len(results) > 0 && results[0].Invalid > 0 {
+		fmt.Fprintf(stderr, "simulate: skipped %d invalid reports\n", results[0].Invalid)
+	}
+	if asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(map[string]interface{}{"results": results})
+	}
+	writeSimulationText(stdout, results)
+	return nil
+}
+
+// writeSimulationText writes a summary and the promoted keys of each
+// result.
+func writeSimulationText(w io.Writer, results []SimulationResult) {
+	for _, res := range results {
+		fmt.Fprintf(w, "# %s: %d reports, %d indicators, %d promoted; time to consensus p50 %s p90 %s p99 %s max %s\n",
+			res.Candidate, res.Reports, res.Indicators, res.Promotions,
+			time.Duration(res.TimeToConsensus.P50), time.Duration(res.TimeToConsensus.P90), time.Duration(res.TimeToConsensus.P99), time.Duration(res.TimeToConsensus.Max))
+		if res.Precision != nil || res.Recall != nil {
+			fmt.Fprintf(w, "# %s: %d true positives, %d false positives, %d false negatives; precision %s recall %s\n",
+				res.Candidate, res.TruePositives, res.FalsePositives, res.FalseNegatives, ratio(res.Precision), ratio(res.Recall))
+		}
+		for _, p := range res.Promoted {
+			fmt.Fprintln(w, p.Address)
+		}
+	}
+}
+
+func ratio(v *float64) string {
+	if v == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.3f", *v)
+}
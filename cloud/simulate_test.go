@@ -0,0 +1,100 @@
+package swarm
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// syntheticStream reports bad from three sources in twenty minutes and
+// then again after two hours, quick twice in a minute, and good twice,
+// out of order.
+func syntheticStream(start time.Time) []IOCReport {
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	r := func(addr, source string, t time.Time) IOCReport {
+		return IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: t, SourceID: source}
+	}
+	bad, quick, good := evmAddress("sim-bad"), evmAddress("sim-quick"), evmAddress("sim-good")
+	return []IOCReport{
+		r(bad, "agent-C", at(2*time.Hour)),
+		r(bad, "agent-A", at(0)),
+		r(quick, "agent-A", at(time.Minute)),
+		r(bad, "agent-B", at(10*time.Minute)),
+		r(bad, "agent-C", at(20*time.Minute)),
+		r(quick, "agent-B", at(2*time.Minute)),
+		r(good, "agent-A", at(3*time.Hour)),
+		r(good, "agent-B", at(4*time.Hour)),
+		{Address: "0xNotHex", ChainID: 1, Confidence: 0.9, Timestamp: at(0), SourceID: "agent-A"},
+	}
+}
+
+func TestSimulateComparesCandidates(t *testing.T) {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	truth := &GroundTruth{
+		Bad:  map[string]bool{evmAddress("sim-bad"): true, evmAddress("sim-quick"): true},
+		Good: map[string]bool{evmAddress("sim-good"): true},
+	}
+	results := Simulate(syntheticStream(start), []SimulationCandidate{
+		{Name: "lenient", TWAB: TWABConfig{MinReportCount: 2, MinDistinctSources: 2}},
+		{Name: "strict", TWAB: TWABConfig{MinReportCount: 3, MinDistinctSources: 3, MinTimeSpanSeconds: 3600}},
+	}, truth)
+
+	lenient, strict := results[0], results[1]
+	if lenient.Reports != 8 || lenient.Invalid != 1 || lenient.Indicators != 3 || lenient.Promotions != 3 {
+		t.Errorf("Unexpected lenient totals %+v", lenient)
+	}
+	if lenient.TruePositives != 2 || lenient.FalsePositives != 1 || *lenient.Precision != 2.0/3 || *lenient.Recall != 1 {
+		t.Errorf("Expected lenient precision 2/3 and recall 1, got %+v", lenient)
+	}
+	if len(strict.Promoted) != 1 || strict.Promoted[0].Address != evmAddress("sim-bad") || strict.Promoted[0].Label != labelBad {
+		t.Fatalf("Expected strict to promote only the slow bad address, got %+v", strict.Promoted)
+	}
+	if p := strict.Promoted[0]; !p.PromotedAt.Equal(start.Add(2*time.Hour)) || p.TimeToConsensus != Duration(2*time.Hour) || p.Reports != 4 {
+		t.Errorf("Expected promotion at the fourth report's timestamp, got %+v", p)
+	}
+	if *strict.Precision != 1 || *strict.Recall != 0.5 || strict.FalseNegatives != 1 {
+		t.Errorf("Expected strict precision 1 and recall 1/2, got %+v", strict)
+	}
+	if got := lenient.TimeToConsensus; got.P50 != Duration(10*time.Minute) || got.Max != Duration(time.Hour) {
+		t.Errorf("Unexpected lenient time to consensus %+v", got)
+	}
+}
+
+func TestSimulateCommand(t *testing.T) {
+	dir := t.TempDir()
+	var stream bytes.Buffer
+	enc := json.NewEncoder(&stream)
+	for _, r := range syntheticStream(time.Now()) {
+		enc.Encode(r)
+	}
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	reports := write("reports.jsonl", stream.String())
+	strict := write("strict.yaml", "twab:\n  min_report_count: 3\n  min_distinct_sources: 3\n  min_time_span_seconds: 3600\n")
+	bad := write("bad.txt", "# known drainers\n"+evmAddress("sim-bad")+"\n\n"+evmAddress("sim-quick")+"\n")
+
+	var stdout, stderr bytes.Buffer
+	if code := RunSimulate([]string{"-reports", reports, "-candidate", strict, "-known-bad", bad}, &stdout, &stderr); code != 0 {
+		t.Fatalf("Expected success, got %d: %s", code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "# strict: 8 reports, 3 indicators, 1 promoted") || !strings.Contains(lines[1], "precision 1.000 recall 0.500") || lines[2] != evmAddress("sim-bad") {
+		t.Errorf("Unexpected output:\n%s", stdout.String())
+	}
+	if !strings.Contains(stderr.String(), "skipped 1 invalid reports") {
+		t.Errorf("Expected the invalid report noted, got %q", stderr.String())
+	}
+
+	if code := RunSimulate([]string{"-candidate", strict}, &stdout, &stderr); code != 1 {
+		t.Errorf("Expected failure without reports, got %d", code)
+	}
+}
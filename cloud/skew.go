@@ -0,0 +1,51 @@
+// Package swarm — Report timestamp skew policy.
+//
+// A report's timestamp is claimed by its sender.  Reports from the network
+// claiming a time more than ingest.max_future_skew ahead of the server
+// clock are rejected with a *TimestampSkewError; those ahead by less are
+// clamped to the receive time.  Every report also carries the time the
+// server received it, and the TWAB time-span gate takes the smaller of the
+// claimed span and the received span covered by MinDistinctSources
+// sources (see TWABEntry.sourceSpan), so neither fabricated timestamps
+// nor colluding sources reporting back to back satisfy MinTimeSpanSeconds.
+package swarm
+
+import (
+	"fmt"
+	"time"
+)
+
+// TimestampSkewError reports a timestamp too far ahead of the server clock.
+type TimestampSkewError struct {
+	Timestamp  time.Time
+	ReceivedAt time.Time
+	MaxSkew    time.Duration
+}
+
+func (e *TimestampSkewError) Error() string {
+	return fmt.Sprintf("timestamp %s is %s ahead of server time, more than the %s allowed",
+		e.Timestamp.UTC().Format(time.RFC3339), e.Timestamp.Sub(e.ReceivedAt).Round(time.Second), e.MaxSkew)
+}
+
+// checkTimestamp rejects a report dated too far in the future and stamps
+// the rest with their receive time.
+func (s *SwarmAggregator) checkTimestamp(report *IOCReport, now time.Time) error {
+	max := time.Duration(s.config.Ingest.MaxFutureSkew)
+	if max > 0 && report.Timestamp.Sub(now) > max {
+		s.metrics.skewRejections.Inc()
+		return &TimestampSkewError{Timestamp: report.Timestamp, ReceivedAt: now, MaxSkew: max}
+	}
+	stampReport(report, now)
+	return nil
+}
+
+// stampReport records when a report was received, unless it already was,
+// and clamps a missing or future timestamp to that time.
+func stampReport(report *IOCReport, now time.Time) {
+	if report.ReceivedAt.IsZero() {
+		report.ReceivedAt = now
+	}
+	if report.Timestamp.IsZero() || report.Timestamp.After(report.ReceivedAt) {
+		report.Timestamp = report.ReceivedAt
+	}
+}
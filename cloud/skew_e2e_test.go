@@ -0,0 +1,55 @@
+package swarm_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+// explain returns GET /explain for address.
+func explain(t *testing.T, h *aegistest.Aggregator, address string) swarm.ThresholdExplanation {
+	t.Helper()
+	var ex swarm.ThresholdExplanation
+	getJSON(t, h, "/explain?address="+address, &ex)
+	return ex
+}
+
+func TestTimeSpanMustBeCoveredBySources(t *testing.T) {
+	h := aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.TWAB = swarm.TWABConfig{MinReportCount: 2, MinTimeSpanSeconds: 3600, MinDistinctSources: 2}
+	}})
+	late, organic := aegistest.Address("late-joiner"), aegistest.Address("organic")
+
+	// One source reports across the hour and a second joins at its end:
+	// the address has an hour of reports but two sources cover none of it.
+	h.Report(swarm.IOCReport{Address: late, SourceID: "agent-A"})
+	h.Report(swarm.IOCReport{Address: organic, SourceID: "agent-A"})
+	h.Advance(10 * time.Minute)
+	h.Report(swarm.IOCReport{Address: organic, SourceID: "agent-B"})
+	h.Advance(50 * time.Minute)
+	h.Report(swarm.IOCReport{Address: late, SourceID: "agent-A"})
+	h.Report(swarm.IOCReport{Address: late, SourceID: "agent-B"})
+	if check(t, h, late).Flagged {
+		t.Fatal("Expected a source joining at the end of the window held back")
+	}
+	ex := explain(t, h, late)
+	if ex.ClaimedSpan != 3600 || ex.ReceivedSpan != 3600 || ex.Gates[1].Observed != 0 || ex.Gates[1].Passed {
+		t.Errorf("Expected an hour claimed and received but none covered, got %+v", ex)
+	}
+
+	// Reports spread over the hour by both sources promote.
+	h.Advance(10 * time.Minute)
+	h.Report(swarm.IOCReport{Address: organic, SourceID: "agent-A"})
+	if !check(t, h, organic).Flagged {
+		t.Errorf("Expected organically spread reports promoted, got %+v", explain(t, h, organic))
+	}
+
+	// So does the late joiner, once it has itself reported for the hour.
+	h.Advance(50 * time.Minute)
+	h.Report(swarm.IOCReport{Address: late, SourceID: "agent-B"})
+	if !check(t, h, late).Flagged {
+		t.Errorf("Expected the address promoted an hour after the second source joined, got %+v", explain(t, h, late))
+	}
+}
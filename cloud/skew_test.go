@@ -0,0 +1,130 @@
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func postTimestamped(t *testing.T, routes http.Handler, addr, source string, ts time.Time) *http.Response {
+	t.Helper()
+	body := fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":%q,"timestamp":%q}`,
+		addr, source, ts.UTC().Format(time.RFC3339))
+	rec := httptest.NewRecorder()
+	routes.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body)))
+	return rec.Result()
+}
+
+func TestFabricatedTimestampsCannotSatisfyTimeSpan(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinTimeSpanSeconds: 3600, MinDistinctSources: 2}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	routes := agg.Routes()
+	addr := evmAddress("fabricated")
+	now := time.Now()
+
+	// Backdating one report and dating the other inside the allowed skew
+	// claims a span of over an hour; both arrive within a second.
+	for i, ts := range []time.Time{now.Add(-2 * time.Hour), now.Add(time.Minute)} {
+		resp := postTimestamped(t, routes, addr, fmt.Sprintf("agent-%d", i), ts)
+		var res ingestResult
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Report %d: expected 200, got %d (%v)", i, resp.StatusCode, err)
+		}
+		if res.AddedToFilter {
+			t.Fatalf("Report %d: fabricated timestamps satisfied the time-span gate", i)
+		}
+	}
+	if agg.BloomFilterLen() != 0 {
+		t.Errorf("Expected an empty filter, got %d entries", agg.BloomFilterLen())
+	}
+	for _, gate := range agg.twab.Explain(addr, agg.config.TWAB).Gates {
+		if gate.Gate == gateTimeSpan && (gate.Passed || gate.Observed > 60) {
+			t.Errorf("Expected the time span to follow receive times, got %+v", gate)
+		}
+	}
+}
+
+func TestFarFutureTimestampRejected(t *testing.T) {
+	agg := NewSwarmAggregator()
+	addr := evmAddress("future")
+	resp := postTimestamped(t, agg.Routes(), addr, "agent-A", time.Date(2099, 1, 1, 0, 0, 0, 0, time.UTC))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d", resp.StatusCode)
+	}
+	if e := decodeErrorResponse(t, resp); e.Code != CodeTimestampSkew {
+		t.Errorf("Expected %s, got %+v", CodeTimestampSkew, e)
+	}
+	if _, ok := agg.twab.Summary(addr); ok {
+		t.Error("A rejected report must not be recorded")
+	}
+	if got := testutil.ToFloat64(agg.metrics.skewRejections); got != 1 {
+		t.Errorf("Expected skew_rejections_total 1, got %v", got)
+	}
+}
+
+func TestSlightlyFutureTimestampClamped(t *testing.T) {
+	agg := NewSwarmAggregator()
+	addr := evmAddress("clamped")
+	resp := postTimestamped(t, agg.Routes(), addr, "agent-A", time.Now().Add(2*time.Minute))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	summary, ok := agg.twab.Summary(addr)
+	if !ok {
+		t.Fatal("Expected the report to be recorded")
+	}
+	if summary.LastSeen.After(time.Now()) {
+		t.Errorf("Expected LastSeen clamped to server time, got %v", summary.LastSeen)
+	}
+}
+
+func TestZeroSkewOnlyClamps(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Ingest.MaxFutureSkew = 0
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	resp := postTimestamped(t, agg.Routes(), evmAddress("zero"), "agent-A", time.Now().Add(24*time.Hour))
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected a zero max skew to accept and clamp, got %d", resp.StatusCode)
+	}
+}
+
+func TestReceiveTimesSurviveExport(t *testing.T) {
+	tw := NewTWAB(TWABConfig{MinReportCount: 2, MinTimeSpanSeconds: 3600, MinDistinctSources: 2})
+	addr := evmAddress("received")
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, source := range []string{"agent-A", "agent-B", "agent-A"} {
+		at := base.Add(time.Duration(i) * 40 * time.Minute)
+		tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.9, SourceID: source, Timestamp: at.Add(-6 * time.Hour), ReceivedAt: at})
+	}
+	shard := tw.shardFor(addr)
+	data, err := json.Marshal(stateOf(addr, shard.entries[addr]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st TWABState
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewTWAB(tw.config)
+	restored.shardFor(addr).entries[addr] = st.entry(defaultRetainReports)
+
+	if got := restored.shardFor(addr).entries[addr].Sources["agent-B"].FirstReceived; !got.Equal(base.Add(40 * time.Minute)) {
+		t.Errorf("Expected agent-B's first receipt kept, got %v", got)
+	}
+	d, _ := restored.Detail(addr)
+	for i, r := range d.RecentReports {
+		if want := base.Add(time.Duration(i) * 40 * time.Minute); !r.ReceivedAt.Equal(want) || r.Timestamp.Equal(r.ReceivedAt) {
+			t.Errorf("Report %d: expected claimed %v and received %v kept apart, got %+v", i, want.Add(-6*time.Hour), want, r)
+		}
+	}
+	if a, b := tw.Explain(addr, tw.config), restored.Explain(addr, tw.config); a.Gates[1] != b.Gates[1] || a.ReceivedSpan != b.ReceivedSpan {
+		t.Errorf("Expected the time-span gate unchanged by export, got %+v and %+v", a.Gates[1], b.Gates[1])
+	}
+}
@@ -0,0 +1,278 @@
+// Package swarm — Promotion latency and SLO reporting.
+//
+// Every consensus promotion of the global swarm is timed from the server
+// receiving its first report, through the report that crossed the
+// threshold, to the first filter push that carried it to subscribers.
+// Report timestamps are the sources' claims and play no part.  The first
+// report to push latency is observed, by chain and category, in
+// aegis_promotion_latency_seconds, and each completed timeline is kept for
+// the stats retention (seven days), at most slo.max_samples of them.
+//
+// GET /stats/slo?window=7d reports over the promotions pushed in the
+// window: their count, the p50, p95 and p99 latencies, and the fraction
+// pushed within slo.target, overall and for each chain and category.  The
+// window is a duration, or whole days like 7d, of up to seven days; it
+// defaults to 24h.  The timelines are saved with the state (see
+// snapshot.go), so the report survives a restart with
+// persistence.state_file set.
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PromotionLatency is the timeline of one consensus promotion, in server
+// receive times.
+type PromotionLatency struct {
+	Address       string    `json:"address"`
+	ChainID       int       `json:"chain_id"`
+	Category      string    `json:"category,omitempty"`
+	FirstReportAt time.Time `json:"first_report_at"`
+	ThresholdAt   time.Time `json:"threshold_at"`
+	PushedAt      time.Time `json:"pushed_at"`
+}
+
+// Latency is the time from first report to push.
+func (p PromotionLatency) Latency() time.Duration {
+	return max(p.PushedAt.Sub(p.FirstReportAt), 0)
+}
+
+// pendingLatency is a promotion waiting for a push of its version.
+type pendingLatency struct {
+	PromotionLatency
+	version uint64
+}
+
+// latencyTracker holds the promotion timelines.
+type latencyTracker struct {
+	mu      sync.Mutex
+	pending []pendingLatency
+	done    []PromotionLatency // in push order
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{}
+}
+
+// promoted starts the timeline of a promotion that entered the filter at
+// version.
+func (t *latencyTracker) promoted(p PromotionLatency, version uint64, limit int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending = append(t.pending, pendingLatency{PromotionLatency: p, version: version})
+	if over := len(t.pending) - limit; over > 0 {
+		t.pending = t.pending[over:]
+	}
+}
+
+// pushed completes the timelines a push of version at now carried,
+// dropping those older than the retention, and returns them.
+func (t *latencyTracker) pushed(version uint64, now time.Time, limit int) []PromotionLatency {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var completed []PromotionLatency
+	waiting := t.pending[:0]
+	for _, p := range t.pending {
+		if p.version > version {
+			waiting = append(waiting, p)
+			continue
+		}
+		p.PushedAt = now
+		completed = append(completed, p.PromotionLatency)
+	}
+	t.pending = waiting
+	t.done = append(t.done, completed...)
+
+	cutoff := now.Add(-statsRetention)
+	drop := max(len(t.done)-limit, 0)
+	for drop < len(t.done) && t.done[drop].PushedAt.Before(cutoff) {
+		drop++
+	}
+	t.done = t.done[drop:]
+	return completed
+}
+
+// list copies the completed timelines.
+func (t *latencyTracker) list() []PromotionLatency {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]PromotionLatency(nil), t.done...)
+}
+
+// restore replaces the timelines with imported ones.
+func (t *latencyTracker) restore(done []PromotionLatency) {
+	done = append([]PromotionLatency(nil), done...)
+	sort.SliceStable(done, func(i, j int) bool { return done[i].PushedAt.Before(done[j].PushedAt) })
+	t.mu.Lock()
+	t.pending, t.done = nil, done
+	t.mu.Unlock()
+}
+
+// between returns the timelines pushed in (from, to].
+func (t *latencyTracker) between(from, to time.Time) []PromotionLatency {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []PromotionLatency
+	for _, p := range t.done {
+		if p.PushedAt.After(from) && !p.PushedAt.After(to) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// recordPromotionLatencyLocked starts timing a fresh consensus promotion of
+// report that entered the filter at version.  Its first report's receive
+// time comes from the TWAB; s.mu is held so no push of version can run
+// before the timeline is waiting for it.
+func (s *SwarmAggregator) recordPromotionLatencyLocked(report IOCReport, now time.Time, version uint64) {
+	crossed := report.ReceivedAt
+	if crossed.IsZero() {
+		crossed = now
+	}
+	first := crossed
+	if received, ok := s.twab.FirstReceived(report.Address); ok && !received.IsZero() {
+		first = received
+	}
+	s.latency.promoted(PromotionLatency{
+		Address:       report.Address,
+		ChainID:       report.ChainID,
+		Category:      report.Category,
+		FirstReportAt: first,
+		ThresholdAt:   crossed,
+	}, version, s.current().SLO.MaxSamples)
+}
+
+// recordPushLatency completes the timelines a push of the global filter
+// at version carried.
+func (s *SwarmAggregator) recordPushLatency(version uint64) {
+	for _, p := range s.latency.pushed(version, s.clock.Now(), s.current().SLO.MaxSamples) {
+		s.metrics.promotionLatency.WithLabelValues(strconv.Itoa(p.ChainID), p.Category).Observe(p.Latency().Seconds())
+	}
+}
+
+// LatencyStats summarizes the latencies of a set of promotions.  The
+// percentiles and WithinTarget are omitted when there are none.
+type LatencyStats struct {
+	Promotions   int      `json:"promotions"`
+	P50Seconds   *float64 `json:"p50_seconds,omitempty"`
+	P95Seconds   *float64 `json:"p95_seconds,omitempty"`
+	P99Seconds   *float64 `json:"p99_seconds,omitempty"`
+	WithinTarget *float64 `json:"within_target,omitempty"` // fraction pushed within the target
+}
+
+// LatencyBucket is the latency of one chain and category.
+type LatencyBucket struct {
+	ChainID   int    `json:"chain_id"`
+	ChainName string `json:"chain_name,omitempty"`
+	Category  string `json:"category"`
+	LatencyStats
+}
+
+// SLOReport is the body of GET /stats/slo.
+type SLOReport struct {
+	Window        string    `json:"window"`
+	From          time.Time `json:"from"`
+	To            time.Time `json:"to"`
+	TargetSeconds float64   `json:"target_seconds"`
+	LatencyStats
+	Buckets []LatencyBucket `json:"buckets"` // most promotions first
+}
+
+// latencyStats summarizes latencies against target.
+func latencyStats(latencies []time.Duration, target time.Duration) LatencyStats {
+	st := LatencyStats{Promotions: len(latencies)}
+	if len(latencies) == 0 {
+		return st
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	within := sort.Search(len(latencies), func(i int) bool { return latencies[i] > target })
+	percentile := func(q float64) *float64 {
+		rank := int(math.Ceil(q*float64(len(latencies)))) - 1 // nearest rank
+		v := latencies[max(rank, 0)].Seconds()
+		return &v
+	}
+	frac := float64(within) / float64(len(latencies))
+	st.P50Seconds, st.P95Seconds, st.P99Seconds, st.WithinTarget = percentile(0.50), percentile(0.95), percentile(0.99), &frac
+	return st
+}
+
+// SLO reports the latencies of the promotions pushed in the window ending
+// at now.
+func (s *SwarmAggregator) SLO(window time.Duration, now time.Time) SLOReport {
+	cfg := s.current()
+	target := time.Duration(cfg.SLO.Target)
+	out := SLOReport{Window: window.String(), From: now.Add(-window), To: now, TargetSeconds: target.Seconds()}
+
+	type key struct {
+		chain    int
+		category string
+	}
+	var all []time.Duration
+	byKey := make(map[key][]time.Duration)
+	for _, p := range s.latency.between(out.From, now) {
+		k := key{p.ChainID, p.Category}
+		all = append(all, p.Latency())
+		byKey[k] = append(byKey[k], p.Latency())
+	}
+	out.LatencyStats = latencyStats(all, target)
+	out.Buckets = make([]LatencyBucket, 0, len(byKey))
+	for k, latencies := range byKey {
+		out.Buckets = append(out.Buckets, LatencyBucket{
+			ChainID:      k.chain,
+			ChainName:    cfg.displayChainName(k.chain),
+			Category:     k.category,
+			LatencyStats: latencyStats(latencies, target),
+		})
+	}
+	sort.Slice(out.Buckets, func(i, j int) bool {
+		a, b := out.Buckets[i], out.Buckets[j]
+		if a.Promotions != b.Promotions {
+			return a.Promotions > b.Promotions
+		}
+		if a.ChainID != b.ChainID {
+			return a.ChainID < b.ChainID
+		}
+		return a.Category < b.Category
+	})
+	return out
+}
+
+// parseSLOWindow reads a window as a duration or a whole number of days.
+func parseSLOWindow(v string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %q", v)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
+
+// handleSLO is the HTTP handler for GET /stats/slo[?window=].
+func (s *SwarmAggregator) handleSLO(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	window := defaultStatsWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := parseSLOWindow(v)
+		if err != nil || d <= 0 || d > statsRetention {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "window must be a duration or a number of days, up to 7d")
+			return
+		}
+		window = d
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.SLO(window, s.clock.Now()))
+}
@@ -0,0 +1,116 @@
+package swarm_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+// promoteAfter reports an address from two sources and again from the
+// first, wait later on the test clock.  The first report claims to be an
+// hour older than it is.
+func promoteAfter(t *testing.T, agg *aegistest.Aggregator, label string, chainID int, category string, wait time.Duration) {
+	t.Helper()
+	addr := aegistest.Address(label)
+	agg.Report(swarm.IOCReport{Address: addr, ChainID: chainID, Category: category, SourceID: "agent-A", Timestamp: agg.Clock.Now().Add(-time.Hour)})
+	agg.Report(swarm.IOCReport{Address: addr, ChainID: chainID, Category: category, SourceID: "agent-B"})
+	agg.Advance(wait)
+	agg.Report(swarm.IOCReport{Address: addr, ChainID: chainID, Category: category, SourceID: "agent-A"})
+	if !check(t, agg, addr).Flagged {
+		t.Fatalf("Expected %s promoted", label)
+	}
+}
+
+func getSLO(t *testing.T, agg *aegistest.Aggregator, query string) swarm.SLOReport {
+	t.Helper()
+	status, data := agg.Do(http.MethodGet, "/stats/slo"+query, "")
+	var report swarm.SLOReport
+	if err := json.Unmarshal(data, &report); status != http.StatusOK || err != nil {
+		t.Fatalf("GET /stats/slo%s: expected 200, got %d: %s", query, status, data)
+	}
+	return report
+}
+
+func TestSLOReportsLatencyFromServerReceiveTimes(t *testing.T) {
+	agg := aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.Ingest.Synchronous = true
+		cfg.SLO.Target = swarm.Duration(10 * time.Minute)
+	}})
+	promoteAfter(t, agg, "fast-drainer", 1, "drainer", 2*time.Minute)
+	promoteAfter(t, agg, "drainer", 1, "drainer", 5*time.Minute)
+	promoteAfter(t, agg, "slow-phish", 137, "phishing", 20*time.Minute)
+
+	report := getSLO(t, agg, "?window=7d")
+	if report.Window != "168h0m0s" || report.TargetSeconds != 600 || report.Promotions != 3 {
+		t.Fatalf("Expected three promotions in a week against a 10m target, got %+v", report)
+	}
+	if *report.P50Seconds != 300 || *report.P95Seconds != 1200 || *report.P99Seconds != 1200 || *report.WithinTarget != 2.0/3 {
+		t.Errorf("Expected p50 5m, p95 and p99 20m, two thirds within target, got %+v", report.LatencyStats)
+	}
+	if len(report.Buckets) != 2 {
+		t.Fatalf("Expected a bucket per chain and category, got %+v", report.Buckets)
+	}
+	if b := report.Buckets[0]; b.ChainID != 1 || b.Category != "drainer" || b.Promotions != 2 || *b.P50Seconds != 120 || *b.WithinTarget != 1 {
+		t.Errorf("Expected two fast Ethereum drainers first, got %+v", b)
+	}
+	if b := report.Buckets[1]; b.ChainID != 137 || b.ChainName != "Polygon" || b.Category != "phishing" || *b.P50Seconds != 1200 || *b.WithinTarget != 0 {
+		t.Errorf("Expected one slow Polygon phish, got %+v", b)
+	}
+
+	timeline := agg.PromotionLatencies()
+	if p := timeline[len(timeline)-1]; p.ThresholdAt.Sub(p.FirstReportAt) != 20*time.Minute || !p.PushedAt.Equal(p.ThresholdAt) {
+		t.Errorf("Expected the timeline in server receive times, got %+v", p)
+	}
+
+	resp, err := http.Get(agg.MetricsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := `aegis_promotion_latency_seconds_count{category="drainer",chain="1"} 2`; !strings.Contains(string(body), want) {
+		t.Errorf("Expected %s in the metrics", want)
+	}
+
+	if report := getSLO(t, agg, "?window=10m"); report.Promotions != 1 {
+		t.Errorf("Expected only the last promotion in the last ten minutes, got %+v", report)
+	}
+	agg.Advance(8 * 24 * time.Hour)
+	if report := getSLO(t, agg, ""); report.Promotions != 0 || report.P50Seconds != nil || len(report.Buckets) != 0 {
+		t.Errorf("Expected no promotions in the last day, got %+v", report)
+	}
+	if status, _ := agg.Do(http.MethodGet, "/stats/slo?window=8d", ""); status != http.StatusBadRequest {
+		t.Errorf("Expected a window past the retention refused, got %d", status)
+	}
+}
+
+func TestSLOSurvivesRestart(t *testing.T) {
+	src := aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) { cfg.Ingest.Synchronous = true }})
+	promoteAfter(t, src, "saved", 1, "drainer", 3*time.Minute)
+	path := filepath.Join(t.TempDir(), "state.jsonl")
+	if err := src.SaveStateFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := aegistest.StartTestAggregator(t, aegistest.Options{Start: src.Clock.Now()})
+	if _, err := dst.LoadStateFile(context.Background(), path); err != nil {
+		t.Fatal(err)
+	}
+	if report := getSLO(t, dst, ""); report.Promotions != 1 || *report.P50Seconds != 180 {
+		t.Errorf("Expected the saved promotion's latency restored, got %+v", report)
+	}
+
+	cfg := swarm.DefaultConfig()
+	cfg.SLO.Target = 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "slo.target") {
+		t.Errorf("Expected a zero target rejected, got %v", err)
+	}
+}
@@ -0,0 +1,700 @@
+// Package swarm — State export and import.
+//
+// GET /admin/snapshot/export streams the global swarm's state as JSON
+// lines, to seed another environment or inspect offline: the confirmed
+// set, the TWAB aggregate of every tracked indicator, the allowlist,
+// banned sources, removed addresses cooling down, promotion latencies
+// (see slo.go) and source calibration curves (see calibration.go).  The
+// first line is a header,
+//
+//	{"format":"aegis-state","version":2,"exported_at":"...",
+//	 "filter_version":N,"instance_uuid":"...","epoch":N,
+//	 "confirmed":N,"twab":N,"allowlist":N,"bans":N,"cooldowns":N,
+//	 "latencies":N,"calibrations":N}
+//
+// counting the records that follow, one per line, each with a kind:
+//
+//	{"kind":"confirmed","confirmed":{...}}   a ConfirmedEntry
+//	{"kind":"twab","twab":{...}}             a TWABState
+//	{"kind":"allowlist","address":"..."}
+//	{"kind":"ban","ban":{"source_id":"...","quota":{...}}}
+//	{"kind":"cooldown","cooldown":{...}}     a Cooldown (see cooldown.go)
+//	{"kind":"latency","latency":{...}}       a PromotionLatency (see slo.go)
+//	{"kind":"calibration","calibration":{...}} a SourceCalibration
+//
+// and a last line {"kind":"checksum","crc32c":"..."}, the CRC-32C of
+// every byte before it (see checksum.go).  Version 1 streams, from before
+// the checksum, are still read.
+//
+// The state is copied at one point in time, with the confirmed set and
+// every TWAB shard locked together only while it is copied; encoding and
+// sending happen after, so ingest waits for the copy and not the client.
+// TWAB entries keep their retained reports but not where or when the
+// server received them.  Tenant namespaces are not exported.
+//
+// POST /admin/snapshot/import loads such a stream, checking its format
+// version and counts and every key before anything changes.  State that
+// is not empty is only replaced with ?force=1; otherwise the import is
+// refused with 409.  Addresses from persistence.allowlist_file stay
+// allowlisted either way.  The filter is rebuilt from the imported set
+// as one new version and pushed.
+//
+// With persistence.state_file set, the state is written there at
+// shutdown and loaded at startup, along with the instance UUID and epoch
+// the header carries (see epoch.go); an import through the API keeps the
+// importing aggregator's own.  A file that fails to read, its
+// checksum included, is moved aside with a .corrupt suffix and the
+// aggregator starts without it.
+package swarm
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	stateFormat        = "aegis-state"
+	stateFormatVersion = 2
+)
+
+// Record kinds of a state stream.
+const (
+	stateConfirmed   = "confirmed"
+	stateTWAB        = "twab"
+	stateAllowlist   = "allowlist"
+	stateBan         = "ban"
+	stateCooldown    = "cooldown"
+	stateLatency     = "latency"
+	stateCalibration = "calibration"
+	stateChecksum    = "checksum" // the last record
+)
+
+// ErrStateNotEmpty refuses an import over existing state without force.
+var ErrStateNotEmpty = errors.New("aggregator state is not empty; use force=1 to replace it")
+
+// StateHeader is the first line of a state stream.
+type StateHeader struct {
+	Format        string    `json:"format"`
+	Version       int       `json:"version"`
+	ExportedAt    time.Time `json:"exported_at"`
+	FilterVersion uint64    `json:"filter_version"`
+	InstanceUUID  string    `json:"instance_uuid,omitempty"`
+	Epoch         uint64    `json:"epoch,omitempty"`
+	Confirmed     int       `json:"confirmed"`
+	TWAB          int       `json:"twab"`
+	Allowlist     int       `json:"allowlist"`
+	Bans          int       `json:"bans"`
+	Cooldowns     int       `json:"cooldowns,omitempty"`
+	Latencies     int       `json:"latencies,omitempty"`
+	Calibrations  int       `json:"calibrations,omitempty"`
+}
+
+// StateRecord is every line of a state stream after the header.
+type StateRecord struct {
+	Kind        string             `json:"kind"`
+	Confirmed   *ConfirmedEntry    `json:"confirmed,omitempty"`
+	TWAB        *TWABState         `json:"twab,omitempty"`
+	Address     string             `json:"address,omitempty"`
+	Ban         *BanState          `json:"ban,omitempty"`
+	Cooldown    *Cooldown          `json:"cooldown,omitempty"`
+	Latency     *PromotionLatency  `json:"latency,omitempty"`
+	Calibration *SourceCalibration `json:"calibration,omitempty"`
+	CRC32C      string             `json:"crc32c,omitempty"`
+}
+
+// TWABState is the exported form of a TWABEntry.
+type TWABState struct {
+	Address          string             `json:"address"`
+	ChainID          int                `json:"chain_id"`
+	ReportCount      int                `json:"report_count"`
+	ConfidenceSum    float64            `json:"confidence_sum"`
+	Sources          []TWABSourceState  `json:"sources"` // in order of first report
+	Networks         map[string]int     `json:"networks"`
+	FirstSeen        time.Time          `json:"first_seen"`
+	LastSeen         time.Time          `json:"last_seen"`
+	FirstReceived    time.Time          `json:"first_received"`
+	LastReceived     time.Time          `json:"last_received"`
+	EvidencedReports int                `json:"evidenced_reports,omitempty"`
+	CompactedReports int                `json:"compacted_reports,omitempty"`
+	MaxSeverity      int                `json:"max_severity,omitempty"`
+	Tiers            map[SourceTier]int `json:"tiers,omitempty"` // reports per source tier
+	BestTrusted      float64            `json:"best_trusted,omitempty"`
+	Evidence         []Evidence         `json:"evidence,omitempty"`
+	Recent           []IOCReport        `json:"recent,omitempty"`          // oldest first
+	RecentReceived   []time.Time        `json:"recent_received,omitempty"` // when each of Recent was received
+}
+
+// TWABSourceState is one source's reports in a TWABState.
+type TWABSourceState struct {
+	SourceID       string     `json:"source_id"`
+	Reports        int        `json:"reports"`
+	BestConfidence float64    `json:"best_confidence"`
+	Weight         float64    `json:"weight,omitempty"`
+	Tier           SourceTier `json:"tier,omitempty"` // of its latest report; anonymous if unset
+	FirstSeen      time.Time  `json:"first_seen"`
+	LastSeen       time.Time  `json:"last_seen"`
+	FirstReceived  time.Time  `json:"first_received,omitempty"`
+}
+
+// BanState is a banned source with its quota state.
+type BanState struct {
+	SourceID string      `json:"source_id"`
+	Quota    sourceQuota `json:"quota"`
+}
+
+// exportedState is a point-in-time copy of the aggregator's state.
+type exportedState struct {
+	header       StateHeader
+	confirmed    []ConfirmedEntry
+	twab         []TWABState
+	allowlist    []string
+	bans         []BanState
+	cooldowns    []Cooldown
+	latencies    []PromotionLatency
+	calibrations []SourceCalibration
+
+	recorded uint64 // TWAB reports recorded as of the copy (see standby.go)
+}
+
+// stateOf copies an entry.  The caller holds the shard lock.
+func stateOf(address string, e *TWABEntry) TWABState {
+	st := TWABState{
+		Address:          address,
+		ChainID:          e.ChainID,
+		ReportCount:      e.ReportCount,
+		ConfidenceSum:    e.ConfidenceSum,
+		Sources:          make([]TWABSourceState, 0, len(e.sourceOrder)),
+		Networks:         make(map[string]int, len(e.Networks)),
+		FirstSeen:        e.FirstSeen,
+		LastSeen:         e.LastSeen,
+		FirstReceived:    e.FirstReceived,
+		LastReceived:     e.LastReceived,
+		EvidencedReports: e.EvidencedReports,
+		CompactedReports: e.CompactedReports,
+		MaxSeverity:      e.MaxSeverity,
+		BestTrusted:      e.BestTrusted,
+		Evidence:         append([]Evidence(nil), e.evidence...),
+		Recent:           e.Recent(),
+	}
+	for _, report := range st.Recent {
+		st.RecentReceived = append(st.RecentReceived, report.ReceivedAt)
+	}
+	if len(e.Tiers) > 0 {
+		st.Tiers = make(map[SourceTier]int, len(e.Tiers))
+		for tier, n := range e.Tiers {
+			st.Tiers[tier] = n
+		}
+	}
+	for _, id := range e.sourceOrder {
+		src := e.Sources[id]
+		st.Sources = append(st.Sources, TWABSourceState{SourceID: id, Reports: src.Reports, BestConfidence: src.BestConfidence, Weight: src.Weight, Tier: src.Tier, FirstSeen: src.FirstSeen, LastSeen: src.LastSeen, FirstReceived: src.FirstReceived})
+	}
+	for network, n := range e.Networks {
+		st.Networks[network] = n
+	}
+	return st
+}
+
+// entry rebuilds the TWABEntry, keeping the latest retain reports.
+func (st TWABState) entry(retain int) *TWABEntry {
+	e := &TWABEntry{
+		ReportCount:      st.ReportCount,
+		ConfidenceSum:    st.ConfidenceSum,
+		ChainID:          st.ChainID,
+		Sources:          make(map[string]*TWABSourceStats, len(st.Sources)),
+		Networks:         make(map[string]int, len(st.Networks)),
+		FirstSeen:        st.FirstSeen,
+		LastSeen:         st.LastSeen,
+		FirstReceived:    st.FirstReceived,
+		LastReceived:     st.LastReceived,
+		EvidencedReports: st.EvidencedReports,
+		CompactedReports: st.CompactedReports,
+		MaxSeverity:      st.MaxSeverity,
+		BestTrusted:      st.BestTrusted,
+		evidence:         append([]Evidence(nil), st.Evidence...),
+	}
+	if len(st.Tiers) > 0 {
+		e.Tiers = make(map[SourceTier]int, len(st.Tiers))
+		for tier, n := range st.Tiers {
+			e.Tiers[tier] = n
+		}
+	}
+	for _, src := range st.Sources {
+		weight := src.Weight
+		if weight == 0 {
+			weight = src.BestConfidence // exported before weights were kept; a lower bound
+		}
+		received := src.FirstReceived
+		if received.IsZero() {
+			received = src.FirstSeen // exported before receive times were kept per source
+		}
+		if _, ok := e.Sources[src.SourceID]; !ok {
+			e.sourceOrder = append(e.sourceOrder, src.SourceID)
+			e.addFirstReceipt(received)
+		}
+		e.Sources[src.SourceID] = &TWABSourceStats{Reports: src.Reports, BestConfidence: src.BestConfidence, Weight: weight, Tier: src.Tier, FirstSeen: src.FirstSeen, LastSeen: src.LastSeen, FirstReceived: received}
+	}
+	for network, n := range st.Networks {
+		e.Networks[network] = n
+	}
+	e.recent = append([]IOCReport(nil), st.Recent...)
+	if len(st.RecentReceived) == len(e.recent) {
+		for i := range e.recent {
+			e.recent[i].ReceivedAt = st.RecentReceived[i]
+		}
+	}
+	if len(e.recent) > retain {
+		e.recent = e.recent[len(e.recent)-retain:]
+	}
+	return e
+}
+
+// lockAll read-locks every shard, in order.
+func (t *TWAB) lockAll() {
+	for i := range t.shards {
+		t.shards[i].mu.RLock()
+	}
+}
+
+func (t *TWAB) unlockAll() {
+	for i := range t.shards {
+		t.shards[i].mu.RUnlock()
+	}
+}
+
+// exportLocked copies every entry.  The caller holds every shard lock.
+func (t *TWAB) exportLocked() []TWABState {
+	var out []TWABState
+	for i := range t.shards {
+		for addr, entry := range t.shards[i].entries {
+			out = append(out, stateOf(addr, entry))
+		}
+	}
+	return out
+}
+
+// empty reports whether no address is tracked.
+func (t *TWAB) empty() bool {
+	t.lockAll()
+	defer t.unlockAll()
+	for i := range t.shards {
+		if len(t.shards[i].entries) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// restore replaces every entry with states.
+func (t *TWAB) restore(states []TWABState) {
+	for i := range t.shards {
+		t.shards[i].mu.Lock()
+	}
+	for i := range t.shards {
+		t.shards[i].entries = make(map[string]*TWABEntry)
+	}
+	for _, st := range states {
+		t.shardFor(st.Address).entries[st.Address] = st.entry(t.config.RetainReports)
+	}
+	for i := range t.shards {
+		t.shards[i].mu.Unlock()
+	}
+}
+
+// bannedState copies the state of every banned source.
+func (t *quotaTracker) bannedState(now time.Time) []BanState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var out []BanState
+	for id, q := range t.sources {
+		if now.Before(q.BannedUntil) {
+			out = append(out, BanState{SourceID: id, Quota: *q})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SourceID < out[j].SourceID })
+	return out
+}
+
+// restoreBans replaces the tracked sources with bans.
+func (t *quotaTracker) restoreBans(bans []BanState) {
+	sources := make(map[string]*sourceQuota, len(bans))
+	for _, ban := range bans {
+		q := ban.Quota
+		sources[ban.SourceID] = &q
+	}
+	t.mu.Lock()
+	t.sources = sources
+	t.mu.Unlock()
+}
+
+// exportState copies the global state at one point in time.
+func (s *SwarmAggregator) exportState(now time.Time) exportedState {
+	var st exportedState
+	s.mu.RLock()
+	s.twab.lockAll()
+	st.header.FilterVersion = s.bloomFilter.Version()
+	st.confirmed = make([]ConfirmedEntry, 0, len(s.confirmed))
+	for _, entry := range s.confirmed {
+		st.confirmed = append(st.confirmed, *entry)
+	}
+	st.allowlist = make([]string, 0, len(s.allowlist))
+	for addr := range s.allowlist {
+		st.allowlist = append(st.allowlist, addr)
+	}
+	st.twab = s.twab.exportLocked()
+	st.recorded = s.twab.recorded.Load()
+	s.twab.unlockAll()
+	s.mu.RUnlock()
+	st.bans = s.quotas.bannedState(now)
+	st.cooldowns = s.cooldowns.list(now)
+	st.latencies = s.latency.list()
+	st.calibrations = s.calibration.list()
+
+	sort.Slice(st.confirmed, func(i, j int) bool { return st.confirmed[i].Address < st.confirmed[j].Address })
+	sort.Slice(st.twab, func(i, j int) bool { return st.twab[i].Address < st.twab[j].Address })
+	sort.Strings(st.allowlist)
+	identity := s.Identity()
+	st.header = StateHeader{
+		Format:        stateFormat,
+		Version:       stateFormatVersion,
+		ExportedAt:    now,
+		FilterVersion: st.header.FilterVersion,
+		InstanceUUID:  identity.InstanceUUID,
+		Epoch:         identity.Epoch,
+		Confirmed:     len(st.confirmed),
+		TWAB:          len(st.twab),
+		Allowlist:     len(st.allowlist),
+		Bans:          len(st.bans),
+		Cooldowns:     len(st.cooldowns),
+		Latencies:     len(st.latencies),
+		Calibrations:  len(st.calibrations),
+	}
+	return st
+}
+
+// writeState streams st as JSON lines, ending with their checksum.
+func writeState(w io.Writer, st exportedState) error {
+	sum := crc32.New(castagnoli)
+	enc := json.NewEncoder(io.MultiWriter(w, sum))
+	if err := enc.Encode(st.header); err != nil {
+		return err
+	}
+	for i := range st.confirmed {
+		if err := enc.Encode(StateRecord{Kind: stateConfirmed, Confirmed: &st.confirmed[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range st.twab {
+		if err := enc.Encode(StateRecord{Kind: stateTWAB, TWAB: &st.twab[i]}); err != nil {
+			return err
+		}
+	}
+	for _, addr := range st.allowlist {
+		if err := enc.Encode(StateRecord{Kind: stateAllowlist, Address: addr}); err != nil {
+			return err
+		}
+	}
+	for i := range st.bans {
+		if err := enc.Encode(StateRecord{Kind: stateBan, Ban: &st.bans[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range st.cooldowns {
+		if err := enc.Encode(StateRecord{Kind: stateCooldown, Cooldown: &st.cooldowns[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range st.latencies {
+		if err := enc.Encode(StateRecord{Kind: stateLatency, Latency: &st.latencies[i]}); err != nil {
+			return err
+		}
+	}
+	for i := range st.calibrations {
+		if err := enc.Encode(StateRecord{Kind: stateCalibration, Calibration: &st.calibrations[i]}); err != nil {
+			return err
+		}
+	}
+	return json.NewEncoder(w).Encode(StateRecord{Kind: stateChecksum, CRC32C: checksumHex(sum.Sum32())})
+}
+
+// readState decodes and validates a state stream.  It reads line by
+// line, so the checksum covers exactly the bytes before its record.
+func readState(r io.Reader) (exportedState, error) {
+	return readStateRecords(bufio.NewReader(r), false)
+}
+
+// readStateStream decodes a state stream at the head of br, leaving what
+// follows its checksum unread (see standby.go).
+func readStateStream(br *bufio.Reader) (exportedState, error) {
+	return readStateRecords(br, true)
+}
+
+func readStateRecords(br *bufio.Reader, untilChecksum bool) (exportedState, error) {
+	var st exportedState
+	sum := crc32.New(castagnoli)
+	line, err := br.ReadBytes('\n')
+	if err := json.Unmarshal(line, &st.header); err != nil {
+		return st, fmt.Errorf("state header: %w", err)
+	}
+	sum.Write(line)
+	if st.header.Format != stateFormat {
+		return st, fmt.Errorf("not an %s stream", stateFormat)
+	}
+	if st.header.Version != 1 && st.header.Version != stateFormatVersion {
+		return st, fmt.Errorf("unsupported %s version %d, want %d", stateFormat, st.header.Version, stateFormatVersion)
+	}
+	checked := false
+	for n := 2; err == nil && !(checked && untilChecksum); n++ {
+		line, err = br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return st, fmt.Errorf("record %d: %w", n, err)
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		if checked {
+			return st, fmt.Errorf("record %d: records after the checksum", n)
+		}
+		var rec StateRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return st, fmt.Errorf("record %d: %w", n, err)
+		}
+		if rec.Kind == stateChecksum && st.header.Version >= 2 {
+			if want := checksumHex(sum.Sum32()); rec.CRC32C != want {
+				return st, fmt.Errorf("checksum mismatch: stream has %s, contents give %s", rec.CRC32C, want)
+			}
+			checked = true
+			continue
+		}
+		sum.Write(line)
+		if err := st.add(rec); err != nil {
+			return st, fmt.Errorf("record %d: %w", n, err)
+		}
+	}
+	if st.header.Version >= 2 && !checked {
+		return st, errors.New("no checksum record; the stream may be truncated")
+	}
+	if len(st.confirmed) != st.header.Confirmed || len(st.twab) != st.header.TWAB || len(st.allowlist) != st.header.Allowlist || len(st.bans) != st.header.Bans || len(st.cooldowns) != st.header.Cooldowns || len(st.latencies) != st.header.Latencies || len(st.calibrations) != st.header.Calibrations {
+		return st, errors.New("record counts do not match the header; the stream may be truncated")
+	}
+	return st, nil
+}
+
+// add validates a record and appends it.
+func (st *exportedState) add(rec StateRecord) error {
+	switch {
+	case rec.Kind == stateConfirmed && rec.Confirmed != nil:
+		if err := checkStateKey(rec.Confirmed.Address, rec.Confirmed.ChainID); err != nil {
+			return err
+		}
+		st.confirmed = append(st.confirmed, *rec.Confirmed)
+	case rec.Kind == stateTWAB && rec.TWAB != nil:
+		if err := checkStateKey(rec.TWAB.Address, rec.TWAB.ChainID); err != nil {
+			return err
+		}
+		if rec.TWAB.ReportCount < 0 {
+			return fmt.Errorf("negative report count for %s", rec.TWAB.Address)
+		}
+		for tier := range rec.TWAB.Tiers {
+			if !tier.valid() {
+				return fmt.Errorf("unknown source tier %q for %s", tier, rec.TWAB.Address)
+			}
+		}
+		st.twab = append(st.twab, *rec.TWAB)
+	case rec.Kind == stateAllowlist && rec.Address != "":
+		if err := checkStateKey(rec.Address, 0); err != nil {
+			return err
+		}
+		st.allowlist = append(st.allowlist, rec.Address)
+	case rec.Kind == stateBan && rec.Ban != nil && rec.Ban.SourceID != "":
+		st.bans = append(st.bans, *rec.Ban)
+	case rec.Kind == stateCooldown && rec.Cooldown != nil:
+		if err := checkStateKey(rec.Cooldown.Address, 0); err != nil {
+			return err
+		}
+		st.cooldowns = append(st.cooldowns, *rec.Cooldown)
+	case rec.Kind == stateLatency && rec.Latency != nil:
+		if err := checkStateKey(rec.Latency.Address, rec.Latency.ChainID); err != nil {
+			return err
+		}
+		st.latencies = append(st.latencies, *rec.Latency)
+	case rec.Kind == stateCalibration && rec.Calibration != nil:
+		if !rec.Calibration.valid() {
+			return fmt.Errorf("invalid calibration curve for %q", rec.Calibration.SourceID)
+		}
+		st.calibrations = append(st.calibrations, *rec.Calibration)
+	default:
+		return fmt.Errorf("invalid %q record", rec.Kind)
+	}
+	return nil
+}
+
+// checkStateKey checks that an imported key is already normalized.
+func checkStateKey(key string, chainID int) error {
+	normalized, err := IndicatorKey(indicatorTypeOf(key), chainID, key)
+	if err != nil {
+		return err
+	}
+	if normalized != key {
+		return fmt.Errorf("key %q is not normalized, want %q", key, normalized)
+	}
+	return nil
+}
+
+// stateEmptyLocked reports whether there is no state an import would
+// replace.  s.mu must be held.
+func (s *SwarmAggregator) stateEmptyLocked(now time.Time) bool {
+	if len(s.confirmed) > 0 || len(s.allowlist) > len(s.fileAllow) {
+		return false
+	}
+	return s.twab.empty() && len(s.quotas.Bans(now)) == 0 && s.cooldowns.len() == 0
+}
+
+// importState replaces the global state with st, refusing with
+// ErrStateNotEmpty unless force is set or there is nothing to replace.
+func (s *SwarmAggregator) importState(ctx context.Context, st exportedState, force bool) error {
+	now := s.clock.Now()
+	s.mu.Lock()
+	if !force && !s.stateEmptyLocked(now) {
+		s.mu.Unlock()
+		return ErrStateNotEmpty
+	}
+	s.confirmed = make(map[string]*ConfirmedEntry, len(st.confirmed))
+	s.feedTags = make(map[string][]Provenance)
+	s.expiries = nil
+	entries := make(map[string]bool, len(st.confirmed))
+	for i := range st.confirmed {
+		entry := &st.confirmed[i]
+		s.confirmed[entry.Address] = entry
+		entries[entry.Address] = true
+		if entry.ExpiresAt != nil {
+			heap.Push(&s.expiries, expiryItem{at: *entry.ExpiresAt, address: entry.Address})
+		}
+	}
+	s.allowlist = make(map[string]bool, len(st.allowlist)+len(s.fileAllow))
+	for addr := range s.fileAllow {
+		s.allowlist[addr] = true
+	}
+	for _, addr := range st.allowlist {
+		s.allowlist[addr] = true
+	}
+	s.tripwire.set(protectedAllowlist, s.allowlist)
+	s.twab.restore(st.twab)
+	version := s.bloomFilter.Replace(entries, s.bloomFilter.Params())
+	s.bitPatches.reset() // subscribers holding bits resync (see bitpatch.go)
+	snap := s.filterStateLocked().snap
+	snap.rebuild = true
+	s.pushes.offer(ctx, snap) // before any push of this version (see pushqueue.go)
+	s.mu.Unlock()
+	s.quotas.restoreBans(st.bans)
+	s.cooldowns.restore(st.cooldowns)
+	s.latency.restore(st.latencies)
+	s.calibration.restore(st.calibrations)
+
+	log.Printf("Imported state exported at %s: %d confirmed, %d tracked, %d allowlisted, %d banned, %d cooling down; filter now v%d",
+		st.header.ExportedAt.Format(time.RFC3339), len(st.confirmed), len(st.twab), len(st.allowlist), len(st.bans), len(st.cooldowns), version)
+	s.pushes.drain(s.broadcastSnapshot)
+	if n := s.events.unsubscribeStandbys(); n > 0 {
+		log.Printf("Closed %d standby streams to copy the imported state", n)
+	}
+	return nil
+}
+
+// SaveStateFile writes the state to path through a temporary file.
+func (s *SwarmAggregator) SaveStateFile(path string) error {
+	var buf bytes.Buffer
+	if err := writeState(&buf, s.exportState(s.clock.Now())); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// LoadStateFile imports the state saved at path into an empty aggregator,
+// adopting its identity.  A missing file is not an error; one that does
+// not read, checksum included, is renamed to path.corrupt so the next
+// save does not overwrite it, and the error says so.
+func (s *SwarmAggregator) LoadStateFile(ctx context.Context, path string) (int, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	st, err := readState(f)
+	f.Close()
+	if err != nil {
+		if renameErr := os.Rename(path, path+".corrupt"); renameErr != nil {
+			return 0, fmt.Errorf("state file %s: %w (and could not move it aside: %v)", path, err, renameErr)
+		}
+		return 0, fmt.Errorf("state file %s: %w; moved to %s.corrupt", path, err, path)
+	}
+	previous := s.Identity()
+	restored := s.restoreIdentity(st.header)
+	if err := s.importState(ctx, st, false); err != nil {
+		if restored {
+			s.identity.Store(&previous)
+		}
+		return 0, fmt.Errorf("state file %s: %w", path, err)
+	}
+	return len(st.confirmed), nil
+}
+
+// handleAdminSnapshotExport is the HTTP handler for GET
+// /admin/snapshot/export.
+func (s *SwarmAggregator) handleAdminSnapshotExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	st := s.exportState(s.clock.Now())
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="aegis-state.jsonl"`)
+	bw := bufio.NewWriter(w)
+	if err := writeState(bw, st); err != nil {
+		log.Printf("State export aborted: %v", err)
+		return
+	}
+	bw.Flush()
+}
+
+// handleAdminSnapshotImport is the HTTP handler for POST
+// /admin/snapshot/import.
+func (s *SwarmAggregator) handleAdminSnapshotImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	st, err := readState(bufio.NewReader(r.Body))
+	if err != nil {
+		writeBodyError(w, r, err, err.Error())
+		return
+	}
+	force := r.URL.Query().Get("force") == "1"
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditStateImport, Subject: st.header.ExportedAt.Format(time.RFC3339)}) {
+		return
+	}
+	if err := s.importState(r.Context(), st, force); err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"confirmed": len(st.confirmed),
+		"twab":      len(st.twab),
+		"allowlist": len(st.allowlist),
+		"bans":      len(st.bans),
+	})
+}
@@ -0,0 +1,144 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSnapshotAggregator() *SwarmAggregator {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Ingest.Synchronous = true
+	cfg.Quota.ReportsPerHour = 3
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.keys.Add("admin-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	return agg
+}
+
+func exportState(t *testing.T, agg *SwarmAggregator) string {
+	t.Helper()
+	rec := adminRequest(t, agg, "admin-secret", http.MethodGet, "/admin/snapshot/export", "")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("Export failed: %d %s", rec.Code, rec.Body)
+	}
+	return rec.Body.String()
+}
+
+// stateRecords drops the header and the checksum, which differ between
+// exports.
+func stateRecords(export string) []string {
+	lines := strings.Split(strings.TrimSpace(export), "\n")
+	return lines[1 : len(lines)-1]
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	src := newSnapshotAggregator()
+	ctx := context.Background()
+	report := func(agg *SwarmAggregator, typ IndicatorType, value, source string) {
+		t.Helper()
+		if _, err := agg.SubmitReport(ctx, IOCReport{IndicatorType: typ, Address: value, ChainID: 1, Confidence: 0.8, Category: "drainer", Timestamp: time.Now(), SourceID: source}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	confirmed, pending, allowed := evmAddress("confirmed"), evmAddress("pending"), evmAddress("allowed")
+	report(src, "", confirmed, "agent-A")
+	report(src, "", confirmed, "agent-B")
+	report(src, "", pending, "agent-A")
+	report(src, IndicatorDomain, "claim.example", "agent-C")
+	src.Allow(ctx, allowed)
+	for i := 0; i < 4; i++ {
+		src.SubmitReport(ctx, IOCReport{Address: evmAddress("spam"), ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "spammer"})
+	}
+
+	export := exportState(t, src)
+	var header StateHeader
+	json.Unmarshal([]byte(strings.SplitN(export, "\n", 2)[0]), &header)
+	if header.Format != stateFormat || header.Version != stateFormatVersion || header.Confirmed != 1 || header.TWAB != 4 || header.Allowlist != 1 || header.Bans != 1 {
+		t.Fatalf("Unexpected header %+v", header)
+	}
+
+	dst := newSnapshotAggregator()
+	if rec := adminRequest(t, dst, "admin-secret", http.MethodPost, "/admin/snapshot/import", export); rec.Code != http.StatusOK {
+		t.Fatalf("Import failed: %d %s", rec.Code, rec.Body)
+	}
+	if got := stateRecords(exportState(t, dst)); !reflect.DeepEqual(got, stateRecords(export)) {
+		t.Errorf("Expected the imported state to export the same records:\n%v\n%v", got, stateRecords(export))
+	}
+
+	check := func(agg *SwarmAggregator, query string) map[string]interface{} {
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/check?"+query, nil))
+		var resp map[string]interface{}
+		json.NewDecoder(rec.Body).Decode(&resp)
+		delete(resp, "filter_version")
+		return resp
+	}
+	for _, query := range []string{"address=" + confirmed, "address=" + pending, "address=" + allowed, "type=domain&address=claim.example"} {
+		if got, want := check(dst, query), check(src, query); !reflect.DeepEqual(got, want) {
+			t.Errorf("/check?%s: expected %v, got %v", query, want, got)
+		}
+	}
+	for _, key := range []string{confirmed, pending, "domain:claim.example"} {
+		if got, want := dst.twab.MeetsThreshold(key, dst.current().TWAB), src.twab.MeetsThreshold(key, src.current().TWAB); got != want {
+			t.Errorf("MeetsThreshold(%s): expected %v, got %v", key, want, got)
+		}
+	}
+	got, _ := json.Marshal(dst.quotas.Bans(time.Now()))
+	want, _ := json.Marshal(src.quotas.Bans(time.Now()))
+	if string(got) != string(want) {
+		t.Errorf("Expected bans %s carried over, got %s", want, got)
+	}
+
+	// Consensus continues where it left off.
+	report(src, "", pending, "agent-B")
+	report(dst, "", pending, "agent-B")
+	if !src.bloomFilter.Contains(pending) || !dst.bloomFilter.Contains(pending) {
+		t.Error("Expected the pending address promoted on both sides by one more source")
+	}
+}
+
+func TestSnapshotImportValidation(t *testing.T) {
+	src := newSnapshotAggregator()
+	src.Block(context.Background(), AdminAction{Address: evmAddress("blocked"), ChainID: 1})
+	export := exportState(t, src)
+
+	dst := newSnapshotAggregator()
+	dst.Block(context.Background(), AdminAction{Address: evmAddress("existing"), ChainID: 1})
+	var body ErrorResponse
+	rec := adminRequest(t, dst, "admin-secret", http.MethodPost, "/admin/snapshot/import", export)
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusConflict || body.Error.Code != CodeStateNotEmpty {
+		t.Fatalf("Expected 409 importing over existing state, got %d %+v", rec.Code, body)
+	}
+	if rec := adminRequest(t, dst, "admin-secret", http.MethodPost, "/admin/snapshot/import?force=1", export); rec.Code != http.StatusOK {
+		t.Fatalf("Expected a forced import, got %d %s", rec.Code, rec.Body)
+	}
+	if !dst.bloomFilter.Contains(evmAddress("blocked")) || dst.bloomFilter.Contains(evmAddress("existing")) {
+		t.Error("Expected the forced import to replace the confirmed set")
+	}
+
+	fresh := newSnapshotAggregator()
+	lines := strings.Split(strings.TrimSpace(export), "\n")
+	for name, stream := range map[string]string{
+		"future version": strings.Replace(export, `"version":2`, `"version":3`, 1),
+		"other format":   strings.Replace(export, stateFormat, "other", 1),
+		"truncated":      lines[0] + "\n",
+		"unnormalized":   strings.Replace(export, evmAddress("blocked"), strings.ToUpper(evmAddress("blocked")[2:]), 1),
+	} {
+		var body ErrorResponse
+		rec := adminRequest(t, fresh, "admin-secret", http.MethodPost, "/admin/snapshot/import", stream)
+		json.NewDecoder(rec.Body).Decode(&body)
+		if rec.Code != http.StatusBadRequest || body.Error.Code != CodeInvalidBody {
+			t.Errorf("%s: expected 400 %s, got %d %+v", name, CodeInvalidBody, rec.Code, body)
+		}
+	}
+	if fresh.BloomFilterLen() != 0 {
+		t.Error("Expected rejected imports to change nothing")
+	}
+}
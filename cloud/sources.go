@@ -0,0 +1,360 @@
+// Package swarm — Per-source analytics.
+//
+// GET /admin/sources shows operators which sources dominate the report
+// stream and how well their reports predict consensus.  The ingest path
+// counts, per source and hour over the last seven days, the reports
+// recorded, those rejected (bad chain, address or evidence, a timestamp
+// too far ahead, over quota or banned) and the replays of a report ID
+// already seen, with the addresses reported and when each was first.
+// Consensus promotions are kept by address, so a source's promotion rate
+// is the share of the addresses it reported that were promoted at or
+// after its first report of them in the window.
+//
+// Cardinality is bounded: an hour tracks at most maxSourceStats sources,
+// counting any beyond them together as "other", and keeps at most
+// sourceAddressCap addresses per source, so a source reporting more in an
+// hour has its unique addresses and promotion rate computed over the
+// first ones.  Only the global swarm is counted, as for GET /stats.  A
+// source with a confidence calibration curve (see calibration.go) is
+// listed with it.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	sourceBucketWidth = time.Hour
+	sourceBuckets     = int(statsRetention / sourceBucketWidth)
+
+	// maxSourceStats bounds the sources tracked per hour.
+	maxSourceStats = 1000
+
+	// sourceAddressCap bounds the addresses kept per source per hour.
+	sourceAddressCap = 4096
+
+	// defaultSourcesLimit is the sources listed when no limit is given.
+	defaultSourcesLimit = 100
+)
+
+// Orders of GET /admin/sources.
+const (
+	sortSourcesReports       = "reports"
+	sortSourcesPromotionRate = "promotion_rate"
+	sortSourcesRejectionRate = "rejection_rate"
+)
+
+// sourceCounts is one source's hour, or the merge of several.
+type sourceCounts struct {
+	reports    int64
+	rejected   int64
+	duplicates int64
+	addresses  map[uint64]int64 // address hash -> first report, unix nanos
+}
+
+func newSourceCounts() *sourceCounts {
+	return &sourceCounts{addresses: make(map[uint64]int64)}
+}
+
+// merge adds o into c, keeping the earlier first report of an address.
+func (c *sourceCounts) merge(o *sourceCounts) {
+	c.reports += o.reports
+	c.rejected += o.rejected
+	c.duplicates += o.duplicates
+	for h, at := range o.addresses {
+		if have, ok := c.addresses[h]; !ok || at < have {
+			c.addresses[h] = at
+		}
+	}
+}
+
+// sourceBucket is one hour of per-source counts.
+type sourceBucket struct {
+	index   int64 // start time / sourceBucketWidth
+	sources map[string]*sourceCounts
+	other   *sourceCounts // sources beyond maxSources
+}
+
+// sourceStats is the ring of hourly buckets behind GET /admin/sources.
+type sourceStats struct {
+	maxSources int
+
+	mu       sync.Mutex
+	slots    [sourceBuckets]*sourceBucket
+	promoted map[uint64]int64 // address hash -> last consensus promotion, unix nanos
+}
+
+func newSourceStats(maxSources int) *sourceStats {
+	return &sourceStats{maxSources: maxSources, promoted: make(map[uint64]int64)}
+}
+
+// countsLocked returns the counts of source for the hour of now, nil
+// when now falls before the hour its slot holds.
+func (t *sourceStats) countsLocked(source string, now time.Time) *sourceCounts {
+	index := now.UnixNano() / int64(sourceBucketWidth)
+	slot := &t.slots[index%int64(sourceBuckets)]
+	switch b := *slot; {
+	case b != nil && b.index > index:
+		return nil
+	case b == nil || b.index < index:
+		*slot = &sourceBucket{index: index, sources: make(map[string]*sourceCounts)}
+	}
+	b := *slot
+	if c, ok := b.sources[source]; ok {
+		return c
+	}
+	if len(b.sources) < t.maxSources {
+		c := newSourceCounts()
+		b.sources[source] = c
+		return c
+	}
+	if b.other == nil {
+		b.other = newSourceCounts()
+	}
+	return b.other
+}
+
+// recordReport counts a report recorded toward consensus.
+func (t *sourceStats) recordReport(source, address string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c := t.countsLocked(source, now)
+	if c == nil {
+		return
+	}
+	c.reports++
+	h := addressHash(address)
+	if _, ok := c.addresses[h]; !ok && len(c.addresses) < sourceAddressCap {
+		c.addresses[h] = now.UnixNano()
+	}
+}
+
+// recordRejected counts a report refused before reaching consensus.
+func (t *sourceStats) recordRejected(source string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c := t.countsLocked(source, now); c != nil {
+		c.rejected++
+	}
+}
+
+// recordDuplicate counts a replay of a report ID already seen.
+func (t *sourceStats) recordDuplicate(source string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c := t.countsLocked(source, now); c != nil {
+		c.duplicates++
+	}
+}
+
+// recordPromotion notes a consensus promotion of address.
+func (t *sourceStats) recordPromotion(address string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.promoted[addressHash(address)] = now.UnixNano()
+}
+
+// prune forgets promotions older than the retention, which no report in
+// any window can precede.
+func (t *sourceStats) prune(ctx context.Context, now time.Time) TaskStats {
+	var stats TaskStats
+	cutoff := now.Add(-statsRetention).UnixNano()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for h, at := range t.promoted {
+		if ctx.Err() != nil {
+			break
+		}
+		stats.Items++
+		if at < cutoff {
+			delete(t.promoted, h)
+		}
+	}
+	return stats
+}
+
+// SourceStats is one source's line of GET /admin/sources.  Share is of
+// every report recorded in the window; PromotionRate is Promoted over
+// UniqueAddresses, and RejectionRate Rejected over all it sent but
+// replays.
+type SourceStats struct {
+	SourceID        string             `json:"source_id,omitempty"`
+	Reports         int64              `json:"reports"`
+	UniqueAddresses int                `json:"unique_addresses"`
+	Share           float64            `json:"share"`
+	Promoted        int                `json:"promoted"`
+	PromotionRate   float64            `json:"promotion_rate"`
+	Rejected        int64              `json:"rejected"`
+	RejectionRate   float64            `json:"rejection_rate"`
+	Duplicates      int64              `json:"duplicates"`
+	Quota           *SourceQuotaStatus `json:"quota,omitempty"`
+	Calibration     *CalibrationCurve  `json:"calibration,omitempty"`
+}
+
+// SourcesReport is the body of GET /admin/sources.  Other sums the
+// sources past the per-hour cap; Total counts every source's reports.
+type SourcesReport struct {
+	Window  string        `json:"window"`
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Sort    string        `json:"sort"`
+	Total   int64         `json:"total_reports"`
+	Sources []SourceStats `json:"sources"`
+	Other   *SourceStats  `json:"other,omitempty"`
+}
+
+// Aggregate merges the buckets in the window ending at now, rounded up
+// to whole hours, and lists the sources in order.
+func (t *sourceStats) Aggregate(window time.Duration, now time.Time, order string) SourcesReport {
+	window = min(window, statsRetention)
+	n := max(int64((window+sourceBucketWidth-1)/sourceBucketWidth), 1)
+	last := now.UnixNano() / int64(sourceBucketWidth)
+	first := last - n + 1
+
+	merged := make(map[string]*sourceCounts)
+	var other *sourceCounts
+	t.mu.Lock()
+	for _, b := range t.slots {
+		if b == nil || b.index < first || b.index > last {
+			continue
+		}
+		for id, c := range b.sources {
+			m, ok := merged[id]
+			if !ok {
+				m = newSourceCounts()
+				merged[id] = m
+			}
+			m.merge(c)
+		}
+		if b.other != nil {
+			if other == nil {
+				other = newSourceCounts()
+			}
+			other.merge(b.other)
+		}
+	}
+	out := SourcesReport{Window: window.String(), From: now.Add(-window), To: now, Sort: order, Sources: make([]SourceStats, 0, len(merged))}
+	for id, c := range merged {
+		out.Sources = append(out.Sources, t.statsLocked(id, c))
+		out.Total += c.reports
+	}
+	if other != nil {
+		st := t.statsLocked("", other)
+		out.Other = &st
+		out.Total += other.reports
+	}
+	t.mu.Unlock()
+
+	for i := range out.Sources {
+		out.Sources[i].setShare(out.Total)
+	}
+	if out.Other != nil {
+		out.Other.setShare(out.Total)
+	}
+	sort.Slice(out.Sources, func(i, j int) bool {
+		a, b := out.Sources[i], out.Sources[j]
+		var x, y float64
+		switch order {
+		case sortSourcesPromotionRate:
+			x, y = a.PromotionRate, b.PromotionRate
+		case sortSourcesRejectionRate:
+			x, y = a.RejectionRate, b.RejectionRate
+		}
+		if x != y {
+			return x > y
+		}
+		if a.Reports != b.Reports {
+			return a.Reports > b.Reports
+		}
+		return a.SourceID < b.SourceID
+	})
+	return out
+}
+
+// statsLocked computes the line of merged counts.  t.mu must be held.
+func (t *sourceStats) statsLocked(id string, c *sourceCounts) SourceStats {
+	st := SourceStats{SourceID: id, Reports: c.reports, UniqueAddresses: len(c.addresses), Rejected: c.rejected, Duplicates: c.duplicates}
+	for h, at := range c.addresses {
+		if promoted, ok := t.promoted[h]; ok && promoted >= at {
+			st.Promoted++
+		}
+	}
+	if st.UniqueAddresses > 0 {
+		st.PromotionRate = float64(st.Promoted) / float64(st.UniqueAddresses)
+	}
+	if sent := c.reports + c.rejected; sent > 0 {
+		st.RejectionRate = float64(c.rejected) / float64(sent)
+	}
+	return st
+}
+
+func (st *SourceStats) setShare(total int64) {
+	if total > 0 {
+		st.Share = float64(st.Reports) / float64(total)
+	}
+}
+
+// pruneSourceStats is the maintenance task forgetting old promotions.
+func (s *SwarmAggregator) pruneSourceStats(ctx context.Context) TaskStats {
+	return s.sourceStats.prune(ctx, s.maintenance.clock.Now())
+}
+
+// handleAdminSources is the HTTP handler for GET /admin/sources, taking
+// ?sort=reports|promotion_rate|rejection_rate, ?window= between 1h and
+// 168h (24h by default), and ?limit= (100 by default).
+func (s *SwarmAggregator) handleAdminSources(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	order := q.Get("sort")
+	switch order {
+	case "":
+		order = sortSourcesReports
+	case sortSourcesReports, sortSourcesPromotionRate, sortSourcesRejectionRate:
+	default:
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "sort must be reports, promotion_rate or rejection_rate")
+		return
+	}
+	window := defaultStatsWindow
+	if v := q.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < sourceBucketWidth || d > statsRetention {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "window must be a duration between 1h and 168h")
+			return
+		}
+		window = d
+	}
+	limit := defaultSourcesLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "limit must be a positive integer")
+			return
+		}
+		limit = n
+	}
+
+	now := s.clock.Now()
+	report := s.sourceStats.Aggregate(window, now, order)
+	if len(report.Sources) > limit {
+		report.Sources = report.Sources[:limit]
+	}
+	for i := range report.Sources {
+		if status, ok := s.quotas.status(report.Sources[i].SourceID, now); ok {
+			report.Sources[i].Quota = &status
+		}
+		if curve, ok := s.calibration.curve(report.Sources[i].SourceID, s.current().Calibration); ok {
+			report.Sources[i].Calibration = &curve
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,147 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func querySources(t *testing.T, agg *SwarmAggregator, query string) SourcesReport {
+	t.Helper()
+	rec := adminRequest(t, agg, "ops-secret", http.MethodGet, "/admin/sources"+query, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /admin/sources%s: expected 200, got %d: %s", query, rec.Code, rec.Body)
+	}
+	var report SourcesReport
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func sourceLine(t *testing.T, report SourcesReport, id string) SourceStats {
+	t.Helper()
+	for _, st := range report.Sources {
+		if st.SourceID == id {
+			return st
+		}
+	}
+	t.Fatalf("Expected %q in %+v", id, report.Sources)
+	return SourceStats{}
+}
+
+func TestSourcePromotionHitRate(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Quota = testQuota(100, 0)
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.keys.Add("ops-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	ctx := context.Background()
+	report := func(source, seed string) {
+		t.Helper()
+		if _, err := agg.SubmitReport(ctx, IOCReport{Address: evmAddress(seed), ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: source}); err != nil {
+			t.Fatalf("Report of %s by %s rejected: %v", seed, source, err)
+		}
+	}
+
+	// scout reports four addresses first; echo confirms two of them.
+	for _, seed := range []string{"a", "b", "c", "d"} {
+		report("scout", seed)
+	}
+	report("echo", "a")
+	report("echo", "b")
+	// late only reports an address already promoted, noise nothing that is.
+	report("late", "a")
+	report("noise", "e")
+	report("noise", "e")
+	report("noise", "f")
+	if _, err := agg.SubmitReport(ctx, IOCReport{Address: "not-an-address", ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "noise"}); err == nil {
+		t.Fatal("Expected the malformed report rejected")
+	}
+	body := fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.5,"source_id":"noise","report_id":"r-1"}`, evmAddress("g"))
+	for i := 0; i < 2; i++ {
+		if rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/ingest", body); rec.Code != http.StatusOK {
+			t.Fatalf("POST /ingest: expected 200, got %d: %s", rec.Code, rec.Body)
+		}
+	}
+
+	got := querySources(t, agg, "?sort=promotion_rate&window=1h")
+	if got.Total != 11 || len(got.Sources) != 4 || got.Other != nil {
+		t.Fatalf("Expected 11 reports from 4 sources, got %+v", got)
+	}
+	for _, want := range []struct {
+		id       string
+		reports  int64
+		unique   int
+		promoted int
+		rate     float64
+	}{
+		{"echo", 2, 2, 2, 1},
+		{"scout", 4, 4, 2, 0.5},
+		{"late", 1, 1, 0, 0},
+		{"noise", 4, 3, 0, 0},
+	} {
+		st := sourceLine(t, got, want.id)
+		if st.Reports != want.reports || st.UniqueAddresses != want.unique || st.Promoted != want.promoted || st.PromotionRate != want.rate {
+			t.Errorf("Expected %s with %d reports of %d addresses, %d promoted (%v), got %+v", want.id, want.reports, want.unique, want.promoted, want.rate, st)
+		}
+	}
+	if got.Sources[0].SourceID != "echo" || got.Sources[1].SourceID != "scout" || got.Sources[2].SourceID != "noise" {
+		t.Errorf("Expected sources by promotion rate then reports, got %+v", got.Sources)
+	}
+
+	noise := sourceLine(t, got, "noise")
+	if noise.Rejected != 1 || noise.Duplicates != 1 || noise.RejectionRate != 0.2 || noise.Share != 4.0/11 {
+		t.Errorf("Expected noise with 1 rejection, 1 replay and a 4/11 share, got %+v", noise)
+	}
+	if noise.Quota == nil || noise.Quota.HourReports != 4 || noise.Quota.ReportsPerHour != 100 || noise.Quota.BannedUntil != nil {
+		t.Errorf("Expected noise's quota standing, got %+v", noise.Quota)
+	}
+	if first := querySources(t, agg, "?sort=rejection_rate&limit=1"); len(first.Sources) != 1 || first.Sources[0].SourceID != "noise" {
+		t.Errorf("Expected noise first by rejection rate, got %+v", first.Sources)
+	}
+}
+
+func TestSourceStatsFoldOverflowIntoOther(t *testing.T) {
+	now := time.Now()
+	st := newSourceStats(2)
+	for i, source := range []string{"a", "b", "c", "d"} {
+		st.recordReport(source, evmAddress(source), now)
+		if i == 3 {
+			st.recordReport(source, evmAddress(source), now)
+		}
+	}
+	st.recordPromotion(evmAddress("d"), now)
+	st.recordReport("a", evmAddress("old"), now.Add(-3*time.Hour))
+
+	got := st.Aggregate(time.Hour, now, sortSourcesReports)
+	if len(got.Sources) != 2 || got.Total != 5 {
+		t.Fatalf("Expected 2 sources and 5 reports in the last hour, got %+v", got)
+	}
+	if o := got.Other; o == nil || o.Reports != 3 || o.UniqueAddresses != 2 || o.Promoted != 1 || o.Share != 0.6 {
+		t.Errorf("Expected c and d counted as other, got %+v", got.Other)
+	}
+	if a := st.Aggregate(4*time.Hour, now, sortSourcesReports); a.Total != 6 {
+		t.Errorf("Expected the older report in a wider window, got %+v", a)
+	}
+
+	if stats := st.prune(context.Background(), now.Add(statsRetention+time.Hour)); stats.Items != 1 || len(st.promoted) != 0 {
+		t.Errorf("Expected the old promotion pruned, got %+v", stats)
+	}
+}
+
+func TestAdminSourcesRejectsBadParameters(t *testing.T) {
+	agg := NewSwarmAggregator()
+	agg.keys.Add("ops-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	for _, query := range []string{"?sort=name", "?window=30m", "?window=200h", "?limit=0"} {
+		if rec := adminRequest(t, agg, "ops-secret", http.MethodGet, "/admin/sources"+query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("GET /admin/sources%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+	if rec := adminRequest(t, agg, "", http.MethodGet, "/admin/sources", ""); rec.Code == http.StatusOK {
+		t.Error("Expected GET /admin/sources to require an admin key")
+	}
+}
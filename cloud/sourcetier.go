@@ -0,0 +1,181 @@
+// Package swarm — Source tiers.
+//
+// Every report is recorded with the tier of the source that sent it,
+// taken from the API key it was sent with: anonymous without a key,
+// registered with one, and trusted with a key an admin has granted the
+// trusted tier, such as a curated feed importer or the internal research
+// team.  twab.tier_weights scales each source's contribution to the
+// weighted score by its tier, and with twab.trusted_confidence one
+// trusted report at least that confident promotes on its own, whatever
+// the other gates; it is still recorded in TWAB like any other.  The
+// tiers behind an address are shown by /explain, and the tier of the
+// report that promoted it by its promotion event and alert.
+//
+// /admin/source-tiers manages the grants: GET lists them, POST
+// {"key": "ns/id", "reason": "..."} grants a reporter or admin key the
+// trusted tier, and DELETE ?key=&reason= demotes it back to registered.
+// Both changes are audited.  Reports already recorded keep the tier they
+// were sent with.  Grants, like key revocations, last until restart, and
+// revoking a key drops its grant.
+package swarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SourceTier is how far the sender of a report is trusted.
+type SourceTier string
+
+const (
+	SourceAnonymous  SourceTier = "anonymous"
+	SourceRegistered SourceTier = "registered"
+	SourceTrusted    SourceTier = "trusted"
+)
+
+func (t SourceTier) valid() bool {
+	return t == SourceAnonymous || t == SourceRegistered || t == SourceTrusted
+}
+
+// orAnonymous returns the tier, anonymous for reports recorded without
+// one: in-process, from the message bus, or from before tiers.
+func (t SourceTier) orAnonymous() SourceTier {
+	if t == "" {
+		return SourceAnonymous
+	}
+	return t
+}
+
+// TrustGrant is a key granted the trusted tier.
+type TrustGrant struct {
+	Key       string    `json:"key"`
+	GrantedBy string    `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// trustGrants is the set of trusted keys, by key name.
+type trustGrants struct {
+	mu     sync.RWMutex
+	grants map[string]TrustGrant
+}
+
+func newTrustGrants() *trustGrants {
+	return &trustGrants{grants: make(map[string]TrustGrant)}
+}
+
+func (g *trustGrants) trusted(key string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	_, ok := g.grants[key]
+	return ok
+}
+
+// grant records a grant, reporting whether the key was not trusted yet.
+func (g *trustGrants) grant(grant TrustGrant) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, had := g.grants[grant.Key]
+	if !had {
+		g.grants[grant.Key] = grant
+	}
+	return !had
+}
+
+// revoke drops a key's grant, reporting whether it had one.
+func (g *trustGrants) revoke(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, had := g.grants[key]
+	delete(g.grants, key)
+	return had
+}
+
+// list returns the grants sorted by key.
+func (g *trustGrants) list() []TrustGrant {
+	g.mu.RLock()
+	out := make([]TrustGrant, 0, len(g.grants))
+	for _, grant := range g.grants {
+		out = append(out, grant)
+	}
+	g.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
+
+// requestSourceTier returns the tier of the key a report was sent with.
+// An invalid key has already been refused by requestNamespace.
+func (s *SwarmAggregator) requestSourceTier(r *http.Request) SourceTier {
+	secret := presentedSecret(r)
+	if secret == "" {
+		return SourceAnonymous
+	}
+	key, ok := s.keys.Lookup(secret)
+	switch {
+	case !ok:
+		return SourceAnonymous
+	case s.trust.trusted(key.Name()):
+		return SourceTrusted
+	}
+	return SourceRegistered
+}
+
+// handleAdminSourceTiers is the HTTP handler for /admin/source-tiers.
+func (s *SwarmAggregator) handleAdminSourceTiers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"trusted": s.trust.list()})
+
+	case http.MethodPost:
+		var req struct {
+			Key    string `json:"key"`
+			Reason string `json:"reason,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Key == "" {
+			writeBodyError(w, r, err, "Invalid grant body, want {\"key\": \"ns/id\"}")
+			return
+		}
+		key, ok := s.keys.Named(req.Key)
+		if !ok {
+			writeError(w, r, http.StatusNotFound, CodeNotFound, "API key not found")
+			return
+		}
+		if !key.hasRole(RoleReporter) {
+			writeError(w, r, http.StatusUnprocessableEntity, CodeInvalidParameter, "Only reporter and admin keys may be trusted")
+			return
+		}
+		if !s.auditAdmin(w, r, AuditEvent{Action: AuditTierGrant, Subject: req.Key, Reason: req.Reason}) {
+			return
+		}
+		admin, _ := APIKeyFromContext(r.Context())
+		changed := s.trust.grant(TrustGrant{Key: req.Key, GrantedBy: admin.Name(), GrantedAt: s.clock.Now(), Reason: req.Reason})
+		writeTierResult(w, req.Key, SourceTrusted, changed)
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("key")
+		if name == "" {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Missing key")
+			return
+		}
+		if !s.auditAdmin(w, r, AuditEvent{Action: AuditTierRevoke, Subject: name}) {
+			return
+		}
+		writeTierResult(w, name, SourceRegistered, s.trust.revoke(name))
+
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func writeTierResult(w http.ResponseWriter, key string, tier SourceTier, changed bool) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key":     key,
+		"tier":    tier,
+		"changed": changed,
+	})
+}
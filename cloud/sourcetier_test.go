@@ -0,0 +1,182 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// newTierAggregator returns an audited aggregator processing reports
+// inline, where one trusted report of confidence 0.9 promotes and
+// anonymous sources count for half the weighted score.  It accepts the
+// reporter key "curated-feed" (feed-secret) and the subscriber key
+// "wallet" (wallet-secret) besides the admins of newAuditedAggregator.
+func newTierAggregator(t *testing.T) *SwarmAggregator {
+	cfg := DefaultConfig()
+	cfg.Ingest.Synchronous = true
+	cfg.TWAB = TWABConfig{
+		MinReportCount:     2,
+		MinDistinctSources: 2,
+		MinTimeSpanSeconds: 60,
+		MinWeightedScore:   1,
+		TierWeights:        map[SourceTier]float64{SourceAnonymous: 0.5},
+		TrustedConfidence:  0.9,
+	}
+	agg, _ := newAuditedAggregator(t, cfg)
+	agg.keys.Add("feed-secret", APIKey{ID: "curated-feed", Role: RoleReporter})
+	agg.keys.Add("wallet-secret", APIKey{ID: "wallet", Role: RoleSubscriber})
+	return agg
+}
+
+// ingestAs posts a report with the given key, "" for none.
+func ingestAs(t *testing.T, agg *SwarmAggregator, secret, address, source string, confidence float64) ingestResult {
+	t.Helper()
+	body := fmt.Sprintf(`{"address":%q,"chain_id":1,"category":"drainer","confidence":%g,"source_id":%q}`, address, confidence, source)
+	rec := adminRequest(t, agg, secret, http.MethodPost, "/ingest", body)
+	var res ingestResult
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Ingest as %q: expected 200, got %d %s", secret, rec.Code, rec.Body)
+	}
+	return res
+}
+
+func gateOf(exp ThresholdExplanation, name string) (ThresholdGate, bool) {
+	for _, g := range exp.Gates {
+		if g.Gate == name {
+			return g, true
+		}
+	}
+	return ThresholdGate{}, false
+}
+
+func TestAnonymousReportsStayBelowThreshold(t *testing.T) {
+	agg := newTierAggregator(t)
+	addr := evmAddress("anonymous")
+	ingestAs(t, agg, "", addr, "agent-A", 1)
+	res := ingestAs(t, agg, "feed-secret", addr, "agent-B", 1) // registered, not trusted
+
+	if res.AddedToFilter || res.ReasonCode != reasonBelowThreshold || agg.bloomFilter.Contains(addr) {
+		t.Fatalf("Expected anonymous and registered reports below threshold, got %+v", res)
+	}
+	exp := agg.explain("", addr)
+	if exp.Tiers[SourceAnonymous] != 1 || exp.Tiers[SourceRegistered] != 1 || exp.Tiers[SourceTrusted] != 0 {
+		t.Errorf("Expected one anonymous and one registered report, got %v", exp.Tiers)
+	}
+	if g, ok := gateOf(exp, gateTrusted); !ok || g.Passed || g.Threshold != 0.9 {
+		t.Errorf("Expected the trusted report gate failed, got %+v", exp.Gates)
+	}
+	want := []SourceContribution{{Tier: SourceAnonymous, Raw: 1, Capped: 0.5}, {Tier: SourceRegistered, Raw: 1, Capped: 0.5}}
+	if len(exp.Contributions) != 2 || exp.Contributions[0] != want[0] || exp.Contributions[1] != want[1] {
+		t.Errorf("Expected contributions %+v, got %+v", want, exp.Contributions)
+	}
+
+	// Weighed down, a less confident anonymous source falls short.
+	other := evmAddress("anonymous-low")
+	ingestAs(t, agg, "", other, "agent-A", 0.8)
+	if exp := agg.explain("", other); exp.Contributions[0].Capped != 0.4 {
+		t.Errorf("Expected an anonymous report of 0.8 to contribute 0.4, got %+v", exp.Contributions)
+	}
+}
+
+func TestOneTrustedReportPromotes(t *testing.T) {
+	agg := newTierAggregator(t)
+	var promoted []Event
+	agg.events.handle(func(_ context.Context, events []Event) { promoted = append(promoted, events...) }, EventPromoted)
+
+	rec := adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/source-tiers", `{"key":"curated-feed","reason":"curated feed"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"tier":"trusted"`) {
+		t.Fatalf("Expected the grant accepted, got %d %s", rec.Code, rec.Body)
+	}
+
+	unsure := evmAddress("unsure")
+	if res := ingestAs(t, agg, "feed-secret", unsure, "feed", 0.5); res.AddedToFilter {
+		t.Errorf("Expected a trusted report below trusted_confidence not to promote alone, got %+v", res)
+	}
+	addr := evmAddress("trusted")
+	res := ingestAs(t, agg, "feed-secret", addr, "feed", 0.95)
+	if !res.AddedToFilter || res.ReasonCode != reasonInFilter || !agg.bloomFilter.Contains(addr) {
+		t.Fatalf("Expected one trusted report to promote, got %+v", res)
+	}
+	if sum, ok := agg.twab.Summary(addr); !ok || sum.ReportCount != 1 {
+		t.Errorf("Expected the trusted report recorded in TWAB, got %+v", sum)
+	}
+	if exp := agg.explain("", addr); !exp.MeetsThreshold || exp.Tiers[SourceTrusted] != 1 {
+		t.Errorf("Expected the explanation to meet the threshold on a trusted report, got %+v", exp)
+	}
+	if len(promoted) != 1 || promoted[0].Address != addr || promoted[0].SourceTier != SourceTrusted {
+		t.Errorf("Expected a promotion event of tier trusted, got %+v", promoted)
+	}
+
+	page := queryAudit(t, agg, "?action=source_tier_grant")
+	if len(page.Events) != 1 || page.Events[0].Actor != "ops" || page.Events[0].Subject != "curated-feed" || page.Events[0].Reason != "curated feed" {
+		t.Errorf("Expected the grant audited, got %+v", page.Events)
+	}
+	var list struct {
+		Trusted []TrustGrant `json:"trusted"`
+	}
+	json.NewDecoder(adminRequest(t, agg, "ops-secret", http.MethodGet, "/admin/source-tiers", "").Body).Decode(&list)
+	if len(list.Trusted) != 1 || list.Trusted[0].Key != "curated-feed" || list.Trusted[0].GrantedBy != "ops" {
+		t.Errorf("Expected the grant listed, got %+v", list.Trusted)
+	}
+}
+
+func TestDemotedKeyLosesTrust(t *testing.T) {
+	agg := newTierAggregator(t)
+	adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/source-tiers", `{"key":"curated-feed"}`)
+	rec := adminRequest(t, agg, "oncall-secret", http.MethodDelete, "/admin/source-tiers?key=curated-feed&reason=compromised", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"changed":true`) {
+		t.Fatalf("Expected the demotion accepted, got %d %s", rec.Code, rec.Body)
+	}
+
+	addr := evmAddress("after-demotion")
+	if res := ingestAs(t, agg, "feed-secret", addr, "feed", 1); res.AddedToFilter {
+		t.Errorf("Expected a demoted key's report not to promote alone, got %+v", res)
+	}
+	if exp := agg.explain("", addr); exp.Tiers[SourceRegistered] != 1 {
+		t.Errorf("Expected the report recorded as registered, got %v", exp.Tiers)
+	}
+	page := queryAudit(t, agg, "?action=source_tier_revoke")
+	if len(page.Events) != 1 || page.Events[0].Actor != "oncall" || page.Events[0].Reason != "compromised" {
+		t.Errorf("Expected the demotion audited, got %+v", page.Events)
+	}
+
+	// Revoking a key drops its grant with it.
+	adminRequest(t, agg, "ops-secret", http.MethodPost, "/admin/source-tiers", `{"key":"curated-feed"}`)
+	agg.RevokeAPIKey("curated-feed")
+	if len(agg.trust.list()) != 0 {
+		t.Errorf("Expected a revoked key's grant dropped, got %+v", agg.trust.list())
+	}
+}
+
+func TestSourceTierGrantValidation(t *testing.T) {
+	agg := newTierAggregator(t)
+	for _, tc := range []struct {
+		secret, body string
+		want         int
+	}{
+		{"feed-secret", `{"key":"curated-feed"}`, http.StatusForbidden}, // a reporter cannot trust itself
+		{"ops-secret", `{"key":"nobody"}`, http.StatusNotFound},
+		{"ops-secret", `{"key":"wallet"}`, http.StatusUnprocessableEntity},
+		{"ops-secret", `{}`, http.StatusBadRequest},
+	} {
+		if rec := adminRequest(t, agg, tc.secret, http.MethodPost, "/admin/source-tiers", tc.body); rec.Code != tc.want {
+			t.Errorf("%s %s: expected %d, got %d %s", tc.secret, tc.body, tc.want, rec.Code, rec.Body)
+		}
+	}
+	if n := len(agg.trust.list()); n != 0 {
+		t.Errorf("Expected no grants, got %d", n)
+	}
+
+	cfg := DefaultConfig()
+	cfg.TWAB.TrustedConfidence = 1.5
+	cfg.TWAB.TierWeights = map[SourceTier]float64{"vip": 2, SourceAnonymous: -1}
+	err := cfg.Validate()
+	for _, want := range []string{"twab.trusted_confidence", "unknown source tier", "twab.tier_weights.anonymous"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %s rejected, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+// Package swarm — Server-Sent Events transport for filter pushes.
+//
+// GET /sse/filter streams what /ws sends to clients with nothing more than
+// an EventSource, such as browser dashboards and simple scripts.  Each
+// envelope is one event named by its kind, "snapshot" or "delta", whose id
+// is the filter version it brings the client to, suffixed "@epoch" (see
+// epoch.go) and prefixed "instance:" with replication.  push.chunk_size does not apply, since events have no size
+// limit.
+//
+// A reconnecting EventSource sends the last id back as Last-Event-ID,
+// which resumes from it as ?last_version does on /ws (see resume.go); an
+// id from another instance or epoch is answered with a resync snapshot.  The query
+// parameters, authentication and subscription limits are those of /ws,
+// including ?api_key= since an EventSource cannot set headers.  With
+// ?ack=1, acknowledgements go to POST /subscriptions/{id}/ack, id being
+// the X-Subscription-ID response header (see ack.go).  A ": ping" comment
+// every wsPingInterval keeps the connection alive through proxies.
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseFilterEventID reads the id of a filter event: a version, and
+// which instance and epoch numbered it if known.
+func parseFilterEventID(id string) (instance string, version uint64, epoch string, err error) {
+	instance, raw, ok := strings.Cut(id, ":")
+	if !ok {
+		instance, raw = "", id
+	}
+	raw, epoch, _ = strings.Cut(raw, "@")
+	version, err = strconv.ParseUint(raw, 10, 64)
+	return instance, version, epoch, err
+}
+
+// handleSSEFilter is the HTTP handler for GET /sse/filter.
+func (s *SwarmAggregator) handleSSEFilter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var instance, epoch string
+	var lastVersion uint64
+	resume := true
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		var err error
+		if instance, lastVersion, epoch, err = parseFilterEventID(id); err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid Last-Event-ID")
+			return
+		}
+	} else if r.URL.Query().Has("last_version") {
+		v, err := strconv.ParseUint(r.URL.Query().Get("last_version"), 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid last_version")
+			return
+		}
+		instance, lastVersion, epoch = r.URL.Query().Get("instance"), v, r.URL.Query().Get("epoch")
+	} else {
+		resume = false
+	}
+	foreign := instance != "" && instance != s.config.Replication.InstanceID || s.foreignEpoch(epoch)
+	fs, ok := s.openFilterStream(w, r, "sse", resume, foreign, lastVersion)
+	if !ok {
+		return
+	}
+	defer fs.close()
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Set("X-Subscription-ID", fs.id)
+	w.WriteHeader(http.StatusOK)
+
+	envelopes, err := fs.initial()
+	if err != nil {
+		s.logs.Printf(logSerializeFailure, fs.id, "Failed to serialize bloom filter for %s: %v", fs.id, err)
+		return
+	}
+	for _, data := range envelopes {
+		if !writeFilterEvent(w, rc, data) {
+			return
+		}
+		fs.sub.markSent(envelopeVersion(data), time.Now())
+	}
+	if rc.Flush() != nil {
+		return
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	redeliver, stop := s.redeliveries(fs)
+	defer stop()
+
+	for {
+		select {
+		case now := <-redeliver:
+			data, err := s.redelivery(fs, now)
+			if err != nil {
+				s.logs.Printf(logSerializeFailure, fs.id, "Failed to serialize bloom filter for %s: %v", fs.id, err)
+				return
+			}
+			if data == nil {
+				continue
+			}
+			if !writeFilterEvent(w, rc, data) || rc.Flush() != nil {
+				return
+			}
+			fs.sub.markSent(envelopeVersion(data), now)
+		case data, ok := <-fs.ch:
+			if !ok || !writeFilterEvent(w, rc, data) {
+				return
+			}
+			if len(fs.ch) == 0 && rc.Flush() != nil {
+				return
+			}
+		case <-ping.C:
+			rc.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeFilterEvent writes an envelope as one event, reporting whether the
+// stream is still usable.  Encoded JSON has no newlines, so the envelope
+// fits one data line.
+func writeFilterEvent(w http.ResponseWriter, rc *http.ResponseController, data []byte) bool {
+	var env struct {
+		Kind      string `json:"kind"`
+		Instance  string `json:"instance"`
+		Epoch     uint64 `json:"epoch"`
+		Version   uint64 `json:"version"`
+		ToVersion uint64 `json:"to_version"`
+	}
+	if json.Unmarshal(data, &env) != nil {
+		return false
+	}
+	id := strconv.FormatUint(max(env.Version, env.ToVersion), 10)
+	if env.Epoch != 0 {
+		id += "@" + strconv.FormatUint(env.Epoch, 10)
+	}
+	if env.Instance != "" {
+		id = env.Instance + ":" + id
+	}
+	rc.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	_, err := fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", id, env.Kind, data)
+	return err == nil
+}
@@ -0,0 +1,153 @@
+package swarm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/client"
+)
+
+// sseFrame is one event read off a stream.
+type sseFrame struct {
+	id, event string
+	env       FilterEnvelope
+}
+
+// readFilterEvents reads n events from an SSE stream, skipping comments.
+func readFilterEvents(t *testing.T, r *bufio.Reader, n int) []sseFrame {
+	t.Helper()
+	var frames []sseFrame
+	var frame sseFrame
+	var data string
+	for len(frames) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected %d events, read %d: %v", n, len(frames), err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			frame.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			frame.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && data != "":
+			if err := json.Unmarshal([]byte(data), &frame.env); err != nil {
+				t.Fatal(err)
+			}
+			frames = append(frames, frame)
+			frame, data = sseFrame{}, ""
+		}
+	}
+	return frames
+}
+
+// openSSEFilter opens GET /sse/filter, closing it when the test ends.
+func openSSEFilter(t *testing.T, ctx context.Context, srv *httptest.Server, query string, header http.Header) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/sse/filter"+query, nil)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestSSEFilterStreamsSnapshotThenPushes(t *testing.T) {
+	agg := NewSwarmAggregator()
+	header := addTestSubscriber(agg)
+	blockAll(agg, evmAddress("first"))
+	srv := httptest.NewServer(agg.Routes())
+	t.Cleanup(srv.Close) // after the streams opened below are closed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp := openSSEFilter(t, ctx, srv, "", header)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" || !strings.HasPrefix(resp.Header.Get("X-Subscription-ID"), "sse-siem-") {
+		t.Fatalf("Expected an event stream, got %d %v", resp.StatusCode, resp.Header)
+	}
+	r := bufio.NewReader(resp.Body)
+	frames := readFilterEvents(t, r, 1)
+	at := "@" + strconv.FormatUint(agg.Identity().Epoch, 10)
+	if frames[0].id != "1"+at || frames[0].event != envelopeSnapshot || frames[0].env.Version != 1 {
+		t.Fatalf("Expected the v1 snapshot first, got %+v", frames[0])
+	}
+	data, _ := json.Marshal(frames[0].env)
+	if err := client.VerifyFilterPayload(agg.signer.Active().Public(), data); err != nil {
+		t.Errorf("Snapshot failed verification: %v", err)
+	}
+
+	blockAll(agg, evmAddress("second"))
+	if frames = readFilterEvents(t, r, 1); frames[0].id != "2"+at || frames[0].event != frames[0].env.Kind {
+		t.Errorf("Expected the push to v2 named by its kind, got %+v", frames[0])
+	}
+
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for agg.subscribers.len() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := agg.subscribers.len(); n != 0 {
+		t.Errorf("Expected the subscription dropped when the client went away, %d left", n)
+	}
+}
+
+func TestSSEFilterResumesFromLastEventID(t *testing.T) {
+	agg := NewSwarmAggregator()
+	header := addTestSubscriber(agg)
+	blockAll(agg, evmAddress("one"), evmAddress("two"), evmAddress("three")) // v3
+	srv := httptest.NewServer(agg.Routes())
+	t.Cleanup(srv.Close) // after the streams opened below are closed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	at := "@" + strconv.FormatUint(agg.Identity().Epoch, 10)
+	withID := func(id string) http.Header {
+		h := header.Clone()
+		h.Set("Last-Event-ID", id)
+		return h
+	}
+
+	frames := readFilterEvents(t, bufio.NewReader(openSSEFilter(t, ctx, srv, "?last_version=0", withID("1")).Body), 1)
+	if f := frames[0]; f.event != envelopeDelta || f.id != "3"+at || f.env.FromVersion != 1 {
+		t.Errorf("Expected Last-Event-ID to resume with a delta from v1, got %+v", f)
+	}
+	frames = readFilterEvents(t, bufio.NewReader(openSSEFilter(t, ctx, srv, "?last_version=2", header).Body), 1)
+	if f := frames[0]; f.event != envelopeDelta || f.env.FromVersion != 2 {
+		t.Errorf("Expected ?last_version to resume with a delta from v2, got %+v", f)
+	}
+	frames = readFilterEvents(t, bufio.NewReader(openSSEFilter(t, ctx, srv, "", withID("peer-b:1")).Body), 1)
+	if f := frames[0]; f.event != envelopeSnapshot || !f.env.Resync || f.id != "3"+at {
+		t.Errorf("Expected an id from another instance answered with a resync snapshot, got %+v", f)
+	}
+	frames = readFilterEvents(t, bufio.NewReader(openSSEFilter(t, ctx, srv, "", withID("2"+at)).Body), 1)
+	if f := frames[0]; f.event != envelopeDelta || f.env.FromVersion != 2 {
+		t.Errorf("Expected an id of this epoch to resume with a delta, got %+v", f)
+	}
+	frames = readFilterEvents(t, bufio.NewReader(openSSEFilter(t, ctx, srv, "", withID("2@1")).Body), 1)
+	if f := frames[0]; f.event != envelopeSnapshot || !f.env.Resync {
+		t.Errorf("Expected an id from another epoch answered with a resync snapshot, got %+v", f)
+	}
+
+	if resp := openSSEFilter(t, ctx, srv, "", withID("latest")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a malformed Last-Event-ID, got %d", resp.StatusCode)
+	}
+	if resp := openSSEFilter(t, ctx, srv, "", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a key, got %d", resp.StatusCode)
+	}
+	frames = readFilterEvents(t, bufio.NewReader(openSSEFilter(t, ctx, srv, "?format=exact", header).Body), 1)
+	if f := frames[0]; f.event != envelopeExact || f.env.Version != 3 {
+		t.Errorf("Expected the exact format for a subscriber key as on /ws, got %+v", f)
+	}
+}
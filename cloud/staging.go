@@ -0,0 +1,299 @@
+// Package swarm — Staging tier for new promotions.
+//
+// With staging.enabled, an address that meets consensus does not enter
+// the main filter straight away.  It is held in a staging filter of its
+// own, with its own version, for staging.soak_period (an hour by
+// default), and graduates to the main filter on the first maintenance
+// tick after that.  Unblocking or allowlisting a staged address cancels
+// its graduation; each such save is logged, audited, published as a
+// retracted event (see events.go), and counted in
+// aegis_staging_saves_total.
+//
+// Subscribers choose a tier with /ws?tier= (and GET /filter?tier=):
+// main, the default, is sent the main filter only; staging is sent the
+// staging filter only; both is sent the two merged, its version the sum
+// of theirs.  Canary clients subscribe to both.  Staging subscriptions
+// are only ever sent snapshots, and namespaced keys cannot ask for one.
+// GET /check reports the tier an address is in.
+//
+// Staged addresses are not persisted or replicated; after a restart they
+// are staged again by their next report.
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SubscriptionTier selects the filter a subscription is sent.
+type SubscriptionTier string
+
+const (
+	TierMain    SubscriptionTier = "main"
+	TierStaging SubscriptionTier = "staging"
+	TierBoth    SubscriptionTier = "both"
+)
+
+func (t SubscriptionTier) valid() bool {
+	switch t {
+	case "", TierMain, TierStaging, TierBoth:
+		return true
+	}
+	return false
+}
+
+// Reasons a staged address was saved from graduating, recorded in
+// aegis_staging_saves_total.
+const (
+	stagingSaveUnblocked   = "unblocked"
+	stagingSaveAllowlisted = "allowlisted"
+)
+
+// StagingConfig holds new promotions back from the main filter for
+// SoakPeriod.
+type StagingConfig struct {
+	Enabled    bool     `json:"enabled" yaml:"enabled"`
+	SoakPeriod Duration `json:"soak_period" yaml:"soak_period"`
+}
+
+// stagedEntry is an address soaking in the staging filter.
+type stagedEntry struct {
+	report      IOCReport
+	stagedAt    time.Time
+	graduatesAt time.Time
+}
+
+// stagingTier is the staging filter and the subscribers sent it.
+type stagingTier struct {
+	filter      *BloomFilter
+	subscribers *subscriberSet // tier staging
+	both        *subscriberSet // tier both
+
+	pushMu      sync.Mutex
+	pushPending bool // a debounced push is scheduled
+}
+
+func newStagingTier(cfg Config, logs *logSampler) *stagingTier {
+	if !cfg.Staging.Enabled {
+		return nil
+	}
+	return &stagingTier{
+		filter:      NewBloomFilterWithConfig(cfg.Bloom, 0),
+		subscribers: newSubscriberSet(logs),
+		both:        newSubscriberSet(logs),
+	}
+}
+
+// requestTier reads the tier parameter of a request from namespace ns.
+// An invalid tier, or a staging one without staging.enabled or from a
+// namespaced key, is answered with 400 and ok false.
+func (s *SwarmAggregator) requestTier(w http.ResponseWriter, r *http.Request, ns string) (SubscriptionTier, bool) {
+	tier := SubscriptionTier(r.URL.Query().Get("tier"))
+	switch {
+	case !tier.valid():
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid tier, want main, staging or both")
+		return "", false
+	case tier == "" || tier == TierMain:
+		return TierMain, true
+	case s.staging == nil:
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Staging is not enabled")
+		return "", false
+	case ns != "":
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Namespaces have no staging tier")
+		return "", false
+	}
+	return tier, true
+}
+
+// tierSubscribers returns the subscribers of a tier.
+func (s *SwarmAggregator) tierSubscribers(tier SubscriptionTier) *subscriberSet {
+	switch {
+	case s.staging == nil:
+		return s.subscribers
+	case tier == TierStaging:
+		return s.staging.subscribers
+	case tier == TierBoth:
+		return s.staging.both
+	}
+	return s.subscribers
+}
+
+// tierSnapshot returns how to capture the filter of a tier.
+func (s *SwarmAggregator) tierSnapshot(tier SubscriptionTier) func() filterSnapshot {
+	switch {
+	case s.staging == nil:
+		return s.globalSnapshot
+	case tier == TierStaging:
+		return s.stagingSnapshot
+	case tier == TierBoth:
+		return s.bothSnapshot
+	}
+	return s.globalSnapshot
+}
+
+// stagingSnapshot captures the staging filter.
+func (s *SwarmAggregator) stagingSnapshot() filterSnapshot {
+	entries, version := s.staging.filter.Snapshot()
+	return filterSnapshot{version: version, entries: entries, params: s.staging.filter.Params(), scorer: s.ConsensusScore}
+}
+
+// bothSnapshot captures the main and staging filters merged.
+func (s *SwarmAggregator) bothSnapshot() filterSnapshot {
+	staged, stagedVersion := s.staging.filter.Snapshot()
+	main := s.FilterSnapshot()
+	return filterSnapshot{
+		version: main.Version + stagedVersion,
+		entries: mergeSorted(main.snap.entries, staged),
+		params:  s.bloomFilter.Params(),
+		scorer:  s.ConsensusScore,
+	}
+}
+
+// stageLocked holds a newly promoted address in staging.  It reports
+// whether the address is soaking, and fresh if it was only now staged;
+// an address whose soak period is over is left for the caller to
+// promote.  s.mu must be held.
+func (s *SwarmAggregator) stageLocked(report IOCReport, now time.Time) (soaking, fresh bool) {
+	if s.staging == nil {
+		return false, false
+	}
+	if st, ok := s.staged[report.Address]; ok {
+		return now.Before(st.graduatesAt), false
+	}
+	s.staged[report.Address] = &stagedEntry{
+		report:      report,
+		stagedAt:    now,
+		graduatesAt: now.Add(time.Duration(s.config.Staging.SoakPeriod)),
+	}
+	s.staging.filter.Add(report.Address)
+	return true, true
+}
+
+// unstageLocked drops an address from staging, reporting whether it was
+// there.  s.mu must be held.
+func (s *SwarmAggregator) unstageLocked(address string) bool {
+	if _, ok := s.staged[address]; !ok {
+		return false
+	}
+	delete(s.staged, address)
+	s.staging.filter.Remove(address)
+	return true
+}
+
+// stagedRecord returns a copy of the staging record for an address.
+func (s *SwarmAggregator) stagedRecord(address string) (stagedEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st, ok := s.staged[address]
+	if !ok {
+		return stagedEntry{}, false
+	}
+	return *st, true
+}
+
+// saveStaged cancels the graduation of a staged address, reporting
+// whether it was staged.
+func (s *SwarmAggregator) saveStaged(ctx context.Context, address, reason string) bool {
+	s.mu.Lock()
+	st, ok := s.staged[address]
+	if ok {
+		s.unstageLocked(address)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	now := s.clock.Now()
+	log.Printf("Staging saved %s from graduating: %s %s into its soak period", address, reason, now.Sub(st.stagedAt).Round(time.Second))
+	s.metrics.stagingSaves.WithLabelValues(reason).Inc()
+	s.auditSystem(AuditEvent{
+		Action:  AuditStagingSave,
+		Address: address,
+		Reason:  fmt.Sprintf("%s before graduating at %s", reason, st.graduatesAt.Format(time.RFC3339)),
+		Time:    now,
+	})
+	ev := reportEvent(EventRetracted, st.report)
+	ev.FromTier, ev.Reason, ev.Time = TierStaging, reason, now
+	s.events.publish(ctx, ev)
+	return true
+}
+
+// graduateStaged promotes every staged address whose soak period is over
+// into the main filter.
+func (s *SwarmAggregator) graduateStaged(ctx context.Context) TaskStats {
+	now := s.maintenance.clock.Now()
+	s.mu.RLock()
+	var due []IOCReport
+	var allowed []string // allowlisted other than through Allow
+	for address, st := range s.staged {
+		if s.allowlist[address] {
+			allowed = append(allowed, address)
+		} else if !now.Before(st.graduatesAt) {
+			due = append(due, st.report)
+		}
+	}
+	s.mu.RUnlock()
+
+	stats := TaskStats{Items: len(allowed)}
+	for _, address := range allowed {
+		s.saveStaged(ctx, address, stagingSaveAllowlisted)
+	}
+	for _, report := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		if s.promoteConsensus(ctx, report, now, false) {
+			s.metrics.stagingGraduations.Inc()
+		}
+		stats.Items++
+	}
+	return stats
+}
+
+// pushStaging pushes the staging tier to its subscribers.
+func (s *SwarmAggregator) pushStaging(ctx context.Context) {
+	if s.staging == nil {
+		return
+	}
+	s.schedulePush(ctx, &s.staging.pushMu, &s.staging.pushPending, func(ctx context.Context) {
+		s.pushTier(ctx, s.staging.subscribers, s.stagingSnapshot())
+		s.pushTier(ctx, s.staging.both, s.bothSnapshot())
+	})
+}
+
+// pushTier sends snap to the subscribers of a staging tier.
+func (s *SwarmAggregator) pushTier(ctx context.Context, ss *subscriberSet, snap filterSnapshot) {
+	if ss.len() == 0 {
+		return
+	}
+	_, span := s.tracer.Start(ctx, spanPush)
+	defer span.End()
+	msgs, err := s.broadcastPush(ss, snap, nil)
+	if err != nil {
+		span.RecordError(err)
+		return
+	}
+	span.SetAttributes(attrPayloadSize.Int(msgs.size(FormatBloom)))
+}
+
+// stagingHealth is the staging section of /health.
+type stagingHealth struct {
+	FilterSize    int      `json:"filter_size"`
+	FilterVersion uint64   `json:"filter_version"`
+	SoakPeriod    Duration `json:"soak_period"`
+	Subscribers   int      `json:"subscribers"`
+}
+
+func (s *SwarmAggregator) stagingHealth() stagingHealth {
+	return stagingHealth{
+		FilterSize:    s.staging.filter.Len(),
+		FilterVersion: s.staging.filter.Version(),
+		SoakPeriod:    s.config.Staging.SoakPeriod,
+		Subscribers:   s.staging.subscribers.len() + s.staging.both.len(),
+	}
+}
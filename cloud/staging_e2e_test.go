@@ -0,0 +1,157 @@
+package swarm_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+// newStagingAggregator starts a test aggregator promoting on one report
+// into the staging tier.
+func newStagingAggregator(t *testing.T) *aegistest.Aggregator {
+	return aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.TWAB = swarm.TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+		cfg.Staging.Enabled = true
+	}})
+}
+
+// promoteSeed reports the address derived from seed once, from a source
+// of its own, returning whether it went into the main filter.
+func promoteSeed(agg *aegistest.Aggregator, seed string, confidence float64) bool {
+	return agg.IngestReport(context.Background(), swarm.IOCReport{
+		Address: aegistest.Address(seed), ChainID: 1, Category: "drainer",
+		Confidence: confidence, Timestamp: agg.Clock.Now(), SourceID: "agent-" + seed,
+	})
+}
+
+// recvEnvelope returns the next push on ch.
+func recvEnvelope(t *testing.T, ch chan []byte) swarm.FilterEnvelope {
+	t.Helper()
+	select {
+	case data := <-ch:
+		var env swarm.FilterEnvelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			t.Fatalf("Decode push: %v", err)
+		}
+		return env
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a push")
+	}
+	return swarm.FilterEnvelope{}
+}
+
+func envelopeEntries(t *testing.T, env swarm.FilterEnvelope) string {
+	t.Helper()
+	var payload swarm.FilterPayload
+	if err := json.Unmarshal(env.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(payload.Entries, ",")
+}
+
+// stagedEntries returns the staging filter GET /filter?tier=staging serves.
+func stagedEntries(t *testing.T, agg *aegistest.Aggregator) []string {
+	t.Helper()
+	var payload swarm.FilterPayload
+	getJSON(t, agg, "/filter?tier=staging", &payload)
+	return payload.Entries
+}
+
+func TestStagingSoaksThenGraduates(t *testing.T) {
+	agg := newStagingAggregator(t)
+	ctx := context.Background()
+	staging := agg.SubscribeWithOptions("canary", swarm.SubscribeOptions{Tier: swarm.TierStaging})
+	both := agg.SubscribeWithOptions("canary-both", swarm.SubscribeOptions{Tier: swarm.TierBoth})
+	mainTier := agg.Subscribe("main")
+	defer agg.Unsubscribe("canary")
+	defer agg.Unsubscribe("canary-both")
+	defer agg.Unsubscribe("main")
+	agg.Block(ctx, swarm.AdminAction{Address: aegistest.Address("blocked"), ChainID: 1})
+	recvEnvelope(t, mainTier)
+	recvEnvelope(t, both)
+
+	address := aegistest.Address("soaking")
+	if promoteSeed(agg, "soaking", 0.9) {
+		t.Fatal("Expected the promotion held in staging")
+	}
+	if check(t, agg, address).Tier != string(swarm.TierStaging) {
+		t.Fatal("Expected the address in the staging filter only")
+	}
+	if got := envelopeEntries(t, recvEnvelope(t, staging)); got != address {
+		t.Errorf("Expected the staging tier pushed the staged address, got %q", got)
+	}
+	if env := recvEnvelope(t, both); !strings.Contains(envelopeEntries(t, env), address) || !strings.Contains(envelopeEntries(t, env), aegistest.Address("blocked")) {
+		t.Errorf("Expected the both tier pushed both filters merged, got %q", envelopeEntries(t, env))
+	}
+
+	agg.Clock.Advance(30 * time.Minute)
+	agg.RunMaintenance(ctx)
+	if check(t, agg, address).Tier != string(swarm.TierStaging) {
+		t.Fatal("Expected no graduation inside the soak period")
+	}
+
+	agg.Clock.Advance(time.Hour)
+	agg.RunMaintenance(ctx)
+	if check(t, agg, address).Tier != string(swarm.TierMain) || len(stagedEntries(t, agg)) != 0 {
+		t.Fatal("Expected the address graduated to the main filter")
+	}
+	if got := envelopeEntries(t, recvEnvelope(t, mainTier)); !strings.Contains(got, address) {
+		t.Errorf("Expected the main tier pushed the graduate, got %q", got)
+	}
+	if got := metric(t, agg, "aegis_staging_graduations_total"); got != 1 {
+		t.Errorf("Expected one graduation counted, got %v", got)
+	}
+}
+
+func TestStagingRetractionCancelsGraduation(t *testing.T) {
+	agg := newStagingAggregator(t)
+	ctx := context.Background()
+	promoteSeed(agg, "retracted", 0.9)
+	promoteSeed(agg, "allowed", 0.9)
+
+	if !agg.Unblock(ctx, aegistest.Address("retracted")) {
+		t.Error("Expected unblocking a staged address to succeed")
+	}
+	agg.Allow(ctx, aegistest.Address("allowed"))
+	if staged := stagedEntries(t, agg); len(staged) != 0 {
+		t.Errorf("Expected both saved addresses dropped from staging, got %v", staged)
+	}
+	if tier := check(t, agg, aegistest.Address("retracted")).Tier; tier != "" {
+		t.Errorf("Expected a saved address in no tier, got %q", tier)
+	}
+
+	agg.Clock.Advance(2 * time.Hour)
+	agg.RunMaintenance(ctx)
+	if agg.BloomFilterLen() != 0 {
+		t.Errorf("Expected nothing graduated, got %d entries", agg.BloomFilterLen())
+	}
+	for _, reason := range []string{"unblocked", "allowlisted"} {
+		if got := metric(t, agg, `aegis_staging_saves_total{reason="`+reason+`"}`); got != 1 {
+			t.Errorf("Expected one %s save counted, got %v", reason, got)
+		}
+	}
+}
+
+func TestStagingTierParameter(t *testing.T) {
+	agg := newStagingAggregator(t)
+	promoteSeed(agg, "staged", 0.9)
+	if staged := stagedEntries(t, agg); len(staged) != 1 || staged[0] != aegistest.Address("staged") {
+		t.Errorf("Expected the staging filter, got %v", staged)
+	}
+
+	unstaged := aegistest.StartTestAggregator(t, aegistest.Options{})
+	for _, c := range []struct {
+		agg  *aegistest.Aggregator
+		tier string
+	}{{agg, "canary"}, {unstaged, "staging"}} {
+		if status, _ := c.agg.Do(http.MethodGet, "/filter?tier="+c.tier, ""); status != http.StatusBadRequest {
+			t.Errorf("tier=%s: expected 400, got %d", c.tier, status)
+		}
+	}
+}
@@ -0,0 +1,583 @@
+// Package swarm — Warm standby failover.
+//
+// Two aggregators can run as an active and a warm standby, so the active
+// can be taken down for maintenance without losing state or promotions.
+// The standby, started with standby.active (--standby-of), connects to
+// the active's GET /internal/standby with a peer-role key and keeps an
+// up-to-date copy of its state in memory: the stream opens with the
+// state export format (see snapshot.go), which the standby imports along
+// with the active's identity, and carries on with one JSON line per
+// event the active publishes, in the event stream format (see
+// events.go), so the standby applies every accepted report, promotion,
+// removal and expiry as it happens and republishes it to its own
+// subscribers.  Only the main tier is followed; staged addresses come
+// over as they graduate.  A report_accepted event comes with the whole report,
+// numbered by the active's TWAB, and those numbered no later than the
+// exported copy are skipped, so none is counted twice.
+//
+// Feed imports, sanctions syncs, merges, replication from peers and
+// mirror updates publish an event per address they change, so they come
+// over like any promotion.  A state import publishes none: the active
+// closes its standby streams instead, and a standby whose stream is
+// closed, or that falls more than events.subscriber_buffer events
+// behind, reconnects and copies the state again.  Every few seconds the
+// active sends a heartbeat; a link with no line for three heartbeats is
+// taken as lost.
+//
+// The standby serves the subscribe routes from its copy and refuses
+// everything else, ingest, admin and replication alike, with 503 and
+// code standby, naming the active in the message and in X-Aegis-Active.
+// It does not expire entries, sync sanctions or consume the report bus
+// either; the active does, and the standby applies the outcome.
+//
+// POST /admin/promote-to-active, audited, makes the standby the active:
+// it drops the link, bumps its epoch so clients resync (see epoch.go),
+// pushes its filter as a rebuild, and from then on judges reports like
+// any aggregator.  With standby.failover_after set, it promotes itself
+// once the link has been down that long, provided it copied the active's
+// state at least once.  Promotion is one way; the old active rejoins as
+// the new standby by starting with standby.active pointing at this one.
+// GET /health reports the role and the link under standby.
+package swarm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// standbyPath is the internal endpoint a standby follows.
+	standbyPath = "/internal/standby"
+
+	// standbyPromotePath promotes a standby to active.
+	standbyPromotePath = "/admin/promote-to-active"
+
+	// standbyHeartbeat is how often the active writes a heartbeat, and
+	// standbyLinkTimeout how long the standby waits for any line.
+	standbyHeartbeat   = 2 * time.Second
+	standbyLinkTimeout = 3 * standbyHeartbeat
+)
+
+const (
+	// headerActive names the active in a standby's refusals.
+	headerActive = "X-Aegis-Active"
+
+	// headerStandbyRecorded carries the number of the last TWAB report
+	// the state at the head of a standby stream holds.
+	headerStandbyRecorded = "X-Aegis-TWAB-Recorded"
+)
+
+// Roles reported under standby in GET /health.
+const (
+	standbyRoleStandby = "standby"
+	standbyRoleActive  = "active"
+)
+
+var (
+	// ErrNotStandby is returned for promoting an aggregator that was not
+	// started as a standby.
+	ErrNotStandby = errors.New("this aggregator is not a standby")
+
+	// ErrAlreadyActive is returned for promoting a standby twice.
+	ErrAlreadyActive = errors.New("this aggregator is already active")
+)
+
+// Enabled reports whether the aggregator starts as a standby.
+func (c StandbyConfig) Enabled() bool { return c.Active != "" }
+
+// StandbyReport is the report of a report_accepted event on a standby
+// stream, with what the server learnt of it.  Recorded numbers it in the
+// active's TWAB.
+type StandbyReport struct {
+	IOCReport
+	Recorded   uint64     `json:"recorded"`
+	ReceivedAt time.Time  `json:"received_at"`
+	Network    string     `json:"network,omitempty"`
+	SourceTier SourceTier `json:"source_tier,omitempty"`
+	Calibrated float64    `json:"calibrated,omitempty"`
+}
+
+// report returns the report as it was recorded.
+func (r StandbyReport) report() IOCReport {
+	report := r.IOCReport
+	report.ReceivedAt, report.Network = r.ReceivedAt, r.Network
+	report.SourceTier, report.Calibrated = r.SourceTier, r.Calibrated
+	return report
+}
+
+// StandbyHeartbeat is the active's periodic record of a standby stream.
+type StandbyHeartbeat struct {
+	At time.Time `json:"at"`
+}
+
+// StandbyRecord is every line of a standby stream after the state: an
+// event, with its report for report_accepted, or a heartbeat.
+type StandbyRecord struct {
+	Event     *Event            `json:"event,omitempty"`
+	Report    *StandbyReport    `json:"report,omitempty"`
+	Heartbeat *StandbyHeartbeat `json:"heartbeat,omitempty"`
+}
+
+// StandbyHealth is the standby object of GET /health.
+type StandbyHealth struct {
+	Role          string     `json:"role"`
+	Active        string     `json:"active"`
+	Connected     bool       `json:"connected"`
+	Synced        bool       `json:"synced"` // copied the active's state at least once
+	LastRecordAt  *time.Time `json:"last_record_at,omitempty"`
+	LinkDownSince *time.Time `json:"link_down_since,omitempty"`
+	PromotedAt    *time.Time `json:"promoted_at,omitempty"`
+	PromotedBy    string     `json:"promoted_by,omitempty"`
+}
+
+// standbyState tracks the link of a standby to its active.
+type standbyState struct {
+	config    StandbyConfig
+	activated chan struct{} // closed on promotion
+
+	// applying is held while a record is applied, and by promotion, so
+	// no record lands after the standby turns active.
+	applying sync.Mutex
+
+	mu         sync.Mutex
+	promotedAt time.Time // zero while a standby
+	promotedBy string
+	connected  bool
+	synced     bool
+	lastRecord time.Time
+	downSince  time.Time // zero while connected
+	cancel     context.CancelFunc
+}
+
+func newStandbyState(cfg StandbyConfig, now time.Time) *standbyState {
+	if !cfg.Enabled() {
+		return nil
+	}
+	return &standbyState{config: cfg, activated: make(chan struct{}), downSince: now}
+}
+
+// passive reports whether the aggregator is a standby not yet promoted.
+func (st *standbyState) passive() bool {
+	if st == nil {
+		return false
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.promotedAt.IsZero()
+}
+
+// health reports the role and the link.
+func (st *standbyState) health() StandbyHealth {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	h := StandbyHealth{Role: standbyRoleStandby, Active: st.config.Active, Connected: st.connected, Synced: st.synced}
+	if !st.lastRecord.IsZero() {
+		last := st.lastRecord
+		h.LastRecordAt = &last
+	}
+	if !st.promotedAt.IsZero() {
+		promoted := st.promotedAt
+		h.Role, h.PromotedAt, h.PromotedBy = standbyRoleActive, &promoted, st.promotedBy
+		return h
+	}
+	if !st.downSince.IsZero() {
+		down := st.downSince
+		h.LinkDownSince = &down
+	}
+	return h
+}
+
+// handleStandbyStream is the HTTP handler for GET /internal/standby: the
+// state, then the events and heartbeats, until the standby goes away or
+// falls too far behind.
+func (s *SwarmAggregator) handleStandbyStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	// Subscribed before the copy, so no event falls between the two.
+	sub := s.events.subscribeStandby(auditActor(r))
+	defer s.events.unsubscribe(sub)
+	st := s.exportState(s.clock.Now())
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set(headerStandbyRecorded, strconv.FormatUint(st.recorded, 10))
+	bw := bufio.NewWriter(w)
+	if err := writeState(bw, st); err != nil {
+		log.Printf("Standby stream to %s aborted: %v", auditActor(r), err)
+		return
+	}
+	log.Printf("Streaming state to standby %s: %d confirmed", auditActor(r), len(st.confirmed))
+
+	ticker := time.NewTicker(standbyHeartbeat)
+	defer ticker.Stop()
+	enc := json.NewEncoder(bw)
+	for {
+		if bw.Flush() != nil || rc.Flush() != nil {
+			return
+		}
+		var rec StandbyRecord
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-sub.ch:
+			if !ok {
+				log.Printf("Standby %s fell behind the event stream or the state was replaced; closing it to resync", auditActor(r))
+				return
+			}
+			rec.Event, rec.Report = &ev, ev.report
+		case <-ticker.C:
+			rec.Heartbeat = &StandbyHeartbeat{At: s.clock.Now()}
+		}
+		if err := enc.Encode(rec); err != nil {
+			return
+		}
+	}
+}
+
+// StartStandby follows the active until the aggregator is promoted or
+// ctx is done, reconnecting with backoff and promoting itself after
+// standby.failover_after without a link.
+func (s *SwarmAggregator) StartStandby(ctx context.Context) {
+	go s.runStandby(ctx)
+}
+
+func (s *SwarmAggregator) runStandby(ctx context.Context) {
+	cfg := s.standby.config
+	backoff := replicationMinBackoff
+	for ctx.Err() == nil && s.standby.passive() {
+		synced, err := s.followActive(ctx)
+		if !s.standby.passive() || ctx.Err() != nil {
+			return
+		}
+		now := s.clock.Now()
+		s.standby.mu.Lock()
+		s.standby.connected = false
+		if s.standby.downSince.IsZero() {
+			s.standby.downSince = now
+		}
+		down, everSynced := now.Sub(s.standby.downSince), s.standby.synced
+		s.standby.mu.Unlock()
+		log.Printf("Standby link to %s lost: %v", cfg.Active, err)
+
+		if synced {
+			backoff = replicationMinBackoff
+		}
+		wait := backoff
+		if failover := time.Duration(cfg.FailoverAfter); failover > 0 && everSynced {
+			if down >= failover {
+				s.auditSystem(AuditEvent{Action: AuditPromoteToActive, Subject: cfg.Active, Reason: "link down for " + down.String()})
+				if _, err := s.PromoteToActive(ctx, auditActorSystem); err != nil {
+					log.Printf("Standby failover failed: %v", err)
+				}
+				return
+			}
+			wait = min(wait, failover-down)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.standby.activated:
+			return
+		case <-time.After(wait):
+		}
+		backoff = min(backoff*2, replicationMaxBackoff)
+	}
+}
+
+// followActive copies the active's state and applies its stream until
+// the link fails.  It reports whether the state was copied.
+func (s *SwarmAggregator) followActive(ctx context.Context) (bool, error) {
+	cfg := s.standby.config
+	linkCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.standby.mu.Lock()
+	s.standby.cancel = cancel
+	s.standby.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(linkCtx, http.MethodGet, cfg.Active+standbyPath, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.APIKey)
+	watchdog := time.AfterFunc(standbyLinkTimeout, cancel)
+	defer watchdog.Stop()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("active returned %s", resp.Status)
+	}
+	copied, err := strconv.ParseUint(resp.Header.Get(headerStandbyRecorded), 10, 64)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s header: %w", headerStandbyRecorded, err)
+	}
+	br := bufio.NewReader(resp.Body)
+	st, err := readStateStream(br)
+	if err != nil {
+		return false, err
+	}
+	watchdog.Reset(standbyLinkTimeout)
+	if err := s.syncFromActive(ctx, st); err != nil {
+		return false, err
+	}
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil {
+			return true, err
+		}
+		watchdog.Reset(standbyLinkTimeout)
+		var rec StandbyRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return true, fmt.Errorf("standby record: %w", err)
+		}
+		s.applyStandbyRecord(ctx, rec, copied)
+	}
+}
+
+// syncFromActive replaces the state with a copy of the active's,
+// adopting its identity.
+func (s *SwarmAggregator) syncFromActive(ctx context.Context, st exportedState) error {
+	s.standby.applying.Lock()
+	defer s.standby.applying.Unlock()
+	if !s.standby.passive() {
+		return ErrAlreadyActive
+	}
+	s.restoreIdentity(st.header)
+	if err := s.importState(ctx, st, true); err != nil {
+		return err
+	}
+	now := s.clock.Now()
+	s.standby.mu.Lock()
+	s.standby.connected, s.standby.synced = true, true
+	s.standby.downSince, s.standby.lastRecord = time.Time{}, now
+	s.standby.mu.Unlock()
+	log.Printf("Standby synced with %s: %d confirmed, filter v%d", s.standby.config.Active, len(st.confirmed), s.bloomFilter.Version())
+	return nil
+}
+
+// applyStandbyRecord applies one record of the active's stream.  Reports
+// numbered up to copied are already in the copied state.
+func (s *SwarmAggregator) applyStandbyRecord(ctx context.Context, rec StandbyRecord, copied uint64) {
+	s.standby.applying.Lock()
+	defer s.standby.applying.Unlock()
+	if !s.standby.passive() {
+		return // the link is being dropped
+	}
+	now := s.clock.Now()
+	s.standby.mu.Lock()
+	s.standby.lastRecord = now
+	s.standby.mu.Unlock()
+
+	if rec.Event == nil {
+		return // a heartbeat
+	}
+	ev := *rec.Event
+	switch ev.Type {
+	case EventReportAccepted:
+		if rec.Report == nil || rec.Report.Recorded <= copied {
+			return
+		}
+		s.applyStandbyReport(rec.Report.report())
+	case EventPromoted:
+		if ev.Tier != TierMain {
+			return
+		}
+		s.applyStandbyPromotion(ev)
+	case EventRemoved, EventExpired, EventRetracted:
+		if ev.FromTier != TierMain {
+			return
+		}
+		s.applyStandbyRemoval(ev)
+	default:
+		return
+	}
+	s.events.publish(ctx, ev)
+}
+
+// applyStandbyReport records a report the active accepted, refreshing
+// the expiry of a confirmed address it keeps at consensus as the active
+// does.
+func (s *SwarmAggregator) applyStandbyReport(report IOCReport) {
+	s.twab.Record(report.Address, report)
+	if !s.twab.MeetsThreshold(report.Address, s.current().TWAB) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.confirmed[report.Address]; ok {
+		s.refreshExpiryLocked(entry, report.ReceivedAt)
+	}
+}
+
+// applyStandbyPromotion adds an address the active promoted to its main
+// filter, blocked, or took from a feed, a merge, a peer or its upstream.
+func (s *SwarmAggregator) applyStandbyPromotion(ev Event) {
+	source := ev.Source
+	if source == "" {
+		source = provenanceConsensus
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.confirmed[ev.Address]; ok {
+		return
+	}
+	entry := &ConfirmedEntry{
+		Address:    ev.Address,
+		ChainID:    ev.ChainID,
+		Category:   ev.Category,
+		Confidence: ev.Confidence,
+		PromotedAt: ev.Time,
+		Provenance: Provenance{Source: source, Category: ev.Category, ImportedAt: ev.Time},
+	}
+	feed := strings.HasPrefix(source, feedSourceID(""))
+	if feed {
+		entry.Provenance.Mode = FeedTrusted
+	}
+	if source == provenanceAdmin { // as Block does
+		entry.Provenance.Reason = ev.Reason
+		delete(s.allowlist, ev.Address)
+		s.tripwire.remove(protectedAllowlist, ev.Address)
+		s.cooldowns.lift(ev.Address)
+	} else {
+		s.scheduleExpiryLocked(entry, ev.Time)
+	}
+	if feed || strings.HasPrefix(source, mergeSourceID("")) { // as ImportFeed and MergeFilter do
+		s.feedTags[ev.Address] = append(s.feedTags[ev.Address], entry.Provenance)
+	}
+	s.confirmed[ev.Address] = entry
+	s.filterAddLocked(entry)
+}
+
+// applyStandbyRemoval takes an address the active removed out of the
+// main filter, with the allowlisting, cooldown or forgotten history that
+// went with it there.
+func (s *SwarmAggregator) applyStandbyRemoval(ev Event) {
+	s.mu.Lock()
+	if entry, ok := s.confirmed[ev.Address]; ok {
+		delete(s.confirmed, ev.Address)
+		delete(s.feedTags, ev.Address)
+		s.filterRemoveLocked(entry)
+	}
+	if ev.Reason == eventReasonAllowlisted {
+		s.allowlist[ev.Address] = true
+		s.tripwire.add(protectedAllowlist, ev.Address)
+	}
+	s.mu.Unlock()
+	switch {
+	case ev.Type == EventExpired:
+		s.twab.Forget(ev.Address)
+		s.startCooldown(ev.Address, cooldownExpired, ev.Time)
+	case ev.Reason == eventReasonUnblocked:
+		s.startCooldown(ev.Address, cooldownUnblocked, ev.Time)
+	}
+}
+
+// PromoteToActive makes a standby the active, audited as actor: it drops
+// the link, bumps the epoch and pushes the filter as a rebuild.
+func (s *SwarmAggregator) PromoteToActive(ctx context.Context, actor string) (Identity, error) {
+	if s.standby == nil {
+		return Identity{}, ErrNotStandby
+	}
+	s.standby.applying.Lock()
+	now := s.clock.Now()
+	s.standby.mu.Lock()
+	if !s.standby.promotedAt.IsZero() {
+		s.standby.mu.Unlock()
+		s.standby.applying.Unlock()
+		return s.Identity(), ErrAlreadyActive
+	}
+	s.standby.promotedAt, s.standby.promotedBy, s.standby.connected = now, actor, false
+	if s.standby.cancel != nil {
+		s.standby.cancel()
+	}
+	s.standby.mu.Unlock()
+
+	previous := s.Identity()
+	next := newIdentity(now)
+	next.InstanceUUID, next.Epoch = previous.InstanceUUID, max(next.Epoch, previous.Epoch+1)
+	s.identity.Store(&next)
+	s.standby.applying.Unlock()
+	close(s.standby.activated)
+
+	if _, err := s.rebuildFilter(ctx, BloomParams{}); err != nil {
+		log.Printf("Failed to push the filter after promotion: %v", err)
+	}
+	log.Printf("Promoted to active by %s: epoch %d, following %s no more", actor, next.Epoch, s.standby.config.Active)
+	return next, nil
+}
+
+// whenActive calls fn once the aggregator is active: now, unless it is a
+// standby, or on its promotion.
+func (s *SwarmAggregator) whenActive(ctx context.Context, fn func()) {
+	if !s.standby.passive() {
+		fn()
+		return
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-s.standby.activated:
+			fn()
+		}
+	}()
+}
+
+// handleAdminPromoteToActive is the HTTP handler for POST
+// /admin/promote-to-active.
+func (s *SwarmAggregator) handleAdminPromoteToActive(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	switch {
+	case s.standby == nil:
+		writeAPIError(w, r, ErrNotStandby)
+		return
+	case !s.standby.passive():
+		writeAPIError(w, r, ErrAlreadyActive)
+		return
+	}
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditPromoteToActive, Subject: s.standby.config.Active}) {
+		return
+	}
+	identity, err := s.PromoteToActive(r.Context(), auditActor(r))
+	if err != nil {
+		writeAPIError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"role":           standbyRoleActive,
+		"instance_uuid":  identity.InstanceUUID,
+		"epoch":          identity.Epoch,
+		"filter_version": s.bloomFilter.Version(),
+	})
+}
+
+// standbyRefuses wraps a handler to refuse while the aggregator is a
+// standby.
+func (s *SwarmAggregator) standbyRefuses(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !s.standby.passive() {
+			next(w, r)
+			return
+		}
+		active := s.standby.config.Active
+		w.Header().Set(headerActive, active)
+		writeError(w, r, http.StatusServiceUnavailable, CodeStandby, fmt.Sprintf("This aggregator is a warm standby; send %s to the active at %s", r.URL.Path, active))
+	}
+}
@@ -0,0 +1,190 @@
+package swarm_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm"
+	"github.com/aegis-protocol/swarm/aegistest"
+)
+
+const peerSecret = "aegistest-standby-peer"
+
+// startStandbyPair starts an active aggregator and a standby following it,
+// with the standby's failover timeout set to failoverAfter.
+func startStandbyPair(t *testing.T, failoverAfter time.Duration) (active, standby *aegistest.Aggregator) {
+	active = aegistest.StartTestAggregator(t, aegistest.Options{
+		Configure: func(cfg *swarm.Config) {
+			cfg.Ingest.Synchronous = true
+			cfg.Listeners[0].Routes = append(cfg.Listeners[0].Routes, swarm.RouteReplication)
+		},
+		Keys: map[string]swarm.APIKey{peerSecret: {ID: "standby", Role: swarm.RolePeer}},
+	})
+	standby = aegistest.StartTestAggregator(t, aegistest.Options{Configure: func(cfg *swarm.Config) {
+		cfg.Ingest.Synchronous = true
+		cfg.Standby = swarm.StandbyConfig{Active: active.URL, APIKey: peerSecret, FailoverAfter: swarm.Duration(failoverAfter)}
+	}})
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	standby.StartStandby(ctx)
+	return active, standby
+}
+
+// standbyHealth returns the standby section of GET /health.
+func standbyHealth(t *testing.T, h *aegistest.Aggregator) swarm.StandbyHealth {
+	t.Helper()
+	var health struct{ Standby *swarm.StandbyHealth }
+	getJSON(t, h, "/health", &health)
+	if health.Standby == nil {
+		t.Fatal("Expected standby in /health")
+	}
+	return *health.Standby
+}
+
+// sameAddresses reports whether got and want list the same addresses.
+func sameAddresses(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStandbyTakesOverWithoutLosingConfirmed(t *testing.T) {
+	active, standby := startStandbyPair(t, 0)
+	ctx := context.Background()
+	copied := aegistest.Address("copied")
+	promoteOver(t, active, copied, "drainer")
+	waitFor(t, "the standby to sync", func() bool { return standbyHealth(t, standby).Synced })
+	if standby.Identity() != active.Identity() {
+		t.Errorf("Expected the standby to adopt the active's identity, got %+v and %+v", standby.Identity(), active.Identity())
+	}
+
+	// Promotions, a block, and an allowlisting stream over.
+	streamed, blocked, cleared := aegistest.Address("streamed"), aegistest.Address("blocked"), aegistest.Address("cleared")
+	promoteOver(t, active, streamed, "drainer")
+	promoteOver(t, active, cleared, "drainer")
+	active.Block(ctx, swarm.AdminAction{Address: blocked, ChainID: 1, Category: "phishing", Reason: "incident"})
+	active.Allow(ctx, cleared)
+	active.Report(swarm.IOCReport{Address: copied, Category: "drainer", SourceID: "agent-C"})
+	want := active.ConfirmedAddresses()
+	if len(want) != 3 {
+		t.Fatalf("Expected three confirmed on the active, got %v", want)
+	}
+	waitFor(t, "the standby to catch up", func() bool { return sameAddresses(standby.ConfirmedAddresses(), want) })
+	if !check(t, standby, blocked).Flagged || check(t, standby, cleared).Flagged {
+		t.Error("Expected the standby's filter to follow the active's")
+	}
+	waitFor(t, "the late report", func() bool {
+		d, _ := twabDetail(t, standby, copied)
+		return d.ReportCount == 4
+	})
+	if d, _ := twabDetail(t, standby, streamed); d.ReportCount != 3 {
+		t.Errorf("Expected each streamed report recorded once, got %d", d.ReportCount)
+	}
+
+	// A standby refuses writes and points at the active.
+	req, _ := http.NewRequest(http.MethodPost, standby.URL+"/ingest", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("X-Aegis-Active") != active.URL {
+		t.Errorf("Expected ingest refused with the active named, got %d %q", resp.StatusCode, resp.Header.Get("X-Aegis-Active"))
+	}
+	if code, _ := standby.Do(http.MethodGet, "/check?address="+blocked, ""); code != http.StatusOK {
+		t.Errorf("Expected the standby to serve checks, got %d", code)
+	}
+
+	active.Kill()
+	before := standby.Identity()
+	code, data := standby.Do(http.MethodPost, "/admin/promote-to-active", "")
+	if code != http.StatusOK {
+		t.Fatalf("Promotion failed: %d %s", code, data)
+	}
+	var promoted struct {
+		Role  string `json:"role"`
+		Epoch uint64 `json:"epoch"`
+	}
+	json.Unmarshal(data, &promoted)
+	if promoted.Role != "active" || promoted.Epoch <= before.Epoch || standby.Identity().InstanceUUID != before.InstanceUUID {
+		t.Errorf("Expected the epoch bumped under the same instance, got %s after %+v", data, before)
+	}
+	if got := standby.ConfirmedAddresses(); len(got) != len(want) {
+		t.Errorf("Expected no confirmed address lost, got %v want %v", got, want)
+	}
+
+	// Now active, it judges reports itself.
+	fresh := aegistest.Address("fresh")
+	promoteOver(t, standby, fresh, "drainer")
+	if _, ok := standby.Confirmed(fresh); !ok {
+		t.Error("Expected the promoted standby to promote by consensus")
+	}
+	if code, _ := standby.Do(http.MethodPost, "/admin/promote-to-active", ""); code != http.StatusConflict {
+		t.Errorf("Expected a second promotion refused with 409, got %d", code)
+	}
+	if h := standbyHealth(t, standby); h.Role != "active" || h.PromotedBy != "aegistest-admin" {
+		t.Errorf("Unexpected standby health %+v", h)
+	}
+}
+
+func TestStandbyFollowsBulkChangesOfTheSameSize(t *testing.T) {
+	active, standby := startStandbyPair(t, 0)
+	ctx := context.Background()
+	swapped := aegistest.Address("swapped")
+	promoteOver(t, active, swapped, "drainer")
+	waitFor(t, "the standby to sync", func() bool { return standbyHealth(t, standby).Synced })
+	_, exported := active.Do(http.MethodGet, "/admin/snapshot/export", "")
+
+	// An unblock and a trusted import leave the confirmed count as it was.
+	imported := aegistest.Address("imported")
+	active.Unblock(ctx, swapped)
+	if _, err := active.ImportFeed(ctx, "ofac", swarm.FeedTrusted, []swarm.FeedEntry{{Address: imported, ChainID: 1, Category: "sanctions", Confidence: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the imported address", func() bool { return sameAddresses(standby.ConfirmedAddresses(), []string{imported}) })
+	if entry, _ := standby.Confirmed(imported); entry.Provenance.Source != "feed:ofac" || entry.Provenance.Mode != swarm.FeedTrusted {
+		t.Errorf("Expected the feed's provenance on the standby, got %+v", entry.Provenance)
+	}
+
+	// A state import publishes no events; the standby copies it again.
+	if status, data := active.Do(http.MethodPost, "/admin/snapshot/import?force=1", string(exported)); status != http.StatusOK {
+		t.Fatalf("Import failed: %d %s", status, data)
+	}
+	waitFor(t, "the imported state", func() bool { return sameAddresses(standby.ConfirmedAddresses(), []string{swapped}) })
+}
+
+func TestStandbyFailsOverAfterLinkLoss(t *testing.T) {
+	active, standby := startStandbyPair(t, time.Second)
+	promoteOver(t, active, aegistest.Address("kept"), "drainer")
+	waitFor(t, "the standby to sync", func() bool { return len(standby.ConfirmedAddresses()) == 1 })
+	if code, _ := standby.Do(http.MethodPost, "/admin/block", `{"address":"`+aegistest.Address("refused")+`"}`); code != http.StatusServiceUnavailable {
+		t.Errorf("Expected admin writes refused on a standby, got %d", code)
+	}
+
+	active.Kill()
+	waitFor(t, "the link to drop", func() bool { return !standbyHealth(t, standby).Connected })
+	standby.Clock.Advance(2 * time.Second)
+	waitFor(t, "the failover", func() bool { return standbyHealth(t, standby).Role == "active" })
+	if h := standbyHealth(t, standby); h.PromotedBy != "system" {
+		t.Errorf("Expected the standby promoted by the system, got %+v", h)
+	}
+	if got := standby.ConfirmedAddresses(); len(got) != 1 {
+		t.Errorf("Expected the confirmed address kept, got %v", got)
+	}
+}
+
+func TestPromoteToActiveNeedsAStandby(t *testing.T) {
+	h := aegistest.StartTestAggregator(t, aegistest.Options{})
+	if code, _ := h.Do(http.MethodPost, "/admin/promote-to-active", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 on an aggregator that is not a standby, got %d", code)
+	}
+}
@@ -0,0 +1,314 @@
+// Package swarm — Time-windowed consensus statistics.
+//
+// IngestReport and the promotion path feed a ring of five-minute buckets
+// covering the last seven days.  Recording never takes a lock: each bucket
+// is a set of atomic counters, a slot is recycled by atomically swapping
+// in a fresh bucket when its time comes round again, and per-chain and
+// per-category counters live in a sync.Map, which only locks the first
+// time a key is seen.  Unique addresses are estimated with a HyperLogLog
+// sketch per bucket, merged across the window when queried.  Only the
+// global swarm is counted, so tenant namespaces leak nothing into GET
+// /stats.
+package swarm
+
+import (
+	"encoding/json"
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	statsBucketWidth = 5 * time.Minute
+	statsRetention   = 7 * 24 * time.Hour
+	statsBuckets     = int(statsRetention / statsBucketWidth)
+
+	// defaultStatsWindow is the window served when none is requested.
+	defaultStatsWindow = 24 * time.Hour
+
+	// statsTopN bounds the top chains and categories in a response.
+	statsTopN = 10
+)
+
+// Unique-address sketch parameters: 2^8 registers estimate within about
+// 6.5%.
+const (
+	hllPrecision = 8
+	hllRegisters = 1 << hllPrecision
+)
+
+// ttcBins is the number of time-to-consensus histogram bins.  Bin 0 holds
+// durations under a second and bin i those in [2^(i-1), 2^i) seconds; the
+// last bin is open-ended (about 97 days and up).
+const ttcBins = 24
+
+// hllSeed is the process-wide seed for hashing addresses into sketches.
+var hllSeed = maphash.MakeSeed()
+
+// statsBucket holds the counters for one bucket-width of time.
+type statsBucket struct {
+	index int64 // start time / statsBucketWidth
+
+	reports    atomic.Int64
+	promotions atomic.Int64
+	chains     sync.Map // int -> *atomic.Int64
+	categories sync.Map // string -> *atomic.Int64
+	ttc        [ttcBins]atomic.Int64
+
+	// Eight one-byte HyperLogLog registers per word.
+	sketch [hllRegisters / 8]atomic.Uint64
+}
+
+// consensusStats is the ring of buckets behind GET /stats.
+type consensusStats struct {
+	slots [statsBuckets]atomic.Pointer[statsBucket]
+}
+
+func newConsensusStats() *consensusStats {
+	return &consensusStats{}
+}
+
+// bucket returns the bucket covering now, rotating its slot if it still
+// holds an older bucket.  It returns nil when now falls in a bucket older
+// than the slot's, as happens after the clock steps back.
+func (c *consensusStats) bucket(now time.Time) *statsBucket {
+	index := now.UnixNano() / int64(statsBucketWidth)
+	slot := &c.slots[index%int64(statsBuckets)]
+	for {
+		b := slot.Load()
+		switch {
+		case b != nil && b.index == index:
+			return b
+		case b != nil && b.index > index:
+			return nil
+		}
+		if fresh := (&statsBucket{index: index}); slot.CompareAndSwap(b, fresh) {
+			return fresh
+		}
+	}
+}
+
+// recordReport counts one ingested report.
+func (c *consensusStats) recordReport(report IOCReport, now time.Time) {
+	b := c.bucket(now)
+	if b == nil {
+		return
+	}
+	b.reports.Add(1)
+	counter(&b.chains, report.ChainID).Add(1)
+	if report.Category != "" {
+		counter(&b.categories, report.Category).Add(1)
+	}
+	b.addAddress(report.Address)
+}
+
+// recordPromotion counts one promotion that took ttc from first report.
+func (c *consensusStats) recordPromotion(ttc time.Duration, now time.Time) {
+	b := c.bucket(now)
+	if b == nil {
+		return
+	}
+	b.promotions.Add(1)
+	b.ttc[ttcBin(ttc)].Add(1)
+}
+
+// counter returns the counter stored under key, creating it if needed.
+func counter(m *sync.Map, key any) *atomic.Int64 {
+	if v, ok := m.Load(key); ok {
+		return v.(*atomic.Int64)
+	}
+	v, _ := m.LoadOrStore(key, new(atomic.Int64))
+	return v.(*atomic.Int64)
+}
+
+// addAddress adds an address to the bucket's sketch, raising its register
+// with a compare-and-swap on the containing word.
+func (b *statsBucket) addAddress(address string) {
+	h := maphash.String(hllSeed, address)
+	reg := h >> (64 - hllPrecision)
+	rank := uint64(bits.LeadingZeros64(h<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	word, shift := &b.sketch[reg/8], (reg%8)*8
+	for {
+		old := word.Load()
+		if (old>>shift)&0xff >= rank {
+			return
+		}
+		if word.CompareAndSwap(old, old&^(0xff<<shift)|rank<<shift) {
+			return
+		}
+	}
+}
+
+// ttcBin returns the histogram bin for a time to consensus.
+func ttcBin(d time.Duration) int {
+	secs := int64(d / time.Second)
+	if secs <= 0 {
+		return 0
+	}
+	return min(bits.Len64(uint64(secs)), ttcBins-1)
+}
+
+// ChainStat is one entry of the top reporting chains.
+type ChainStat struct {
+	ChainID   int    `json:"chain_id"`
+	ChainName string `json:"chain_name,omitempty"`
+	Reports   int64  `json:"reports"`
+}
+
+// CategoryStat is one entry of the top reported categories.
+type CategoryStat struct {
+	Category string `json:"category"`
+	Reports  int64  `json:"reports"`
+}
+
+// ConsensusStats is the body of GET /stats.
+type ConsensusStats struct {
+	Window           string         `json:"window"`
+	From             time.Time      `json:"from"`
+	To               time.Time      `json:"to"`
+	Reports          int64          `json:"reports"`
+	ReportsPerHour   float64        `json:"reports_per_hour"`
+	UniqueAddresses  int64          `json:"unique_addresses"` // estimated
+	Promotions       int64          `json:"promotions"`
+	PromotionsPerDay float64        `json:"promotions_per_day"`
+	TopChains        []ChainStat    `json:"top_chains"`
+	TopCategories    []CategoryStat `json:"top_categories"`
+
+	// MedianTimeToConsensusSeconds is the median delay from first report
+	// to promotion, resolved to a power of two; omitted with no promotions.
+	MedianTimeToConsensusSeconds *float64 `json:"median_time_to_consensus_seconds,omitempty"`
+}
+
+// Aggregate sums the buckets in the window ending at now.  The window is
+// rounded up to whole buckets and capped at the retention.
+func (c *consensusStats) Aggregate(window time.Duration, now time.Time) ConsensusStats {
+	window = min(window, statsRetention)
+	n := max(int64((window+statsBucketWidth-1)/statsBucketWidth), 1)
+	last := now.UnixNano() / int64(statsBucketWidth)
+	first := last - n + 1
+
+	var (
+		out        = ConsensusStats{Window: window.String(), To: now, From: now.Add(-window)}
+		chains     = make(map[int]int64)
+		categories = make(map[string]int64)
+		ttc        [ttcBins]int64
+		sketch     [hllRegisters]uint8
+	)
+	for i := range c.slots {
+		b := c.slots[i].Load()
+		if b == nil || b.index < first || b.index > last {
+			continue
+		}
+		out.Reports += b.reports.Load()
+		out.Promotions += b.promotions.Load()
+		b.chains.Range(func(k, v any) bool {
+			chains[k.(int)] += v.(*atomic.Int64).Load()
+			return true
+		})
+		b.categories.Range(func(k, v any) bool {
+			categories[k.(string)] += v.(*atomic.Int64).Load()
+			return true
+		})
+		for j := range b.ttc {
+			ttc[j] += b.ttc[j].Load()
+		}
+		for w := range b.sketch {
+			word := b.sketch[w].Load()
+			for k := 0; k < 8; k++ {
+				sketch[w*8+k] = max(sketch[w*8+k], uint8(word>>(k*8)))
+			}
+		}
+	}
+
+	hours := window.Hours()
+	out.ReportsPerHour = float64(out.Reports) / hours
+	out.PromotionsPerDay = float64(out.Promotions) / (hours / 24)
+	out.UniqueAddresses = hllEstimate(sketch)
+	out.TopChains = make([]ChainStat, 0, len(chains))
+	for chain, count := range chains {
+		out.TopChains = append(out.TopChains, ChainStat{ChainID: chain, Reports: count})
+	}
+	sort.Slice(out.TopChains, func(i, j int) bool {
+		a, b := out.TopChains[i], out.TopChains[j]
+		return a.Reports > b.Reports || a.Reports == b.Reports && a.ChainID < b.ChainID
+	})
+	out.TopChains = out.TopChains[:min(len(out.TopChains), statsTopN)]
+
+	out.TopCategories = make([]CategoryStat, 0, len(categories))
+	for category, count := range categories {
+		out.TopCategories = append(out.TopCategories, CategoryStat{Category: category, Reports: count})
+	}
+	sort.Slice(out.TopCategories, func(i, j int) bool {
+		a, b := out.TopCategories[i], out.TopCategories[j]
+		return a.Reports > b.Reports || a.Reports == b.Reports && a.Category < b.Category
+	})
+	out.TopCategories = out.TopCategories[:min(len(out.TopCategories), statsTopN)]
+
+	if median, ok := ttcMedian(ttc, out.Promotions); ok {
+		out.MedianTimeToConsensusSeconds = &median
+	}
+	return out
+}
+
+// ttcMedian returns the upper bound, in seconds, of the bin holding the
+// median promotion.
+func ttcMedian(bins [ttcBins]int64, total int64) (float64, bool) {
+	if total == 0 {
+		return 0, false
+	}
+	var seen int64
+	for i, n := range bins {
+		if seen += n; seen*2 >= total {
+			return float64(uint64(1) << i), true
+		}
+	}
+	return float64(uint64(1) << (ttcBins - 1)), true
+}
+
+// hllEstimate is the HyperLogLog cardinality estimate, with linear
+// counting for small sets.
+func hllEstimate(registers [hllRegisters]uint8) int64 {
+	const m = float64(hllRegisters)
+	var sum float64
+	zeros := 0
+	for _, r := range registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(math.Round(estimate))
+}
+
+// handleStats is the HTTP handler for GET /stats?window=24h.
+func (s *SwarmAggregator) handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	window := defaultStatsWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < statsBucketWidth || d > statsRetention {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "window must be a duration between 5m and 168h")
+			return
+		}
+		window = d
+	}
+	stats := s.stats.Aggregate(window, s.clock.Now())
+	cfg := s.current()
+	for i := range stats.TopChains {
+		stats.TopChains[i].ChainName = cfg.displayChainName(stats.TopChains[i].ChainID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
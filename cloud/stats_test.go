@@ -0,0 +1,112 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// statsEpoch is a fixed, bucket-aligned clock origin.
+var statsEpoch = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+func TestStatsBucketRotation(t *testing.T) {
+	c := newConsensusStats()
+	c.recordReport(IOCReport{Address: evmAddress("old"), ChainID: 1}, statsEpoch)
+
+	// A week later the same slot comes round again and is recycled.
+	later := statsEpoch.Add(statsRetention)
+	c.recordReport(IOCReport{Address: evmAddress("new"), ChainID: 1}, later)
+	c.recordReport(IOCReport{Address: evmAddress("new"), ChainID: 1}, later.Add(time.Minute))
+
+	if got := c.Aggregate(statsRetention, later.Add(time.Minute)); got.Reports != 2 || got.UniqueAddresses != 1 {
+		t.Errorf("Expected the recycled bucket to hold only the new reports, got %+v", got)
+	}
+	if got := c.Aggregate(statsRetention, statsEpoch); got.Reports != 0 {
+		t.Errorf("Expected the overwritten bucket to be gone, got %d reports", got.Reports)
+	}
+
+	// A report from before the slot's current bucket is dropped.
+	c.recordReport(IOCReport{Address: evmAddress("stale"), ChainID: 1}, statsEpoch)
+	if got := c.Aggregate(statsRetention, later.Add(time.Minute)); got.Reports != 2 {
+		t.Errorf("Expected a stale report not to clobber the current bucket, got %d", got.Reports)
+	}
+}
+
+func TestStatsWindowedAggregation(t *testing.T) {
+	c := newConsensusStats()
+	now := statsEpoch.Add(30 * time.Hour)
+
+	// Outside a 24h window ending at now.
+	c.recordReport(IOCReport{Address: evmAddress("early"), ChainID: 56, Category: "phishing"}, statsEpoch.Add(time.Hour))
+	c.recordPromotion(time.Second, statsEpoch.Add(time.Hour))
+
+	for i := 0; i < 200; i++ {
+		chain, category := 1, "drainer"
+		if i%4 == 0 {
+			chain, category = 137, "phishing"
+		}
+		at := now.Add(-time.Duration(i) * time.Minute)
+		c.recordReport(IOCReport{Address: evmAddress(fmt.Sprintf("a%d", i%100)), ChainID: chain, Category: category}, at)
+	}
+	c.recordPromotion(10*time.Minute, now)
+	c.recordPromotion(2*time.Hour, now.Add(-time.Hour))
+	c.recordPromotion(3*time.Hour, now.Add(-2*time.Hour))
+
+	got := c.Aggregate(24*time.Hour, now)
+	if got.Reports != 200 || got.ReportsPerHour != 200.0/24 {
+		t.Errorf("Expected 200 reports in the window, got %d (%g/h)", got.Reports, got.ReportsPerHour)
+	}
+	// The sketch's seed is random per process: allow about four standard
+	// errors either way.
+	if got.UniqueAddresses < 75 || got.UniqueAddresses > 125 {
+		t.Errorf("Expected about 100 unique addresses, got %d", got.UniqueAddresses)
+	}
+	if got.Promotions != 3 || got.PromotionsPerDay != 3 {
+		t.Errorf("Expected 3 promotions in the window, got %d (%g/day)", got.Promotions, got.PromotionsPerDay)
+	}
+	if len(got.TopChains) != 2 || got.TopChains[0] != (ChainStat{ChainID: 1, Reports: 150}) || got.TopChains[1] != (ChainStat{ChainID: 137, Reports: 50}) {
+		t.Errorf("Unexpected top chains %+v", got.TopChains)
+	}
+	if len(got.TopCategories) != 2 || got.TopCategories[0].Category != "drainer" {
+		t.Errorf("Unexpected top categories %+v", got.TopCategories)
+	}
+	// 2h falls in the [4096s, 8192s) bin.
+	if m := got.MedianTimeToConsensusSeconds; m == nil || *m != 8192 {
+		t.Errorf("Expected median time to consensus of 8192s, got %v", m)
+	}
+
+	if wide := c.Aggregate(statsRetention, now); wide.Reports != 201 || wide.Promotions != 4 {
+		t.Errorf("Expected the full retention to include the early report, got %+v", wide)
+	}
+}
+
+func TestStatsEndpoint(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2})
+	for _, src := range []string{"agent-A", "agent-B"} {
+		agg.IngestReport(context.Background(), IOCReport{
+			Address: evmAddress("drainer"), ChainID: 1, Category: "drainer",
+			Confidence: 0.9, Timestamp: time.Now(), SourceID: src,
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats?window=1h", nil))
+	var stats ConsensusStats
+	json.NewDecoder(rec.Body).Decode(&stats)
+	if rec.Code != http.StatusOK || stats.Reports != 2 || stats.UniqueAddresses != 1 || stats.Promotions != 1 || stats.Window != "1h0m0s" {
+		t.Errorf("Unexpected stats %d %+v", rec.Code, stats)
+	}
+	if stats.MedianTimeToConsensusSeconds == nil {
+		t.Error("Expected a median time to consensus after a promotion")
+	}
+
+	rec = httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats?window=30d", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unparseable window, got %d", rec.Code)
+	}
+}
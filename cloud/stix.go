@@ -0,0 +1,286 @@
+// Package swarm — STIX 2.1 export of confirmed IOCs.
+//
+// Enterprise SIEMs consume STIX, not Bloom filters.  GET /export/stix
+// renders the exact confirmed set that backs the filter as a Bundle of
+// Indicator objects.  Object IDs are UUIDv5 values derived from the
+// address so repeated exports of the same entry are stable.
+package swarm
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// stixNamespace seeds deterministic UUIDv5 identifiers (the STIX 2.1
+// namespace for cyber-observable IDs).
+var stixNamespace = uuid.MustParse("00abedb4-aa42-466c-9c01-fed23315a9b7")
+
+// stixTimeFormat is the millisecond-precision UTC timestamp STIX requires.
+const stixTimeFormat = "2006-01-02T15:04:05.000Z"
+
+const stixMediaType = "application/stix+json;version=2.1"
+
+const (
+	defaultSTIXPageSize = 1000
+	maxSTIXPageSize     = 10000
+)
+
+// STIXIndicator is a STIX 2.1 Indicator SDO.
+type STIXIndicator struct {
+	Type           string   `json:"type"`
+	SpecVersion    string   `json:"spec_version"`
+	ID             string   `json:"id"`
+	Created        string   `json:"created"`
+	Modified       string   `json:"modified"`
+	Name           string   `json:"name"`
+	IndicatorTypes []string `json:"indicator_types"`
+	Pattern        string   `json:"pattern"`
+	PatternType    string   `json:"pattern_type"`
+	ValidFrom      string   `json:"valid_from"`
+	Confidence     int      `json:"confidence"`
+	Labels         []string `json:"labels,omitempty"`
+	ChainID        int      `json:"x_aegis_chain_id"`
+	Source         string   `json:"x_aegis_source"`
+}
+
+// STIXBundle is a STIX 2.1 Bundle.  Next carries the cursor link for the
+// following page, if any.
+type STIXBundle struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Objects []STIXIndicator `json:"objects"`
+	Next    string          `json:"x_aegis_next,omitempty"`
+}
+
+// confirmedSnapshot returns a copy of the confirmed set ordered by
+// promotion time, then address.  The order is the export cursor order.
+func (s *SwarmAggregator) confirmedSnapshot() []ConfirmedEntry {
+	s.mu.RLock()
+	out := make([]ConfirmedEntry, 0, len(s.confirmed))
+	for _, entry := range s.confirmed {
+		out = append(out, *entry)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].PromotedAt.Equal(out[j].PromotedAt) {
+			return out[i].PromotedAt.Before(out[j].PromotedAt)
+		}
+		return out[i].Address < out[j].Address
+	})
+	return out
+}
+
+// entryConfidence returns the aggregated confidence in [0, 1] for a
+// confirmed entry: the TWAB mean when reports exist, else the value
+// recorded at promotion (e.g. from a trusted feed).
+func (s *SwarmAggregator) entryConfidence(entry ConfirmedEntry) float64 {
+	if summary, ok := s.twab.Summary(entry.Address); ok && summary.ReportCount > 0 {
+		return summary.MeanConfidence
+	}
+	return entry.Confidence
+}
+
+// NewSTIXIndicator renders one confirmed entry as a STIX Indicator.
+func NewSTIXIndicator(entry ConfirmedEntry, confidence float64) STIXIndicator {
+	ts := entry.PromotedAt.UTC().Format(stixTimeFormat)
+	labels := []string{"malicious-activity"}
+	if entry.Category != "" {
+		labels = []string{entry.Category}
+	}
+
+	return STIXIndicator{
+		Type:           "indicator",
+		SpecVersion:    "2.1",
+		ID:             "indicator--" + uuid.NewSHA1(stixNamespace, []byte(entry.Address)).String(),
+		Created:        ts,
+		Modified:       ts,
+		Name:           "Aegis swarm consensus: " + entry.Address,
+		IndicatorTypes: []string{"malicious-activity"},
+		Pattern:        fmt.Sprintf("[x-crypto-address:value = '%s']", stixEscape(entry.Address)),
+		PatternType:    "stix",
+		ValidFrom:      ts,
+		Confidence:     int(math.Round(clamp01(confidence) * 100)),
+		Labels:         labels,
+		ChainID:        entry.ChainID,
+		Source:         entry.Provenance.Source,
+	}
+}
+
+// stixEscape escapes a value for use inside a single-quoted STIX pattern
+// string literal.
+func stixEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v)
+}
+
+func clamp01(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// stixCursor encodes a position in confirmedSnapshot order.
+func stixCursor(entry ConfirmedEntry) string {
+	raw := strconv.FormatInt(entry.PromotedAt.UnixNano(), 10) + ":" + entry.Address
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseSTIXCursor decodes a cursor produced by stixCursor.
+func parseSTIXCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	nanos, address, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", fmt.Errorf("invalid cursor")
+	}
+	return time.Unix(0, n), address, nil
+}
+
+// STIXExportOptions selects the slice of the confirmed set to export.
+type STIXExportOptions struct {
+	Since  time.Time // export entries promoted strictly after Since
+	After  string    // cursor from a previous page
+	Limit  int
+	Filter uint64 // filter version, mixed into the bundle ID
+}
+
+// ExportSTIX builds one page of the STIX export.  The returned cursor is
+// empty on the last page.
+func (s *SwarmAggregator) ExportSTIX(opts STIXExportOptions) (STIXBundle, string, error) {
+	opts.Limit = stixPageSize(opts.Limit)
+	entries, next, err := s.confirmedPage(opts)
+	if err != nil {
+		return STIXBundle{}, "", err
+	}
+
+	bundle := STIXBundle{
+		Type:    "bundle",
+		Objects: s.stixIndicators(entries),
+	}
+	seed := fmt.Sprintf("%d|%s|%s|%d", opts.Filter, opts.Since.UTC().Format(time.RFC3339Nano), opts.After, opts.Limit)
+	bundle.ID = "bundle--" + uuid.NewSHA1(stixNamespace, []byte(seed)).String()
+	return bundle, next, nil
+}
+
+// confirmedPage selects one page of the confirmed set in cursor order.
+// The returned cursor is empty on the last page.
+func (s *SwarmAggregator) confirmedPage(opts STIXExportOptions) ([]ConfirmedEntry, string, error) {
+
+	var (
+		afterTime time.Time
+		afterAddr string
+	)
+	if opts.After != "" {
+		var err error
+		if afterTime, afterAddr, err = parseSTIXCursor(opts.After); err != nil {
+			return nil, "", err
+		}
+	}
+
+	limit := stixPageSize(opts.Limit)
+	var page []ConfirmedEntry
+	for _, entry := range s.confirmedSnapshot() {
+		if !opts.Since.IsZero() && !entry.PromotedAt.After(opts.Since) {
+			continue
+		}
+		if opts.After != "" {
+			if entry.PromotedAt.Before(afterTime) ||
+				(entry.PromotedAt.Equal(afterTime) && entry.Address <= afterAddr) {
+				continue
+			}
+		}
+		if len(page) == limit {
+			return page, stixCursor(page[len(page)-1]), nil
+		}
+		page = append(page, entry)
+	}
+	return page, "", nil
+}
+
+// stixPageSize applies the default and maximum page size.
+func stixPageSize(limit int) int {
+	if limit <= 0 {
+		return defaultSTIXPageSize
+	}
+	if limit > maxSTIXPageSize {
+		return maxSTIXPageSize
+	}
+	return limit
+}
+
+// stixIndicators renders confirmed entries as STIX Indicators.
+func (s *SwarmAggregator) stixIndicators(entries []ConfirmedEntry) []STIXIndicator {
+	out := make([]STIXIndicator, 0, len(entries))
+	for _, entry := range entries {
+		out = append(out, NewSTIXIndicator(entry, s.entryConfidence(entry)))
+	}
+	return out
+}
+
+// handleExportSTIX is the HTTP handler for GET /export/stix.
+//
+// Query parameters: since (RFC 3339), limit, and after (the cursor from a
+// previous page's next link).
+func (s *SwarmAggregator) handleExportSTIX(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	q := r.URL.Query()
+	opts := STIXExportOptions{
+		After:  q.Get("after"),
+		Filter: s.bloomFilter.Version(),
+	}
+	if raw := q.Get("since"); raw != "" {
+		since, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid since timestamp")
+			return
+		}
+		opts.Since = since
+	}
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid limit")
+			return
+		}
+		opts.Limit = limit
+	}
+
+	bundle, cursor, err := s.ExportSTIX(opts)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	if cursor != "" {
+		next := url.Values{}
+		if since := q.Get("since"); since != "" {
+			next.Set("since", since)
+		}
+		if limit := q.Get("limit"); limit != "" {
+			next.Set("limit", limit)
+		}
+		next.Set("after", cursor)
+		bundle.Next = r.URL.Path + "?" + next.Encode()
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", bundle.Next))
+	}
+
+	w.Header().Set("Content-Type", stixMediaType)
+	json.NewEncoder(w).Encode(bundle)
+}
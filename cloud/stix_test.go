@@ -0,0 +1,116 @@
+package swarm
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata/")
+
+// stixFixtureAggregator builds an aggregator with three confirmed entries
+// at fixed promotion times.
+func stixFixtureAggregator() *SwarmAggregator {
+	agg := NewSwarmAggregator()
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	entries := []ConfirmedEntry{
+		{Address: "0xaaa1", ChainID: 1, Category: "drainer", Confidence: 0.9,
+			PromotedAt: base, Provenance: Provenance{Source: provenanceConsensus, ImportedAt: base}},
+		{Address: "0xbbb2", ChainID: 137, Category: "sanctions", Confidence: 1.0,
+			PromotedAt: base.Add(time.Hour), Provenance: Provenance{Source: "feed:ofac", Mode: FeedTrusted, ImportedAt: base.Add(time.Hour)}},
+		{Address: "0xccc3", ChainID: 1, Confidence: 0.5,
+			PromotedAt: base.Add(2 * time.Hour), Provenance: Provenance{Source: provenanceConsensus, ImportedAt: base.Add(2 * time.Hour)}},
+	}
+	for i := range entries {
+		agg.confirmed[entries[i].Address] = &entries[i]
+		agg.bloomFilter.Add(entries[i].Address)
+	}
+
+	// TWAB reports take precedence over the promotion-time confidence.
+	agg.twab.Record("0xaaa1", IOCReport{Address: "0xaaa1", Confidence: 0.8, Timestamp: base, SourceID: "a"})
+	agg.twab.Record("0xaaa1", IOCReport{Address: "0xaaa1", Confidence: 0.6, Timestamp: base, SourceID: "b"})
+	return agg
+}
+
+func TestSTIXExportMatchesGolden(t *testing.T) {
+	agg := stixFixtureAggregator()
+
+	bundle, next, err := agg.ExportSTIX(STIXExportOptions{Filter: agg.bloomFilter.Version()})
+	if err != nil {
+		t.Fatalf("ExportSTIX failed: %v", err)
+	}
+	if next != "" {
+		t.Errorf("Expected a single page, got next cursor %q", next)
+	}
+
+	got, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	got = append(got, '\n')
+
+	golden := filepath.Join("testdata", "stix_export.golden.json")
+	if *updateGolden {
+		if err := os.WriteFile(golden, got, 0o644); err != nil {
+			t.Fatalf("Write golden: %v", err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Read golden: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("STIX export differs from %s (run go test -update)\ngot:\n%s", golden, got)
+	}
+}
+
+func TestSTIXExportSinceAndPagination(t *testing.T) {
+	agg := stixFixtureAggregator()
+	agg.keys.Add("sub-secret", APIKey{ID: "siem", Role: RoleSubscriber})
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	get := func(path string) (STIXBundle, *http.Response) {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+		req.Header.Set("X-API-Key", "sub-secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var b STIXBundle
+		json.NewDecoder(resp.Body).Decode(&b)
+		return b, resp
+	}
+
+	since, _ := get("/export/stix?since=2026-03-01T12:00:00Z")
+	if len(since.Objects) != 2 || since.Objects[0].Pattern != "[x-crypto-address:value = '0xbbb2']" {
+		t.Errorf("Expected entries promoted strictly after since, got %+v", since.Objects)
+	}
+
+	var seen []string
+	path := "/export/stix?limit=2"
+	for pages := 0; path != ""; pages++ {
+		if pages > 3 {
+			t.Fatal("Pagination did not terminate")
+		}
+		page, resp := get(path)
+		if page.Next != "" && resp.Header.Get("Link") == "" {
+			t.Error("Expected Link header alongside next")
+		}
+		for _, obj := range page.Objects {
+			seen = append(seen, obj.Pattern)
+		}
+		path = page.Next
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected 3 indicators across pages, got %v", seen)
+	}
+}
@@ -0,0 +1,163 @@
+// Package swarm — Streaming filter subscriptions.
+//
+// /ws and /sse/filter open the same subscription and differ only in how
+// they frame what it is sent.  openFilterStream authorizes the request,
+// reads the query parameters both take (see websocket.go), and subscribes
+// it to the filter they select; the transport then sends the initial
+// envelopes, every push, and with ?ack=1 the redeliveries of ack.go.
+package swarm
+
+import (
+	"net/http"
+	"time"
+)
+
+// filterStream is a subscription opened for a streaming transport.
+type filterStream struct {
+	id       string
+	format   FilterFormat
+	encoding WireEncoding
+	acks     bool
+	ch       <-chan []byte
+	sub      *subscriber
+	snapshot func() filterSnapshot
+	initial  func() ([][]byte, error) // the current filter, or the resume reply
+	close    func()
+}
+
+// openFilterStream subscribes a streaming request, resuming from
+// lastVersion if resume; foreign marks lastVersion as numbered by another
+// replicating instance.  It answers the request itself on failure.  The
+// caller must close the stream once it ends.
+func (s *SwarmAggregator) openFilterStream(w http.ResponseWriter, r *http.Request, transport string, resume, foreign bool, lastVersion uint64) (*filterStream, bool) {
+	format, err := parseFilterFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return nil, false
+	}
+	deltas, err := parseDeltaMode(r.URL.Query().Get("deltas"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return nil, false
+	}
+	encoding, err := parseStreamEncoding(r.URL.Query().Get("encoding"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return nil, false
+	}
+	if encoding == WireMsgpack && transport != "ws" {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "encoding=msgpack needs the WebSocket transport")
+		return nil, false
+	}
+	indicator := IndicatorType(r.URL.Query().Get("type"))
+	if !indicator.valid() {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid type")
+		return nil, false
+	}
+	acks := r.URL.Query().Get("ack") == "1"
+	role := RoleSubscriber
+	if acks {
+		role = RoleEnterprise
+	}
+	secret := subscriberSecret(r)
+	key, ok := s.authorizeSecret(w, r, secret, role)
+	if !ok {
+		return nil, false
+	}
+	owner, nsName := key.Name(), key.Namespace
+	tier, ok := s.requestTier(w, r, nsName)
+	if !ok {
+		return nil, false
+	}
+	buffer, mode, ok := s.requestBuffer(w, r, key.Role)
+	if !ok {
+		return nil, false
+	}
+	schedule, ok := requestSchedule(w, r)
+	if !ok {
+		return nil, false
+	}
+	id, takeover, ok := requestSubscriberID(w, r, transport, key)
+	if !ok {
+		return nil, false
+	}
+	ss, snapshot := s.tierSubscribers(tier), s.tierSnapshot(tier)
+	if nsName != "" {
+		ns := s.namespace(nsName)
+		ss, snapshot = ns.subscribers, func() filterSnapshot { return s.namespaceSnapshot(ns) }
+	}
+	// Taking over a subscription closes it, freeing its slot.
+	if takeover && ss.get(id) != nil {
+		s.keySubs.take(owner)
+	} else if !s.keySubs.acquire(owner) {
+		writeError(w, r, http.StatusTooManyRequests, CodeTooManySubs, "Too many subscriptions for this API key")
+		return nil, false
+	}
+
+	opts := SubscribeOptions{Format: format, Key: owner, NoSummary: r.URL.Query().Get("summary") == "0", IndicatorType: indicator, Acks: acks, Tier: tier, Buffer: buffer, Mode: mode, Encoding: encoding, Schedule: schedule}
+	if indicator != "" {
+		// A typed subscription starts from a snapshot of its type.
+		all := snapshot
+		snapshot = func() filterSnapshot { return all().ofType(indicator) }
+	}
+	sub := ss.subscribe(id, s.config.Push.SubscriberBuffer, opts, s.clock.Now())
+	fs := &filterStream{
+		id:       id,
+		format:   format,
+		encoding: encoding,
+		acks:     acks,
+		ch:       sub.ch,
+		sub:      sub,
+		snapshot: snapshot,
+		close: func() {
+			ss.remove(sub) // not whoever took it over
+			s.keySubs.release(owner)
+		},
+	}
+	fs.initial = func() ([][]byte, error) { return s.initialSnapshot(snapshot(), format, encoding, resume) }
+	if nsName == "" && tier == TierMain && indicator == "" && format == FormatBloom && encoding == WireJSON && !(resume && foreign) {
+		fs.initial = func() ([][]byte, error) { return s.initialEnvelopes(resume, lastVersion, deltas) }
+		if deltas == DeltaBits {
+			s.bitPatches.subscribers.Add(1)
+			closeStream := fs.close
+			fs.close = func() {
+				closeStream()
+				s.bitPatches.subscribers.Add(-1)
+			}
+		}
+	}
+
+	// A key revoked since it was checked has already had its subscriptions
+	// closed; this one must not outlive it.
+	if _, ok := s.keys.Lookup(secret); !ok {
+		fs.close()
+		writeError(w, r, http.StatusUnauthorized, CodeUnauthorized, "Invalid API key")
+		return nil, false
+	}
+	return fs, true
+}
+
+// redeliveries returns the ticker an acknowledging stream checks for
+// overdue deliveries on, nil otherwise, and its stop function.
+func (s *SwarmAggregator) redeliveries(fs *filterStream) (<-chan time.Time, func()) {
+	ackTimeout := time.Duration(s.config.Push.AckTimeout)
+	if !fs.acks || ackTimeout <= 0 {
+		return nil, func() {}
+	}
+	ticker := time.NewTicker(ackTimeout / 4)
+	return ticker.C, ticker.Stop
+}
+
+// redelivery returns the filter to send a stream again at now, nil if no
+// delivery is overdue.
+func (s *SwarmAggregator) redelivery(fs *filterStream, now time.Time) ([]byte, error) {
+	if !fs.sub.dueRedelivery(now, time.Duration(s.config.Push.AckTimeout), s.config.Push.AckMaxRedeliveries) {
+		return nil, nil
+	}
+	envelopes, err := s.initialSnapshot(fs.snapshot(), fs.format, fs.encoding, true)
+	if err != nil {
+		return nil, err
+	}
+	s.metrics.pushRedeliveries.Inc()
+	return envelopes[0], nil
+}
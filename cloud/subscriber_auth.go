@@ -0,0 +1,155 @@
+// Package swarm — Subscriber authentication and per-key subscription limits.
+//
+// A /ws upgrade must present a key holding the subscriber role, as a
+// bearer token, in X-API-Key, or as ?api_key= for browser clients that
+// cannot set headers on the upgrade.  Each connection is owned by its key:
+// the subscriber ID is derived from the key name plus a per-connection
+// nonce, /admin/subscribers lists the key, and at most
+// push.max_subscriptions_per_key connections may be open under one key at
+// a time; the next upgrade is answered 429.  Revoking a key through POST
+// /admin/api-keys/revoke closes every subscription it holds.
+//
+// The ID is sent back in X-Subscription-ID.  A client reconnecting, over
+// the same transport and with the same key, may present it as
+// ?subscription_id= to resume its session: the new connection takes the
+// ID over, closing the subscription still holding it, as a connection the
+// client has given up on often is (see subscribers.go), and without
+// counting against the key's limit.  Adding ?last_version= catches it
+// up on what it missed (see resume.go).  An ID not issued to the key for
+// the transport is answered 400.
+package swarm
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// keySubscriptions counts the open WebSocket subscriptions per API key.
+type keySubscriptions struct {
+	max int // zero is unlimited
+
+	mu   sync.Mutex
+	open map[string]int // key name -> open subscriptions
+}
+
+func newKeySubscriptions(max int) *keySubscriptions {
+	return &keySubscriptions{max: max, open: make(map[string]int)}
+}
+
+// acquire takes a subscription slot for the key, reporting false when it
+// already holds the maximum.
+func (ks *keySubscriptions) acquire(key string) bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.max > 0 && ks.open[key] >= ks.max {
+		return false
+	}
+	ks.open[key]++
+	return true
+}
+
+// take takes a slot for the key whatever it holds, for a connection
+// taking over one that is closing.
+func (ks *keySubscriptions) take(key string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.open[key]++
+}
+
+// release returns a slot taken by acquire or take.
+func (ks *keySubscriptions) release(key string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if ks.open[key]--; ks.open[key] <= 0 {
+		delete(ks.open, key)
+	}
+}
+
+// subscriberSecret extracts the API key secret from a /ws upgrade: the
+// usual headers, or the api_key query parameter.
+func subscriberSecret(r *http.Request) string {
+	if secret := presentedSecret(r); secret != "" {
+		return secret
+	}
+	return r.URL.Query().Get("api_key")
+}
+
+// subscriberID derives a connection's subscriber ID from its transport
+// and key.
+func subscriberID(transport string, key APIKey) string {
+	return subscriberIDPrefix(transport, key) + uuid.NewString()
+}
+
+func subscriberIDPrefix(transport string, key APIKey) string {
+	return transport + "-" + key.Name() + "-"
+}
+
+// requestSubscriberID returns the subscriber ID of a streaming request: a
+// new one, or the ?subscription_id= it resumes, reporting which.  It
+// answers the request itself if that ID was not issued to the key for the
+// transport.
+func requestSubscriberID(w http.ResponseWriter, r *http.Request, transport string, key APIKey) (string, bool, bool) {
+	id := r.URL.Query().Get("subscription_id")
+	if id == "" {
+		return subscriberID(transport, key), false, true
+	}
+	nonce, found := strings.CutPrefix(id, subscriberIDPrefix(transport, key))
+	if u, err := uuid.Parse(nonce); !found || err != nil || u.String() != nonce {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "subscription_id was not issued to this key")
+		return "", false, false
+	}
+	return id, true, true
+}
+
+// RevokeAPIKey removes every secret configured for the named key (ns/id
+// for a namespaced key) and closes the subscriptions it holds, reporting
+// whether the key existed.
+func (s *SwarmAggregator) RevokeAPIKey(name string) bool {
+	if s.keys.Revoke(name) == 0 {
+		return false
+	}
+	s.trust.revoke(name)
+	closed := s.subscribers.unsubscribeKey(name)
+	if s.staging != nil {
+		closed += s.staging.subscribers.unsubscribeKey(name) + s.staging.both.unsubscribeKey(name)
+	}
+	for _, ns := range s.namespaceList() {
+		closed += ns.subscribers.unsubscribeKey(name)
+	}
+	closed += s.events.unsubscribeKey(name)
+	log.Printf("Revoked API key %s, closing %d subscriptions", name, closed)
+	return true
+}
+
+// handleAdminRevokeAPIKey is the HTTP handler for POST
+// /admin/api-keys/revoke with a body of {"id": "ns/id", "reason": "..."}.
+func (s *SwarmAggregator) handleAdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req struct {
+		ID     string `json:"id"`
+		Reason string `json:"reason,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		writeBodyError(w, r, err, "Invalid revoke body")
+		return
+	}
+	if !s.auditAdmin(w, r, AuditEvent{Action: AuditAPIKeyRevoke, Subject: req.ID, Reason: req.Reason}) {
+		return
+	}
+	if !s.RevokeAPIKey(req.ID) {
+		writeError(w, r, http.StatusNotFound, CodeNotFound, "API key not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"revoked": req.ID})
+}
@@ -0,0 +1,219 @@
+package swarm
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const testSubscriberSecret = "siem-secret"
+
+// addTestSubscriber registers a subscriber key named "siem" and returns
+// the header presenting it.
+func addTestSubscriber(agg *SwarmAggregator) http.Header {
+	agg.keys.Add(testSubscriberSecret, APIKey{ID: "siem", Role: RoleSubscriber})
+	return http.Header{"X-API-Key": {testSubscriberSecret}}
+}
+
+// dialSubscriber opens /ws and reads the initial snapshot.
+func dialSubscriber(t *testing.T, srv *httptest.Server, query string, header http.Header) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws"+query, header)
+	if err != nil {
+		return nil, resp, err
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var initial FilterEnvelope
+	if err := conn.ReadJSON(&initial); err != nil {
+		conn.Close()
+		t.Fatalf("Expected initial snapshot: %v", err)
+	}
+	return conn, resp, nil
+}
+
+func TestWebSocketRejectsAnonymousUpgrade(t *testing.T) {
+	agg := NewSwarmAggregator()
+	addTestSubscriber(agg)
+	agg.keys.Add("reporter-secret", APIKey{ID: "agent", Role: RoleReporter})
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	for name, tc := range map[string]struct {
+		header http.Header
+		want   int
+	}{
+		"anonymous":    {nil, http.StatusUnauthorized},
+		"unknown key":  {http.Header{"X-API-Key": {"nope"}}, http.StatusUnauthorized},
+		"reporter key": {http.Header{"X-API-Key": {"reporter-secret"}}, http.StatusForbidden},
+	} {
+		_, resp, err := dialSubscriber(t, srv, "", tc.header)
+		if err == nil || resp == nil || resp.StatusCode != tc.want {
+			t.Errorf("%s: expected %d, got %v", name, tc.want, err)
+		}
+	}
+	if n := len(agg.ListSubscribers()); n != 0 {
+		t.Errorf("Expected no subscribers, got %d", n)
+	}
+
+	conn, _, err := dialSubscriber(t, srv, "?api_key="+testSubscriberSecret, nil)
+	if err != nil {
+		t.Fatalf("Expected the api_key query parameter to authenticate: %v", err)
+	}
+	conn.Close()
+}
+
+func TestWebSocketSubscriptionLimitPerKey(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Push.MaxSubscriptionsPerKey = 2
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	first, _, err := dialSubscriber(t, srv, "", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	second, _, err := dialSubscriber(t, srv, "", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer second.Close()
+
+	_, resp, err := dialSubscriber(t, srv, "", header)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("Expected 429 past the per-key limit, got %v", err)
+	}
+
+	subs := agg.ListSubscribers()
+	if len(subs) != 2 || subs[0].Key != "siem" || subs[0].ID == subs[1].ID || !strings.HasPrefix(subs[0].ID, "ws-siem-") {
+		t.Errorf("Expected two distinct subscribers owned by siem, got %+v", subs)
+	}
+
+	// Closing a connection frees its slot.
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, _, err := dialSubscriber(t, srv, "", header)
+		if err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected a slot after closing a connection: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRevokingKeyClosesItsSubscriptions(t *testing.T) {
+	agg := NewSwarmAggregator()
+	header := addTestSubscriber(agg)
+	agg.keys.Add("admin-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	conn, _, err := dialSubscriber(t, srv, "", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/api-keys/revoke", strings.NewReader(`{"id":"siem"}`))
+	req.Header.Set("X-API-Key", "admin-secret")
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if ne, ok := err.(net.Error); err == nil || ok && ne.Timeout() {
+		t.Fatalf("Expected the connection closed, got %v", err)
+	}
+	if n := len(agg.ListSubscribers()); n != 0 {
+		t.Errorf("Expected no subscribers after revocation, got %d", n)
+	}
+	if _, resp, err := dialSubscriber(t, srv, "", header); err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected the revoked key rejected, got %v", err)
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/admin/api-keys/revoke", strings.NewReader(`{"id":"siem"}`))
+	req.Header.Set("X-API-Key", "admin-secret")
+	agg.Routes().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 revoking an unknown key, got %d", rec.Code)
+	}
+}
+
+func TestWebSocketReconnectWithSubscriptionIDTakesOver(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Push.MaxSubscriptionsPerKey = 1
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	defer srv.Close()
+
+	first, resp, err := dialSubscriber(t, srv, "", header)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer first.Close()
+	id := resp.Header.Get("X-Subscription-ID")
+
+	// At the key's limit, the reconnect still gets in, and the connection
+	// it replaces is closed.
+	second, resp, err := dialSubscriber(t, srv, "?subscription_id="+id, header)
+	if err != nil {
+		t.Fatalf("Expected the reconnect to take over %s: %v", id, err)
+	}
+	defer second.Close()
+	if got := resp.Header.Get("X-Subscription-ID"); got != id {
+		t.Errorf("Expected the subscription ID kept, got %q", got)
+	}
+	if _, _, err := first.ReadMessage(); err == nil {
+		t.Error("Expected the replaced connection closed")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	open := func() int {
+		agg.keySubs.mu.Lock()
+		defer agg.keySubs.mu.Unlock()
+		return agg.keySubs.open["siem"]
+	}
+	for open() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the replaced connection's slot released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if subs := agg.ListSubscribers(); len(subs) != 1 || subs[0].ID != id {
+		t.Fatalf("Expected the new connection subscribed as %s, got %+v", id, subs)
+	}
+	blockAll(agg, "0xA")
+	var env FilterEnvelope
+	if err := second.ReadJSON(&env); err != nil || env.Version != 1 {
+		t.Errorf("Expected the push on the new connection, got %+v (%v)", env, err)
+	}
+	if _, replaced := agg.subscriberEvictions(); replaced != 1 {
+		t.Errorf("Expected one replacement counted, got %d", replaced)
+	}
+
+	agg.keys.Add("other-secret", APIKey{ID: "other", Role: RoleSubscriber})
+	for name, query := range map[string]string{
+		"another key's":       "?subscription_id=ws-other-" + strings.TrimPrefix(id, "ws-siem-"),
+		"another transport's": "?subscription_id=sse-" + strings.TrimPrefix(id, "ws-"),
+		"made up":             "?subscription_id=ws-siem-1",
+	} {
+		_, resp, err := dialSubscriber(t, srv, query, header)
+		if err == nil || resp == nil || resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %v", name, err)
+		}
+	}
+}
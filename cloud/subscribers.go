@@ -0,0 +1,531 @@
+// Package swarm — Push subscriber lifecycle.
+//
+// Every subscriber channel, in the global swarm or a namespace, belongs to
+// a subscriberSet that counts what was delivered and dropped.  A push to a
+// full channel is skipped; a subscriber that keeps skipping is evicted
+// once it reaches push.evict_after_drops consecutive drops, or has gone
+// push.evict_after_idle without a successful delivery while dropping.
+// Delivery, and so eviction, is the work of the subscriber's pump (see
+// pump.go).  Eviction goes through unsubscribe, so the pump closes the
+// channel exactly once and the WebSocket writer sees it and hangs up.  A
+// subscriber that simply receives no pushes is never evicted.
+//
+// A subscription may ask for its own buffer with ?buffer=N, up to
+// push.max_subscriber_buffer for its key's role, and for ?mode=latest:
+// since every push is a whole snapshot, a latest-only subscriber finding
+// its channel full has the oldest push queued replaced by the new one
+// rather than the new one skipped, so it never drops and is never
+// evicted.  The default is the queue mode and push.subscriber_buffer.  GET /admin/subscribers reports each one's mode
+// and the most pushes it has had queued at once.
+//
+// A subscriber ID names one subscription at a time: subscribing again
+// with an ID already in use replaces the subscriber holding it, whose
+// pump closes its channel as on eviction, so its reader is released
+// rather than left waiting on a channel nothing sends to.  The last
+// subscription wins.  GET /admin/subscribers and
+// aegis_subscriber_evictions_total count the subscribers evicted for
+// falling behind and those replaced.
+package swarm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrDuplicateSubscriber is returned by TrySubscribe for an ID a
+// subscriber already holds.
+var ErrDuplicateSubscriber = errors.New("a subscriber already holds this ID")
+
+// SubscriberMode is what a push to a full subscriber channel does.
+type SubscriberMode string
+
+const (
+	SubscriberQueue  SubscriberMode = "queue"  // skips the new push
+	SubscriberLatest SubscriberMode = "latest" // replaces the oldest queued
+)
+
+func (m SubscriberMode) valid() bool {
+	return m == "" || m == SubscriberQueue || m == SubscriberLatest
+}
+
+// subscriber is one push channel and its delivery counters.
+type subscriber struct {
+	id           string
+	key          string // name of the API key that opened it, if any
+	ch           chan []byte
+	format       FilterFormat
+	summary      bool          // wants push summaries
+	indicator    IndicatorType // only entries of this type; "" for all
+	encoding     WireEncoding  // of the pushes (see encoding.go)
+	acks         bool          // acknowledges deliveries (see ack.go)
+	mode         SubscriberMode
+	schedule     PushSchedule // see pushschedule.go
+	subscribedAt time.Time
+	mail         *mailbox // fed to its pump (see pump.go)
+
+	mu               sync.Mutex // guards the counters below
+	delivered        int64
+	dropped          int64
+	consecutiveDrops int
+	lastDelivery     time.Time // zero until the first delivery
+	lastVersion      uint64
+	replaced         int64 // queued pushes superseded, in latest mode
+	highWater        int   // most pushes queued at once
+
+	// Acknowledgements, when acks is set.
+	ackedVersion uint64
+	ackedAt      time.Time
+	sentAt       time.Time // when lastVersion was first sent, or last resent
+	resent       int       // redeliveries of lastVersion
+	redeliveries int64
+
+	// The push held for a scheduled subscriber, and the last delivery,
+	// or the subscription, its window runs from.
+	flushedAt   time.Time
+	held        []byte
+	heldVersion uint64
+	heldSince   time.Time
+}
+
+// evictionPolicy bounds how long a subscriber may keep dropping pushes.
+type evictionPolicy struct {
+	maxDrops int           // consecutive drops; zero disables
+	maxIdle  time.Duration // without a delivery while dropping; zero disables
+}
+
+func newEvictionPolicy(cfg PushConfig) evictionPolicy {
+	return evictionPolicy{maxDrops: cfg.EvictAfterDrops, maxIdle: time.Duration(cfg.EvictAfterIdle)}
+}
+
+// offer queues data for the subscriber, reporting whether it should now be
+// evicted.
+func (sub *subscriber) offer(data []byte, version uint64, policy evictionPolicy, logs *logSampler, now time.Time) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	if sub.mode == SubscriberLatest && len(sub.ch) == cap(sub.ch) {
+		// The pump is the only sender, so once one is taken the send
+		// below cannot block.
+		select {
+		case <-sub.ch:
+			sub.replaced++
+		default: // the reader got there first
+		}
+	}
+	select {
+	case sub.ch <- data:
+		sub.highWater = max(sub.highWater, len(sub.ch))
+		sub.delivered++
+		sub.consecutiveDrops = 0
+		sub.lastDelivery = now
+		sub.sentLocked(version, now)
+		return false
+	default:
+	}
+	sub.dropped++
+	sub.consecutiveDrops++
+	if sub.consecutiveDrops == 1 {
+		logs.Printf(logSlowSubscriber, sub.id, "Subscriber %s too slow, skipping push", sub.id)
+	}
+	if policy.maxDrops > 0 && sub.consecutiveDrops >= policy.maxDrops {
+		return true
+	}
+	since := sub.lastDelivery
+	if since.IsZero() {
+		since = sub.subscribedAt
+	}
+	return policy.maxIdle > 0 && now.Sub(since) >= policy.maxIdle
+}
+
+// SubscriberInfo describes one connected subscriber.
+type SubscriberInfo struct {
+	ID             string           `json:"id"`
+	Namespace      string           `json:"namespace,omitempty"`
+	Tier           SubscriptionTier `json:"tier,omitempty"` // staging tiers only (see staging.go)
+	Key            string           `json:"key,omitempty"`
+	Format         FilterFormat     `json:"format"`
+	IndicatorType  IndicatorType    `json:"indicator_type,omitempty"`
+	SubscribedAt   time.Time        `json:"subscribed_at"`
+	Mode           SubscriberMode   `json:"mode"`
+	Buffer         int              `json:"buffer"`
+	BufferHigh     int              `json:"buffer_high_water"` // most pushes queued at once
+	Delivered      int64            `json:"delivered"`
+	Dropped        int64            `json:"dropped"`
+	Replaced       int64            `json:"replaced,omitempty"` // latest mode only
+	LastDeliveryAt *time.Time       `json:"last_delivery_at,omitempty"`
+	LastVersion    uint64           `json:"last_version"`
+
+	// Acknowledgements, for a subscriber opened with ack=1 (see ack.go).
+	Acks         bool       `json:"acks,omitempty"`
+	AckedVersion uint64     `json:"acked_version,omitempty"`
+	AckedAt      *time.Time `json:"acked_at,omitempty"`
+	Redeliveries int64      `json:"redeliveries,omitempty"`
+	Lag          uint64     `json:"lag,omitempty"` // filter versions past the last ack
+
+	// The schedule of a subscriber opened with ?max_frequency= (see
+	// pushschedule.go), and the filter versions held back from it.
+	MaxFrequency   Duration   `json:"max_frequency,omitempty"`
+	UrgentSeverity Severity   `json:"urgent_severity,omitempty"`
+	PendingDeltas  uint64     `json:"pending_deltas,omitempty"`
+	PendingSince   *time.Time `json:"pending_since,omitempty"`
+}
+
+func (sub *subscriber) info() SubscriberInfo {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+
+	info := SubscriberInfo{
+		ID:            sub.id,
+		Key:           sub.key,
+		Format:        sub.format,
+		IndicatorType: sub.indicator,
+		SubscribedAt:  sub.subscribedAt,
+		Mode:          sub.mode,
+		Buffer:        cap(sub.ch),
+		BufferHigh:    sub.highWater,
+		Delivered:     sub.delivered,
+		Dropped:       sub.dropped,
+		Replaced:      sub.replaced,
+		LastVersion:   sub.lastVersion,
+	}
+	if !sub.lastDelivery.IsZero() {
+		at := sub.lastDelivery
+		info.LastDeliveryAt = &at
+	}
+	if sub.acks {
+		info.Acks, info.AckedVersion, info.Redeliveries = true, sub.ackedVersion, sub.redeliveries
+		info.Lag = sub.lastVersion - sub.ackedVersion
+		if !sub.ackedAt.IsZero() {
+			at := sub.ackedAt
+			info.AckedAt = &at
+		}
+	}
+	if sub.schedule.MaxFrequency > 0 {
+		info.MaxFrequency, info.UrgentSeverity = Duration(sub.schedule.MaxFrequency), sub.schedule.UrgentSeverity
+	}
+	if sub.held != nil {
+		since := sub.heldSince
+		info.PendingDeltas, info.PendingSince = sub.heldVersion-min(sub.heldVersion, sub.lastVersion), &since
+	}
+	return info
+}
+
+// subscriberSet is a concurrent-safe set of subscribers.  Long-polling
+// clients wait on watch, which every broadcast publishes to.
+type subscriberSet struct {
+	mu    sync.RWMutex
+	subs  map[string]*subscriber // subscriber_id -> subscriber
+	watch *versionWatch
+	cache *pushCache // messages of the last version pushed (see pushcache.go)
+	logs  *logSampler
+
+	evicted  atomic.Int64 // for falling behind
+	replaced atomic.Int64 // by a subscription with the same ID
+}
+
+func newSubscriberSet(logs *logSampler) *subscriberSet {
+	return &subscriberSet{subs: make(map[string]*subscriber), watch: newVersionWatch(), cache: newPushCache(), logs: logs}
+}
+
+// subscribe registers a subscriber at now with a channel buffering buffer
+// pushes, or opts.Buffer if set, replacing any subscriber with the same
+// ID.  opts.Format must be set.
+func (ss *subscriberSet) subscribe(id string, buffer int, opts SubscribeOptions, now time.Time) *subscriber {
+	sub, _ := ss.add(id, buffer, opts, now, true)
+	return sub
+}
+
+// add registers a subscriber as subscribe does, or fails with
+// ErrDuplicateSubscriber if one holds id and replace is false.
+func (ss *subscriberSet) add(id string, buffer int, opts SubscribeOptions, now time.Time, replace bool) (*subscriber, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, ok := ss.subs[id]; ok && !replace {
+		return nil, fmt.Errorf("%w: %s", ErrDuplicateSubscriber, id)
+	}
+	if opts.Buffer > 0 {
+		buffer = opts.Buffer
+	}
+	if opts.Mode == "" {
+		opts.Mode = SubscriberQueue
+	}
+	if opts.Encoding == "" {
+		opts.Encoding = WireJSON
+	}
+	sub := &subscriber{
+		id:           id,
+		key:          opts.Key,
+		ch:           make(chan []byte, buffer),
+		format:       opts.Format,
+		summary:      !opts.NoSummary && opts.IndicatorType == "" && opts.Schedule.MaxFrequency == 0,
+		indicator:    opts.IndicatorType,
+		encoding:     opts.Encoding,
+		acks:         opts.Acks,
+		mode:         opts.Mode,
+		schedule:     opts.Schedule,
+		subscribedAt: now,
+		mail:         newMailbox(),
+		flushedAt:    now,
+	}
+	if old, ok := ss.subs[id]; ok {
+		close(old.mail.stop)
+		ss.replaced.Add(1)
+		ss.logs.Printf(logEvictedSubscriber, id, "Replaced subscriber %s by a new subscription with its ID", id)
+	}
+	ss.subs[id] = sub
+	go ss.pump(sub)
+	return sub, nil
+}
+
+// get returns the subscriber with id, or nil.
+func (ss *subscriberSet) get(id string) *subscriber {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return ss.subs[id]
+}
+
+// unsubscribe removes a subscriber and stops its pump, which closes its
+// channel.  It is safe to call more than once.
+func (ss *subscriberSet) unsubscribe(id string) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub, ok := ss.subs[id]
+	if ok {
+		close(sub.mail.stop)
+		delete(ss.subs, id)
+	}
+	return ok
+}
+
+// remove unsubscribes sub unless it has been replaced, reporting whether
+// it had.
+func (ss *subscriberSet) remove(sub *subscriber) bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.subs[sub.id] != sub {
+		return false
+	}
+	close(sub.mail.stop)
+	delete(ss.subs, sub.id)
+	return true
+}
+
+// unsubscribeKey removes every subscriber opened with the named key,
+// returning how many were removed.
+func (ss *subscriberSet) unsubscribeKey(key string) int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	n := 0
+	for id, sub := range ss.subs {
+		if sub.key == key {
+			close(sub.mail.stop)
+			delete(ss.subs, id)
+			n++
+		}
+	}
+	return n
+}
+
+// len returns the number of subscribers.
+func (ss *subscriberSet) len() int {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	return len(ss.subs)
+}
+
+// pushVariant is one encoding of a push: a format, with or without the
+// summary, of every entry or those of one indicator type, in JSON or
+// msgpack.
+type pushVariant struct {
+	format    FilterFormat
+	summary   bool
+	indicator IndicatorType
+	encoding  WireEncoding
+}
+
+func (sub *subscriber) variant() pushVariant {
+	return pushVariant{sub.format, sub.summary, sub.indicator, sub.encoding}
+}
+
+// pushMessages holds a push encoded in each variant.
+type pushMessages map[pushVariant][]byte
+
+// size returns the length of the largest message in a format.
+func (m pushMessages) size(format FilterFormat) int {
+	n := 0
+	for v, data := range m {
+		if v.format == format {
+			n = max(n, len(data))
+		}
+	}
+	return n
+}
+
+// variants returns the push variants subscribers currently want.
+func (ss *subscriberSet) variants() map[pushVariant]bool {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+
+	out := make(map[pushVariant]bool, 2)
+	for _, sub := range ss.subs {
+		out[sub.variant()] = true
+	}
+	return out
+}
+
+// broadcast posts each subscriber the message for its variant, encoding
+// push, for its pump to deliver.  Subscribers whose variant is missing
+// from msgs, having joined since it was encoded, are skipped without
+// counting a drop; scheduled ones may have it held for later (see
+// pushschedule.go).  Long-polling waiters are woken last.  It returns the
+// number of subscribers posted the message.
+func (ss *subscriberSet) broadcast(msgs pushMessages, push pushEvent, policy evictionPolicy) int {
+	offered := 0
+	ss.mu.RLock()
+	for _, sub := range ss.subs {
+		data, ok := msgs[sub.variant()]
+		if !ok {
+			continue
+		}
+		offered++
+		sub.mail.post(pushNote{data: data, push: push, policy: policy})
+	}
+	ss.mu.RUnlock()
+
+	ss.watch.publish(push.version)
+	return offered
+}
+
+// evict unsubscribes a subscriber a push gave up on.
+func (ss *subscriberSet) evict(sub *subscriber) {
+	if ss.remove(sub) {
+		ss.evicted.Add(1)
+		info := sub.info()
+		ss.logs.Printf(logEvictedSubscriber, sub.id, "Evicted subscriber %s after %d dropped pushes", sub.id, info.Dropped)
+	}
+}
+
+// list returns every subscriber, oldest first.
+func (ss *subscriberSet) list() []SubscriberInfo {
+	ss.mu.RLock()
+	out := make([]SubscriberInfo, 0, len(ss.subs))
+	for _, sub := range ss.subs {
+		out = append(out, sub.info())
+	}
+	ss.mu.RUnlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].SubscribedAt.Equal(out[j].SubscribedAt) {
+			return out[i].SubscribedAt.Before(out[j].SubscribedAt)
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out
+}
+
+// requestBuffer reads the ?buffer= and ?mode= of a subscription opened by
+// a key of role, answering the request itself if they are invalid.  A
+// zero buffer means push.subscriber_buffer.
+func (s *SwarmAggregator) requestBuffer(w http.ResponseWriter, r *http.Request, role Role) (int, SubscriberMode, bool) {
+	q := r.URL.Query()
+	mode := SubscriberMode(q.Get("mode"))
+	if !mode.valid() {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid mode, want queue or latest")
+		return 0, "", false
+	}
+	if !q.Has("buffer") {
+		return 0, mode, true
+	}
+	limit := s.config.Push.maxBuffer(role)
+	buffer, err := strconv.Atoi(q.Get("buffer"))
+	if err != nil || buffer < 1 || buffer > limit {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, fmt.Sprintf("Invalid buffer, want 1 to %d for this key", limit))
+		return 0, "", false
+	}
+	return buffer, mode, true
+}
+
+// ListSubscribers returns every subscriber of the global swarm, oldest
+// first, followed by those of the staging tiers and of each namespace.
+func (s *SwarmAggregator) ListSubscribers() []SubscriberInfo {
+	out := s.subscribers.list()
+	if s.staging != nil {
+		for _, info := range s.staging.subscribers.list() {
+			info.Tier = TierStaging
+			out = append(out, info)
+		}
+		for _, info := range s.staging.both.list() {
+			info.Tier = TierBoth
+			out = append(out, info)
+		}
+	}
+	for _, ns := range s.namespaceList() {
+		for _, info := range ns.subscribers.list() {
+			info.Namespace = ns.name
+			out = append(out, info)
+		}
+	}
+	return out
+}
+
+// handleAdminSubscribers is the HTTP handler for GET /admin/subscribers.
+// With ?min_lag=N only acknowledging subscribers at least N versions
+// behind are listed (see ack.go).
+func (s *SwarmAggregator) handleAdminSubscribers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	minLag, ok := parseMinLag(r)
+	if !ok {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid min_lag")
+		return
+	}
+	subs := s.ListSubscribers()
+	if r.URL.Query().Has("min_lag") {
+		lagging := subs[:0]
+		for _, info := range subs {
+			if info.Acks && info.Lag >= minLag {
+				lagging = append(lagging, info)
+			}
+		}
+		subs = lagging
+	}
+	evicted, replaced := s.subscriberEvictions()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"subscribers": subs, "evicted": evicted, "replaced": replaced})
+}
+
+// subscriberSets returns every subscriber set: the global swarm's, the
+// staging tiers' and the namespaces'.
+func (s *SwarmAggregator) subscriberSets() []*subscriberSet {
+	sets := []*subscriberSet{s.subscribers}
+	if s.staging != nil {
+		sets = append(sets, s.staging.subscribers, s.staging.both)
+	}
+	for _, ns := range s.namespaceList() {
+		sets = append(sets, ns.subscribers)
+	}
+	return sets
+}
+
+// subscriberEvictions sums, over every set, the subscribers evicted for
+// falling behind and those replaced by a subscription with the same ID.
+func (s *SwarmAggregator) subscriberEvictions() (evicted, replaced int64) {
+	for _, ss := range s.subscriberSets() {
+		evicted += ss.evicted.Load()
+		replaced += ss.replaced.Load()
+	}
+	return evicted, replaced
+}
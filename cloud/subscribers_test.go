@@ -0,0 +1,191 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestUnreadSubscriberEvictedHealthyKept(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 1, MinDistinctSources: 1}
+	cfg.Push.SubscriberBuffer = 1
+	cfg.Push.EvictAfterDrops = 3
+	agg := NewSwarmAggregatorWithConfig(cfg)
+
+	healthy := agg.Subscribe("healthy")
+	defer agg.Unsubscribe("healthy")
+	stalled := agg.Subscribe("stalled")
+	defer agg.Unsubscribe("stalled") // already evicted; must not panic
+
+	for i := 0; i < 5; i++ {
+		agg.IngestReport(context.Background(), IOCReport{
+			Address: evmAddress(fmt.Sprintf("evict%d", i)), ChainID: 1,
+			Confidence: 1, Timestamp: time.Now(), SourceID: "agent-A",
+		})
+		select {
+		case <-healthy:
+		case <-time.After(time.Second):
+			t.Fatal("Healthy subscriber missed a push")
+		}
+	}
+
+	settlePushes(agg)
+	<-stalled // the one push that fit in its buffer
+	if _, ok := <-stalled; ok {
+		t.Fatal("Expected the stalled subscriber's channel to be closed")
+	}
+
+	subs := agg.ListSubscribers()
+	if len(subs) != 1 || subs[0].ID != "healthy" {
+		t.Fatalf("Expected only the healthy subscriber to remain, got %+v", subs)
+	}
+	if s := subs[0]; s.Delivered != 5 || s.Dropped != 0 || s.LastVersion != 5 || s.LastDeliveryAt == nil {
+		t.Errorf("Unexpected healthy subscriber stats %+v", s)
+	}
+}
+
+func TestSubscriberIdleEviction(t *testing.T) {
+	policy := evictionPolicy{maxIdle: time.Minute}
+	start := time.Now()
+	sub := &subscriber{id: "idle", ch: make(chan []byte, 1), subscribedAt: start}
+	logs := newLogSampler(t.Logf, logSampleKeys)
+
+	if sub.offer([]byte("v1"), 1, policy, logs, start) {
+		t.Fatal("A delivered push must not evict")
+	}
+	if sub.offer([]byte("v2"), 2, policy, logs, start.Add(30*time.Second)) {
+		t.Error("Dropping within the idle window must not evict")
+	}
+	if !sub.offer([]byte("v3"), 3, policy, logs, start.Add(time.Minute)) {
+		t.Error("Expected eviction a minute after the last delivery")
+	}
+	if info := sub.info(); info.Delivered != 1 || info.Dropped != 2 || info.LastVersion != 1 {
+		t.Errorf("Unexpected stats %+v", info)
+	}
+}
+
+func TestAdminSubscribersEndpoint(t *testing.T) {
+	agg := NewSwarmAggregator()
+	agg.keys.Add("admin-secret", APIKey{ID: "ops", Role: RoleAdmin})
+	agg.Subscribe("siem")
+	defer agg.Unsubscribe("siem")
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/subscribers", nil)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+
+	var resp struct {
+		Subscribers []SubscriberInfo `json:"subscribers"`
+	}
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if rec.Code != http.StatusOK || len(resp.Subscribers) != 1 || resp.Subscribers[0].ID != "siem" {
+		t.Errorf("Unexpected response %d %+v", rec.Code, resp)
+	}
+}
+
+func TestLatestOnlySubscriberKeepsNewestPush(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Push.EvictAfterDrops = 2
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	latest := agg.SubscribeWithOptions("latest", SubscribeOptions{Buffer: 1, Mode: SubscriberLatest})
+	defer agg.Unsubscribe("latest")
+	queued := agg.SubscribeWithOptions("queued", SubscribeOptions{Buffer: 2})
+	defer agg.Unsubscribe("queued")
+
+	blockAll(agg, evmAddress("one"), evmAddress("two"), evmAddress("three"), evmAddress("four")) // v4, unread
+	settlePushes(agg)
+	if v := envelopeVersion(<-latest); v != 4 || len(latest) != 0 {
+		t.Errorf("Expected the latest-only subscriber to hold just v4, got v%d and %d more", v, len(latest))
+	}
+	subs := agg.ListSubscribers()
+	if len(subs) != 1 || subs[0].ID != "latest" {
+		t.Fatalf("Expected the queued subscriber evicted and the latest-only one kept, got %+v", subs)
+	}
+	if s := subs[0]; s.Mode != SubscriberLatest || s.Buffer != 1 || s.BufferHigh != 1 || s.Dropped != 0 || s.Replaced != 3 || s.Delivered != 4 {
+		t.Errorf("Unexpected latest-only subscriber stats %+v", s)
+	}
+	for _, v := range []uint64{1, 2} {
+		if got := envelopeVersion(<-queued); got != v {
+			t.Errorf("Expected the queued subscriber's buffer to hold v%d, got v%d", v, got)
+		}
+	}
+}
+
+func TestSubscriberBufferCappedByRole(t *testing.T) {
+	agg := NewSwarmAggregator()
+	header := addTestSubscriber(agg)
+	srv := httptest.NewServer(agg.Routes())
+	t.Cleanup(srv.Close) // after the streams opened below are closed
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for query, want := range map[string]int{
+		"?buffer=65":   http.StatusBadRequest, // subscriber keys stop at 64
+		"?buffer=0":    http.StatusBadRequest,
+		"?mode=oldest": http.StatusBadRequest,
+	} {
+		if resp := openSSEFilter(t, ctx, srv, query, header); resp.StatusCode != want {
+			t.Errorf("%s: expected %d, got %d", query, want, resp.StatusCode)
+		}
+	}
+	if resp := openSSEFilter(t, ctx, srv, "?buffer=64&mode=latest", header); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the largest buffer allowed accepted, got %d", resp.StatusCode)
+	}
+	if subs := agg.ListSubscribers(); len(subs) != 1 || subs[0].Buffer != 64 || subs[0].Mode != SubscriberLatest {
+		t.Errorf("Expected a latest-only subscriber buffering 64, got %+v", subs)
+	}
+}
+
+func TestDuplicateSubscribeReplacesFirst(t *testing.T) {
+	agg := NewSwarmAggregator()
+	before := runtime.NumGoroutine()
+
+	first := agg.Subscribe("enterprise-1")
+	second := agg.Subscribe("enterprise-1")
+	select {
+	case _, ok := <-first:
+		if ok {
+			t.Fatal("Expected nothing delivered to the replaced subscriber")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the replaced subscriber's channel closed")
+	}
+	blockAll(agg, "0xA")
+	settlePushes(agg)
+	if len(second) != 1 {
+		t.Errorf("Expected the push delivered to the new subscriber, got %d queued", len(second))
+	}
+	if subs := agg.ListSubscribers(); len(subs) != 1 {
+		t.Errorf("Expected one subscriber, got %+v", subs)
+	}
+	if evicted, replaced := agg.subscriberEvictions(); evicted != 0 || replaced != 1 {
+		t.Errorf("Expected one replacement and no eviction, got %d and %d", replaced, evicted)
+	}
+	agg.Unsubscribe("enterprise-1")
+	for range second {
+	}
+
+	// Neither replaced nor replacing subscribers leave a pump behind.
+	for i := 0; i < 500; i++ {
+		a, b := agg.Subscribe("again"), agg.Subscribe("again")
+		agg.Unsubscribe("again")
+		for range a {
+		}
+		for range b {
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d goroutines after replacing subscribers, got %d", before, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,153 @@
+// Package swarm — Push summaries.
+//
+// Every push of the global filter carries a summary block in its
+// envelope: the additions and removals since the previous push, broken
+// down by chain and by category, and the size of the confirmed set, so a
+// dashboard can show "12 new mainnet drainers in the last push" without
+// diffing filters.  Chains are also named, as in chains.go.  The counts come from the filter's change history,
+// which records the chain and category of each confirmed entry as it is
+// added or removed, not from a rescan of the confirmed set.  A debounced
+// push covers every version since the last one sent, and an address
+// changed more than once in that range is counted once, by its final
+// state, as in a delta.  When the history no longer reaches back to the
+// previous push the summary is left out.
+//
+// The summary sits outside the signed payload.  A subscriber connecting
+// with ?summary=0 on /ws is sent pushes without it.  GET
+// /filter/diff?from=N returns the net change from version N to the
+// current one with the same summary; 410 Gone means the history no
+// longer reaches back to N.  Namespace filters keep no change history:
+// their pushes carry no summary and /filter/diff is answered 410.
+package swarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/aegis-protocol/swarm/types"
+)
+
+// uncategorizedSummary is the summary category of entries without one.
+const uncategorizedSummary = "uncategorized"
+
+// Change summaries (see the types package).
+type (
+	ChangeCounts  = types.ChangeCounts
+	FilterSummary = types.FilterSummary
+)
+
+// summarizeChanges counts a contiguous run of changes following from,
+// each address once by its last change.
+func summarizeChanges(from uint64, changes []FilterChange, total int) *FilterSummary {
+	sum := &FilterSummary{
+		FromVersion: from,
+		ToVersion:   from,
+		Chains:      make(map[int]ChangeCounts),
+		Categories:  make(map[string]ChangeCounts),
+		Total:       total,
+	}
+	last := make(map[string]FilterChange, len(changes))
+	for _, c := range changes {
+		last[c.Address] = c
+		sum.ToVersion = c.Version
+	}
+	for _, c := range last {
+		category := c.Category
+		if category == "" {
+			category = uncategorizedSummary
+		}
+		chain, cat := sum.Chains[c.ChainID], sum.Categories[category]
+		if c.Removed {
+			sum.Removed++
+			chain.Removed++
+			cat.Removed++
+		} else {
+			sum.Added++
+			chain.Added++
+			cat.Added++
+		}
+		sum.Chains[c.ChainID], sum.Categories[category] = chain, cat
+	}
+	return sum
+}
+
+// filterAddLocked adds a confirmed entry to the global filter, tagging
+// the change with its chain and category.  s.mu must be held.
+func (s *SwarmAggregator) filterAddLocked(entry *ConfirmedEntry) {
+	s.bloomFilter.AddTagged(entry.Address, entry.ChainID, entry.Category)
+}
+
+// filterRemoveLocked removes a confirmed entry from the global filter,
+// tagging the change as filterAddLocked does.  s.mu must be held.
+func (s *SwarmAggregator) filterRemoveLocked(entry *ConfirmedEntry) bool {
+	return s.bloomFilter.RemoveTagged(entry.Address, entry.ChainID, entry.Category)
+}
+
+// pushSummary summarizes the changes since the previous push, up to the
+// pushed snapshot, and records snap as pushed.  It returns nil when the
+// history does not reach back that far, or a later push already went out.
+func (s *SwarmAggregator) pushSummary(snap filterSnapshot) *FilterSummary {
+	var from uint64
+	for {
+		from = s.pushedVersion.Load()
+		if snap.version < from {
+			return nil
+		}
+		if s.pushedVersion.CompareAndSwap(from, snap.version) {
+			break
+		}
+	}
+	changes, _, ok := s.bloomFilter.ChangesSince(from)
+	if !ok {
+		return nil
+	}
+	for len(changes) > 0 && changes[len(changes)-1].Version > snap.version {
+		changes = changes[:len(changes)-1] // made after the snapshot
+	}
+	sum := summarizeChanges(from, changes, len(snap.entries))
+	sum.ChainNames = s.current().chainNames(sum.Chains)
+	return sum
+}
+
+// filterDiff is the body of GET /filter/diff.
+type filterDiff struct {
+	FilterDelta
+	Summary *FilterSummary `json:"summary"`
+}
+
+// handleFilterDiff is the HTTP handler for GET /filter/diff.
+func (s *SwarmAggregator) handleFilterDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	from, err := strconv.ParseUint(r.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid from version")
+		return
+	}
+	ns, ok := s.requestNamespace(w, r)
+	if !ok {
+		return
+	}
+	if ns != "" {
+		writeError(w, r, http.StatusGone, CodeVersionGone, "Namespace filters keep no change history")
+		return
+	}
+
+	s.mu.RLock()
+	changes, current, ok := s.bloomFilter.ChangesSince(from)
+	total := s.bloomFilter.Len()
+	s.mu.RUnlock()
+	if !ok {
+		writeError(w, r, http.StatusGone, CodeVersionGone, "History does not reach back to version "+strconv.FormatUint(from, 10))
+		return
+	}
+	diff := filterDiff{FilterDelta: newFilterDelta(from, changes), Summary: summarizeChanges(from, changes, total)}
+	diff.Summary.ChainNames = s.current().chainNames(diff.Summary.Chains)
+	diff.Version, diff.Summary.ToVersion = current, current
+	diff.BloomParams = s.bloomFilter.Params()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(diff)
+}
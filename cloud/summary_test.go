@@ -0,0 +1,109 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func promoteOn(agg *SwarmAggregator, addr string, chainID int, category string) {
+	for _, source := range []string{"agent-A", "agent-B"} {
+		agg.IngestReport(context.Background(), IOCReport{Address: addr, ChainID: chainID, Category: category, Confidence: 0.9, Timestamp: time.Now(), SourceID: source})
+	}
+}
+
+func recvEnvelope(t *testing.T, ch chan []byte) FilterEnvelope {
+	t.Helper()
+	select {
+	case data := <-ch:
+		var env FilterEnvelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			t.Fatalf("Decode push: %v", err)
+		}
+		return env
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for a push")
+	}
+	return FilterEnvelope{}
+}
+
+func TestCoalescedPushSummary(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Push.Debounce = Duration(50 * time.Millisecond)
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	ctx := context.Background()
+
+	kept, dropped := evmAddress("kept"), evmAddress("dropped")
+	promoteOn(agg, kept, 1, "phishing")
+	promoteOn(agg, dropped, 1, "drainer")
+	time.Sleep(100 * time.Millisecond) // let the first push go out
+	version := agg.bloomFilter.Version()
+
+	ch := agg.Subscribe("summary")
+	defer agg.Unsubscribe("summary")
+	bare := agg.SubscribeWithOptions("bare", SubscribeOptions{NoSummary: true})
+	defer agg.Unsubscribe("bare")
+
+	drainers := []string{evmAddress("drainer-1"), evmAddress("drainer-2"), evmAddress("drainer-3")}
+	for _, addr := range drainers {
+		promoteOn(agg, addr, 1, "drainer")
+	}
+	promoteOn(agg, evmAddress("polygon"), 137, "")
+	agg.Unblock(ctx, dropped)
+	agg.Unblock(ctx, drainers[2])
+
+	env := recvEnvelope(t, ch)
+	sum := env.Summary
+	if sum == nil {
+		t.Fatal("Expected a summary on the push")
+	}
+	if sum.FromVersion != version || sum.ToVersion != env.Version || sum.Total != agg.BloomFilterLen() {
+		t.Errorf("Expected the summary to cover v%d..v%d with %d entries, got %+v", version, env.Version, agg.BloomFilterLen(), sum)
+	}
+	if sum.ChangeCounts != (ChangeCounts{Added: 3, Removed: 2}) {
+		t.Errorf("Expected 3 net additions and 2 removals across the coalesced versions, got %+v", sum.ChangeCounts)
+	}
+	if sum.Chains[1] != (ChangeCounts{Added: 2, Removed: 2}) || sum.Chains[137] != (ChangeCounts{Added: 1}) {
+		t.Errorf("Unexpected per-chain counts %+v", sum.Chains)
+	}
+	if sum.Categories["drainer"] != (ChangeCounts{Added: 2, Removed: 2}) || sum.Categories[uncategorizedSummary] != (ChangeCounts{Added: 1}) {
+		t.Errorf("Unexpected per-category counts %+v", sum.Categories)
+	}
+
+	if env := recvEnvelope(t, bare); env.Summary != nil {
+		t.Errorf("Expected no summary for a subscriber that opted out, got %+v", env.Summary)
+	}
+}
+
+func TestFilterDiffSummary(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2})
+	promoteOn(agg, evmAddress("before"), 1, "phishing")
+	from := agg.bloomFilter.Version()
+	promoteOn(agg, evmAddress("after"), 10, "drainer")
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/filter/diff?from="+strconv.FormatUint(from, 10), nil))
+	var diff filterDiff
+	if err := json.NewDecoder(rec.Body).Decode(&diff); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /filter/diff: %d %v", rec.Code, err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0] != evmAddress("after") || diff.Version != agg.bloomFilter.Version() {
+		t.Errorf("Expected the one addition since v%d, got %+v", from, diff.FilterDelta)
+	}
+	if s := diff.Summary; s == nil || s.Chains[10] != (ChangeCounts{Added: 1}) || s.Categories["drainer"] != (ChangeCounts{Added: 1}) || s.Total != 2 {
+		t.Errorf("Unexpected summary %+v", diff.Summary)
+	}
+
+	for query, want := range map[string]int{"?from=x": http.StatusBadRequest, "?from=99": http.StatusGone} {
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/filter/diff"+query, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", query, want, rec.Code)
+		}
+	}
+}
@@ -1,4 +1,4 @@
-// Package main — Aegis Swarm Consensus Engine.
+// Package swarm — Aegis Swarm Consensus Engine.
 //
 // Centralized cloud service that ingests anonymous Indicators of
 // Compromise (IOCs) from all Aegis SDK instances globally.  Uses TWAB
@@ -7,24 +7,119 @@
 // it via WebSockets to Enterprise clients.
 //
 // Phase 5.1 of the v2.0 roadmap.
-package main
+//
+// The aggregator is importable as github.com/aegis-protocol/swarm, with
+// the Bloom filter in its bloom package, the Go SDK in client and an
+// end-to-end test harness in aegistest; the server binary,
+// cmd/swarm-aggregator, only calls Run.  The TWAB and the report types
+// stay in this package rather than in twab and types packages: they
+// share the aggregator's config, clock and source tiers, and its
+// snapshots restore their unexported state, so splitting them would
+// export much of the aggregator for callers this package already serves.
+package swarm
 
 import (
+	"context"
 	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // IOCReport is an anonymous Indicator of Compromise report from an Aegis SDK.
 type IOCReport struct {
-	Address    string    `json:"address"`
+	Address    string    `json:"address"` // the indicator's value
 	Selector   string    `json:"selector,omitempty"`
 	ChainID    int       `json:"chain_id"`
+	Category   string    `json:"category,omitempty"`
 	Confidence float64   `json:"confidence"`
 	Timestamp  time.Time `json:"timestamp"`
 	SourceID   string    `json:"source_id"` // anonymous hash of the reporting agent
+
+	// ReportID makes retries of the report idempotent (see idempotency.go).
+	ReportID string `json:"report_id,omitempty"`
+
+	// IndicatorType is what Address holds, an on-chain address when
+	// empty (see indicator.go).
+	IndicatorType IndicatorType `json:"indicator_type,omitempty"`
+
+	// Severity rates what the reporter saw from 1 to 5, zero if unrated
+	// (see severityband.go).
+	Severity int `json:"severity,omitempty"`
+
+	// Evidence the report cites, for analysts (see evidence.go).
+	Evidence []Evidence `json:"evidence,omitempty"`
+
+	// Sanitized names the rewrites made to the report before it was
+	// stored (see sanitize.go).  It is set by the aggregator, never taken
+	// from the report.
+	Sanitized []string `json:"sanitized,omitempty"`
+
+	// Namespace is the tenant the report belongs to, taken from the
+	// reporter's API key; "" is the global swarm.
+	Namespace string `json:"-"`
+
+	// ReceivedAt is when the server accepted the report (see skew.go);
+	// zero for reports recorded in-process, which TWAB dates by Timestamp.
+	ReceivedAt time.Time `json:"-"`
+
+	// Network is the hash of the network the report was sent from (see
+	// network.go); empty if it arrived without a remote address.
+	Network string `json:"-"`
+
+	// SourceTier is the tier of the key the report was sent with (see
+	// sourcetier.go); empty for reports recorded in-process.
+	SourceTier SourceTier `json:"-"`
+
+	// Calibrated is the confidence mapped through the source's
+	// calibration curve (see calibration.go), which its TWAB weight sums;
+	// zero weighs the raw confidence.
+	Calibrated float64 `json:"-"`
+}
+
+// Provenance records where an address came from: organic SDK consensus,
+// an imported threat feed, a federated peer region, or a replicating
+// peer instance.
+type Provenance struct {
+	Source     string    `json:"source"`         // "consensus", "feed:<name>", "merge:<region>", "replica:<instance>", or "mirror"
+	Mode       FeedMode  `json:"mode,omitempty"` // feed imports only
+	Category   string    `json:"category,omitempty"`
+	Reason     string    `json:"reason,omitempty"`   // admin force-adds only
+	Region     string    `json:"region,omitempty"`   // federated merges only
+	Instance   string    `json:"instance,omitempty"` // replicated and mirrored entries only
+	ImportedAt time.Time `json:"imported_at"`
+}
+
+// provenanceConsensus is the Source tag for addresses promoted by TWAB.
+const provenanceConsensus = "consensus"
+
+// ConfirmedEntry is the server-side record behind a Bloom filter entry.
+type ConfirmedEntry struct {
+	Address    string     `json:"address"`
+	ChainID    int        `json:"chain_id"`
+	Category   string     `json:"category,omitempty"`
+	Confidence float64    `json:"confidence"`
+	PromotedAt time.Time  `json:"promoted_at"`
+	Provenance Provenance `json:"provenance"`
+
+	// ExpiresAt is when the entry drops out of the filter unless a fresh
+	// report arrives; nil if it never expires.  Replaced, never mutated.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
 }
 
 // SwarmAggregator ingests IOC reports and compiles a consensus Bloom filter.
@@ -32,139 +127,1228 @@ type SwarmAggregator struct {
 	mu          sync.RWMutex
 	bloomFilter *BloomFilter
 	twab        *TWAB
-	subscribers map[string]chan []byte // subscriber_id -> channel
-	subMu       sync.RWMutex
+	subscribers *subscriberSet
+	keySubs     *keySubscriptions // open WebSocket subscriptions per API key
+	chunks      *chunker          // splits large WebSocket messages
+	tracer      trace.Tracer
+	keys        *KeyStore
+	trust       *trustGrants // keys granted the trusted source tier
+	growth      filterGrowth // of the confirmed set, for auto-sizing
+	signer      *Keyring
+	metrics     *Metrics
+	config      Config // as started; see current for reloadable settings
+	live        atomic.Pointer[Config]
+	limiter     *ingestLimiter
+	idempotency *idempotencyCache // nil when disabled
+	maintenance *Maintenance
+	clock       Clock       // time of ingest and consensus (see clock.go)
+	logs        *logSampler // hot-path warnings
+
+	watchlist    atomic.Pointer[watchlist] // nil until first computed
+	watchLimiter *ingestLimiter            // watchlist polls per API key
+	disputeLimit *ingestLimiter            // disputes per API key
+	quotas       *quotaTracker
+	cooldowns    *cooldownList
+	disputes     *disputeTracker
+	ingest       *ingestQueue // nil processes reports in the handler
+	alerts       *alertDispatcher
+	stats        *consensusStats
+	latency      *latencyTracker
+	burnRate     *burnRateTracker
+	calibration  *calibrationTracker
+	retention    retentionState
+	tripwire     *similarityIndex // protected addresses (see tripwire.go)
+	bitPatches   *bitHistory
+	sourceStats  *sourceStats
+	replicator   *replicator      // nil without replication peers
+	mirror       *mirrorState     // nil unless mirroring an upstream
+	standby      *standbyState    // nil unless started as a standby
+	audit        *AuditLogger     // nil without persistence.audit_log_file
+	shadow       *shadowEvaluator // nil without shadow candidates
+	review       *reviewQueue
+	networks     *networkHasher
+	staging      *stagingTier   // nil without staging.enabled
+	sanctions    *sanctionsSync // nil without sanctions.feeds
+	verification *evidenceVerification
+	events       *eventBus
+
+	nsMu       sync.Mutex
+	namespaces map[string]*namespace // tenant name -> isolated state
+
+	pushMu        sync.Mutex
+	pushPending   bool                        // a debounced push is scheduled
+	pushedVersion atomic.Uint64               // version of the last global push, for its summary
+	pushes        pushQueue                   // orders global pushes (see pushqueue.go)
+	filterState   atomic.Pointer[FilterState] // capture of the global filter (see filterstate.go)
+	identity      atomic.Pointer[Identity]    // instance UUID and epoch (see epoch.go)
+
+	reloadMu     sync.Mutex    // serializes reloads
+	configSource *ConfigSource // nil until SetConfigSource
+
+	// Guarded by mu.
+	confirmed map[string]*ConfirmedEntry // address -> confirmed record
+	feedTags  map[string][]Provenance    // address -> feeds that listed it
+	allowlist map[string]bool            // addresses consensus may never promote
+	fileAllow map[string]bool            // those of allowlist read from persistence.allowlist_file
+	expiries  expiryQueue                // deadlines of expiring entries
+	raisedCap int                        // filter cap raised by the rebuild overflow policy
+	staged    map[string]*stagedEntry    // address -> soaking in the staging filter
+
+	// Version vector, guarded by mu (see replication.go).
+	replicaChanges uint64            // filter versions spent applying peer promotions
+	peerVersions   map[string]uint64 // peer instance -> its own component
 }
 
-// NewSwarmAggregator creates a new aggregator with default TWAB config.
+// NewSwarmAggregator creates a new aggregator with the default config.
 func NewSwarmAggregator() *SwarmAggregator {
-	return &SwarmAggregator{
-		bloomFilter: NewBloomFilter(),
-		twab:        NewTWAB(DefaultTWABConfig()),
-		subscribers: make(map[string]chan []byte),
-	}
+	return NewSwarmAggregatorWithConfig(DefaultConfig())
 }
 
-// NewSwarmAggregatorWithConfig creates an aggregator with custom TWAB config.
-func NewSwarmAggregatorWithConfig(config TWABConfig) *SwarmAggregator {
-	return &SwarmAggregator{
-		bloomFilter: NewBloomFilter(),
-		twab:        NewTWAB(config),
-		subscribers: make(map[string]chan []byte),
+// NewSwarmAggregatorWithConfig creates an aggregator from a full config.
+// The config is expected to have passed Validate.
+func NewSwarmAggregatorWithConfig(config Config) *SwarmAggregator {
+	signer, err := NewEphemeralKeyring()
+	if err != nil {
+		panic(fmt.Sprintf("generate signing key: %v", err))
+	}
+	logs := newLogSampler(log.Printf, logSampleKeys)
+	s := &SwarmAggregator{
+		bloomFilter:  NewBloomFilterWithConfig(config.Bloom, config.Push.ResumeHistory),
+		twab:         NewTWAB(config.TWAB),
+		subscribers:  newSubscriberSet(logs),
+		logs:         logs,
+		keySubs:      newKeySubscriptions(config.Push.MaxSubscriptionsPerKey),
+		chunks:       newChunker(config.Push.ChunkSize),
+		tracer:       defaultTracer(),
+		keys:         NewKeyStore(),
+		trust:        newTrustGrants(),
+		signer:       signer,
+		config:       config,
+		clock:        systemClock{},
+		limiter:      newIngestLimiter(config.RateLimit),
+		watchLimiter: newWatchLimiter(config.Watchlist),
+		disputeLimit: newFeedbackLimiter(config.Feedback),
+		idempotency:  newIdempotencyCache(config.Ingest),
+		quotas:       newQuotaTracker(config.Quota),
+		cooldowns:    newCooldownList(config.Cooldown.MaxEntries),
+		disputes:     newDisputeTracker(),
+		alerts:       newAlertDispatcher(config.Alerts),
+		stats:        newConsensusStats(),
+		latency:      newLatencyTracker(),
+		burnRate:     newBurnRateTracker(),
+		calibration:  newCalibrationTracker(),
+		bitPatches:   newBitHistory(),
+		sourceStats:  newSourceStats(maxSourceStats),
+		review:       newReviewQueue(),
+		tripwire:     newSimilarityIndex(config.Tripwire),
+		networks:     newNetworkHasher(),
+		staging:      newStagingTier(config, logs),
+		staged:       make(map[string]*stagedEntry),
+		confirmed:    make(map[string]*ConfirmedEntry),
+		feedTags:     make(map[string][]Provenance),
+		allowlist:    make(map[string]bool),
+		fileAllow:    make(map[string]bool),
+		namespaces:   make(map[string]*namespace),
+
+		peerVersions: make(map[string]uint64),
+	}
+	s.mirror = newMirrorState(config.Mirror)
+	s.standby = newStandbyState(config.Standby, time.Now())
+	s.live.Store(&config)
+	identity := newIdentity(time.Now())
+	s.identity.Store(&identity)
+	for name := range config.Namespaces {
+		s.namespace(name)
+	}
+	s.metrics = newMetrics(s)
+	s.events = newEventBus(config.Events, config.Replication.InstanceID, s.metrics.eventsPublished, s.metrics.eventSubscribersDropped)
+	s.events.handle(s.pushOnEvents, EventPromoted, EventRemoved, EventExpired, EventRetracted)
+	s.shadow = newShadowEvaluator(config.Shadow, s.metrics.shadowPromotions, s.metrics.shadowVerdicts)
+	if config.Replication.Enabled() {
+		s.replicator = newReplicator(config.Replication, s.metrics.replicationEvents)
 	}
+	s.sanctions = newSanctionsSync(config.Sanctions, s.metrics.sanctionsSyncs)
+	s.verification = newEvidenceVerification(config.EvidenceVerification, s.metrics.evidenceChecks)
+	s.maintenance = newMaintenance(config.Maintenance, s.clock, s.metrics.maintenance)
+	s.registerMaintenance()
+	return s
 }
 
 // IngestReport processes a new IOC report.
 //
 // The report is added to the TWAB tracker.  If the address meets the
-// consensus threshold (enough independent reports over time), it is
-// added to the Bloom filter and pushed to all subscribers.
-func (s *SwarmAggregator) IngestReport(report IOCReport) bool {
-	s.mu.Lock()
-	s.twab.Record(report.Address, report)
+// consensus threshold (enough independent reports over time) and is not
+// cooling down after a removal (see cooldown.go), it is
+// added to the Bloom filter and pushed to all subscribers, or queued for
+// an analyst under the review policy (see review.go).  Reports for a
+// tenant namespace only reach that namespace (see namespace.go).
+//
+// TWAB recording and threshold evaluation only lock the address's shard;
+// s.mu is taken just long enough to update the confirmed set, so ingests
+// for unrelated addresses proceed in parallel.
+func (s *SwarmAggregator) IngestReport(ctx context.Context, report IOCReport) bool {
+	ctx, span := s.tracer.Start(ctx, spanIngestReport, trace.WithAttributes(
+		attrChainID.Int(report.ChainID),
+	))
+	defer span.End()
+	if err := s.normalizeReport(&report); err != nil {
+		s.logs.Printf(logDroppedReport, report.SourceID, "Dropping report from %q: %v", report.SourceID, err)
+		return false
+	}
+	if err := s.checkEvidence(&report); err != nil {
+		s.logs.Printf(logDroppedReport, report.SourceID, "Dropping report from %q: %v", report.SourceID, err)
+		return false
+	}
+	s.metrics.reportsIngested.Inc()
+	if report.Namespace != "" {
+		promoted := s.ingestNamespace(ctx, report)
+		span.SetAttributes(attrPromoted.Bool(promoted))
+		return promoted
+	}
+	now := s.clock.Now()
+	s.stats.recordReport(report, now)
+	s.sourceStats.recordReport(report.SourceID, report.Address, now)
 
-	if s.twab.MeetsThreshold(report.Address) {
-		s.bloomFilter.Add(report.Address)
-		s.mu.Unlock()
-		s.pushToSubscribers()
-		return true // address was added to filter
+	report.Calibrated = s.calibration.calibrate(report.SourceID, report.Confidence, s.current().Calibration)
+	_, recordSpan := s.tracer.Start(ctx, spanTWABRecord)
+	recorded := s.twab.Record(report.Address, report)
+	recordSpan.End()
+	s.queueEvidenceChecks(report)
+	accepted := reportEvent(EventReportAccepted, report)
+	accepted.SourceID, accepted.Time = report.SourceID, now
+	accepted.report = &StandbyReport{IOCReport: report, Recorded: recorded, ReceivedAt: report.ReceivedAt, Network: report.Network, SourceTier: report.SourceTier, Calibrated: report.Calibrated}
+	s.events.publish(ctx, accepted)
+
+	_, thresholdSpan := s.tracer.Start(ctx, spanTWABThreshold)
+	promoted := s.twab.MeetsThreshold(report.Address, s.current().TWAB)
+	thresholdSpan.SetAttributes(attrPromoted.Bool(promoted))
+	thresholdSpan.End()
+	s.shadow.evaluate(s.twab, report.Address, promoted, now)
+	if promoted && s.coolingDown(report.Address, now) {
+		promoted = false
 	}
 
+	if promoted {
+		promoted = s.promoteConsensus(ctx, report, now, s.reviews(report))
+	} else {
+		s.flagDisqualified(report, now)
+	}
+	span.SetAttributes(attrPromoted.Bool(promoted))
+	return promoted
+}
+
+// promoteConsensus adds an address that met consensus to the confirmed
+// set and the filter, or refreshes its expiry if it is already there, and
+// pushes.  An allowlisted address is left out, a new one resembling a
+// protected address is queued for review (see tripwire.go), and with
+// review set any new one is (see review.go).  With staging a new
+// one soaks in the staging filter first (see staging.go).  A new one past
+// bloom.max_filter_entries is handled by the overflow policy (see
+// filtercap.go).  It reports whether the address is in the filter.
+func (s *SwarmAggregator) promoteConsensus(ctx context.Context, report IOCReport, now time.Time, review bool) bool {
+	s.mu.Lock()
+	if s.allowlist[report.Address] {
+		s.mu.Unlock()
+		return false
+	}
+	var fresh *ConfirmedEntry // copied under the lock, for the alert and peers
+	var hit *capHit
+	var soaking, staged bool
+	if entry, ok := s.confirmed[report.Address]; ok {
+		s.refreshExpiryLocked(entry, now)
+	} else if match, near := s.tripwire.near(report.Address); near {
+		s.mu.Unlock()
+		s.quarantine(report, match, now)
+		return false
+	} else if review {
+		s.mu.Unlock()
+		s.queueForReview(report, now)
+		return false
+	} else if soaking, staged = s.stageLocked(report, now); soaking {
+		s.mu.Unlock()
+		if staged {
+			ev := reportEvent(EventPromoted, report)
+			ev.Source, ev.Tier, ev.SourceTier = provenanceConsensus, TierStaging, report.SourceTier.orAnonymous()
+			ev.SeverityBand = s.twab.SeverityBand(report.Address, s.current().TWAB)
+			s.events.publish(ctx, ev)
+		}
+		return false
+	} else if hit = s.makeRoomLocked(); hit != nil && hit.rejected {
+		staged = s.unstageLocked(report.Address)
+		s.mu.Unlock()
+		if staged {
+			ev := reportEvent(EventRemoved, report)
+			ev.FromTier, ev.Reason = TierStaging, eventReasonFilterCap
+			s.events.publish(ctx, ev)
+		}
+		s.reportCapHit(ctx, report, *hit, now)
+		s.queueForReview(report, now)
+		return false
+	} else {
+		staged = s.unstageLocked(report.Address) // graduating
+		entry = &ConfirmedEntry{
+			Address:    report.Address,
+			ChainID:    report.ChainID,
+			Category:   report.Category,
+			Confidence: report.Confidence,
+			PromotedAt: now,
+			Provenance: Provenance{Source: provenanceConsensus, ImportedAt: now},
+		}
+		s.confirmed[report.Address] = entry
+		s.scheduleExpiryLocked(entry, now)
+		s.metrics.promotions.Inc()
+		copied := *entry
+		fresh = &copied
+	}
+	s.filterAddLocked(s.confirmed[report.Address])
+	ownVersion := s.ownVersionLocked()
+	if fresh != nil {
+		s.recordPromotionLatencyLocked(report, now, s.bloomFilter.Version())
+	}
 	s.mu.Unlock()
-	return false
+	if fresh == nil {
+		s.pushToSubscribers(ctx) // refreshed, not promoted
+		return true
+	}
+
+	s.replicatePromotion(*fresh, ownVersion)
+	if summary, ok := s.twab.Summary(report.Address); ok {
+		s.stats.recordPromotion(now.Sub(summary.FirstSeen), now)
+	}
+	s.sourceStats.recordPromotion(report.Address, now)
+	s.burnRate.record(report.ChainID, now)
+	s.calibration.record(s.twab.SourceConfidences(report.Address), true, s.current().Calibration)
+	s.alertPromotion(*fresh, report.SourceTier.orAnonymous())
+	var events []Event
+	if hit != nil && hit.evicted != nil {
+		ev := entryEvent(EventRemoved, hit.evicted)
+		ev.FromTier, ev.Reason = TierMain, eventReasonEvicted
+		events = append(events, ev)
+	}
+	ev := entryEvent(EventPromoted, fresh)
+	ev.Source, ev.Tier, ev.SourceTier = provenanceConsensus, TierMain, report.SourceTier.orAnonymous()
+	ev.SeverityBand = s.twab.SeverityBand(report.Address, s.current().TWAB)
+	if staged {
+		ev.FromTier = TierStaging // graduating
+	}
+	ev.pushed = hit != nil && s.reportCapHit(ctx, report, *hit, now)
+	s.events.publish(ctx, append(events, ev)...)
+	return true // address was added to filter
 }
 
-// BloomFilterLen returns the number of addresses in the Bloom filter.
+// Confirmed returns a copy of the confirmed record for an address.
+func (s *SwarmAggregator) Confirmed(address string) (ConfirmedEntry, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entry, ok := s.confirmed[address]
+	if !ok {
+		return ConfirmedEntry{}, false
+	}
+	return *entry, true
+}
+
+// FeedTags returns the feeds that have listed an address.
+func (s *SwarmAggregator) FeedTags(address string) []Provenance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Provenance(nil), s.feedTags[address]...)
+}
+
+// BloomFilterLen returns the number of addresses in the Bloom filter, as
+// of its current FilterSnapshot.
 func (s *SwarmAggregator) BloomFilterLen() int {
-	return s.bloomFilter.Len()
+	return s.FilterSnapshot().Count
 }
 
-// Subscribe registers a new WebSocket subscriber.
+// SubscribeOptions configures a subscription.
+type SubscribeOptions struct {
+	Format FilterFormat // FormatBloom if empty
+	Key    string       // name of the API key subscribing, if any
+
+	// NoSummary leaves the summary out of pushes (see summary.go).
+	NoSummary bool
+
+	// IndicatorType limits pushes to entries of that type (see
+	// indicator.go); such pushes carry no summary.
+	IndicatorType IndicatorType
+
+	// Acks records the versions the subscriber acknowledges and redelivers
+	// those it does not (see ack.go).
+	Acks bool
+
+	// Tier selects the main filter, the default, the staging filter, or
+	// both (see staging.go).
+	Tier SubscriptionTier
+
+	// Buffer is the pushes queued for the subscriber, push.subscriber_buffer
+	// if zero, and Mode what a push finding them full does, SubscriberQueue
+	// if empty (see subscribers.go).
+	Buffer int
+	Mode   SubscriberMode
+
+	// Encoding is that of the pushes, WireJSON if empty (see encoding.go).
+	Encoding WireEncoding
+
+	// Schedule batches pushes into at most one per window (see
+	// pushschedule.go).
+	Schedule PushSchedule
+}
+
+// Subscribe registers a new WebSocket subscriber to the Bloom format.  A
+// subscriber already holding id is replaced, and its channel closed.
 func (s *SwarmAggregator) Subscribe(id string) chan []byte {
-	s.subMu.Lock()
-	defer s.subMu.Unlock()
+	return s.SubscribeWithOptions(id, SubscribeOptions{})
+}
 
-	ch := make(chan []byte, 16)
-	s.subscribers[id] = ch
-	return ch
+// SubscribeWithOptions registers a new subscriber with the given options,
+// replacing any with the same ID as Subscribe does.
+func (s *SwarmAggregator) SubscribeWithOptions(id string, opts SubscribeOptions) chan []byte {
+	if opts.Format == "" {
+		opts.Format = FormatBloom
+	}
+	return s.tierSubscribers(opts.Tier).subscribe(id, s.config.Push.SubscriberBuffer, opts, s.clock.Now()).ch
+}
+
+// TrySubscribe registers a new subscriber as SubscribeWithOptions does,
+// but fails with ErrDuplicateSubscriber rather than replace one already
+// holding id.
+func (s *SwarmAggregator) TrySubscribe(id string, opts SubscribeOptions) (chan []byte, error) {
+	if opts.Format == "" {
+		opts.Format = FormatBloom
+	}
+	sub, err := s.tierSubscribers(opts.Tier).add(id, s.config.Push.SubscriberBuffer, opts, s.clock.Now(), false)
+	if err != nil {
+		return nil, err
+	}
+	return sub.ch, nil
 }
 
-// Unsubscribe removes a subscriber.
+// Unsubscribe removes a subscriber of the global swarm, a staging tier or
+// a namespace.  Evicted subscribers are already gone.
 func (s *SwarmAggregator) Unsubscribe(id string) {
-	s.subMu.Lock()
-	defer s.subMu.Unlock()
+	if s.subscribers.unsubscribe(id) {
+		return
+	}
+	if s.staging != nil && (s.staging.subscribers.unsubscribe(id) || s.staging.both.unsubscribe(id)) {
+		return
+	}
+	for _, ns := range s.namespaceList() {
+		if ns.subscribers.unsubscribe(id) {
+			return
+		}
+	}
+}
+
+// pushToSubscribers pushes the current filter to all subscribers.  With a
+// push debounce configured, changes inside the window are coalesced into
+// one push at its end, traced as a child of the change that opened it.
+func (s *SwarmAggregator) pushToSubscribers(ctx context.Context) {
+	s.schedulePush(ctx, &s.pushMu, &s.pushPending, s.pushNow)
+}
+
+// schedulePush runs push now, or once at the end of the debounce window
+// if none is already pending.  mu guards pending.
+func (s *SwarmAggregator) schedulePush(ctx context.Context, mu *sync.Mutex, pending *bool, push func(context.Context)) {
+	debounce := time.Duration(s.current().Push.Debounce)
+	if debounce <= 0 {
+		push(ctx)
+		return
+	}
 
-	if ch, ok := s.subscribers[id]; ok {
-		close(ch)
-		delete(s.subscribers, id)
+	mu.Lock()
+	defer mu.Unlock()
+	if *pending {
+		return
 	}
+	*pending = true
+	parent := trace.SpanContextFromContext(ctx)
+	time.AfterFunc(debounce, func() {
+		mu.Lock()
+		*pending = false
+		mu.Unlock()
+		push(trace.ContextWithSpanContext(context.Background(), parent))
+	})
+}
+
+// pushNow serializes and signs the Bloom filter and sends the envelope to
+// all subscribers, including namespaces that merge the global filter.
+func (s *SwarmAggregator) pushNow(ctx context.Context) {
+	s.pushSnapshot(ctx, s.globalSnapshot())
 }
 
-// pushToSubscribers serializes the Bloom filter and sends it to all subscribers.
-func (s *SwarmAggregator) pushToSubscribers() {
-	data, err := s.bloomFilter.Serialize()
+// pushSnapshot is pushNow for a snapshot of the global filter already
+// taken.  Snapshots are broadcast in version order (see pushqueue.go).
+func (s *SwarmAggregator) pushSnapshot(ctx context.Context, snap filterSnapshot) {
+	s.pushes.offer(ctx, snap)
+	s.pushes.drain(s.broadcastSnapshot)
+}
+
+// broadcastSnapshot sends a snapshot of the global filter to its
+// subscribers; only the push queue calls it.
+func (s *SwarmAggregator) broadcastSnapshot(ctx context.Context, snap filterSnapshot) {
+	ctx, span := s.tracer.Start(ctx, spanPush)
+	defer span.End()
+
+	_, serializeSpan := s.tracer.Start(ctx, spanBloomSerialize)
+	summary := s.pushSummary(snap)
+	msgs, built, err := s.encodePush(s.subscribers, snap, summary)
 	if err != nil {
-		log.Printf("Failed to serialize bloom filter: %v", err)
+		serializeSpan.RecordError(err)
+		serializeSpan.SetStatus(codes.Error, "serialize failed")
+		serializeSpan.End()
+		s.logs.Printf(logSerializeFailure, "", "Failed to serialize bloom filter: %v", err)
 		return
 	}
+	serializeSpan.SetAttributes(attrPayloadSize.Int(msgs.size(FormatBloom)))
+	serializeSpan.End()
 
-	s.subMu.RLock()
-	defer s.subMu.RUnlock()
+	span.SetAttributes(attrSubscribers.Int(s.subscribers.len()))
+	push := pushEvent{version: snap.version, severity: s.pushSeverity(summary), at: s.clock.Now()}
+	offered := s.subscribers.broadcast(msgs, push, newEvictionPolicy(s.config.Push))
+	s.recordPushCache(offered, built)
+	s.recordPushLatency(snap.version)
+	s.retainPushedBits(snap)
 
-	for id, ch := range s.subscribers {
-		select {
-		case ch <- data:
-		default:
-			log.Printf("Subscriber %s too slow, skipping push", id)
-		}
+	s.pushMergingNamespaces(ctx)
+	if s.staging != nil {
+		s.pushTier(ctx, s.staging.both, s.bothSnapshot())
 	}
 }
 
-// handleIngest is the HTTP handler for POST /ingest.
+// handleIngest is the HTTP handler for POST /ingest.  With ?verbose=1 a
+// report processed inline is answered with its threshold explanation.
 func (s *SwarmAggregator) handleIngest(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := s.tracer.Start(ctx, spanHandleIngest, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
+	if r.Method != http.MethodPost {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	ns, ok := s.requestNamespace(w, r)
+	if !ok {
+		return
+	}
+	limitBody(w, r, s.config.Ingest.MaxBodyBytes)
+	report, err := decodeReport(r)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if report.Address == "" {
+		writeError(w, r, http.StatusBadRequest, CodeMissingAddress, "Missing address")
+		return
+	}
+	report.Namespace = ns
+	report.Network = s.requestNetwork(r)
+	report.SourceTier = s.requestSourceTier(r)
+
+	id := r.Header.Get(headerIdempotencyKey)
+	if id == "" {
+		id = report.ReportID
+	} else if len(id) > maxReportIDLen {
+		writeAPIError(w, r, &ValidationError{Field: headerIdempotencyKey, Reason: fmt.Sprintf("%d bytes, at most %d allowed", len(id), maxReportIDLen)})
+		return
+	}
+	owned, original, err := s.claimIngest(ctx, idempotencyKey(report, id))
+	if err != nil {
+		writeAPIError(w, r, err) // timed out, or the client went away
+		return
+	}
+	if original != nil {
+		if ns == "" {
+			s.sourceStats.recordDuplicate(report.SourceID, s.clock.Now())
+		}
+		writeIngestResult(w, r, original.status, original.result.replayed())
+		return
+	}
+	if !s.allowIngest(w, r) {
+		s.idempotency.abandon(owned)
+		return
+	}
+
+	res, err := s.acceptReport(ctx, report)
+	if err != nil {
+		s.idempotency.abandon(owned)
+		writeAPIError(w, r, err)
+		return
+	}
+	if r.URL.Query().Get("verbose") == "1" && res.IngestID == "" {
+		if address, err := NormalizeAddress(report.ChainID, report.Address); err == nil {
+			explanation := s.explain(report.Namespace, address)
+			res.Explanation = &explanation
+		}
+	}
+	span.SetAttributes(attrChainID.Int(report.ChainID), attrPromoted.Bool(res.AddedToFilter))
+	status := s.ingestStatus()
+	s.idempotency.finish(owned, status, res)
+	writeIngestResult(w, r, status, res)
+}
+
+// maxBatchSize caps the number of reports accepted by one batch request.
+const maxBatchSize = 1000
+
+// handleIngestBatch is the HTTP handler for POST /ingest/batch.  The body
+// is a JSON array of reports; the response carries one result per report
+// in request order, rejected ones included.  The request only fails as a
+// whole when no report was accepted and the last rejection was an error
+// rather than a missing address.
+func (s *SwarmAggregator) handleIngestBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := s.tracer.Start(ctx, spanHandleIngest, trace.WithSpanKind(trace.SpanKindServer))
+	defer span.End()
+
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
-	var report IOCReport
-	if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	ns, ok := s.requestNamespace(w, r)
+	if !ok {
+		return
+	}
+	limitBody(w, r, s.config.Ingest.MaxBatchBodyBytes)
+	reports, err := decodeReportBatch(r)
+	if err != nil {
+		writeDecodeError(w, r, err)
+		return
+	}
+	if len(reports) > maxBatchSize {
+		writeError(w, r, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Batch too large")
 		return
 	}
 
-	if report.Timestamp.IsZero() {
-		report.Timestamp = time.Now()
+	network, tier := s.requestNetwork(r), s.requestSourceTier(r)
+	results := make([]ingestResult, len(reports))
+	accepted, promoted := 0, 0
+	charged := false // only once an item is not a replay
+	var lastErr error
+	for i, report := range reports {
+		if report.Address == "" {
+			results[i] = ingestResult{Status: IngestRejected, ReasonCode: string(CodeMissingAddress), Message: "Missing address"}
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			lastErr = err
+			break
+		}
+		report.Namespace, report.Network, report.SourceTier = ns, network, tier
+		owned, original, err := s.claimIngest(ctx, idempotencyKey(report, report.ReportID))
+		if err != nil {
+			lastErr = err
+			break
+		}
+		var res ingestResult
+		if original != nil {
+			res = original.result.replayed()
+			if ns == "" {
+				s.sourceStats.recordDuplicate(report.SourceID, s.clock.Now())
+			}
+		} else {
+			if !charged {
+				if !s.allowIngest(w, r) {
+					s.idempotency.abandon(owned)
+					return
+				}
+				charged = true
+			}
+			if res, err = s.acceptReport(ctx, report); err != nil {
+				s.idempotency.abandon(owned)
+				results[i], lastErr = rejectedResult(err), err
+				continue
+			}
+			s.idempotency.finish(owned, s.ingestStatus(), res)
+		}
+		results[i] = res
+		accepted++
+		if res.AddedToFilter {
+			promoted++
+		}
+	}
+	for i := range results {
+		if results[i].Status == "" {
+			results[i] = rejectedResult(lastErr) // left once the batch stopped early
+		}
 	}
+	if accepted == 0 && lastErr != nil {
+		writeAPIError(w, r, lastErr)
+		return
+	}
+	span.SetAttributes(attrPromoted.Bool(promoted > 0))
+	writeIngestBatchResult(w, r, s.ingestStatus(), results, accepted, promoted)
+}
 
-	added := s.IngestReport(report)
+// handleHealth is the HTTP handler for GET /health.  Per-namespace sizes
+// are only included for a global admin key.
+func (s *SwarmAggregator) handleHealth(w http.ResponseWriter, r *http.Request) {
+	st := s.FilterSnapshot()
 	resp := map[string]interface{}{
-		"accepted": true,
-		"added_to_filter": added,
+		"status":         "ok",
+		"filter_size":    st.Count,
+		"filter_version": st.Version,
+	}
+	identity := s.Identity()
+	resp["instance_uuid"], resp["epoch"] = identity.InstanceUUID, identity.Epoch
+	if !st.UpdatedAt.IsZero() {
+		resp["filter_updated_at"] = st.UpdatedAt
+	}
+	if capacity, ok := s.filterCapacity(); ok {
+		resp["filter_cap"] = capacity
+	}
+	if s.config.Bloom.AutoSizeHorizon > 0 {
+		resp["filter_sizing"] = s.filterSizing(s.clock.Now())
+	}
+	if s.staging != nil {
+		resp["staging"] = s.stagingHealth()
+	}
+	if s.sanctions != nil {
+		resp["sanctions"] = s.sanctions.health()
+	}
+	if s.mirror != nil {
+		resp["mirror"] = s.mirror.health(s.clock.Now())
+	}
+	if s.standby != nil {
+		resp["standby"] = s.standby.health()
+	}
+	if stats := s.retention.get(); stats != nil {
+		resp["retention"] = stats
+	}
+	cfg := s.current()
+	resp["chains"] = cfg.registeredChains()
+	resp["allow_unknown_chains"] = cfg.AllowUnknownChains
+	if secret := presentedSecret(r); secret != "" {
+		if key, ok := s.keys.Lookup(secret); ok && key.Role == RoleAdmin && key.Namespace == "" {
+			resp["namespaces"] = s.namespacesHealth()
+		}
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-// handleHealth is the HTTP handler for GET /health.
-func (s *SwarmAggregator) handleHealth(w http.ResponseWriter, r *http.Request) {
+// handleFilter is the HTTP handler for GET /filter[?format=exact][&tier=].
+// A namespaced API key gets its namespace's filter.  Accept:
+// application/octet-stream asks for the binary Bloom encoding (see
+// bloom_format.go), and application/msgpack for the payload in msgpack,
+// signed over those bytes (see encoding.go).
+func (s *SwarmAggregator) handleFilter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	format, err := parseFilterFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, err.Error())
+		return
+	}
+	ns, ok := s.requestNamespace(w, r)
+	if !ok {
+		return
+	}
+	tier, ok := s.requestTier(w, r, ns)
+	if !ok {
+		return
+	}
+	snap := s.tierSnapshot(tier)()
+	if ns != "" {
+		snap = s.namespaceSnapshot(s.namespace(ns))
+	}
+	s.writeFilter(w, r, snap, format)
+}
+
+// writeFilter answers a filter request with the signed payload of snap,
+// its signature in headers.
+func (s *SwarmAggregator) writeFilter(w http.ResponseWriter, r *http.Request, snap filterSnapshot, format FilterFormat) {
+	var env FilterEnvelope
+	var err error
+	encoding := encodingJSON
+	if format == FormatBloom && acceptsBinaryFilter(r) {
+		encoding = encodingBinary
+	} else if negotiateEncoding(r, WireMsgpack) == WireMsgpack {
+		encoding = encodingMsgpack
+	}
+	contentType := "application/json"
+	switch encoding {
+	case encodingBinary:
+		env, err = s.signBinary(snap)
+		contentType = contentTypeBinaryFilter
+	case encodingMsgpack:
+		env, err = s.signEncoded(snap, format, WireMsgpack)
+		contentType = contentTypeMsgpack
+	default:
+		env, err = s.signFormat(snap, format)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, CodeInternal, "Failed to serialize filter")
+		return
+	}
+	identity := s.Identity()
+	w.Header().Set(headerInstanceUUID, identity.InstanceUUID)
+	w.Header().Set(headerFilterEpoch, strconv.FormatUint(identity.Epoch, 10))
+	w.Header().Set(headerFilterVersion, strconv.FormatUint(env.Version, 10))
+	if etag := filterETag(env, format, encoding); etag != "" {
+		w.Header().Set("ETag", etag)
+		w.Header().Add("Vary", "Accept")
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set(headerFilterKeyID, env.KeyID)
+	w.Header().Set(headerFilterSignature, env.Signature)
+	w.Header().Set(headerPayloadChecksum, env.PayloadChecksum)
+	if env.Instance != "" {
+		w.Header().Set(headerFilterInstance, env.Instance)
+		w.Header().Set(headerFilterLogicalVersion, strconv.FormatUint(env.LogicalVersion, 10))
+	}
+	w.Write(env.Payload)
+}
+
+// normalizeQueryAddress returns the indicator key of a looked-up address
+// for the optional type and chain_id query parameters, answering the
+// request itself on failure.
+func normalizeQueryAddress(w http.ResponseWriter, r *http.Request, address string) (string, bool) {
+	typ := IndicatorType(r.URL.Query().Get("type"))
+	if !typ.valid() {
+		writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid type")
+		return "", false
+	}
+	chainID := 0
+	if raw := r.URL.Query().Get("chain_id"); raw != "" {
+		var err error
+		if chainID, err = strconv.Atoi(raw); err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid chain_id")
+			return "", false
+		}
+	}
+	normalized, err := IndicatorKey(typ, chainID, address)
+	if err != nil {
+		code := CodeInvalidAddress
+		if _, ok := err.(*IndicatorError); ok {
+			code = CodeInvalidIndicator
+		}
+		writeError(w, r, http.StatusUnprocessableEntity, code, err.Error())
+		return "", false
+	}
+	return normalized, true
+}
+
+// handleCheck is the HTTP handler for GET /check?address=...[&chain_id=...][&type=...]
+func (s *SwarmAggregator) handleCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		writeError(w, r, http.StatusBadRequest, CodeMissingAddress, "Missing address")
+		return
+	}
+	address, ok := normalizeQueryAddress(w, r, address)
+	if !ok {
+		return
+	}
+
 	resp := map[string]interface{}{
-		"status":       "ok",
-		"filter_size":  s.bloomFilter.Len(),
-		"filter_version": s.bloomFilter.Version(),
+		"address":         address,
+		"indicator_type":  indicatorTypeOf(address),
+		"flagged":         s.bloomFilter.Contains(address),
+		"filter_version":  s.bloomFilter.Version(),
+		"consensus_score": s.ConsensusScore(address),
+	}
+	chainID, _ := strconv.Atoi(r.URL.Query().Get("chain_id")) // checked above
+	if entry, ok := s.Confirmed(address); ok {
+		resp["tier"] = TierMain
+		resp["provenance"] = entry.Provenance
+		if entry.ExpiresAt != nil {
+			resp["expires_at"] = entry.ExpiresAt
+		}
+		chainID = entry.ChainID
+	} else if st, ok := s.stagedRecord(address); ok {
+		resp["tier"] = TierStaging
+		resp["staged_at"] = st.stagedAt
+		resp["graduates_at"] = st.graduatesAt
+		chainID = st.report.ChainID
+	}
+	if chainID != 0 {
+		resp["chain_id"] = chainID
+		resp["chain_name"] = s.current().displayChainName(chainID)
+	}
+	if tags := s.FeedTags(address); len(tags) > 0 {
+		resp["feeds"] = tags
+	}
+	writeEncoded(w, r, http.StatusOK, encoderFor(negotiateEncoding(r, WireMsgpack)), resp)
+}
+
+// handleAddress is the HTTP handler for GET /address/{addr}.
+func (s *SwarmAggregator) handleAddress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	address := strings.TrimPrefix(r.URL.Path, "/address/")
+	if address == "" || strings.Contains(address, "/") {
+		writeError(w, r, http.StatusBadRequest, CodeMissingAddress, "Missing address")
+		return
+	}
+	address, ok := normalizeQueryAddress(w, r, address)
+	if !ok {
+		return
+	}
+
+	resp := map[string]interface{}{
+		"address": address,
+		"flagged": s.bloomFilter.Contains(address),
+	}
+	if entry, ok := s.Confirmed(address); ok {
+		resp["confirmed"] = entry
+	}
+	if tags := s.FeedTags(address); len(tags) > 0 {
+		resp["feeds"] = tags
+	}
+	if c, ok := s.cooldowns.active(address, s.clock.Now()); ok {
+		resp["cooldown"] = c
+	}
+	if d, ok := s.disputes.get(address); ok {
+		if s.isAnalyst(r) {
+			resp["disputes"] = d
+		} else {
+			resp["disputes"] = d.summary()
+		}
+	}
+	if detail, ok := s.twab.Detail(address); ok {
+		if !s.isAnalyst(r) {
+			detail.Evidence = nil
+		}
+		detail.ConsensusScore = s.ConsensusScore(address)
+		resp["twab"] = detail
 	}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
 
-func main() {
-	agg := NewSwarmAggregator()
+// Routes returns the handler serving every aggregator endpoint, with
+// request IDs and panic recovery (see errors.go).
+func (s *SwarmAggregator) Routes() http.Handler {
+	return s.RoutesFor(allRouteGroups...)
+}
 
-	http.HandleFunc("/ingest", agg.handleIngest)
-	http.HandleFunc("/health", agg.handleHealth)
+// RoutesFor returns the handler serving the endpoints of the given route
+// groups (see listener.go), /health, and /limits (see limits.go).
+func (s *SwarmAggregator) RoutesFor(groups ...RouteGroup) http.Handler {
+	routes := []struct {
+		group   RouteGroup
+		path    string
+		handler http.HandlerFunc
+	}{
+		{RouteIngest, "/ingest", s.handleIngest},
+		{RouteIngest, "/ingest/batch", s.withCompression(s.handleIngestBatch)},
+		{RouteIngest, "/pending", s.requireRole(s.handlePending, RoleReporter, RoleAdmin)},
+		{RouteIngest, "/explain", s.requireRole(s.handleExplain, RoleReporter, RoleAdmin)},
+		{RouteIngest, streamPath, s.handleStream},
+		{RouteSubscribe, "/stats", s.withCompression(s.handleStats)},
+		{RouteSubscribe, "/stats/slo", s.handleSLO},
+		{RouteSubscribe, "/check", s.handleCheck},
+		{RouteSubscribe, "/watchlist", s.requireRole(s.handleWatchlist, RoleReporter, RoleSubscriber)},
+		{RouteSubscribe, "/feedback", s.requireRole(s.handleFeedback, RoleSubscriber)},
+		{RouteSubscribe, "/filter", s.withCompression(s.handleFilter)},
+		{RouteSubscribe, "/filter/wait", s.handleFilterWait},
+		{RouteSubscribe, "/filter/params", s.handleFilterParams},
+		{RouteSubscribe, "/filter/version", s.handleFilterVersion},
+		{RouteSubscribe, "/filter/diff", s.handleFilterDiff},
+		{RouteSubscribe, "/ws", s.handleWebSocket},
+		{RouteSubscribe, "/sse/filter", s.handleSSEFilter},
+		{RouteSubscribe, "/events", s.handleEvents},
+		{RouteSubscribe, "/subscriptions/", s.requireRole(s.handleSubscriptionAck, RoleEnterprise)},
+		{RouteSubscribe, "/address/", s.handleAddress},
+		{RouteSubscribe, "/keys", s.handleKeys},
+		{RouteSubscribe, "/export/stix", s.requireRole(s.withCompression(s.handleExportSTIX), RoleSubscriber)},
+		{RouteSubscribe, taxiiRootPath, s.requireRole(s.handleTAXII, RoleSubscriber)},
+		{RouteMetrics, "/metrics", s.handleMetrics},
+		{RouteAdmin, "/admin/import", s.requireRole(s.handleAdminImport, RoleAdmin)},
+		{RouteAdmin, "/admin/block", s.requireRole(s.handleAdminBlock, RoleAdmin)},
+		{RouteAdmin, "/admin/unblock", s.requireRole(s.handleAdminUnblock, RoleAdmin)},
+		{RouteAdmin, "/admin/allowlist", s.requireRole(s.handleAdminAllowlist, RoleAdmin)},
+		{RouteAdmin, "/admin/keys/rotate", s.requireRole(s.handleAdminRotateKey, RoleAdmin)},
+		{RouteAdmin, "/admin/merge", s.requireRole(s.handleAdminMerge, RoleAdmin)},
+		{RouteAdmin, "/admin/rebuild", s.requireRole(s.handleAdminRebuild, RoleAdmin)},
+		{RouteAdmin, "/admin/bans", s.requireRole(s.handleAdminBans, RoleAdmin)},
+		{RouteAdmin, "/admin/sources", s.requireRole(s.handleAdminSources, RoleAdmin)},
+		{RouteAdmin, "/admin/snapshot/export", s.requireRole(s.handleAdminSnapshotExport, RoleAdmin)},
+		{RouteAdmin, "/admin/snapshot/import", s.requireRole(s.handleAdminSnapshotImport, RoleAdmin)},
+		{RouteAdmin, "/admin/reload", s.requireRole(s.handleAdminReload, RoleAdmin)},
+		{RouteAdmin, "/admin/subscribers", s.requireRole(s.handleAdminSubscribers, RoleAdmin)},
+		{RouteAdmin, "/admin/api-keys/revoke", s.requireRole(s.handleAdminRevokeAPIKey, RoleAdmin)},
+		{RouteAdmin, "/admin/source-tiers", s.requireRole(s.handleAdminSourceTiers, RoleAdmin)},
+		{RouteAdmin, "/admin/audit", s.requireRole(s.handleAdminAudit, RoleAdmin)},
+		{RouteAdmin, "/admin/shadow", s.requireRole(s.handleAdminShadow, RoleAdmin)},
+		{RouteAdmin, "/admin/review", s.requireRole(s.handleAdminReview, RoleAdmin)},
+		{RouteAdmin, "/admin/alerts", s.requireRole(s.handleAdminAlerts, RoleAdmin)},
+		{RouteAdmin, "/admin/alerts/", s.requireRole(s.handleAdminAlertAck, RoleAdmin)},
+		{RouteAdmin, "/admin/review/", s.requireRole(s.handleAdminReviewDecision, RoleAdmin)},
+		{RouteAdmin, "/admin/disputes", s.requireRole(s.handleAdminDisputes, RoleAdmin)},
+		{RouteAdmin, "/admin/disputes/", s.requireRole(s.handleAdminDisputeDecision, RoleAdmin)},
+		{RouteAdmin, standbyPromotePath, s.requireRole(s.handleAdminPromoteToActive, RoleAdmin)},
+		{RouteReplication, replicationPath, s.requireRole(s.handleReplicate, RolePeer)},
+		{RouteReplication, standbyPath, s.requireRole(s.handleStandbyStream, RolePeer)},
+	}
 
-	log.Println("Aegis Swarm Aggregator listening on :9090")
-	if err := http.ListenAndServe(":9090", nil); err != nil {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleNotFound)
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/limits", s.handleLimits)
+	for _, route := range routes {
+		if !slices.Contains(groups, route.group) {
+			continue
+		}
+		handler := route.handler
+		if s.mirror != nil && route.group != RouteSubscribe && route.group != RouteMetrics {
+			handler = s.mirrorReadOnly
+		}
+		if s.standby != nil && route.group != RouteSubscribe && route.group != RouteMetrics && route.path != standbyPromotePath {
+			handler = s.standbyRefuses(handler)
+		}
+		if !untimedPaths[route.path] {
+			handler = s.withRequestTimeout(handler)
+		}
+		mux.HandleFunc(route.path, s.withBodyLimit(route.path, handler))
+	}
+	return s.withRequestID(mux)
+}
+
+// handleNotFound answers paths no endpoint is registered for.
+func (s *SwarmAggregator) handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, CodeNotFound, "No such endpoint")
+}
+
+// shutdownTimeout bounds how long Run waits for in-flight work on exit.
+const shutdownTimeout = 10 * time.Second
+
+// Run is the server: it parses args with fs, runs the aggregator until
+// SIGINT or SIGTERM, and shuts it down.  It exits the process on a fatal
+// error.
+func Run(fs *flag.FlagSet, args []string) {
+	importPath := fs.String("import-feed", "", "path to a CSV or JSON threat feed to import at startup")
+	importName := fs.String("import-name", "", "feed name for -import-feed (default: file name)")
+	importMode := fs.String("import-mode", string(FeedUntrusted), "feed import mode: trusted or untrusted")
+	src, err := ParseConfigSource(fs, args, os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg, err := src.Load()
+	if err != nil {
 		log.Fatal(err)
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+	for _, w := range cfg.Warnings() {
+		log.Printf("Config warning: %s", w)
+	}
+
+	tracingCfg, err := TracingConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	shutdownTracing, err := InitTracing(context.Background(), tracingCfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer shutdownTracing(context.Background())
+
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.SetConfigSource(src)
+
+	if spec := os.Getenv("AEGIS_API_KEYS"); spec != "" {
+		keys, err := ParseAPIKeys(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
+		agg.SetAPIKeys(keys)
+	}
+
+	if path := cfg.Persistence.SigningKeyFile; path != "" {
+		signer, err := LoadOrCreateKeyring(path)
+		if err != nil {
+			log.Fatalf("Failed to load signing key %s: %v", path, err)
+		}
+		agg.signer = signer
+	} else {
+		log.Println("No -signing-key set; signing filters with an ephemeral key")
+	}
+	log.Printf("Signing filters with key %s", agg.signer.Active().ID)
+
+	if path := cfg.Persistence.AuditLogFile; path != "" {
+		audit, err := OpenAuditLogger(path, agg.metrics.auditEvents)
+		if err != nil {
+			log.Fatalf("Failed to open audit log %s: %v", path, err)
+		}
+		agg.audit = audit
+	} else {
+		log.Println("No -audit-log set; state changes are not audited")
+	}
+
+	if path := cfg.Persistence.StateFile; path != "" {
+		if n, err := agg.LoadStateFile(context.Background(), path); err != nil {
+			log.Printf("Refusing saved state, starting without it: %v", err)
+		} else if n > 0 {
+			log.Printf("Loaded %d confirmed addresses from %s", n, path)
+		}
+	}
+
+	if *importPath != "" {
+		sum, err := agg.ImportFeedFile(context.Background(), *importPath, *importName, FeedMode(*importMode))
+		if err != nil {
+			log.Fatalf("Failed to import feed %s: %v", *importPath, err)
+		}
+		log.Printf("Imported feed %s (%s): %d added, %d skipped, %d invalid",
+			sum.Feed, sum.Mode, sum.Added, sum.Skipped, sum.Invalid)
+	}
+
+	if res, err := agg.LoadAllowlistFile(context.Background()); err != nil {
+		log.Fatalf("Failed to load allowlist: %v", err)
+	} else if res.Path != "" {
+		log.Printf("Allowlisted %d addresses from %s", res.Entries, res.Path)
+	}
+	if res, err := agg.LoadHighValueFile(context.Background()); err != nil {
+		log.Fatalf("Failed to load high-value addresses: %v", err)
+	} else if res.Path != "" {
+		log.Printf("Protecting %d high-value addresses from %s", res.Entries, res.Path)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go agg.runReloader(ctx, hup)
+
+	if cfg.Expiry.Enabled() {
+		go agg.runExpirySweeper(ctx)
+	}
+
+	if cfg.Sanctions.Enabled() {
+		agg.whenActive(ctx, func() { go agg.runSanctionsSync(ctx) })
+		log.Printf("Syncing %d sanctions feeds every %s", len(cfg.Sanctions.Feeds), time.Duration(cfg.Sanctions.Interval))
+	}
+
+	go agg.runEvidenceVerification(ctx)
+	if cfg.EvidenceVerification.Enabled() {
+		log.Printf("Verifying evidence on %d chains at %d confirmations", len(cfg.EvidenceVerification.Chains), cfg.EvidenceVerification.Confirmations)
+	}
+
+	agg.StartMaintenance()
+	defer agg.Close()
+
+	if path := cfg.Persistence.ReviewQueueFile; path != "" {
+		if err := agg.review.open(path); err != nil {
+			log.Fatalf("Failed to load review queue: %v", err)
+		}
+	}
+
+	if path := cfg.Persistence.QuotaStateFile; path != "" {
+		if err := agg.quotas.load(path); err != nil {
+			log.Fatalf("Failed to load quota state: %v", err)
+		}
+		go agg.runQuotaPersister(ctx, path)
+	}
+
+	if cfg.Alerts.WebhookURL != "" {
+		agg.AddAlertSink(NewWebhookSink(cfg.Alerts.WebhookURL))
+	}
+	if cfg.Alerts.Log {
+		agg.AddAlertSink(LogSink{})
+	}
+	alertsDone := make(chan struct{})
+	go func() {
+		defer close(alertsDone)
+		agg.runAlerts(ctx)
+	}()
+
+	var consumer *BusConsumer
+	if natsCfg := NATSConfigFromEnv(); natsCfg.URL != "" {
+		src, err := NewNATSSource(context.Background(), natsCfg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		consumer = NewBusConsumer(agg, src)
+		agg.whenActive(ctx, func() { consumer.Start(context.Background()) })
+		log.Printf("Consuming reports from NATS subject %s", natsCfg.Subject)
+	}
+
+	if !cfg.Ingest.Synchronous {
+		agg.StartIngestQueue()
+	}
+
+	if cfg.Mirror.Enabled() {
+		if err := agg.StartMirror(ctx); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Mirroring %s read-only", cfg.Mirror.Upstream)
+	}
+
+	if cfg.Standby.Enabled() {
+		agg.StartStandby(ctx)
+		log.Printf("Warm standby of %s; POST %s to take over", cfg.Standby.Active, standbyPromotePath)
+	}
+
+	if cfg.Replication.Enabled() {
+		agg.whenActive(ctx, func() { agg.StartReplication(ctx) })
+		log.Printf("Replicating promotions as %s to %d peers", cfg.Replication.InstanceID, len(cfg.Replication.Peers))
+	}
+
+	serveErr := make(chan error, 1)
+	servers, err := agg.Serve(cfg, serveErr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, l := range cfg.listenerConfigs() {
+		log.Printf("Aegis Swarm Aggregator listening on %s (%v)", l.Address, l.groups())
+	}
+
+	select {
+	case err := <-serveErr:
+		log.Fatal(err)
+	case <-ctx.Done():
+	}
+
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if consumer != nil && !agg.standby.passive() { // a standby never started it
+		if err := consumer.Drain(shutdownCtx); err != nil {
+			log.Printf("Bus consumer drain: %v", err)
+		}
+	}
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP shutdown: %v", err)
+		}
+	}
+	if err := agg.DrainIngestQueue(shutdownCtx); err != nil {
+		log.Printf("Ingest queue drain: %v", err)
+	}
+	if path := cfg.Persistence.QuotaStateFile; path != "" {
+		if err := agg.quotas.save(path); err != nil {
+			log.Printf("Failed to save quota state: %v", err)
+		}
+	}
+	if path := cfg.Persistence.StateFile; path != "" {
+		if err := agg.SaveStateFile(path); err != nil {
+			log.Printf("Failed to save state: %v", err)
+		}
+	}
+	if err := agg.audit.Close(); err != nil {
+		log.Printf("Failed to close audit log: %v", err)
+	}
+	select {
+	case <-alertsDone:
+	case <-shutdownCtx.Done():
+	}
 }
@@ -0,0 +1,170 @@
+// Package swarm — TAXII 2.1 server for the confirmed IOC set.
+//
+// Threat-intel platforms poll TAXII rather than fetching STIX bundles by
+// hand.  This is a minimal read-only TAXII 2.1 server: discovery at
+// /taxii2/, one API root, and a single "aegis-consensus" collection whose
+// objects are the same Indicators /export/stix serves.  An object's
+// date_added is its promotion time, so added_after polling sees exactly
+// the entries that reached the filter since the last poll.
+package swarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	taxiiMediaType = "application/taxii+json;version=2.1"
+
+	taxiiRootPath    = "/taxii2/"
+	taxiiAPIRootPath = taxiiRootPath + "aegis/"
+
+	// taxiiCollectionID is the ID of the only collection.
+	taxiiCollectionID = "aegis-consensus"
+)
+
+// TAXIIDiscovery is the TAXII 2.1 discovery resource.
+type TAXIIDiscovery struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	APIRoots    []string `json:"api_roots"`
+}
+
+// TAXIIAPIRoot is the TAXII 2.1 API root resource.
+type TAXIIAPIRoot struct {
+	Title            string   `json:"title"`
+	Description      string   `json:"description,omitempty"`
+	Versions         []string `json:"versions"`
+	MaxContentLength int      `json:"max_content_length"`
+}
+
+// TAXIICollection is the TAXII 2.1 collection resource.
+type TAXIICollection struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	CanRead     bool     `json:"can_read"`
+	CanWrite    bool     `json:"can_write"`
+	MediaTypes  []string `json:"media_types"`
+}
+
+// TAXIIEnvelope is the TAXII 2.1 envelope returned by the objects
+// endpoint.  An empty result is the empty envelope {}.
+type TAXIIEnvelope struct {
+	More    bool            `json:"more,omitempty"`
+	Next    string          `json:"next,omitempty"`
+	Objects []STIXIndicator `json:"objects,omitempty"`
+}
+
+// taxiiError is the TAXII 2.1 error message resource.
+type taxiiError struct {
+	Title      string `json:"title"`
+	ErrorID    string `json:"error_id,omitempty"` // the request ID
+	HTTPStatus string `json:"http_status"`
+}
+
+func writeTAXII(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", taxiiMediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeTAXIIError(w http.ResponseWriter, r *http.Request, status int, title string) {
+	writeTAXII(w, status, taxiiError{Title: title, ErrorID: RequestIDFromContext(r.Context()), HTTPStatus: strconv.Itoa(status)})
+}
+
+// acceptsTAXII reports whether the Accept header allows a TAXII response.
+func acceptsTAXII(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return accept == "" || strings.Contains(accept, "*/*") || strings.Contains(accept, "application/taxii+json")
+}
+
+func taxiiCollection() TAXIICollection {
+	return TAXIICollection{
+		ID:          taxiiCollectionID,
+		Title:       "Aegis swarm consensus",
+		Description: "Addresses confirmed by swarm consensus or trusted feeds; the set the Bloom filter is built from.",
+		CanRead:     true,
+		MediaTypes:  []string{stixMediaType},
+	}
+}
+
+// handleTAXII is the HTTP handler for everything under /taxii2/.
+func (s *SwarmAggregator) handleTAXII(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeTAXIIError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	if !acceptsTAXII(r) {
+		writeTAXIIError(w, r, http.StatusNotAcceptable, "Accept must include "+taxiiMediaType)
+		return
+	}
+
+	collectionPath := taxiiAPIRootPath + "collections/" + taxiiCollectionID + "/"
+	switch r.URL.Path {
+	case taxiiRootPath:
+		writeTAXII(w, http.StatusOK, TAXIIDiscovery{
+			Title:       "Aegis swarm TAXII server",
+			Description: "Confirmed malicious addresses from the Aegis swarm.",
+			Default:     taxiiAPIRootPath,
+			APIRoots:    []string{taxiiAPIRootPath},
+		})
+	case taxiiAPIRootPath:
+		writeTAXII(w, http.StatusOK, TAXIIAPIRoot{
+			Title:            "Aegis",
+			Versions:         []string{taxiiMediaType},
+			MaxContentLength: 0, // read-only: nothing is accepted
+		})
+	case taxiiAPIRootPath + "collections/":
+		writeTAXII(w, http.StatusOK, map[string][]TAXIICollection{"collections": {taxiiCollection()}})
+	case collectionPath:
+		writeTAXII(w, http.StatusOK, taxiiCollection())
+	case collectionPath + "objects/":
+		s.handleTAXIIObjects(w, r)
+	default:
+		writeTAXIIError(w, r, http.StatusNotFound, "Not found")
+	}
+}
+
+// handleTAXIIObjects serves the collection's objects endpoint.
+//
+// Query parameters: added_after (RFC 3339), limit, and next (the value
+// from the previous envelope).
+func (s *SwarmAggregator) handleTAXIIObjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := STIXExportOptions{After: q.Get("next")}
+	if raw := q.Get("added_after"); raw != "" {
+		since, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			writeTAXIIError(w, r, http.StatusBadRequest, "Invalid added_after timestamp")
+			return
+		}
+		opts.Since = since
+	}
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			writeTAXIIError(w, r, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+		opts.Limit = limit
+	}
+
+	entries, next, err := s.confirmedPage(opts)
+	if err != nil {
+		writeTAXIIError(w, r, http.StatusBadRequest, "Invalid next")
+		return
+	}
+
+	env := TAXIIEnvelope{More: next != "", Next: next}
+	if len(entries) > 0 {
+		env.Objects = s.stixIndicators(entries)
+		w.Header().Set("X-TAXII-Date-Added-First", entries[0].PromotedAt.UTC().Format(stixTimeFormat))
+		w.Header().Set("X-TAXII-Date-Added-Last", entries[len(entries)-1].PromotedAt.UTC().Format(stixTimeFormat))
+	}
+	writeTAXII(w, http.StatusOK, env)
+}
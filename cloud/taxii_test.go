@@ -0,0 +1,121 @@
+package swarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// taxiiGet fetches path and decodes the body into out.
+func taxiiGet(t *testing.T, srv *httptest.Server, path string, out interface{}) *http.Response {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	req.Header.Set("Authorization", "Bearer sub-secret")
+	req.Header.Set("Accept", taxiiMediaType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	json.NewDecoder(resp.Body).Decode(out)
+	return resp
+}
+
+func newTAXIIServer() *httptest.Server {
+	agg := stixFixtureAggregator()
+	agg.keys.Add("sub-secret", APIKey{ID: "tip", Role: RoleSubscriber})
+	return httptest.NewServer(agg.Routes())
+}
+
+func TestTAXIIDiscoveryAndCollections(t *testing.T) {
+	srv := newTAXIIServer()
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path string
+		keys []string
+	}{
+		{"/taxii2/", []string{"title", "default", "api_roots"}},
+		{"/taxii2/aegis/", []string{"title", "versions", "max_content_length"}},
+		{"/taxii2/aegis/collections/", []string{"collections"}},
+		{"/taxii2/aegis/collections/aegis-consensus/", []string{"id", "title", "can_read", "can_write", "media_types"}},
+	} {
+		var body map[string]json.RawMessage
+		resp := taxiiGet(t, srv, tc.path, &body)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != taxiiMediaType {
+			t.Errorf("%s: got %d %q", tc.path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		for _, k := range tc.keys {
+			if _, ok := body[k]; !ok {
+				t.Errorf("%s: missing %q in %v", tc.path, k, body)
+			}
+		}
+	}
+
+	var terr taxiiError
+	if resp := taxiiGet(t, srv, "/taxii2/aegis/collections/other/", &terr); resp.StatusCode != http.StatusNotFound || terr.HTTPStatus != "404" {
+		t.Errorf("Expected a TAXII error for an unknown collection, got %d %+v", resp.StatusCode, terr)
+	}
+	resp, err := http.Get(srv.URL + "/taxii2/")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without an API key, got %d", resp.StatusCode)
+	}
+}
+
+func TestTAXIIObjectsPaginationAndDateHeaders(t *testing.T) {
+	srv := newTAXIIServer()
+	defer srv.Close()
+	objects := "/taxii2/aegis/collections/aegis-consensus/objects/"
+
+	var (
+		seen []STIXIndicator
+		next string
+	)
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("Pagination did not terminate")
+		}
+		q := url.Values{"limit": {"2"}}
+		if next != "" {
+			q.Set("next", next)
+		}
+		var env TAXIIEnvelope
+		resp := taxiiGet(t, srv, objects+"?"+q.Encode(), &env)
+		if env.More != (env.Next != "") {
+			t.Errorf("more and next disagree: %+v", env)
+		}
+		if first := resp.Header.Get("X-TAXII-Date-Added-First"); first != env.Objects[0].Created {
+			t.Errorf("Date-Added-First %q, first object added %q", first, env.Objects[0].Created)
+		}
+		if last := resp.Header.Get("X-TAXII-Date-Added-Last"); last != env.Objects[len(env.Objects)-1].Created {
+			t.Errorf("Date-Added-Last %q, last object added %q", last, env.Objects[len(env.Objects)-1].Created)
+		}
+		seen = append(seen, env.Objects...)
+		if next = env.Next; !env.More {
+			break
+		}
+	}
+	if len(seen) != 3 || seen[0].Pattern != "[x-crypto-address:value = '0xaaa1']" {
+		t.Errorf("Expected all 3 indicators in promotion order, got %+v", seen)
+	}
+
+	var env TAXIIEnvelope
+	taxiiGet(t, srv, objects+"?added_after=2026-03-01T13:00:00.000Z", &env)
+	if len(env.Objects) != 1 || env.Objects[0].Pattern != "[x-crypto-address:value = '0xccc3']" {
+		t.Errorf("Expected only the entry added after 13:00, got %+v", env.Objects)
+	}
+
+	var empty map[string]json.RawMessage
+	resp := taxiiGet(t, srv, objects+"?added_after=2027-01-01T00:00:00Z", &empty)
+	if resp.StatusCode != http.StatusOK || len(empty) != 0 || resp.Header.Get("X-TAXII-Date-Added-First") != "" {
+		t.Errorf("Expected the empty envelope without date headers, got %v", empty)
+	}
+	if resp := taxiiGet(t, srv, objects+"?added_after=yesterday", &taxiiError{}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a bad added_after, got %d", resp.StatusCode)
+	}
+}
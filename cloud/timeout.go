@@ -0,0 +1,55 @@
+// Package swarm — Request timeouts.
+//
+// Every request but the long-lived ones (WebSocket subscriptions and
+// filter long polls, which have their own limits) runs under a context
+// that ends request_timeout after it arrives, as well as when the client
+// disconnects.  Handlers and what they call check that context at their
+// blocking points: waiting on a duplicate report still in flight, and
+// before a report is admitted, so one is either processed in full or not
+// started.  A report already being processed runs to completion; pushes,
+// alerts and replication run on their own deadlines (push, alerts.timeout,
+// replication.timeout) rather than the request's.
+//
+// A request that runs out of time is answered 503 with code timeout; a
+// batch that runs out part-way answers for the reports it processed, the
+// rest not accepted.  Zero disables the timeout.
+package swarm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// untimedPaths are the long-lived endpoints request_timeout does not apply
+// to.
+var untimedPaths = map[string]bool{
+	"/ws":                    true,
+	"/sse/filter":            true,
+	"/events":                true,
+	"/filter/wait":           true,
+	streamPath:               true,
+	standbyPath:              true,
+	"/admin/snapshot/export": true,
+	"/admin/snapshot/import": true,
+}
+
+// withRequestTimeout bounds the request's context by request_timeout.
+func (s *SwarmAggregator) withRequestTimeout(next http.HandlerFunc) http.HandlerFunc {
+	timeout := time.Duration(s.config.RequestTimeout)
+	if timeout <= 0 {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// isContextError reports whether err is a context's cancellation or
+// deadline.
+func isContextError(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
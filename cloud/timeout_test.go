@@ -0,0 +1,89 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTimeoutAggregator(timeout time.Duration) *SwarmAggregator {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	cfg.Ingest.Synchronous = true
+	cfg.RequestTimeout = Duration(timeout)
+	return NewSwarmAggregatorWithConfig(cfg)
+}
+
+func expectTimeout(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	var body ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusServiceUnavailable || body.Error.Code != CodeTimeout {
+		t.Errorf("Expected 503 %s, got %d %+v", CodeTimeout, rec.Code, body)
+	}
+}
+
+func TestExpiredRequestLeavesNoState(t *testing.T) {
+	agg := newTimeoutAggregator(time.Nanosecond)
+	addr := evmAddress("late")
+	report := fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-A"}`, addr)
+
+	expectTimeout(t, postIngest(agg, "/ingest", report))
+	expectTimeout(t, postIngest(agg, "/ingest/batch", "["+report+"]"))
+	if _, ok := agg.twab.Summary(addr); ok {
+		t.Error("Expected no TWAB entry from a timed-out request")
+	}
+}
+
+func TestRequestTimeoutAbortsWaitOnSlowDuplicate(t *testing.T) {
+	agg := newTimeoutAggregator(50 * time.Millisecond)
+	addr := evmAddress("slow")
+	report := IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, SourceID: "agent-A", ReportID: "r-1"}
+
+	// An original still being processed holds the report ID.
+	original, owner := agg.idempotency.claim(idempotencyKey(report, report.ReportID))
+	if !owner {
+		t.Fatal("Expected to own the report ID")
+	}
+	start := time.Now()
+	expectTimeout(t, postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-A","report_id":"r-1"}`, addr)))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected the duplicate to give up at the timeout, waited %v", elapsed)
+	}
+	if _, ok := agg.twab.Summary(addr); ok {
+		t.Error("Expected the timed-out duplicate to record nothing")
+	}
+
+	agg.idempotency.abandon(original)
+	if rec := postIngest(agg, "/ingest", fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":"agent-A","report_id":"r-1"}`, addr)); rec.Code != http.StatusOK {
+		t.Fatalf("Expected a retry to be processed, got %d %s", rec.Code, rec.Body)
+	}
+	if summary, ok := agg.twab.Summary(addr); !ok || summary.ReportCount != 1 {
+		t.Errorf("Expected exactly one recorded report, got %+v", summary)
+	}
+}
+
+func TestRequestTimeoutScope(t *testing.T) {
+	agg := newTimeoutAggregator(time.Minute)
+	var deadline bool
+	handler := agg.withRequestTimeout(func(w http.ResponseWriter, r *http.Request) {
+		_, deadline = r.Context().Deadline()
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !deadline {
+		t.Error("Expected the request context to carry a deadline")
+	}
+
+	agg = newTimeoutAggregator(0)
+	handler = agg.withRequestTimeout(func(w http.ResponseWriter, r *http.Request) {
+		_, deadline = r.Context().Deadline()
+	})
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil).WithContext(context.Background()))
+	if deadline {
+		t.Error("Expected no deadline with the timeout disabled")
+	}
+}
@@ -0,0 +1,112 @@
+// Package swarm — OpenTelemetry tracing for the Swarm Aggregator.
+//
+// Tracing is off by default: the global provider stays the OpenTelemetry
+// no-op implementation, so span creation on the ingest hot path costs a
+// couple of interface calls.  Setting OTEL_EXPORTER_OTLP_ENDPOINT enables
+// an OTLP/HTTP exporter; AEGIS_TRACE_SAMPLE_RATIO controls head sampling.
+package swarm
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkresource "go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies spans emitted by the aggregator.
+const tracerName = "github.com/aegis-protocol/swarm"
+
+// Span and attribute names, kept together so dashboards have one place to look.
+const (
+	spanHandleIngest   = "handleIngest"
+	spanIngestReport   = "SwarmAggregator.IngestReport"
+	spanTWABRecord     = "TWAB.Record"
+	spanTWABThreshold  = "TWAB.MeetsThreshold"
+	spanPush           = "SwarmAggregator.pushToSubscribers"
+	spanBloomSerialize = "BloomFilter.Serialize"
+
+	attrChainID     = attribute.Key("aegis.chain_id")
+	attrPromoted    = attribute.Key("aegis.promoted")
+	attrSubscribers = attribute.Key("aegis.subscribers")
+	attrPayloadSize = attribute.Key("aegis.payload_bytes")
+)
+
+// TracingConfig holds the exporter settings read from the environment.
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP collector endpoint.  Empty disables tracing.
+	Endpoint string
+
+	// SampleRatio is the fraction of root traces sampled, in [0, 1].
+	SampleRatio float64
+}
+
+// TracingConfigFromEnv reads OTEL_EXPORTER_OTLP_ENDPOINT and
+// AEGIS_TRACE_SAMPLE_RATIO (default 1.0).
+func TracingConfigFromEnv() (TracingConfig, error) {
+	cfg := TracingConfig{
+		Endpoint:    os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		SampleRatio: 1.0,
+	}
+
+	if raw := os.Getenv("AEGIS_TRACE_SAMPLE_RATIO"); raw != "" {
+		ratio, err := strconv.ParseFloat(raw, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return cfg, fmt.Errorf("AEGIS_TRACE_SAMPLE_RATIO must be a number in [0, 1], got %q", raw)
+		}
+		cfg.SampleRatio = ratio
+	}
+
+	return cfg, nil
+}
+
+// InitTracing installs the global tracer provider described by cfg and
+// returns a shutdown function that flushes pending spans.  When no endpoint
+// is configured the no-op provider is left in place.
+func InitTracing(ctx context.Context, cfg TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	// The exporter picks up the endpoint (and headers, TLS, etc.) from the
+	// standard OTEL_EXPORTER_OTLP_* variables.
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+
+	res, err := sdkresource.Merge(sdkresource.Default(), sdkresource.NewSchemaless(
+		attribute.String("service.name", "aegis-swarm-aggregator"),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("build trace resource: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+
+	return tp.Shutdown, nil
+}
+
+// defaultTracer returns the aggregator tracer from the global provider.
+// Because the global provider delegates, tracers obtained before
+// InitTracing still pick up the configured exporter.
+func defaultTracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
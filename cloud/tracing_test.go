@@ -0,0 +1,84 @@
+package swarm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingSpanHierarchyOnPromoteAndPush(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	agg := newTestAggregator(TWABConfig{
+		MinReportCount:     1,
+		MinTimeSpanSeconds: 0.0,
+		MinDistinctSources: 1,
+	})
+	agg.tracer = tp.Tracer(tracerName)
+
+	ch := agg.Subscribe("trace-sub")
+	defer agg.Unsubscribe("trace-sub")
+
+	body := fmt.Sprintf(`{"address":%q,"chain_id":137,"confidence":1.0,"source_id":"agent-T"}`, evmAddress("traced"))
+	req := httptest.NewRequest(http.MethodPost, "/ingest", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	agg.handleIngest(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("Subscriber did not receive push")
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = s
+	}
+
+	parentOf := map[string]string{
+		spanIngestReport:   spanHandleIngest,
+		spanTWABRecord:     spanIngestReport,
+		spanTWABThreshold:  spanIngestReport,
+		spanPush:           spanIngestReport,
+		spanBloomSerialize: spanPush,
+	}
+	root, ok := spans[spanHandleIngest]
+	if !ok {
+		t.Fatalf("Missing root span %q", spanHandleIngest)
+	}
+	if root.Parent().IsValid() {
+		t.Errorf("Expected %q to be a root span", spanHandleIngest)
+	}
+	for child, parent := range parentOf {
+		c, ok := spans[child]
+		if !ok {
+			t.Errorf("Missing span %q", child)
+			continue
+		}
+		if c.Parent().SpanID() != spans[parent].SpanContext().SpanID() {
+			t.Errorf("Expected %q to be a child of %q", child, parent)
+		}
+	}
+
+	attrs := map[attribute.Key]attribute.Value{}
+	for _, kv := range root.Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if v, ok := attrs[attrChainID]; !ok || v.AsInt64() != 137 {
+		t.Errorf("Expected chain_id=137 on %q, got %v", spanHandleIngest, v)
+	}
+	if v, ok := attrs[attrPromoted]; !ok || !v.AsBool() {
+		t.Errorf("Expected promoted=true on %q, got %v", spanHandleIngest, v)
+	}
+}
@@ -0,0 +1,374 @@
+// Package swarm — Consensus poisoning tripwire.
+//
+// Address poisoning plants a look-alike of an address users trust, and a
+// coordinated swarm can try to get the real one's neighbours, or the real
+// one itself, into the filter so wallets warn on the wrong address.  An
+// address that meets consensus is therefore compared with the protected
+// addresses first: the allowlist and the tripwire.high_value_file list of
+// treasuries, bridges, and exchanges.  One within tripwire.max_distance
+// edits (Levenshtein over the 40 hex digits of an EVM address), sharing
+// its first tripwire.prefix_length hex digits, or a high-value address
+// itself, is not promoted: it is queued for review with reason
+// near_protected and the match (see review.go), counted in
+// aegis_tripwire_hits_total, and alerted on at high priority (see
+// alert.go).  An address unlike any protected one promotes as before.
+//
+// The comparison runs on every promotion, so the protected addresses are
+// indexed as they change rather than scanned.  With at most k edits
+// between two bodies, splitting the protected one into k+1 segments
+// leaves at least one segment intact in the other, at most k digits from
+// where it was; a lookup lists the addresses having a segment at one of
+// those (k+1)(2k+1) places, plus those in the query's prefix bucket, and
+// verifies the few candidates with a banded edit distance.  The high-value
+// file has the allowlist file's format and, like it, is re-read on reload
+// (see reload.go).  Addresses of other shapes are not compared.
+package swarm
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Protected address lists the tripwire compares with.
+const (
+	protectedAllowlist = "allowlist"
+	protectedHighValue = "high_value"
+)
+
+// reviewReasonNearProtected is the review reason of a tripwire hit.
+const reviewReasonNearProtected = "near_protected"
+
+const (
+	// evmBodyLength is the number of hex digits of an EVM address.
+	evmBodyLength = 40
+	// maxTripwireDistance bounds tripwire.max_distance: each edit
+	// multiplies the lookups, and more than a few match by chance.
+	maxTripwireDistance = 4
+)
+
+// NearMatch is the protected address a promotion resembles.  Distance is
+// the edit distance between their hex bodies and Prefix the number of
+// leading hex digits they share.
+type NearMatch struct {
+	Protected string `json:"protected"`
+	List      string `json:"list"`
+	Distance  int    `json:"distance"`
+	Prefix    int    `json:"prefix"`
+}
+
+// segmentKey is one segment of an indexed hex body.
+type segmentKey struct {
+	index int
+	text  string
+}
+
+// similarityIndex finds protected addresses near a given one.  Lock order:
+// the aggregator's mu before the index's.
+type similarityIndex struct {
+	maxDistance int
+	prefixLen   int
+	bounds      []int // segment i is body[bounds[i]:bounds[i+1]]
+
+	mu       sync.RWMutex
+	members  map[string]map[string]bool // address -> lists it is on
+	segments map[segmentKey]map[string]bool
+	prefixes map[string]map[string]bool
+}
+
+func newSimilarityIndex(cfg TripwireConfig) *similarityIndex {
+	parts := cfg.MaxDistance + 1
+	bounds := make([]int, parts+1)
+	for i := range bounds {
+		bounds[i] = i * evmBodyLength / parts
+	}
+	return &similarityIndex{
+		maxDistance: cfg.MaxDistance,
+		prefixLen:   cfg.PrefixLength,
+		bounds:      bounds,
+		members:     make(map[string]map[string]bool),
+		segments:    make(map[segmentKey]map[string]bool),
+		prefixes:    make(map[string]map[string]bool),
+	}
+}
+
+// evmBody returns the hex digits of a normalized EVM address.
+func evmBody(address string) (string, bool) {
+	if !isEVMShaped(address) {
+		return "", false
+	}
+	return address[2:], true
+}
+
+// add puts an address on a list.
+func (x *similarityIndex) add(list, address string) {
+	body, ok := evmBody(address)
+	if !ok {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.addLocked(list, address, body)
+}
+
+func (x *similarityIndex) addLocked(list, address, body string) {
+	lists := x.members[address]
+	if lists == nil {
+		lists = make(map[string]bool, 1)
+		x.members[address] = lists
+		for i := 0; i+1 < len(x.bounds); i++ {
+			putMember(x.segments, segmentKey{i, body[x.bounds[i]:x.bounds[i+1]]}, address)
+		}
+		if x.prefixLen > 0 {
+			putMember(x.prefixes, body[:x.prefixLen], address)
+		}
+	}
+	lists[list] = true
+}
+
+// remove takes an address off a list.
+func (x *similarityIndex) remove(list, address string) {
+	body, ok := evmBody(address)
+	if !ok {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.removeLocked(list, address, body)
+}
+
+func (x *similarityIndex) removeLocked(list, address, body string) {
+	lists := x.members[address]
+	if !lists[list] {
+		return
+	}
+	delete(lists, list)
+	if len(lists) > 0 {
+		return
+	}
+	delete(x.members, address)
+	for i := 0; i+1 < len(x.bounds); i++ {
+		dropMember(x.segments, segmentKey{i, body[x.bounds[i]:x.bounds[i+1]]}, address)
+	}
+	if x.prefixLen > 0 {
+		dropMember(x.prefixes, body[:x.prefixLen], address)
+	}
+}
+
+// set makes addrs the addresses on a list and returns how many were added
+// to and removed from it.
+func (x *similarityIndex) set(list string, addrs map[string]bool) (added, removed int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	for address, lists := range x.members {
+		if lists[list] && !addrs[address] {
+			x.removeLocked(list, address, address[2:])
+			removed++
+		}
+	}
+	for address := range addrs {
+		body, ok := evmBody(address)
+		if !ok || x.members[address][list] {
+			continue
+		}
+		x.addLocked(list, address, body)
+		added++
+	}
+	return added, removed
+}
+
+// near returns the protected address closest to address, if one is near
+// enough: fewest edits, then longest shared prefix.
+func (x *similarityIndex) near(address string) (NearMatch, bool) {
+	body, ok := evmBody(address)
+	if !ok {
+		return NearMatch{}, false
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	if len(x.members) == 0 {
+		return NearMatch{}, false
+	}
+	candidates := make(map[string]bool)
+	for i := 0; i+1 < len(x.bounds); i++ {
+		width := x.bounds[i+1] - x.bounds[i]
+		for shift := -x.maxDistance; shift <= x.maxDistance; shift++ {
+			start := x.bounds[i] + shift
+			if start < 0 || start+width > len(body) {
+				continue
+			}
+			for candidate := range x.segments[segmentKey{i, body[start : start+width]}] {
+				candidates[candidate] = true
+			}
+		}
+	}
+	if x.prefixLen > 0 {
+		for candidate := range x.prefixes[body[:x.prefixLen]] {
+			candidates[candidate] = true
+		}
+	}
+
+	var best NearMatch
+	found := false
+	for candidate := range candidates {
+		other := candidate[2:]
+		match := NearMatch{Protected: candidate, Distance: editDistance(body, other, x.maxDistance), Prefix: sharedPrefix(body, other)}
+		if match.Distance > x.maxDistance {
+			if x.prefixLen == 0 || match.Prefix < x.prefixLen {
+				continue
+			}
+			match.Distance = editDistance(body, other, evmBodyLength)
+		}
+		if !found || closer(match, best) {
+			best, found = match, true
+		}
+	}
+	if found {
+		best.List = firstList(x.members[best.Protected])
+	}
+	return best, found
+}
+
+// closer reports whether a is a better match than b.
+func closer(a, b NearMatch) bool {
+	if a.Distance != b.Distance {
+		return a.Distance < b.Distance
+	}
+	if a.Prefix != b.Prefix {
+		return a.Prefix > b.Prefix
+	}
+	return a.Protected < b.Protected
+}
+
+// firstList names one of the lists an address is on, the allowlist
+// before the high-value list.
+func firstList(lists map[string]bool) string {
+	names := make([]string, 0, len(lists))
+	for name := range lists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+func putMember[K comparable](index map[K]map[string]bool, key K, address string) {
+	set := index[key]
+	if set == nil {
+		set = make(map[string]bool, 1)
+		index[key] = set
+	}
+	set[address] = true
+}
+
+func dropMember[K comparable](index map[K]map[string]bool, key K, address string) {
+	delete(index[key], address)
+	if len(index[key]) == 0 {
+		delete(index, key)
+	}
+}
+
+// sharedPrefix returns the number of leading bytes a and b share.
+func sharedPrefix(a, b string) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// editDistance returns the Levenshtein distance between a and b, or
+// limit+1 once it exceeds limit.  Only the cells within limit of the
+// diagonal are computed.
+func editDistance(a, b string, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+	over := limit + 1
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = min(j, over)
+	}
+	for i := 1; i <= len(a); i++ {
+		lo, hi := max(1, i-limit), min(len(b), i+limit)
+		cur[0] = min(i, over)
+		if lo > 1 {
+			cur[lo-1] = over
+		}
+		rowMin := cur[0]
+		for j := lo; j <= hi; j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d := prev[j-1] + cost
+			if j < i+limit {
+				d = min(d, prev[j]+1)
+			}
+			d = min(d, cur[j-1]+1, over)
+			cur[j] = d
+			rowMin = min(rowMin, d)
+		}
+		if hi < len(b) {
+			cur[hi+1] = over
+		}
+		if rowMin >= over {
+			return over
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// quarantine queues an address that met consensus near a protected one
+// for review instead of promoting it, and alerts the first time.
+func (s *SwarmAggregator) quarantine(report IOCReport, match NearMatch, now time.Time) {
+	if !s.review.offer(ReviewItem{
+		Address:     report.Address,
+		ChainID:     report.ChainID,
+		Category:    report.Category,
+		Confidence:  report.Confidence,
+		UpdatedAt:   now,
+		Explanation: s.twab.Explain(report.Address, s.current().TWAB),
+		Reason:      reviewReasonNearProtected,
+		NearMatch:   &match,
+	}) {
+		return
+	}
+	log.Printf("Tripwire: %s reached consensus %d edits from %s address %s; queued for review",
+		report.Address, match.Distance, match.List, match.Protected)
+	s.metrics.tripwireHits.WithLabelValues(match.List).Inc()
+	s.alerts.enqueue(PromotionEvent{
+		Kind:        alertTripwire,
+		Priority:    alertPriorityHigh,
+		Address:     report.Address,
+		ChainID:     report.ChainID,
+		Category:    report.Category,
+		NearAddress: match.Protected,
+		NearList:    match.List,
+		Distance:    match.Distance,
+		PromotedAt:  now,
+	})
+}
+
+// LoadHighValueFile reads tripwire.high_value_file, if set, at startup.
+func (s *SwarmAggregator) LoadHighValueFile(context.Context) (AllowlistFileResult, error) {
+	path := s.current().Tripwire.HighValueFile
+	if path == "" {
+		return AllowlistFileResult{}, nil
+	}
+	addrs, err := readAddressFile("high-value", path)
+	if err != nil {
+		return AllowlistFileResult{}, err
+	}
+	return s.setHighValue(path, addrs), nil
+}
+
+// setHighValue makes addrs the high-value addresses.
+func (s *SwarmAggregator) setHighValue(path string, addrs map[string]bool) AllowlistFileResult {
+	res := AllowlistFileResult{Path: path, Entries: len(addrs)}
+	res.Added, res.Removed = s.tripwire.set(protectedHighValue, addrs)
+	return res
+}
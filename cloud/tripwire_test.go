@@ -0,0 +1,158 @@
+package swarm
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// tripwireAlerts flushes the alerts and returns the tripwire ones.
+func tripwireAlerts(agg *SwarmAggregator, sink *recordingSink) []PromotionEvent {
+	agg.alerts.flush(context.Background())
+	var out []PromotionEvent
+	for _, e := range sink.Events() {
+		if e.Kind == alertTripwire {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// flipDigit returns address with the hex digit at i of its body changed.
+func flipDigit(address string, i int) string {
+	b := []byte(address)
+	if b[2+i] == '0' {
+		b[2+i] = '1'
+	} else {
+		b[2+i] = '0'
+	}
+	return string(b)
+}
+
+// queuedItem returns the review item of an address.
+func queuedItem(agg *SwarmAggregator, address string) (ReviewItem, bool) {
+	for _, item := range agg.review.list() {
+		if item.Address == address {
+			return item, true
+		}
+	}
+	return ReviewItem{}, false
+}
+
+func TestTripwireQuarantinesNearAllowlisted(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 2, MinDistinctSources: 2}
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	sink := &recordingSink{}
+	agg.AddAlertSink(sink)
+	ctx := context.Background()
+	treasury := "0x5aaeb6053f3e94c9b9a09f33669435e7ef1beaed"
+	agg.Allow(ctx, treasury)
+
+	lookalike := flipDigit(treasury, 20)
+	promote(agg, lookalike, "drainer")
+	promote(agg, lookalike, "drainer") // still held back, alerted once
+	if _, ok := agg.Confirmed(lookalike); ok || agg.bloomFilter.Contains(lookalike) {
+		t.Fatal("Expected the look-alike held out of the filter")
+	}
+	items := agg.review.list()
+	if len(items) != 1 || items[0].Address != lookalike || items[0].Reason != reviewReasonNearProtected {
+		t.Fatalf("Expected the look-alike queued as near_protected, got %+v", items)
+	}
+	want := NearMatch{Protected: treasury, List: protectedAllowlist, Distance: 1, Prefix: 20}
+	if m := items[0].NearMatch; m == nil || *m != want {
+		t.Errorf("Expected match %+v, got %+v", want, items[0].NearMatch)
+	}
+	alerts := tripwireAlerts(agg, sink)
+	if len(alerts) != 1 || alerts[0].Address != lookalike || alerts[0].NearAddress != treasury || alerts[0].Priority != alertPriorityHigh || alerts[0].Distance != 1 {
+		t.Errorf("Expected one high-priority tripwire alert, got %+v", alerts)
+	}
+	if got := testutil.ToFloat64(agg.metrics.tripwireHits.WithLabelValues(protectedAllowlist)); got != 1 {
+		t.Errorf("Expected one allowlist hit counted, got %v", got)
+	}
+
+	// An address unlike any protected one promotes untouched.
+	other := evmAddress("unrelated")
+	promote(agg, other, "drainer")
+	if _, ok := agg.Confirmed(other); !ok || agg.review.queued(other) {
+		t.Error("Expected a dissimilar address promoted")
+	}
+
+	// Off the allowlist, the treasury protects nothing.
+	agg.Disallow(treasury)
+	again := flipDigit(treasury, 0)
+	promote(agg, again, "drainer")
+	if _, ok := agg.Confirmed(again); !ok {
+		t.Error("Expected promotions near a disallowed address to go through")
+	}
+}
+
+func TestTripwireHighValueFileReloads(t *testing.T) {
+	dir := t.TempDir()
+	listPath := filepath.Join(dir, "high-value.txt")
+	bridge, exchange := evmAddress("bridge"), evmAddress("exchange")
+	writeConfigFile(t, listPath, "# bridges\n"+bridge+"\n")
+	agg, _ := newReloadableAggregator(t, "twab:\n  min_report_count: 1\n  min_time_span_seconds: 0\n  min_distinct_sources: 1\ntripwire:\n  max_distance: 2\n  prefix_length: 12\n  high_value_file: "+listPath+"\n")
+	if res, err := agg.LoadHighValueFile(context.Background()); err != nil || res.Entries != 1 {
+		t.Fatalf("LoadHighValueFile failed: %+v %v", res, err)
+	}
+
+	// One digit dropped and another appended is two edits away.
+	shifted := "0x" + bridge[3:] + "0"
+	promote(agg, shifted, "")
+	if _, ok := agg.Confirmed(shifted); ok || !agg.review.queued(shifted) {
+		t.Error("Expected a shifted copy of the bridge queued for review")
+	}
+	// A high-value address is never promoted by consensus itself.
+	promote(agg, bridge, "")
+	if _, ok := agg.Confirmed(bridge); ok || !agg.review.queued(bridge) {
+		t.Error("Expected the bridge itself queued for review")
+	}
+	// Sharing the first twelve digits is a match however far the rest.
+	vanity := bridge[:14] + evmAddress("vanity")[14:]
+	promote(agg, vanity, "")
+	if item, ok := queuedItem(agg, vanity); !ok || item.NearMatch == nil || item.NearMatch.Prefix < 12 || item.NearMatch.List != protectedHighValue {
+		t.Errorf("Expected the vanity prefix queued as near the bridge, got %+v", item)
+	}
+
+	writeConfigFile(t, listPath, exchange+"\n")
+	res, err := agg.Reload(context.Background(), auditActorSystem)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if h := res.HighValue; h == nil || h.Added != 1 || h.Removed != 1 || h.Path != listPath {
+		t.Errorf("Expected the high-value list swapped, got %+v", res.HighValue)
+	}
+	near := flipDigit(exchange, 39)
+	promote(agg, near, "")
+	if !agg.review.queued(near) {
+		t.Error("Expected a reloaded high-value address protected")
+	}
+	freed := flipDigit(bridge, 30)
+	promote(agg, freed, "")
+	if _, ok := agg.Confirmed(freed); !ok {
+		t.Error("Expected an address dropped from the file no longer protected")
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b  string
+		limit int
+		want  int
+	}{
+		{"abcdef", "abcdef", 2, 0},
+		{"abcdef", "abcxef", 2, 1},
+		{"abcdef", "bcdefa", 2, 2},
+		{"abcdef", "badcfe", 2, 3}, // three swaps exceed the limit
+		{"abcdef", "fedcba", 6, 6},
+		{"abc", "abcde", 1, 2},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b, tt.limit); got != tt.want {
+			t.Errorf("editDistance(%q, %q, %d) = %d, want %d", tt.a, tt.b, tt.limit, got, tt.want)
+		}
+	}
+}
@@ -1,12 +1,24 @@
-// Package main — Time-Weighted Average Balance (TWAB) for Sybil resistance.
+// Package swarm — Time-Weighted Average Balance (TWAB) for Sybil resistance.
 //
 // An address must receive IOC reports from multiple independent sources
 // over time before being included in the consensus Bloom filter.  This
 // prevents a single malicious actor from poisoning the threat feed.
-package main
+//
+// Memory per address is bounded by its distinct sources and networks, not
+// its reports: an entry keeps only the most recent twab.retain_reports
+// reports, in a ring, and maintains every aggregate the gates and
+// summaries need incrementally as reports arrive.
+package swarm
 
 import (
+	"context"
+	"hash/fnv"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,91 +26,686 @@ import (
 type TWABConfig struct {
 	// MinReportCount is the minimum number of independent reports
 	// required before an address enters the Bloom filter.
-	MinReportCount int
+	MinReportCount int `json:"min_report_count" yaml:"min_report_count"`
 
 	// MinTimeSpanSeconds is the minimum time span (in seconds) between
-	// the first and last report.  This prevents burst-reporting.
-	MinTimeSpanSeconds float64
+	// the first and last report.  This prevents burst-reporting.  The
+	// span is measured on receive times and must be covered by
+	// MinDistinctSources sources (see TWABEntry.timeSpan).
+	MinTimeSpanSeconds float64 `json:"min_time_span_seconds" yaml:"min_time_span_seconds"`
 
 	// MinDistinctSources is the minimum number of distinct agent sources
 	// that must report the same address.
-	MinDistinctSources int
+	MinDistinctSources int `json:"min_distinct_sources" yaml:"min_distinct_sources"`
+
+	// MinDistinctNetworks is the minimum number of distinct networks the
+	// reports must come from (see network.go).  Zero disables it.
+	MinDistinctNetworks int `json:"min_distinct_networks" yaml:"min_distinct_networks"`
+
+	// MinAverageConfidence is the mean report confidence required for
+	// promotion, however well the other gates are met.  Zero disables it.
+	MinAverageConfidence float64 `json:"min_average_confidence" yaml:"min_average_confidence"`
+
+	// RetainReports is the number of recent reports kept per address for
+	// the detail view.  Zero uses defaultRetainReports.
+	RetainReports int `json:"retain_reports" yaml:"retain_reports"`
+
+	// MinWeightedScore is the weighted score promotion needs: the
+	// confidences of each source's reports summed, weighed by TierWeights,
+	// each source counting for at most MaxSourceContribution of it.  Zero
+	// disables it.
+	MinWeightedScore float64 `json:"min_weighted_score" yaml:"min_weighted_score"`
+
+	// MaxSourceContribution caps one source's contribution to the weighted
+	// score at this fraction of MinWeightedScore, in (0, 1], so however
+	// many reports a source sends it cannot promote alone.  Zero means
+	// defaultMaxSourceContribution.
+	MaxSourceContribution float64 `json:"max_source_contribution" yaml:"max_source_contribution"`
+
+	// TierWeights scales each source's contribution to the weighted
+	// score by the tier of its latest report (see sourcetier.go) before it
+	// is capped, e.g. to count anonymous agents for half.  A tier left out
+	// counts for 1.
+	TierWeights map[SourceTier]float64 `json:"tier_weights,omitempty" yaml:"tier_weights,omitempty"`
+
+	// TrustedConfidence lets one report from a trusted source, with at
+	// least this confidence, promote on its own whatever the other gates.
+	// Zero disables it.
+	TrustedConfidence float64 `json:"trusted_confidence" yaml:"trusted_confidence"`
+
+	// RequireEvidenceForPromotion makes promotion need at least one
+	// report citing evidence (see evidence.go).
+	RequireEvidenceForPromotion bool `json:"require_evidence_for_promotion" yaml:"require_evidence_for_promotion"`
+
+	// RequireVerifiedEvidence makes promotion need at least one cited
+	// transaction verified on chain (see evidence_verify.go).  Without an
+	// evidence verifier nothing is verified, so nothing promotes.
+	RequireVerifiedEvidence bool `json:"require_verified_evidence" yaml:"require_verified_evidence"`
+
+	// ScoreWeights weighs the components of the consensus score (see
+	// score.go).  All zero uses DefaultScoreWeights.
+	ScoreWeights ScoreWeights `json:"score_weights" yaml:"score_weights"`
+
+	// PromotionScore is the consensus score promotion needs, in (0, 1].
+	// Zero means 1, which with the default weights requires every
+	// threshold above to be met.
+	PromotionScore float64 `json:"promotion_score" yaml:"promotion_score"`
+
+	// Types replaces these thresholds for indicators of a type (see
+	// indicator.go), e.g. to ask more of domains than of addresses.  An
+	// override is complete: fields it leaves out are zero, not inherited.
+	Types map[IndicatorType]TWABConfig `json:"types,omitempty" yaml:"types,omitempty"`
+
+	// CategoryOverrides replaces these thresholds for addresses whose
+	// reports mostly give a category, e.g. to promote sanctions on one
+	// source but ask five over six hours of suspicious approvals.  As with
+	// Types an override is complete; addresses of any other category fall
+	// through to these thresholds.  A Types override has its own.
+	CategoryOverrides map[string]TWABConfig `json:"category_overrides,omitempty" yaml:"category_overrides,omitempty"`
+
+	// SeverityBands replaces these thresholds, after any Types or
+	// CategoryOverrides, by those of the band for the highest severity
+	// reported for the address (see severityband.go), e.g. to promote
+	// active drainers on two sources in ten minutes.  A band's Config is
+	// complete, as with the other overrides.
+	SeverityBands []SeverityBand `json:"severity_bands,omitempty" yaml:"severity_bands,omitempty"`
 }
 
+// forEntry returns the thresholds for an indicator key's entry, nil if it
+// is untracked, and names the override applied, if any: its type's entry
+// in Types, then its majority category's in the CategoryOverrides of
+// those thresholds, then its severity band in their SeverityBands.  The
+// category and severity are recomputed on every call, so they follow the
+// reports.  The caller holds the shard lock.
+func (c TWABConfig) forEntry(key string, entry *TWABEntry) (TWABConfig, string) {
+	c, override, _ := c.resolve(key, entry)
+	return c, override
+}
+
+// resolve is forEntry, also returning the MinSeverity of the severity band
+// applied, zero for none.  The caller holds the shard lock.
+func (c TWABConfig) resolve(key string, entry *TWABEntry) (TWABConfig, string, int) {
+	var applied []string
+	typ := indicatorTypeOf(key)
+	if override, ok := c.Types[typ]; ok {
+		c, applied = override, append(applied, "types."+string(typ))
+	}
+	if entry != nil && len(c.CategoryOverrides) > 0 {
+		if category := entry.categoryGuess(); category != "" {
+			if override, ok := c.CategoryOverrides[category]; ok {
+				c, applied = override, append(applied, "category_overrides."+category)
+			}
+		}
+	}
+	band := 0
+	if entry != nil {
+		if i, ok := c.bandFor(entry.MaxSeverity); ok {
+			band = c.SeverityBands[i].MinSeverity
+			c, applied = c.SeverityBands[i].Config, append(applied, "severity_bands."+strconv.Itoa(i))
+		}
+	}
+	return c, strings.Join(applied, "."), band
+}
+
+// defaultRetainReports is the per-address report ring size.
+const defaultRetainReports = 256
+
+// defaultMaxSourceContribution is the share of the weighted score one
+// source may contribute, so it takes at least two.
+const defaultMaxSourceContribution = 0.5
+
 // DefaultTWABConfig returns sensible defaults for production.
 func DefaultTWABConfig() TWABConfig {
 	return TWABConfig{
-		MinReportCount:     3,
-		MinTimeSpanSeconds: 3600.0, // 1 hour
-		MinDistinctSources: 2,
+		MinReportCount:        3,
+		MinTimeSpanSeconds:    3600.0, // 1 hour
+		MinDistinctSources:    2,
+		RetainReports:         defaultRetainReports,
+		ScoreWeights:          DefaultScoreWeights(),
+		PromotionScore:        1,
+		MaxSourceContribution: defaultMaxSourceContribution,
 	}
 }
 
-// TWABEntry tracks reports for a single address.
+// TWABSourceStats aggregates one source's reports for an address.
+// Weight is the sum of their calibrated confidences (see calibration.go),
+// and Tier that of the latest.  FirstReceived is when the server received
+// the first of them.
+type TWABSourceStats struct {
+	Reports        int
+	BestConfidence float64
+	Weight         float64
+	Tier           SourceTier
+	FirstSeen      time.Time
+	LastSeen       time.Time
+	FirstReceived  time.Time
+}
+
+// TWABEntry tracks reports for a single address.  FirstSeen and LastSeen
+// are the claimed report times; FirstReceived and LastReceived are when
+// the server received them.
 type TWABEntry struct {
-	Reports   []IOCReport
-	Sources   map[string]bool // distinct source IDs
-	FirstSeen time.Time
-	LastSeen  time.Time
+	ReportCount   int
+	ConfidenceSum float64
+	ChainID       int                         // of the latest report
+	Sources       map[string]*TWABSourceStats // source ID -> its reports
+	Networks      map[string]int              // network hash -> reports from it
+	FirstSeen     time.Time
+	LastSeen      time.Time
+	FirstReceived time.Time
+	LastReceived  time.Time
+
+	// EvidencedReports counts the reports that cited evidence.
+	EvidencedReports int
+
+	// MaxSeverity is the highest severity reported, zero if none was.
+	MaxSeverity int
+
+	// CompactedReports counts the retained reports retention compacted
+	// into the per-source aggregates (see retention.go).
+	CompactedReports int
+
+	// Tiers counts the reports of each source tier, and BestTrusted is
+	// the highest confidence of a trusted one.
+	Tiers       map[SourceTier]int
+	BestTrusted float64
+
+	sourceOrder []string    // sources by first report, so sums are deterministic
+	firsts      []time.Time // first receipt of each source, earliest first
+	recent      []IOCReport // ring of the latest reports, without evidence
+	next        int         // ring slot the next report goes in, once full
+	evidence    []Evidence  // unique items cited, the first maxEntryEvidence
 }
 
-// TWAB implements Time-Weighted Average Balance Sybil resistance.
-type TWAB struct {
+// add folds a report, received at received, into the entry, keeping at
+// most retain reports.
+func (e *TWABEntry) add(report IOCReport, received time.Time, retain int) {
+	e.ReportCount++
+	e.ConfidenceSum += report.Confidence
+	e.ChainID = report.ChainID
+	e.MaxSeverity = max(e.MaxSeverity, report.Severity)
+
+	network := report.Network
+	if network == "" {
+		network = networkUnknown
+	}
+	e.Networks[network]++
+
+	tier := report.SourceTier.orAnonymous()
+	if e.Tiers == nil {
+		e.Tiers = make(map[SourceTier]int)
+	}
+	e.Tiers[tier]++
+	if tier == SourceTrusted && report.Confidence > e.BestTrusted {
+		e.BestTrusted = report.Confidence
+	}
+
+	src, ok := e.Sources[report.SourceID]
+	if !ok {
+		src = &TWABSourceStats{FirstSeen: report.Timestamp, FirstReceived: received}
+		e.Sources[report.SourceID] = src
+		e.sourceOrder = append(e.sourceOrder, report.SourceID)
+		e.addFirstReceipt(received)
+	}
+	if src.Reports == 0 || report.Confidence > src.BestConfidence {
+		src.BestConfidence = report.Confidence
+	}
+	src.Reports++
+	src.Weight += report.weight()
+	src.Tier = tier
+	src.LastSeen = report.Timestamp
+
+	e.addEvidence(report.Evidence)
+	report.Evidence = nil
+	report.ReceivedAt = received
+	if len(e.recent) < retain {
+		e.recent = append(e.recent, report)
+		return
+	}
+	e.recent[e.next] = report
+	e.next = (e.next + 1) % len(e.recent)
+}
+
+// Recent returns the retained reports, oldest first.
+func (e *TWABEntry) Recent() []IOCReport {
+	out := make([]IOCReport, 0, len(e.recent))
+	out = append(out, e.recent[e.next:]...)
+	return append(out, e.recent[:e.next]...)
+}
+
+// meanConfidence is the average confidence of every report, kept from
+// the running sum.
+func (e *TWABEntry) meanConfidence() float64 {
+	if e.ReportCount == 0 {
+		return 0
+	}
+	return e.ConfidenceSum / float64(e.ReportCount)
+}
+
+// claimedSpan is the time between the first and last claimed report
+// times.
+func (e *TWABEntry) claimedSpan() time.Duration {
+	return e.LastSeen.Sub(e.FirstSeen)
+}
+
+// receivedSpan is the time between the first and last receipts.
+func (e *TWABEntry) receivedSpan() time.Duration {
+	return e.LastReceived.Sub(e.FirstReceived)
+}
+
+// sourceSpan is the span the reports of sources distinct sources cover:
+// from the first receipt of the sources-th source to report, in order of
+// first receipt, to the last receipt, so that the earliest report of each
+// of those sources opens the window.  With fewer sources it is the span
+// all of them cover.  One long-lived source joined by others at the last
+// moment covers nothing.
+func (e *TWABEntry) sourceSpan(sources int) time.Duration {
+	sources = min(max(sources, 1), len(e.firsts))
+	if sources == 0 {
+		return 0
+	}
+	return max(e.LastReceived.Sub(e.firsts[sources-1]), 0)
+}
+
+// addFirstReceipt files a new source's first receipt in firsts.
+func (e *TWABEntry) addFirstReceipt(at time.Time) {
+	i := sort.Search(len(e.firsts), func(i int) bool { return e.firsts[i].After(at) })
+	e.firsts = append(e.firsts, time.Time{})
+	copy(e.firsts[i+1:], e.firsts[i:])
+	e.firsts[i] = at
+}
+
+// timeSpan is the span the time-span gate of c is held to: the smaller of
+// the claimed span and the span covered by MinDistinctSources sources on
+// receive times, since claimed times are attacker-controlled and
+// colluding sources can each report once, back to back.  The caller holds
+// the shard lock.
+func (c TWABConfig) timeSpan(e *TWABEntry) time.Duration {
+	return min(e.claimedSpan(), e.sourceSpan(c.MinDistinctSources))
+}
+
+// twabShardCount is the number of independently locked entry maps.  It
+// must be a power of two so the shard index is a cheap mask.
+const twabShardCount = 64
+
+// twabShard is one slice of the address space with its own lock.
+type twabShard struct {
 	mu      sync.RWMutex
-	config  TWABConfig
 	entries map[string]*TWABEntry // address -> entry
 }
 
+// TWAB implements Time-Weighted Average Balance Sybil resistance.
+//
+// Entries are spread over twabShardCount shards keyed by the FNV-1a hash
+// of the address, so reports for unrelated addresses never contend on the
+// same lock.
+type TWAB struct {
+	config TWABConfig
+	shards [twabShardCount]twabShard
+
+	// recorded numbers the reports recorded, each under its shard's
+	// lock, so a copy taken with every shard locked holds exactly those
+	// numbered up to its value (see standby.go).
+	recorded atomic.Uint64
+}
+
 // NewTWAB creates a TWAB with the given configuration.
 func NewTWAB(config TWABConfig) *TWAB {
-	return &TWAB{
-		config:  config,
-		entries: make(map[string]*TWABEntry),
+	if config.RetainReports <= 0 {
+		config.RetainReports = defaultRetainReports
+	}
+	t := &TWAB{config: config}
+	for i := range t.shards {
+		t.shards[i].entries = make(map[string]*TWABEntry)
 	}
+	return t
 }
 
-// Record adds a report for an address.
-func (t *TWAB) Record(address string, report IOCReport) {
-	t.mu.Lock()
-	defer t.mu.Unlock()
+// shardFor returns the shard owning an address.
+func (t *TWAB) shardFor(address string) *twabShard {
+	h := fnv.New32a()
+	h.Write([]byte(address))
+	return &t.shards[h.Sum32()&(twabShardCount-1)]
+}
 
-	entry, ok := t.entries[address]
+// Record adds a report for an address and returns its number.  A report
+// without a receive time is taken to have been received at its claimed
+// time.
+func (t *TWAB) Record(address string, report IOCReport) uint64 {
+	received := report.ReceivedAt
+	if received.IsZero() {
+		received = report.Timestamp
+	}
+
+	shard := t.shardFor(address)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	entry, ok := shard.entries[address]
 	if !ok {
 		entry = &TWABEntry{
-			Sources:   make(map[string]bool),
-			FirstSeen: report.Timestamp,
+			Sources:       make(map[string]*TWABSourceStats),
+			Networks:      make(map[string]int),
+			FirstSeen:     report.Timestamp,
+			FirstReceived: received,
 		}
-		t.entries[address] = entry
+		shard.entries[address] = entry
 	}
 
-	entry.Reports = append(entry.Reports, report)
-	entry.Sources[report.SourceID] = true
+	entry.add(report, received, t.config.RetainReports)
 	entry.LastSeen = report.Timestamp
+	entry.LastReceived = received
+	return t.recorded.Add(1)
+}
+
+// Forget drops all reports for an address, so consensus on it starts over.
+func (t *TWAB) Forget(address string) {
+	shard := t.shardFor(address)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	delete(shard.entries, address)
+}
+
+// SourceConfidences returns the best confidence each source has reported
+// for an address.
+func (t *TWAB) SourceConfidences(address string) map[string]float64 {
+	shard := t.shardFor(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+	if entry, ok := shard.entries[address]; ok {
+		return entry.sourceConfidences()
+	}
+	return nil
+}
+
+func (e *TWABEntry) sourceConfidences() map[string]float64 {
+	out := make(map[string]float64, len(e.Sources))
+	for id, src := range e.Sources {
+		out[id] = src.BestConfidence
+	}
+	return out
 }
 
 // MeetsThreshold checks whether an address has sufficient independent
-// reports over enough time to be included in the Bloom filter.
-func (t *TWAB) MeetsThreshold(address string) bool {
-	t.mu.RLock()
-	defer t.mu.RUnlock()
+// reports over enough time to be included in the Bloom filter under the
+// given thresholds: normally those the TWAB was created with, or a shadow
+// candidate's (see shadow.go).
+func (t *TWAB) MeetsThreshold(address string, config TWABConfig) bool {
+	shard := t.shardFor(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
 
-	entry, ok := t.entries[address]
+	entry, ok := shard.entries[address]
 	if !ok {
 		return false
 	}
+	config, _ = config.forEntry(address, entry)
+	return config.met(entry)
+}
+
+// met reports whether an entry has a trusted report confident enough to
+// promote alone, or reaches the promotion score and passes every
+// remaining gate.  The caller holds the shard lock.
+func (c TWABConfig) met(entry *TWABEntry) bool {
+	if c.trustedMet(entry) {
+		return true
+	}
+
+	if c.score(entry) < c.promotionScore() {
+		return false
+	}
+
+	if c.MinAverageConfidence > 0 && entry.meanConfidence() < c.MinAverageConfidence {
+		return false
+	}
 
-	if len(entry.Reports) < t.config.MinReportCount {
+	if c.MinDistinctNetworks > 0 && len(entry.Networks) < c.MinDistinctNetworks {
 		return false
 	}
 
-	timeSpan := entry.LastSeen.Sub(entry.FirstSeen).Seconds()
-	if timeSpan < t.config.MinTimeSpanSeconds {
+	if c.MinWeightedScore > 0 && c.weightedScore(entry) < c.MinWeightedScore {
 		return false
 	}
 
-	if len(entry.Sources) < t.config.MinDistinctSources {
+	if c.RequireEvidenceForPromotion && entry.EvidencedReports == 0 {
+		return false
+	}
+
+	if c.RequireVerifiedEvidence && entry.verifiedEvidence() == 0 {
 		return false
 	}
 
 	return true
 }
+
+// trustedMet reports whether a trusted report reached TrustedConfidence.
+// The caller holds the shard lock.
+func (c TWABConfig) trustedMet(entry *TWABEntry) bool {
+	return c.TrustedConfidence > 0 && entry.BestTrusted >= c.TrustedConfidence
+}
+
+// tierWeight returns the weight of a source tier; 1 if unset.
+func (c TWABConfig) tierWeight(tier SourceTier) float64 {
+	if w, ok := c.TierWeights[tier.orAnonymous()]; ok {
+		return w
+	}
+	return 1
+}
+
+// contribution is a source's contribution to the weighted score, weighed
+// by its tier and capped.
+func (c TWABConfig) contribution(src *TWABSourceStats) float64 {
+	return math.Min(src.Weight*c.tierWeight(src.Tier), c.sourceCap())
+}
+
+// sourceCap is the most one source contributes to the weighted score.
+func (c TWABConfig) sourceCap() float64 {
+	share := c.MaxSourceContribution
+	if share <= 0 {
+		share = defaultMaxSourceContribution
+	}
+	return share * c.MinWeightedScore
+}
+
+// weightedScore sums the capped contributions of an entry's sources.  The
+// caller holds the shard lock.
+func (c TWABConfig) weightedScore(entry *TWABEntry) float64 {
+	var sum float64
+	for _, source := range entry.sourceOrder {
+		sum += c.contribution(entry.Sources[source])
+	}
+	return sum
+}
+
+// TWABSummary is a read-only view of an entry's aggregate state.  It
+// deliberately omits SourceIDs and networks to preserve reporter
+// anonymity.
+//
+// WeightedScore sums each distinct source's highest confidence, so one
+// source repeating itself cannot inflate it.
+type TWABSummary struct {
+	ChainID          int       `json:"chain_id"`
+	ReportCount      int       `json:"report_count"`
+	DistinctSources  int       `json:"distinct_sources"`
+	DistinctNetworks int       `json:"distinct_networks"`
+	MeanConfidence   float64   `json:"mean_confidence"`
+	WeightedScore    float64   `json:"weighted_score"`
+	FirstSeen        time.Time `json:"first_seen"`
+	LastSeen         time.Time `json:"last_seen"`
+}
+
+// TimeSpan is the time between the first and last report.
+func (s TWABSummary) TimeSpan() time.Duration {
+	return s.LastSeen.Sub(s.FirstSeen)
+}
+
+// summarize computes the summary of an entry from its aggregates.  The
+// caller holds the shard lock.
+func summarize(entry *TWABEntry) TWABSummary {
+	var score float64
+	for _, source := range entry.sourceOrder {
+		score += entry.Sources[source].BestConfidence
+	}
+	return TWABSummary{
+		ChainID:          entry.ChainID,
+		ReportCount:      entry.ReportCount,
+		DistinctSources:  len(entry.Sources),
+		DistinctNetworks: len(entry.Networks),
+		MeanConfidence:   entry.meanConfidence(),
+		WeightedScore:    score,
+		FirstSeen:        entry.FirstSeen,
+		LastSeen:         entry.LastSeen,
+	}
+}
+
+// Summary returns the aggregate state for an address, if it has reports.
+func (t *TWAB) Summary(address string) (TWABSummary, bool) {
+	shard := t.shardFor(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, ok := shard.entries[address]
+	if !ok {
+		return TWABSummary{}, false
+	}
+	return summarize(entry), true
+}
+
+// FirstReceived returns when the server received an address's first
+// report, if it has reports.
+func (t *TWAB) FirstReceived(address string) (time.Time, bool) {
+	shard := t.shardFor(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, ok := shard.entries[address]
+	if !ok {
+		return time.Time{}, false
+	}
+	return entry.FirstReceived, true
+}
+
+// RetainedReport is a recent report as shown in a TWABDetail, without its
+// SourceID.  Timestamp is the claimed time and ReceivedAt when the server
+// received it.
+type RetainedReport struct {
+	ChainID    int       `json:"chain_id"`
+	Category   string    `json:"category,omitempty"`
+	Confidence float64   `json:"confidence"`
+	Timestamp  time.Time `json:"timestamp"`
+	ReceivedAt time.Time `json:"received_at"`
+	Sanitized  []string  `json:"sanitized,omitempty"`
+}
+
+// TWABDetail is an entry's summary with its retained recent reports,
+// oldest first, and the evidence cited for it.
+type TWABDetail struct {
+	TWABSummary
+	RecentReports    []RetainedReport `json:"recent_reports"`
+	EvidencedReports int              `json:"evidenced_reports"`
+	CompactedReports int              `json:"compacted_reports,omitempty"`
+	Evidence         []Evidence       `json:"evidence,omitempty"`
+	ConsensusScore   float64          `json:"consensus_score"` // set by the caller, which knows the thresholds
+}
+
+// Detail returns the summary and recent reports for an address, if it has
+// reports.
+func (t *TWAB) Detail(address string) (TWABDetail, bool) {
+	shard := t.shardFor(address)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	entry, ok := shard.entries[address]
+	if !ok {
+		return TWABDetail{}, false
+	}
+	d := TWABDetail{
+		TWABSummary:      summarize(entry),
+		RecentReports:    make([]RetainedReport, 0, len(entry.recent)),
+		EvidencedReports: entry.EvidencedReports,
+		CompactedReports: entry.CompactedReports,
+		Evidence:         append([]Evidence(nil), entry.evidence...),
+	}
+	for _, r := range entry.Recent() {
+		d.RecentReports = append(d.RecentReports, RetainedReport{
+			ChainID:    r.ChainID,
+			Category:   r.Category,
+			Confidence: r.Confidence,
+			Timestamp:  r.Timestamp,
+			ReceivedAt: receivedAt(r),
+			Sanitized:  r.Sanitized,
+		})
+	}
+	return d, true
+}
+
+// TWABSnapshotEntry is one address in a Snapshot.
+type TWABSnapshotEntry struct {
+	Address string
+	TWABSummary
+}
+
+// Snapshot returns a summary of every tracked address.  Shards are locked
+// one at a time, and only while their entries are summarized, so ingest
+// is never blocked for the whole walk.  The result is in no particular
+// order and is not a single point-in-time view across shards.
+func (t *TWAB) Snapshot() []TWABSnapshotEntry {
+	var out []TWABSnapshotEntry
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.mu.RLock()
+		for addr, entry := range shard.entries {
+			out = append(out, TWABSnapshotEntry{Address: addr, TWABSummary: summarize(entry)})
+		}
+		shard.mu.RUnlock()
+	}
+	return out
+}
+
+// CollectIdle drops the entries of addresses with no report received
+// since before, except those keep is true for, and passes dropped the best
+// confidence of each source of every entry dropped.  Shards are walked one
+// at a time, stopping early once ctx is done; keep and dropped are called
+// with no shard locked, and an address reported again meanwhile is left
+// alone.
+func (t *TWAB) CollectIdle(ctx context.Context, before time.Time, keep func(address string) bool, dropped func(sources map[string]float64)) TaskStats {
+	var stats TaskStats
+	for i := range t.shards {
+		if ctx.Err() != nil {
+			break
+		}
+		shard := &t.shards[i]
+		var idle []string
+		shard.mu.RLock()
+		for addr, entry := range shard.entries {
+			stats.Items++
+			if entry.LastReceived.Before(before) {
+				idle = append(idle, addr)
+			}
+		}
+		shard.mu.RUnlock()
+
+		var drop []string
+		for _, addr := range idle {
+			if !keep(addr) {
+				drop = append(drop, addr)
+			}
+		}
+		if len(drop) == 0 {
+			continue
+		}
+		var forgotten []map[string]float64
+		shard.mu.Lock()
+		for _, addr := range drop {
+			if entry, ok := shard.entries[addr]; ok && entry.LastReceived.Before(before) {
+				delete(shard.entries, addr)
+				forgotten = append(forgotten, entry.sourceConfidences())
+			}
+		}
+		shard.mu.Unlock()
+		for _, sources := range forgotten {
+			dropped(sources)
+		}
+	}
+	return stats
+}
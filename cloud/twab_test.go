@@ -0,0 +1,140 @@
+package swarm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestTWABRetainsRecentReportsAndFullAggregates(t *testing.T) {
+	cfg := TWABConfig{MinReportCount: 10, MinDistinctSources: 2, RetainReports: 4}
+	tw := NewTWAB(cfg)
+	addr := evmAddress("campaign")
+	base := time.Now().Add(-time.Hour)
+	for i := 0; i < 10; i++ {
+		tw.Record(addr, IOCReport{
+			ChainID:    1,
+			Confidence: float64(i) / 10,
+			Timestamp:  base.Add(time.Duration(i) * time.Minute),
+			SourceID:   fmt.Sprintf("agent-%d", i%2),
+		})
+	}
+
+	detail, ok := tw.Detail(addr)
+	if !ok {
+		t.Fatal("Expected the address to be tracked")
+	}
+	if len(detail.RecentReports) != 4 {
+		t.Fatalf("Expected 4 retained reports, got %d", len(detail.RecentReports))
+	}
+	for i, r := range detail.RecentReports {
+		if want := base.Add(time.Duration(6+i) * time.Minute); !r.Timestamp.Equal(want) {
+			t.Errorf("Retained report %d: expected %v, got %v", i, want, r.Timestamp)
+		}
+	}
+
+	// Aggregates cover every report, not just the retained ones.
+	if detail.ReportCount != 10 || detail.DistinctSources != 2 {
+		t.Errorf("Expected 10 reports from 2 sources, got %+v", detail.TWABSummary)
+	}
+	if detail.MeanConfidence < 0.449 || detail.MeanConfidence > 0.451 {
+		t.Errorf("Expected mean confidence 0.45, got %g", detail.MeanConfidence)
+	}
+	if detail.WeightedScore < 1.699 || detail.WeightedScore > 1.701 {
+		t.Errorf("Expected each source's best confidence summed to 1.7, got %g", detail.WeightedScore)
+	}
+	if !detail.FirstSeen.Equal(base) || !tw.MeetsThreshold(addr, cfg) {
+		t.Errorf("Expected the first report to still date the entry and the gates to pass, got %+v", detail.TWABSummary)
+	}
+}
+
+func TestSourceSpanKeepsFirstReceiptsInOrder(t *testing.T) {
+	tw := NewTWAB(TWABConfig{MinReportCount: 1, MinDistinctSources: 2})
+	addr := evmAddress("spanned")
+	base := time.Now()
+	// Receipts can arrive out of order, as a standby replays them.
+	for _, r := range []struct {
+		source string
+		at     time.Duration
+	}{{"agent-C", 50 * time.Minute}, {"agent-A", 0}, {"agent-B", 20 * time.Minute}, {"agent-A", 90 * time.Minute}} {
+		tw.Record(addr, IOCReport{ChainID: 1, Confidence: 0.9, SourceID: r.source, Timestamp: base.Add(r.at), ReceivedAt: base.Add(r.at)})
+	}
+	entry := tw.shardFor(addr).entries[addr]
+	for sources, want := range map[int]time.Duration{1: 90 * time.Minute, 2: 70 * time.Minute, 3: 40 * time.Minute, 4: 40 * time.Minute} {
+		if got := entry.sourceSpan(sources); got != want {
+			t.Errorf("Span of %d sources: got %v, want %v", sources, got, want)
+		}
+	}
+	if allocs := testing.AllocsPerRun(100, func() { entry.sourceSpan(2) }); allocs != 0 {
+		t.Errorf("Expected the span computed without allocating, got %v allocations", allocs)
+	}
+}
+
+func TestAddressDetailIncludesRecentReports(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 5, MinDistinctSources: 1})
+	addr := evmAddress("detail")
+	report := IOCReport{Address: addr, ChainID: 1, Category: "drainer", Confidence: 0.7, Timestamp: time.Now(), SourceID: "agent-A"}
+	agg.twab.Record(addr, report)
+
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/address/"+addr, nil))
+	var resp struct {
+		TWAB map[string]json.RawMessage `json:"twab"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Invalid response: %v", err)
+	}
+	var recent []map[string]interface{}
+	json.Unmarshal(resp.TWAB["recent_reports"], &recent)
+	if len(recent) != 1 || recent[0]["category"] != "drainer" || resp.TWAB["report_count"] == nil {
+		t.Errorf("Expected the summary and one recent report, got %v", resp.TWAB)
+	}
+	if _, leaked := recent[0]["source_id"]; leaked {
+		t.Error("Recent reports must not expose source IDs")
+	}
+}
+
+// BenchmarkTWABMemoryOneAddress records a million reports for one address
+// and reports the heap retained afterwards: "unbounded" keeps every report
+// the way entries used to, "ring" is the TWAB as it is.
+func BenchmarkTWABMemoryOneAddress(b *testing.B) {
+	const reports = 1000000
+	addr := evmAddress("hot")
+	report := func(i int) IOCReport {
+		return IOCReport{Address: addr, ChainID: 1, Confidence: 0.9, Timestamp: time.Unix(int64(i), 0), SourceID: fmt.Sprintf("agent-%d", i%50)}
+	}
+
+	b.Run("unbounded", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			before := heapInUse()
+			var kept []IOCReport
+			for i := 0; i < reports; i++ {
+				kept = append(kept, report(i))
+			}
+			b.ReportMetric(float64(heapInUse()-before), "retained-B")
+			runtime.KeepAlive(kept)
+		}
+	})
+	b.Run("ring", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			before := heapInUse()
+			tw := NewTWAB(DefaultTWABConfig())
+			for i := 0; i < reports; i++ {
+				tw.Record(addr, report(i))
+			}
+			b.ReportMetric(float64(heapInUse()-before), "retained-B")
+			runtime.KeepAlive(tw)
+		}
+	})
+}
+
+func heapInUse() int64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapInuse)
+}
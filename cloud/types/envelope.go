@@ -0,0 +1,72 @@
+// Package types holds the wire types the aggregator and the client SDK
+// share: the signed envelope pushed to every subscriber and the change
+// summary it carries.  The swarm and client packages alias them, so a
+// value of either is a value of both.
+package types
+
+import "encoding/json"
+
+// Envelope kinds.
+const (
+	KindSnapshot = "snapshot"
+	KindDelta    = "delta"
+	KindChunk    = "chunk"
+)
+
+// FilterEnvelope wraps a signed payload: the serialized filter, or for a
+// resuming subscriber a delta.  It is the unit pushed to subscribers.
+// Payload is kept byte-for-byte as signed.  FromVersion is zero for a
+// full snapshot; ToVersion always equals Version.  Instance and
+// LogicalVersion are only set by replicating aggregators, and
+// LogicalVersion only on envelopes reaching the current version;
+// InstanceUUID and Epoch identify the state the aggregator serves.  None
+// of these are covered by the signature.
+type FilterEnvelope struct {
+	Kind           string          `json:"kind"`
+	Version        uint64          `json:"version"`
+	FromVersion    uint64          `json:"from_version"`
+	ToVersion      uint64          `json:"to_version"`
+	Resync         bool            `json:"resync,omitempty"`
+	Rebuild        bool            `json:"rebuild,omitempty"`
+	Instance       string          `json:"instance,omitempty"`
+	LogicalVersion uint64          `json:"logical_version,omitempty"`
+	InstanceUUID   string          `json:"instance_uuid,omitempty"`
+	Epoch          uint64          `json:"epoch,omitempty"`
+	KeyID          string          `json:"key_id"`
+	Signature      string          `json:"signature"`
+	Payload        json.RawMessage `json:"payload,omitempty"`
+
+	// PayloadChecksum is the CRC-32C of Payload, checked whether or not
+	// the signature is.
+	PayloadChecksum string `json:"payload_crc32c,omitempty"`
+
+	// Summary counts the changes since the previous push.  It is not
+	// signed.
+	Summary *FilterSummary `json:"summary,omitempty"`
+
+	// Set only on chunk envelopes, which carry a slice of a larger
+	// envelope.
+	ChunkIndex int    `json:"chunk_index,omitempty"`
+	ChunkCount int    `json:"chunk_count,omitempty"`
+	Checksum   string `json:"checksum,omitempty"`
+	Chunk      []byte `json:"chunk,omitempty"`
+}
+
+// ChangeCounts are the additions and removals in a version range.
+type ChangeCounts struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+}
+
+// FilterSummary describes the changes from FromVersion to ToVersion, in
+// all and by chain ID and category.  Total is the size of the filter at
+// ToVersion.
+type FilterSummary struct {
+	FromVersion uint64 `json:"from_version"`
+	ToVersion   uint64 `json:"to_version"`
+	ChangeCounts
+	Chains     map[int]ChangeCounts    `json:"chains"`
+	ChainNames map[int]string          `json:"chain_names,omitempty"`
+	Categories map[string]ChangeCounts `json:"categories"`
+	Total      int                     `json:"total"`
+}
@@ -0,0 +1,198 @@
+// Package swarm — SDK watchlist.
+//
+// SDK clients cannot hold a subscription, but can poll GET /watchlist,
+// about once an hour, for the pending addresses closest to consensus and
+// warn on them before they are promoted.  The list carries only each
+// address, its chain, its consensus score, and the category most of its
+// recent reports give; never sources or report details.
+//
+// Scores decay with the time since the address was last reported, halving
+// every watchlist.half_life, so stale suspicion drops off.  The list is
+// computed by a maintenance task (see maintenance.go) rather than per
+// request: the top watchlist.size addresses overall and on each chain,
+// excluding allowlisted ones.  Requests only filter that list, also
+// dropping addresses promoted since, and may be cached for
+// watchlist.max_age.  Each API key may poll watchlist.requests_per_hour
+// times an hour.
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// WatchEntry is one address on the watchlist.
+type WatchEntry struct {
+	Address  string  `json:"address"`
+	ChainID  int     `json:"chain_id"`
+	Score    float64 `json:"score"`
+	Category string  `json:"category,omitempty"`
+}
+
+// watchlist is the precomputed list, each slice sorted by descending
+// score.
+type watchlist struct {
+	generated time.Time
+	all       []WatchEntry
+	chains    map[int][]WatchEntry
+}
+
+// newWatchLimiter allows each API key requests_per_hour polls an hour,
+// all of them at once after an idle hour.
+func newWatchLimiter(cfg WatchlistConfig) *ingestLimiter {
+	return newIngestLimiter(RateLimitConfig{
+		IngestPerSecond: cfg.RequestsPerHour / 3600,
+		IngestBurst:     int(math.Ceil(cfg.RequestsPerHour)),
+	})
+}
+
+// watchCandidates scores every tracked address under config, decayed by
+// the time since it was last received.
+func (t *TWAB) watchCandidates(config TWABConfig, now time.Time, halfLife time.Duration) ([]WatchEntry, int) {
+	var out []WatchEntry
+	examined := 0
+	for i := range t.shards {
+		shard := &t.shards[i]
+		shard.mu.RLock()
+		for addr, entry := range shard.entries {
+			examined++
+			thresholds, _ := config.forEntry(addr, entry)
+			score := thresholds.score(entry)
+			if halfLife > 0 {
+				score *= math.Exp2(-now.Sub(entry.LastReceived).Seconds() / halfLife.Seconds())
+			}
+			if score <= 0 {
+				continue
+			}
+			out = append(out, WatchEntry{Address: addr, ChainID: entry.ChainID, Score: score, Category: entry.categoryGuess()})
+		}
+		shard.mu.RUnlock()
+	}
+	return out, examined
+}
+
+// categoryGuess is the category most of the retained reports give, the
+// latest of those tied.  It also chooses twab.category_overrides (see
+// twab.go).  The caller holds the shard lock.
+func (e *TWABEntry) categoryGuess() string {
+	counts := make(map[string]int)
+	guess, best := "", 0
+	for _, report := range e.Recent() {
+		if report.Category == "" {
+			continue
+		}
+		counts[report.Category]++
+		if n := counts[report.Category]; n >= best {
+			guess, best = report.Category, n
+		}
+	}
+	return guess
+}
+
+// refreshWatchlist is the maintenance task recomputing the watchlist.
+func (s *SwarmAggregator) refreshWatchlist(ctx context.Context) TaskStats {
+	cfg := s.config.Watchlist
+	now := s.clock.Now()
+	candidates, examined := s.twab.watchCandidates(s.current().TWAB, now, time.Duration(cfg.HalfLife))
+
+	s.mu.RLock()
+	kept := candidates[:0]
+	for _, c := range candidates {
+		if !s.allowlist[c.Address] && s.confirmed[c.Address] == nil {
+			kept = append(kept, c)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(kept, func(i, j int) bool {
+		if kept[i].Score != kept[j].Score {
+			return kept[i].Score > kept[j].Score
+		}
+		return kept[i].Address < kept[j].Address
+	})
+
+	list := &watchlist{generated: now, all: []WatchEntry{}, chains: make(map[int][]WatchEntry)}
+	for _, c := range kept {
+		if len(list.all) < cfg.Size {
+			list.all = append(list.all, c)
+		}
+		if len(list.chains[c.ChainID]) < cfg.Size {
+			list.chains[c.ChainID] = append(list.chains[c.ChainID], c)
+		}
+	}
+	s.watchlist.Store(list)
+	return TaskStats{Items: examined}
+}
+
+// handleWatchlist is the HTTP handler for
+// GET /watchlist?chain_id=&min_score=&limit=.
+func (s *SwarmAggregator) handleWatchlist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	cfg := s.config.Watchlist
+	q := r.URL.Query()
+	chainID, limit := 0, cfg.Size
+	for name, dst := range map[string]*int{"chain_id": &chainID, "limit": &limit} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid "+name)
+			return
+		}
+		*dst = n
+	}
+	if limit == 0 || limit > cfg.Size {
+		limit = cfg.Size
+	}
+	var minScore float64
+	if v := q.Get("min_score"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f >= 0 && f <= 1) {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid min_score")
+			return
+		}
+		minScore = f
+	}
+
+	key, _ := APIKeyFromContext(r.Context())
+	if !s.watchLimiter.allow(key.ID) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(3600/cfg.RequestsPerHour))))
+		writeError(w, r, http.StatusTooManyRequests, CodeRateLimited, "Watchlist polled too often")
+		return
+	}
+
+	list := s.watchlist.Load()
+	if list == nil {
+		s.refreshWatchlist(r.Context()) // first request before the first tick
+		list = s.watchlist.Load()
+	}
+	from := list.all
+	if chainID != 0 {
+		from = list.chains[chainID]
+	}
+	items := []WatchEntry{}
+	for _, e := range from {
+		if len(items) == limit || e.Score < minScore {
+			break // sorted by score
+		}
+		if !s.bloomFilter.Contains(e.Address) {
+			items = append(items, e)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(time.Duration(cfg.MaxAge).Seconds())))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"generated_at": list.generated,
+		"items":        items,
+	})
+}
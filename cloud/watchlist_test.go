@@ -0,0 +1,127 @@
+package swarm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newWatchlistAggregator(requestsPerHour float64) *SwarmAggregator {
+	cfg := DefaultConfig()
+	cfg.TWAB = TWABConfig{MinReportCount: 3, MinDistinctSources: 2}
+	cfg.Watchlist.RequestsPerHour = requestsPerHour
+	agg := NewSwarmAggregatorWithConfig(cfg)
+	agg.keys.Add("sdk-secret", APIKey{ID: "sdk", Role: RoleReporter})
+	agg.keys.Add("other-secret", APIKey{ID: "other", Role: RoleSubscriber})
+	return agg
+}
+
+func getWatchlist(agg *SwarmAggregator, secret, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/watchlist"+query, nil)
+	req.Header.Set("Authorization", "Bearer "+secret)
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, req)
+	return rec
+}
+
+func watchItems(t *testing.T, rec *httptest.ResponseRecorder) []WatchEntry {
+	t.Helper()
+	var resp struct {
+		Items []WatchEntry `json:"items"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GET /watchlist: %d %v", rec.Code, err)
+	}
+	return resp.Items
+}
+
+func TestWatchlistRefreshesAsPendingSetChanges(t *testing.T) {
+	agg := newWatchlistAggregator(0)
+	ctx := context.Background()
+	report := func(addr string, chainID int, source, category string, conf float64) {
+		agg.IngestReport(ctx, IOCReport{Address: addr, ChainID: chainID, Category: category, Confidence: conf, Timestamp: time.Now(), SourceID: source})
+	}
+	brewing, polygon := evmAddress("brewing"), evmAddress("polygon")
+	report(brewing, 1, "agent-A", "phishing", 0.9)
+	report(brewing, 1, "agent-B", "phishing", 0.9)
+	report(polygon, 137, "agent-A", "", 0.5)
+
+	rec := getWatchlist(agg, "sdk-secret", "")
+	if cc := rec.Header().Get("Cache-Control"); cc != "private, max-age=300" {
+		t.Errorf("Expected a five-minute max-age, got %q", cc)
+	}
+	if strings.Contains(rec.Body.String(), "agent-") {
+		t.Errorf("Expected no source IDs in the watchlist, got %s", rec.Body)
+	}
+	items := watchItems(t, rec)
+	if len(items) != 2 || items[0].Address != brewing || items[0].Category != "phishing" || items[0].ChainID != 1 || items[1].Address != polygon {
+		t.Fatalf("Expected both pending addresses by score, got %+v", items)
+	}
+	if items := watchItems(t, getWatchlist(agg, "other-secret", "?chain_id=137")); len(items) != 1 || items[0].Address != polygon {
+		t.Errorf("Expected only the chain 137 address, got %+v", items)
+	}
+	if items := watchItems(t, getWatchlist(agg, "sdk-secret", "?min_score=0.7")); len(items) != 1 || items[0].Address != brewing {
+		t.Errorf("Expected only the address scoring at least 0.7, got %+v", items)
+	}
+
+	report(brewing, 1, "agent-C", "phishing", 0.9) // promoted
+	fresh := evmAddress("fresh")
+	report(fresh, 1, "agent-B", "drainer", 0.7)
+	if items := watchItems(t, getWatchlist(agg, "sdk-secret", "")); len(items) != 1 || items[0].Address != polygon {
+		t.Errorf("Expected the promoted address dropped before the next refresh, got %+v", items)
+	}
+	agg.maintenance.RunOnce(ctx)
+	items = watchItems(t, getWatchlist(agg, "sdk-secret", ""))
+	if len(items) != 2 || items[0].Address != fresh || items[0].Category != "drainer" || items[1].Address != polygon {
+		t.Errorf("Expected the refreshed list to hold the new pending address, got %+v", items)
+	}
+
+	for _, query := range []string{"?chain_id=x", "?min_score=2", "?limit=-1"} {
+		if rec := getWatchlist(agg, "sdk-secret", query); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestWatchlistDecaysStaleScores(t *testing.T) {
+	agg := newWatchlistAggregator(0)
+	ctx := context.Background()
+	old, recent := evmAddress("old"), evmAddress("recent")
+	agg.IngestReport(ctx, IOCReport{Address: old, ChainID: 1, Confidence: 0.9, Timestamp: time.Now(), SourceID: "agent-A"})
+	agg.IngestReport(ctx, IOCReport{Address: recent, ChainID: 1, Confidence: 0.6, Timestamp: time.Now(), SourceID: "agent-A"})
+	entry := agg.twab.shardFor(old).entries[old]
+	undecayed := agg.current().TWAB.score(entry)
+	entry.FirstReceived = entry.FirstReceived.Add(-48 * time.Hour) // two half-lives
+	entry.LastReceived = entry.LastReceived.Add(-48 * time.Hour)
+
+	agg.maintenance.RunOnce(ctx)
+	items := watchItems(t, getWatchlist(agg, "sdk-secret", ""))
+	if len(items) != 2 || items[0].Address != recent || items[1].Address != old {
+		t.Fatalf("Expected the stale address ranked last, got %+v", items)
+	}
+	if want := undecayed / 4; items[1].Score < want*0.99 || items[1].Score > want*1.01 {
+		t.Errorf("Expected the stale score quartered to %.3f, got %.3f", want, items[1].Score)
+	}
+}
+
+func TestWatchlistRateLimitedPerKey(t *testing.T) {
+	agg := newWatchlistAggregator(2)
+	for i := 0; i < 2; i++ {
+		if rec := getWatchlist(agg, "sdk-secret", ""); rec.Code != http.StatusOK {
+			t.Fatalf("Poll %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+	rec := getWatchlist(agg, "sdk-secret", "")
+	var body ErrorResponse
+	json.NewDecoder(rec.Body).Decode(&body)
+	if rec.Code != http.StatusTooManyRequests || body.Error.Code != CodeRateLimited || rec.Header().Get("Retry-After") != "1800" {
+		t.Errorf("Expected 429 with Retry-After 1800, got %d %+v %q", rec.Code, body, rec.Header().Get("Retry-After"))
+	}
+	if rec := getWatchlist(agg, "other-secret", ""); rec.Code != http.StatusOK {
+		t.Errorf("Expected another key unaffected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,221 @@
+// Package swarm — WebSocket transport for filter pushes.
+//
+// Each /ws connection becomes a subscriber, authenticated by a
+// subscriber-role API key (see subscriber_auth.go).  The current filter is sent
+// immediately on connect, followed by every subsequent push.  A client
+// reconnecting with ?last_version=N is instead caught up from N (see
+// resume.go); adding &instance=ID, the instance that numbered N, makes any
+// other instance answer with a resync snapshot (see replication.go), as
+// does &epoch=E naming an epoch other than the current one (see
+// epoch.go), and
+// &deltas=bloom, for a client holding only Bloom bits, makes any removal
+// since N reach it as a resync snapshot too, and &deltas=bits sends it a
+// patch of the bits instead (see bitpatch.go).  A
+// client presenting a namespaced key subscribes to its namespace;
+// those are only ever sent snapshots, marked resync on resume.
+// ?format=exact subscribes to the exact set, which is likewise always
+// sent whole.  Messages above push.chunk_size are split into chunk
+// envelopes (see chunk.go).  Pushes carry a summary of the changes since
+// the previous one unless the client asks for ?summary=0 (see summary.go).
+// With ?type= a subscription is sent only the entries of that indicator
+// type, always as snapshots and without summaries (see indicator.go).
+// An enterprise key may ask for ?ack=1 to acknowledge the versions it is
+// sent and be sent the filter again while it does not (see ack.go).
+// ?encoding=msgpack makes every message a msgpack envelope in a binary
+// frame, never chunked, and a resume a resync snapshot (see encoding.go);
+// acks stay JSON text.  ?buffer=N and ?mode=latest size the subscription's push queue and make
+// a full one replace its oldest push rather than skip the new one (see
+// subscribers.go).  ?max_frequency=15m sends at most one push per window,
+// and &urgent_severity=high sends additions that severe at once (see
+// pushschedule.go).  With staging enabled, ?tier=staging or ?tier=both subscribes to the
+// staging filter, alone or merged with the main one, always as snapshots
+// (see staging.go).  GET /sse/filter takes the same parameters (see
+// sse.go and stream.go).  ?subscription_id= resumes the session of an
+// earlier connection, replacing it (see subscriber_auth.go).
+package swarm
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// handleWebSocket is the HTTP handler for GET /ws.
+func (s *SwarmAggregator) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	resume, foreign, lastVersion, ok := s.resumeQuery(w, r)
+	if !ok {
+		return
+	}
+	fs, ok := s.openFilterStream(w, r, "ws", resume, foreign, lastVersion)
+	if !ok {
+		return
+	}
+	defer fs.close()
+
+	conn, err := wsUpgrader.Upgrade(w, r, http.Header{"X-Subscription-ID": {fs.id}})
+	if err != nil {
+		return // Upgrade already wrote the error response
+	}
+	defer conn.Close()
+
+	// The only application messages a client sends are acks; otherwise
+	// reading is how we notice it went away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg ackMessage
+			if fs.acks && json.Unmarshal(data, &msg) == nil && msg.Type == "ack" {
+				fs.sub.ack(msg.Version, time.Now())
+			}
+		}
+	}()
+
+	send := func(data []byte, superseded func() bool) bool {
+		if fs.encoding == WireMsgpack {
+			return wsWriteBinary(conn, data)
+		}
+		return s.wsSend(conn, data, superseded)
+	}
+	envelopes, err := fs.initial()
+	if err != nil {
+		s.logs.Printf(logSerializeFailure, fs.id, "Failed to serialize bloom filter for %s: %v", fs.id, err)
+		return
+	}
+	for _, data := range envelopes {
+		if !send(data, nil) {
+			return
+		}
+		fs.sub.markSent(envelopeVersion(data), time.Now())
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	redeliver, stop := s.redeliveries(fs)
+	defer stop()
+
+	for {
+		select {
+		case now := <-redeliver:
+			data, err := s.redelivery(fs, now)
+			if err != nil {
+				s.logs.Printf(logSerializeFailure, fs.id, "Failed to serialize bloom filter for %s: %v", fs.id, err)
+				return
+			}
+			if data == nil {
+				continue
+			}
+			if !send(data, nil) {
+				return
+			}
+			fs.sub.markSent(envelopeVersion(data), now)
+		case data, ok := <-fs.ch:
+			if !ok || !send(data, func() bool { return len(fs.ch) > 0 }) {
+				return
+			}
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// resumeQuery reads the ?last_version, ?instance and ?epoch a stream
+// resumes from, answering the request itself on failure.  foreign marks
+// a version another replicating instance, or another epoch, numbered,
+// which cannot be resumed from.
+func (s *SwarmAggregator) resumeQuery(w http.ResponseWriter, r *http.Request) (resume, foreign bool, lastVersion uint64, ok bool) {
+	if resume = r.URL.Query().Has("last_version"); resume {
+		v, err := strconv.ParseUint(r.URL.Query().Get("last_version"), 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, CodeInvalidParameter, "Invalid last_version")
+			return false, false, 0, false
+		}
+		lastVersion = v
+	}
+	foreign = r.URL.Query().Has("instance") && r.URL.Query().Get("instance") != s.config.Replication.InstanceID ||
+		s.foreignEpoch(r.URL.Query().Get("epoch"))
+	return resume, foreign, lastVersion, true
+}
+
+// initialEnvelopes encodes what a new connection is sent before live
+// pushes: the current filter, or the resume reply.  The subscription is
+// taken first, so pushes queued meanwhile may repeat versions already
+// covered; clients skip those.
+func (s *SwarmAggregator) initialEnvelopes(resume bool, lastVersion uint64, deltas DeltaMode) ([][]byte, error) {
+	if !resume {
+		snap := s.globalSnapshot()
+		if deltas == DeltaBits {
+			s.retainBits(snap) // what it will resume from
+		}
+		env, err := s.signSnapshot(snap)
+		if err != nil {
+			return nil, err
+		}
+		snapshot, err := json.Marshal(env)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{snapshot}, nil
+	}
+	envs, err := s.Resume(lastVersion, deltas)
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, len(envs))
+	for i, env := range envs {
+		if out[i], err = json.Marshal(env); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// initialSnapshot encodes a snapshot for a new connection that is not
+// caught up by deltas.
+func (s *SwarmAggregator) initialSnapshot(snap filterSnapshot, format FilterFormat, encoding WireEncoding, resume bool) ([][]byte, error) {
+	env, err := s.signEncoded(snap, format, encoding)
+	if err != nil {
+		return nil, err
+	}
+	env.Resync = resume
+	data, err := marshalEnvelope(env, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{data}, nil
+}
+
+// wsWrite sends one text frame, reporting whether the connection is usable.
+func wsWrite(conn *websocket.Conn, data []byte) bool {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteMessage(websocket.TextMessage, data) == nil
+}
+
+// wsWriteBinary sends one binary frame, reporting whether the connection
+// is usable.
+func wsWriteBinary(conn *websocket.Conn, data []byte) bool {
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteMessage(websocket.BinaryMessage, data) == nil
+}
@@ -0,0 +1,6 @@
+// Package aegispb holds the generated protobuf wire types for the
+// aggregator's ingest API.  The aggregator converts them to its internal
+// types at the HTTP boundary; nothing else should depend on them.
+package aegispb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative ioc_report.proto
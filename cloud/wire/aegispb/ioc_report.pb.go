@@ -0,0 +1,506 @@
+// Wire schema for IOC report ingest.
+//
+// Clients POST an IOCReport to /ingest, or an IOCReportBatch to
+// /ingest/batch, with Content-Type: application/x-protobuf.  Responses
+// are protobuf when the Accept header asks for it.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.2
+// 	protoc        (unknown)
+// source: ioc_report.proto
+
+package aegispb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type IOCReport struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Address    string                 `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
+	Selector   string                 `protobuf:"bytes,2,opt,name=selector,proto3" json:"selector,omitempty"`
+	ChainId    int64                  `protobuf:"varint,3,opt,name=chain_id,json=chainId,proto3" json:"chain_id,omitempty"`
+	Category   string                 `protobuf:"bytes,4,opt,name=category,proto3" json:"category,omitempty"`
+	Confidence float64                `protobuf:"fixed64,5,opt,name=confidence,proto3" json:"confidence,omitempty"`
+	Timestamp  *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	SourceId   string                 `protobuf:"bytes,7,opt,name=source_id,json=sourceId,proto3" json:"source_id,omitempty"`
+}
+
+func (x *IOCReport) Reset() {
+	*x = IOCReport{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_ioc_report_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *IOCReport) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IOCReport) ProtoMessage() {}
+
+func (x *IOCReport) ProtoReflect() protoreflect.Message {
+	mi := &file_ioc_report_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IOCReport.ProtoReflect.Descriptor instead.
+func (*IOCReport) Descriptor() ([]byte, []int) {
+	return file_ioc_report_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *IOCReport) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+func (x *IOCReport) GetSelector() string {
+	if x != nil {
+		return x.Selector
+	}
+	return ""
+}
+
+func (x *IOCReport) GetChainId() int64 {
+	if x != nil {
+		return x.ChainId
+	}
+	return 0
+}
+
+func (x *IOCReport) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *IOCReport) GetConfidence() float64 {
+	if x != nil {
+		return x.Confidence
+	}
+	return 0
+}
+
+func (x *IOCReport) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *IOCReport) GetSourceId() string {
+	if x != nil {
+		return x.SourceId
+	}
+	return ""
+}
+
+type IOCReportBatch struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Reports []*IOCReport `protobuf:"bytes,1,rep,name=reports,proto3" json:"reports,omitempty"`
+}
+
+func (x *IOCReportBatch) Reset() {
+	*x = IOCReportBatch{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_ioc_report_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *IOCReportBatch) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IOCReportBatch) ProtoMessage() {}
+
+func (x *IOCReportBatch) ProtoReflect() protoreflect.Message {
+	mi := &file_ioc_report_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IOCReportBatch.ProtoReflect.Descriptor instead.
+func (*IOCReportBatch) Descriptor() ([]byte, []int) {
+	return file_ioc_report_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *IOCReportBatch) GetReports() []*IOCReport {
+	if x != nil {
+		return x.Reports
+	}
+	return nil
+}
+
+type IngestResult struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Deprecated: use status.  True unless the report was rejected.
+	Accepted      bool `protobuf:"varint,1,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	AddedToFilter bool `protobuf:"varint,2,opt,name=added_to_filter,json=addedToFilter,proto3" json:"added_to_filter,omitempty"`
+	// Set when the report was queued for asynchronous processing; the
+	// outcome is then not yet known and added_to_filter is false.
+	IngestId string `protobuf:"bytes,3,opt,name=ingest_id,json=ingestId,proto3" json:"ingest_id,omitempty"`
+	// "recorded", "duplicate" or "rejected".
+	Status string `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	// Why the report has its status; see writeIngestResult.
+	ReasonCode     string  `protobuf:"bytes,5,opt,name=reason_code,json=reasonCode,proto3" json:"reason_code,omitempty"`
+	ConsensusScore float64 `protobuf:"fixed64,6,opt,name=consensus_score,json=consensusScore,proto3" json:"consensus_score,omitempty"`
+	FilterVersion  uint64  `protobuf:"varint,7,opt,name=filter_version,json=filterVersion,proto3" json:"filter_version,omitempty"`
+	// Set for rejected batch items.
+	Message string `protobuf:"bytes,8,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (x *IngestResult) Reset() {
+	*x = IngestResult{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_ioc_report_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *IngestResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestResult) ProtoMessage() {}
+
+func (x *IngestResult) ProtoReflect() protoreflect.Message {
+	mi := &file_ioc_report_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestResult.ProtoReflect.Descriptor instead.
+func (*IngestResult) Descriptor() ([]byte, []int) {
+	return file_ioc_report_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *IngestResult) GetAccepted() bool {
+	if x != nil {
+		return x.Accepted
+	}
+	return false
+}
+
+func (x *IngestResult) GetAddedToFilter() bool {
+	if x != nil {
+		return x.AddedToFilter
+	}
+	return false
+}
+
+func (x *IngestResult) GetIngestId() string {
+	if x != nil {
+		return x.IngestId
+	}
+	return ""
+}
+
+func (x *IngestResult) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *IngestResult) GetReasonCode() string {
+	if x != nil {
+		return x.ReasonCode
+	}
+	return ""
+}
+
+func (x *IngestResult) GetConsensusScore() float64 {
+	if x != nil {
+		return x.ConsensusScore
+	}
+	return 0
+}
+
+func (x *IngestResult) GetFilterVersion() uint64 {
+	if x != nil {
+		return x.FilterVersion
+	}
+	return 0
+}
+
+func (x *IngestResult) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type IngestBatchResult struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Results       []*IngestResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	Accepted      int64           `protobuf:"varint,2,opt,name=accepted,proto3" json:"accepted,omitempty"`
+	AddedToFilter int64           `protobuf:"varint,3,opt,name=added_to_filter,json=addedToFilter,proto3" json:"added_to_filter,omitempty"`
+}
+
+func (x *IngestBatchResult) Reset() {
+	*x = IngestBatchResult{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_ioc_report_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *IngestBatchResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestBatchResult) ProtoMessage() {}
+
+func (x *IngestBatchResult) ProtoReflect() protoreflect.Message {
+	mi := &file_ioc_report_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestBatchResult.ProtoReflect.Descriptor instead.
+func (*IngestBatchResult) Descriptor() ([]byte, []int) {
+	return file_ioc_report_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *IngestBatchResult) GetResults() []*IngestResult {
+	if x != nil {
+		return x.Results
+	}
+	return nil
+}
+
+func (x *IngestBatchResult) GetAccepted() int64 {
+	if x != nil {
+		return x.Accepted
+	}
+	return 0
+}
+
+func (x *IngestBatchResult) GetAddedToFilter() int64 {
+	if x != nil {
+		return x.AddedToFilter
+	}
+	return 0
+}
+
+var File_ioc_report_proto protoreflect.FileDescriptor
+
+var file_ioc_report_proto_rawDesc = []byte{
+	0x0a, 0x10, 0x69, 0x6f, 0x63, 0x5f, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x12, 0x08, 0x61, 0x65, 0x67, 0x69, 0x73, 0x2e, 0x76, 0x31, 0x1a, 0x1f, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xef, 0x01,
+	0x0a, 0x09, 0x49, 0x4f, 0x43, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x12, 0x18, 0x0a, 0x07, 0x61,
+	0x64, 0x64, 0x72, 0x65, 0x73, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61, 0x64,
+	0x64, 0x72, 0x65, 0x73, 0x73, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x6f,
+	0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x6f,
+	0x72, 0x12, 0x19, 0x0a, 0x08, 0x63, 0x68, 0x61, 0x69, 0x6e, 0x5f, 0x69, 0x64, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x07, 0x63, 0x68, 0x61, 0x69, 0x6e, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08,
+	0x63, 0x61, 0x74, 0x65, 0x67, 0x6f, 0x72, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08,
+	0x63, 0x61, 0x74, 0x65, 0x67, 0x6f, 0x72, 0x79, 0x12, 0x1e, 0x0a, 0x0a, 0x63, 0x6f, 0x6e, 0x66,
+	0x69, 0x64, 0x65, 0x6e, 0x63, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0a, 0x63, 0x6f,
+	0x6e, 0x66, 0x69, 0x64, 0x65, 0x6e, 0x63, 0x65, 0x12, 0x38, 0x0a, 0x09, 0x74, 0x69, 0x6d, 0x65,
+	0x73, 0x74, 0x61, 0x6d, 0x70, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54, 0x69,
+	0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61,
+	0x6d, 0x70, 0x12, 0x1b, 0x0a, 0x09, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x69, 0x64, 0x18,
+	0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x49, 0x64, 0x22,
+	0x3f, 0x0a, 0x0e, 0x49, 0x4f, 0x43, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x42, 0x61, 0x74, 0x63,
+	0x68, 0x12, 0x2d, 0x0a, 0x07, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x13, 0x2e, 0x61, 0x65, 0x67, 0x69, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x49, 0x4f,
+	0x43, 0x52, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x52, 0x07, 0x72, 0x65, 0x70, 0x6f, 0x72, 0x74, 0x73,
+	0x22, 0x92, 0x02, 0x0a, 0x0c, 0x49, 0x6e, 0x67, 0x65, 0x73, 0x74, 0x52, 0x65, 0x73, 0x75, 0x6c,
+	0x74, 0x12, 0x1a, 0x0a, 0x08, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x08, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x12, 0x26, 0x0a,
+	0x0f, 0x61, 0x64, 0x64, 0x65, 0x64, 0x5f, 0x74, 0x6f, 0x5f, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0d, 0x61, 0x64, 0x64, 0x65, 0x64, 0x54, 0x6f, 0x46,
+	0x69, 0x6c, 0x74, 0x65, 0x72, 0x12, 0x1b, 0x0a, 0x09, 0x69, 0x6e, 0x67, 0x65, 0x73, 0x74, 0x5f,
+	0x69, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x69, 0x6e, 0x67, 0x65, 0x73, 0x74,
+	0x49, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12, 0x1f, 0x0a, 0x0b, 0x72, 0x65,
+	0x61, 0x73, 0x6f, 0x6e, 0x5f, 0x63, 0x6f, 0x64, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0a, 0x72, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x43, 0x6f, 0x64, 0x65, 0x12, 0x27, 0x0a, 0x0f, 0x63,
+	0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x73, 0x75, 0x73, 0x5f, 0x73, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x0e, 0x63, 0x6f, 0x6e, 0x73, 0x65, 0x6e, 0x73, 0x75, 0x73, 0x53,
+	0x63, 0x6f, 0x72, 0x65, 0x12, 0x25, 0x0a, 0x0e, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x5f, 0x76,
+	0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0d, 0x66, 0x69,
+	0x6c, 0x74, 0x65, 0x72, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x6d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65,
+	0x73, 0x73, 0x61, 0x67, 0x65, 0x22, 0x89, 0x01, 0x0a, 0x11, 0x49, 0x6e, 0x67, 0x65, 0x73, 0x74,
+	0x42, 0x61, 0x74, 0x63, 0x68, 0x52, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x12, 0x30, 0x0a, 0x07, 0x72,
+	0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x61,
+	0x65, 0x67, 0x69, 0x73, 0x2e, 0x76, 0x31, 0x2e, 0x49, 0x6e, 0x67, 0x65, 0x73, 0x74, 0x52, 0x65,
+	0x73, 0x75, 0x6c, 0x74, 0x52, 0x07, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x73, 0x12, 0x1a, 0x0a,
+	0x08, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x08, 0x61, 0x63, 0x63, 0x65, 0x70, 0x74, 0x65, 0x64, 0x12, 0x26, 0x0a, 0x0f, 0x61, 0x64, 0x64,
+	0x65, 0x64, 0x5f, 0x74, 0x6f, 0x5f, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x0d, 0x61, 0x64, 0x64, 0x65, 0x64, 0x54, 0x6f, 0x46, 0x69, 0x6c, 0x74, 0x65,
+	0x72, 0x42, 0x2e, 0x5a, 0x2c, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
+	0x61, 0x65, 0x67, 0x69, 0x73, 0x2d, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x2f, 0x73,
+	0x77, 0x61, 0x72, 0x6d, 0x2f, 0x77, 0x69, 0x72, 0x65, 0x2f, 0x61, 0x65, 0x67, 0x69, 0x73, 0x70,
+	0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_ioc_report_proto_rawDescOnce sync.Once
+	file_ioc_report_proto_rawDescData = file_ioc_report_proto_rawDesc
+)
+
+func file_ioc_report_proto_rawDescGZIP() []byte {
+	file_ioc_report_proto_rawDescOnce.Do(func() {
+		file_ioc_report_proto_rawDescData = protoimpl.X.CompressGZIP(file_ioc_report_proto_rawDescData)
+	})
+	return file_ioc_report_proto_rawDescData
+}
+
+var file_ioc_report_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_ioc_report_proto_goTypes = []any{
+	(*IOCReport)(nil),             // 0: aegis.v1.IOCReport
+	(*IOCReportBatch)(nil),        // 1: aegis.v1.IOCReportBatch
+	(*IngestResult)(nil),          // 2: aegis.v1.IngestResult
+	(*IngestBatchResult)(nil),     // 3: aegis.v1.IngestBatchResult
+	(*timestamppb.Timestamp)(nil), // 4: google.protobuf.Timestamp
+}
+var file_ioc_report_proto_depIdxs = []int32{
+	4, // 0: aegis.v1.IOCReport.timestamp:type_name -> google.protobuf.Timestamp
+	0, // 1: aegis.v1.IOCReportBatch.reports:type_name -> aegis.v1.IOCReport
+	2, // 2: aegis.v1.IngestBatchResult.results:type_name -> aegis.v1.IngestResult
+	3, // [3:3] is the sub-list for method output_type
+	3, // [3:3] is the sub-list for method input_type
+	3, // [3:3] is the sub-list for extension type_name
+	3, // [3:3] is the sub-list for extension extendee
+	0, // [0:3] is the sub-list for field type_name
+}
+
+func init() { file_ioc_report_proto_init() }
+func file_ioc_report_proto_init() {
+	if File_ioc_report_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_ioc_report_proto_msgTypes[0].Exporter = func(v any, i int) any {
+			switch v := v.(*IOCReport); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_ioc_report_proto_msgTypes[1].Exporter = func(v any, i int) any {
+			switch v := v.(*IOCReportBatch); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_ioc_report_proto_msgTypes[2].Exporter = func(v any, i int) any {
+			switch v := v.(*IngestResult); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_ioc_report_proto_msgTypes[3].Exporter = func(v any, i int) any {
+			switch v := v.(*IngestBatchResult); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_ioc_report_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_ioc_report_proto_goTypes,
+		DependencyIndexes: file_ioc_report_proto_depIdxs,
+		MessageInfos:      file_ioc_report_proto_msgTypes,
+	}.Build()
+	File_ioc_report_proto = out.File
+	file_ioc_report_proto_rawDesc = nil
+	file_ioc_report_proto_goTypes = nil
+	file_ioc_report_proto_depIdxs = nil
+}
@@ -0,0 +1,241 @@
+// Package swarm — Ingest wire encodings.
+//
+// /ingest and /ingest/batch accept JSON (the default), protobuf
+// (Content-Type: application/x-protobuf, schema in wire/aegispb) or
+// msgpack (application/msgpack, see msgpack.go).  The response uses the
+// encoding the Accept header asks for, falling back to the request's own
+// encoding (see encoding.go).  Wire types are converted to the internal
+// ones here, at the boundary, so neither depends on the other.
+package swarm
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/aegis-protocol/swarm/wire/aegispb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	contentTypeJSON     = "application/json"
+	contentTypeProtobuf = "application/x-protobuf"
+)
+
+// reportFromProto converts a wire report to the internal type.
+func reportFromProto(p *aegispb.IOCReport) IOCReport {
+	r := IOCReport{
+		Address:    p.GetAddress(),
+		Selector:   p.GetSelector(),
+		ChainID:    int(p.GetChainId()),
+		Category:   p.GetCategory(),
+		Confidence: p.GetConfidence(),
+		SourceID:   p.GetSourceId(),
+	}
+	if ts := p.GetTimestamp(); ts != nil {
+		r.Timestamp = ts.AsTime()
+	}
+	return r
+}
+
+// reportToProto converts an internal report to the wire type.
+func reportToProto(r IOCReport) *aegispb.IOCReport {
+	p := &aegispb.IOCReport{
+		Address:    r.Address,
+		Selector:   r.Selector,
+		ChainId:    int64(r.ChainID),
+		Category:   r.Category,
+		Confidence: r.Confidence,
+		SourceId:   r.SourceID,
+	}
+	if !r.Timestamp.IsZero() {
+		p.Timestamp = timestamppb.New(r.Timestamp)
+	}
+	return p
+}
+
+// Ingest statuses.  A recorded report counted towards consensus; a
+// duplicate replayed the result of one already recorded under the same
+// report ID or Idempotency-Key; a rejected one was refused and changed
+// nothing.
+const (
+	IngestRecorded  = "recorded"
+	IngestDuplicate = "duplicate"
+	IngestRejected  = "rejected"
+)
+
+// Reason codes of recorded and duplicate reports.  A rejected report's
+// reason code is the ErrorCode its single-report request is refused with.
+const (
+	reasonInFilter       = "in_filter"
+	reasonBelowThreshold = "below_threshold"
+	reasonWithheld       = "withheld"
+	reasonQueued         = "queued"
+	reasonReplayed       = "replayed"
+)
+
+// ingestResult is one report's outcome, in either encoding.
+type ingestResult struct {
+	Status         string  `json:"status"`
+	ReasonCode     string  `json:"reason_code"`
+	Message        string  `json:"message,omitempty"` // rejected batch items only
+	AddedToFilter  bool    `json:"added_to_filter"`
+	ConsensusScore float64 `json:"consensus_score"`
+	FilterVersion  uint64  `json:"filter_version"`
+	IngestID       string  `json:"ingest_id,omitempty"` // queued reports only
+
+	// Accepted is true unless the report was rejected.
+	//
+	// Deprecated: use Status.  Kept for one release.
+	Accepted bool `json:"accepted"`
+
+	// Explanation is set for ?verbose=1 on reports processed inline.  It
+	// is not carried by the protobuf encoding.
+	Explanation *ThresholdExplanation `json:"explanation,omitempty"`
+}
+
+// replayed returns the result as answered to a replay of its report.
+func (r ingestResult) replayed() ingestResult {
+	r.Status, r.ReasonCode = IngestDuplicate, reasonReplayed
+	return r
+}
+
+// rejectedResult is the result of a batch item refused with err.
+func rejectedResult(err error) ingestResult {
+	_, code, msg := errorResponse(err)
+	return ingestResult{Status: IngestRejected, ReasonCode: string(code), Message: msg}
+}
+
+func (r ingestResult) proto() *aegispb.IngestResult {
+	return &aegispb.IngestResult{
+		Accepted:       r.Accepted,
+		AddedToFilter:  r.AddedToFilter,
+		IngestId:       r.IngestID,
+		Status:         r.Status,
+		ReasonCode:     r.ReasonCode,
+		ConsensusScore: r.ConsensusScore,
+		FilterVersion:  r.FilterVersion,
+		Message:        r.Message,
+	}
+}
+
+func (r ingestResult) wire() proto.Message { return r.proto() }
+
+// decodeReport reads a single report in the request's encoding.
+func decodeReport(r *http.Request) (IOCReport, error) {
+	var report IOCReport
+	switch requestEncoding(r) {
+	case WireProtobuf:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return IOCReport{}, err
+		}
+		var p aegispb.IOCReport
+		if err := proto.Unmarshal(data, &p); err != nil {
+			return IOCReport{}, err
+		}
+		return reportFromProto(&p), nil
+	case WireMsgpack:
+		err := unmarshalMsgpackBody(r, &report)
+		return report, err
+	}
+	err := json.NewDecoder(r.Body).Decode(&report)
+	return report, err
+}
+
+// decodeReportBatch reads a batch of reports in the request's encoding.
+func decodeReportBatch(r *http.Request) ([]IOCReport, error) {
+	var reports []IOCReport
+	switch requestEncoding(r) {
+	case WireProtobuf:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return unmarshalReportBatch(data)
+	case WireMsgpack:
+		err := unmarshalMsgpackBody(r, &reports)
+		return reports, err
+	}
+	err := json.NewDecoder(r.Body).Decode(&reports)
+	return reports, err
+}
+
+// unmarshalMsgpackBody decodes a msgpack request body into v by way of
+// its JSON form.
+func unmarshalMsgpackBody(r *http.Request, v any) error {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if data, err = msgpackToJSON(data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// unmarshalReportBatch decodes a protobuf IOCReportBatch.
+func unmarshalReportBatch(data []byte) ([]IOCReport, error) {
+	var batch aegispb.IOCReportBatch
+	if err := proto.Unmarshal(data, &batch); err != nil {
+		return nil, err
+	}
+	reports := make([]IOCReport, len(batch.GetReports()))
+	for i, p := range batch.GetReports() {
+		reports[i] = reportFromProto(p)
+	}
+	return reports, nil
+}
+
+// ingestBatchResult is a batch response.
+type ingestBatchResult struct {
+	Accepted      int            `json:"accepted"`
+	AddedToFilter int            `json:"added_to_filter"`
+	Results       []ingestResult `json:"results"`
+}
+
+func (b ingestBatchResult) wire() proto.Message {
+	out := &aegispb.IngestBatchResult{
+		Accepted:      int64(b.Accepted),
+		AddedToFilter: int64(b.AddedToFilter),
+	}
+	for _, res := range b.Results {
+		out.Results = append(out.Results, res.proto())
+	}
+	return out
+}
+
+// ingestEncoder picks the encoding of an ingest response.
+func ingestEncoder(r *http.Request) Encoder {
+	return encoderFor(negotiateEncoding(r, WireMsgpack, WireProtobuf))
+}
+
+// writeIngestResult encodes a single-report response.  Status is
+// "recorded" or "duplicate"; a rejected report is answered with an error
+// (see writeAPIError) whose code is the reason code a batch item
+// rejected the same way carries.  The reason codes of the other two are:
+//
+//   - in_filter: the address is in the filter, promoted by this report
+//     or already confirmed.
+//   - below_threshold: the address has not yet reached consensus.
+//   - withheld: the address reached consensus but is not in the filter:
+//     it is allowlisted, cooling down, held for review, soaking in
+//     staging, or refused by the filter cap.
+//   - queued: the report was queued for asynchronous processing under
+//     IngestID, and its outcome is not yet known.
+//   - replayed: the report repeats a report ID or Idempotency-Key seen
+//     within the idempotency window; the rest of the result is that of
+//     the original.
+//
+// ConsensusScore and FilterVersion are read once the report has been
+// counted, or when it was queued.
+func writeIngestResult(w http.ResponseWriter, r *http.Request, status int, res ingestResult) {
+	writeEncoded(w, r, status, ingestEncoder(r), res)
+}
+
+// writeIngestBatchResult encodes a batch response.  Each result carries
+// the schema of writeIngestResult, rejected items included.
+func writeIngestBatchResult(w http.ResponseWriter, r *http.Request, status int, results []ingestResult, accepted, promoted int) {
+	writeEncoded(w, r, status, ingestEncoder(r), ingestBatchResult{Accepted: accepted, AddedToFilter: promoted, Results: results})
+}
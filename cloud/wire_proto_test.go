@@ -0,0 +1,180 @@
+package swarm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aegis-protocol/swarm/wire/aegispb"
+	"google.golang.org/protobuf/proto"
+)
+
+func wireTestReports(n int) []IOCReport {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	reports := make([]IOCReport, n)
+	for i := range reports {
+		reports[i] = IOCReport{
+			Address:    fmt.Sprintf("0x%040x", i%97),
+			Selector:   evmAddress("a9059cbb"),
+			ChainID:    1 + i%3,
+			Category:   "drainer",
+			Confidence: 0.5 + float64(i%50)/100,
+			Timestamp:  base.Add(time.Duration(i) * time.Second),
+			SourceID:   fmt.Sprintf("agent-%d", i%7),
+		}
+	}
+	return reports
+}
+
+func encodeBatchJSON(t testing.TB, reports []IOCReport) []byte {
+	data, err := json.Marshal(reports)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func encodeBatchProto(t testing.TB, reports []IOCReport) []byte {
+	batch := &aegispb.IOCReportBatch{}
+	for _, r := range reports {
+		batch.Reports = append(batch.Reports, reportToProto(r))
+	}
+	data, err := proto.Marshal(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestJSONAndProtobufIngestProduceIdenticalTWABState(t *testing.T) {
+	reports := wireTestReports(300)
+	cfg := TWABConfig{MinReportCount: 3, MinDistinctSources: 2}
+	viaJSON, viaProto := newTestAggregator(cfg), newTestAggregator(cfg)
+
+	post := func(agg *SwarmAggregator, contentType string, body []byte) {
+		req := httptest.NewRequest(http.MethodPost, "/ingest/batch", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s batch rejected: %d %s", contentType, rec.Code, rec.Body)
+		}
+	}
+	post(viaJSON, contentTypeJSON, encodeBatchJSON(t, reports))
+	post(viaProto, contentTypeProtobuf, encodeBatchProto(t, reports))
+
+	for _, r := range reports {
+		a, _ := viaJSON.twab.Summary(r.Address)
+		b, _ := viaProto.twab.Summary(r.Address)
+		if !reflect.DeepEqual(a, b) {
+			t.Fatalf("TWAB state differs for %s:\njson:  %+v\nproto: %+v", r.Address, a, b)
+		}
+	}
+	if viaJSON.bloomFilter.Len() != viaProto.bloomFilter.Len() {
+		t.Errorf("Filter sizes differ: %d vs %d", viaJSON.bloomFilter.Len(), viaProto.bloomFilter.Len())
+	}
+}
+
+func TestIngestResponseNegotiation(t *testing.T) {
+	agg := NewSwarmAggregator()
+	body, _ := proto.Marshal(reportToProto(IOCReport{Address: evmAddress("a"), ChainID: 1, Confidence: 0.5, SourceID: "s"}))
+
+	for _, tc := range []struct {
+		accept    string
+		wantProto bool
+	}{
+		{"", true},
+		{"application/json", false},
+		{"application/x-protobuf", true},
+		{"application/json, application/x-protobuf;q=0.5", false},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/ingest", bytes.NewReader(body))
+		req.Header.Set("Content-Type", contentTypeProtobuf)
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		rec := httptest.NewRecorder()
+		agg.Routes().ServeHTTP(rec, req)
+
+		if tc.wantProto {
+			var res aegispb.IngestResult
+			if err := proto.Unmarshal(rec.Body.Bytes(), &res); err != nil || res.Status != IngestRecorded || !res.Accepted {
+				t.Errorf("Accept %q: expected protobuf result, got %q (%v)", tc.accept, rec.Body, err)
+			}
+		} else if rec.Header().Get("Content-Type") != contentTypeJSON {
+			t.Errorf("Accept %q: expected JSON, got %s", tc.accept, rec.Header().Get("Content-Type"))
+		}
+	}
+}
+
+func TestIngestResultsCarryStatusAndReason(t *testing.T) {
+	agg := newTestAggregator(TWABConfig{MinReportCount: 2, MinDistinctSources: 2})
+	addr := evmAddress("status")
+	item := func(source, id string) string {
+		return fmt.Sprintf(`{"address":%q,"chain_id":1,"confidence":0.9,"source_id":%q,"report_id":%q}`, addr, source, id)
+	}
+	body := "[" + strings.Join([]string{
+		item("agent-A", "r-1"),
+		`{"chain_id":1,"source_id":"agent-A"}`,
+		`{"address":"0x1234","chain_id":1,"source_id":"agent-A"}`,
+		item("agent-A", "r-1"),
+		item("agent-B", "r-2"),
+	}, ",") + "]"
+	rec := httptest.NewRecorder()
+	agg.Routes().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/ingest/batch", strings.NewReader(body)))
+	var batch ingestBatchResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &batch); err != nil || rec.Code != http.StatusOK || len(batch.Results) != 5 {
+		t.Fatalf("Expected 5 results, got %d %s", rec.Code, rec.Body)
+	}
+
+	want := []struct{ status, reason string }{
+		{IngestRecorded, reasonBelowThreshold},
+		{IngestRejected, string(CodeMissingAddress)},
+		{IngestRejected, string(CodeInvalidAddress)},
+		{IngestDuplicate, reasonReplayed},
+		{IngestRecorded, reasonInFilter},
+	}
+	for i, w := range want {
+		if res := batch.Results[i]; res.Status != w.status || res.ReasonCode != w.reason || res.Accepted != (w.status != IngestRejected) {
+			t.Errorf("Item %d: expected %s/%s, got %+v", i, w.status, w.reason, res)
+		}
+	}
+	if res := batch.Results[2]; res.Message == "" {
+		t.Error("Expected a rejected item to carry a message")
+	}
+	if res := batch.Results[4]; res.ConsensusScore < 1 || res.FilterVersion != agg.bloomFilter.Version() {
+		t.Errorf("Expected the promoting item's score and filter version, got %+v", res)
+	}
+	if batch.Accepted != 3 || batch.AddedToFilter != 1 {
+		t.Errorf("Expected 3 accepted and 1 added, got %d and %d", batch.Accepted, batch.AddedToFilter)
+	}
+}
+
+func BenchmarkDecodeBatch(b *testing.B) {
+	reports := wireTestReports(1000)
+	jsonBody, protoBody := encodeBatchJSON(b, reports), encodeBatchProto(b, reports)
+
+	b.Run("json", func(b *testing.B) {
+		b.SetBytes(int64(len(jsonBody)))
+		for i := 0; i < b.N; i++ {
+			var out []IOCReport
+			if err := json.Unmarshal(jsonBody, &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("protobuf", func(b *testing.B) {
+		b.SetBytes(int64(len(protoBody)))
+		for i := 0; i < b.N; i++ {
+			if _, err := unmarshalReportBatch(protoBody); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}